@@ -46,6 +46,8 @@ func main() {
 			},
 			CustomHelpers: true,
 		},
+		// Record spans with the global tracer provider; see the README for Jaeger
+		Tracing: plugin.TracingConfig{Enabled: true},
 	}
 
 	// Initialize GenKit with Google AI plugin and prompts support
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	genkit_agentic_rag "github.com/ZanzyTHEbar/genkit-agentic-rag"
+	"github.com/ZanzyTHEbar/genkit-agentic-rag/plugin"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+)
+
+// exportGraph writes the graph to path with the given exporter
+func exportGraph(path string, export func(f *os.File) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := export(f); err != nil {
+		log.Fatalf("Failed to export %s: %v", path, err)
+	}
+	fmt.Printf("Wrote %s\n", path)
+}
+
+func main() {
+	ctx := context.Background()
+
+	config := plugin.DefaultConfig()
+	config.Prompts.Directory = "../../prompts"
+
+	g, err := genkit.Init(ctx,
+		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithPromptDir(config.Prompts.Directory),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize GenKit: %v", err)
+	}
+	config.Genkit = g
+
+	if err := genkit_agentic_rag.InitializeAgenticRAG(g, config); err != nil {
+		log.Fatalf("Failed to initialize Agentic RAG: %v", err)
+	}
+
+	processor := genkit_agentic_rag.NewAgenticRAGProcessor(config)
+	response, err := processor.Process(ctx, plugin.AgenticRAGRequest{
+		Query: "What are the key features and benefits of microservices architecture?",
+		Documents: []string{
+			`Microservices architecture is a design approach where applications are built as a collection of small,
+			independent services that communicate through well-defined APIs. Each service is responsible for a specific
+			business function and can be developed, deployed, and scaled independently. Key benefits include improved
+			scalability, technology diversity, faster deployment cycles, and better fault isolation. Companies like
+			Netflix, Amazon, and Google have successfully adopted microservices to handle massive scale and complexity.
+			However, microservices also introduce challenges such as increased complexity in service communication,
+			data consistency issues, and the need for sophisticated monitoring and orchestration tools like Kubernetes.`,
+		},
+		Options: plugin.AgenticRAGOptions{
+			EnableKnowledgeGraph: true,
+		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to process request: %v", err)
+	}
+	if response.KnowledgeGraph == nil {
+		log.Fatal("No knowledge graph was built")
+	}
+
+	// Render with: dot -Tsvg graph.dot -o graph.svg, or open graph.graphml in Gephi
+	opts := plugin.GraphExportOptions{MinConfidence: 0.5, IncludeAttributes: true}
+	exportGraph("graph.dot", func(f *os.File) error { return response.KnowledgeGraph.ExportDOT(f, opts) })
+	exportGraph("graph.graphml", func(f *os.File) error { return response.KnowledgeGraph.ExportGraphML(f, opts) })
+
+	// Load into Neo4j with: cypher-shell -f graph.cypher
+	exportGraph("graph.cypher", func(f *os.File) error {
+		return response.KnowledgeGraph.ExportCypher(f, plugin.CypherExportOptions{MinConfidence: 0.5})
+	})
+}
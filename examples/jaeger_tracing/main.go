@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	genkit_agentic_rag "github.com/ZanzyTHEbar/genkit-agentic-rag"
+	"github.com/ZanzyTHEbar/genkit-agentic-rag/plugin"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+)
+
+// newTracerProvider exports spans over OTLP/HTTP to the Jaeger from docker-compose.yml,
+// or to OTEL_EXPORTER_OTLP_ENDPOINT when set
+func newTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithInsecure())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName("agentic-rag-example"))),
+	), nil
+}
+
+func main() {
+	ctx := context.Background()
+
+	provider, err := newTracerProvider(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Flush the batched spans before exiting
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			log.Printf("Failed to flush spans: %v", err)
+		}
+	}()
+
+	config := plugin.DefaultConfig()
+	config.Prompts.Directory = "../../prompts"
+	config.TracerProvider = provider
+
+	g, err := genkit.Init(ctx,
+		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithPromptDir(config.Prompts.Directory),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize GenKit: %v", err)
+	}
+	config.Genkit = g
+
+	if err := genkit_agentic_rag.InitializeAgenticRAG(g, config); err != nil {
+		log.Fatalf("Failed to initialize Agentic RAG: %v", err)
+	}
+
+	// Every span of this request nests under the example's own span
+	tracer := provider.Tracer("jaeger_tracing")
+	ctx, span := tracer.Start(ctx, "example.request")
+	defer span.End()
+
+	processor := genkit_agentic_rag.NewAgenticRAGProcessor(config)
+	response, err := processor.Process(ctx, plugin.AgenticRAGRequest{
+		Query: "How do distributed databases handle consistency and partition tolerance in CAP theorem?",
+		Documents: []string{
+			`The CAP theorem, formulated by Eric Brewer, states that distributed data stores can only guarantee two
+			out of three properties: Consistency, Availability and Partition tolerance. Traditional ACID databases like
+			PostgreSQL prioritize consistency, while NoSQL databases like Cassandra and DynamoDB choose availability and
+			partition tolerance, implementing eventual consistency. CockroachDB and Spanner provide strong consistency
+			across distributed systems using consensus algorithms like Raft and Paxos.`,
+		},
+		Options: plugin.AgenticRAGOptions{
+			EnableKnowledgeGraph:   true,
+			EnableFactVerification: true,
+		},
+	})
+	if err != nil {
+		span.RecordError(err)
+		log.Printf("Failed to process request: %v", err)
+		return
+	}
+
+	fmt.Printf("Answer: %s\n\n", response.Answer)
+	fmt.Printf("Trace %s is in Jaeger at http://localhost:16686 under the agentic-rag-example service\n",
+		span.SpanContext().TraceID())
+}
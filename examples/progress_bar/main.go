@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	genkit_agentic_rag "github.com/ZanzyTHEbar/genkit-agentic-rag"
+	"github.com/ZanzyTHEbar/genkit-agentic-rag/plugin"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/firebase/genkit/go/plugins/googlegenai"
+)
+
+// barWidth is the width of the progress bar in characters
+const barWidth = 30
+
+// drawProgress redraws a single-line terminal progress bar for the current stage
+func drawProgress(stage string, done, total int) {
+	filled := 0
+	if total > 0 {
+		filled = done * barWidth / total
+	}
+	fmt.Printf("\r\033[K%-18s [%s%s] %d/%d",
+		stage, strings.Repeat("#", filled), strings.Repeat(" ", barWidth-filled), done, total)
+}
+
+func main() {
+	ctx := context.Background()
+
+	config := plugin.DefaultConfig()
+	config.Prompts.Directory = "../../prompts"
+
+	g, err := genkit.Init(ctx,
+		genkit.WithPlugins(&googlegenai.GoogleAI{}),
+		genkit.WithPromptDir(config.Prompts.Directory),
+	)
+	if err != nil {
+		log.Fatalf("Failed to initialize GenKit: %v", err)
+	}
+	config.Genkit = g
+
+	if err := genkit_agentic_rag.InitializeAgenticRAG(g, config); err != nil {
+		log.Fatalf("Failed to initialize Agentic RAG: %v", err)
+	}
+
+	processor := genkit_agentic_rag.NewAgenticRAGProcessor(config)
+	response, err := processor.Process(ctx, plugin.AgenticRAGRequest{
+		Query: "Why did the Apollo program use a lunar orbit rendezvous?",
+		Documents: []string{
+			`Lunar orbit rendezvous (LOR) was chosen for Apollo in 1962 after a long debate. Instead of landing the
+			entire spacecraft on the Moon, a small lander descended while the command module stayed in orbit. This
+			drastically reduced the mass that had to be launched, allowing a single Saturn V to carry the mission.
+			John Houbolt of NASA Langley was the most persistent advocate of the approach.`,
+		},
+		Options: plugin.AgenticRAGOptions{
+			EnableKnowledgeGraph:   true,
+			EnableFactVerification: true,
+			OnProgress:             drawProgress,
+		},
+	})
+	fmt.Println()
+	if err != nil {
+		log.Fatalf("Failed to process request: %v", err)
+	}
+
+	fmt.Printf("\nAnswer: %s\n", response.Answer)
+}
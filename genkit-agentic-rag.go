@@ -21,8 +21,8 @@ func InitializeAgenticRAGWithDefaults(g *genkit.Genkit) error {
 }
 
 // NewAgenticRAGProcessor creates a new agentic RAG processor that can be used standalone
-func NewAgenticRAGProcessor(config *plugin.AgenticRAGConfig) *plugin.AgenticRAGProcessor {
-	return plugin.NewAgenticRAGProcessor(config)
+func NewAgenticRAGProcessor(config *plugin.AgenticRAGConfig, opts ...plugin.ProcessorOption) *plugin.AgenticRAGProcessor {
+	return plugin.NewAgenticRAGProcessor(config, opts...)
 }
 
 // DefaultAgenticRAGConfig returns a default configuration for the agentic RAG system
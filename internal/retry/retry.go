@@ -0,0 +1,140 @@
+// Package retry provides a generic, context-budget-aware retry loop shared by
+// every component that calls out to a model provider.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrBudgetExhausted is returned when the caller's context deadline leaves
+// too little time for another attempt to complete
+var ErrBudgetExhausted = errors.New("insufficient time remaining for another attempt")
+
+// Config controls the retry loop
+type Config struct {
+	MaxAttempts        int           // Total attempts including the first call
+	InitialBackoff     time.Duration // Delay before the first retry
+	MaxBackoff         time.Duration // Upper bound for the delay between attempts
+	Multiplier         float64       // Backoff growth factor per attempt
+	MinAttemptDuration time.Duration // Minimum time a retry needs to complete; the first attempt always runs
+
+	// OnAttempt is invoked after every failed attempt, e.g. to record metrics
+	OnAttempt func(Attempt)
+}
+
+// Attempt describes a single failed attempt
+type Attempt struct {
+	Number int           // 1-based attempt number
+	Err    error         // Error returned by the attempt
+	Delay  time.Duration // Delay before the next attempt, zero if none follows
+}
+
+// Classifier reports whether an error is worth retrying. A nil Classifier
+// treats every error as retryable.
+type Classifier func(error) bool
+
+// Do runs fn until it succeeds, the error is classified as permanent, the
+// attempts are used up, or the context deadline no longer leaves room for a
+// backoff plus a full attempt. The first attempt is always made, however
+// little time the deadline leaves.
+func Do[T any](ctx context.Context, cfg Config, classifier Classifier, fn func() (T, error)) (T, error) {
+	var zero T
+
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+
+	backoff := cfg.InitialBackoff
+	var lastErr error
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		result, err := fn()
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+
+		// Context errors are final; retrying can't help
+		if ctx.Err() != nil {
+			notify(ctx, cfg, Attempt{Number: attempt, Err: err})
+			return zero, fmt.Errorf("attempt %d interrupted: %w", attempt, err)
+		}
+
+		if classifier != nil && !classifier(err) {
+			notify(ctx, cfg, Attempt{Number: attempt, Err: err})
+			return zero, err
+		}
+
+		if attempt == maxAttempts {
+			notify(ctx, cfg, Attempt{Number: attempt, Err: err})
+			break
+		}
+
+		// Don't sleep if the next attempt couldn't finish afterwards anyway
+		if !hasTimeFor(ctx, backoff+cfg.MinAttemptDuration) {
+			notify(ctx, cfg, Attempt{Number: attempt, Err: err})
+			return zero, budgetExhaustedError(attempt, lastErr)
+		}
+
+		notify(ctx, cfg, Attempt{Number: attempt, Err: err, Delay: backoff})
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return zero, fmt.Errorf("retry cancelled after %d attempt(s): %w", attempt, errors.Join(ctx.Err(), lastErr))
+		case <-timer.C:
+		}
+
+		backoff = nextBackoff(backoff, cfg)
+	}
+
+	return zero, fmt.Errorf("all %d attempt(s) failed: %w", maxAttempts, lastErr)
+}
+
+// notify logs a failed attempt and forwards it to the configured hook
+func notify(ctx context.Context, cfg Config, a Attempt) {
+	slog.DebugContext(ctx, "retry attempt failed",
+		"attempt", a.Number,
+		"max_attempts", cfg.MaxAttempts,
+		"delay", a.Delay,
+		"error", a.Err,
+	)
+
+	if cfg.OnAttempt != nil {
+		cfg.OnAttempt(a)
+	}
+}
+
+// hasTimeFor reports whether the context deadline leaves at least d of remaining time
+func hasTimeFor(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return true
+	}
+	return time.Until(deadline) >= d
+}
+
+// nextBackoff grows the backoff by the configured multiplier, capped at MaxBackoff
+func nextBackoff(current time.Duration, cfg Config) time.Duration {
+	multiplier := cfg.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	next := time.Duration(float64(current) * multiplier)
+	if cfg.MaxBackoff > 0 && next > cfg.MaxBackoff {
+		next = cfg.MaxBackoff
+	}
+	return next
+}
+
+// budgetExhaustedError reports how many attempts ran before the deadline cut retries short
+func budgetExhaustedError(attempts int, lastErr error) error {
+	return fmt.Errorf("%w: %d attempt(s) made, last error: %w", ErrBudgetExhausted, attempts, lastErr)
+}
@@ -0,0 +1,225 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errPermanent = errors.New("permanent")
+)
+
+// transientOnly retries errTransient only
+func transientOnly(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		classifier Classifier
+		timeout    time.Duration // Context deadline, none when zero
+		errs       []error       // Errors of successive attempts; attempts past the end succeed
+
+		wantCalls  int
+		wantErr    error // Matched with errors.Is, nil for success
+		wantDelays []time.Duration
+	}{
+		{
+			name:       "first attempt succeeds",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			classifier: transientOnly,
+			wantCalls:  1,
+			wantDelays: []time.Duration{},
+		},
+		{
+			name:       "retries transient errors until success",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2},
+			classifier: transientOnly,
+			errs:       []error{errTransient, errTransient},
+			wantCalls:  3,
+			wantDelays: []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:       "permanent error stops at once",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			classifier: transientOnly,
+			errs:       []error{errPermanent},
+			wantCalls:  1,
+			wantErr:    errPermanent,
+			wantDelays: []time.Duration{0},
+		},
+		{
+			name:       "nil classifier retries everything",
+			cfg:        Config{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			errs:       []error{errPermanent},
+			wantCalls:  2,
+			wantDelays: []time.Duration{time.Millisecond},
+		},
+		{
+			name:       "attempts used up",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2},
+			classifier: transientOnly,
+			errs:       []error{errTransient, errTransient, errTransient},
+			wantCalls:  3,
+			wantErr:    errTransient,
+			wantDelays: []time.Duration{time.Millisecond, 2 * time.Millisecond, 0},
+		},
+		{
+			name:       "zero attempts means one",
+			cfg:        Config{},
+			classifier: transientOnly,
+			errs:       []error{errTransient},
+			wantCalls:  1,
+			wantErr:    errTransient,
+			wantDelays: []time.Duration{0},
+		},
+		{
+			name:       "backoff capped at MaxBackoff",
+			cfg:        Config{MaxAttempts: 5, InitialBackoff: time.Millisecond, MaxBackoff: 3 * time.Millisecond, Multiplier: 2},
+			classifier: transientOnly,
+			errs:       []error{errTransient, errTransient, errTransient, errTransient},
+			wantCalls:  5,
+			wantDelays: []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond},
+		},
+		{
+			name:       "multiplier below one keeps the backoff",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 0.5},
+			classifier: transientOnly,
+			errs:       []error{errTransient, errTransient},
+			wantCalls:  3,
+			wantDelays: []time.Duration{time.Millisecond, time.Millisecond},
+		},
+		{
+			name:       "short deadline still gets the first attempt",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MinAttemptDuration: time.Hour},
+			classifier: transientOnly,
+			timeout:    time.Minute,
+			wantCalls:  1,
+			wantDelays: []time.Duration{},
+		},
+		{
+			name:       "deadline cuts retries short",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MinAttemptDuration: time.Hour},
+			classifier: transientOnly,
+			timeout:    time.Minute,
+			errs:       []error{errTransient},
+			wantCalls:  1,
+			wantErr:    ErrBudgetExhausted,
+			wantDelays: []time.Duration{0},
+		},
+		{
+			name:       "deadline leaves room for the backoff and an attempt",
+			cfg:        Config{MaxAttempts: 3, InitialBackoff: time.Millisecond, MinAttemptDuration: time.Millisecond},
+			classifier: transientOnly,
+			timeout:    time.Minute,
+			errs:       []error{errTransient},
+			wantCalls:  2,
+			wantDelays: []time.Duration{time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			delays := []time.Duration{}
+			cfg := tt.cfg
+			cfg.OnAttempt = func(a Attempt) {
+				if a.Number != len(delays)+1 {
+					t.Errorf("OnAttempt got attempt %d, want %d", a.Number, len(delays)+1)
+				}
+				if a.Err == nil {
+					t.Errorf("OnAttempt got attempt %d without an error", a.Number)
+				}
+				delays = append(delays, a.Delay)
+			}
+
+			calls := 0
+			result, err := Do(ctx, cfg, tt.classifier, func() (int, error) {
+				calls++
+				if calls <= len(tt.errs) {
+					return 0, tt.errs[calls-1]
+				}
+				return calls, nil
+			})
+
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Do returned %v, want success", err)
+				}
+				if result != calls {
+					t.Errorf("result = %d, want the value of the last attempt, %d", result, calls)
+				}
+			} else if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do returned %v, want %v", err, tt.wantErr)
+			}
+			if len(delays) != len(tt.wantDelays) {
+				t.Fatalf("OnAttempt delays = %v, want %v", delays, tt.wantDelays)
+			}
+			for i := range delays {
+				if delays[i] != tt.wantDelays[i] {
+					t.Errorf("OnAttempt delays = %v, want %v", delays, tt.wantDelays)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDoCancelledDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := Config{
+		MaxAttempts:    3,
+		InitialBackoff: time.Hour,
+		OnAttempt:      func(Attempt) { cancel() },
+	}
+
+	calls := 0
+	_, err := Do(ctx, cfg, transientOnly, func() (int, error) {
+		calls++
+		return 0, errTransient
+	})
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errTransient) {
+		t.Errorf("Do returned %v, want the cancellation and the last error", err)
+	}
+}
+
+func TestDoContextErrorIsFinal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var attempts []Attempt
+	cfg := Config{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		OnAttempt:      func(a Attempt) { attempts = append(attempts, a) },
+	}
+
+	calls := 0
+	_, err := Do(ctx, cfg, nil, func() (int, error) {
+		calls++
+		cancel()
+		return 0, context.Canceled
+	})
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do returned %v, want context.Canceled", err)
+	}
+	if len(attempts) != 1 || attempts[0].Delay != 0 {
+		t.Errorf("OnAttempt got %+v, want one attempt without a delay", attempts)
+	}
+}
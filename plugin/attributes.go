@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/invopop/jsonschema"
+)
+
+// AttributeValue is a fact about an entity the text states, such as a person's role
+// or an organization's founding year
+type AttributeValue struct {
+	Value      string           `json:"value"`
+	Confidence float64          `json:"confidence"`
+	Source     string           `json:"source,omitempty"`    // Text stating the value
+	Conflicts  []AttributeValue `json:"conflicts,omitempty"` // Other values found for the attribute, less confident than Value
+}
+
+// JSONSchema describes the value for genkit's flow schemas. Reflection would recurse
+// forever through Conflicts, as genkit inlines every type, while conflicts never have
+// conflicts of their own.
+func (AttributeValue) JSONSchema() *jsonschema.Schema {
+	value := func() *jsonschema.Schema {
+		properties := jsonschema.NewProperties()
+		properties.Set("value", &jsonschema.Schema{Type: "string"})
+		properties.Set("confidence", &jsonschema.Schema{Type: "number"})
+		properties.Set("source", &jsonschema.Schema{Type: "string", Description: "Text stating the value"})
+		return &jsonschema.Schema{Type: "object", Properties: properties, Required: []string{"value", "confidence"}}
+	}
+	schema := value()
+	schema.Properties.Set("conflicts", &jsonschema.Schema{Type: "array", Items: value(), Description: "Other values found for the attribute, less confident than value"})
+	return schema
+}
+
+// attributeKey normalizes an attribute name to lower snake case, so "Founding Year"
+// and "founding_year" are the same attribute
+func attributeKey(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-'
+	}), "_")
+}
+
+// parseAttributes reads the attributes of an extracted entity, merging values given
+// more than once. Returns nil when there are none.
+func parseAttributes(value any) map[string]AttributeValue {
+	list, _ := value.([]any)
+	var attributes map[string]AttributeValue
+	for _, item := range list {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := fields["name"].(string)
+		text, _ := fields["value"].(string)
+		key, text := attributeKey(name), strings.TrimSpace(text)
+		if key == "" || text == "" {
+			continue
+		}
+		attribute := AttributeValue{Value: text}
+		attribute.Confidence, _ = fields["confidence"].(float64)
+		attribute.Source, _ = fields["source"].(string)
+		attributes = mergeAttributes(attributes, map[string]AttributeValue{key: attribute})
+	}
+	return attributes
+}
+
+// mergeAttributes returns the attributes of both maps without modifying either. Where
+// both have an attribute, the more confident value wins and differing values are kept
+// as its conflicts, so no value is silently overwritten.
+func mergeAttributes(base, update map[string]AttributeValue) map[string]AttributeValue {
+	if len(base) == 0 && len(update) == 0 {
+		return nil
+	}
+	merged := make(map[string]AttributeValue, len(base)+len(update))
+	for _, attributes := range []map[string]AttributeValue{base, update} {
+		for key, attribute := range attributes {
+			existing, ok := merged[key]
+			if !ok {
+				attribute.Conflicts = append([]AttributeValue(nil), attribute.Conflicts...)
+				merged[key] = attribute
+				continue
+			}
+			merged[key] = mergeAttributeValue(existing, attribute)
+		}
+	}
+	return merged
+}
+
+// mergeAttributeValue merges two values of the same attribute. Values equal ignoring
+// case are one value at the higher confidence.
+func mergeAttributeValue(a, b AttributeValue) AttributeValue {
+	candidates := append(append([]AttributeValue{withoutConflicts(a), withoutConflicts(b)}, a.Conflicts...), b.Conflicts...)
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Confidence > candidates[j].Confidence })
+
+	var values []AttributeValue
+	for _, candidate := range candidates {
+		duplicate := false
+		for _, value := range values {
+			if strings.EqualFold(value.Value, candidate.Value) {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			values = append(values, candidate)
+		}
+	}
+	merged := values[0]
+	if len(values) > 1 {
+		merged.Conflicts = values[1:]
+	}
+	return merged
+}
+
+// withoutConflicts returns the value alone
+func withoutConflicts(value AttributeValue) AttributeValue {
+	value.Conflicts = nil
+	return value
+}
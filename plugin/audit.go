@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// AuditLogger receives an event per model or embedding call for compliance logging.
+// Events never carry prompts, document text or credentials. Implementations must be
+// safe for concurrent use. FileAuditLogger is the bundled implementation.
+type AuditLogger interface {
+	LogCall(ctx context.Context, event AuditEvent) error
+}
+
+// Kinds of audited calls
+const (
+	AuditKindGenerate = "generate"
+	AuditKindEmbed    = "embed"
+)
+
+// AuditEvent is one audited call. Each attempt of a retried call is an event of its own.
+type AuditEvent struct {
+	Time            time.Time     `json:"time"`
+	Kind            string        `json:"kind"`                 // AuditKindGenerate or AuditKindEmbed
+	RequestID       string        `json:"request_id,omitempty"` // Empty for calls outside requests, such as ingestion
+	Stage           string        `json:"stage,omitempty"`
+	Model           string        `json:"model"` // Model or embedder name
+	PromptHash      string        `json:"prompt_hash"`
+	InputTokens     int           `json:"input_tokens,omitempty"`
+	OutputTokens    int           `json:"output_tokens,omitempty"`
+	Texts           int           `json:"texts,omitempty"` // Texts embedded, for embedding calls
+	Duration        time.Duration `json:"duration"`
+	Outcome         string        `json:"outcome"`                    // One of the Outcome constants
+	Error           string        `json:"error,omitempty"`            // Redacted and truncated like the preview
+	ResponsePreview string        `json:"response_preview,omitempty"` // Redacted start of the model output
+}
+
+// AuditConfig contains configuration for audit logging of model and embedding calls
+type AuditConfig struct {
+	Logger    AuditLogger     `json:"-"`         // Receives the events; nothing is audited without one
+	Redaction RedactionPolicy `json:"redaction"` // Governs the response previews and error messages
+}
+
+// RedactionPolicy governs what of a model's output reaches the audit log. Credentials
+// such as API keys and bearer tokens are always redacted.
+type RedactionPolicy struct {
+	PreviewBytes int      `json:"preview_bytes"`      // Response previews are cut beyond this size, 0 for no preview
+	Emails       bool     `json:"emails"`             // Redact email addresses
+	Patterns     []string `json:"patterns,omitempty"` // Regular expressions of further text to redact, such as account numbers
+}
+
+// auditErrorBytes caps the error messages of audit events, which providers sometimes
+// pad with the request they failed
+const auditErrorBytes = 500
+
+// emailPattern matches email addresses
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// auditor hashes, redacts and logs calls for an AuditConfig
+type auditor struct {
+	logger       AuditLogger
+	previewBytes int
+	patterns     []*regexp.Regexp
+	// invalid is set when a custom pattern doesn't compile, withholding previews and
+	// errors rather than logging them unredacted
+	invalid bool
+}
+
+// newAuditor returns the auditor of the configuration, nil without a logger
+func newAuditor(config AuditConfig) *auditor {
+	if config.Logger == nil {
+		return nil
+	}
+	a := &auditor{logger: config.Logger, previewBytes: config.Redaction.PreviewBytes}
+	if config.Redaction.Emails {
+		a.patterns = append(a.patterns, emailPattern)
+	}
+	for _, pattern := range config.Redaction.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			a.invalid = true
+			continue
+		}
+		a.patterns = append(a.patterns, compiled)
+	}
+	return a
+}
+
+// AuditMiddleware returns model middleware auditing each call of the model, for
+// genkit calls made outside the processor. Without a logger, calls pass through.
+func AuditMiddleware(config AuditConfig, model string) ai.ModelMiddleware {
+	return newAuditor(config).middleware(model)
+}
+
+// AuditEmbedder wraps an embedder so each of its calls is audited, for embedders
+// used outside the processor such as those of the vector stores. Without a logger,
+// the embedder is returned as is.
+func AuditEmbedder(embedder ai.Embedder, config AuditConfig) ai.Embedder {
+	return newAuditor(config).embedder(embedder)
+}
+
+// middleware audits each call of the model it wraps
+func (a *auditor) middleware(model string) ai.ModelMiddleware {
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		if a == nil {
+			return next
+		}
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			start := time.Now()
+			response, err := next(ctx, req, cb)
+			event := a.event(ctx, AuditKindGenerate, model, renderMessages(req.Messages), start, classifyError(model, err))
+			if response != nil {
+				if response.Usage != nil {
+					event.InputTokens = response.Usage.InputTokens
+					event.OutputTokens = response.Usage.OutputTokens
+				}
+				if a.previewBytes > 0 {
+					event.ResponsePreview = a.redact(response.Text(), a.previewBytes)
+				}
+			}
+			a.log(ctx, event)
+			return response, err
+		}
+	}
+}
+
+// embedder wraps an embedder so each of its calls is audited, nil-safe
+func (a *auditor) embedder(embedder ai.Embedder) ai.Embedder {
+	if a == nil || embedder == nil {
+		return embedder
+	}
+	return auditedEmbedder{Embedder: embedder, auditor: a}
+}
+
+// auditedEmbedder audits the calls of the embedder it wraps
+type auditedEmbedder struct {
+	ai.Embedder
+	auditor *auditor
+}
+
+// Embed implements ai.Embedder
+func (e auditedEmbedder) Embed(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+	start := time.Now()
+	response, err := e.Embedder.Embed(ctx, req)
+	var input []byte
+	for i, doc := range req.Input {
+		if i > 0 {
+			input = append(input, 0)
+		}
+		for _, part := range doc.Content {
+			input = append(input, part.Text...)
+		}
+	}
+	event := e.auditor.event(ctx, AuditKindEmbed, e.Name(), string(input), start, classifyError(e.Name(), err))
+	event.Texts = len(req.Input)
+	e.auditor.log(ctx, event)
+	return response, err
+}
+
+// event builds the event of a call, hashing its input
+func (a *auditor) event(ctx context.Context, kind, model, input string, start time.Time, err error) AuditEvent {
+	hash := sha256.Sum256([]byte(input))
+	event := AuditEvent{
+		Time:       start.UTC(),
+		Kind:       kind,
+		RequestID:  requestStateFrom(ctx).id(),
+		Stage:      stageFrom(ctx),
+		Model:      model,
+		PromptHash: hex.EncodeToString(hash[:]),
+		Duration:   time.Since(start),
+		Outcome:    metricsOutcome(err),
+	}
+	if err != nil {
+		event.Error = a.redact(err.Error(), auditErrorBytes)
+	}
+	return event
+}
+
+// redact applies the redaction policy to text and cuts it to limit bytes. Nothing is
+// returned when a custom pattern is invalid.
+func (a *auditor) redact(text string, limit int) string {
+	if a.invalid {
+		return debugRedacted
+	}
+	text = redactSecrets(text)
+	for _, pattern := range a.patterns {
+		text = pattern.ReplaceAllString(text, debugRedacted)
+	}
+	text, _ = truncateCapture(text, limit)
+	return text
+}
+
+// log hands the event to the logger. A failing logger is reported as a warning on
+// the request and in the process log, and doesn't fail the call.
+func (a *auditor) log(ctx context.Context, event AuditEvent) {
+	if err := a.logger.LogCall(ctx, event); err != nil {
+		requestStateFrom(ctx).recordWarning(fmt.Sprintf("audit event dropped: %v", err))
+		slog.ErrorContext(ctx, "failed to write audit event", "request_id", event.RequestID, "model", event.Model, "error", err)
+	}
+}
+
+// FileAuditLogger appends audit events to a file as JSON lines
+type FileAuditLogger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditLogger opens the file at path for appending, creating it readable by
+// its owner only if it doesn't exist
+func NewFileAuditLogger(path string) (*FileAuditLogger, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileAuditLogger{file: file}, nil
+}
+
+// LogCall implements AuditLogger, writing each event with a single write
+func (l *FileAuditLogger) LogCall(ctx context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the file
+func (l *FileAuditLogger) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,200 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// auditSecrets are in the mock's answers and errors, and must not reach the audit log
+var auditSecrets = []string{"admin@example.com", "ACCT-1234", "sk-abcdefghijklmnopqrstuvwxyz"}
+
+// auditRedaction redacts auditSecrets
+var auditRedaction = RedactionPolicy{PreviewBytes: 200, Emails: true, Patterns: []string{`ACCT-\d+`}}
+
+// readAuditLog returns the raw audit log at path and its events
+func readAuditLog(t *testing.T, path string) (string, []AuditEvent) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the audit log: %v", err)
+	}
+	var events []AuditEvent
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("audit log line %q isn't an event: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return string(data), events
+}
+
+// newAuditedProcessor returns a processor auditing to a file and the file's path
+func newAuditedProcessor(t *testing.T, mock *mockProvider, embedder *testEmbedder) (*AgenticRAGProcessor, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logger, err := NewFileAuditLogger(path)
+	if err != nil {
+		t.Fatalf("NewFileAuditLogger: %v", err)
+	}
+	t.Cleanup(func() { logger.Close() })
+	p := newTestProcessor(t, mock, func(config *AgenticRAGConfig) {
+		config.Audit = AuditConfig{Logger: logger, Redaction: auditRedaction}
+		if embedder != nil {
+			config.Processing.RelevanceMode = RelevanceModeHybrid
+			config.Embedder = embedder.define(t)
+		}
+	})
+	return p, path
+}
+
+func TestAuditLogsEveryCall(t *testing.T) {
+	mock := &mockProvider{answer: func(string) string {
+		return "Write to admin@example.com about ACCT-1234, quoting key sk-abcdefghijklmnopqrstuvwxyz."
+	}}
+	p, path := newAuditedProcessor(t, mock, &testEmbedder{})
+	response := mustProcess(t, p, testRequest("Where is the Eiffel Tower?"))
+
+	raw, events := readAuditLog(t, path)
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("audit log mode %v, %v, want readable by its owner only", info.Mode(), err)
+	}
+	// Neither documents, prompts nor secrets are logged
+	for _, leaked := range append([]string{"World's Fair", "Where is the Eiffel Tower?"}, auditSecrets...) {
+		if strings.Contains(raw, leaked) {
+			t.Errorf("audit log contains %q", leaked)
+		}
+	}
+
+	generated, embedded := 0, 0
+	hash := regexp.MustCompile(`^[0-9a-f]{64}$`)
+	for i, event := range events {
+		if event.RequestID != response.ProcessingMetadata.RequestID || event.Stage == "" || event.Outcome != OutcomeSuccess || !hash.MatchString(event.PromptHash) {
+			t.Errorf("event %d = %+v, want the request, its stage, success and a prompt hash", i, event)
+		}
+		switch event.Kind {
+		case AuditKindGenerate:
+			generated++
+			if event.Model != mockModel || event.InputTokens == 0 || event.ResponsePreview == "" || len(event.ResponsePreview) > auditRedaction.PreviewBytes+len("…") {
+				t.Errorf("generate event %d = %+v, want the model, tokens and a preview of at most %d bytes", i, event, auditRedaction.PreviewBytes)
+			}
+		case AuditKindEmbed:
+			embedded++
+			if event.Model != "test/words" || event.Texts == 0 || event.ResponsePreview != "" {
+				t.Errorf("embed event %d = %+v, want the embedder and its texts, without a preview", i, event)
+			}
+		}
+	}
+	if generated != mock.callCount() {
+		t.Errorf("audited %d model calls, the mock served %d", generated, mock.callCount())
+	}
+	if embedded != response.ProcessingMetadata.EmbeddingCalls || embedded == 0 {
+		t.Errorf("audited %d embedding calls, the request made %d", embedded, response.ProcessingMetadata.EmbeddingCalls)
+	}
+	if !strings.Contains(raw, "Write to [REDACTED] about [REDACTED], quoting key [REDACTED].") {
+		t.Errorf("no preview of the redacted answer in the audit log:\n%s", raw)
+	}
+}
+
+func TestAuditLogsFailures(t *testing.T) {
+	mock := &mockProvider{fail: func(string) error {
+		return errors.New("invalid argument: key sk-abcdefghijklmnopqrstuvwxyz of admin@example.com rejected")
+	}}
+	p, path := newAuditedProcessor(t, mock, nil)
+	if _, err := p.Process(context.Background(), testRequest("Where is the Eiffel Tower?")); err == nil {
+		t.Fatal("Process succeeded with a failing model")
+	}
+
+	raw, events := readAuditLog(t, path)
+	if len(events) == 0 || len(events) != mock.callCount() {
+		t.Fatalf("audited %d calls, the mock served %d", len(events), mock.callCount())
+	}
+	if event := events[0]; event.Outcome == OutcomeSuccess || !strings.Contains(event.Error, "key [REDACTED] of [REDACTED] rejected") || event.ResponsePreview != "" {
+		t.Errorf("failed call audited as %+v, want the failure with its error redacted", event)
+	}
+	for _, leaked := range auditSecrets {
+		if strings.Contains(raw, leaked) {
+			t.Errorf("audit log contains %q", leaked)
+		}
+	}
+}
+
+// failingAuditLogger fails every event
+type failingAuditLogger struct{}
+
+func (failingAuditLogger) LogCall(context.Context, AuditEvent) error {
+	return errors.New("audit sink unavailable")
+}
+
+func TestAuditLoggerFailureWarns(t *testing.T) {
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Audit.Logger = failingAuditLogger{}
+	})
+	response := mustProcess(t, p, testRequest("Where is the Eiffel Tower?"))
+	if !strings.Contains(strings.Join(response.ProcessingMetadata.Warnings, "\n"), "audit event dropped: audit sink unavailable") {
+		t.Errorf("warnings %q, want the dropped audit events", response.ProcessingMetadata.Warnings)
+	}
+}
+
+// recordingAuditLogger keeps the events it receives
+type recordingAuditLogger struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (l *recordingAuditLogger) LogCall(ctx context.Context, event AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+	return nil
+}
+
+func TestAuditRedact(t *testing.T) {
+	text := "Mail admin@example.com about ACCT-1234 with bearer abcdefghijklmnopqrstuvwxyz and AIza" + strings.Repeat("x", 35)
+	tests := []struct {
+		name   string
+		policy RedactionPolicy
+		limit  int
+		want   string
+	}{
+		{"credentials always", RedactionPolicy{}, 0, "Mail admin@example.com about ACCT-1234 with [REDACTED] and [REDACTED]"},
+		{"emails and patterns", auditRedaction, 0, "Mail [REDACTED] about [REDACTED] with [REDACTED] and [REDACTED]"},
+		{"truncated", auditRedaction, 14, "Mail [REDACTED…"},
+		{"invalid pattern withholds everything", RedactionPolicy{Patterns: []string{"("}}, 0, "[REDACTED]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuditor(AuditConfig{Logger: &recordingAuditLogger{}, Redaction: tt.policy})
+			if got := a.redact(text, tt.limit); got != tt.want {
+				t.Errorf("redact = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditEmbedder(t *testing.T) {
+	embedder := (&testEmbedder{}).define(t)
+	if got := AuditEmbedder(embedder, AuditConfig{}); got != embedder {
+		t.Errorf("AuditEmbedder without a logger wrapped the embedder")
+	}
+
+	logger := &recordingAuditLogger{}
+	audited := AuditEmbedder(embedder, AuditConfig{Logger: logger})
+	if _, err := audited.Embed(context.Background(), &ai.EmbedRequest{Input: []*ai.Document{ai.DocumentFromText("Paris", nil), ai.DocumentFromText("Lyon", nil)}}); err != nil {
+		t.Fatalf("Embed: %v", err)
+	}
+	if len(logger.events) != 1 || logger.events[0].Kind != AuditKindEmbed || logger.events[0].Texts != 2 || logger.events[0].RequestID != "" {
+		t.Errorf("events %+v, want one embedding of two texts outside any request", logger.events)
+	}
+}
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// modelBalancer spreads model calls across the configured model instances and
+// takes instances that fail with provider errors out of rotation for a cooldown
+type modelBalancer struct {
+	mu        sync.Mutex
+	strategy  LoadBalancingStrategy
+	cooldown  time.Duration
+	instances []*balancedInstance
+}
+
+// balancedInstance tracks the selection and health state of a single model instance
+type balancedInstance struct {
+	ModelInstance
+	currentWeight  int       // Smooth weighted round-robin accumulator
+	inflight       int       // Calls currently running on this instance
+	unhealthyUntil time.Time // Instance is skipped until this time
+}
+
+// newModelBalancer creates a balancer for the configured instances, or nil if none are configured
+func newModelBalancer(config LoadBalancingConfig) *modelBalancer {
+	if len(config.Instances) == 0 {
+		return nil
+	}
+
+	b := &modelBalancer{
+		strategy: config.Strategy,
+		cooldown: config.UnhealthyCooldown,
+	}
+	if b.strategy == "" {
+		b.strategy = LoadBalanceWeightedRoundRobin
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = 30 * time.Second
+	}
+
+	for _, instance := range config.Instances {
+		if instance.Weight <= 0 {
+			instance.Weight = 1
+		}
+		b.instances = append(b.instances, &balancedInstance{ModelInstance: instance})
+	}
+
+	return b
+}
+
+// acquire selects an instance for the next call and marks it in flight.
+// The caller must call release with the outcome when the call finishes.
+func (b *modelBalancer) acquire() *balancedInstance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	healthy := make([]*balancedInstance, 0, len(b.instances))
+	for _, instance := range b.instances {
+		if now.After(instance.unhealthyUntil) {
+			healthy = append(healthy, instance)
+		}
+	}
+
+	// With every instance cooling down, use the one that recovers first
+	if len(healthy) == 0 {
+		soonest := b.instances[0]
+		for _, instance := range b.instances[1:] {
+			if instance.unhealthyUntil.Before(soonest.unhealthyUntil) {
+				soonest = instance
+			}
+		}
+		soonest.inflight++
+		return soonest
+	}
+
+	var selected *balancedInstance
+	switch b.strategy {
+	case LoadBalanceLeastInflight:
+		// Compare inflight/weight without floating point: a.inflight/a.weight < b.inflight/b.weight
+		for _, instance := range healthy {
+			if selected == nil || instance.inflight*selected.Weight < selected.inflight*instance.Weight {
+				selected = instance
+			}
+		}
+	default:
+		// Smooth weighted round-robin: deterministic and evenly interleaved
+		total := 0
+		for _, instance := range healthy {
+			instance.currentWeight += instance.Weight
+			total += instance.Weight
+			if selected == nil || instance.currentWeight > selected.currentWeight {
+				selected = instance
+			}
+		}
+		selected.currentWeight -= total
+	}
+
+	selected.inflight++
+	return selected
+}
+
+// release marks a call on the instance as finished. Provider errors take the
+// instance out of rotation for the configured cooldown.
+func (b *modelBalancer) release(instance *balancedInstance, providerErr bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	instance.inflight--
+	if providerErr {
+		instance.unhealthyUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// candidate returns the model candidate for the instance
+func (i *balancedInstance) candidate() modelCandidate {
+	if i.Model != nil {
+		return modelCandidate{name: i.Model.Name(), option: ai.WithModel(i.Model), instance: i}
+	}
+	return modelCandidate{name: i.ModelName, option: ai.WithModelName(i.ModelName), instance: i}
+}
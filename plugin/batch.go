@@ -0,0 +1,180 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// batchCorpus is the documents and chunks a batch loads once and shares across its queries
+type batchCorpus struct {
+	documents []Document
+	chunks    []DocumentChunk
+}
+
+// ProcessBatch answers every query over the same documents, loading and chunking them
+// once. In the embedding relevance modes the chunks are also embedded once up front,
+// so each query only embeds itself. Queries run up to Batch.Concurrency at a time and
+// fail independently: a failed query is reported in its BatchResult and the others
+// still complete. An error is returned only when the batch can't start, such as an
+// invalid request or documents that fail to load.
+func (p *AgenticRAGProcessor) ProcessBatch(ctx context.Context, documents []Document, queries []string, options AgenticRAGOptions) (*BatchResponse, error) {
+	startTime := time.Now()
+	if err := p.validateBatch(documents, queries, options); err != nil {
+		return nil, err
+	}
+
+	// Load, chunk and index the documents once
+	ctx, state := withRequestState(ctx)
+	if options.DryRun {
+		state.setDryRun()
+	}
+	maxChunks := options.MaxChunks
+	if maxChunks == 0 {
+		maxChunks = p.config.Processing.DefaultMaxChunks
+	}
+	loaded, chunks, err := p.loadCorpus(ctx, documents, nil, maxChunks, options.DocumentFilter)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.indexCorpus(ctx, chunks); err != nil {
+		return nil, fmt.Errorf("failed to index chunks: %w", err)
+	}
+	corpus := &batchCorpus{documents: loaded, chunks: chunks}
+
+	responses, errs, _ := runParallel(ctx, len(queries), p.config.Batch.Concurrency, false,
+		func(ctx context.Context, i int) (*AgenticRAGResponse, error) {
+			ctx, queryState := withRequestState(ctx)
+			queryState.corpus = corpus
+			return p.process(ctx, queryState, AgenticRAGRequest{Query: queries[i], Docs: loaded, Options: options})
+		})
+
+	response := &BatchResponse{
+		Results: make([]BatchResult, len(queries)),
+		Metadata: BatchMetadata{
+			RequestID:            state.id(),
+			ChunksProcessed:      len(chunks),
+			TokensUsed:           state.tokens(),
+			ModelCalls:           state.modelCallCount(),
+			EmbeddingCalls:       state.embeddingCallCount(),
+			EmbeddingCacheHits:   state.embeddingCacheHits(),
+			EmbeddingCacheMisses: state.embeddingCacheMisses(),
+			DocumentErrors:       state.documentErrors(),
+			DocumentsFiltered:    state.filteredDocuments(),
+			StageTimings:         state.stageTimings(),
+		},
+	}
+	for _, doc := range loaded {
+		if fromCache, _ := doc.Metadata["from_cache"].(bool); fromCache {
+			response.Metadata.DocumentCacheHits++
+		}
+	}
+	for i, query := range queries {
+		result := BatchResult{Query: query, Response: responses[i], Err: errs[i]}
+		if errs[i] != nil {
+			result.Response, result.Error = nil, errs[i].Error()
+			response.Metadata.Failed++
+		} else {
+			response.Metadata.Succeeded++
+			metadata := responses[i].ProcessingMetadata
+			response.Metadata.TokensUsed += metadata.TokensUsed
+			response.Metadata.ModelCalls += metadata.ModelCalls
+			response.Metadata.EmbeddingCalls += metadata.EmbeddingCalls
+			response.Metadata.EmbeddingCacheHits += metadata.EmbeddingCacheHits
+			response.Metadata.EmbeddingCacheMisses += metadata.EmbeddingCacheMisses
+			if graph := responses[i].KnowledgeGraph; graph != nil {
+				if response.KnowledgeGraph == nil {
+					response.KnowledgeGraph = &KnowledgeGraph{}
+				}
+				response.KnowledgeGraph.Merge(graph, MergePolicyMax)
+			}
+		}
+		response.Results[i] = result
+	}
+	response.Metadata.ProcessingTime = time.Since(startTime)
+	return response, nil
+}
+
+// validateBatch checks the queries, documents and options of a batch
+func (p *AgenticRAGProcessor) validateBatch(documents []Document, queries []string, options AgenticRAGOptions) error {
+	if len(queries) == 0 {
+		return fieldError("queries", "at least one query is required")
+	}
+	var errs []error
+	for i, query := range queries {
+		if query == "" {
+			errs = append(errs, fieldError(fmt.Sprintf("queries[%d]", i), "must not be empty"))
+		}
+	}
+	// The documents and options are checked as a request for the first query
+	errs = append(errs, p.ValidateRequest(AgenticRAGRequest{Query: queries[0], Docs: documents, Options: options}))
+	return errors.Join(errs...)
+}
+
+// loadCorpus loads the documents, drops those the filter excludes and chunks the rest,
+// reporting loading and chunking progress and completion to the request state
+func (p *AgenticRAGProcessor) loadCorpus(ctx context.Context, docs []Document, sources []string, maxChunks int, filter *DocumentFilter) ([]Document, []DocumentChunk, error) {
+	state := requestStateFrom(ctx)
+
+	var documents []Document
+	err := runStage(ctx, StageLoading, func() (string, error) {
+		p.startStage(ctx, StageLoading, fmt.Sprintf("%d documents, %d sources", len(docs), len(sources)))
+		state.reportProgress(StageLoading, 0, len(docs)+len(sources))
+		loaded, err := p.loadDocuments(withStage(ctx, StageLoading), docs, sources)
+		if err != nil {
+			err = fmt.Errorf("failed to load documents: %w", err)
+			p.failStage(ctx, err)
+			return "", err
+		}
+		state.reportProgress(StageLoading, len(loaded), len(loaded))
+		if documents, err = filterDocuments(loaded, filter); err != nil {
+			p.failStage(ctx, err)
+			return "", err
+		}
+		state.recordFilteredDocuments(len(loaded) - len(documents))
+		state.completeStage(StageLoading)
+		output := fmt.Sprintf("%d documents, %d filtered out", len(documents), len(loaded)-len(documents))
+		return output, p.endStage(ctx, StageLoading, output, nil)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chunks []DocumentChunk
+	err = runStage(ctx, StageChunking, func() (string, error) {
+		p.startStage(ctx, StageChunking, fmt.Sprintf("%d documents", len(documents)))
+		set, err := p.chunker.Chunk(ctx, documents, maxChunks)
+		if err != nil {
+			p.failStage(ctx, err)
+			return "", err
+		}
+		chunks = set.Chunks
+		state.reportProgress(StageChunking, len(documents), len(documents))
+		state.completeStage(StageChunking)
+		output := fmt.Sprintf("%d chunks", len(chunks))
+		return output, p.endStage(ctx, StageChunking, output, nil)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	state.beginStage("")
+	return documents, chunks, nil
+}
+
+// indexCorpus embeds the chunks into the embedding cache when relevance scoring uses
+// embeddings, so queries of a batch don't embed them again. Nothing is done without
+// an embedder or a cache, or in a dry run.
+func (p *AgenticRAGProcessor) indexCorpus(ctx context.Context, chunks []DocumentChunk) error {
+	mode := p.config.Processing.RelevanceMode
+	embedder := p.embedder()
+	if len(chunks) == 0 || mode == "" || mode == RelevanceModeLLM || embedder == nil || p.embeddings == nil || requestStateFrom(ctx).isDryRun() {
+		return nil
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Content
+	}
+	_, err := p.embed(ctx, embedder, texts)
+	return err
+}
@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// budgetOutputReserve is the number of tokens kept free for the answer when fitting
+// synthesis context into a token budget
+const budgetOutputReserve = 1024
+
+// responseTokens returns the tokens a model call used, estimating them when the
+// provider doesn't report usage
+func responseTokens(response *ai.ModelResponse, estimatedInput int) int {
+	if response == nil {
+		return 0
+	}
+	if usage := response.Usage; usage != nil {
+		if usage.TotalTokens > 0 {
+			return usage.TotalTokens
+		}
+		if usage.InputTokens+usage.OutputTokens > 0 {
+			return usage.InputTokens + usage.OutputTokens
+		}
+	}
+	return estimatedInput + estimateTokens(response.Text())
+}
+
+// checkBudget refuses a model call whose estimated input would exceed the request's token budget
+func checkBudget(ctx context.Context, estimatedTokens int) error {
+	state := requestStateFrom(ctx)
+	remaining, limited := state.budgetRemaining()
+	if !limited || estimatedTokens <= remaining {
+		return nil
+	}
+
+	state.markBudgetExhausted()
+	return fmt.Errorf("%w: call needs about %d tokens, %d remaining", ErrBudgetExceeded, estimatedTokens, remaining)
+}
+
+// fitBudget drops the least relevant chunks until the synthesis call fits the remaining
+// token budget. Returns ErrBudgetExceeded if not even one chunk fits.
+func (p *AgenticRAGProcessor) fitBudget(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
+	state := requestStateFrom(ctx)
+	remaining, limited := state.budgetRemaining()
+	if !limited || len(chunks) == 0 {
+		return chunks, nil
+	}
+
+	available := remaining - promptOverheadTokens - estimateTokens(query) - budgetOutputReserve
+	total := 0
+	for _, chunk := range chunks {
+		total += chunkTokens(chunk)
+	}
+	if total <= available {
+		return chunks, nil
+	}
+
+	state.markBudgetExhausted()
+	kept := append([]DocumentChunk(nil), chunks...)
+	for total > available && len(kept) > 0 {
+		i := byRelevance(kept)[0]
+		state.recordTruncation(TruncatedChunk{
+			ChunkID:        kept[i].ID,
+			DocumentID:     kept[i].DocumentID,
+			Action:         TruncationActionDropped,
+			OriginalTokens: estimateTokens(kept[i].Content),
+		})
+		total -= chunkTokens(kept[i])
+		kept = append(kept[:i], kept[i+1:]...)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%w: %d tokens remaining, not enough to synthesize an answer", ErrBudgetExceeded, remaining)
+	}
+
+	return kept, nil
+}
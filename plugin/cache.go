@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache stores responses of identical requests. Implementations must be safe
+// for concurrent use and may keep the stored responses as is; the processor never
+// modifies them.
+type ResponseCache interface {
+	// Get returns the response stored under key, or nil if there is none or it expired
+	Get(ctx context.Context, key string) (*AgenticRAGResponse, error)
+	// Put stores the response under key, replacing any earlier one
+	Put(ctx context.Context, key string, response *AgenticRAGResponse) error
+}
+
+// MemoryResponseCache is an in-process ResponseCache that evicts the least recently
+// used response once full and expires responses a fixed time after they were stored
+type MemoryResponseCache struct {
+	entries *lruCache[*AgenticRAGResponse]
+}
+
+// NewMemoryResponseCache creates an in-memory cache holding up to maxEntries responses
+// (0 for no limit). A ttl of 0 keeps responses until they're evicted.
+func NewMemoryResponseCache(maxEntries int, ttl time.Duration) *MemoryResponseCache {
+	return &MemoryResponseCache{entries: newLRUCache[*AgenticRAGResponse](maxEntries, ttl)}
+}
+
+// Get implements ResponseCache
+func (c *MemoryResponseCache) Get(ctx context.Context, key string) (*AgenticRAGResponse, error) {
+	return c.entries.get(key), nil
+}
+
+// Put implements ResponseCache, evicting the least recently used responses when full
+func (c *MemoryResponseCache) Put(ctx context.Context, key string, response *AgenticRAGResponse) error {
+	c.entries.put(key, response)
+	return nil
+}
+
+// lruCache is a map that evicts the least recently used value once full and expires
+// values a fixed time after they were stored
+type lruCache[V any] struct {
+	maxEntries int
+	ttl        time.Duration
+
+	mu      sync.Mutex
+	order   *list.List // Most recently used first
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a value stored in an lruCache
+type cacheEntry[V any] struct {
+	key      string
+	value    V
+	storedAt time.Time
+}
+
+// newLRUCache creates a cache holding up to maxEntries values (0 for no limit). A ttl
+// of 0 keeps values until they're evicted.
+func newLRUCache[V any](maxEntries int, ttl time.Duration) *lruCache[V] {
+	return &lruCache[V]{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+// get returns the value stored under key, or the zero value if there is none or it
+// expired
+func (c *lruCache[V]) get(key string) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var zero V
+	element, ok := c.entries[key]
+	if !ok {
+		return zero
+	}
+	entry := element.Value.(*cacheEntry[V])
+	if c.ttl > 0 && time.Since(entry.storedAt) > c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return zero
+	}
+	c.order.MoveToFront(element)
+	return entry.value
+}
+
+// put stores the value under key, evicting the least recently used values when full
+func (c *lruCache[V]) put(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = &cacheEntry[V]{key: key, value: value, storedAt: time.Now()}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry[V]{key: key, value: value, storedAt: time.Now()})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+// cachedResponse returns a copy of the cached response for key marked as a cache hit,
+// or nil if there is none
+func (p *AgenticRAGProcessor) cachedResponse(ctx context.Context, key string, startTime time.Time) (*AgenticRAGResponse, error) {
+	cached, err := p.cache.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response cache: %w", err)
+	}
+	if cached == nil {
+		return nil, nil
+	}
+	response, err := cloneResponse(cached)
+	if err != nil {
+		return nil, err
+	}
+	response.ProcessingMetadata.RequestID = requestStateFrom(ctx).id()
+	response.ProcessingMetadata.CacheHit = true
+	response.ProcessingMetadata.ProcessingTime = time.Since(startTime)
+	return response, nil
+}
+
+// cacheResponse stores a copy of the response under key
+func (p *AgenticRAGProcessor) cacheResponse(ctx context.Context, key string, response *AgenticRAGResponse) error {
+	cached, err := cloneResponse(response)
+	if err != nil {
+		return err
+	}
+	if err := p.cache.Put(ctx, key, cached); err != nil {
+		return fmt.Errorf("failed to write response cache: %w", err)
+	}
+	return nil
+}
+
+// responseCacheKey returns a stable hash of everything in the request that affects
+// the response: the normalized query, the history, the documents and the options,
+// and the content hashes of the reloadable prompts it runs with, so a prompt reload
+// doesn't serve answers of the old prompts. Documents given by path or URL are keyed
+// by that reference, not by what it points to.
+func responseCacheKey(request AgenticRAGRequest, prompts *promptSet) (string, error) {
+	options := request.Options
+	options.ForceRefresh = false  // Doesn't change the response
+	options.ForceReverify = false // Only changes where verdicts come from
+	options.AllowPartial = false  // Only complete responses are cached
+
+	documents := make([]string, 0, len(request.Docs)+len(request.Documents))
+	for _, doc := range request.Docs {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode document %s: %w", doc.ID, err)
+		}
+		documents = append(documents, hashBytes(data))
+	}
+	for _, document := range request.Documents {
+		documents = append(documents, hashBytes([]byte(document)))
+	}
+
+	var promptHashes map[string]string
+	if prompts != nil {
+		promptHashes = prompts.hashes
+	}
+
+	data, err := json.Marshal(struct {
+		Query     string            `json:"query"`
+		History   []Turn            `json:"history,omitempty"`
+		Documents []string          `json:"documents"`
+		Options   AgenticRAGOptions `json:"options"`
+		Prompts   map[string]string `json:"prompts,omitempty"`
+	}{
+		Query:     strings.ToLower(collapseWhitespace(request.Query)),
+		History:   request.History,
+		Documents: documents,
+		Options:   options,
+		Prompts:   promptHashes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	return hashBytes(data), nil
+}
+
+// hashBytes returns the hex SHA-256 of data
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cloneResponse returns a deep copy of the response
+func cloneResponse(response *AgenticRAGResponse) (*AgenticRAGResponse, error) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode response: %w", err)
+	}
+	var cloned AgenticRAGResponse
+	if err := json.Unmarshal(data, &cloned); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &cloned, nil
+}
@@ -0,0 +1,541 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Distance functions a Chroma collection can be created with
+const (
+	ChromaCosine       = "cosine"
+	ChromaL2           = "l2"
+	ChromaInnerProduct = "ip"
+)
+
+// Metadata keys of a chunk's entry in a Chroma collection. Chroma metadata is flat, so
+// the scalar values of the document metadata are stored under chromaMetadataPrefix for
+// filtering, and the whole metadata as JSON under chromaMetadataJSONKey.
+const (
+	chromaMetadataPrefix  = "metadata."
+	chromaMetadataJSONKey = "metadata_json"
+)
+
+// ChromaConfig contains configuration for a ChromaStore
+type ChromaConfig struct {
+	Host       string        `json:"host"`       // Host of the server (default: localhost)
+	Port       int           `json:"port"`       // Port of the server (default: 8000)
+	SSL        bool          `json:"ssl"`        // Connect over HTTPS, implied by TLS
+	AuthToken  string        `json:"auth_token"` // Sent as a bearer token, required by servers with token auth
+	Tenant     string        `json:"tenant"`     // Tenant of the database (default: default_tenant)
+	Database   string        `json:"database"`   // Database of the collection (default: default_database)
+	Collection string        `json:"collection"` // Collection of the chunks (default: rag_chunks)
+	Distance   string        `json:"distance"`   // Distance function of the collection: cosine, l2 or ip, matching how the embedder's vectors compare (default: cosine)
+	BatchSize  int           `json:"batch_size"` // Chunks embedded and written per request (default: 100)
+	Timeout    time.Duration `json:"timeout"`    // Per-request timeout
+
+	TLS        *tls.Config  `json:"-"` // TLS settings such as a private CA or client certificates, ignored with HTTPClient
+	HTTPClient *http.Client `json:"-"` // Client used for requests (not serialized)
+}
+
+// ChromaStore is an Indexer and Retriever in a Chroma collection, over its v2 HTTP API.
+// Each chunk is an entry whose document is the chunk's content and whose metadata
+// describes the chunk and its document. Entry IDs derive from the document ID and the
+// chunk's content, so indexing a document again only embeds its new chunks and drops
+// the ones it no longer has. Document filters are translated to where filters.
+// Failures reaching Chroma wrap ErrRetrieverUnavailable.
+type ChromaStore struct {
+	config       ChromaConfig
+	embedder     ai.Embedder
+	client       *http.Client
+	baseURL      string // Collections endpoint of the database
+	collectionID string
+}
+
+// NewChromaStore creates the collection with the configured distance function if it
+// doesn't exist yet, and checks an existing one uses it. The embedder embeds both
+// chunks and queries.
+func NewChromaStore(ctx context.Context, embedder ai.Embedder, config ChromaConfig) (*ChromaStore, error) {
+	if config.Host == "" {
+		config.Host = "localhost"
+	}
+	if config.Port == 0 {
+		config.Port = 8000
+	}
+	if config.Tenant == "" {
+		config.Tenant = "default_tenant"
+	}
+	if config.Database == "" {
+		config.Database = "default_database"
+	}
+	if config.Collection == "" {
+		config.Collection = "rag_chunks"
+	}
+	if config.Distance == "" {
+		config.Distance = ChromaCosine
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if strings.Contains(config.Host, "/") || config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid chroma host %q and port %d", config.Host, config.Port)
+	}
+	switch config.Distance {
+	case ChromaCosine, ChromaL2, ChromaInnerProduct:
+	default:
+		return nil, fmt.Errorf("unknown chroma distance %q, must be cosine, l2 or ip", config.Distance)
+	}
+	if embedder == nil {
+		return nil, errors.New("chroma store requires an embedder")
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+		if config.TLS != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = config.TLS
+			client.Transport = transport
+		}
+	}
+	scheme := "http"
+	if config.SSL || config.TLS != nil {
+		scheme = "https"
+	}
+	s := &ChromaStore{
+		config:   config,
+		embedder: embedder,
+		client:   client,
+		baseURL: fmt.Sprintf("%s://%s/api/v2/tenants/%s/databases/%s/collections", scheme,
+			net.JoinHostPort(config.Host, strconv.Itoa(config.Port)), url.PathEscape(config.Tenant), url.PathEscape(config.Database)),
+	}
+	if err := s.ensureCollection(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ensureCollection gets or creates the collection, keeping its ID
+func (s *ChromaStore) ensureCollection(ctx context.Context) error {
+	var collection struct {
+		ID       string         `json:"id"`
+		Metadata map[string]any `json:"metadata"`
+	}
+	create := map[string]any{
+		"name":          s.config.Collection,
+		"metadata":      map[string]any{"hnsw:space": s.config.Distance},
+		"get_or_create": true,
+	}
+	if err := s.call(ctx, "collection creation", http.MethodPost, s.baseURL, create, &collection); err != nil {
+		return err
+	}
+	// Chroma keeps an existing collection's distance function, l2 unless set
+	space, _ := collection.Metadata["hnsw:space"].(string)
+	if space == "" {
+		space = ChromaL2
+	}
+	if space != s.config.Distance {
+		return &ChromaError{
+			Op:      "collection creation",
+			Message: fmt.Sprintf("collection %s uses the %s distance, not %s", s.config.Collection, space, s.config.Distance),
+			Hint:    "use a new collection, or set ChromaConfig.Distance to the collection's",
+		}
+	}
+	s.collectionID = collection.ID
+	return nil
+}
+
+// chromaEntries are entries of the collection, as Chroma takes and returns them
+type chromaEntries struct {
+	IDs        []string         `json:"ids"`
+	Embeddings [][]float32      `json:"embeddings,omitempty"`
+	Documents  []string         `json:"documents,omitempty"`
+	Metadatas  []map[string]any `json:"metadatas,omitempty"`
+}
+
+// Index implements Indexer. The chunks of each document replace the entries stored for
+// it, embedding only the chunks whose content isn't stored yet.
+func (s *ChromaStore) Index(ctx context.Context, documents []Document, chunks []DocumentChunk) error {
+	byID := make(map[string]Document, len(documents))
+	for _, doc := range documents {
+		byID[doc.ID] = doc
+	}
+	var order []string
+	grouped := make(map[string][]DocumentChunk)
+	for _, chunk := range chunks {
+		if _, ok := grouped[chunk.DocumentID]; !ok {
+			order = append(order, chunk.DocumentID)
+		}
+		grouped[chunk.DocumentID] = append(grouped[chunk.DocumentID], chunk)
+	}
+	for _, id := range order {
+		if err := s.indexDocument(ctx, byID[id], id, grouped[id]); err != nil {
+			return fmt.Errorf("failed to index document %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// indexDocument replaces the entries of the document with its chunks
+func (s *ChromaStore) indexDocument(ctx context.Context, doc Document, id string, chunks []DocumentChunk) error {
+	var stored chromaEntries
+	lookup := map[string]any{"where": chromaEq("document_id", id), "include": []string{}}
+	if err := s.call(ctx, "entry lookup", http.MethodPost, s.collectionURL("/get"), lookup, &stored); err != nil {
+		return err
+	}
+	stale := make(map[string]bool, len(stored.IDs))
+	for _, storedID := range stored.IDs {
+		stale[storedID] = true
+	}
+
+	// Chunks of the same content share their entry, so only the first is kept
+	var added, updated chromaEntries
+	docHash := documentHash(doc)
+	seen := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		hash := contentHash(chunk.Content)
+		entryID := contentHash(id + "\x00" + hash)
+		if seen[entryID] {
+			continue
+		}
+		seen[entryID] = true
+		metadata, err := chromaMetadata(doc, chunk, hash, docHash)
+		if err != nil {
+			return err
+		}
+		target := &added
+		if stale[entryID] {
+			target = &updated
+			delete(stale, entryID)
+		}
+		target.IDs = append(target.IDs, entryID)
+		target.Documents = append(target.Documents, chunk.Content)
+		target.Metadatas = append(target.Metadatas, metadata)
+	}
+
+	for start := 0; start < len(added.IDs); start += s.config.BatchSize {
+		end := min(start+s.config.BatchSize, len(added.IDs))
+		embeddings, err := s.embed(ctx, added.Documents[start:end])
+		if err != nil {
+			return err
+		}
+		batch := chromaEntries{IDs: added.IDs[start:end], Embeddings: embeddings, Documents: added.Documents[start:end], Metadatas: added.Metadatas[start:end]}
+		if err := s.call(ctx, "add", http.MethodPost, s.collectionURL("/add"), batch, nil); err != nil {
+			return err
+		}
+	}
+	for start := 0; start < len(updated.IDs); start += s.config.BatchSize {
+		end := min(start+s.config.BatchSize, len(updated.IDs))
+		batch := chromaEntries{IDs: updated.IDs[start:end], Documents: updated.Documents[start:end], Metadatas: updated.Metadatas[start:end]}
+		if err := s.call(ctx, "update", http.MethodPost, s.collectionURL("/update"), batch, nil); err != nil {
+			return err
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(stale))
+	for staleID := range stale {
+		ids = append(ids, staleID)
+	}
+	return s.call(ctx, "stale entry deletion", http.MethodPost, s.collectionURL("/delete"), map[string]any{"ids": ids}, nil)
+}
+
+// chromaMetadata returns the metadata of a chunk's entry
+func chromaMetadata(doc Document, chunk DocumentChunk, hash, docHash string) (map[string]any, error) {
+	encoded, err := json.Marshal(doc.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata of document %s: %w", chunk.DocumentID, err)
+	}
+	metadata := map[string]any{
+		"document_id":         chunk.DocumentID,
+		"chunk_id":            chunk.ID,
+		"chunk_index":         chunk.ChunkIndex,
+		"content_hash":        hash,
+		"title":               doc.Title,
+		"source":              doc.Source,
+		"document_hash":       docHash,
+		chromaMetadataJSONKey: string(encoded),
+	}
+	for key, value := range doc.Metadata {
+		switch value.(type) {
+		case string, bool, float64, float32, int, int64, int32:
+			metadata[chromaMetadataPrefix+key] = value
+		}
+	}
+	return metadata, nil
+}
+
+// DocumentHash implements Indexer
+func (s *ChromaStore) DocumentHash(ctx context.Context, documentID string) (string, error) {
+	var stored chromaEntries
+	lookup := map[string]any{"where": chromaEq("document_id", documentID), "limit": 1, "include": []string{"metadatas"}}
+	if err := s.call(ctx, "document lookup", http.MethodPost, s.collectionURL("/get"), lookup, &stored); err != nil {
+		return "", err
+	}
+	if len(stored.Metadatas) == 0 {
+		return "", nil
+	}
+	hash, _ := stored.Metadatas[0]["document_hash"].(string)
+	return hash, nil
+}
+
+// Delete implements Indexer
+func (s *ChromaStore) Delete(ctx context.Context, documentID string) ([]string, error) {
+	where := chromaEq("document_id", documentID)
+	var stored chromaEntries
+	lookup := map[string]any{"where": where, "include": []string{"metadatas"}}
+	if err := s.call(ctx, "document lookup", http.MethodPost, s.collectionURL("/get"), lookup, &stored); err != nil {
+		return nil, err
+	}
+	var hashes []string
+	for _, metadata := range stored.Metadatas {
+		if hash, ok := metadata["content_hash"].(string); ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	if err := s.call(ctx, "document deletion", http.MethodPost, s.collectionURL("/delete"), map[string]any{"where": where}, nil); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// Retrieve implements Retriever. Filters are applied by Chroma where they translate,
+// and to the entries returned otherwise.
+func (s *ChromaStore) Retrieve(ctx context.Context, query string, k int, filter *DocumentFilter) ([]ScoredChunk, error) {
+	embeddings, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	search := map[string]any{"query_embeddings": embeddings, "n_results": k, "include": []string{"documents", "metadatas", "distances"}}
+	if filter != nil {
+		where, exact := chromaWhere(*filter)
+		if where != nil {
+			search["where"] = where
+		}
+		if !exact {
+			search["n_results"] = k * retrievalOverfetch
+		}
+	}
+	var result struct {
+		Documents [][]string         `json:"documents"`
+		Metadatas [][]map[string]any `json:"metadatas"`
+		Distances [][]float64        `json:"distances"`
+	}
+	if err := s.call(ctx, "query", http.MethodPost, s.collectionURL("/query"), search, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Documents) == 0 || len(result.Metadatas) == 0 || len(result.Distances) == 0 {
+		return nil, nil
+	}
+
+	documents, metadatas, distances := result.Documents[0], result.Metadatas[0], result.Distances[0]
+	chunks := make([]ScoredChunk, 0, min(k, len(documents)))
+	for i, stored := range metadatas {
+		if len(chunks) == k || i >= len(documents) || i >= len(distances) {
+			break
+		}
+		var metadata map[string]any
+		if encoded, ok := stored[chromaMetadataJSONKey].(string); ok {
+			_ = json.Unmarshal([]byte(encoded), &metadata)
+		}
+		if filter != nil && !filter.matches(metadata) {
+			continue
+		}
+		scored := ScoredChunk{Score: s.score(distances[i]), Metadata: metadata}
+		scored.Chunk.DocumentID, _ = stored["document_id"].(string)
+		scored.Chunk.ID, _ = stored["chunk_id"].(string)
+		scored.Chunk.Content = documents[i]
+		if index, ok := stored["chunk_index"].(float64); ok {
+			scored.Chunk.ChunkIndex = int(index)
+		}
+		scored.Title, _ = stored["title"].(string)
+		scored.Source, _ = stored["source"].(string)
+		chunks = append(chunks, scored)
+	}
+	return chunks, nil
+}
+
+// score turns a distance Chroma returns into a similarity between 0 and 1
+func (s *ChromaStore) score(distance float64) float64 {
+	if s.config.Distance == ChromaL2 {
+		return 1 / (1 + distance)
+	}
+	// Cosine and inner product distances are 1 minus the similarity
+	return min(1, max(0, 1-distance))
+}
+
+// embed returns one embedding per text
+func (s *ChromaStore) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	response, err := ai.Embed(ctx, s.embedder, ai.WithTextDocs(texts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with %s: %w", s.embedder.Name(), err)
+	}
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder %s returned %d embeddings for %d texts", s.embedder.Name(), len(response.Embeddings), len(texts))
+	}
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range response.Embeddings {
+		embeddings[i] = embedding.Embedding
+	}
+	return embeddings, nil
+}
+
+// collectionURL returns the endpoint of the collection's operation under path
+func (s *ChromaStore) collectionURL(path string) string {
+	return s.baseURL + "/" + url.PathEscape(s.collectionID) + path
+}
+
+// call sends a request to endpoint and decodes the response into result unless it is
+// nil
+func (s *ChromaStore) call(ctx context.Context, op, method, endpoint string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode chroma %s: %w", op, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create chroma %s request: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.AuthToken)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &ChromaError{Op: op, Message: err.Error(), Hint: "check ChromaConfig.Host and Port and that Chroma is reachable", Kind: ErrRetrieverUnavailable}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &ChromaError{Op: op, Status: resp.StatusCode, Message: err.Error(), Kind: ErrRetrieverUnavailable}
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		message := failure.Message
+		if message == "" {
+			message = failure.Error
+		}
+		if message == "" {
+			message = resp.Status
+		}
+		return s.classify(op, resp.StatusCode, message)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("failed to read chroma %s response: %w", op, err)
+	}
+	return nil
+}
+
+// classify turns a Chroma error response into a ChromaError, recognizing missing
+// collections, embedding size mismatches, rejected tokens and server failures
+func (s *ChromaStore) classify(op string, status int, message string) error {
+	err := &ChromaError{Op: op, Status: status, Message: message}
+	lower := strings.ToLower(message)
+	switch {
+	case status == http.StatusNotFound:
+		err.Hint = fmt.Sprintf("collection %s, database %s or tenant %s doesn't exist, create the store again to recreate the collection",
+			s.config.Collection, s.config.Database, s.config.Tenant)
+	case strings.Contains(lower, "dimension"):
+		err.Hint = "the collection was created for another embedder, use a new collection or recreate it"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		err.Hint = "check ChromaConfig.AuthToken"
+	case status >= http.StatusInternalServerError:
+		err.Kind = ErrRetrieverUnavailable
+	}
+	return err
+}
+
+// chromaEq returns a where filter on a metadata key equal to value
+func chromaEq(key string, value any) map[string]any {
+	return map[string]any{key: map[string]any{"$eq": value}}
+}
+
+// chromaWhere translates the filter to a Chroma where filter and reports whether it is
+// exact. Predicates Chroma can't express, on dates, comparing strings by order or
+// matching entries without the key, are left out, widening the filter, for
+// DocumentFilter.matches on the entries returned. A nil filter matches every entry.
+func chromaWhere(f DocumentFilter) (map[string]any, bool) {
+	switch {
+	case f.And != nil:
+		var all []any
+		exact := true
+		for _, sub := range f.And {
+			where, ok := chromaWhere(sub)
+			exact = exact && ok
+			if where != nil {
+				all = append(all, where)
+			}
+		}
+		return chromaCombine("$and", all), exact
+	case f.Or != nil:
+		if len(f.Or) == 0 {
+			return nil, false
+		}
+		anyOf := make([]any, 0, len(f.Or))
+		for _, sub := range f.Or {
+			where, ok := chromaWhere(sub)
+			if where == nil || !ok {
+				return nil, false // Leaving out a branch would narrow the filter
+			}
+			anyOf = append(anyOf, where)
+		}
+		return chromaCombine("$or", anyOf), true
+	}
+
+	// Chroma's $ne skips entries without the key, which FilterOpNe matches
+	if f.Op == FilterOpNe {
+		return nil, false
+	}
+	key := chromaMetadataPrefix + f.Key
+	if number, ok := filterNumber(f.Value); ok {
+		return map[string]any{key: map[string]any{"$" + string(f.Op): number}}, true
+	}
+	if _, ok := filterTime(f.Value); ok {
+		return nil, false
+	}
+	switch f.Value.(type) {
+	case string, bool:
+		if f.Op == FilterOpEq {
+			return chromaEq(key, f.Value), true
+		}
+	}
+	return nil, false
+}
+
+// chromaCombine joins where filters with $and or $or, which Chroma only accepts with
+// two or more filters
+func chromaCombine(op string, filters []any) map[string]any {
+	switch len(filters) {
+	case 0:
+		return nil
+	case 1:
+		return filters[0].(map[string]any)
+	}
+	return map[string]any{op: filters}
+}
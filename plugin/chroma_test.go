@@ -0,0 +1,420 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// chromaCollectionsPath is the collections endpoint of the default tenant and database
+const chromaCollectionsPath = "/api/v2/tenants/default_tenant/databases/default_database/collections"
+
+// fakeChromaEntry is an entry of a fakeChroma collection
+type fakeChromaEntry struct {
+	embedding []float32
+	document  string
+	metadata  map[string]any
+}
+
+// fakeChroma stands in for the v2 HTTP API of a Chroma server with one collection,
+// keeping its entries in memory. Queries rank the entries passing the where filter by
+// cosine distance; the where filters sent are recorded.
+type fakeChroma struct {
+	mu       sync.Mutex
+	space    string // Distance of the collection, empty while it doesn't exist
+	entries  map[string]fakeChromaEntry
+	requests []string         // Method and path of every request, relative to the collection
+	auth     []string         // Authorization header of every request
+	wheres   []map[string]any // Where filters of the queries
+	fail     func(path string) (status int, message string)
+	server   *httptest.Server
+}
+
+// newFakeChroma starts a fake whose collection uses the distance space, or doesn't
+// exist when space is empty
+func newFakeChroma(t *testing.T, space string) *fakeChroma {
+	f := &fakeChroma{space: space, entries: make(map[string]fakeChromaEntry)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// log returns the requests answered so far
+func (f *fakeChroma) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// respondChroma writes a Chroma response, or error with status
+func respondChroma(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status >= 300 {
+		result = map[string]any{"error": "ChromaError", "message": result}
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeChroma) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path, ok := strings.CutPrefix(r.URL.Path, chromaCollectionsPath)
+	if !ok {
+		respondChroma(w, http.StatusNotFound, "no such tenant or database")
+		return
+	}
+	path = strings.TrimPrefix(path, "/collection_1")
+	f.requests = append(f.requests, r.Method+" "+path)
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+	if f.fail != nil {
+		if status, message := f.fail(path); status != 0 {
+			respondChroma(w, status, message)
+			return
+		}
+	}
+	var body map[string]any
+	json.NewDecoder(r.Body).Decode(&body)
+
+	switch path {
+	case "":
+		if f.space == "" {
+			metadata := body["metadata"].(map[string]any)
+			f.space = metadata["hnsw:space"].(string)
+		}
+		respondChroma(w, http.StatusOK, map[string]any{"id": "collection_1", "name": body["name"], "metadata": map[string]any{"hnsw:space": f.space}})
+	case "/get":
+		ids := f.matching(body["where"])
+		if limit, ok := body["limit"].(float64); ok {
+			ids = ids[:min(int(limit), len(ids))]
+		}
+		result := chromaEntries{IDs: ids, Metadatas: []map[string]any{}}
+		for _, id := range ids {
+			result.Metadatas = append(result.Metadatas, f.entries[id].metadata)
+		}
+		respondChroma(w, http.StatusOK, result)
+	case "/add", "/update":
+		var entries chromaEntries
+		remarshal(body, &entries)
+		for i, id := range entries.IDs {
+			entry := f.entries[id]
+			if path == "/add" {
+				entry.embedding = entries.Embeddings[i]
+			}
+			entry.document, entry.metadata = entries.Documents[i], entries.Metadatas[i]
+			f.entries[id] = entry
+		}
+		respondChroma(w, http.StatusOK, true)
+	case "/delete":
+		ids := asSlice(body["ids"])
+		if ids == nil {
+			for _, id := range f.matching(body["where"]) {
+				ids = append(ids, id)
+			}
+		}
+		for _, id := range ids {
+			delete(f.entries, id.(string))
+		}
+		respondChroma(w, http.StatusOK, nil)
+	case "/query":
+		where, _ := body["where"].(map[string]any)
+		f.wheres = append(f.wheres, where)
+		var query [][]float32
+		remarshal(body["query_embeddings"], &query)
+		type hit struct {
+			id       string
+			distance float64
+		}
+		var hits []hit
+		for _, id := range f.matching(where) {
+			hits = append(hits, hit{id, 1 - float64(dot(query[0], f.entries[id].embedding))})
+		}
+		slices.SortStableFunc(hits, func(a, b hit) int { return cmp.Compare(a.distance, b.distance) })
+		hits = hits[:min(int(body["n_results"].(float64)), len(hits))]
+		documents, metadatas, distances := []string{}, []map[string]any{}, []float64{}
+		for _, hit := range hits {
+			documents = append(documents, f.entries[hit.id].document)
+			metadatas = append(metadatas, f.entries[hit.id].metadata)
+			distances = append(distances, hit.distance)
+		}
+		respondChroma(w, http.StatusOK, map[string]any{
+			"documents": [][]string{documents}, "metadatas": [][]map[string]any{metadatas}, "distances": [][]float64{distances},
+		})
+	default:
+		respondChroma(w, http.StatusNotFound, "no such endpoint "+path)
+	}
+}
+
+// matching returns the IDs of the entries passing the where filter, sorted
+func (f *fakeChroma) matching(where any) []string {
+	var ids []string
+	for id, entry := range f.entries {
+		if where == nil || chromaPasses(entry.metadata, where.(map[string]any)) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// chromaPasses evaluates a where filter of $and, $or and comparisons on metadata
+func chromaPasses(metadata, where map[string]any) bool {
+	for key, condition := range where {
+		switch key {
+		case "$and":
+			for _, sub := range asSlice(condition) {
+				if !chromaPasses(metadata, sub.(map[string]any)) {
+					return false
+				}
+			}
+			continue
+		case "$or":
+			if !slices.ContainsFunc(asSlice(condition), func(sub any) bool { return chromaPasses(metadata, sub.(map[string]any)) }) {
+				return false
+			}
+			continue
+		}
+		value, ok := metadata[key]
+		if !ok {
+			return false
+		}
+		for op, operand := range condition.(map[string]any) {
+			number, _ := value.(float64)
+			limit, _ := operand.(float64)
+			passes := map[string]bool{"$eq": value == operand, "$ne": value != operand,
+				"$gt": number > limit, "$gte": number >= limit, "$lt": number < limit, "$lte": number <= limit}[op]
+			if !passes {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newTestChromaStore returns a store on the fake
+func newTestChromaStore(t *testing.T, f *fakeChroma, embedder *testEmbedder, config ChromaConfig) *ChromaStore {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(strings.TrimPrefix(f.server.URL, "http://"))
+	config.Host = host
+	config.Port, _ = strconv.Atoi(port)
+	store, err := NewChromaStore(context.Background(), embedder.define(t), config)
+	if err != nil {
+		t.Fatalf("NewChromaStore: %v", err)
+	}
+	return store
+}
+
+func TestNewChromaStoreCollection(t *testing.T) {
+	f := newFakeChroma(t, "")
+	newTestChromaStore(t, f, &testEmbedder{}, ChromaConfig{AuthToken: "token", Distance: ChromaInnerProduct})
+	if got := f.log(); !reflect.DeepEqual(got, []string{"POST "}) {
+		t.Errorf("requests %q, want one get-or-create", got)
+	}
+	if f.space != ChromaInnerProduct || f.auth[0] != "Bearer token" {
+		t.Errorf("collection created with distance %q and authorization %q, want ip and the token", f.space, f.auth[0])
+	}
+
+	// An existing collection must use the configured distance
+	_, err := NewChromaStore(context.Background(), (&testEmbedder{}).define(t), ChromaConfig{Host: "127.0.0.1", Port: f.server.Listener.Addr().(*net.TCPAddr).Port})
+	var chromaErr *ChromaError
+	if !errors.As(err, &chromaErr) || !strings.Contains(chromaErr.Message, "uses the ip distance, not cosine") || chromaErr.Hint == "" {
+		t.Errorf("NewChromaStore on a collection of another distance = %v, want a mismatch with a hint", err)
+	}
+
+	embedder := (&testEmbedder{}).define(t)
+	tests := []struct {
+		name     string
+		config   ChromaConfig
+		embedder bool
+		want     string
+	}{
+		{"URL as host", ChromaConfig{Host: "http://localhost"}, true, "invalid chroma host"},
+		{"port out of range", ChromaConfig{Port: 70000}, true, "invalid chroma host"},
+		{"unknown distance", ChromaConfig{Distance: "manhattan"}, true, "unknown chroma distance"},
+		{"no embedder", ChromaConfig{}, false, "requires an embedder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := embedder
+			if !tt.embedder {
+				e = nil
+			}
+			if _, err := NewChromaStore(context.Background(), e, tt.config); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewChromaStore = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChromaStoreIndexAndRetrieve(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeChroma(t, ChromaCosine)
+	embedder := &testEmbedder{}
+	store := newTestChromaStore(t, f, embedder, ChromaConfig{BatchSize: 1})
+
+	paris := Document{ID: "doc_1", Title: "Paris", Source: "https://example.com/paris", Metadata: map[string]any{"lang": "en", "year": 2024, "tags": []string{"city"}}}
+	chunks := []DocumentChunk{
+		{ID: "doc_1_chunk_0", DocumentID: "doc_1", ChunkIndex: 0, Content: "The Eiffel Tower is in Paris."},
+		{ID: "doc_1_chunk_1", DocumentID: "doc_1", ChunkIndex: 1, Content: "It was completed in 1889."},
+		{ID: "doc_1_chunk_2", DocumentID: "doc_1", ChunkIndex: 2, Content: "The Eiffel Tower is in Paris."}, // Same content as the first
+	}
+	if err := store.Index(ctx, []Document{paris}, chunks); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if len(f.entries) != 2 {
+		t.Fatalf("stored %d entries, want one per distinct chunk", len(f.entries))
+	}
+	for id, entry := range f.entries {
+		if entry.metadata["metadata.lang"] != "en" || entry.metadata["metadata.tags"] != nil || entry.metadata[chromaMetadataJSONKey] == nil {
+			t.Errorf("entry %s has metadata %v, want the scalar values flattened and all of it as JSON", id, entry.metadata)
+		}
+	}
+
+	// Indexing the document again embeds only its new chunk, updates the kept one and
+	// drops the one it lost
+	embedded := len(embedder.embedded())
+	chunks = []DocumentChunk{chunks[0], {ID: "doc_1_chunk_1", DocumentID: "doc_1", ChunkIndex: 1, Content: "The Seine flows through Paris."}}
+	requests := len(f.log())
+	if err := store.Index(ctx, []Document{paris}, chunks); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if got := embedder.embedded()[embedded:]; !reflect.DeepEqual(got, []string{chunks[1].Content}) {
+		t.Errorf("reindexing embedded %q, want only the new chunk", got)
+	}
+	if got, want := f.log()[requests:], []string{"POST /get", "POST /add", "POST /update", "POST /delete"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reindexing sent %q, want %q", got, want)
+	}
+	var contents []string
+	for _, entry := range f.entries {
+		contents = append(contents, entry.document)
+	}
+	slices.Sort(contents)
+	if want := []string{chunks[0].Content, chunks[1].Content}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("stored %q, want %q", contents, want)
+	}
+	if hash, err := store.DocumentHash(ctx, "doc_1"); err != nil || hash != documentHash(paris) {
+		t.Errorf("DocumentHash = %q, %v, want %q", hash, err, documentHash(paris))
+	}
+
+	lyon := Document{ID: "doc_2", Title: "Lyon", Metadata: map[string]any{"lang": "fr", "year": 2020}}
+	if err := store.Index(ctx, []Document{lyon}, []DocumentChunk{{ID: "doc_2_chunk_0", DocumentID: "doc_2", Content: "The Eiffel Tower is not in Lyon."}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	// The filter goes to Chroma as a where filter
+	filter := &DocumentFilter{And: []DocumentFilter{{Key: "lang", Op: FilterOpEq, Value: "en"}, {Key: "year", Op: FilterOpGte, Value: 2020}}}
+	results, err := store.Retrieve(ctx, "Where is the Eiffel Tower?", 1, filter)
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if len(results) != 1 || results[0].Chunk.DocumentID != "doc_1" || results[0].Chunk.Content != chunks[0].Content ||
+		results[0].Title != "Paris" || results[0].Metadata["lang"] != "en" || results[0].Score <= 0 || results[0].Score > 1 {
+		t.Errorf("Retrieve = %+v, want the English Eiffel Tower chunk", results)
+	}
+	want := map[string]any{"$and": []any{
+		map[string]any{"metadata.lang": map[string]any{"$eq": "en"}},
+		map[string]any{"metadata.year": map[string]any{"$gte": float64(2020)}},
+	}}
+	if !reflect.DeepEqual(f.wheres[0], want) {
+		t.Errorf("query where %v, want %v", f.wheres[0], want)
+	}
+
+	hashes, err := store.Delete(ctx, "doc_1")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	slices.Sort(hashes)
+	wantHashes := []string{contentHash(chunks[0].Content), contentHash(chunks[1].Content)}
+	slices.Sort(wantHashes)
+	if !reflect.DeepEqual(hashes, wantHashes) {
+		t.Errorf("Delete returned hashes %q, want %q", hashes, wantHashes)
+	}
+	if len(f.entries) != 1 {
+		t.Errorf("%d entries left, want only Lyon's", len(f.entries))
+	}
+}
+
+func TestChromaStoreErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		kind   error
+		hint   string
+	}{
+		{"collection deleted", http.StatusNotFound, nil, "create the store again"},
+		{"rejected token", http.StatusForbidden, nil, "ChromaConfig.AuthToken"},
+		{"server failure", http.StatusInternalServerError, ErrRetrieverUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeChroma(t, ChromaCosine)
+			store := newTestChromaStore(t, f, &testEmbedder{}, ChromaConfig{})
+			f.fail = func(string) (int, string) { return tt.status, "request failed" }
+
+			_, err := store.Retrieve(context.Background(), "Where is the Eiffel Tower?", 3, nil)
+			var chromaErr *ChromaError
+			if !errors.As(err, &chromaErr) || chromaErr.Op != "query" || chromaErr.Status != tt.status || chromaErr.Message != "request failed" {
+				t.Fatalf("Retrieve = %v, want the query failure", err)
+			}
+			if chromaErr.Kind != tt.kind {
+				t.Errorf("error kind %v, want %v", chromaErr.Kind, tt.kind)
+			}
+			if !strings.Contains(chromaErr.Hint, tt.hint) {
+				t.Errorf("hint %q, want it to mention %q", chromaErr.Hint, tt.hint)
+			}
+		})
+	}
+
+	// An unreachable server is unavailable too
+	f := newFakeChroma(t, ChromaCosine)
+	store := newTestChromaStore(t, f, &testEmbedder{}, ChromaConfig{})
+	f.server.Close()
+	if _, err := store.Retrieve(context.Background(), "Where is the Eiffel Tower?", 3, nil); !errors.Is(err, ErrRetrieverUnavailable) {
+		t.Errorf("Retrieve from a stopped server = %v, want ErrRetrieverUnavailable", err)
+	}
+}
+
+func TestChromaWhere(t *testing.T) {
+	en := DocumentFilter{Key: "lang", Op: FilterOpEq, Value: "en"}
+	tests := []struct {
+		name   string
+		filter DocumentFilter
+		want   string
+		exact  bool
+	}{
+		{"equal string", en, `{"metadata.lang":{"$eq":"en"}}`, true},
+		{"number", DocumentFilter{Key: "year", Op: FilterOpLt, Value: 2020}, `{"metadata.year":{"$lt":2020}}`, true},
+		{"not equal", DocumentFilter{Key: "lang", Op: FilterOpNe, Value: "en"}, `null`, false},
+		{"date", DocumentFilter{Key: "published", Op: FilterOpGt, Value: "2024-01-01"}, `null`, false},
+		{"string order", DocumentFilter{Key: "lang", Op: FilterOpGt, Value: "en"}, `null`, false},
+		{"and of one", DocumentFilter{And: []DocumentFilter{en, {Key: "lang", Op: FilterOpNe, Value: "fr"}}}, `{"metadata.lang":{"$eq":"en"}}`, false},
+		{
+			"or",
+			DocumentFilter{Or: []DocumentFilter{en, {Key: "year", Op: FilterOpGte, Value: 2020}}},
+			`{"$or":[{"metadata.lang":{"$eq":"en"}},{"metadata.year":{"$gte":2020}}]}`, true,
+		},
+		{"or with a branch left out", DocumentFilter{Or: []DocumentFilter{en, {Key: "lang", Op: FilterOpNe, Value: "fr"}}}, `null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, exact := chromaWhere(tt.filter)
+			got, err := json.Marshal(where)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(got) != tt.want || exact != tt.exact {
+				t.Errorf("chromaWhere = %s, %v, want %s, %v", got, exact, tt.want, tt.exact)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+)
+
+// paragraphDocument separates its sentences with blank lines rather than single spaces
+const paragraphDocument = "Intro line here.\n\nThe Eiffel Tower is in Paris.\n\nIt was completed in 1889 for the fair.\n\nKafka runs on the JVM today."
+
+// chunkParagraphs chunks paragraphDocument into chunks of at most size characters
+func chunkParagraphs(t *testing.T, size int) (Document, []DocumentChunk) {
+	t.Helper()
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Processing.DefaultChunkSize = size
+	})
+	doc := Document{ID: "doc_1", Content: paragraphDocument}
+	chunks, err := p.chunkDocument(context.Background(), doc, 10)
+	if err != nil {
+		t.Fatalf("chunkDocument: %v", err)
+	}
+	return doc, chunks
+}
+
+func TestChunkTextKeepsSourceOffsets(t *testing.T) {
+	for _, tt := range []struct {
+		size       int
+		wantStarts []int
+	}{
+		{40, []int{0, 18, 49, 89}}, // A sentence per chunk
+		{80, []int{0, 49}},         // Two paragraphs per chunk
+	} {
+		doc, chunks := chunkParagraphs(t, tt.size)
+		if len(chunks) != len(tt.wantStarts) {
+			t.Fatalf("size %d: got %d chunks, want %d", tt.size, len(chunks), len(tt.wantStarts))
+		}
+		for i, chunk := range chunks {
+			if chunk.StartIndex != tt.wantStarts[i] || doc.Content[chunk.StartIndex:chunk.EndIndex] != chunk.Content {
+				t.Errorf("size %d: chunk %d at [%d:%d] = %q, want it at %d and the source text there", tt.size, i, chunk.StartIndex, chunk.EndIndex, chunk.Content, tt.wantStarts[i])
+			}
+		}
+	}
+
+	// Sentences of a chunk keep the separators between them, and sub-chunks their own offsets
+	doc := Document{ID: "doc_1", Content: paragraphDocument}
+	p := newTestProcessor(t, &mockProvider{}, nil)
+	chunks := p.chunkText(doc, doc.Content, 0, 0, 10, "")
+	if len(chunks) != 1 || chunks[0].Content != paragraphDocument {
+		t.Fatalf("chunks = %+v, want the whole document", chunks)
+	}
+	for _, sub := range p.breakdownChunk(chunks[0], "") {
+		if doc.Content[sub.StartIndex:sub.EndIndex] != sub.Content {
+			t.Errorf("sub-chunk at [%d:%d] = %q, not the source text there", sub.StartIndex, sub.EndIndex, sub.Content)
+		}
+	}
+
+	// Offsets of paged documents count the form feeds between pages
+	paged := Document{ID: "doc_2", Pages: []DocumentPage{
+		{Number: 1, Content: "  First page.\n\nIt has two paragraphs.\n"},
+		{Number: 2, Content: "Second page.\n\n\nIt ends here."},
+	}}
+	chunks, err := p.chunkDocument(context.Background(), paged, 10)
+	if err != nil {
+		t.Fatalf("chunkDocument: %v", err)
+	}
+	text := documentText(paged)
+	for _, chunk := range chunks {
+		if text[chunk.StartIndex:chunk.EndIndex] != chunk.Content {
+			t.Errorf("page %d chunk at [%d:%d] = %q, not the source text there", chunk.Page, chunk.StartIndex, chunk.EndIndex, chunk.Content)
+		}
+	}
+}
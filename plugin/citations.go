@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// citationMarkerPattern matches bracketed citation markers such as [1] or [1, 3]
+var citationMarkerPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// Whitespace patterns left behind by removed markers
+var (
+	spaceBeforePunctuation = regexp.MustCompile(`[ \t]+([.,;:!?])`)
+	repeatedSpaces         = regexp.MustCompile(`(\S) {2,}`)
+)
+
+// extractCitations resolves the citation markers in answer against the chunks the
+// answer was synthesized from, marker n referring to chunks[n-1]. Markers that don't
+// refer to a real chunk are removed from the answer. Returns the validated answer,
+// a clean variant without markers, and one citation per cited chunk in marker order.
+func extractCitations(answer string, chunks []DocumentChunk, documentIndex map[string]int) (string, string, []Citation) {
+	var citations []Citation
+	cited := make(map[int]bool)
+
+	validated := citationMarkerPattern.ReplaceAllStringFunc(answer, func(marker string) string {
+		var valid []string
+		for _, field := range strings.Split(strings.Trim(marker, "[]"), ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > len(chunks) {
+				continue // Hallucinated marker
+			}
+			valid = append(valid, strconv.Itoa(n))
+			if cited[n] {
+				continue
+			}
+			cited[n] = true
+
+			chunk := chunks[n-1]
+			index, ok := documentIndex[chunk.DocumentID]
+			if !ok {
+				index = -1
+			}
+			citations = append(citations, Citation{
+				Marker:        n,
+				DocumentIndex: index,
+				DocumentID:    chunk.DocumentID,
+				ChunkID:       chunk.ID,
+				CharStart:     chunk.StartIndex,
+				CharEnd:       chunk.EndIndex,
+				Quote:         chunk.Content,
+				Page:          chunk.Page,
+				HeadingPath:   chunk.HeadingPath,
+			})
+		}
+		if len(valid) == 0 {
+			return ""
+		}
+		return "[" + strings.Join(valid, ", ") + "]"
+	})
+
+	if validated != answer {
+		validated = tidyAnswer(validated)
+	}
+	return validated, tidyAnswer(citationMarkerPattern.ReplaceAllString(validated, "")), citations
+}
+
+// tidyAnswer removes the stray whitespace left behind by removed markers
+func tidyAnswer(answer string) string {
+	answer = spaceBeforePunctuation.ReplaceAllString(answer, "$1")
+	answer = repeatedSpaces.ReplaceAllString(answer, "$1 ")
+	return strings.TrimSpace(answer)
+}
@@ -0,0 +1,30 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCitationOffsetsAcrossParagraphs(t *testing.T) {
+	mock := &mockProvider{answer: func(prompt string) string {
+		return "The tower is in Paris [1]. Kafka runs on the JVM [2]."
+	}}
+	p := newTestProcessor(t, mock, func(config *AgenticRAGConfig) {
+		config.Processing.DefaultChunkSize = 80
+	})
+	request := AgenticRAGRequest{Query: "Where is the Eiffel Tower?", Documents: []string{paragraphDocument}}
+	request.Options.EnableCitations = true
+	response := mustProcess(t, p, request)
+
+	if len(response.Citations) != 2 {
+		t.Fatalf("citations = %+v, want two", response.Citations)
+	}
+	for _, citation := range response.Citations {
+		if citation.DocumentIndex != 0 {
+			t.Fatalf("citation %+v, want the only document", citation)
+		}
+		if got := paragraphDocument[citation.CharStart:citation.CharEnd]; got != citation.Quote || !strings.Contains(got, "\n\n") {
+			t.Errorf("citation %d at [%d:%d] = %q, want its chunk %q spanning two paragraphs", citation.Marker, citation.CharStart, citation.CharEnd, got, citation.Quote)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// ClaimType classifies what kind of statement a claim makes
+type ClaimType string
+
+const (
+	ClaimTypeNumeric      ClaimType = "numeric"      // States a quantity, measurement, date or ranking
+	ClaimTypeCausal       ClaimType = "causal"       // States that something causes or leads to something else
+	ClaimTypeDefinitional ClaimType = "definitional" // States what something is or how it's classified
+	ClaimTypeAttributed   ClaimType = "attributed"   // Reports what a person or organization said or believes
+	ClaimTypeOther        ClaimType = "other"        // Any other factual statement
+)
+
+// claimsSchema is the JSON schema of the model's extracted claims
+var claimsSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"claims"},
+	"properties": map[string]any{
+		"claims": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"sentence", "text", "type"},
+				"properties": map[string]any{
+					"sentence": map[string]any{"type": "integer"},
+					"text":     map[string]any{"type": "string"},
+					"type":     map[string]any{"type": "string", "enum": []any{"numeric", "causal", "definitional", "attributed", "other"}},
+					"entities": map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+				},
+			},
+		},
+	},
+}
+
+// ClaimExtractionOptions tunes ClaimExtractor.Extract
+type ClaimExtractionOptions struct {
+	Language  string      // BCP 47 language of the text, used to split sentences and word claims (default: English)
+	Types     []ClaimType // Only return claims of these types (default: all)
+	MaxClaims int         // Return at most this many claims, in text order (0 for no limit)
+}
+
+// ClaimExtractor breaks text down into the individual factual claims it makes. Fact
+// verification extracts the claims of an answer with it before checking them, and it
+// can be used on its own to screen any text.
+type ClaimExtractor struct {
+	processor *AgenticRAGProcessor
+}
+
+// NewClaimExtractor creates a claim extractor calling the processor's models
+func NewClaimExtractor(processor *AgenticRAGProcessor) *ClaimExtractor {
+	return &ClaimExtractor{processor: processor}
+}
+
+// Extract returns the factual claims of the text in text order. Each claim is
+// rewritten to stand on its own and carries the sentence it came from with the
+// sentence's byte offsets in text, its type and the entities it names. Sentences
+// without factual content yield no claims.
+func (e *ClaimExtractor) Extract(ctx context.Context, text string, opts ClaimExtractionOptions) ([]Claim, error) {
+	var sentences []prosePart
+	for _, part := range segmentSentences(text, opts.Language) {
+		trimmed := strings.TrimSpace(part.text)
+		if trimmed == "" {
+			continue
+		}
+		start := part.start + strings.Index(part.text, trimmed)
+		sentences = append(sentences, prosePart{text: trimmed, start: start, end: start + len(trimmed)})
+	}
+	if len(sentences) == 0 {
+		return nil, nil
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(`Break the numbered sentences below down into the individual factual claims they make. For each claim give:
+- sentence: the number of the sentence it comes from
+- text: the claim as one short, self-contained statement, with pronouns replaced by what they refer to
+- type: numeric (a quantity, measurement, date or ranking), causal (something causes or leads to something else), definitional (what something is or how it's classified), attributed (what a person or organization said or believes) or other
+- entities: the people, organizations, places, products and concepts the claim names
+Skip opinions, questions, instructions and transitions.
+`)
+	if opts.Language != "" {
+		fmt.Fprintf(&prompt, "Write claim text in %s; keep entity names as written.\n", languageName(opts.Language))
+	}
+	prompt.WriteString("\nSentences:\n")
+	for i, sentence := range sentences {
+		fmt.Fprintf(&prompt, "(%d) %s\n", i, sentence.text)
+	}
+	prompt.WriteString(`
+Example: {"claims": [{"sentence": 0, "text": "Acme was founded in 1998.", "type": "numeric", "entities": ["Acme"]}]}`)
+
+	value, _, err := e.processor.generateJSON(ctx, prompt.String(), claimsSchema, &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 300 + 120*len(sentences),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract claims: %w", err)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+	var extracted struct {
+		Claims []struct {
+			Sentence int       `json:"sentence"`
+			Text     string    `json:"text"`
+			Type     ClaimType `json:"type"`
+			Entities []string  `json:"entities"`
+		} `json:"claims"`
+	}
+	if err := json.Unmarshal(data, &extracted); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	claims := make([]Claim, 0, len(extracted.Claims))
+	for _, item := range extracted.Claims {
+		text := strings.TrimSpace(item.Text)
+		if item.Sentence < 0 || item.Sentence >= len(sentences) || text == "" {
+			continue
+		}
+		switch item.Type {
+		case ClaimTypeNumeric, ClaimTypeCausal, ClaimTypeDefinitional, ClaimTypeAttributed:
+		default:
+			item.Type = ClaimTypeOther
+		}
+		if len(opts.Types) > 0 && !slices.Contains(opts.Types, item.Type) {
+			continue
+		}
+		sentence := sentences[item.Sentence]
+		claims = append(claims, Claim{
+			Text:          text,
+			Type:          item.Type,
+			Sentence:      sentence.text,
+			SentenceStart: sentence.start,
+			SentenceEnd:   sentence.end,
+			Entities:      item.Entities,
+		})
+	}
+	slices.SortStableFunc(claims, func(a, b Claim) int { return a.SentenceStart - b.SentenceStart })
+	if opts.MaxClaims > 0 && len(claims) > opts.MaxClaims {
+		claims = claims[:opts.MaxClaims]
+	}
+	return claims, nil
+}
@@ -0,0 +1,292 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Community defaults, see KnowledgeGraphConfig.EnableCommunities
+const (
+	maxLabelIterations        = 20
+	minCommunitySize          = 2
+	communitySummaryTokens    = 300
+	communityCacheSize        = 1000
+	defaultRoutedCommunities  = 3
+	maxCommunityPromptMembers = 50
+)
+
+// Community is a group of densely related entities of a knowledge graph with a
+// model-written summary, used to route queries over large corpora
+type Community struct {
+	ID       string   `json:"id"`                // Derived from the members and their relations, stable across requests
+	Entities []string `json:"entities"`          // Names of the member entities
+	Summary  string   `json:"summary,omitempty"` // Empty if the summary couldn't be generated
+}
+
+// weightedEdge is an undirected edge between two nodes numbered from 0
+type weightedEdge struct {
+	a, b   int
+	weight float64
+}
+
+// labelPropagation finds communities: every node starts in its own group and
+// repeatedly joins the group most of its neighbors are in, weighted by edge weight,
+// until no node moves. Nodes are visited in order and ties go to the lowest group, so
+// the groups are deterministic. Returns each node's group, numbered from 0 in node
+// order.
+func labelPropagation(n int, edges []weightedEdge) []int {
+	type neighbor struct {
+		node   int
+		weight float64
+	}
+	neighbors := make([][]neighbor, n)
+	for _, edge := range edges {
+		if edge.a == edge.b {
+			continue
+		}
+		neighbors[edge.a] = append(neighbors[edge.a], neighbor{edge.b, edge.weight})
+		neighbors[edge.b] = append(neighbors[edge.b], neighbor{edge.a, edge.weight})
+	}
+
+	labels := make([]int, n)
+	for i := range labels {
+		labels[i] = i
+	}
+	for iteration := 0; iteration < maxLabelIterations; iteration++ {
+		moved := false
+		for i := range labels {
+			if len(neighbors[i]) == 0 {
+				continue
+			}
+			votes := make(map[int]float64)
+			for _, neighbor := range neighbors[i] {
+				votes[labels[neighbor.node]] += neighbor.weight
+			}
+			best := labels[i]
+			for label, vote := range votes {
+				if vote > votes[best] || (vote == votes[best] && label < best) {
+					best = label
+				}
+			}
+			if best != labels[i] {
+				labels[i], moved = best, true
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+
+	groups := make([]int, n)
+	numbers := make(map[int]int)
+	for i, label := range labels {
+		number, ok := numbers[label]
+		if !ok {
+			number = len(numbers)
+			numbers[label] = number
+		}
+		groups[i] = number
+	}
+	return groups
+}
+
+// detectCommunities groups the entities of the graph by label propagation over its
+// relations, weighted by confidence. Groups smaller than minCommunitySize are left
+// out. Returns the communities, largest first, and the text describing each, which
+// both the summary prompt and the cache key are built from.
+func (kg *KnowledgeGraph) detectCommunities(minConfidence float64) ([]Community, []string) {
+	nodes, edges := kg.exportGraph(GraphExportOptions{MinConfidence: minConfidence})
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		index[node.id] = i
+	}
+	weighted := make([]weightedEdge, len(edges))
+	for i, edge := range edges {
+		weighted[i] = weightedEdge{a: index[edge.source], b: index[edge.target], weight: edge.relation.Confidence}
+	}
+	groups := labelPropagation(len(nodes), weighted)
+
+	members := make(map[int][]int)
+	for i, group := range groups {
+		members[group] = append(members[group], i)
+	}
+	var order []int
+	for group, nodes := range members {
+		if len(nodes) >= minCommunitySize {
+			order = append(order, group)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if len(members[order[i]]) != len(members[order[j]]) {
+			return len(members[order[i]]) > len(members[order[j]])
+		}
+		return order[i] < order[j]
+	})
+
+	communities := make([]Community, 0, len(order))
+	texts := make([]string, 0, len(order))
+	for _, group := range order {
+		var community Community
+		var text strings.Builder
+		for _, i := range members[group] {
+			entity := nodes[i].entity
+			community.Entities = append(community.Entities, entity.Name)
+			switch {
+			case len(community.Entities) > maxCommunityPromptMembers:
+			case entity.Type == "":
+				fmt.Fprintf(&text, "- %s\n", entity.Name)
+			default:
+				fmt.Fprintf(&text, "- %s (%s)\n", entity.Name, entity.Type)
+			}
+		}
+		text.WriteString("Relations:\n")
+		for _, edge := range edges {
+			if groups[index[edge.source]] == group && groups[index[edge.target]] == group {
+				fmt.Fprintf(&text, "- %s %s %s\n", nodes[index[edge.source]].entity.Name, edge.relation.Predicate, nodes[index[edge.target]].entity.Name)
+			}
+		}
+		community.ID = "community_" + contentHash(text.String())[:16]
+		communities = append(communities, community)
+		texts = append(texts, text.String())
+	}
+	return communities, texts
+}
+
+// buildCommunities detects the communities of the graph and summarizes each, reusing
+// summaries of communities with the same members and relations from earlier requests.
+// Summaries stop when the token budget runs out; a failed summary is left empty with
+// a warning, since the members still route queries.
+func (p *AgenticRAGProcessor) buildCommunities(ctx context.Context, kg *KnowledgeGraph) []Community {
+	communities, texts := kg.detectCommunities(p.config.KnowledgeGraph.MinConfidenceThreshold)
+	state := requestStateFrom(ctx)
+	for i := range communities {
+		key := contentHash(texts[i])
+		if summary, ok := p.communities.get(key); ok {
+			communities[i].Summary = summary
+			continue
+		}
+		prompt := fmt.Sprintf(`Summarize this community of related entities from a knowledge graph in 2-3 sentences: what it is about,
+its central entities and how they relate. Mention the entities by name.
+
+Entities:
+%s
+Respond with the summary only.`, texts[i])
+		response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+			Temperature:     0,
+			MaxOutputTokens: communitySummaryTokens,
+		})
+		if errors.Is(err, ErrBudgetExceeded) {
+			break
+		}
+		if err != nil {
+			state.recordWarning(fmt.Sprintf("failed to summarize community %s: %v", communities[i].ID, err))
+			continue
+		}
+		communities[i].Summary = strings.TrimSpace(response.Text())
+		p.communities.put(key, communities[i].Summary)
+	}
+	return communities
+}
+
+// routeCommunities narrows the chunks to those mentioning members of the communities
+// whose summaries and members best match the query, a coarse index over the graph of
+// the conversation or the graph store. Returns the chunks unchanged, and no
+// communities, when there's no graph or nothing matches.
+func (p *AgenticRAGProcessor) routeCommunities(ctx context.Context, query string, options AgenticRAGOptions, session *Session, chunks []DocumentChunk) ([]DocumentChunk, []Community, error) {
+	var graph *KnowledgeGraph
+	if session != nil && session.KnowledgeGraph != nil {
+		graph = session.KnowledgeGraph
+	} else if store := p.config.KnowledgeGraph.Store; store != nil {
+		var text strings.Builder
+		for _, chunk := range chunks {
+			text.WriteString(chunk.Content)
+			text.WriteString("\n")
+		}
+		stored, err := store.Query(ctx, text.String())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query graph store: %w", err)
+		}
+		graph = stored
+	}
+	if graph == nil {
+		return chunks, nil, nil
+	}
+	communities := graph.Communities
+	if len(communities) == 0 {
+		communities = p.buildCommunities(ctx, graph)
+	}
+
+	limit := options.MaxCommunities
+	if limit <= 0 {
+		limit = defaultRoutedCommunities
+	}
+	scores := make([]float64, len(communities))
+	for i, community := range communities {
+		scores[i] = p.calculateRelevanceScore(query, community.Summary+"\n"+strings.Join(community.Entities, "\n"))
+	}
+	ranked := make([]int, len(communities))
+	for i := range ranked {
+		ranked[i] = i
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return scores[ranked[i]] > scores[ranked[j]] })
+
+	var routed []Community
+	var names []string
+	for _, i := range ranked {
+		if len(routed) >= limit || scores[i] == 0 {
+			break
+		}
+		routed = append(routed, communities[i])
+		for _, name := range communities[i].Entities {
+			if name != "" {
+				names = append(names, strings.ToLower(name))
+			}
+		}
+	}
+
+	var narrowed []DocumentChunk
+	for _, chunk := range chunks {
+		content := strings.ToLower(chunk.Content)
+		for _, name := range names {
+			if strings.Contains(content, name) {
+				narrowed = append(narrowed, chunk)
+				break
+			}
+		}
+	}
+	if len(narrowed) == 0 {
+		return chunks, nil, nil
+	}
+	return narrowed, routed, nil
+}
+
+// communityCache keeps community summaries across requests, keyed by the hash of the
+// community's members and relations. It's cleared when full.
+type communityCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+// get returns the cached summary of a community
+func (c *communityCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	summary, ok := c.entries[key]
+	return summary, ok
+}
+
+// put caches the summary of a community
+func (c *communityCache) put(key, summary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil || len(c.entries) >= communityCacheSize {
+		c.entries = make(map[string]string)
+	}
+	c.entries[key] = summary
+}
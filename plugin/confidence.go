@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// maxClarifyingQuestions caps the questions suggested with a refusal
+const maxClarifyingQuestions = 3
+
+// answerAssessment is the model's judgement of how well the passages support an answer
+type answerAssessment struct {
+	Confidence          float64  `json:"confidence"`
+	ClarifyingQuestions []string `json:"clarifying_questions"`
+}
+
+// assessAnswer asks the model how confidently the chunks support the answer, and what
+// to ask the user if they don't
+func (p *AgenticRAGProcessor) assessAnswer(ctx context.Context, query, answer string, chunks []DocumentChunk) (*answerAssessment, error) {
+	if err := p.initializePrompts(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	passages := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		passages[i] = chunk.Content
+	}
+
+	assessmentPrompt := p.lookupPrompt(ctx, "answer_assessment", p.config.Prompts.AnswerAssessmentPrompt)
+	if assessmentPrompt == nil {
+		return p.assessAnswerFallback(ctx, query, answer, passages)
+	}
+
+	response, err := p.executePrompt(ctx, assessmentPrompt, map[string]any{
+		"query":    query,
+		"answer":   answer,
+		"passages": passages,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess answer: %w", err)
+	}
+
+	var assessment answerAssessment
+	if err := response.Output(&assessment); err != nil {
+		return nil, fmt.Errorf("failed to parse answer assessment: %w", err)
+	}
+	return &assessment, nil
+}
+
+// assessAnswerFallback assesses the answer with a hardcoded prompt when the dotprompt
+// isn't available
+func (p *AgenticRAGProcessor) assessAnswerFallback(ctx context.Context, query, answer string, passages []string) (*answerAssessment, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "An answer was written to the query %q using only the passages below. Judge how well the passages actually support it.\n\n", query)
+	fmt.Fprintf(&prompt, "Answer:\n%s\n\n", answer)
+	for i, passage := range passages {
+		fmt.Fprintf(&prompt, "Passage %d:\n%s\n\n", i, passage)
+	}
+	prompt.WriteString(`Respond with JSON in this exact format:
+{
+  "confidence": 0.3,
+  "clarifying_questions": ["Which product version do you mean?"]
+}
+confidence (0.0-1.0) is how confident you are that the answer is correct and fully supported by the passages, near 0.0 if they don't address the query.
+clarifying_questions are up to three short questions to ask the user that would help find a better answer, empty if the answer is well supported.`)
+
+	response, err := p.generate(ctx, prompt.String(), &ai.GenerationCommonConfig{
+		Temperature:     0.1,
+		MaxOutputTokens: 600,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess answer: %w", err)
+	}
+
+	text := response.Text()
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	var assessment answerAssessment
+	if err := json.Unmarshal([]byte(text), &assessment); err != nil {
+		return nil, fmt.Errorf("failed to parse answer assessment: %w", err)
+	}
+	return &assessment, nil
+}
+
+// answerConfidence combines the signals available for an answer into a 0-1 score: the
+// relevance of the chunks it was built from (the mean of their best and average
+// score), the model's self-assessment if any and the fact verification outcome if
+// any, weighted equally
+func answerConfidence(chunks []DocumentChunk, assessment *answerAssessment, verification *FactVerification) float64 {
+	var best, total float64
+	for _, chunk := range chunks {
+		best = max(best, chunk.RelevanceScore)
+		total += chunk.RelevanceScore
+	}
+	retrieval := 0.0
+	if len(chunks) > 0 {
+		retrieval = (best + total/float64(len(chunks))) / 2
+	}
+
+	signals := []float64{retrieval}
+	if assessment != nil {
+		signals = append(signals, assessment.Confidence)
+	}
+	if verification != nil && len(verification.Claims) > 0 {
+		var verified float64
+		for _, claim := range verification.Claims {
+			switch claim.Verdict {
+			case VerdictSupported:
+				verified++
+			case VerdictPartiallySupported:
+				verified += 0.5
+			}
+		}
+		signals = append(signals, verified/float64(len(verification.Claims)))
+	}
+
+	var sum float64
+	for _, signal := range signals {
+		sum += max(0, min(1, signal))
+	}
+	return sum / float64(len(signals))
+}
+
+// newRefusal explains why an answer with the given confidence was withheld
+func newRefusal(confidence, threshold float64, chunks []DocumentChunk, assessment *answerAssessment) *Refusal {
+	reason := fmt.Sprintf("answer confidence %.2f is below the minimum of %.2f", confidence, threshold)
+	if len(chunks) == 0 {
+		reason = "no passage in the documents is relevant to the query"
+	}
+	refusal := &Refusal{Reason: reason}
+	if assessment != nil {
+		for _, question := range assessment.ClarifyingQuestions {
+			if question = strings.TrimSpace(question); question != "" && len(refusal.ClarifyingQuestions) < maxClarifyingQuestions {
+				refusal.ClarifyingQuestions = append(refusal.ClarifyingQuestions, question)
+			}
+		}
+	}
+	return refusal
+}
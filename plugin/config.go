@@ -0,0 +1,477 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// ValidationError describes a single invalid field
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// fieldError creates a ValidationError for the given field
+func fieldError(field, format string, args ...any) error {
+	return &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// ParseConfig decodes a generic configuration map (e.g. loaded from JSON or YAML)
+// on top of DefaultConfig, keyed by the json field names. Durations are given as
+// strings such as "30s" or "1m30s", or as integer nanoseconds. Unknown keys,
+// wrong-typed values and failed validation rules are all collected and returned
+// together as a single joined error.
+func ParseConfig(raw map[string]any) (*AgenticRAGConfig, error) {
+	config := DefaultConfig()
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:     "json",
+		ErrorUnused: true,
+		DecodeHook:  mapstructure.StringToTimeDurationHookFunc(),
+		Result:      config,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create configuration decoder: %w", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return nil, errors.Join(decodeErrors(err)...)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// decodeErrors flattens the wrapped and joined errors of a mapstructure decode into
+// ValidationErrors naming their field, with one error per unknown key
+func decodeErrors(err error) []error {
+	decodeErr, ok := err.(*mapstructure.DecodeError)
+	if !ok {
+		switch wrapped := err.(type) {
+		case interface{ Unwrap() []error }:
+			var errs []error
+			for _, e := range wrapped.Unwrap() {
+				errs = append(errs, decodeErrors(e)...)
+			}
+			return errs
+		case interface{ Unwrap() error }:
+			return decodeErrors(wrapped.Unwrap())
+		}
+		return []error{err}
+	}
+
+	cause := decodeErr.Unwrap()
+	if keys, ok := strings.CutPrefix(cause.Error(), "has invalid keys: "); ok {
+		var errs []error
+		for _, key := range strings.Split(keys, ", ") {
+			field := key
+			if decodeErr.Name() != "" {
+				field = decodeErr.Name() + "." + key
+			}
+			errs = append(errs, fieldError(field, "unknown configuration key"))
+		}
+		return errs
+	}
+	return []error{fieldError(decodeErr.Name(), "%v", cause)}
+}
+
+// Validate checks the configuration and returns every problem found as a single joined error
+func (c *AgenticRAGConfig) Validate() error {
+	var errs []error
+
+	if c.Model == nil && c.ModelName == "" {
+		errs = append(errs, fieldError("model_name", "is required when no model instance is configured"))
+	}
+	for i, name := range c.FallbackModels {
+		if name == "" {
+			errs = append(errs, fieldError(fmt.Sprintf("fallback_models[%d]", i), "must not be empty"))
+		}
+	}
+
+	// Load balancing
+	labels := make(map[string]bool)
+	for i, instance := range c.LoadBalancing.Instances {
+		field := fmt.Sprintf("load_balancing.instances[%d]", i)
+		if instance.Label == "" {
+			errs = append(errs, fieldError(field+".label", "is required"))
+		} else if labels[instance.Label] {
+			errs = append(errs, fieldError(field+".label", "duplicate label %q", instance.Label))
+		}
+		labels[instance.Label] = true
+		if instance.Model == nil && instance.ModelName == "" {
+			errs = append(errs, fieldError(field+".model_name", "is required when no model instance is configured"))
+		}
+		if instance.Weight < 0 {
+			errs = append(errs, fieldError(field+".weight", "must not be negative, got %d", instance.Weight))
+		}
+	}
+	switch c.LoadBalancing.Strategy {
+	case "", LoadBalanceWeightedRoundRobin, LoadBalanceLeastInflight:
+	default:
+		errs = append(errs, fieldError("load_balancing.strategy", "must be %q or %q, got %q", LoadBalanceWeightedRoundRobin, LoadBalanceLeastInflight, c.LoadBalancing.Strategy))
+	}
+	if c.LoadBalancing.UnhealthyCooldown < 0 {
+		errs = append(errs, fieldError("load_balancing.unhealthy_cooldown", "must not be negative, got %v", c.LoadBalancing.UnhealthyCooldown))
+	}
+
+	// Context escalation
+	if c.ContextEscalation.MaxEscalations < 0 {
+		errs = append(errs, fieldError("context_escalation.max_escalations", "must not be negative, got %d", c.ContextEscalation.MaxEscalations))
+	}
+	for i, name := range c.ContextEscalation.Models {
+		if name == "" {
+			errs = append(errs, fieldError(fmt.Sprintf("context_escalation.models[%d]", i), "must not be empty"))
+		}
+	}
+	for _, name := range sortedKeys(c.ContextEscalation.ContextWindows) {
+		if c.ContextEscalation.ContextWindows[name] <= 0 {
+			errs = append(errs, fieldError("context_escalation.context_windows."+name, "must be greater than 0, got %d", c.ContextEscalation.ContextWindows[name]))
+		}
+	}
+
+	// Truncation
+	switch c.Truncation.Strategy {
+	case "", TruncationNone, TruncationDropLowestRelevance, TruncationHeadTail, TruncationSummary:
+	default:
+		errs = append(errs, fieldError("truncation.strategy", "must be one of %q, %q, %q or %q, got %q",
+			TruncationNone, TruncationDropLowestRelevance, TruncationHeadTail, TruncationSummary, c.Truncation.Strategy))
+	}
+	if c.Truncation.MaxContextTokens < 0 {
+		errs = append(errs, fieldError("truncation.max_context_tokens", "must not be negative, got %d", c.Truncation.MaxContextTokens))
+	}
+	if c.Truncation.OutputReserve < 0 {
+		errs = append(errs, fieldError("truncation.output_reserve", "must not be negative, got %d", c.Truncation.OutputReserve))
+	}
+
+	// Downgrade
+	for _, from := range sortedKeys(c.Downgrade.Models) {
+		to := c.Downgrade.Models[from]
+		switch {
+		case from == "":
+			errs = append(errs, fieldError("downgrade.models", "model names must not be empty"))
+		case to == "":
+			errs = append(errs, fieldError("downgrade.models."+from, "must not be empty"))
+		case to == from:
+			errs = append(errs, fieldError("downgrade.models."+from, "must not downgrade to itself"))
+		case downgradeLoops(c.Downgrade.Models, from):
+			errs = append(errs, fieldError("downgrade.models."+from, "must not lead back to %q through other downgrades", from))
+		}
+	}
+	if c.Downgrade.Threshold < 0 {
+		errs = append(errs, fieldError("downgrade.threshold", "must not be negative, got %d", c.Downgrade.Threshold))
+	}
+	if c.Downgrade.Cooldown < 0 {
+		errs = append(errs, fieldError("downgrade.cooldown", "must not be negative, got %v", c.Downgrade.Cooldown))
+	}
+
+	// URL loader
+	if c.URLLoader.Timeout < 0 {
+		errs = append(errs, fieldError("url_loader.timeout", "must not be negative, got %v", c.URLLoader.Timeout))
+	}
+	if c.URLLoader.MaxBytes < 0 {
+		errs = append(errs, fieldError("url_loader.max_bytes", "must not be negative, got %d", c.URLLoader.MaxBytes))
+	}
+	if c.URLLoader.CacheTTL < 0 {
+		errs = append(errs, fieldError("url_loader.cache_ttl", "must not be negative, got %v", c.URLLoader.CacheTTL))
+	}
+
+	// Termination
+	termination := c.Processing.Termination
+	if termination.MinInformationGain < 0 || termination.MinInformationGain > 1 {
+		errs = append(errs, fieldError("processing.termination.min_information_gain", "must be between 0 and 1, got %v", termination.MinInformationGain))
+	}
+	if termination.TargetConfidence < 0 || termination.TargetConfidence > 1 {
+		errs = append(errs, fieldError("processing.termination.target_confidence", "must be between 0 and 1, got %v", termination.TargetConfidence))
+	}
+
+	// Sessions
+	if c.Sessions.TTL < 0 {
+		errs = append(errs, fieldError("sessions.ttl", "must not be negative, got %v", c.Sessions.TTL))
+	}
+	if c.Sessions.MaxTurns < 0 {
+		errs = append(errs, fieldError("sessions.max_turns", "must not be negative, got %d", c.Sessions.MaxTurns))
+	}
+
+	// Batch
+	if c.Batch.Concurrency < 0 {
+		errs = append(errs, fieldError("batch.concurrency", "must not be negative, got %d", c.Batch.Concurrency))
+	}
+
+	// Cache
+	if c.Cache.TTL < 0 {
+		errs = append(errs, fieldError("cache.ttl", "must not be negative, got %v", c.Cache.TTL))
+	}
+	if c.Cache.MaxEntries < 0 {
+		errs = append(errs, fieldError("cache.max_entries", "must not be negative, got %d", c.Cache.MaxEntries))
+	}
+
+	// Debug
+	if c.Debug.MaxCaptureBytes < 0 {
+		errs = append(errs, fieldError("debug.max_capture_bytes", "must not be negative, got %d", c.Debug.MaxCaptureBytes))
+	}
+
+	// Audit
+	if c.Audit.Redaction.PreviewBytes < 0 {
+		errs = append(errs, fieldError("audit.redaction.preview_bytes", "must not be negative, got %d", c.Audit.Redaction.PreviewBytes))
+	}
+	for i, pattern := range c.Audit.Redaction.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fieldError(fmt.Sprintf("audit.redaction.patterns[%d]", i), "is not a valid regular expression: %v", err))
+		}
+	}
+
+	// Jobs
+	if c.Jobs.Workers < 0 {
+		errs = append(errs, fieldError("jobs.workers", "must not be negative, got %d", c.Jobs.Workers))
+	}
+	if c.Jobs.QueueSize < 0 {
+		errs = append(errs, fieldError("jobs.queue_size", "must not be negative, got %d", c.Jobs.QueueSize))
+	}
+	if c.Jobs.ResultTTL < 0 {
+		errs = append(errs, fieldError("jobs.result_ttl", "must not be negative, got %v", c.Jobs.ResultTTL))
+	}
+
+	// PDF
+	if c.PDF.MaxBytes < 0 {
+		errs = append(errs, fieldError("pdf.max_bytes", "must not be negative, got %d", c.PDF.MaxBytes))
+	}
+
+	// Processing
+	if c.Processing.DefaultChunkSize <= 0 {
+		errs = append(errs, fieldError("processing.default_chunk_size", "must be greater than 0, got %d", c.Processing.DefaultChunkSize))
+	}
+	if c.Processing.DefaultMaxChunks <= 0 {
+		errs = append(errs, fieldError("processing.default_max_chunks", "must be greater than 0, got %d", c.Processing.DefaultMaxChunks))
+	}
+	if c.Processing.DefaultRecursiveDepth < 0 {
+		errs = append(errs, fieldError("processing.default_recursive_depth", "must not be negative, got %d", c.Processing.DefaultRecursiveDepth))
+	}
+	if c.Processing.MaxRecursiveDepth < 0 {
+		errs = append(errs, fieldError("processing.max_recursive_depth", "must not be negative (0 for no limit), got %d", c.Processing.MaxRecursiveDepth))
+	} else if c.Processing.MaxRecursiveDepth > 0 && c.Processing.DefaultRecursiveDepth > c.Processing.MaxRecursiveDepth {
+		errs = append(errs, fieldError("processing.max_recursive_depth", "must not be smaller than default_recursive_depth (%d), got %d", c.Processing.DefaultRecursiveDepth, c.Processing.MaxRecursiveDepth))
+	}
+	switch c.Processing.ChunkStrategy {
+	case "", ChunkStrategySentence, ChunkStrategyMarkdown, ChunkStrategySemantic:
+	default:
+		errs = append(errs, fieldError("processing.chunk_strategy", "must be one of %q, %q or %q, got %q",
+			ChunkStrategySentence, ChunkStrategyMarkdown, ChunkStrategySemantic, c.Processing.ChunkStrategy))
+	}
+	semantic := c.Processing.Semantic
+	if semantic.BreakpointPercentile < 0 || semantic.BreakpointPercentile > 100 {
+		errs = append(errs, fieldError("processing.semantic.breakpoint_percentile", "must be between 0 and 100, got %v", semantic.BreakpointPercentile))
+	}
+	if semantic.MinChunkSize < 0 {
+		errs = append(errs, fieldError("processing.semantic.min_chunk_size", "must not be negative, got %d", semantic.MinChunkSize))
+	}
+	if semantic.MaxChunkSize < 0 {
+		errs = append(errs, fieldError("processing.semantic.max_chunk_size", "must not be negative, got %d", semantic.MaxChunkSize))
+	}
+	if semantic.MaxChunkSize > 0 && semantic.MinChunkSize > semantic.MaxChunkSize {
+		errs = append(errs, fieldError("processing.semantic.min_chunk_size", "must not exceed max_chunk_size (%d), got %d", semantic.MaxChunkSize, semantic.MinChunkSize))
+	}
+	switch c.Processing.RelevanceMode {
+	case "", RelevanceModeLLM, RelevanceModeEmbedding, RelevanceModeHybrid:
+	default:
+		errs = append(errs, fieldError("processing.relevance_mode", "must be one of %q, %q or %q, got %q",
+			RelevanceModeLLM, RelevanceModeEmbedding, RelevanceModeHybrid, c.Processing.RelevanceMode))
+	}
+	if c.Processing.RerankTopN < 0 {
+		errs = append(errs, fieldError("processing.rerank_top_n", "must not be negative, got %d", c.Processing.RerankTopN))
+	}
+	if c.Processing.HybridTopK < 0 {
+		errs = append(errs, fieldError("processing.hybrid_top_k", "must not be negative, got %d", c.Processing.HybridTopK))
+	}
+	if c.Processing.DiversityLambda < 0 || c.Processing.DiversityLambda > 1 {
+		errs = append(errs, fieldError("processing.diversity_lambda", "must be between 0 and 1, got %v", c.Processing.DiversityLambda))
+	}
+	if (c.Retriever != nil || c.Indexer != nil) && c.Processing.RetrievalTopK <= 0 {
+		errs = append(errs, fieldError("processing.retrieval_top_k", "must be greater than 0 with a retriever, got %d", c.Processing.RetrievalTopK))
+	}
+	if c.Embedding.CacheSize < 0 {
+		errs = append(errs, fieldError("embedding.cache_size", "must not be negative, got %d", c.Embedding.CacheSize))
+	}
+	if c.Embedding.BatchSize < 0 {
+		errs = append(errs, fieldError("embedding.batch_size", "must not be negative, got %d", c.Embedding.BatchSize))
+	}
+	switch c.Processing.ChunkUnit {
+	case "", ChunkUnitChars, ChunkUnitTokens:
+	default:
+		errs = append(errs, fieldError("processing.chunk_unit", "must be %q or %q, got %q",
+			ChunkUnitChars, ChunkUnitTokens, c.Processing.ChunkUnit))
+	}
+	if c.Processing.Concurrency < 0 {
+		errs = append(errs, fieldError("processing.concurrency", "must not be negative, got %d", c.Processing.Concurrency))
+	}
+	if c.Processing.MaxChunkFailureRate < 0 || c.Processing.MaxChunkFailureRate > 1 {
+		errs = append(errs, fieldError("processing.max_chunk_failure_rate", "must be between 0 and 1, got %v", c.Processing.MaxChunkFailureRate))
+	}
+
+	// Knowledge graph
+	if c.KnowledgeGraph.Enabled {
+		if len(c.KnowledgeGraph.EntityTypes) == 0 {
+			errs = append(errs, fieldError("knowledge_graph.entity_types", "must not be empty when the knowledge graph is enabled"))
+		}
+		if len(c.KnowledgeGraph.RelationTypes) == 0 {
+			errs = append(errs, fieldError("knowledge_graph.relation_types", "must not be empty when the knowledge graph is enabled"))
+		}
+	}
+	if !inUnitRange(c.KnowledgeGraph.MinConfidenceThreshold) {
+		errs = append(errs, fieldError("knowledge_graph.min_confidence_threshold", "must be between 0 and 1, got %v", c.KnowledgeGraph.MinConfidenceThreshold))
+	}
+	if !inUnitRange(c.KnowledgeGraph.MergeSimilarity) {
+		errs = append(errs, fieldError("knowledge_graph.merge_similarity", "must be between 0 and 1, got %v", c.KnowledgeGraph.MergeSimilarity))
+	}
+	if c.KnowledgeGraph.MaxEntities < 0 {
+		errs = append(errs, fieldError("knowledge_graph.max_entities", "must not be negative (0 for no limit), got %d", c.KnowledgeGraph.MaxEntities))
+	}
+	if c.KnowledgeGraph.MaxRelations < 0 {
+		errs = append(errs, fieldError("knowledge_graph.max_relations", "must not be negative (0 for no limit), got %d", c.KnowledgeGraph.MaxRelations))
+	}
+	switch c.KnowledgeGraph.UnknownEntityTypes {
+	case "", UnknownEntityTypesKeep, UnknownEntityTypesAncestor, UnknownEntityTypesOther:
+	default:
+		errs = append(errs, fieldError("knowledge_graph.unknown_entity_types", "must be one of %q, %q or %q, got %q",
+			UnknownEntityTypesKeep, UnknownEntityTypesAncestor, UnknownEntityTypesOther, c.KnowledgeGraph.UnknownEntityTypes))
+	}
+	ontology := newEntityOntology(c.KnowledgeGraph.TypeParents)
+	for _, child := range sortedKeys(ontology) {
+		if containsFold(ontology.ancestors(child), child) {
+			errs = append(errs, fieldError("knowledge_graph.type_parents", "must not be cyclic, %s is its own ancestor", child))
+		}
+	}
+	switch c.KnowledgeGraph.DanglingRelations {
+	case "", DanglingRelationsCreate, DanglingRelationsDrop:
+	default:
+		errs = append(errs, fieldError("knowledge_graph.dangling_relations", "must be %q or %q, got %q",
+			DanglingRelationsCreate, DanglingRelationsDrop, c.KnowledgeGraph.DanglingRelations))
+	}
+
+	// Fact verification
+	errs = append(errs, validateFactVerification("fact_verification.", c.FactVerification)...)
+
+	// Prompts
+	prompts := map[string]string{
+		"prompts.relevance_scoring_prompt":    c.Prompts.RelevanceScoringPrompt,
+		"prompts.response_generation_prompt":  c.Prompts.ResponseGenerationPrompt,
+		"prompts.knowledge_extraction_prompt": c.Prompts.KnowledgeExtractionPrompt,
+		"prompts.fact_verification_prompt":    c.Prompts.FactVerificationPrompt,
+	}
+	for _, field := range sortedKeys(prompts) {
+		if prompts[field] == "" {
+			errs = append(errs, fieldError(field, "is required"))
+		}
+	}
+
+	promptNames := c.Prompts.promptNames()
+	for _, key := range sortedKeys(c.Prompts.Experiments) {
+		field := "prompts.experiments." + key
+		if _, known := promptNames[key]; !known {
+			errs = append(errs, fieldError(field, "unknown prompt, must be one of %s", strings.Join(sortedKeys(promptNames), ", ")))
+			continue
+		}
+		errs = append(errs, validateExperiment(field, c.Prompts.Experiments[key])...)
+	}
+	for _, name := range sortedKeys(c.Prompts.Helpers) {
+		if err := validatePromptHelper(name, c.Prompts.Helpers[name]); err != nil {
+			errs = append(errs, fieldError("prompts.helpers."+name, "%v", err))
+		}
+	}
+	if c.Prompts.RequireDirectory && c.Prompts.Directory == "" {
+		errs = append(errs, fieldError("prompts.require_directory", "requires prompts.directory"))
+	}
+	if c.Prompts.WatchDebounce < 0 {
+		errs = append(errs, fieldError("prompts.watch_debounce", "must not be negative, got %v", c.Prompts.WatchDebounce))
+	}
+
+	// Retry
+	if c.Retry.MaxAttempts < 0 {
+		errs = append(errs, fieldError("retry.max_attempts", "must not be negative, got %d", c.Retry.MaxAttempts))
+	}
+	if c.Retry.InitialBackoff < 0 {
+		errs = append(errs, fieldError("retry.initial_backoff", "must not be negative, got %v", c.Retry.InitialBackoff))
+	}
+	if c.Retry.MaxBackoff < 0 {
+		errs = append(errs, fieldError("retry.max_backoff", "must not be negative, got %v", c.Retry.MaxBackoff))
+	} else if c.Retry.MaxBackoff > 0 && c.Retry.MaxBackoff < c.Retry.InitialBackoff {
+		errs = append(errs, fieldError("retry.max_backoff", "must not be smaller than initial_backoff (%v), got %v", c.Retry.InitialBackoff, c.Retry.MaxBackoff))
+	}
+	if c.Retry.Multiplier != 0 && c.Retry.Multiplier < 1 {
+		errs = append(errs, fieldError("retry.multiplier", "must be at least 1 (or 0 for constant backoff), got %v", c.Retry.Multiplier))
+	}
+	if c.Retry.MinAttemptDuration < 0 {
+		errs = append(errs, fieldError("retry.min_attempt_duration", "must not be negative, got %v", c.Retry.MinAttemptDuration))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateFactVerification checks fact verification settings, naming fields with the
+// prefix
+func validateFactVerification(prefix string, c FactVerificationConfig) []error {
+	var errs []error
+	if !inUnitRange(c.MinConfidenceScore) {
+		errs = append(errs, fieldError(prefix+"min_confidence_score", "must be between 0 and 1, got %v", c.MinConfidenceScore))
+	}
+	if !inUnitRange(c.PartialSupportMinConfidence) {
+		errs = append(errs, fieldError(prefix+"partial_support_min_confidence", "must be between 0 and 1, got %v", c.PartialSupportMinConfidence))
+	} else if c.PartialSupportMinConfidence > c.MinConfidenceScore {
+		errs = append(errs, fieldError(prefix+"partial_support_min_confidence", "must not exceed min_confidence_score %v, got %v", c.MinConfidenceScore, c.PartialSupportMinConfidence))
+	}
+	if !inUnitRange(c.NumericTolerance) {
+		errs = append(errs, fieldError(prefix+"numeric_tolerance", "must be between 0 and 1, got %v", c.NumericTolerance))
+	}
+	if c.SelfConsistency && c.SelfConsistencySamples < 1 {
+		errs = append(errs, fieldError(prefix+"self_consistency_samples", "must be at least 1 with self_consistency, got %d", c.SelfConsistencySamples))
+	}
+	if !inUnitRange(c.SelfConsistencyBand) {
+		errs = append(errs, fieldError(prefix+"self_consistency_band", "must be between 0 and 1, got %v", c.SelfConsistencyBand))
+	}
+	if c.Cache.TTL < 0 {
+		errs = append(errs, fieldError(prefix+"cache.ttl", "must not be negative, got %v", c.Cache.TTL))
+	}
+	if c.Cache.MaxEntries < 0 {
+		errs = append(errs, fieldError(prefix+"cache.max_entries", "must not be negative, got %d", c.Cache.MaxEntries))
+	}
+	if c.VerificationProvider != "" && c.VerificationModel == "" {
+		errs = append(errs, fieldError(prefix+"verification_provider", "requires verification_model"))
+	}
+	switch c.UnsupportedClaims {
+	case "", UnsupportedClaimPolicyKeep:
+	case UnsupportedClaimPolicyFlag, UnsupportedClaimPolicyRemove, UnsupportedClaimPolicyFail:
+		if !c.RequireEvidence {
+			errs = append(errs, fieldError(prefix+"unsupported_claims", "%q requires require_evidence", c.UnsupportedClaims))
+		}
+	default:
+		errs = append(errs, fieldError(prefix+"unsupported_claims", "must be %q, %q, %q or %q, got %q",
+			UnsupportedClaimPolicyKeep, UnsupportedClaimPolicyFlag, UnsupportedClaimPolicyRemove, UnsupportedClaimPolicyFail, c.UnsupportedClaims))
+	}
+	return errs
+}
+
+// inUnitRange reports whether v lies within [0, 1]
+func inUnitRange(v float64) bool {
+	return v >= 0 && v <= 1
+}
+
+// sortedKeys returns the keys of m in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
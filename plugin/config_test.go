@@ -0,0 +1,79 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfigDurations(t *testing.T) {
+	config, err := ParseConfig(map[string]any{
+		"model_name": "googleai/gemini-2.5-flash",
+		"retry": map[string]any{
+			"initial_backoff": "500ms",
+			"max_backoff":     "1m30s",
+			// Integer nanoseconds are still accepted
+			"min_attempt_duration": float64(2 * time.Second),
+		},
+		"cache": map[string]any{"ttl": "30s"},
+	})
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if got := config.Retry.InitialBackoff; got != 500*time.Millisecond {
+		t.Errorf("retry.initial_backoff = %v, want 500ms", got)
+	}
+	if got := config.Retry.MaxBackoff; got != 90*time.Second {
+		t.Errorf("retry.max_backoff = %v, want 1m30s", got)
+	}
+	if got := config.Retry.MinAttemptDuration; got != 2*time.Second {
+		t.Errorf("retry.min_attempt_duration = %v, want 2s", got)
+	}
+	if got := config.Cache.TTL; got != 30*time.Second {
+		t.Errorf("cache.ttl = %v, want 30s", got)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	_, err := ParseConfig(map[string]any{
+		"model_name": 42,
+		"retry":      map[string]any{"initial_backoff": "soon", "max_backoff": true, "jitter": 0.1},
+		"cache":      map[string]any{"max_entries": "many"},
+		"unknown":    "value",
+	})
+	if err == nil {
+		t.Fatal("ParseConfig accepted an invalid configuration")
+	}
+	// Every problem is reported at once, each naming its field
+	want := map[string]string{
+		"model_name":            "expected type 'string'",
+		"retry.initial_backoff": "invalid duration",
+		"retry.max_backoff":     "expected type 'time.Duration'",
+		"retry.jitter":          "unknown configuration key",
+		"cache.max_entries":     "expected type 'int'",
+		"unknown":               "unknown configuration key",
+	}
+	found := make(map[string]bool)
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var validation *ValidationError
+		if !errors.As(e, &validation) {
+			t.Errorf("unexpected error %v", e)
+			continue
+		}
+		message, ok := want[validation.Field]
+		if !ok {
+			t.Errorf("unexpected error for %s: %s", validation.Field, validation.Message)
+			continue
+		}
+		if !strings.Contains(validation.Message, message) {
+			t.Errorf("%s: message %q doesn't contain %q", validation.Field, validation.Message, message)
+		}
+		found[validation.Field] = true
+	}
+	for field := range want {
+		if !found[field] {
+			t.Errorf("no error reported for %s", field)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// CorpusName is the name of the corpus retriever, registered under PluginID, and of
+// the corpus ingestion flow. Genkit has no indexer actions, so the flow stands in for
+// one.
+const CorpusName = "corpus"
+
+// CorpusRetrieverOptions are the options of the corpus retriever, passed with
+// ai.WithConfig or as JSON from the developer UI
+type CorpusRetrieverOptions struct {
+	K      int             `json:"k,omitempty" jsonschema_description:"Maximum number of chunks to return (default: processing.retrieval_top_k)"`
+	Filter *DocumentFilter `json:"filter,omitempty" jsonschema_description:"Only return chunks whose document metadata matches the filter"`
+}
+
+// CorpusIngestRequest is the input of the corpus ingestion flow
+type CorpusIngestRequest struct {
+	Documents []Document `json:"documents" jsonschema_description:"Documents to ingest, replacing earlier versions with the same ID"`
+}
+
+// CorpusIngestResponse is the output of the corpus ingestion flow
+type CorpusIngestResponse struct {
+	Reports []IngestReport `json:"reports"`
+}
+
+// registerCorpus registers the corpus retriever when a retriever or indexer is
+// configured, and the ingestion flow when an indexer is and flows are enabled, so other
+// flows and the developer UI can use the ingested corpus. Names taken by another registration are
+// an error rather than genkit's panic.
+func (p *AgenticRAGPlugin) registerCorpus(g *genkit.Genkit) error {
+	name := PluginID + "/" + CorpusName
+	if p.processor.retriever() != nil {
+		if genkit.LookupRetriever(g, PluginID, CorpusName) != nil {
+			return fmt.Errorf("retriever %q is already registered; initialize the plugin once per genkit instance", name)
+		}
+		genkit.DefineRetriever(g, PluginID, CorpusName, p.processor.retrieveForGenkit)
+	}
+
+	if p.config.Indexer != nil && !p.config.Flows.Disabled {
+		for _, flow := range genkit.ListFlows(g) {
+			if flow.Name() == name {
+				return fmt.Errorf("flow %q is already registered; initialize the plugin once per genkit instance", name)
+			}
+		}
+		genkit.DefineFlow(g, name, func(ctx context.Context, input CorpusIngestRequest) (*CorpusIngestResponse, error) {
+			reports, err := p.processor.Ingest(ctx, input.Documents)
+			if err != nil {
+				return nil, err
+			}
+			return &CorpusIngestResponse{Reports: reports}, nil
+		})
+	}
+	return nil
+}
+
+// retrieveForGenkit serves the corpus retriever: each returned document is a chunk,
+// with the metadata of its document and the keys NewGenkitRetriever reads
+func (p *AgenticRAGProcessor) retrieveForGenkit(ctx context.Context, request *ai.RetrieverRequest) (*ai.RetrieverResponse, error) {
+	if request.Query == nil {
+		return nil, errors.New("retriever request has no query")
+	}
+	var query strings.Builder
+	for _, part := range request.Query.Content {
+		if part.IsText() {
+			query.WriteString(part.Text)
+		}
+	}
+	options, err := corpusRetrieverOptions(request.Options)
+	if err != nil {
+		return nil, err
+	}
+	k := options.K
+	if k == 0 {
+		k = p.config.Processing.RetrievalTopK
+	}
+
+	retrieved, err := p.retriever().Retrieve(ctx, query.String(), k, options.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chunks: %w", err)
+	}
+	if len(retrieved) > k {
+		retrieved = retrieved[:k]
+	}
+	response := &ai.RetrieverResponse{Documents: make([]*ai.Document, len(retrieved))}
+	for i, scored := range retrieved {
+		metadata := maps.Clone(scored.Metadata)
+		if metadata == nil {
+			metadata = make(map[string]any, 4)
+		}
+		metadata[RetrievedDocumentIDKey] = scored.Chunk.DocumentID
+		metadata[RetrievedTitleKey] = scored.Title
+		metadata[RetrievedSourceKey] = scored.Source
+		metadata[RetrievedScoreKey] = scored.Score
+		response.Documents[i] = ai.DocumentFromText(scored.Chunk.Content, metadata)
+	}
+	return response, nil
+}
+
+// corpusRetrieverOptions reads the corpus retriever's options, given as
+// CorpusRetrieverOptions or their JSON form, and checks them
+func corpusRetrieverOptions(raw any) (CorpusRetrieverOptions, error) {
+	var options CorpusRetrieverOptions
+	switch raw := raw.(type) {
+	case nil:
+	case CorpusRetrieverOptions:
+		options = raw
+	case *CorpusRetrieverOptions:
+		if raw != nil {
+			options = *raw
+		}
+	default:
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return options, fmt.Errorf("invalid retriever options: %w", err)
+		}
+		if err := json.Unmarshal(data, &options); err != nil {
+			return options, fmt.Errorf("invalid retriever options: %w", err)
+		}
+	}
+
+	var errs []error
+	if options.K < 0 {
+		errs = append(errs, fieldError("k", "must not be negative (0 for the default), got %d", options.K))
+	}
+	if options.Filter != nil {
+		errs = append(errs, options.Filter.validate("filter")...)
+	}
+	if len(errs) > 0 {
+		return options, fmt.Errorf("invalid retriever options: %w", errors.Join(errs...))
+	}
+	return options, nil
+}
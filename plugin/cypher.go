@@ -0,0 +1,181 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// defaultCypherBatchSize is the number of statements per transaction of ExportCypher
+const defaultCypherBatchSize = 500
+
+// CypherExportOptions selects what ExportCypher writes
+type CypherExportOptions struct {
+	MinConfidence float64           // Leave out entities and relations below this confidence
+	EntityTypes   []string          // Only export entities of these types or their subtypes, case-insensitively (default: all)
+	TypeParents   map[string]string // Entity type hierarchy for EntityTypes, see KnowledgeGraphConfig.TypeParents
+	BatchSize     int               // Statements per transaction (default: 500)
+}
+
+// ExportCypher writes the graph as a cypher-shell script of MERGE statements, so it
+// can be loaded into Neo4j repeatedly without duplicating nodes. Entities become
+// Entity nodes labelled with their type and keyed on an ID derived from their name
+// and type, matching how a GraphStore identifies them. Relations become relationships
+// typed by their predicate. Both carry their confidence and the documents they were
+// found in, which for a relation are the documents of both its endpoints.
+func (kg *KnowledgeGraph) ExportCypher(w io.Writer, opts CypherExportOptions) error {
+	nodes, edges := kg.exportGraph(GraphExportOptions{MinConfidence: opts.MinConfidence, EntityTypes: opts.EntityTypes, TypeParents: opts.TypeParents})
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultCypherBatchSize
+	}
+
+	var out strings.Builder
+	out.WriteString("CREATE CONSTRAINT kg_entity_id IF NOT EXISTS FOR (n:Entity) REQUIRE n.id IS UNIQUE;\n")
+	statements := 0
+	write := func(statement string) {
+		if statements%batchSize == 0 {
+			if statements > 0 {
+				out.WriteString(":commit\n")
+			}
+			out.WriteString(":begin\n")
+		}
+		out.WriteString(statement)
+		out.WriteString(";\n")
+		statements++
+	}
+
+	ids := make(map[string]string, len(nodes))
+	documents := make(map[string][]string, len(nodes))
+	for _, node := range nodes {
+		id := stableEntityID(node.entity)
+		ids[node.id] = id
+		documents[node.id] = node.entity.DocumentIDs
+
+		labels := "Entity"
+		if label := cypherLabel(node.entity.Type); label != "" && label != "Entity" {
+			labels += ":" + label
+		}
+		statement := fmt.Sprintf("MERGE (n:%s {id: %s}) SET n.name = %s, n.type = %s",
+			labels, cypherString(id), cypherString(node.entity.Name), cypherString(node.entity.Type))
+		if !node.bare {
+			statement += fmt.Sprintf(", n.confidence = %s, n.document_ids = %s",
+				cypherFloat(node.entity.Confidence), cypherList(node.entity.DocumentIDs))
+			for _, key := range sortedKeys(node.entity.Attributes) {
+				statement += fmt.Sprintf(", n.`attr_%s` = %s", strings.ReplaceAll(key, "`", "``"), cypherString(node.entity.Attributes[key].Value))
+			}
+		}
+		write(statement)
+	}
+	for _, edge := range edges {
+		var shared []string
+		for _, id := range documents[edge.source] {
+			if slices.Contains(documents[edge.target], id) {
+				shared = append(shared, id)
+			}
+		}
+		write(fmt.Sprintf("MATCH (a:Entity {id: %s}), (b:Entity {id: %s}) MERGE (a)-[r:%s]->(b) SET r.id = %s, r.predicate = %s, r.confidence = %s, r.document_ids = %s",
+			cypherString(ids[edge.source]), cypherString(ids[edge.target]), cypherRelationType(edge.relation.Predicate),
+			cypherString(edgeID(edge, ids)), cypherString(edge.relation.Predicate), cypherFloat(edge.relation.Confidence), cypherList(shared)) +
+			cypherTemporal(edge.relation.Temporal))
+	}
+	if statements > 0 {
+		out.WriteString(":commit\n")
+	}
+
+	if _, err := io.WriteString(w, out.String()); err != nil {
+		return fmt.Errorf("failed to write Cypher: %w", err)
+	}
+	return nil
+}
+
+// cypherTemporal returns the SET clauses of a relation's temporal properties
+func cypherTemporal(temporal *TemporalQualifier) string {
+	var clauses strings.Builder
+	for _, property := range temporalProperties(temporal) {
+		fmt.Fprintf(&clauses, ", r.%s = %s", property[0], cypherString(property[1]))
+	}
+	return clauses.String()
+}
+
+// cypherWords splits s into its runs of letters and digits
+func cypherWords(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// cypherLabel turns an entity type into a PascalCase node label, empty when the type
+// has no letters or digits
+func cypherLabel(entityType string) string {
+	var label strings.Builder
+	for _, word := range cypherWords(entityType) {
+		runes := []rune(word)
+		label.WriteString(strings.ToUpper(string(runes[0])))
+		label.WriteString(string(runes[1:]))
+	}
+	return cypherIdentifier(label.String())
+}
+
+// cypherRelationType turns a predicate into an UPPER_SNAKE_CASE relationship type
+func cypherRelationType(predicate string) string {
+	relationType := cypherIdentifier(strings.ToUpper(strings.Join(cypherWords(predicate), "_")))
+	if relationType == "" {
+		return "RELATED_TO"
+	}
+	return relationType
+}
+
+// cypherIdentifier prefixes s with an underscore when it starts with a digit, which
+// an unquoted Cypher identifier can't
+func cypherIdentifier(s string) string {
+	if s != "" && unicode.IsDigit([]rune(s)[0]) {
+		return "_" + s
+	}
+	return s
+}
+
+// cypherString returns s as a quoted Cypher string literal
+func cypherString(s string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '\\':
+			quoted.WriteString(`\\`)
+		case '"':
+			quoted.WriteString(`\"`)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		default:
+			quoted.WriteRune(r)
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
+
+// cypherList returns the strings as a Cypher list literal
+func cypherList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = cypherString(value)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// cypherFloat formats f as a Cypher float literal
+func cypherFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// cypherLiteral matches a quoted string literal as cypherString writes it
+const cypherLiteral = `"(?:[^"\\]|\\.)*"`
+
+var (
+	cypherNodeStatement = regexp.MustCompile(`^MERGE \(n:Entity(?::([A-Za-z_][A-Za-z0-9_]*))? \{id: (` + cypherLiteral + `)\}\) SET n\.name = (` + cypherLiteral + `), n\.type = (` + cypherLiteral + `)(.*);$`)
+	cypherEdgeStatement = regexp.MustCompile(`^MATCH \(a:Entity \{id: (` + cypherLiteral + `)\}\), \(b:Entity \{id: (` + cypherLiteral + `)\}\) MERGE \(a\)-\[r:([A-Za-z_][A-Za-z0-9_]*)\]->\(b\) SET r\.id = (` + cypherLiteral + `), r\.predicate = (` + cypherLiteral + `), r\.confidence = ([0-9.]+), (.*);$`)
+	cypherStrings       = regexp.MustCompile(cypherLiteral)
+)
+
+// parsedCypher is the graph a Cypher script builds, as far as ExportCypher writes it
+type parsedCypher struct {
+	nodes        map[string]Entity // By node ID
+	labels       map[string]string // Node label by node ID
+	edges        []Relation        // Subject and Object are node IDs
+	edgeTypes    []string          // Relationship type of each edge
+	transactions []int             // Statements per transaction
+}
+
+// parseCypher parses a script written by ExportCypher back into the graph it builds
+func parseCypher(t *testing.T, script string) parsedCypher {
+	t.Helper()
+	unquote := func(literal string) string {
+		s, err := strconv.Unquote(literal)
+		if err != nil {
+			t.Fatalf("invalid string literal %s: %v", literal, err)
+		}
+		return s
+	}
+	parsed := parsedCypher{nodes: map[string]Entity{}, labels: map[string]string{}}
+	open := false
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	for line := 0; scanner.Scan(); line++ {
+		statement := scanner.Text()
+		switch {
+		case line == 0:
+			if !strings.HasPrefix(statement, "CREATE CONSTRAINT") {
+				t.Fatalf("script doesn't start with the constraint: %q", statement)
+			}
+		case statement == ":begin":
+			if open {
+				t.Fatalf("line %d: transaction begun twice", line)
+			}
+			open = true
+			parsed.transactions = append(parsed.transactions, 0)
+		case statement == ":commit":
+			if !open {
+				t.Fatalf("line %d: commit outside a transaction", line)
+			}
+			open = false
+		case !open:
+			t.Fatalf("line %d: statement outside a transaction: %q", line, statement)
+		default:
+			parsed.transactions[len(parsed.transactions)-1]++
+			if match := cypherNodeStatement.FindStringSubmatch(statement); match != nil {
+				id := unquote(match[2])
+				if _, exists := parsed.nodes[id]; exists {
+					t.Errorf("node %s merged twice", id)
+				}
+				entity := Entity{ID: id, Name: unquote(match[3]), Type: unquote(match[4])}
+				for _, literal := range cypherStrings.FindAllString(match[5], -1) {
+					entity.DocumentIDs = append(entity.DocumentIDs, unquote(literal))
+				}
+				parsed.nodes[id] = entity
+				parsed.labels[id] = match[1]
+			} else if match := cypherEdgeStatement.FindStringSubmatch(statement); match != nil {
+				confidence, err := strconv.ParseFloat(match[6], 64)
+				if err != nil {
+					t.Fatalf("line %d: invalid confidence %s", line, match[6])
+				}
+				parsed.edges = append(parsed.edges, Relation{
+					ID:         unquote(match[4]),
+					Subject:    unquote(match[1]),
+					Predicate:  unquote(match[5]),
+					Object:     unquote(match[2]),
+					Confidence: confidence,
+				})
+				parsed.edgeTypes = append(parsed.edgeTypes, match[3])
+			} else {
+				t.Fatalf("line %d: unrecognized statement %q", line, statement)
+			}
+		}
+	}
+	if open {
+		t.Fatalf("script ends inside a transaction")
+	}
+	return parsed
+}
+
+func TestExportCypherRoundTrip(t *testing.T) {
+	graph := &KnowledgeGraph{
+		Entities: []Entity{
+			{ID: "entity_1", Name: "Eiffel Tower", Type: "LANDMARK", Confidence: 0.9, DocumentIDs: []string{"doc_1"}},
+			{ID: "entity_2", Name: "Paris", Type: "location", Confidence: 0.95, DocumentIDs: []string{"doc_1", "doc_2"}},
+			{ID: "entity_3", Name: `Gustave "the engineer" Eiffel`, Type: "person / engineer", Confidence: 0.8, DocumentIDs: []string{"doc_1"}},
+			{ID: "entity_4", Name: "Line\nbreak", Type: "3d model", Confidence: 0.7},
+			{ID: "entity_5", Name: "France", Type: "", Confidence: 1},
+			{ID: "entity_6", Name: "Dropped", Type: "CONCEPT", Confidence: 0.1},
+		},
+		Relations: []Relation{
+			{Subject: "entity_1", Predicate: "located in", Object: "entity_2", Confidence: 0.9},
+			{Subject: "entity_3", Predicate: "designed", Object: "Eiffel Tower", Confidence: 0.85},
+			{Subject: "entity_2", Predicate: "capital-of", Object: "entity_5", Confidence: 1},
+			{Subject: "entity_4", Predicate: "", Object: "entity_1", Confidence: 0.6},
+			{Subject: "entity_1", Predicate: "1st of", Object: "Seine", Confidence: 0.5},
+			{Subject: "entity_2", Predicate: "weak", Object: "entity_5", Confidence: 0.1},
+		},
+	}
+
+	for _, batchSize := range []int{0, 1, 3, 100} {
+		var script strings.Builder
+		if err := graph.ExportCypher(&script, CypherExportOptions{MinConfidence: 0.5, BatchSize: batchSize}); err != nil {
+			t.Fatalf("ExportCypher: %v", err)
+		}
+		parsed := parseCypher(t, script.String())
+
+		// Five entities above the threshold, and Seine as the bare endpoint of a relation
+		if len(parsed.nodes) != 6 {
+			t.Errorf("batch %d: %d nodes, want 6", batchSize, len(parsed.nodes))
+		}
+		if len(parsed.edges) != 5 {
+			t.Errorf("batch %d: %d relationships, want 5", batchSize, len(parsed.edges))
+		}
+		size := batchSize
+		if size == 0 {
+			size = defaultCypherBatchSize
+		}
+		total := 0
+		for i, statements := range parsed.transactions {
+			if statements > size || statements == 0 || (i < len(parsed.transactions)-1 && statements != size) {
+				t.Errorf("batch %d: transaction %d has %d statements", batchSize, i, statements)
+			}
+			total += statements
+		}
+		if total != len(parsed.nodes)+len(parsed.edges) {
+			t.Errorf("batch %d: %d statements, want %d", batchSize, total, len(parsed.nodes)+len(parsed.edges))
+		}
+
+		byName := make(map[string]Entity, len(parsed.nodes))
+		for id, node := range parsed.nodes {
+			byName[node.Name] = node
+			if id != stableEntityID(node) {
+				t.Errorf("node %q has ID %s, want %s", node.Name, id, stableEntityID(node))
+			}
+		}
+		for _, entity := range graph.Entities {
+			node, ok := byName[entity.Name]
+			if entity.Confidence < 0.5 {
+				if ok {
+					t.Errorf("entity %q below the threshold was exported", entity.Name)
+				}
+				continue
+			}
+			if !ok || node.Type != entity.Type || strings.Join(node.DocumentIDs, ",") != strings.Join(entity.DocumentIDs, ",") {
+				t.Errorf("entity %+v round-tripped as %+v", entity, node)
+			}
+		}
+		for id, label := range parsed.labels {
+			want := map[string]string{"LANDMARK": "LANDMARK", "location": "Location", "person / engineer": "PersonEngineer", "3d model": "_3dModel", "": ""}[parsed.nodes[id].Type]
+			if label != want {
+				t.Errorf("node %q has label %q, want %q", parsed.nodes[id].Name, label, want)
+			}
+		}
+
+		relationTypes := map[string]string{"located in": "LOCATED_IN", "designed": "DESIGNED", "capital-of": "CAPITAL_OF", "": "RELATED_TO", "1st of": "_1ST_OF"}
+		for i, edge := range parsed.edges {
+			if _, ok := parsed.nodes[edge.Subject]; !ok {
+				t.Errorf("relationship %q starts at unknown node %s", edge.Predicate, edge.Subject)
+			}
+			if _, ok := parsed.nodes[edge.Object]; !ok {
+				t.Errorf("relationship %q ends at unknown node %s", edge.Predicate, edge.Object)
+			}
+			if want, ok := relationTypes[edge.Predicate]; !ok || parsed.edgeTypes[i] != want {
+				t.Errorf("relationship %q has type %s, want %s", edge.Predicate, parsed.edgeTypes[i], want)
+			}
+		}
+	}
+}
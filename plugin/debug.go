@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// debugRedacted replaces sensitive values in debug traces
+const debugRedacted = "[REDACTED]"
+
+// sensitiveConfigKey matches generation config keys whose values are credentials, but
+// not counts like maxOutputTokens
+var sensitiveConfigKey = regexp.MustCompile(`(?i)(api_?key|(access|auth|bearer|id|refresh)_?token|secret|password|credential|authorization)`)
+
+// secretPattern matches credentials that might appear in captured text: Google API
+// keys, OpenAI and Anthropic style keys and bearer tokens
+var secretPattern = regexp.MustCompile(`AIza[0-9A-Za-z_\-]{35}|\bsk-[0-9A-Za-z_\-]{20,}|(?i)\bbearer\s+[0-9A-Za-z._\-]{16,}`)
+
+// DebugConfig contains configuration for the debug traces of requests setting
+// AgenticRAGOptions.Debug
+type DebugConfig struct {
+	MaxCaptureBytes int       `json:"max_capture_bytes"` // Captured prompts and outputs are truncated beyond this size, 0 for no limit
+	Writer          io.Writer `json:"-"`                 // Receives each captured call as a JSON line instead of the response's DebugTrace
+}
+
+// DebugTrace is what a debug request sent to the models and got back, in call order
+type DebugTrace struct {
+	Calls           []DebugCall `json:"calls"`
+	DisplacedChunks []string    `json:"displaced_chunks,omitempty"` // IDs of chunks among the most relevant that diversification left out
+}
+
+// DebugCall is one model call of a debug request
+type DebugCall struct {
+	RequestID string         `json:"request_id"`
+	Stage     string         `json:"stage,omitempty"`
+	Model     string         `json:"model,omitempty"`
+	Prompt    string         `json:"prompt"`           // Rendered messages, one "role: text" block per message
+	Config    map[string]any `json:"config,omitempty"` // Generation config, with credentials redacted
+	Output    string         `json:"output,omitempty"` // Raw model output
+	Error     string         `json:"error,omitempty"`
+	Duration  time.Duration  `json:"duration"`
+	Truncated bool           `json:"truncated,omitempty"` // The prompt or output was cut at DebugConfig.MaxCaptureBytes
+}
+
+// debugMiddleware captures each call of the model it wraps into the request's debug
+// trace
+func (p *AgenticRAGProcessor) debugMiddleware(stage, model string) ai.ModelMiddleware {
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			start := time.Now()
+			response, err := next(ctx, req, cb)
+
+			limit := p.config.Debug.MaxCaptureBytes
+			call := DebugCall{
+				RequestID: requestStateFrom(ctx).id(),
+				Stage:     stage,
+				Model:     model,
+				Config:    redactConfig(req.Config),
+				Duration:  time.Since(start),
+			}
+			var promptCut, outputCut bool
+			call.Prompt, promptCut = truncateCapture(redactSecrets(renderMessages(req.Messages)), limit)
+			if response != nil {
+				call.Output, outputCut = truncateCapture(redactSecrets(response.Text()), limit)
+			}
+			if err != nil {
+				call.Error = redactSecrets(err.Error())
+			}
+			call.Truncated = promptCut || outputCut
+			p.recordDebugCall(ctx, call)
+			return response, err
+		}
+	}
+}
+
+// recordDebugCall writes the call to DebugConfig.Writer, or adds it to the request's
+// trace without one
+func (p *AgenticRAGProcessor) recordDebugCall(ctx context.Context, call DebugCall) {
+	state := requestStateFrom(ctx)
+	if p.config.Debug.Writer == nil {
+		state.addDebugCall(call)
+		return
+	}
+	line, err := json.Marshal(call)
+	if err != nil {
+		state.recordWarning(fmt.Sprintf("debug trace call dropped: %v", err))
+		return
+	}
+	p.debugMu.Lock()
+	defer p.debugMu.Unlock()
+	if _, err := p.config.Debug.Writer.Write(append(line, '\n')); err != nil {
+		state.recordWarning(fmt.Sprintf("debug trace call dropped: %v", err))
+	}
+}
+
+// renderMessages renders the messages as text, one "role: text" block per message, with
+// media and tool parts summarized so images don't bloat the trace
+func renderMessages(messages []*ai.Message) string {
+	var b strings.Builder
+	for i, message := range messages {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		fmt.Fprintf(&b, "%s: ", message.Role)
+		for _, part := range message.Content {
+			switch {
+			case part.IsText():
+				b.WriteString(part.Text)
+			case part.IsMedia():
+				fmt.Fprintf(&b, "[media %s]", part.ContentType)
+			case part.IsToolRequest():
+				fmt.Fprintf(&b, "[tool request %s]", part.ToolRequest.Name)
+			case part.IsToolResponse():
+				fmt.Fprintf(&b, "[tool response %s]", part.ToolResponse.Name)
+			default:
+				b.WriteString("[non-text part]")
+			}
+		}
+	}
+	return b.String()
+}
+
+// redactConfig returns the generation config as a JSON object with the values of
+// credential-like keys redacted, nil if it doesn't encode as one
+func redactConfig(config any) map[string]any {
+	if config == nil {
+		return nil
+	}
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil
+	}
+	redactFields(fields)
+	return fields
+}
+
+// redactFields redacts the values of credential-like keys in place, recursively
+func redactFields(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if sensitiveConfigKey.MatchString(key) {
+				v[key] = debugRedacted
+				continue
+			}
+			if text, ok := field.(string); ok {
+				v[key] = redactSecrets(text)
+				continue
+			}
+			redactFields(field)
+		}
+	case []any:
+		for i, item := range v {
+			if text, ok := item.(string); ok {
+				v[i] = redactSecrets(text)
+				continue
+			}
+			redactFields(item)
+		}
+	}
+}
+
+// redactSecrets replaces anything in text shaped like a credential
+func redactSecrets(text string) string {
+	return secretPattern.ReplaceAllString(text, debugRedacted)
+}
+
+// truncateCapture cuts text to at most limit bytes on a rune boundary, reporting
+// whether it did. A limit of 0 keeps the text whole.
+func truncateCapture(text string, limit int) (string, bool) {
+	if limit <= 0 || len(text) <= limit {
+		return text, false
+	}
+	cut := limit
+	for cut > 0 && !utf8RuneStart(text[cut]) {
+		cut--
+	}
+	return text[:cut] + "…", true
+}
+
+// utf8RuneStart reports whether the byte starts a UTF-8 encoded rune
+func utf8RuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
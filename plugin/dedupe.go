@@ -0,0 +1,416 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// maxMergeCandidates bounds the entity pairs sent to the model in one merge call
+const maxMergeCandidates = 50
+
+// normalizeEntityName folds case and drops punctuation and spacing, so "Node.js",
+// "node js" and "NodeJS" compare equal
+func normalizeEntityName(name string) string {
+	var normalized strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			normalized.WriteRune(r)
+		}
+	}
+	return normalized.String()
+}
+
+// jaroWinkler returns the Jaro-Winkler similarity of a and b, from 0 to 1
+func jaroWinkler(a, b string) float64 {
+	s, t := []rune(a), []rune(b)
+	if len(s) == 0 || len(t) == 0 {
+		return 0
+	}
+	window := max(0, max(len(s), len(t))/2-1)
+	sMatched, tMatched := make([]bool, len(s)), make([]bool, len(t))
+	matches := 0
+	for i := range s {
+		for j := max(0, i-window); j < min(len(t), i+window+1); j++ {
+			if !tMatched[j] && s[i] == t[j] {
+				sMatched[i], tMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range s {
+		if !sMatched[i] {
+			continue
+		}
+		for !tMatched[j] {
+			j++
+		}
+		if s[i] != t[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(s)) + m/float64(len(t)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s), len(t)) && s[prefix] == t[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// entityGroups is a union-find over entity indexes
+type entityGroups []int
+
+// find returns the representative of i's group
+func (g entityGroups) find(i int) int {
+	for g[i] != i {
+		g[i] = g[g[i]]
+		i = g[i]
+	}
+	return i
+}
+
+// union merges the groups of i and j, reporting whether they were separate
+func (g entityGroups) union(i, j int) bool {
+	i, j = g.find(i), g.find(j)
+	if i == j {
+		return false
+	}
+	g[max(i, j)] = min(i, j)
+	return true
+}
+
+// deduplicateEntities merges entities naming the same thing, whatever their type:
+// names equal after normalization, names the configured aliases map to the same
+// canonical name, and, unless disabled, near-duplicate names the model confirms.
+// A merged entity keeps the highest confidence and its type, every document and
+// mention, and the other names as aliases. Relations are re-pointed to the merged
+// entity. Returns the number of entities merged away.
+func (p *AgenticRAGProcessor) deduplicateEntities(ctx context.Context, kg *KnowledgeGraph) int {
+	if kg == nil || len(kg.Entities) < 2 {
+		return 0
+	}
+	config := p.config.KnowledgeGraph
+	groups, keys, canonicalNames := groupEntities(kg.Entities, config.Aliases)
+	if config.ModelAssistedMerge && config.MergeSimilarity > 0 {
+		p.modelMergeEntities(ctx, kg.Entities, keys, groups)
+	}
+	return collapseEntities(kg, groups, canonicalNames, MergePolicyMax)
+}
+
+// Merge folds other into the graph, merging entities whose names are equal after
+// normalization, as within one extraction, whatever their type. A merged entity keeps
+// the type and ID of its most confident member, weighing confidence by mentions under
+// MergePolicyWeighted, and a confidence resolved by policy;
+// duplicate relations keep the higher confidence, as relations carry no mentions to
+// weigh. Relations of both graphs are re-pointed from entity IDs to names first, since
+// IDs are only unique within one extraction.
+func (kg *KnowledgeGraph) Merge(other *KnowledgeGraph, policy MergePolicy) {
+	if kg == nil {
+		return
+	}
+	combined := &KnowledgeGraph{}
+	for _, graph := range []*KnowledgeGraph{kg, other} {
+		if graph == nil {
+			continue
+		}
+		named := &KnowledgeGraph{Entities: graph.Entities, Relations: append([]Relation(nil), graph.Relations...)}
+		resolveRelationNames(named)
+		combined.Entities = append(combined.Entities, graph.Entities...)
+		combined.Relations = append(combined.Relations, named.Relations...)
+		for key, value := range graph.Metadata {
+			if kg.Metadata == nil {
+				kg.Metadata = make(map[string]interface{}, len(graph.Metadata))
+			}
+			kg.Metadata[key] = value
+		}
+	}
+	for i := range combined.Entities {
+		combined.Entities[i].DocumentIDs = append([]string(nil), combined.Entities[i].DocumentIDs...)
+	}
+
+	groups, _, _ := groupEntities(combined.Entities, nil)
+	collapseEntities(combined, groups, nil, policy)
+	kg.Entities, kg.Relations = combined.Entities, combined.Relations
+	kg.Communities = nil // Membership changed, they're detected again when needed
+	kg.AssignIDs()
+}
+
+// groupEntities groups entities by normalized name, after resolving aliases. Returns
+// the groups, the normalized name of each entity and the canonical names of aliased
+// entities by index.
+func groupEntities(entities []Entity, aliasNames map[string]string) (entityGroups, []string, map[int]string) {
+	aliases := make(map[string]string, len(aliasNames))
+	for alias, canonical := range aliasNames {
+		aliases[normalizeEntityName(alias)] = canonical
+	}
+
+	groups := make(entityGroups, len(entities))
+	keys := make([]string, len(entities))
+	canonicalNames := make(map[int]string)
+	byKey := make(map[string]int)
+	for i, entity := range entities {
+		groups[i] = i
+		keys[i] = normalizeEntityName(entity.Name)
+		if canonical, ok := aliases[keys[i]]; ok {
+			keys[i] = normalizeEntityName(canonical)
+			canonicalNames[i] = canonical
+		}
+		if keys[i] == "" {
+			continue
+		}
+		if first, ok := byKey[keys[i]]; ok {
+			groups.union(first, i)
+		} else {
+			byKey[keys[i]] = i
+		}
+	}
+	return groups, keys, canonicalNames
+}
+
+// collapseEntities replaces the entities of kg by one per group, re-points the
+// relations to them and drops duplicate relations. Returns the number of entities
+// merged away.
+func collapseEntities(kg *KnowledgeGraph, groups entityGroups, canonicalNames map[int]string, policy MergePolicy) int {
+	members := make(map[int][]int)
+	var roots []int
+	for i := range kg.Entities {
+		root := groups.find(i)
+		if _, seen := members[root]; !seen {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	// Build one entity per group on the most confident member, and map the IDs and
+	// names of the others to it
+	entities := make([]Entity, 0, len(roots))
+	ids := make(map[string]string)
+	names := make(map[string]string)
+	for _, root := range roots {
+		group := members[root]
+		best := group[0]
+		for _, i := range group[1:] {
+			if entitySupport(kg.Entities[i], policy) > entitySupport(kg.Entities[best], policy) {
+				best = i
+			}
+		}
+		merged := kg.Entities[best]
+		for _, i := range group {
+			if canonical, ok := canonicalNames[i]; ok {
+				merged.Name = canonical
+				break
+			}
+		}
+		if len(group) > 1 {
+			merged = mergeEntityGroup(merged, kg.Entities, group, policy)
+		}
+		for _, i := range group {
+			if id := kg.Entities[i].ID; id != "" {
+				ids[id] = merged.ID
+				if merged.ID == "" {
+					ids[id] = merged.Name
+				}
+			}
+			names[strings.ToLower(kg.Entities[i].Name)] = merged.Name
+		}
+		entities = append(entities, merged)
+	}
+
+	relations := make([]Relation, 0, len(kg.Relations))
+	seen := make(map[string]int)
+	for _, relation := range kg.Relations {
+		repoint := func(ref string) string {
+			if id, ok := ids[ref]; ok {
+				return id
+			}
+			if name, ok := names[strings.ToLower(ref)]; ok {
+				return name
+			}
+			return ref
+		}
+		subject, object := repoint(relation.Subject), repoint(relation.Object)
+		if subject == object && relation.Subject != relation.Object {
+			continue // The relation related two names of the same entity
+		}
+		relation.Subject, relation.Object = subject, object
+		key := strings.ToLower(subject + "\x00" + relation.Predicate + "\x00" + object)
+		if i, ok := seen[key]; ok {
+			relations[i].Confidence = max(relations[i].Confidence, relation.Confidence)
+			relations[i].Mentions = mergeMentions(relations[i].Mentions, relation.Mentions)
+			if relations[i].Temporal == nil {
+				relations[i].Temporal = relation.Temporal
+			}
+			continue
+		}
+		seen[key] = len(relations)
+		relation.Mentions = append([]Mention(nil), relation.Mentions...)
+		relations = append(relations, relation)
+	}
+
+	merges := len(kg.Entities) - len(entities)
+	kg.Entities, kg.Relations = entities, relations
+	return merges
+}
+
+// mergeEntityGroup folds the documents, mentions, properties and names of the group
+// into merged, resolving its confidence by policy
+func mergeEntityGroup(merged Entity, entities []Entity, group []int, policy MergePolicy) Entity {
+	merged.DocumentIDs, merged.Mentions, merged.Attributes = nil, nil, nil
+	properties := make(map[string]interface{})
+	var mentions, aliases []string
+	var weighted, weights float64
+	for _, i := range group {
+		entity := entities[i]
+		merged.Confidence = max(merged.Confidence, entity.Confidence)
+		weight := entityWeight(entity)
+		merged.Mentions = mergeMentions(merged.Mentions, entity.Mentions)
+		merged.Attributes = mergeAttributes(merged.Attributes, entity.Attributes)
+		weighted += weight * entity.Confidence
+		weights += weight
+		for _, id := range entity.DocumentIDs {
+			if !containsString(merged.DocumentIDs, id) {
+				merged.DocumentIDs = append(merged.DocumentIDs, id)
+			}
+		}
+		for key, value := range entity.Properties {
+			if _, exists := properties[key]; !exists {
+				properties[key] = value
+			}
+		}
+		for _, mention := range entityMentions(entity) {
+			if !containsString(mentions, mention) {
+				mentions = append(mentions, mention)
+			}
+		}
+		if !strings.EqualFold(entity.Name, merged.Name) && !containsString(aliases, entity.Name) {
+			aliases = append(aliases, entity.Name)
+		}
+	}
+	for key, value := range merged.Properties {
+		properties[key] = value
+	}
+	if len(mentions) > 0 {
+		properties["mentions"] = mentions
+	}
+	if len(aliases) > 0 {
+		properties["aliases"] = aliases
+	}
+	merged.Properties = properties
+	if policy == MergePolicyWeighted {
+		merged.Confidence = weighted / weights
+	}
+	return merged
+}
+
+// entityWeight returns the number of mentions of an entity, at least 1, which weighs
+// its confidence under MergePolicyWeighted
+func entityWeight(entity Entity) float64 {
+	return float64(max(1, len(entity.Mentions), len(entityMentions(entity))))
+}
+
+// entitySupport ranks the members of a merge group to pick the one the merged entity
+// is built on. Under MergePolicyWeighted that's the confidence weighted by mentions,
+// since a previously merged entity's averaged confidence would otherwise lose to any
+// single more confident mention, making the merged type depend on the merge order.
+func entitySupport(entity Entity, policy MergePolicy) float64 {
+	if policy == MergePolicyWeighted {
+		return entityWeight(entity) * entity.Confidence
+	}
+	return entity.Confidence
+}
+
+// entityMentions returns the mentions property of an entity, the surface forms the
+// model reported
+func entityMentions(entity Entity) []string {
+	return stringList(entity.Properties["mentions"])
+}
+
+// stringList returns a list property as strings, as parsed from the model or decoded
+// from JSON
+func stringList(value any) []string {
+	switch list := value.(type) {
+	case []string:
+		return list
+	case []any:
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				strs = append(strs, s)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// modelMergeEntities asks the model which pairs of still separate entities with
+// names at least MergeSimilarity alike are the same, and unions those it confirms.
+// Failures leave the entities unmerged with a warning, since merging is best-effort.
+func (p *AgenticRAGProcessor) modelMergeEntities(ctx context.Context, entities []Entity, keys []string, groups entityGroups) {
+	type pair struct{ a, b int }
+	var candidates []pair
+	for i := range entities {
+		for j := i + 1; j < len(entities) && len(candidates) < maxMergeCandidates; j++ {
+			if keys[i] == "" || keys[j] == "" || groups.find(i) == groups.find(j) {
+				continue
+			}
+			if jaroWinkler(keys[i], keys[j]) >= p.config.KnowledgeGraph.MergeSimilarity {
+				candidates = append(candidates, pair{i, j})
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	var list strings.Builder
+	for n, candidate := range candidates {
+		a, b := entities[candidate.a], entities[candidate.b]
+		fmt.Fprintf(&list, "%d. %q (%s) and %q (%s)\n", n+1, a.Name, a.Type, b.Name, b.Type)
+	}
+	prompt := fmt.Sprintf(`Decide which of these pairs of entities, extracted from the same documents, name the same real-world thing,
+such as a product and its abbreviation or a company with and without its legal suffix. Pairs that are merely related,
+like a product and one of its components, are not the same.
+
+%s
+Respond with JSON only, listing the numbers of the pairs that are the same, e.g. {"same": [1, 3]}`, list.String())
+
+	state := requestStateFrom(ctx)
+	response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 200,
+	})
+	if err != nil {
+		if !errors.Is(err, ErrBudgetExceeded) {
+			state.recordWarning(fmt.Sprintf("model-assisted entity merge failed: %v", err))
+		}
+		return
+	}
+	var result struct {
+		Same []int `json:"same"`
+	}
+	text := strings.TrimSpace(response.Text())
+	text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```"), "```")
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &result); err != nil {
+		state.recordWarning(fmt.Sprintf("model-assisted entity merge failed: failed to parse response: %v", err))
+		return
+	}
+	for _, n := range result.Same {
+		if n >= 1 && n <= len(candidates) {
+			groups.union(candidates[n-1].a, candidates[n-1].b)
+		}
+	}
+}
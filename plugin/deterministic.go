@@ -0,0 +1,59 @@
+package plugin
+
+import (
+	"context"
+	"maps"
+
+	"github.com/firebase/genkit/go/ai"
+	"google.golang.org/genai"
+)
+
+// deterministicSeed is the sampling seed sent to providers that accept one in
+// deterministic mode
+const deterministicSeed = 42
+
+// deterministicMiddleware rewrites the generation config of every request to the model
+// for greedy decoding, whether the config came from the caller or the dotprompt
+func deterministicMiddleware(model string) ai.ModelMiddleware {
+	return func(next ai.ModelFunc) ai.ModelFunc {
+		return func(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+			copied := *req
+			copied.Config = deterministicConfig(model, req.Config)
+			return next(ctx, &copied, cb)
+		}
+	}
+}
+
+// deterministicConfig returns a copy of config with temperature 0 and top-k 1, plus a
+// fixed seed when the config type and the model support one. Unknown config types are
+// returned unchanged.
+func deterministicConfig(model string, config any) any {
+	switch c := config.(type) {
+	case nil:
+		if isGeminiModel(model) {
+			return map[string]any{"temperature": 0, "topK": 1, "seed": deterministicSeed}
+		}
+		return &ai.GenerationCommonConfig{TopK: 1}
+	case *ai.GenerationCommonConfig:
+		copied := *c
+		copied.Temperature, copied.TopK, copied.TopP = 0, 1, 0
+		return &copied
+	case *genai.GenerateContentConfig:
+		copied := *c
+		copied.Temperature = genai.Ptr[float32](0)
+		copied.TopK = genai.Ptr[float32](1)
+		copied.TopP = nil
+		copied.Seed = genai.Ptr[int32](deterministicSeed)
+		return &copied
+	case map[string]any:
+		copied := maps.Clone(c)
+		copied["temperature"], copied["topK"] = 0, 1
+		delete(copied, "topP")
+		if isGeminiModel(model) {
+			copied["seed"] = deterministicSeed
+		}
+		return copied
+	default:
+		return config
+	}
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// TestDeterministicRunsIdentical checks two deterministic runs of the same request
+// produce byte-identical responses, metadata ordering included. Only the request ID
+// and the measured times differ between runs, so they're cleared before comparing.
+func TestDeterministicRunsIdentical(t *testing.T) {
+	run := func() []byte {
+		mock := &mockProvider{}
+		p := newTestProcessor(t, mock, nil)
+		request := testRequest("Where is the Eiffel Tower and what covers a third of the Earth?")
+		request.Options.Deterministic = true
+		request.Options.EnableKnowledgeGraph = true
+		request.Options.EnableFactVerification = true
+
+		response := mustProcess(t, p, request)
+		metadata := &response.ProcessingMetadata
+		metadata.RequestID = ""
+		metadata.ProcessingTime = 0
+		metadata.ModelTime = 0
+		for i := range metadata.StageTimings {
+			metadata.StageTimings[i].Duration = 0
+			metadata.StageTimings[i].WorkTime = 0
+		}
+		encoded, err := json.Marshal(response)
+		if err != nil {
+			t.Fatalf("failed to encode response: %v", err)
+		}
+		return encoded
+	}
+
+	first, second := run(), run()
+	if !bytes.Equal(first, second) {
+		t.Errorf("deterministic runs differ:\n%s\n%s", first, second)
+	}
+}
@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"math"
+	"sort"
+)
+
+// defaultDiffEpsilon is the confidence change below which Diff ignores jitter
+const defaultDiffEpsilon = 0.05
+
+// DiffOptions tunes KnowledgeGraph.Diff
+type DiffOptions struct {
+	ConfidenceEpsilon float64 // Confidence changes up to this are jitter, not changes (default: 0.05)
+}
+
+// GraphDiff is what changed between two knowledge graphs, matched by stable ID
+type GraphDiff struct {
+	AddedEntities    []Entity         `json:"added_entities,omitempty"`
+	RemovedEntities  []Entity         `json:"removed_entities,omitempty"`
+	ChangedEntities  []EntityChange   `json:"changed_entities,omitempty"`
+	AddedRelations   []Relation       `json:"added_relations,omitempty"`
+	RemovedRelations []Relation       `json:"removed_relations,omitempty"`
+	ChangedRelations []RelationChange `json:"changed_relations,omitempty"`
+}
+
+// EntityChange is an entity found in both graphs with a different confidence or
+// attributes
+type EntityChange struct {
+	ID      string   `json:"id"`
+	Old     Entity   `json:"old"`
+	New     Entity   `json:"new"`
+	Changes []string `json:"changes"` // What changed: "confidence" or "attributes.<name>"
+}
+
+// RelationChange is a relation found in both graphs with a different confidence or
+// temporal qualifier
+type RelationChange struct {
+	ID      string   `json:"id"`
+	Old     Relation `json:"old"`
+	New     Relation `json:"new"`
+	Changes []string `json:"changes"` // What changed: "confidence" or "temporal"
+}
+
+// Empty reports whether the graphs had no differences
+func (d GraphDiff) Empty() bool {
+	return len(d.AddedEntities) == 0 && len(d.RemovedEntities) == 0 && len(d.ChangedEntities) == 0 &&
+		len(d.AddedRelations) == 0 && len(d.RemovedRelations) == 0 && len(d.ChangedRelations) == 0
+}
+
+// Diff returns what changed from old to this graph, such as after a document was
+// updated and processed again. Entities and relations are matched by their stable IDs,
+// derived for graphs that lack them; each list is sorted by ID.
+func (kg *KnowledgeGraph) Diff(old *KnowledgeGraph, opts DiffOptions) GraphDiff {
+	epsilon := opts.ConfidenceEpsilon
+	if epsilon <= 0 {
+		epsilon = defaultDiffEpsilon
+	}
+	before, after := identifiedCopy(old), identifiedCopy(kg)
+
+	var diff GraphDiff
+	oldEntities := make(map[string]Entity, len(before.Entities))
+	for _, entity := range before.Entities {
+		oldEntities[entity.ID] = entity
+	}
+	seen := make(map[string]bool, len(after.Entities))
+	for _, entity := range after.Entities {
+		seen[entity.ID] = true
+		previous, ok := oldEntities[entity.ID]
+		if !ok {
+			diff.AddedEntities = append(diff.AddedEntities, entity)
+			continue
+		}
+		var changes []string
+		if math.Abs(entity.Confidence-previous.Confidence) > epsilon {
+			changes = append(changes, "confidence")
+		}
+		for _, key := range sortedKeys(mergeAttributes(previous.Attributes, entity.Attributes)) {
+			a, inOld := previous.Attributes[key]
+			b, inNew := entity.Attributes[key]
+			if inOld != inNew || a.Value != b.Value || math.Abs(a.Confidence-b.Confidence) > epsilon {
+				changes = append(changes, "attributes."+key)
+			}
+		}
+		if len(changes) > 0 {
+			diff.ChangedEntities = append(diff.ChangedEntities, EntityChange{ID: entity.ID, Old: previous, New: entity, Changes: changes})
+		}
+	}
+	for _, entity := range before.Entities {
+		if !seen[entity.ID] {
+			diff.RemovedEntities = append(diff.RemovedEntities, entity)
+		}
+	}
+
+	oldRelations := make(map[string]Relation, len(before.Relations))
+	for _, relation := range before.Relations {
+		oldRelations[relation.ID] = relation
+	}
+	seen = make(map[string]bool, len(after.Relations))
+	for _, relation := range after.Relations {
+		seen[relation.ID] = true
+		previous, ok := oldRelations[relation.ID]
+		if !ok {
+			diff.AddedRelations = append(diff.AddedRelations, relation)
+			continue
+		}
+		var changes []string
+		if math.Abs(relation.Confidence-previous.Confidence) > epsilon {
+			changes = append(changes, "confidence")
+		}
+		if temporalLabel(relation.Temporal) != temporalLabel(previous.Temporal) {
+			changes = append(changes, "temporal")
+		}
+		if len(changes) > 0 {
+			diff.ChangedRelations = append(diff.ChangedRelations, RelationChange{ID: relation.ID, Old: previous, New: relation, Changes: changes})
+		}
+	}
+	for _, relation := range before.Relations {
+		if !seen[relation.ID] {
+			diff.RemovedRelations = append(diff.RemovedRelations, relation)
+		}
+	}
+
+	sort.Slice(diff.AddedEntities, func(i, j int) bool { return diff.AddedEntities[i].ID < diff.AddedEntities[j].ID })
+	sort.Slice(diff.RemovedEntities, func(i, j int) bool { return diff.RemovedEntities[i].ID < diff.RemovedEntities[j].ID })
+	sort.Slice(diff.ChangedEntities, func(i, j int) bool { return diff.ChangedEntities[i].ID < diff.ChangedEntities[j].ID })
+	sort.Slice(diff.AddedRelations, func(i, j int) bool { return diff.AddedRelations[i].ID < diff.AddedRelations[j].ID })
+	sort.Slice(diff.RemovedRelations, func(i, j int) bool { return diff.RemovedRelations[i].ID < diff.RemovedRelations[j].ID })
+	sort.Slice(diff.ChangedRelations, func(i, j int) bool { return diff.ChangedRelations[i].ID < diff.ChangedRelations[j].ID })
+	return diff
+}
+
+// identifiedCopy returns a copy of the graph's entities and relations with stable IDs,
+// leaving the graph as it is
+func identifiedCopy(kg *KnowledgeGraph) *KnowledgeGraph {
+	if kg == nil {
+		return &KnowledgeGraph{}
+	}
+	copied := &KnowledgeGraph{
+		Entities:  append([]Entity(nil), kg.Entities...),
+		Relations: append([]Relation(nil), kg.Relations...),
+	}
+	copied.AssignIDs()
+	return copied
+}
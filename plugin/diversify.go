@@ -0,0 +1,129 @@
+package plugin
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// diversityLambda returns the weight of relevance against diversity for the request
+func (p *AgenticRAGProcessor) diversityLambda(options AgenticRAGOptions) float64 {
+	if options.DiversityLambda > 0 {
+		return options.DiversityLambda
+	}
+	return p.config.Processing.DiversityLambda
+}
+
+// diversifyChunks selects up to k of the chunks by maximal marginal relevance: each
+// pick maximizes lambda times its relevance minus 1-lambda times its greatest
+// similarity to the chunks picked before. Similarity is the cosine of the chunks'
+// embeddings, or the overlap of their words without an embedder or if embedding
+// fails. Returns the picks in order and the IDs of the k most relevant chunks left out.
+func (p *AgenticRAGProcessor) diversifyChunks(ctx context.Context, chunks []DocumentChunk, k int, lambda float64) ([]DocumentChunk, []string, error) {
+	if k <= 0 || k > len(chunks) {
+		k = len(chunks)
+	}
+	if k == 0 {
+		return chunks, nil, nil
+	}
+
+	similarity, err := p.chunkSimilarity(ctx, chunks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	picked := make([]bool, len(chunks))
+	// closest holds each chunk's greatest similarity to the picks so far
+	closest := make([]float64, len(chunks))
+	selected := make([]DocumentChunk, 0, k)
+	for len(selected) < k {
+		best, bestScore := -1, math.Inf(-1)
+		for i, chunk := range chunks {
+			if picked[i] {
+				continue
+			}
+			score := lambda*chunk.RelevanceScore - (1-lambda)*closest[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		picked[best] = true
+		selected = append(selected, chunks[best])
+		for i := range chunks {
+			if !picked[i] {
+				closest[i] = max(closest[i], similarity(i, best))
+			}
+		}
+	}
+
+	byRelevance := make([]int, len(chunks))
+	for i := range byRelevance {
+		byRelevance[i] = i
+	}
+	sort.SliceStable(byRelevance, func(a, b int) bool {
+		return chunks[byRelevance[a]].RelevanceScore > chunks[byRelevance[b]].RelevanceScore
+	})
+	var displaced []string
+	for _, i := range byRelevance[:k] {
+		if !picked[i] {
+			displaced = append(displaced, chunks[i].ID)
+		}
+	}
+	return selected, displaced, nil
+}
+
+// chunkSimilarity returns the similarity of two chunks by index, from 0 to 1: the
+// cosine of their embeddings, or the word overlap of wordOverlap when no embedder is
+// configured or embedding fails
+func (p *AgenticRAGProcessor) chunkSimilarity(ctx context.Context, chunks []DocumentChunk) (func(i, j int) float64, error) {
+	if embedder := p.embedder(); embedder != nil && !requestStateFrom(ctx).isDryRun() {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Content
+		}
+		embeddings, err := p.embed(ctx, embedder, texts)
+		if err == nil {
+			return func(i, j int) float64 {
+				return max(0, cosineSimilarity(embeddings[i], embeddings[j]))
+			}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	words := make([]map[string]bool, len(chunks))
+	for i, chunk := range chunks {
+		words[i] = wordSet(chunk.Content)
+	}
+	return func(i, j int) float64 {
+		return wordOverlap(words[i], words[j])
+	}, nil
+}
+
+// wordSet returns the lowercased words of text
+func wordSet(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
+
+// wordOverlap returns the Jaccard similarity of two word sets, 0 if both are empty
+func wordOverlap(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if b[word] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// modelDowngrader switches models that keep exhausting their quota to cheaper
+// models, keeping each downgrade in place for a cooldown so calls don't flap
+// between models
+type modelDowngrader struct {
+	mu        sync.Mutex
+	targets   map[string]string
+	threshold int
+	cooldown  time.Duration
+	strikes   map[string]int       // Consecutive quota-exhausted calls per model
+	until     map[string]time.Time // Active downgrades and when they expire
+}
+
+// newModelDowngrader creates a downgrader for the configured models, or nil if none are configured
+func newModelDowngrader(config DowngradeConfig) *modelDowngrader {
+	if len(config.Models) == 0 {
+		return nil
+	}
+
+	d := &modelDowngrader{
+		targets:   config.Models,
+		threshold: config.Threshold,
+		cooldown:  config.Cooldown,
+		strikes:   make(map[string]int),
+		until:     make(map[string]time.Time),
+	}
+	if d.threshold <= 0 {
+		d.threshold = 1
+	}
+	if d.cooldown <= 0 {
+		d.cooldown = 5 * time.Minute
+	}
+
+	return d
+}
+
+// target returns the configured downgrade for a model
+func (d *modelDowngrader) target(model string) (string, bool) {
+	return downgradeTarget(d.targets, model)
+}
+
+// downgradeTarget returns the downgrade for a model by its full or bare name
+func downgradeTarget(models map[string]string, model string) (string, bool) {
+	key := downgradeKey(models, model)
+	to, ok := models[key]
+	return to, ok
+}
+
+// downgradeKey returns the key the model's downgrade is configured under: its full
+// name if configured, else its bare name
+func downgradeKey(models map[string]string, model string) string {
+	if _, ok := models[model]; ok {
+		return model
+	}
+	return bareModelName(model)
+}
+
+// downgradeLoops reports whether following the downgrades from the configured model
+// leads back to it
+func downgradeLoops(models map[string]string, model string) bool {
+	seen := make(map[string]bool)
+	for to, ok := models[model]; ok; to, ok = downgradeTarget(models, to) {
+		key := downgradeKey(models, to)
+		if key == model {
+			return true
+		}
+		if seen[key] {
+			return false
+		}
+		seen[key] = true
+	}
+	return false
+}
+
+// resolve follows the active downgrades starting at model and returns the model to call
+func (d *modelDowngrader) resolve(model string) string {
+	if d == nil || model == "" {
+		return model
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	seen := map[string]bool{model: true}
+	for {
+		until, active := d.until[model]
+		if !active || now.After(until) {
+			return model
+		}
+		to, ok := d.target(model)
+		if !ok || seen[to] {
+			return model
+		}
+		seen[to] = true
+		model = to
+	}
+}
+
+// exhausted records a quota-exhausted call on model. Once the threshold is reached
+// the downgrade becomes active and its target is returned.
+func (d *modelDowngrader) exhausted(model string) (string, bool) {
+	if d == nil || model == "" {
+		return "", false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	to, ok := d.target(model)
+	if !ok {
+		return "", false
+	}
+	d.strikes[model]++
+	if d.strikes[model] < d.threshold {
+		return "", false
+	}
+	d.strikes[model] = 0
+	d.until[model] = time.Now().Add(d.cooldown)
+	return to, true
+}
+
+// succeeded clears the quota strikes of model
+func (d *modelDowngrader) succeeded(model string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.strikes, model)
+}
+
+// applyDowngrade swaps the candidate for its active downgrade, if the request allows it.
+// Load-balanced instances are left alone since the balancer already routes around them.
+func (p *AgenticRAGProcessor) applyDowngrade(ctx context.Context, model modelCandidate) modelCandidate {
+	state := requestStateFrom(ctx)
+	if model.instance != nil || !state.downgradesAllowed() {
+		return model
+	}
+
+	to := p.downgrader.resolve(model.name)
+	if to == model.name {
+		return model
+	}
+	state.recordDowngrade(ModelDowngrade{From: model.name, To: to})
+	return namedCandidate(to)
+}
+
+// noteQuotaExhausted records a quota-exhausted call and reports whether the model
+// has just been downgraded
+func (p *AgenticRAGProcessor) noteQuotaExhausted(ctx context.Context, model modelCandidate) bool {
+	if model.instance != nil || !requestStateFrom(ctx).downgradesAllowed() {
+		return false
+	}
+
+	to, ok := p.downgrader.exhausted(model.name)
+	if ok {
+		slog.WarnContext(ctx, "downgrading model after repeated quota exhaustion",
+			"from", model.name,
+			"to", to,
+			"cooldown", p.downgrader.cooldown,
+		)
+	}
+	return ok
+}
@@ -0,0 +1,61 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/core"
+)
+
+func TestValidateRejectsDowngradeCycles(t *testing.T) {
+	config := DefaultConfig()
+	config.Downgrade.Models = map[string]string{
+		"a": "b", "b": "a", // Two models downgrading to each other
+		"x": "y", "y": "googleai/z", "z": "x", // A cycle through a bare model name
+		"pro": "flash", "flash": "lite", // A chain ending in a model without a downgrade
+	}
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted cyclic downgrades")
+	}
+	found := make(map[string]bool)
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var validation *ValidationError
+		if errors.As(e, &validation) {
+			found[validation.Field] = true
+		}
+	}
+	for _, model := range []string{"a", "b", "x", "y", "z"} {
+		if !found["downgrade.models."+model] {
+			t.Errorf("no error reported for the cycle through %s", model)
+		}
+	}
+	if found["downgrade.models.pro"] || found["downgrade.models.flash"] {
+		t.Errorf("errors %v reported for a chain without a cycle", found)
+	}
+}
+
+func TestFailoverBoundsCyclicDowngrades(t *testing.T) {
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Retry.MaxAttempts = 1
+	})
+	// Validation rejects the cycle, so the downgrader is set up directly
+	p.downgrader = newModelDowngrader(DowngradeConfig{Models: map[string]string{mockModel: "googleai/other", "googleai/other": mockModel}, Threshold: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var called []string
+	_, err := p.withFailover(ctx, namedCandidate(mockModel), func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+		called = append(called, model.name)
+		return nil, core.NewError(core.RESOURCE_EXHAUSTED, "quota exceeded")
+	})
+	if err == nil || !isQuotaExhausted(err) {
+		t.Fatalf("withFailover = %v, want the quota error", err)
+	}
+	if ctx.Err() != nil || len(called) != 2 || called[1] != "googleai/other" {
+		t.Errorf("called %v, want the model and then its downgrade once", called)
+	}
+}
@@ -0,0 +1,175 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+)
+
+// Output tokens planned per call of the stages whose output isn't capped by the request,
+// matching the output limits the stages set
+const (
+	plannedRewriteOutput      = 300
+	plannedRelevanceOutput    = 1000
+	plannedRerankOutput       = 100
+	plannedAssessLevelOutput  = 800
+	plannedAnswerOutput       = 2000
+	plannedGroundednessOutput = 600
+	plannedAssessmentOutput   = 600
+	plannedGraphOutput        = 2500
+	plannedMergeOutput        = 200
+	plannedMergePairTokens    = 20 // Input per entity pair judged by the model-assisted merge
+	plannedVerifyOutput       = 2048
+)
+
+// ModelPricing is the price of a model per million tokens, in any currency
+type ModelPricing struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+}
+
+// modelPricing returns the configured pricing of the named model, if any
+func (p *AgenticRAGProcessor) modelPricing(name string) (ModelPricing, bool) {
+	if pricing, ok := p.config.Pricing[name]; ok {
+		return pricing, true
+	}
+	pricing, ok := p.config.Pricing[bareModelName(name)]
+	return pricing, ok
+}
+
+// planDryRun estimates the model calls the request would make over the chunks, without
+// making any. Estimates are upper bounds: every refinement level is assumed to run and
+// the first max_chunks chunks are assumed relevant, since relevance is unknown
+// without scoring.
+func (p *AgenticRAGProcessor) planDryRun(ctx context.Context, request AgenticRAGRequest, history []Turn, chunks []DocumentChunk) *DryRunPlan {
+	state := requestStateFrom(ctx)
+	options := request.Options
+	plan := &DryRunPlan{ChunkCount: len(chunks)}
+	queryTokens := promptOverheadTokens + estimateTokens(request.Query)
+	budget := options.MaxTotalTokens
+
+	add := func(stage string, calls, input, output int) {
+		if calls == 0 {
+			return
+		}
+		model := state.modelOverride(stage)
+		if model == "" {
+			model = p.primaryCandidate().name
+		}
+		planned := PlannedStage{
+			Stage:        stage,
+			Model:        model,
+			ModelCalls:   calls,
+			InputTokens:  input,
+			OutputTokens: output,
+			OverBudget:   budget > 0 && plan.EstimatedTokens+input > budget,
+		}
+		if pricing, ok := p.modelPricing(model); ok {
+			planned.EstimatedCost = (float64(input)*pricing.InputPerMillion + float64(output)*pricing.OutputPerMillion) / 1e6
+		}
+		plan.Stages = append(plan.Stages, planned)
+		plan.EstimatedTokens += input + output
+		plan.EstimatedCost += planned.EstimatedCost
+	}
+	sumTokens := func(chunks []DocumentChunk) int {
+		total := 0
+		for _, chunk := range chunks {
+			total += chunkTokens(chunk)
+		}
+		return total
+	}
+
+	if options.EnableQueryRewrite || len(history) > 0 {
+		var turns strings.Builder
+		for _, turn := range history {
+			turns.WriteString(turn.Content)
+		}
+		add(StageQueryRewrite, 1, queryTokens+estimateTokens(turns.String()), plannedRewriteOutput)
+	}
+
+	// Relevance scoring sends all chunks in one call, or only the best embedding
+	// matches in hybrid mode. Pure embedding scoring makes no model calls.
+	scored := chunks
+	mode := p.config.Processing.RelevanceMode
+	embedded := mode != "" && mode != RelevanceModeLLM && p.embedder() != nil
+	if embedded && mode == RelevanceModeHybrid {
+		if topK := p.config.Processing.HybridTopK; topK > 0 && len(scored) > topK {
+			scored = scored[:topK]
+		}
+	}
+	if len(scored) > 0 && (!embedded || mode == RelevanceModeHybrid) {
+		add(StageRelevanceScoring, 1, queryTokens+sumTokens(scored), plannedRelevanceOutput)
+	}
+	relevant := chunks
+	if maxChunks := p.config.Processing.DefaultMaxChunks; maxChunks > 0 && len(relevant) > maxChunks {
+		relevant = relevant[:maxChunks]
+	}
+
+	if options.EnableRerank {
+		topN := options.RerankTopN
+		if topN == 0 {
+			topN = p.config.Processing.RerankTopN
+		}
+		if topN <= 0 || topN > len(relevant) {
+			topN = len(relevant)
+		}
+		add(StageReranking, topN, topN*queryTokens+sumTokens(relevant[:topN]), topN*plannedRerankOutput)
+	}
+
+	// Each level scores the sub-chunks of every chunk large enough to break down, and
+	// is preceded by an assessment when termination criteria are set
+	criteria := p.config.Processing.Termination
+	assess := criteria.MinInformationGain > 0 || criteria.StopOnNoNewEntities || criteria.TargetConfidence > 0
+	var refinable []DocumentChunk
+	for _, chunk := range relevant {
+		if len(chunk.Content) > 200 && chunk.BlockType == "" {
+			refinable = append(refinable, chunk)
+		}
+	}
+	if levels := options.RecursiveDepth; levels > 0 && len(refinable) > 0 {
+		calls := len(refinable)
+		input := calls*queryTokens + sumTokens(refinable)
+		output := calls * plannedRelevanceOutput
+		if assess {
+			calls++
+			input += queryTokens + sumTokens(relevant)
+			output += plannedAssessLevelOutput
+		}
+		add(StageRefinement, levels*calls, levels*input, levels*output)
+	}
+
+	// Synthesis gets the chunks that fit the context window
+	synthesis := sumTokens(relevant)
+	if strategy := p.config.Truncation.Strategy; strategy != "" && strategy != TruncationNone {
+		if limit := p.contextBudget(request.Query); limit > 0 {
+			synthesis = min(synthesis, limit)
+		}
+	}
+	answerTokens := plannedAnswerOutput
+	if options.MaxAnswerTokens > 0 {
+		answerTokens = options.MaxAnswerTokens
+	}
+	if len(relevant) > 0 {
+		add(StageGeneration, 1, queryTokens+synthesis, answerTokens)
+	}
+
+	prose := options.ResponseFormat != ResponseFormatJSON && options.AnswerMode != AnswerModeExtractive
+	if options.CheckGroundedness && prose {
+		add(StageGroundedness, 1, promptOverheadTokens+answerTokens+synthesis, plannedGroundednessOutput)
+	}
+	if options.MinAnswerConfidence > 0 {
+		add(StageAnswerAssessment, 1, queryTokens+answerTokens+synthesis, plannedAssessmentOutput)
+	}
+	if options.EnableKnowledgeGraph && p.config.KnowledgeGraph.Enabled && len(relevant) > 0 {
+		calls, input, output := 1, promptOverheadTokens+sumTokens(relevant), plannedGraphOutput
+		if p.config.KnowledgeGraph.ModelAssistedMerge && p.config.KnowledgeGraph.MergeSimilarity > 0 {
+			calls++
+			input += promptOverheadTokens + maxMergeCandidates*plannedMergePairTokens
+			output += plannedMergeOutput
+		}
+		add(StageKnowledgeGraph, calls, input, output)
+	}
+	if options.EnableFactVerification {
+		add(StageFactVerification, 1, promptOverheadTokens+answerTokens+sumTokens(relevant), plannedVerifyOutput)
+	}
+	return plan
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// embedder returns the configured embedder, or nil if none is configured
+func (p *AgenticRAGProcessor) embedder() ai.Embedder {
+	if p.config.Embedder != nil {
+		return p.config.Embedder
+	}
+	if p.config.EmbedderName == "" || p.config.Genkit == nil {
+		return nil
+	}
+	provider, name, ok := strings.Cut(p.config.EmbedderName, "/")
+	if !ok {
+		provider, name = "", p.config.EmbedderName
+	}
+	return genkit.LookupEmbedder(p.config.Genkit, provider, name)
+}
+
+// embed returns one embedding per text, reusing cached embeddings and sending the
+// rest in batches, retrying transient failures. A failing cache is bypassed with a
+// warning.
+func (p *AgenticRAGProcessor) embed(ctx context.Context, embedder ai.Embedder, texts []string) (embeddings [][]float32, err error) {
+	state := requestStateFrom(ctx)
+	ctx, span := p.startCallSpan(ctx, "agentic_rag.embed")
+	var missing []int
+	retries := 0
+	defer func() {
+		endEmbedSpan(span, embedder, len(texts), len(texts)-len(missing), retries, err)
+	}()
+
+	embeddings = make([][]float32, len(texts))
+	for i, text := range texts {
+		if p.embeddings != nil {
+			embedding, err := p.embeddings.Get(ctx, embeddingCacheKey(embedder.Name(), text))
+			if err != nil {
+				state.recordWarning(fmt.Sprintf("embedding cache read failed: %v", err))
+			} else if embedding != nil {
+				embeddings[i] = embedding
+				continue
+			}
+		}
+		missing = append(missing, i)
+	}
+	if p.embeddings != nil {
+		state.countEmbeddingCacheHits(len(texts) - len(missing))
+		state.countEmbeddingCacheMisses(len(missing))
+	}
+
+	batchSize := p.config.Embedding.BatchSize
+	if batchSize <= 0 {
+		batchSize = len(missing)
+	}
+	for start := 0; start < len(missing); start += batchSize {
+		indexes := missing[start:min(start+batchSize, len(missing))]
+		batch := make([]string, len(indexes))
+		for i, index := range indexes {
+			batch[i] = texts[index]
+		}
+
+		attempts := 0
+		response, err := withRetry(ctx, p.config.Retry, func(ctx context.Context) (*ai.EmbedResponse, error) {
+			attempts++
+			response, err := ai.Embed(ctx, p.audit.embedder(embedder), ai.WithTextDocs(batch...))
+			return response, classifyError(embedder.Name(), err)
+		})
+		retries += attempts - 1
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed texts: %w", err)
+		}
+		if len(response.Embeddings) != len(batch) {
+			return nil, fmt.Errorf("embedder %s returned %d embeddings for %d texts", embedder.Name(), len(response.Embeddings), len(batch))
+		}
+		state.countEmbeddingCall()
+
+		for i, embedding := range response.Embeddings {
+			embeddings[indexes[i]] = embedding.Embedding
+			if p.embeddings == nil {
+				continue
+			}
+			if err := p.embeddings.Put(ctx, embeddingCacheKey(embedder.Name(), batch[i]), embedding.Embedding); err != nil {
+				state.recordWarning(fmt.Sprintf("embedding cache write failed: %v", err))
+			}
+		}
+	}
+	return embeddings, nil
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, 0 if either is zero
+// or their dimensions differ
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
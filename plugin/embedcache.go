@@ -0,0 +1,217 @@
+package plugin
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EmbeddingCache stores embeddings across requests and index runs, keyed by the
+// embedder and the hash of the text, see embeddingCacheKey. Implementations must be
+// safe for concurrent use.
+type EmbeddingCache interface {
+	// Get returns the embedding stored under key, or nil if there is none
+	Get(ctx context.Context, key string) ([]float32, error)
+	// Put stores the embedding under key, replacing any earlier one
+	Put(ctx context.Context, key string, embedding []float32) error
+}
+
+// embeddingCacheKey returns the cache key of the text's embedding by the embedder.
+// The embedder's name leads the key, so switching embedders misses the cache.
+func embeddingCacheKey(embedder, text string) string {
+	return embedder + "\x00" + contentHash(text)
+}
+
+// MemoryEmbeddingCache is an in-process EmbeddingCache that evicts the least recently
+// used embedding once full
+type MemoryEmbeddingCache struct {
+	entries *lruCache[[]float32]
+}
+
+// NewMemoryEmbeddingCache creates an in-memory cache holding up to maxEntries
+// embeddings (0 for no limit)
+func NewMemoryEmbeddingCache(maxEntries int) *MemoryEmbeddingCache {
+	return &MemoryEmbeddingCache{entries: newLRUCache[[]float32](maxEntries, 0)}
+}
+
+// Get implements EmbeddingCache
+func (c *MemoryEmbeddingCache) Get(ctx context.Context, key string) ([]float32, error) {
+	return c.entries.get(key), nil
+}
+
+// Put implements EmbeddingCache, evicting the least recently used embeddings when full
+func (c *MemoryEmbeddingCache) Put(ctx context.Context, key string, embedding []float32) error {
+	c.entries.put(key, embedding)
+	return nil
+}
+
+// diskEmbeddingSuffix is the file name suffix of embeddings stored by DiskEmbeddingCache
+const diskEmbeddingSuffix = ".embedding.json"
+
+// diskEmbedding is an embedding as DiskEmbeddingCache stores it. The embedder is
+// recorded with it, so a file is never served for another embedder's key.
+type diskEmbedding struct {
+	Embedder  string    `json:"embedder"`
+	Key       string    `json:"key"`
+	Embedding []float32 `json:"embedding"`
+}
+
+// DiskEmbeddingCache is an EmbeddingCache of files in a directory, one per embedding,
+// so embeddings survive restarts. Once full it evicts the least recently used
+// embedding, tracked by file modification time.
+type DiskEmbeddingCache struct {
+	dir        string
+	maxEntries int
+
+	mu      sync.Mutex
+	order   *list.List               // File names, most recently used first
+	entries map[string]*list.Element // By file name
+}
+
+// NewDiskEmbeddingCache creates a cache in dir, creating the directory if needed and
+// picking up the embeddings stored there before. It holds up to maxEntries embeddings
+// (0 for no limit).
+func NewDiskEmbeddingCache(dir string, maxEntries int) (*DiskEmbeddingCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create embedding cache directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding cache directory: %w", err)
+	}
+	type stored struct {
+		name    string
+		modTime time.Time
+	}
+	var files []stored
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), diskEmbeddingSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed since the directory was read
+		}
+		files = append(files, stored{name: entry.Name(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	c := &DiskEmbeddingCache{dir: dir, maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+	for _, file := range files {
+		c.entries[file.name] = c.order.PushBack(file.name)
+	}
+	if err := c.evict(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get implements EmbeddingCache
+func (c *DiskEmbeddingCache) Get(ctx context.Context, key string) ([]float32, error) {
+	name := diskEmbeddingName(key)
+	c.mu.Lock()
+	element, ok := c.entries[name]
+	if ok {
+		c.order.MoveToFront(element)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	path := filepath.Join(c.dir, name)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.forget(name)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached embedding: %w", err)
+	}
+	var stored diskEmbedding
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to read cached embedding %s: %w", path, err)
+	}
+	embedder, _, _ := strings.Cut(key, "\x00")
+	if stored.Key != key || stored.Embedder != embedder {
+		return nil, nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now) // Recency only orders eviction after a restart
+	return stored.Embedding, nil
+}
+
+// Put implements EmbeddingCache, evicting the least recently used embeddings when full
+func (c *DiskEmbeddingCache) Put(ctx context.Context, key string, embedding []float32) error {
+	embedder, _, _ := strings.Cut(key, "\x00")
+	data, err := json.Marshal(diskEmbedding{Embedder: embedder, Key: key, Embedding: embedding})
+	if err != nil {
+		return fmt.Errorf("failed to encode embedding: %w", err)
+	}
+	name := diskEmbeddingName(key)
+	// Write then rename, so readers never see a partial file
+	tmp, err := os.CreateTemp(c.dir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to cache embedding: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache embedding: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache embedding: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to cache embedding: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[name] = c.order.PushFront(name)
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used files beyond maxEntries. The caller holds c.mu.
+func (c *DiskEmbeddingCache) evict() error {
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		name := oldest.Value.(string)
+		c.order.Remove(oldest)
+		delete(c.entries, name)
+		if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to evict cached embedding: %w", err)
+		}
+	}
+	return nil
+}
+
+// forget drops a file removed behind the cache's back
+func (c *DiskEmbeddingCache) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[name]; ok {
+		c.order.Remove(element)
+		delete(c.entries, name)
+	}
+}
+
+// diskEmbeddingName returns the file name of the key's embedding
+func diskEmbeddingName(key string) string {
+	return contentHash(key) + diskEmbeddingSuffix
+}
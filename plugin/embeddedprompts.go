@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Sources of the default prompts, as logged when they're loaded
+const (
+	PromptSourceDirectory = "directory" // Loaded by genkit from the prompts directory
+	PromptSourceEmbedded  = "embedded"  // Built into the plugin
+)
+
+// embeddedPromptNamespace is the genkit namespace the embedded prompts register under,
+// keeping them apart from the directory's prompts of the same name
+const embeddedPromptNamespace = "agentic-rag-embedded"
+
+// defaultPrompts holds copies of the default prompts of the prompts directory and their
+// partials, used when the directory doesn't provide them
+//
+//go:embed all:defaultprompts
+var defaultPrompts embed.FS
+
+// embeddedPromptKeys are the prompts with an embedded default
+var embeddedPromptKeys = []string{"relevance_scoring", "response_generation", "knowledge_extraction", "fact_verification"}
+
+// loadEmbeddedPrompts loads the embedded default of each default prompt genkit didn't
+// load from the prompts directory, logging where each prompt came from. With
+// PromptsConfig.RequireDirectory, a prompt missing from the directory is an error
+// instead. Directory prompts always take precedence.
+func (p *AgenticRAGProcessor) loadEmbeddedPrompts(ctx context.Context) error {
+	g := p.config.Genkit
+	var missing []string
+	for _, key := range embeddedPromptKeys {
+		name := p.config.Prompts.promptNames()[key]
+		if p.inline[name] != nil {
+			continue
+		}
+		if genkit.LookupPrompt(g, name) != nil {
+			slog.InfoContext(ctx, "loaded prompt", "prompt", name, "source", PromptSourceDirectory)
+			continue
+		}
+		missing = append(missing, key)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if p.config.Prompts.RequireDirectory {
+		return fmt.Errorf("%w: prompts %s not found in prompts directory %q", ErrPromptNotFound, strings.Join(missing, ", "), p.config.Prompts.Directory)
+	}
+
+	p.embedded = make(map[string]*ai.Prompt, len(missing))
+	for _, key := range missing {
+		source, err := defaultPrompts.ReadFile("defaultprompts/" + key + ".prompt")
+		if err != nil {
+			return fmt.Errorf("failed to load embedded prompt %s: %w", key, err)
+		}
+		prompt, err := loadPromptSource(g, embeddedPromptNamespace, key, string(source))
+		if err != nil {
+			return fmt.Errorf("failed to load embedded prompt %s: %w", key, err)
+		}
+		p.embedded[key] = prompt
+		slog.InfoContext(ctx, "loaded prompt", "prompt", p.config.Prompts.promptNames()[key], "source", PromptSourceEmbedded)
+	}
+	return nil
+}
+
+// loadPromptSource registers the dotprompt source as the named prompt in namespace,
+// returning the registered prompt if the namespace already has it
+func loadPromptSource(g *genkit.Genkit, namespace, name, source string) (*ai.Prompt, error) {
+	if prompt := genkit.LookupPrompt(g, namespace+"/"+name); prompt != nil {
+		return prompt, nil
+	}
+
+	// genkit only loads prompts from disk, so the source is written out first
+	dir, err := os.MkdirTemp("", "agentic-rag-prompts-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name+".prompt")
+	if err := os.WriteFile(path, []byte(source), 0o600); err != nil {
+		return nil, err
+	}
+	prompt, err := genkit.LoadPrompt(g, path, namespace)
+	if err == nil && prompt == nil {
+		err = errors.New("invalid dotprompt")
+	}
+	return prompt, err
+}
@@ -0,0 +1,299 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+// Error classes attached to model call failures. Use errors.Is to test for them.
+var (
+	// ErrClassUser marks malformed requests or configuration; retrying or failing over won't help
+	ErrClassUser = errors.New("user error")
+	// ErrClassProvider marks provider-side failures such as rate limits and outages; eligible for retry and failover
+	ErrClassProvider = errors.New("provider error")
+	// ErrClassContent marks inputs that exceed model limits; handled by shrinking the input or escalating the model
+	ErrClassContent = errors.New("content error")
+)
+
+// ErrBudgetExceeded is returned when a request's token budget runs out before an answer can be produced
+var ErrBudgetExceeded = errors.New("token budget exceeded")
+
+// ErrTooManyChunkFailures is returned when more of a stage's chunks fail than
+// Processing.MaxChunkFailureRate allows. The error also wraps each chunk's error.
+var ErrTooManyChunkFailures = errors.New("too many chunk failures")
+
+// ErrStageVetoed is returned when an OnStageEnd hook stops the request. The error also
+// wraps the hook's error.
+var ErrStageVetoed = errors.New("stage vetoed")
+
+// ErrPromptNotFound is returned when PromptsConfig.RequireDirectory is set and the
+// prompts directory lacks one of the default prompts
+var ErrPromptNotFound = errors.New("prompt not found")
+
+// Errors returned by JobManager
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrJobNotFinished   = errors.New("job not finished")
+	ErrJobQueueFull     = errors.New("job queue full")
+	ErrJobManagerClosed = errors.New("job manager closed")
+)
+
+// ErrPartialResult marks a request cancelled or timed out after some stages completed,
+// see PartialResultError
+var ErrPartialResult = errors.New("partial result")
+
+// PartialResultError is returned by Process when AllowPartial is set and the request
+// is cancelled or times out. Response holds whatever the completed stages produced.
+type PartialResultError struct {
+	Response *AgenticRAGResponse // Response built from the completed stages
+	Cause    error               // Why the context was cancelled
+	Err      error               // Error of the interrupted stage
+}
+
+// Error implements the error interface
+func (e *PartialResultError) Error() string {
+	return fmt.Sprintf("%v after %v: %v", ErrPartialResult, e.Cause, e.Err)
+}
+
+// Unwrap exposes ErrPartialResult, the cancellation cause and the stage error to
+// errors.Is and errors.As
+func (e *PartialResultError) Unwrap() []error {
+	return []error{ErrPartialResult, e.Cause, e.Err}
+}
+
+// ErrUnsupportedClaims marks an answer rejected under UnsupportedClaimPolicyFail, see
+// UnsupportedClaimsError
+var ErrUnsupportedClaims = errors.New("unsupported claims")
+
+// UnsupportedClaimsError is returned by Process when the answer makes claims without
+// evidence and the unsupported claim policy is UnsupportedClaimPolicyFail
+type UnsupportedClaimsError struct {
+	Claims []Claim // Claims refuted or lacking evidence, in answer order
+}
+
+// Error implements the error interface
+func (e *UnsupportedClaimsError) Error() string {
+	texts := make([]string, len(e.Claims))
+	for i, claim := range e.Claims {
+		texts[i] = fmt.Sprintf("%q (%s)", claim.Text, claim.Verdict)
+	}
+	return fmt.Sprintf("%v: %s", ErrUnsupportedClaims, strings.Join(texts, ", "))
+}
+
+// Unwrap exposes ErrUnsupportedClaims to errors.Is
+func (e *UnsupportedClaimsError) Unwrap() error {
+	return ErrUnsupportedClaims
+}
+
+// StructuredOutputError is returned when the model's answer still doesn't parse as JSON
+// matching the response schema after a corrective retry
+type StructuredOutputError struct {
+	Raw string // Text the model returned
+	Err error  // Why it was rejected
+}
+
+// Error implements the error interface
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("invalid structured answer: %v", e.Err)
+}
+
+// Unwrap returns the rejection reason
+func (e *StructuredOutputError) Unwrap() error {
+	return e.Err
+}
+
+// ErrRetrieverUnavailable is wrapped by store errors when the store can't be reached or
+// fails on its side, so requests need documents until it is back
+var ErrRetrieverUnavailable = errors.New("retriever unavailable")
+
+// Errors of Qdrant requests, see QdrantError
+var (
+	ErrQdrantCollectionNotFound = errors.New("qdrant collection not found")
+	ErrQdrantDimensionMismatch  = errors.New("qdrant dimension mismatch")
+)
+
+// QdrantError is returned when a QdrantStore request fails, with a hint on how to fix
+// the failures it recognizes
+type QdrantError struct {
+	Op      string // What was requested, e.g. "search"
+	Status  int    // HTTP status, 0 if the request wasn't answered
+	Message string // Qdrant's error message
+	Hint    string // How to fix the failure, empty when unknown
+	Kind    error  // ErrQdrantCollectionNotFound, ErrQdrantDimensionMismatch, ErrRetrieverUnavailable or nil
+}
+
+// Error implements the error interface
+func (e *QdrantError) Error() string {
+	message := fmt.Sprintf("qdrant %s failed", e.Op)
+	if e.Status != 0 {
+		message += fmt.Sprintf(" with status %d", e.Status)
+	}
+	message += ": " + e.Message
+	if e.Hint != "" {
+		message += " (" + e.Hint + ")"
+	}
+	return message
+}
+
+// Unwrap exposes the kind of failure to errors.Is
+func (e *QdrantError) Unwrap() error {
+	return e.Kind
+}
+
+// ChromaError is returned when a ChromaStore request fails, with a hint on how to fix
+// the failures it recognizes
+type ChromaError struct {
+	Op      string // What was requested, e.g. "query"
+	Status  int    // HTTP status, 0 if the request wasn't answered
+	Message string // Chroma's error message
+	Hint    string // How to fix the failure, empty when unknown
+	Kind    error  // ErrRetrieverUnavailable or nil
+}
+
+// Error implements the error interface
+func (e *ChromaError) Error() string {
+	message := fmt.Sprintf("chroma %s failed", e.Op)
+	if e.Status != 0 {
+		message += fmt.Sprintf(" with status %d", e.Status)
+	}
+	message += ": " + e.Message
+	if e.Hint != "" {
+		message += " (" + e.Hint + ")"
+	}
+	return message
+}
+
+// Unwrap exposes the kind of failure to errors.Is
+func (e *ChromaError) Unwrap() error {
+	return e.Kind
+}
+
+// ModelError wraps a failed model call with its error class and the model that produced it
+type ModelError struct {
+	Class error  // One of ErrClassUser, ErrClassProvider or ErrClassContent
+	Model string // Model that was called, empty when unknown
+	Err   error  // Underlying error
+}
+
+// Error implements the error interface
+func (e *ModelError) Error() string {
+	if e.Model == "" {
+		return fmt.Sprintf("%v: %v", e.Class, e.Err)
+	}
+	return fmt.Sprintf("%v from model %s: %v", e.Class, e.Model, e.Err)
+}
+
+// Unwrap exposes both the error class and the underlying error to errors.Is and errors.As
+func (e *ModelError) Unwrap() []error {
+	return []error{e.Class, e.Err}
+}
+
+// contentLimitMarkers are message fragments providers use when the input is too large
+var contentLimitMarkers = []string{
+	"context length",
+	"context window",
+	"token limit",
+	"too many tokens",
+	"input is too long",
+	"exceeds the maximum",
+	"maximum number of tokens",
+	"request payload size",
+}
+
+// userErrorMarkers are message fragments for errors caused by the request itself
+var userErrorMarkers = []string{
+	"invalid_argument",
+	"invalid argument",
+	"permission_denied",
+	"unauthenticated",
+	"api key",
+	"not_found",
+	"not found",
+	"failed_precondition",
+	"unsupported",
+}
+
+// classifyError wraps err in a ModelError carrying its error class. Errors that are
+// already classified and context errors are returned unchanged.
+func classifyError(model string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var modelErr *ModelError
+	if errors.As(err, &modelErr) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	return &ModelError{Class: errorClass(err), Model: model, Err: err}
+}
+
+// errorClass determines the class of an unclassified error
+func errorClass(err error) error {
+	message := strings.ToLower(err.Error())
+
+	// Content limits are often reported as invalid arguments, so check them first
+	for _, marker := range contentLimitMarkers {
+		if strings.Contains(message, marker) {
+			return ErrClassContent
+		}
+	}
+
+	var genkitErr *core.GenkitError
+	if errors.As(err, &genkitErr) {
+		switch genkitErr.Status {
+		case core.INVALID_ARGUMENT, core.NOT_FOUND, core.PERMISSION_DENIED,
+			core.UNAUTHENTICATED, core.FAILED_PRECONDITION, core.UNIMPLEMENTED:
+			return ErrClassUser
+		case core.OUT_OF_RANGE:
+			return ErrClassContent
+		default:
+			return ErrClassProvider
+		}
+	}
+
+	var userErr *core.UserFacingError
+	if errors.As(err, &userErr) && userErr.Status == core.INVALID_ARGUMENT {
+		return ErrClassUser
+	}
+
+	for _, marker := range userErrorMarkers {
+		if strings.Contains(message, marker) {
+			return ErrClassUser
+		}
+	}
+
+	// Everything else (rate limits, 5xx, network failures) is the provider's problem
+	return ErrClassProvider
+}
+
+// quotaMarkers are message fragments providers use when a quota is exhausted
+var quotaMarkers = []string{
+	"resource_exhausted",
+	"resource exhausted",
+	"quota",
+}
+
+// isQuotaExhausted reports whether err means the model's quota or rate limit is used up
+func isQuotaExhausted(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var genkitErr *core.GenkitError
+	if errors.As(err, &genkitErr) && genkitErr.Status == core.RESOURCE_EXHAUSTED {
+		return true
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, marker := range quotaMarkers {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/firebase/genkit/go/core"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		class error
+	}{
+		// User errors
+		{"invalid argument message", errors.New("INVALID_ARGUMENT: unknown field"), ErrClassUser},
+		{"api key", errors.New("API key not valid. Please pass a valid API key."), ErrClassUser},
+		{"model not found", errors.New("models/gemini-9 is not found for API version v1beta"), ErrClassUser},
+		{"unauthenticated", errors.New("rpc error: code = Unauthenticated desc = UNAUTHENTICATED"), ErrClassUser},
+		{"unsupported", errors.New("model does not support tools: unsupported"), ErrClassUser},
+		{"genkit invalid argument", core.NewError(core.INVALID_ARGUMENT, "bad schema"), ErrClassUser},
+		{"genkit permission denied", core.NewError(core.PERMISSION_DENIED, "denied"), ErrClassUser},
+		{"genkit unimplemented", core.NewError(core.UNIMPLEMENTED, "streaming"), ErrClassUser},
+		{"public invalid argument", core.NewPublicError(core.INVALID_ARGUMENT, "bad input", nil), ErrClassUser},
+
+		// Provider errors
+		{"rate limit", errors.New("429 Too Many Requests: rate limit reached"), ErrClassProvider},
+		{"server error", errors.New("500 Internal Server Error"), ErrClassProvider},
+		{"network", errors.New("dial tcp 10.0.0.1:443: connection refused"), ErrClassProvider},
+		{"genkit unavailable", core.NewError(core.UNAVAILABLE, "overloaded"), ErrClassProvider},
+		{"genkit resource exhausted", core.NewError(core.RESOURCE_EXHAUSTED, "quota"), ErrClassProvider},
+		{"genkit status over marker", core.NewError(core.INTERNAL, "not found upstream"), ErrClassProvider},
+		{"public internal", core.NewPublicError(core.INTERNAL, "failed", nil), ErrClassProvider},
+
+		// Content errors
+		{"context length", errors.New("This model's maximum context length is 8192 tokens"), ErrClassContent},
+		{"token limit as invalid argument", errors.New("INVALID_ARGUMENT: input token limit exceeded"), ErrClassContent},
+		{"payload size", errors.New("request payload size exceeds the limit"), ErrClassContent},
+		{"genkit out of range", core.NewError(core.OUT_OF_RANGE, "too large"), ErrClassContent},
+		{"genkit invalid argument with limit", core.NewError(core.INVALID_ARGUMENT, "prompt exceeds the maximum size"), ErrClassContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError("test-model", tt.err)
+			var modelErr *ModelError
+			if !errors.As(err, &modelErr) {
+				t.Fatalf("classifyError returned %T, want *ModelError", err)
+			}
+			if modelErr.Class != tt.class {
+				t.Errorf("class = %v, want %v", modelErr.Class, tt.class)
+			}
+			if modelErr.Model != "test-model" {
+				t.Errorf("model = %q, want test-model", modelErr.Model)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("classified error doesn't wrap the original")
+			}
+		})
+	}
+}
+
+func TestClassifyErrorUnchanged(t *testing.T) {
+	classified := &ModelError{Class: ErrClassUser, Model: "first", Err: errors.New("bad request")}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"nil", nil},
+		{"canceled", context.Canceled},
+		{"deadline", fmt.Errorf("stage failed: %w", context.DeadlineExceeded)},
+		{"already classified", classified},
+		{"wrapped classified", fmt.Errorf("retry: %w", classified)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := classifyError("second", tt.err); err != tt.err {
+				t.Errorf("classifyError = %v, want the error unchanged", err)
+			}
+		})
+	}
+}
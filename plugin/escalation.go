@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+)
+
+// defaultContextWindows holds the input context window, in tokens, of well-known models
+var defaultContextWindows = map[string]int{
+	"gemini-2.5-pro":        1048576,
+	"gemini-2.5-flash":      1048576,
+	"gemini-2.5-flash-lite": 1048576,
+	"gemini-2.0-flash":      1048576,
+	"gemini-2.0-flash-lite": 1048576,
+	"gemini-1.5-pro":        2097152,
+	"gemini-1.5-flash":      1048576,
+	"gemini-1.5-flash-8b":   1048576,
+}
+
+// Escalation reasons reported in ModelEscalation.Reason
+const (
+	EscalationReasonPreflight    = "preflight_overflow"   // Estimated prompt size exceeded the model's window
+	EscalationReasonContextError = "context_length_error" // The model rejected the prompt as too large
+)
+
+// estimateTokens approximates the token count of text the way subword tokenizers
+// split it: about four characters per token for words, one token per CJK character
+// and one per symbol, which keeps CJK and code-heavy text from being undercounted
+func estimateTokens(text string) int {
+	tokens, word := 0, 0
+	endWord := func() {
+		tokens += (word + 3) / 4
+		word = 0
+	}
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			endWord()
+			tokens++
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			word++
+		case unicode.IsSpace(r):
+			endWord()
+		default:
+			endWord()
+			tokens++
+		}
+	}
+	endWord()
+	return tokens
+}
+
+// estimateInputTokens approximates the token count of a prompt input map
+func estimateInputTokens(input map[string]any) int {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return 0
+	}
+	return estimateTokens(string(data))
+}
+
+// bareModelName strips the provider prefix from a model name ("googleai/gemini-2.5-flash" -> "gemini-2.5-flash")
+func bareModelName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// contextWindow returns the context window of the named model, or 0 if unknown
+func (p *AgenticRAGProcessor) contextWindow(name string) int {
+	windows := p.config.ContextEscalation.ContextWindows
+	if window, ok := windows[name]; ok {
+		return window
+	}
+	if window, ok := windows[bareModelName(name)]; ok {
+		return window
+	}
+	return defaultContextWindows[bareModelName(name)]
+}
+
+// exceedsContextWindow reports whether a prompt of the estimated size won't fit the named model
+func (p *AgenticRAGProcessor) exceedsContextWindow(name string, estimatedTokens int) bool {
+	window := p.contextWindow(name)
+	return window > 0 && estimatedTokens > window
+}
+
+// escalationPath returns the models to escalate to from the given model: the models
+// listed after it in the escalation list, or the whole list if it isn't listed
+func (p *AgenticRAGProcessor) escalationPath(from string) []string {
+	models := p.config.ContextEscalation.Models
+	for i, name := range models {
+		if name == from || bareModelName(name) == bareModelName(from) {
+			return models[i+1:]
+		}
+	}
+	return models
+}
@@ -0,0 +1,123 @@
+package plugin
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// evidenceMatchThreshold is the share of an evidence quote's words that must appear
+// in a window of the chunk for the quote to be located there
+const evidenceMatchThreshold = 0.8
+
+// EvidenceSpan is where a claim's evidence was found in the chunks
+type EvidenceSpan struct {
+	ChunkID    string `json:"chunk_id"`
+	DocumentID string `json:"document_id"`
+	CharStart  int    `json:"char_start"`     // Start offset in the document text
+	CharEnd    int    `json:"char_end"`       // End offset in the document text
+	Page       int    `json:"page,omitempty"` // Page the chunk is on, for paged documents
+	Text       string `json:"text"`           // The located text, as it appears in the chunk
+	Quote      string `json:"quote"`          // The evidence as the model quoted it
+}
+
+// evidenceToken is a lowercased word of a text with its byte range
+type evidenceToken struct {
+	word       string
+	start, end int
+}
+
+// evidenceTokens splits text into its lowercased words
+func evidenceTokens(text string) []evidenceToken {
+	var tokens []evidenceToken
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, evidenceToken{word: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, evidenceToken{word: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+// locateEvidence returns the byte range of the quote in content. An exact match is
+// tried first, ignoring case; otherwise the quote is located in the window of
+// content's words containing the largest share of the quote's words, at least
+// evidenceMatchThreshold, so light paraphrases and changed punctuation still match.
+func locateEvidence(quote, content string) (int, int, bool) {
+	quote = strings.Trim(strings.TrimSpace(quote), `"'“”‘’`)
+	if quote == "" {
+		return 0, 0, false
+	}
+	lower, lowerQuote := strings.ToLower(content), strings.ToLower(quote)
+	if len(lower) == len(content) && len(lowerQuote) == len(quote) {
+		if i := strings.Index(lower, lowerQuote); i >= 0 && utf8.RuneStart(content[i]) {
+			return i, i + len(quote), true
+		}
+	}
+
+	words := evidenceTokens(content)
+	wanted := evidenceTokens(quote)
+	n := len(wanted)
+	if n == 0 || len(words) == 0 {
+		return 0, 0, false
+	}
+	bestScore, bestStart, bestEnd := 0.0, 0, 0
+	maxSize := min(n+n/4, len(words))
+	for size := min(max(1, n-n/5), maxSize); size <= maxSize; size++ {
+		for i := 0; i+size <= len(words); i++ {
+			end := i + size
+			counts := make(map[string]int, end-i)
+			for _, word := range words[i:end] {
+				counts[word.word]++
+			}
+			matched := 0
+			for _, word := range wanted {
+				if counts[word.word] > 0 {
+					counts[word.word]--
+					matched++
+				}
+			}
+			if score := float64(matched) / float64(n); score > bestScore {
+				bestScore, bestStart, bestEnd = score, words[i].start, words[end-1].end
+			}
+		}
+	}
+	if bestScore < evidenceMatchThreshold {
+		return 0, 0, false
+	}
+	return bestStart, bestEnd, true
+}
+
+// evidenceSpan locates a quote in the chunk, with offsets in the chunk's document text
+// when the document is known and from the chunk's start index otherwise
+func evidenceSpan(quote string, chunk DocumentChunk, documents []Document) (EvidenceSpan, bool) {
+	start, end, ok := locateEvidence(quote, chunk.Content)
+	if !ok {
+		return EvidenceSpan{}, false
+	}
+	offset := chunk.StartIndex
+	for _, doc := range documents {
+		if doc.ID == chunk.DocumentID {
+			offset = chunkOffset(chunk, documentText(doc))
+			break
+		}
+	}
+	return EvidenceSpan{
+		ChunkID:    chunk.ID,
+		DocumentID: chunk.DocumentID,
+		CharStart:  offset + start,
+		CharEnd:    offset + end,
+		Page:       chunk.Page,
+		Text:       chunk.Content[start:end],
+		Quote:      quote,
+	}, true
+}
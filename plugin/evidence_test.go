@@ -0,0 +1,128 @@
+package plugin
+
+import "testing"
+
+// evidenceChunk is the chunk the matcher tests locate quotes in
+const evidenceChunk = "The Eiffel Tower is a wrought-iron lattice tower on the Champ de Mars in Paris, France. " +
+	"It is named after the engineer Gustave Eiffel, whose company designed and built the tower from 1887 to 1889. " +
+	"Locally nicknamed \"La dame de fer\", it was constructed as the centerpiece of the 1889 World's Fair."
+
+func TestLocateEvidence(t *testing.T) {
+	tests := []struct {
+		name  string
+		quote string
+		want  string // The located text, empty when the quote isn't found
+	}{
+		{
+			name:  "verbatim",
+			quote: "whose company designed and built the tower from 1887 to 1889",
+			want:  "whose company designed and built the tower from 1887 to 1889",
+		},
+		{
+			name:  "case and quotes",
+			quote: `“THE EIFFEL TOWER IS A WROUGHT-IRON LATTICE TOWER”`,
+			want:  "The Eiffel Tower is a wrought-iron lattice tower",
+		},
+		{
+			name:  "changed punctuation",
+			quote: "named after the engineer, Gustave Eiffel; whose company designed and built the tower",
+			want:  "named after the engineer Gustave Eiffel, whose company designed and built the tower",
+		},
+		{
+			name:  "one word changed",
+			quote: "whose firm designed and built the tower from 1887 to 1889",
+			want:  "whose company designed and built the tower from 1887 to 1889",
+		},
+		{
+			name:  "word dropped",
+			quote: "it was constructed as the centerpiece of the World's Fair",
+			want:  "it was constructed as the centerpiece of the 1889 World's Fair",
+		},
+		{
+			name:  "words inserted",
+			quote: "a wrought-iron lattice tower located on the Champ de Mars in central Paris",
+			want:  "a wrought-iron lattice tower on the Champ de Mars in Paris",
+		},
+		{
+			name:  "reordered clause",
+			quote: "the tower was designed and built by Gustave Eiffel's company from 1887 to 1889",
+			want:  "Gustave Eiffel, whose company designed and built the tower from 1887 to 1889",
+		},
+		{
+			name:  "loose paraphrase",
+			quote: "Gustave Eiffel's firm erected the structure in two years",
+			want:  "",
+		},
+		{
+			name:  "not in the chunk",
+			quote: "The Statue of Liberty was a gift from France",
+			want:  "",
+		},
+		{
+			name:  "empty",
+			quote: `  ""  `,
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, ok := locateEvidence(tt.quote, evidenceChunk)
+			if tt.want == "" {
+				if ok {
+					t.Errorf("located %q, want no match", evidenceChunk[start:end])
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("quote not located, want %q", tt.want)
+			}
+			if got := evidenceChunk[start:end]; got != tt.want {
+				t.Errorf("located %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvidenceSpanOffsets(t *testing.T) {
+	doc := Document{ID: "doc_1", Content: "Preface. " + evidenceChunk}
+	chunk := DocumentChunk{ID: "chunk_1", DocumentID: "doc_1", Content: evidenceChunk, StartIndex: 9, EndIndex: len(doc.Content)}
+
+	quote := "named after the engineer Gustave Eiffel"
+	span, ok := evidenceSpan(quote, chunk, []Document{doc})
+	if !ok {
+		t.Fatalf("quote not located")
+	}
+	if got := doc.Content[span.CharStart:span.CharEnd]; got != quote {
+		t.Errorf("document offsets [%d:%d] give %q, want %q", span.CharStart, span.CharEnd, got, quote)
+	}
+	if span.ChunkID != "chunk_1" || span.DocumentID != "doc_1" || span.Text != quote || span.Quote != quote {
+		t.Errorf("span = %+v", span)
+	}
+
+	// Without the document the offsets count from the chunk's start index
+	span, ok = evidenceSpan(quote, chunk, nil)
+	if !ok || doc.Content[span.CharStart:span.CharEnd] != quote {
+		t.Errorf("span without the document = %+v, %v", span, ok)
+	}
+
+	if _, ok := evidenceSpan("The Statue of Liberty", chunk, []Document{doc}); ok {
+		t.Errorf("located a quote the chunk doesn't contain")
+	}
+}
+
+func TestEvidenceSpanAcrossParagraphs(t *testing.T) {
+	doc, chunks := chunkParagraphs(t, 80)
+	chunk := chunks[1]
+
+	for _, quote := range []string{"Kafka runs on the JVM today", "completed in 1889 for the fair. Kafka runs on the JVM"} {
+		for _, documents := range [][]Document{{doc}, nil} {
+			span, ok := evidenceSpan(quote, chunk, documents)
+			if !ok {
+				t.Fatalf("quote %q not located", quote)
+			}
+			if got := doc.Content[span.CharStart:span.CharEnd]; got != span.Text {
+				t.Errorf("quote %q with %d documents at [%d:%d] = %q, want %q", quote, len(documents), span.CharStart, span.CharEnd, got, span.Text)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph expansion defaults, see AgenticRAGOptions.EnableGraphExpansion
+const (
+	defaultGraphExpansionHops   = 1
+	maxGraphExpansionHops       = 2
+	defaultGraphExpansionChunks = 3
+)
+
+// GraphExpansion records a chunk added to the relevant chunks because it mentions an
+// entity related to one in the query
+type GraphExpansion struct {
+	ChunkID     string   `json:"chunk_id"`
+	Entity      string   `json:"entity"`       // Related entity the chunk mentions
+	EntityID    string   `json:"entity_id"`    // Stable ID of the related entity
+	QueryEntity string   `json:"query_entity"` // Entity of the query the walk started from
+	Predicates  []string `json:"predicates"`   // Relations walked from the query entity, in order
+	Reason      string   `json:"reason"`       // Human-readable provenance, also set on citations of the chunk
+}
+
+// expandWithGraph adds chunks that weren't selected but mention entities related to
+// those named in the query, walking the conversation's graph and the graph store.
+// Related entities are taken nearest first, each adding at most one chunk, until
+// MaxGraphExpansionChunks chunks were added. Returns the chunks with the additions
+// appended and their provenance.
+func (p *AgenticRAGProcessor) expandWithGraph(ctx context.Context, query string, options AgenticRAGOptions, session *Session, selected, candidates []DocumentChunk) ([]DocumentChunk, []GraphExpansion, error) {
+	hops := options.GraphExpansionHops
+	if hops <= 0 {
+		hops = defaultGraphExpansionHops
+	}
+	hops = min(hops, maxGraphExpansionHops)
+	limit := options.MaxGraphExpansionChunks
+	if limit <= 0 {
+		limit = defaultGraphExpansionChunks
+	}
+	filter := RelationFilter{MinConfidence: p.config.KnowledgeGraph.MinConfidenceThreshold}
+
+	// Related entities of each graph, with the query entity the walk started from
+	type related struct {
+		queryEntity string
+		neighbor    Neighbor
+	}
+	var neighbors []related
+	lowered := strings.ToLower(query)
+	if session != nil && session.KnowledgeGraph != nil {
+		for _, entity := range session.KnowledgeGraph.Entities {
+			if name := strings.ToLower(entity.Name); name == "" || !strings.Contains(lowered, name) {
+				continue
+			}
+			for _, neighbor := range session.KnowledgeGraph.Neighbors(entity.Name, hops, filter) {
+				neighbors = append(neighbors, related{queryEntity: entity.Name, neighbor: neighbor})
+			}
+		}
+	}
+	if store := p.config.KnowledgeGraph.Store; store != nil {
+		matched, err := store.Query(ctx, query)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query graph store: %w", err)
+		}
+		for _, entity := range matched.Entities {
+			found, err := store.Neighbors(ctx, entity.Name, hops, filter)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to walk graph store: %w", err)
+			}
+			for _, neighbor := range found {
+				neighbors = append(neighbors, related{queryEntity: entity.Name, neighbor: neighbor})
+			}
+		}
+	}
+	if len(neighbors) == 0 {
+		return selected, nil, nil
+	}
+	// Nearest first, keeping the order each graph was walked in
+	sort.SliceStable(neighbors, func(i, j int) bool {
+		return neighbors[i].neighbor.Depth < neighbors[j].neighbor.Depth
+	})
+
+	used := make(map[string]bool, len(selected))
+	for _, chunk := range selected {
+		used[chunk.ID] = true
+	}
+	expanded := append([]DocumentChunk(nil), selected...)
+	var expansions []GraphExpansion
+	walked := make(map[string]bool)
+	for _, candidate := range neighbors {
+		if len(expansions) >= limit {
+			break
+		}
+		name := strings.ToLower(candidate.neighbor.Entity.Name)
+		if name == "" || walked[name] || strings.Contains(lowered, name) {
+			continue // Entities of the query itself were already retrieved for
+		}
+		walked[name] = true
+		for _, chunk := range candidates {
+			if used[chunk.ID] || !strings.Contains(strings.ToLower(chunk.Content), name) {
+				continue
+			}
+			predicates := make([]string, len(candidate.neighbor.Path))
+			for i, relation := range candidate.neighbor.Path {
+				predicates[i] = relation.Predicate
+			}
+			used[chunk.ID] = true
+			expanded = append(expanded, chunk)
+			expansions = append(expansions, GraphExpansion{
+				ChunkID:     chunk.ID,
+				Entity:      candidate.neighbor.Entity.Name,
+				EntityID:    candidate.neighbor.Entity.ID,
+				QueryEntity: candidate.queryEntity,
+				Predicates:  predicates,
+				Reason: fmt.Sprintf("included because it mentions %s, related to %s via %s",
+					candidate.neighbor.Entity.Name, candidate.queryEntity, strings.Join(predicates, ", ")),
+			})
+			break
+		}
+	}
+	return expanded, expansions, nil
+}
+
+// expansionReason returns the provenance of a chunk added by graph expansion, or of
+// the chunk it was refined from
+func expansionReason(expansions []GraphExpansion, chunkID string) string {
+	for {
+		for _, expansion := range expansions {
+			if expansion.ChunkID == chunkID {
+				return expansion.Reason
+			}
+		}
+		i := strings.LastIndex(chunkID, "_sub_")
+		if i < 0 {
+			return ""
+		}
+		chunkID = chunkID[:i]
+	}
+}
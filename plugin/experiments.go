@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+)
+
+// PromptExperiment splits requests between variants of a prompt by weight
+type PromptExperiment struct {
+	Name     string             `json:"name,omitempty"` // Name passed to the assignment hooks, also salting the sampling; defaults to the prompt key
+	Variants map[string]float64 `json:"variants"`       // Weight per variant, "default" for the prompt without a variant
+}
+
+// ExperimentAssignment is the variant a request was assigned in a prompt experiment
+type ExperimentAssignment struct {
+	Experiment string // PromptExperiment.Name, or the prompt key if unnamed
+	Prompt     string // Key of the prompt, e.g. "response_generation"
+	Variant    string // Variant assigned, "default" for the prompt without a variant
+	RequestID  string // ID of the request, also in ProcessingMetadata.RequestID
+	Seed       string // What the assignment was derived from, see AgenticRAGOptions.ExperimentSeed
+}
+
+// ExperimentHook observes the variants a request is assigned, e.g. to join them with
+// quality metrics
+type ExperimentHook func(ctx context.Context, assignment ExperimentAssignment)
+
+// OnExperimentAssignment adds a hook called for each experiment a request is assigned
+// a variant in, before the pipeline starts. Requests selecting the prompt's variant
+// explicitly aren't assigned. Hooks run synchronously in the order added; a panicking
+// hook is reported in ProcessingMetadata.Warnings.
+func OnExperimentAssignment(hook ExperimentHook) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.experimentHooks = append(p.experimentHooks, hook) }
+}
+
+// experimentName returns the name of the prompt's experiment
+func (e PromptExperiment) experimentName(key string) string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return key
+}
+
+// sampleVariant picks a variant by weight, deterministically from the experiment name
+// and the seed so the same seed always gets the same variant
+func (e PromptExperiment) sampleVariant(key, seed string) string {
+	sum := sha256.Sum256([]byte(e.experimentName(key) + "\x00" + seed))
+	point := float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+
+	total := 0.0
+	for _, weight := range e.Variants {
+		total += weight
+	}
+	variants := sortedKeys(e.Variants)
+	cumulative := 0.0
+	for _, variant := range variants {
+		cumulative += e.Variants[variant] / total
+		if point < cumulative {
+			return variant
+		}
+	}
+	// Rounding can leave the point past the last boundary
+	for i := len(variants) - 1; i >= 0; i-- {
+		if e.Variants[variants[i]] > 0 {
+			return variants[i]
+		}
+	}
+	return promptVariantDefault
+}
+
+// assignExperiments assigns the request a variant in each configured experiment whose
+// prompt it doesn't select a variant for, and runs the assignment hooks. The seed is
+// AgenticRAGOptions.ExperimentSeed, else the query, so retries get the same variants.
+func (p *AgenticRAGProcessor) assignExperiments(ctx context.Context, request AgenticRAGRequest) {
+	experiments := p.config.Prompts.Experiments
+	if len(experiments) == 0 {
+		return
+	}
+	state := requestStateFrom(ctx)
+	seed := request.Options.ExperimentSeed
+	if seed == "" {
+		seed = request.Query
+	}
+	for _, key := range sortedKeys(experiments) {
+		if _, explicit := request.Options.PromptVariants[key]; explicit {
+			continue
+		}
+		experiment := experiments[key]
+		variant := experiment.sampleVariant(key, seed)
+		state.assignPromptVariant(key, variant)
+
+		assignment := ExperimentAssignment{
+			Experiment: experiment.experimentName(key),
+			Prompt:     key,
+			Variant:    variant,
+			RequestID:  state.id(),
+			Seed:       seed,
+		}
+		for _, hook := range p.experimentHooks {
+			runHook(state, "OnExperimentAssignment", key, func() error {
+				hook(ctx, assignment)
+				return nil
+			})
+		}
+	}
+}
+
+// validateExperiment checks the experiment of the prompt has variants with usable
+// weights
+func validateExperiment(field string, experiment PromptExperiment) []error {
+	var errs []error
+	if len(experiment.Variants) == 0 {
+		return []error{fieldError(field+".variants", "is required")}
+	}
+	total := 0.0
+	for _, variant := range sortedKeys(experiment.Variants) {
+		weight := experiment.Variants[variant]
+		if variant == "" {
+			errs = append(errs, fieldError(field+".variants", "variant must not be empty, use %q for the prompt without a variant", promptVariantDefault))
+		}
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			errs = append(errs, fieldError(field+".variants."+variant, "must be a non-negative weight, got %v", weight))
+			continue
+		}
+		total += weight
+	}
+	if total == 0 && len(errs) == 0 {
+		errs = append(errs, fieldError(field+".variants", "must have a positive weight"))
+	}
+	return errs
+}
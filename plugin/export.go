@@ -0,0 +1,252 @@
+package plugin
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// GraphExportOptions selects what ExportDOT and ExportGraphML write
+type GraphExportOptions struct {
+	MinConfidence     float64           // Leave out entities and relations below this confidence
+	EntityTypes       []string          // Only export entities of these types or their subtypes, case-insensitively (default: all)
+	TypeParents       map[string]string // Entity type hierarchy for EntityTypes, see KnowledgeGraphConfig.TypeParents
+	IncludeAttributes bool              // Add type, confidence, attributes and properties to node labels in DOT and as node data in GraphML
+}
+
+// exportNode is an entity as exported, or a relation endpoint without an entity
+type exportNode struct {
+	id     string
+	entity Entity
+	bare   bool // A relation endpoint without an entity, exported without attributes
+}
+
+// exportEdge is a relation between two exported nodes
+type exportEdge struct {
+	source, target string
+	relation       Relation
+}
+
+// exportGraph returns the nodes and edges to export, sorted so the output is stable
+// across runs. Relations whose endpoints don't name an entity by ID or name get a bare
+// node for the endpoint, unless entity types are filtered.
+func (kg *KnowledgeGraph) exportGraph(opts GraphExportOptions) ([]exportNode, []exportEdge) {
+	if kg == nil {
+		return nil, nil
+	}
+	matchesType := newEntityOntology(opts.TypeParents).filter(opts.EntityTypes)
+
+	nodes := make(map[string]exportNode)
+	byRef := make(map[string]string) // Entity ID and lowercased name to node ID
+	for _, entity := range kg.Entities {
+		if entity.Confidence < opts.MinConfidence || !matchesType(entity.Type) {
+			continue
+		}
+		id := entity.ID
+		if id == "" {
+			id = entity.Name
+		}
+		if _, exists := nodes[id]; exists {
+			continue
+		}
+		nodes[id] = exportNode{id: id, entity: entity}
+		if entity.ID != "" {
+			byRef[entity.ID] = id
+		}
+		if _, named := byRef[strings.ToLower(entity.Name)]; !named {
+			byRef[strings.ToLower(entity.Name)] = id
+		}
+	}
+	resolve := func(ref string) (string, bool) {
+		if id, ok := byRef[ref]; ok {
+			return id, true
+		}
+		if id, ok := byRef[strings.ToLower(ref)]; ok {
+			return id, true
+		}
+		if len(opts.EntityTypes) > 0 || ref == "" {
+			return "", false
+		}
+		if _, exists := nodes[ref]; !exists {
+			nodes[ref] = exportNode{id: ref, entity: Entity{Name: ref}, bare: true}
+		}
+		return ref, true
+	}
+
+	var edges []exportEdge
+	for _, relation := range kg.Relations {
+		if relation.Confidence < opts.MinConfidence {
+			continue
+		}
+		source, ok := resolve(relation.Subject)
+		if !ok {
+			continue
+		}
+		target, ok := resolve(relation.Object)
+		if !ok {
+			continue
+		}
+		edges = append(edges, exportEdge{source: source, target: target, relation: relation})
+	}
+
+	sorted := make([]exportNode, 0, len(nodes))
+	for _, id := range sortedKeys(nodes) {
+		sorted = append(sorted, nodes[id])
+	}
+	sort.SliceStable(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.source != b.source {
+			return a.source < b.source
+		}
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		return a.relation.Predicate < b.relation.Predicate
+	})
+	return sorted, edges
+}
+
+// ExportDOT writes the graph in Graphviz DOT format. Relation confidence becomes the
+// edge weight, scaled to an integer from 0 to 100 as the dot layout requires.
+func (kg *KnowledgeGraph) ExportDOT(w io.Writer, opts GraphExportOptions) error {
+	nodes, edges := kg.exportGraph(opts)
+
+	var out strings.Builder
+	out.WriteString("digraph knowledge_graph {\n")
+	for _, node := range nodes {
+		label := node.entity.Name
+		if opts.IncludeAttributes && !node.bare {
+			lines := []string{label}
+			if node.entity.Type != "" {
+				lines = append(lines, fmt.Sprintf("(%s)", node.entity.Type))
+			}
+			lines = append(lines, fmt.Sprintf("confidence: %.2f", node.entity.Confidence))
+			for _, key := range sortedKeys(node.entity.Attributes) {
+				lines = append(lines, fmt.Sprintf("%s: %s", key, node.entity.Attributes[key].Value))
+			}
+			for _, key := range sortedKeys(node.entity.Properties) {
+				lines = append(lines, fmt.Sprintf("%s: %v", key, node.entity.Properties[key]))
+			}
+			label = strings.Join(lines, "\n")
+		}
+		fmt.Fprintf(&out, "  %s [label=%s];\n", dotQuote(node.id), dotQuote(label))
+	}
+	for _, edge := range edges {
+		weight := int(math.Round(max(0, min(1, edge.relation.Confidence)) * 100))
+		label := edge.relation.Predicate
+		if when := temporalLabel(edge.relation.Temporal); when != "" {
+			label += " (" + when + ")"
+		}
+		fmt.Fprintf(&out, "  %s -> %s [label=%s, weight=%d];\n",
+			dotQuote(edge.source), dotQuote(edge.target), dotQuote(label), weight)
+	}
+	out.WriteString("}\n")
+
+	if _, err := io.WriteString(w, out.String()); err != nil {
+		return fmt.Errorf("failed to write DOT: %w", err)
+	}
+	return nil
+}
+
+// dotQuote returns s as a quoted DOT ID
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\r", "")
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// ExportGraphML writes the graph in GraphML, as read by Gephi. Nodes and edges carry
+// a label, and relation confidence is the edge weight.
+func (kg *KnowledgeGraph) ExportGraphML(w io.Writer, opts GraphExportOptions) error {
+	nodes, edges := kg.exportGraph(opts)
+
+	// Property and attribute keys are declared once, in a stable order
+	var properties, attributes []string
+	if opts.IncludeAttributes {
+		seen, seenAttributes := make(map[string]bool), make(map[string]bool)
+		for _, node := range nodes {
+			for key := range node.entity.Properties {
+				seen[key] = true
+			}
+			for key := range node.entity.Attributes {
+				seenAttributes[key] = true
+			}
+		}
+		properties, attributes = sortedKeys(seen), sortedKeys(seenAttributes)
+	}
+
+	var out strings.Builder
+	out.WriteString(xml.Header)
+	out.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	out.WriteString(`  <key id="label" for="node" attr.name="label" attr.type="string"/>` + "\n")
+	if opts.IncludeAttributes {
+		out.WriteString(`  <key id="type" for="node" attr.name="type" attr.type="string"/>` + "\n")
+		out.WriteString(`  <key id="confidence" for="node" attr.name="confidence" attr.type="double"/>` + "\n")
+		for i, key := range properties {
+			fmt.Fprintf(&out, "  <key id=\"p%d\" for=\"node\" attr.name=%s attr.type=\"string\"/>\n", i, xmlQuote(key))
+		}
+		for i, key := range attributes {
+			fmt.Fprintf(&out, "  <key id=\"a%d\" for=\"node\" attr.name=%s attr.type=\"string\"/>\n", i, xmlQuote("attr_"+key))
+		}
+	}
+	out.WriteString(`  <key id="edge_label" for="edge" attr.name="label" attr.type="string"/>` + "\n")
+	out.WriteString(`  <key id="weight" for="edge" attr.name="weight" attr.type="double"/>` + "\n")
+	for _, key := range []string{"date", "start_date", "end_date", "temporal_expression"} {
+		fmt.Fprintf(&out, "  <key id=%s for=\"edge\" attr.name=%s attr.type=\"string\"/>\n", xmlQuote(key), xmlQuote(key))
+	}
+	out.WriteString(`  <graph id="knowledge_graph" edgedefault="directed">` + "\n")
+
+	for _, node := range nodes {
+		fmt.Fprintf(&out, "    <node id=%s>\n", xmlQuote(node.id))
+		fmt.Fprintf(&out, "      <data key=\"label\">%s</data>\n", xmlText(node.entity.Name))
+		if opts.IncludeAttributes && !node.bare {
+			if node.entity.Type != "" {
+				fmt.Fprintf(&out, "      <data key=\"type\">%s</data>\n", xmlText(node.entity.Type))
+			}
+			fmt.Fprintf(&out, "      <data key=\"confidence\">%g</data>\n", node.entity.Confidence)
+			for i, key := range properties {
+				if value, ok := node.entity.Properties[key]; ok {
+					fmt.Fprintf(&out, "      <data key=\"p%d\">%s</data>\n", i, xmlText(fmt.Sprint(value)))
+				}
+			}
+			for i, key := range attributes {
+				if attribute, ok := node.entity.Attributes[key]; ok {
+					fmt.Fprintf(&out, "      <data key=\"a%d\">%s</data>\n", i, xmlText(attribute.Value))
+				}
+			}
+		}
+		out.WriteString("    </node>\n")
+	}
+	for i, edge := range edges {
+		fmt.Fprintf(&out, "    <edge id=\"e%d\" source=%s target=%s>\n", i, xmlQuote(edge.source), xmlQuote(edge.target))
+		fmt.Fprintf(&out, "      <data key=\"edge_label\">%s</data>\n", xmlText(edge.relation.Predicate))
+		fmt.Fprintf(&out, "      <data key=\"weight\">%g</data>\n", edge.relation.Confidence)
+		for _, property := range temporalProperties(edge.relation.Temporal) {
+			fmt.Fprintf(&out, "      <data key=%s>%s</data>\n", xmlQuote(property[0]), xmlText(property[1]))
+		}
+		out.WriteString("    </edge>\n")
+	}
+	out.WriteString("  </graph>\n</graphml>\n")
+
+	if _, err := io.WriteString(w, out.String()); err != nil {
+		return fmt.Errorf("failed to write GraphML: %w", err)
+	}
+	return nil
+}
+
+// xmlText escapes s for XML character data
+func xmlText(s string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
+
+// xmlQuote returns s as a quoted XML attribute value
+func xmlQuote(s string) string {
+	return `"` + xmlText(s) + `"`
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// maxExternalClaims is the number of claims looked up externally per request, bounding
+// the latency external verification adds
+const maxExternalClaims = 10
+
+// Evidence is a passage from outside the request's documents bearing on a claim
+type Evidence struct {
+	Text   string `json:"text"`
+	URL    string `json:"url"` // Where the passage comes from
+	Title  string `json:"title,omitempty"`
+	Origin string `json:"origin"` // What found it, such as google_search; "external" when the source doesn't say
+}
+
+// VerificationSource finds evidence for claims the request's documents don't settle,
+// such as a search engine or a retriever over another corpus
+type VerificationSource interface {
+	Search(ctx context.Context, claim Claim) ([]Evidence, error)
+}
+
+// WithVerificationSource replaces the built-in Google Search verification source
+func WithVerificationSource(source VerificationSource) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.verificationSource = source }
+}
+
+// googleSearchSource is the built-in VerificationSource, asking a Gemini model grounded
+// with Google Search about the claim
+type googleSearchSource struct {
+	processor *AgenticRAGProcessor
+}
+
+// NewGoogleSearchSource creates a verification source searching the web through Gemini's
+// Google Search grounding. It needs the processor's model to be a Gemini model.
+func NewGoogleSearchSource(processor *AgenticRAGProcessor) VerificationSource {
+	return googleSearchSource{processor: processor}
+}
+
+// Search asks the model about the claim with Google Search grounding and returns the
+// grounded segments of its answer with the pages backing them
+func (s googleSearchSource) Search(ctx context.Context, claim Claim) ([]Evidence, error) {
+	p := s.processor
+	primary := p.primaryCandidate()
+	if model := requestStateFrom(ctx).modelOverride(stageFrom(ctx)); model != "" {
+		primary = namedCandidate(model)
+	} else if verifier, ok := p.verificationCandidate(ctx); ok {
+		primary = verifier
+	}
+	if !isGeminiModel(primary.name) {
+		return nil, fmt.Errorf("google search verification needs a Gemini model, got %q", primary.name)
+	}
+	config, err := groundedConfig(&ai.GenerationCommonConfig{Temperature: 0, MaxOutputTokens: 500})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build grounded config: %w", err)
+	}
+	prompt := fmt.Sprintf("Search the web for whether this claim is true and summarize what reliable sources say about it:\n%s", claim.Text)
+	response, err := p.callModel(ctx, primary, estimateTokens(prompt), func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+		opts := []ai.GenerateOption{model.option, ai.WithPrompt(prompt), ai.WithConfig(config)}
+		if middleware := p.callMiddleware(ctx, model); middleware != nil {
+			opts = append(opts, middleware)
+		}
+		return genkit.Generate(ctx, p.config.Genkit, opts...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	grounding := groundingFromResponse(response)
+	if grounding == nil {
+		return nil, nil
+	}
+	var evidence []Evidence
+	for _, support := range grounding.Supports {
+		for _, index := range support.SourceIndices {
+			if index < 0 || index >= len(grounding.Sources) || grounding.Sources[index].URI == "" {
+				continue
+			}
+			source := grounding.Sources[index]
+			evidence = append(evidence, Evidence{Text: support.Text, URL: source.URI, Title: source.Title, Origin: "google_search"})
+		}
+	}
+	return evidence, nil
+}
+
+// externalVerdictSchema is the JSON schema of the model's verdict on external evidence
+var externalVerdictSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"verdict", "confidence"},
+	"properties": map[string]any{
+		"verdict":    map[string]any{"type": "string", "enum": []any{"supported", "refuted", "partially_supported", "not_enough_evidence"}},
+		"confidence": map[string]any{"type": "number"},
+		"evidence":   map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+	},
+}
+
+// verifyExternally looks up the claims the documents didn't settle in the verification
+// source and judges them again against what it returns. Claims keep their verdict when
+// the source finds nothing or fails, which is recorded as a warning, and the lookups
+// stop when the token budget runs out. A claim judged on external evidence lists it in
+// ExternalEvidence and its URLs in Sources.
+func (p *AgenticRAGProcessor) verifyExternally(ctx context.Context, verification *FactVerification) {
+	if verification == nil || p.verificationSource == nil {
+		return
+	}
+	state := requestStateFrom(ctx)
+	looked := 0
+	for i := range verification.Claims {
+		claim := &verification.Claims[i]
+		if claim.Verdict != VerdictNotEnoughEvidence {
+			continue
+		}
+		if looked == maxExternalClaims {
+			state.recordWarning(fmt.Sprintf("external verification stopped after %d claims", maxExternalClaims))
+			break
+		}
+		looked++
+
+		evidence, err := p.verificationSource.Search(ctx, *claim)
+		if err == nil && len(evidence) > 0 {
+			for j := range evidence {
+				if evidence[j].Origin == "" {
+					evidence[j].Origin = "external"
+				}
+			}
+			err = p.judgeExternalEvidence(ctx, claim, evidence)
+		}
+		if errors.Is(err, ErrBudgetExceeded) {
+			state.recordWarning("external verification stopped: the token budget ran out")
+			break
+		}
+		if err != nil {
+			state.recordWarning(fmt.Sprintf("external verification of claim %q failed: %v", claim.Text, err))
+		}
+	}
+	verification.Overall = overallVerdict(verification.Claims)
+}
+
+// judgeExternalEvidence gives the claim a verdict on the evidence, keeping the pieces
+// the verdict rests on
+func (p *AgenticRAGProcessor) judgeExternalEvidence(ctx context.Context, claim *Claim, evidence []Evidence) error {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, `Judge this claim against the numbered evidence from the web. Give a verdict of supported, refuted, partially_supported or not_enough_evidence, your confidence in it between 0 and 1, and the numbers of the evidence it rests on.
+
+Claim: %s
+
+Evidence:
+`, claim.Text)
+	for i, piece := range evidence {
+		fmt.Fprintf(&prompt, "(%d) %s [%s]\n", i, piece.Text, piece.URL)
+	}
+	prompt.WriteString(`
+Example: {"verdict": "supported", "confidence": 0.8, "evidence": [0, 2]}`)
+
+	value, _, err := p.generateJSON(ctx, prompt.String(), externalVerdictSchema, &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 200,
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to parse verdict: %w", err)
+	}
+	var judged struct {
+		Verdict    Verdict `json:"verdict"`
+		Confidence float64 `json:"confidence"`
+		Evidence   []int   `json:"evidence"`
+	}
+	if err := json.Unmarshal(data, &judged); err != nil {
+		return fmt.Errorf("failed to parse verdict: %w", err)
+	}
+
+	claim.Confidence = max(0, min(1, judged.Confidence))
+	claim.Verdict = calibrateVerdict(p.factVerification(ctx), judged.Verdict, claim.Confidence)
+	for _, index := range judged.Evidence {
+		if index < 0 || index >= len(evidence) {
+			continue
+		}
+		claim.ExternalEvidence = append(claim.ExternalEvidence, evidence[index])
+		if !containsString(claim.Sources, evidence[index].URL) {
+			claim.Sources = append(claim.Sources, evidence[index].URL)
+		}
+	}
+	if len(claim.ExternalEvidence) == 0 && claim.Verdict != VerdictNotEnoughEvidence {
+		claim.Verdict = VerdictNotEnoughEvidence // A verdict must rest on evidence it names
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// extractionOutput is the knowledge graph of a chunk as the model returns it through
+// constrained output. Mentions and evidence are spans quoted verbatim from the text.
+type extractionOutput struct {
+	Entities  []extractedEntity   `json:"entities"`
+	Relations []extractedRelation `json:"relations"`
+}
+
+// extractedEntity is an entity of extractionOutput
+type extractedEntity struct {
+	Name       string               `json:"name" jsonschema_description:"Normalized entity name"`
+	Type       string               `json:"type" jsonschema_description:"One of the requested entity types"`
+	Confidence float64              `json:"confidence" jsonschema_description:"Confidence between 0 and 1"`
+	Mentions   []string             `json:"mentions" jsonschema_description:"Spans of the text naming the entity, quoted verbatim"`
+	Attributes []extractedAttribute `json:"attributes,omitempty" jsonschema_description:"Facts the text clearly states about the entity"`
+}
+
+// extractedAttribute is an attribute of extractedEntity
+type extractedAttribute struct {
+	Name       string  `json:"name"`
+	Value      string  `json:"value"`
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source,omitempty" jsonschema_description:"Span of the text stating the attribute, quoted verbatim"`
+}
+
+// extractedRelation is a relation of extractionOutput
+type extractedRelation struct {
+	FromEntity         string  `json:"from_entity" jsonschema_description:"Name of the subject entity"`
+	ToEntity           string  `json:"to_entity" jsonschema_description:"Name of the object entity"`
+	RelationType       string  `json:"relation_type" jsonschema_description:"One of the requested relation types"`
+	Confidence         float64 `json:"confidence" jsonschema_description:"Confidence between 0 and 1"`
+	Evidence           string  `json:"evidence" jsonschema_description:"Span of the text expressing the relation, quoted verbatim"`
+	Date               string  `json:"date,omitempty" jsonschema_description:"ISO-8601 date the relation happened, if the text says"`
+	StartDate          string  `json:"start_date,omitempty" jsonschema_description:"ISO-8601 date the relation began, if the text says"`
+	EndDate            string  `json:"end_date,omitempty" jsonschema_description:"ISO-8601 date the relation ended, if the text says"`
+	TemporalExpression string  `json:"temporal_expression,omitempty" jsonschema_description:"The text's own wording of when"`
+}
+
+// knowledgeGraphSchema is the shape a chunk's extraction output is checked against
+var knowledgeGraphSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"entities", "relations"},
+	"properties": map[string]any{
+		"entities": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"name", "type", "confidence"},
+			},
+		},
+		"relations": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"from_entity", "to_entity", "relation_type", "confidence"},
+			},
+		},
+	},
+}
+
+// fallbackGraphSchema is the shape of the built-in extraction prompt's JSON, whose
+// relations refer to entities by the IDs it assigns
+var fallbackGraphSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"entities"},
+	"properties": map[string]any{
+		"entities": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"name"},
+			},
+		},
+		"relations": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"subject", "predicate", "object"},
+			},
+		},
+	},
+}
+
+// extractChunkGraph extracts the knowledge graph of a single chunk. Models supporting
+// constrained output are asked for extractionOutput directly; others get the knowledge
+// extraction prompt, falling back to the built-in prompt when it's missing or fails.
+// Output that doesn't match knowledgeGraphSchema gets one corrective retry showing the
+// model what was wrong, then fails as a StructuredOutputError.
+func (p *AgenticRAGProcessor) extractChunkGraph(ctx context.Context, chunk DocumentChunk) (*KnowledgeGraph, error) {
+	constrained := p.supportsConstrainedOutput(ctx)
+	var response *ai.ModelResponse
+	if constrained {
+		prompt := p.extractionPrompt(ctx, chunk)
+		var err error
+		response, err = p.callModel(ctx, p.primaryCandidate(), estimateTokens(prompt), p.constrainedExtractionCall(prompt))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		kgPrompt := p.lookupPrompt(ctx, "knowledge_extraction", p.config.Prompts.KnowledgeExtractionPrompt)
+		if kgPrompt == nil {
+			return p.buildKnowledgeGraphFallback(ctx, []DocumentChunk{chunk})
+		}
+		var err error
+		response, err = p.executePrompt(ctx, kgPrompt, map[string]any{
+			"text_chunks":    []string{chunk.Content},
+			"entity_types":   newEntityOntology(p.config.KnowledgeGraph.TypeParents).describe(p.entityTypes(ctx)),
+			"relation_types": p.config.KnowledgeGraph.RelationTypes,
+			"min_confidence": p.config.KnowledgeGraph.MinConfidenceThreshold,
+		})
+		if err != nil {
+			return p.buildKnowledgeGraphFallback(ctx, []DocumentChunk{chunk})
+		}
+	}
+
+	var responseData map[string]any
+	parseErr := response.Output(&responseData)
+	if parseErr == nil {
+		parseErr = checkSchema(responseData, knowledgeGraphSchema, "output")
+	}
+	if parseErr == nil {
+		return p.parseKnowledgeGraphResponse(responseData)
+	}
+
+	raw := response.Text()
+	corrective := fmt.Sprintf(`Extract entities and relationships from this text as a knowledge graph.
+
+Text:
+%s
+
+Your previous response was rejected: %v
+
+Previous response:
+%s
+
+Respond again with only a JSON object with an "entities" array of {"name", "type", "confidence", "mentions", "attributes"} objects and a "relations" array of {"from_entity", "to_entity", "relation_type", "confidence", "evidence"} objects, without code fences or commentary.`,
+		chunk.Content, parseErr, raw)
+	if constrained {
+		response, parseErr = p.callModel(ctx, p.primaryCandidate(), estimateTokens(corrective), p.constrainedExtractionCall(corrective))
+	} else {
+		response, parseErr = p.generate(ctx, corrective, &ai.GenerationCommonConfig{
+			Temperature:     0.2,
+			MaxOutputTokens: 2500,
+		})
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	raw = response.Text()
+	value, err := parseStructuredAnswer(raw, knowledgeGraphSchema)
+	if err != nil {
+		return nil, &StructuredOutputError{Raw: raw, Err: err}
+	}
+	responseData, _ = value.(map[string]any)
+	return p.parseKnowledgeGraphResponse(responseData)
+}
+
+// constrainedExtractionCall returns a model call asking for extractionOutput through
+// the provider's constrained output
+func (p *AgenticRAGProcessor) constrainedExtractionCall(prompt string) func(context.Context, modelCandidate) (*ai.ModelResponse, error) {
+	return func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+		opts := []ai.GenerateOption{
+			model.option,
+			ai.WithPrompt(prompt),
+			ai.WithConfig(&ai.GenerationCommonConfig{Temperature: 0.2, MaxOutputTokens: 2500}),
+			ai.WithOutputType(extractionOutput{}),
+		}
+		if middleware := p.callMiddleware(ctx, model); middleware != nil {
+			opts = append(opts, middleware)
+		}
+		return genkit.Generate(ctx, p.config.Genkit, opts...)
+	}
+}
+
+// extractionPrompt is the instructions for constrained extraction of a chunk, which
+// leaves the output format to the schema
+func (p *AgenticRAGProcessor) extractionPrompt(ctx context.Context, chunk DocumentChunk) string {
+	return fmt.Sprintf(`You are an expert knowledge graph extractor. Extract entities and relationships from the provided text.
+
+Text to analyze:
+%s
+
+Entity types: %s
+Relation types: %s
+
+- Extract only entities and relations with confidence of at least %.2f
+- Quote the spans naming each entity as its mentions, and the span expressing each relation as its evidence, verbatim from the text
+- Normalize entity names so the same entity is always named the same way
+- Give attributes the text clearly states about an entity, with the span stating each as source
+- When the text says when a relationship happened or held, give date, or start_date and end_date, in ISO-8601 (YYYY, YYYY-MM or YYYY-MM-DD) and the text's own wording in temporal_expression`,
+		chunk.Content,
+		strings.Join(newEntityOntology(p.config.KnowledgeGraph.TypeParents).describe(p.entityTypes(ctx)), ", "),
+		strings.Join(p.config.KnowledgeGraph.RelationTypes, ", "),
+		p.config.KnowledgeGraph.MinConfidenceThreshold)
+}
+
+// supportsConstrainedOutput reports whether the model knowledge extraction would call
+// first declares support for constrained output. Unknown models don't.
+func (p *AgenticRAGProcessor) supportsConstrainedOutput(ctx context.Context) bool {
+	if p.config.Genkit == nil {
+		return false
+	}
+	var model ai.Model
+	if override := requestStateFrom(ctx).modelOverride(stageFrom(ctx)); override != "" {
+		provider, name, _ := strings.Cut(override, "/")
+		model = genkit.LookupModel(p.config.Genkit, provider, name)
+	} else if p.config.Model != nil {
+		model = p.config.Model
+	} else {
+		provider, name, _ := strings.Cut(p.config.ModelName, "/")
+		model = genkit.LookupModel(p.config.Genkit, provider, name)
+	}
+	constrained, ok := model.(interface{ SupportsConstrained(hasTools bool) bool })
+	return ok && constrained.SupportsConstrained(false)
+}
+
+// parseFallbackGraphJSON decodes the built-in extraction prompt's JSON, tolerating code
+// fences and commentary around the object, with relations referring to entities by name
+func (p *AgenticRAGProcessor) parseFallbackGraphJSON(text string) (*KnowledgeGraph, error) {
+	value, err := parseStructuredAnswer(text, fallbackGraphSchema)
+	if err != nil {
+		embedded, ok := embeddedJSONObject(text)
+		if !ok {
+			return nil, err
+		}
+		if value, err = parseStructuredAnswer(embedded, fallbackGraphSchema); err != nil {
+			return nil, err
+		}
+	}
+	data, _ := value.(map[string]any)
+	kg := &KnowledgeGraph{Entities: make([]Entity, 0), Relations: make([]Relation, 0)}
+	minConfidence := p.config.KnowledgeGraph.MinConfidenceThreshold
+	entities, _ := data["entities"].([]any)
+	for _, item := range entities {
+		fields, _ := item.(map[string]any)
+		entity := Entity{}
+		entity.ID, _ = fields["id"].(string)
+		entity.Name, _ = fields["name"].(string)
+		entity.Type, _ = fields["type"].(string)
+		entity.Confidence, _ = fields["confidence"].(float64)
+		if entity.Name != "" && entity.Confidence >= minConfidence {
+			kg.Entities = append(kg.Entities, entity)
+		}
+	}
+	relations, _ := data["relations"].([]any)
+	for _, item := range relations {
+		fields, _ := item.(map[string]any)
+		relation := Relation{}
+		relation.Subject, _ = fields["subject"].(string)
+		relation.Predicate, _ = fields["predicate"].(string)
+		relation.Object, _ = fields["object"].(string)
+		relation.Confidence, _ = fields["confidence"].(float64)
+		if relation.Confidence >= minConfidence {
+			kg.Relations = append(kg.Relations, relation)
+		}
+	}
+	resolveRelationNames(kg)
+	for i := range kg.Entities {
+		kg.Entities[i].ID = "" // Assigned by the prompt, replaced with stable IDs later
+	}
+	return kg, nil
+}
+
+// embeddedJSONObject returns the first complete JSON object in text, for output that
+// wraps it in commentary
+func embeddedJSONObject(text string) (string, bool) {
+	for start := strings.IndexByte(text, '{'); start >= 0; {
+		var object json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&object); err == nil {
+			return string(object), true
+		}
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return "", false
+}
@@ -0,0 +1,166 @@
+package plugin
+
+import (
+	"slices"
+	"testing"
+)
+
+// wellFormedGraph is the built-in extraction prompt's JSON as asked for
+const wellFormedGraph = `{
+  "entities": [
+    {"id": "entity_1", "name": "Eiffel Tower", "type": "LOCATION", "confidence": 0.95},
+    {"id": "entity_2", "name": "Paris", "type": "LOCATION", "confidence": 0.9}
+  ],
+  "relations": [
+    {"id": "rel_1", "subject": "entity_1", "predicate": "located_in", "object": "entity_2", "confidence": 0.9}
+  ]
+}`
+
+// TestParseFallbackGraphCorpus replays malformed outputs models gave the built-in
+// extraction prompt, checking what the fallback parser recovers from each
+func TestParseFallbackGraphCorpus(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		entities  []string
+		relations int
+	}{
+		{
+			name:      "well formed",
+			output:    wellFormedGraph,
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:      "json fence",
+			output:    "```json\n" + wellFormedGraph + "\n```",
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:      "bare fence",
+			output:    "```\n" + wellFormedGraph + "\n```\n",
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:      "leading commentary",
+			output:    "Here is the knowledge graph extracted from the text:\n\n" + wellFormedGraph,
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:      "trailing commentary",
+			output:    wellFormedGraph + "\n\nNote: I only included entities with confidence above {threshold}.",
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:      "fence inside commentary",
+			output:    "Sure! Below is the JSON.\n```json\n" + wellFormedGraph + "\n```\nLet me know if you need anything else.",
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name: "relations by name",
+			output: `{"entities": [{"name": "Eiffel Tower", "type": "LOCATION", "confidence": 0.95}, {"name": "Paris", "type": "LOCATION", "confidence": 0.9}],
+"relations": [{"subject": "Eiffel Tower", "predicate": "located_in", "object": "Paris", "confidence": 0.9}]}`,
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name:     "relations left out",
+			output:   `{"entities": [{"id": "entity_1", "name": "Photosynthesis", "type": "CONCEPT", "confidence": 0.8}]}`,
+			entities: []string{"Photosynthesis"},
+		},
+		{
+			name: "low confidence and nameless entities",
+			output: `{"entities": [{"id": "entity_1", "name": "Paris", "type": "LOCATION", "confidence": 0.9}, {"id": "entity_2", "name": "", "type": "LOCATION", "confidence": 0.9},
+{"id": "entity_3", "name": "Seine", "type": "LOCATION", "confidence": 0.3}], "relations": [{"subject": "entity_1", "predicate": "near", "object": "entity_3", "confidence": 0.2}]}`,
+			entities: []string{"Paris"},
+		},
+		{
+			name: "line format",
+			output: `Entities:
+Eiffel Tower, LOCATION, 95%
+Paris, LOCATION, 0.9
+
+Relations:
+Eiffel Tower, Paris, located_in, 90%`,
+			entities:  []string{"Eiffel Tower", "Paris"},
+			relations: 1,
+		},
+		{
+			name: "wrong entity shape falls back to lines",
+			output: `{"entities": ["Eiffel Tower", "Paris"]}
+Entities:
+Eiffel Tower, LOCATION, 95%`,
+			entities: []string{"Eiffel Tower"},
+		},
+		{
+			name:   "truncated",
+			output: `{"entities": [{"id": "entity_1", "name": "Eiffel Tower", "type": "LOCATION", "confidence": 0.95}, {"id": "entity_2", "na`,
+		},
+		{
+			name:   "trailing commas",
+			output: `{"entities": [{"id": "entity_1", "name": "Paris", "type": "LOCATION", "confidence": 0.9},], "relations": [],}`,
+		},
+		{
+			name:   "refusal",
+			output: "I'm sorry, but I can't find any entities in this text.",
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	p := &AgenticRAGProcessor{config: DefaultConfig()}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kg, err := p.parseFallbackGraph(tt.output)
+			if err != nil {
+				t.Fatalf("parseFallbackGraph: %v", err)
+			}
+			names := make([]string, len(kg.Entities))
+			for i, entity := range kg.Entities {
+				names[i] = entity.Name
+				if entity.ID != "" {
+					t.Errorf("entity %q kept the prompt's ID %q", entity.Name, entity.ID)
+				}
+				if entity.Confidence <= 0 || entity.Confidence > 1 {
+					t.Errorf("entity %q has confidence %v, want a fraction", entity.Name, entity.Confidence)
+				}
+			}
+			if !slices.Equal(names, tt.entities) {
+				t.Errorf("entities = %q, want %q", names, tt.entities)
+			}
+			if len(kg.Relations) != tt.relations {
+				t.Fatalf("got %d relations %+v, want %d", len(kg.Relations), kg.Relations, tt.relations)
+			}
+			for _, relation := range kg.Relations {
+				if relation.Subject != "Eiffel Tower" || relation.Object != "Paris" || relation.Predicate != "located_in" {
+					t.Errorf("relation %+v, want Eiffel Tower located_in Paris by name", relation)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConfidence(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+	}{
+		{"95%", 0.95},
+		{"0.9", 0.9},
+		{" 80% ", 0.8},
+		{"1", 1},
+		{"75", 0.75},
+		{"high", 0},
+	}
+	for _, tt := range tests {
+		if got := parseConfidence(tt.text); got != tt.want {
+			t.Errorf("parseConfidence(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// AnswerMode selects how the answer is composed
+type AnswerMode string
+
+const (
+	AnswerModeAbstractive AnswerMode = "abstractive" // The model writes the answer in its own words
+	AnswerModeExtractive  AnswerMode = "extractive"  // The answer is verbatim quotes from the chunks
+)
+
+// extractiveQuote is a quote the model selected and the marker of the chunk it claims
+// the quote came from
+type extractiveQuote struct {
+	Source int    `json:"source"`
+	Text   string `json:"text"`
+}
+
+// generateExtractiveAnswer composes the answer from verbatim quotes of the chunks. Every
+// quote is checked against the chunks, ignoring differences in whitespace; if any was
+// paraphrased the model is asked once more with the rejected quotes, and quotes that
+// still don't appear verbatim are dropped. Returns the answer with [n] markers, the
+// answer without them and one citation per quote with its offsets in the document.
+func (p *AgenticRAGProcessor) generateExtractiveAnswer(ctx context.Context, query string, chunks []DocumentChunk, documentIndex map[string]int) (string, string, []Citation, error) {
+	if len(chunks) == 0 {
+		return "I don't have enough information to answer your question.", "", nil, nil
+	}
+
+	var citations []Citation
+	var rejected []string
+	for attempt := 0; attempt < 2; attempt++ {
+		quotes, err := p.selectQuotes(ctx, query, chunks, rejected)
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		citations, rejected = citations[:0], rejected[:0]
+		for _, quote := range quotes {
+			citation, ok := locateQuote(quote, chunks, documentIndex)
+			if !ok {
+				rejected = append(rejected, quote.Text)
+				continue
+			}
+			citation.Marker = len(citations) + 1
+			citations = append(citations, citation)
+		}
+		if len(rejected) == 0 {
+			break
+		}
+	}
+	if len(citations) == 0 {
+		return "I couldn't find passages in the documents that answer your question.", "", nil, nil
+	}
+
+	var answer, clean strings.Builder
+	for i, citation := range citations {
+		if i > 0 {
+			answer.WriteString("\n\n")
+			clean.WriteString("\n\n")
+		}
+		fmt.Fprintf(&answer, "%s [%d]", citation.Quote, citation.Marker)
+		clean.WriteString(citation.Quote)
+	}
+	return answer.String(), clean.String(), citations, nil
+}
+
+// selectQuotes asks the model for the quotes that answer the query, telling it which
+// quotes were rejected on a retry
+func (p *AgenticRAGProcessor) selectQuotes(ctx context.Context, query string, chunks []DocumentChunk, rejected []string) ([]extractiveQuote, error) {
+	if err := p.initializePrompts(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	contextChunks := make([]map[string]any, len(chunks))
+	for i, chunk := range chunks {
+		contextChunks[i] = map[string]any{"marker": i + 1, "content": chunk.Content}
+	}
+
+	extractivePrompt := p.lookupPrompt(ctx, "extractive_answer", p.config.Prompts.ExtractiveAnswerPrompt)
+	if extractivePrompt == nil {
+		return p.selectQuotesFallback(ctx, query, chunks, rejected)
+	}
+
+	input := map[string]any{"query": query, "context_chunks": contextChunks}
+	if len(rejected) > 0 {
+		input["rejected"] = rejected
+	}
+	response, err := p.executePrompt(ctx, extractivePrompt, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select quotes: %w", err)
+	}
+
+	var result struct {
+		Quotes []extractiveQuote `json:"quotes"`
+	}
+	if err := response.Output(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse quotes: %w", err)
+	}
+	return result.Quotes, nil
+}
+
+// selectQuotesFallback selects quotes with a hardcoded prompt when the dotprompt isn't
+// available
+func (p *AgenticRAGProcessor) selectQuotesFallback(ctx context.Context, query string, chunks []DocumentChunk, rejected []string) ([]extractiveQuote, error) {
+	var prompt strings.Builder
+	prompt.WriteString(`Answer the query using only exact quotations from the sources. Never paraphrase,
+summarize, translate or correct the text you quote.
+
+`)
+	for i, chunk := range chunks {
+		fmt.Fprintf(&prompt, "Source [%d]:\n%s\n\n", i+1, chunk.Content)
+	}
+	if len(rejected) > 0 {
+		prompt.WriteString("These quotes from your previous attempt were rejected because they don't appear word for word in the cited source. Copy the source text exactly instead:\n")
+		for _, quote := range rejected {
+			fmt.Fprintf(&prompt, "- %s\n", quote)
+		}
+		prompt.WriteString("\n")
+	}
+	fmt.Fprintf(&prompt, `Query: %s
+
+Select the passages that answer the query, in the order they should be read, copying whole
+sentences character for character from a single source. Respond with JSON in this exact format:
+{"quotes": [{"source": 1, "text": "Exact sentence copied from source 1."}]}`, query)
+
+	response, err := p.generate(ctx, prompt.String(), &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 2000,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select quotes: %w", err)
+	}
+
+	text := strings.TrimSpace(response.Text())
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	var result struct {
+		Quotes []extractiveQuote `json:"quotes"`
+	}
+	if err := json.Unmarshal([]byte(text), &result); err != nil {
+		return nil, fmt.Errorf("failed to parse quotes: %w", err)
+	}
+	return result.Quotes, nil
+}
+
+// locateQuote finds the quote verbatim in the chunk it names, or any other chunk if the
+// model cited the wrong one, and returns a citation for the exact span
+func locateQuote(quote extractiveQuote, chunks []DocumentChunk, documentIndex map[string]int) (Citation, bool) {
+	order := make([]int, 0, len(chunks))
+	if quote.Source >= 1 && quote.Source <= len(chunks) {
+		order = append(order, quote.Source-1)
+	}
+	for i := range chunks {
+		if i != quote.Source-1 {
+			order = append(order, i)
+		}
+	}
+
+	for _, i := range order {
+		chunk := chunks[i]
+		start, end, ok := findVerbatim(chunk.Content, quote.Text)
+		if !ok {
+			continue
+		}
+		index, ok := documentIndex[chunk.DocumentID]
+		if !ok {
+			index = -1
+		}
+		return Citation{
+			DocumentIndex: index,
+			DocumentID:    chunk.DocumentID,
+			ChunkID:       chunk.ID,
+			CharStart:     chunk.StartIndex + start,
+			CharEnd:       chunk.StartIndex + end,
+			Page:          chunk.Page,
+			HeadingPath:   chunk.HeadingPath,
+			Quote:         chunk.Content[start:end],
+		}, true
+	}
+	return Citation{}, false
+}
+
+// findVerbatim returns the byte range of quote in content, treating any run of
+// whitespace as equal to any other
+func findVerbatim(content, quote string) (int, int, bool) {
+	quote = collapseWhitespace(strings.TrimSpace(quote))
+	if quote == "" {
+		return 0, 0, false
+	}
+
+	// Collapse the content too, remembering where each byte came from
+	var normalized strings.Builder
+	offsets := make([]int, 0, len(content)+1)
+	inSpace := false
+	for i := 0; i < len(content); {
+		r, width := utf8.DecodeRuneInString(content[i:])
+		if unicode.IsSpace(r) {
+			if !inSpace {
+				normalized.WriteByte(' ')
+				offsets = append(offsets, i)
+			}
+			inSpace = true
+		} else {
+			inSpace = false
+			normalized.WriteString(content[i : i+width])
+			for b := 0; b < width; b++ {
+				offsets = append(offsets, i+b)
+			}
+		}
+		i += width
+	}
+
+	at := strings.Index(normalized.String(), quote)
+	if at < 0 {
+		return 0, 0, false
+	}
+	return offsets[at], offsets[at+len(quote)-1] + 1, true
+}
+
+// collapseWhitespace replaces every run of whitespace with a single space
+func collapseWhitespace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,29 @@
+package plugin
+
+import "testing"
+
+func TestLocateQuoteAcrossParagraphs(t *testing.T) {
+	doc, chunks := chunkParagraphs(t, 80)
+	documentIndex := map[string]int{doc.ID: 0}
+
+	tests := []struct {
+		name  string
+		quote extractiveQuote
+		want  string
+	}{
+		{"later chunk", extractiveQuote{Source: 2, Text: "Kafka runs on the JVM today."}, "Kafka runs on the JVM today."},
+		{"across a paragraph break", extractiveQuote{Source: 2, Text: "for the fair. Kafka runs"}, "for the fair.\n\nKafka runs"},
+		{"wrong source", extractiveQuote{Source: 1, Text: "completed in 1889"}, "completed in 1889"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			citation, ok := locateQuote(tt.quote, chunks, documentIndex)
+			if !ok {
+				t.Fatalf("quote %q not located", tt.quote.Text)
+			}
+			if got := doc.Content[citation.CharStart:citation.CharEnd]; got != tt.want || citation.Quote != tt.want {
+				t.Errorf("citation at [%d:%d] = %q quoting %q, want %q", citation.CharStart, citation.CharEnd, got, citation.Quote, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/invopop/jsonschema"
+)
+
+// FilterOp is the comparison of a DocumentFilter predicate
+type FilterOp string
+
+const (
+	FilterOpEq  FilterOp = "eq"  // Equal
+	FilterOpNe  FilterOp = "ne"  // Not equal, also matching documents without the key
+	FilterOpGt  FilterOp = "gt"  // Greater than
+	FilterOpGte FilterOp = "gte" // Greater than or equal
+	FilterOpLt  FilterOp = "lt"  // Less than
+	FilterOpLte FilterOp = "lte" // Less than or equal
+)
+
+// filterDateLayouts are the layouts tried when comparing strings as dates
+var filterDateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// DocumentFilter selects documents by their metadata before chunking. A filter is
+// either a predicate comparing the metadata value under Key with Value, or a
+// composition of filters with And or Or. Numbers compare numerically, strings that
+// parse as RFC 3339 timestamps or YYYY-MM-DD dates compare as dates, and other strings
+// compare lexically.
+type DocumentFilter struct {
+	Key   string           `json:"key,omitempty"`
+	Op    FilterOp         `json:"op,omitempty"`
+	Value any              `json:"value,omitempty"`
+	And   []DocumentFilter `json:"and,omitempty"` // Every filter must match
+	Or    []DocumentFilter `json:"or,omitempty"`  // At least one filter must match
+}
+
+// JSONSchema describes the filter for genkit's flow and tool schemas. Reflection would
+// recurse forever through And and Or, as genkit inlines every type, so nested filters
+// are described as plain objects.
+func (DocumentFilter) JSONSchema() *jsonschema.Schema {
+	nested := func(description string) *jsonschema.Schema {
+		return &jsonschema.Schema{Type: "array", Description: description, Items: &jsonschema.Schema{Type: "object", Description: "A nested filter"}}
+	}
+	properties := jsonschema.NewProperties()
+	properties.Set("key", &jsonschema.Schema{Type: "string", Description: "Metadata key the predicate compares"})
+	properties.Set("op", &jsonschema.Schema{Type: "string", Enum: []any{FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte}})
+	properties.Set("value", &jsonschema.Schema{Description: "Value the metadata is compared with"})
+	properties.Set("and", nested("Filters that must all match"))
+	properties.Set("or", nested("Filters of which at least one must match"))
+	return &jsonschema.Schema{Type: "object", Properties: properties}
+}
+
+// validate checks the structure of the filter, naming fields from field
+func (f DocumentFilter) validate(field string) []error {
+	forms := 0
+	for _, set := range []bool{f.Key != "", f.And != nil, f.Or != nil} {
+		if set {
+			forms++
+		}
+	}
+	if forms != 1 {
+		return []error{fieldError(field, "must set exactly one of key, and or or")}
+	}
+
+	var errs []error
+	switch {
+	case f.Key != "":
+		switch f.Op {
+		case FilterOpEq, FilterOpNe, FilterOpGt, FilterOpGte, FilterOpLt, FilterOpLte:
+		default:
+			errs = append(errs, fieldError(field+".op", "must be one of eq, ne, gt, gte, lt or lte, got %q", f.Op))
+		}
+		switch f.Value.(type) {
+		case string, float64, int, int64, time.Time:
+		case bool:
+			if f.Op != FilterOpEq && f.Op != FilterOpNe {
+				errs = append(errs, fieldError(field+".op", "must be eq or ne for a boolean value, got %q", f.Op))
+			}
+		default:
+			errs = append(errs, fieldError(field+".value", "must be a string, number, boolean or time, got %T", f.Value))
+		}
+	case f.And != nil:
+		for i, sub := range f.And {
+			errs = append(errs, sub.validate(fmt.Sprintf("%s.and[%d]", field, i))...)
+		}
+	default:
+		for i, sub := range f.Or {
+			errs = append(errs, sub.validate(fmt.Sprintf("%s.or[%d]", field, i))...)
+		}
+	}
+	return errs
+}
+
+// keys adds the metadata keys the filter references to fields, mapped to the field
+// naming the first predicate that uses each
+func (f DocumentFilter) keys(field string, fields map[string]string) {
+	if f.Key != "" {
+		if _, ok := fields[f.Key]; !ok {
+			fields[f.Key] = field + ".key"
+		}
+	}
+	for i, sub := range f.And {
+		sub.keys(fmt.Sprintf("%s.and[%d]", field, i), fields)
+	}
+	for i, sub := range f.Or {
+		sub.keys(fmt.Sprintf("%s.or[%d]", field, i), fields)
+	}
+}
+
+// matches reports whether the metadata satisfies the filter
+func (f DocumentFilter) matches(metadata map[string]any) bool {
+	switch {
+	case f.And != nil:
+		for _, sub := range f.And {
+			if !sub.matches(metadata) {
+				return false
+			}
+		}
+		return true
+	case f.Or != nil:
+		for _, sub := range f.Or {
+			if sub.matches(metadata) {
+				return true
+			}
+		}
+		return false
+	}
+
+	value, ok := metadata[f.Key]
+	if !ok {
+		return f.Op == FilterOpNe
+	}
+	cmp, comparable := compareFilterValues(value, f.Value)
+	switch f.Op {
+	case FilterOpEq:
+		return comparable && cmp == 0
+	case FilterOpNe:
+		return !comparable || cmp != 0
+	case FilterOpGt:
+		return comparable && cmp > 0
+	case FilterOpGte:
+		return comparable && cmp >= 0
+	case FilterOpLt:
+		return comparable && cmp < 0
+	case FilterOpLte:
+		return comparable && cmp <= 0
+	}
+	return false
+}
+
+// compareFilterValues compares a metadata value with a filter value, returning -1, 0
+// or 1 and whether the two are comparable at all
+func compareFilterValues(value, target any) (int, bool) {
+	if a, ok := filterNumber(value); ok {
+		if b, ok := filterNumber(target); ok {
+			return compareOrdered(a, b), true
+		}
+		return 0, false
+	}
+	if a, ok := filterTime(value); ok {
+		if b, ok := filterTime(target); ok {
+			return a.Compare(b), true
+		}
+	}
+	switch a := value.(type) {
+	case string:
+		if b, ok := target.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := target.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true // Booleans only compare for equality
+		}
+	}
+	return 0, false
+}
+
+// compareOrdered returns -1, 0 or 1 as a is less than, equal to or greater than b
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// filterNumber converts numeric metadata values to float64
+func filterNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// filterTime converts times and date strings to a time
+func filterTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, true
+	case string:
+		for _, layout := range filterDateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// filterDocuments returns the documents matching the filter. It fails when the filter
+// references a metadata key no document has, which is most likely a typo.
+func filterDocuments(documents []Document, filter *DocumentFilter) ([]Document, error) {
+	if filter == nil || len(documents) == 0 {
+		return documents, nil
+	}
+
+	fields := make(map[string]string)
+	filter.keys("options.document_filter", fields)
+	var errs []error
+	for _, key := range sortedKeys(fields) {
+		found := false
+		for _, doc := range documents {
+			if _, ok := doc.Metadata[key]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fieldError(fields[key], "metadata key %q is absent from every document", key))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	matched := make([]Document, 0, len(documents))
+	for _, doc := range documents {
+		if filter.matches(doc.Metadata) {
+			matched = append(matched, doc)
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,80 @@
+package plugin
+
+import (
+	"context"
+
+	"github.com/firebase/genkit/go/genkit"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FlowConfig contains configuration for the genkit flows the plugin registers
+type FlowConfig struct {
+	Disabled bool `json:"disabled"` // Skip registering the agenticRAG, agenticRAGSimple and corpus ingestion flows, when the processor is only called directly
+}
+
+// flowTracerKey is the context key of genkit's tracer for requests run by a flow
+type flowTracerKey struct{}
+
+// withFlowTracer marks ctx as inside a genkit flow, so the request it runs records its
+// spans with genkit's tracer, under the flow's span, and runs its stages as flow steps.
+// The developer UI only shows spans of that tracer, which genkit doesn't expose but the
+// flow's span carries.
+func withFlowTracer(ctx context.Context) context.Context {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return ctx
+	}
+	return context.WithValue(ctx, flowTracerKey{}, span.TracerProvider().Tracer(tracerName))
+}
+
+// flowTracerFrom returns genkit's tracer stored in ctx, nil outside flows
+func flowTracerFrom(ctx context.Context) trace.Tracer {
+	tracer, _ := ctx.Value(flowTracerKey{}).(trace.Tracer)
+	return tracer
+}
+
+// runStage runs a stage of the request in ctx. In a genkit flow the stage is a flow
+// step, so the developer UI's timeline lists it with fn's output summary. fn returns
+// the summary and the error stopping the request, if any.
+func runStage(ctx context.Context, stage string, fn func() (string, error)) error {
+	if requestStateFrom(ctx).tracerInFlow() == nil {
+		_, err := fn()
+		return err
+	}
+	_, err := genkit.Run(ctx, stage, fn)
+	return err
+}
+
+// processFlow runs a request for the agenticRAG flow. With a streaming callback, the
+// pipeline's events up to the answer deltas are streamed to it, and the final response
+// is the flow's output rather than an event. A failing callback cancels the request.
+func (p *AgenticRAGProcessor) processFlow(ctx context.Context, request AgenticRAGRequest, cb func(context.Context, RAGEvent) error) (*AgenticRAGResponse, error) {
+	ctx = withFlowTracer(ctx)
+	if cb == nil {
+		return p.Process(ctx, request)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events, err := p.ProcessStream(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	for event := range events {
+		switch event.Type {
+		case EventComplete:
+			return event.Response, event.Err
+		case EventError:
+			return nil, event.Err
+		}
+		if err := cb(ctx, event); err != nil {
+			cancel()
+			// Let the pipeline stop before returning
+			for range events {
+			}
+			return nil, err
+		}
+	}
+	// The channel closes without a final event only when ctx is done
+	return nil, ctx.Err()
+}
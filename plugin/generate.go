@@ -0,0 +1,291 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// modelCandidate is a model to try for a generation call
+type modelCandidate struct {
+	name     string             // Model name used for error reporting
+	option   ai.CommonGenOption // Option selecting the model, nil to keep the prompt's own model
+	instance *balancedInstance  // Load-balanced instance backing this candidate, if any
+	declared bool               // Declared by the prompt's frontmatter, taking precedence over the configured models
+}
+
+// namedCandidate returns a candidate selecting the model by name
+func namedCandidate(name string) modelCandidate {
+	return modelCandidate{name: name, option: ai.WithModelName(name)}
+}
+
+// generate sends a raw prompt to the configured model, retrying transient failures,
+// failing over on provider errors and escalating to larger models when the prompt
+// doesn't fit
+func (p *AgenticRAGProcessor) generate(ctx context.Context, prompt string, config *ai.GenerationCommonConfig) (*ai.ModelResponse, error) {
+	return p.callModel(ctx, p.primaryCandidate(), estimateTokens(prompt), p.generateCall(prompt, config))
+}
+
+// primaryCandidate returns the configured model as a candidate
+func (p *AgenticRAGProcessor) primaryCandidate() modelCandidate {
+	if p.config.Model != nil {
+		return modelCandidate{name: p.config.Model.Name(), option: ai.WithModel(p.config.Model)}
+	}
+	return namedCandidate(p.config.ModelName)
+}
+
+// generateCall returns a model call that sends the raw prompt to the candidate model
+func (p *AgenticRAGProcessor) generateCall(prompt string, config *ai.GenerationCommonConfig) func(context.Context, modelCandidate) (*ai.ModelResponse, error) {
+	return func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+		opts := []ai.GenerateOption{model.option, ai.WithPrompt(prompt), ai.WithConfig(config)}
+		if middleware := p.callMiddleware(ctx, model); middleware != nil {
+			opts = append(opts, middleware)
+		}
+		return genkit.Generate(ctx, p.config.Genkit, opts...)
+	}
+}
+
+// callMiddleware returns the middleware option the request's calls of the model run
+// with, nil for none. Deterministic mode rewrites the config before debug mode captures
+// it, so the trace shows what the model received. Every call is audited when an audit
+// logger is configured.
+func (p *AgenticRAGProcessor) callMiddleware(ctx context.Context, model modelCandidate) ai.CommonGenOption {
+	middleware := p.callMiddlewares(ctx, model)
+	if len(middleware) == 0 {
+		return nil
+	}
+	return ai.WithMiddleware(middleware...)
+}
+
+// callMiddlewares returns the middleware of callMiddleware as a list
+func (p *AgenticRAGProcessor) callMiddlewares(ctx context.Context, model modelCandidate) []ai.ModelMiddleware {
+	state := requestStateFrom(ctx)
+	var middleware []ai.ModelMiddleware
+	if state.isDeterministic() {
+		middleware = append(middleware, deterministicMiddleware(model.name))
+	}
+	if state.isDebug() {
+		middleware = append(middleware, p.debugMiddleware(stageFrom(ctx), model.name))
+	}
+	if p.audit != nil {
+		middleware = append(middleware, p.audit.middleware(model.name))
+	}
+	return middleware
+}
+
+// executePrompt executes a dotprompt with the given input, retrying transient failures,
+// failing over on provider errors and escalating to larger models when the input
+// doesn't fit
+func (p *AgenticRAGProcessor) executePrompt(ctx context.Context, prompt *ai.Prompt, input map[string]any) (*ai.ModelResponse, error) {
+	return p.executePromptStream(ctx, prompt, input, nil, nil)
+}
+
+// executePromptStream is executePrompt with the response streamed from the provider.
+// config, when set, replaces the prompt's generation config. onText receives the text
+// accumulated by the current attempt, so a retry restarts it.
+//
+// The prompt is rendered once, under the render lock of the genkit instance, and each
+// attempt sends a copy of the request with GenerateWithRequest rather than calling
+// Prompt.Execute, which renders without the lock and updates the prompt it's called on.
+func (p *AgenticRAGProcessor) executePromptStream(ctx context.Context, prompt *ai.Prompt, input map[string]any, config any, onText func(text string)) (*ai.ModelResponse, error) {
+	rendered, err := p.renderPrompt(ctx, prompt, input)
+	if err != nil {
+		return nil, err
+	}
+	return p.callModel(ctx, p.promptCandidate(rendered), estimateInputTokens(input), func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+		request := *rendered
+		if config != nil {
+			request.Config = config
+		}
+		if model.option != nil {
+			request.Model = model.name
+		}
+		var stream ai.ModelStreamCallback
+		if onText != nil {
+			var text strings.Builder
+			stream = func(ctx context.Context, chunk *ai.ModelResponseChunk) error {
+				text.WriteString(chunk.Text())
+				onText(text.String())
+				return nil
+			}
+		}
+		return genkit.GenerateWithRequest(ctx, p.config.Genkit, &request, p.callMiddlewares(ctx, model), stream)
+	})
+}
+
+// renderLocks holds a mutex per genkit instance, serializing the rendering of its
+// dotprompts. Genkit's dotprompt compiles each template into state shared by all
+// prompts of the instance, whichever processor renders them.
+var renderLocks sync.Map // *genkit.Genkit to *sync.Mutex
+
+// renderPrompt renders a dotprompt with the given input into a generate request
+func (p *AgenticRAGProcessor) renderPrompt(ctx context.Context, prompt *ai.Prompt, input map[string]any) (*ai.GenerateActionOptions, error) {
+	lock, _ := renderLocks.LoadOrStore(p.config.Genkit, new(sync.Mutex))
+	mu := lock.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+	return prompt.Render(ctx, input)
+}
+
+// promptCandidate returns a candidate naming the model the rendered dotprompt's
+// frontmatter declares, so escalation and downgrades can reason about it while the
+// prompt keeps selecting the model. Prompts declaring none run on the configured model.
+func (p *AgenticRAGProcessor) promptCandidate(rendered *ai.GenerateActionOptions) modelCandidate {
+	if rendered == nil || rendered.Model == "" {
+		return p.primaryCandidate()
+	}
+	return modelCandidate{name: rendered.Model, declared: true}
+}
+
+// callModel runs call against the primary model, escalating along the configured
+// larger-context models when the estimated prompt size exceeds the model's window
+// or the model rejects the prompt as too long
+func (p *AgenticRAGProcessor) callModel(ctx context.Context, primary modelCandidate, estimatedTokens int, call func(context.Context, modelCandidate) (*ai.ModelResponse, error)) (*ai.ModelResponse, error) {
+	// A model selected by the request takes precedence, then the model the prompt
+	// declares, then the verification model for fact verification, then a configured
+	// load balancer. Deterministic requests skip the balancer, whose pick depends on
+	// concurrent traffic. A verification model failing with a provider error leaves the
+	// call to the main model.
+	state, stage := requestStateFrom(ctx), stageFrom(ctx)
+	override := state.modelOverride(stage)
+	if override == "" && !primary.declared {
+		if verifier, ok := p.verificationCandidate(ctx); ok {
+			state.recordStageModel(stage, verifier.name, ModelSourceConfig)
+			response, err := p.escalateCall(ctx, verifier, estimatedTokens, call)
+			if !errors.Is(err, ErrClassProvider) || ctx.Err() != nil {
+				return response, err
+			}
+			p.fallBackFromVerificationModel(ctx, err)
+		}
+	}
+	source := ModelSourceConfig
+	switch {
+	case override != "":
+		primary, source = namedCandidate(override), ModelSourceRequest
+	case primary.declared:
+		source = ModelSourcePrompt
+	case p.balancer != nil && !state.isDeterministic():
+		primary = p.balancer.acquire().candidate()
+	}
+	state.recordStageModel(stage, primary.name, source)
+	return p.escalateCall(ctx, primary, estimatedTokens, call)
+}
+
+// escalateCall runs call against the model, escalating along the configured
+// larger-context models as callModel describes
+func (p *AgenticRAGProcessor) escalateCall(ctx context.Context, primary modelCandidate, estimatedTokens int, call func(context.Context, modelCandidate) (*ai.ModelResponse, error)) (*ai.ModelResponse, error) {
+	escalation := p.config.ContextEscalation
+	path := p.escalationPath(primary.name)
+	escalations := 0
+	canEscalate := func() bool {
+		return escalation.Enabled && escalations < escalation.MaxEscalations && len(path) > 0
+	}
+	escalate := func(from modelCandidate, reason string) modelCandidate {
+		next := namedCandidate(path[0])
+		path = path[1:]
+		escalations++
+		requestStateFrom(ctx).recordEscalation(ModelEscalation{From: from.name, To: next.name, Reason: reason})
+		return next
+	}
+
+	if err := checkBudget(ctx, estimatedTokens); err != nil {
+		if primary.instance != nil {
+			p.balancer.release(primary.instance, false)
+		}
+		return nil, err
+	}
+
+	model := primary
+	for {
+		// Pre-flight: don't send a prompt the model can't hold
+		if p.exceedsContextWindow(model.name, estimatedTokens) && canEscalate() {
+			if model.instance != nil {
+				p.balancer.release(model.instance, false)
+			}
+			model = escalate(model, EscalationReasonPreflight)
+			continue
+		}
+
+		response, err := p.withFailover(ctx, model, call)
+		if err == nil {
+			requestStateFrom(ctx).addTokens(stageFrom(ctx), responseTokens(response, estimatedTokens))
+			return response, nil
+		}
+		if !errors.Is(err, ErrClassContent) || !canEscalate() {
+			return nil, err
+		}
+		model = escalate(model, EscalationReasonContextError)
+	}
+}
+
+// withFailover runs call against the given model and then each fallback model
+// until one succeeds. Only provider-class errors move on to the next model; user
+// and content errors are returned immediately since another model won't fix them.
+func (p *AgenticRAGProcessor) withFailover(ctx context.Context, primary modelCandidate, call func(context.Context, modelCandidate) (*ai.ModelResponse, error)) (*ai.ModelResponse, error) {
+	candidates := []modelCandidate{primary}
+	for _, name := range p.config.FallbackModels {
+		candidates = append(candidates, namedCandidate(name))
+	}
+
+	var lastErr error
+	retried := -1 // Candidate slot already retried with its downgrade
+	for i := 0; i < len(candidates); i++ {
+		model := p.applyDowngrade(ctx, candidates[i])
+		attempts := 0
+		provider := modelProvider(model.name)
+		// Sum the backoff of the retries for the metrics, still calling the configured hook
+		var waited time.Duration
+		retryConfig, onAttempt := p.config.Retry, p.config.Retry.OnAttempt
+		retryConfig.OnAttempt = func(attempt RetryAttempt) {
+			waited += attempt.Delay
+			if onAttempt != nil {
+				onAttempt(attempt)
+			}
+		}
+		callCtx, span := p.startCallSpan(ctx, "agentic_rag.generate")
+		response, err := withRetry(callCtx, retryConfig, func(ctx context.Context) (*ai.ModelResponse, error) {
+			attempts++
+			start := time.Now()
+			response, err := call(ctx, model)
+			requestStateFrom(ctx).addModelTime(time.Since(start))
+			err = classifyError(model.name, err)
+			p.metrics.ModelCalled(provider, model.name, metricsOutcome(err))
+			return response, err
+		})
+		if waited > 0 {
+			p.metrics.RetryWaited(provider, model.name, waited)
+		}
+		requestStateFrom(ctx).countRetries(stageFrom(ctx), attempts-1)
+		endModelSpan(span, model.name, response, attempts-1, err)
+		if model.instance != nil {
+			p.balancer.release(model.instance, errors.Is(err, ErrClassProvider))
+		}
+		if err == nil {
+			p.downgrader.succeeded(model.name)
+			state := requestStateFrom(ctx)
+			state.recordModel(model.name)
+			state.countModelCall(stageFrom(ctx), model.name)
+			if model.instance != nil {
+				state.recordInstance(model.instance.Label)
+			}
+			return response, nil
+		}
+
+		lastErr = err
+		if !errors.Is(err, ErrClassProvider) || ctx.Err() != nil {
+			return nil, err
+		}
+		// A freshly downgraded model gets one more go at this candidate slot
+		if isQuotaExhausted(err) && p.noteQuotaExhausted(ctx, model) && retried != i {
+			retried = i
+			i--
+		}
+	}
+
+	return nil, lastErr
+}
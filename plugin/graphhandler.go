@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+// GraphHandler serves knowledge graphs as VisGraph JSON for web frontends. Mount it
+// at /graph:
+//
+//	jobs := plugin.NewJobManager(processor)
+//	http.Handle("/graph", plugin.NewGraphHandler(processor, jobs, plugin.VisExportOptions{Groups: true}))
+//
+// GET /graph?conversation=<id> returns the graph merged across a conversation's
+// turns, and GET /graph?job=<id> the graph of a finished job's response. The
+// min_confidence parameter overrides the export's minimum confidence.
+type GraphHandler struct {
+	processor *AgenticRAGProcessor
+	jobs      *JobManager
+	opts      VisExportOptions
+}
+
+// NewGraphHandler creates a handler serving the conversations of the processor and
+// the jobs of the job manager, which may be nil to serve conversations only
+func NewGraphHandler(p *AgenticRAGProcessor, jobs *JobManager, opts VisExportOptions) *GraphHandler {
+	return &GraphHandler{processor: p, jobs: jobs, opts: opts}
+}
+
+// ServeHTTP implements http.Handler
+func (h *GraphHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	opts := h.opts
+	if value := query.Get("min_confidence"); value != "" {
+		confidence, err := strconv.ParseFloat(value, 64)
+		if err != nil || confidence < 0 || confidence > 1 {
+			http.Error(w, fmt.Sprintf("min_confidence must be between 0 and 1, got %q", value), http.StatusBadRequest)
+			return
+		}
+		opts.MinConfidence = confidence
+	}
+
+	graph, status, err := h.graph(r, query.Get("conversation"), query.Get("job"))
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := graph.ExportVisJSON(w, opts); err != nil {
+		slog.ErrorContext(r.Context(), "failed to write graph", "error", err)
+	}
+}
+
+// graph returns the knowledge graph of the conversation or job, or the HTTP status
+// and error to answer with. A conversation or job without a graph has an empty one.
+func (h *GraphHandler) graph(r *http.Request, conversation, job string) (*KnowledgeGraph, int, error) {
+	switch {
+	case (conversation == "") == (job == ""):
+		return nil, http.StatusBadRequest, errors.New("give either a conversation or a job")
+	case conversation != "":
+		session, err := h.processor.sessions.Load(r.Context(), conversation)
+		if err != nil {
+			return nil, http.StatusInternalServerError, fmt.Errorf("failed to load conversation %s: %w", conversation, err)
+		}
+		if session == nil {
+			return nil, http.StatusNotFound, fmt.Errorf("conversation %s not found", conversation)
+		}
+		if session.KnowledgeGraph == nil {
+			return &KnowledgeGraph{}, http.StatusOK, nil
+		}
+		return session.KnowledgeGraph, http.StatusOK, nil
+	case h.jobs == nil:
+		return nil, http.StatusNotFound, ErrJobNotFound
+	}
+
+	response, err := h.jobs.Result(r.Context(), job)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return nil, http.StatusNotFound, err
+	case errors.Is(err, ErrJobNotFinished):
+		return nil, http.StatusConflict, err
+	case err != nil:
+		return nil, http.StatusInternalServerError, err
+	case response.KnowledgeGraph == nil:
+		return &KnowledgeGraph{}, http.StatusOK, nil
+	}
+	return response.KnowledgeGraph, http.StatusOK, nil
+}
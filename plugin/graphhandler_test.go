@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGraphHandler(t *testing.T) {
+	m := newTestJobManager(t, &mockProvider{}, nil)
+	p := m.processor
+
+	request := testRequest("Where is the Eiffel Tower?")
+	request.ConversationID = "conversation"
+	request.Options.EnableKnowledgeGraph = true
+	mustProcess(t, p, request)
+
+	request.ConversationID = ""
+	job, err := m.Submit(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	waitForJob(t, m, job)
+
+	handler := NewGraphHandler(p, m, VisExportOptions{Groups: true})
+	tests := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{"conversation", http.MethodGet, "/graph?conversation=conversation", http.StatusOK},
+		{"job", http.MethodGet, "/graph?job=" + job, http.StatusOK},
+		{"min confidence", http.MethodGet, "/graph?job=" + job + "&min_confidence=0.5", http.StatusOK},
+		{"unknown conversation", http.MethodGet, "/graph?conversation=unknown", http.StatusNotFound},
+		{"unknown job", http.MethodGet, "/graph?job=unknown", http.StatusNotFound},
+		{"neither", http.MethodGet, "/graph", http.StatusBadRequest},
+		{"both", http.MethodGet, "/graph?conversation=conversation&job=" + job, http.StatusBadRequest},
+		{"bad min confidence", http.MethodGet, "/graph?job=" + job + "&min_confidence=high", http.StatusBadRequest},
+		{"post", http.MethodPost, "/graph?job=" + job, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.target, nil))
+			if recorder.Code != tt.status {
+				t.Fatalf("status %d (%s), want %d", recorder.Code, recorder.Body, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var graph VisGraph
+			if err := json.Unmarshal(recorder.Body.Bytes(), &graph); err != nil {
+				t.Fatalf("response isn't a VisGraph: %v\n%s", err, recorder.Body)
+			}
+			if len(graph.Nodes) == 0 || graph.Nodes[0].Group == nil {
+				t.Errorf("graph %+v, want grouped nodes", graph)
+			}
+		})
+	}
+
+	// Without a job manager only conversations are served
+	recorder := httptest.NewRecorder()
+	NewGraphHandler(p, nil, VisExportOptions{}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/graph?job="+job, nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("job without a job manager: status %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,245 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// RelationFilter restricts the relations and entities a graph query walks
+type RelationFilter struct {
+	Predicates    []string // Only walk relations with these predicates, case-insensitively (default: all)
+	MinConfidence float64  // Skip relations and entities below this confidence
+}
+
+// Neighbor is an entity reached by walking relations from another
+type Neighbor struct {
+	Entity Entity     `json:"entity"`
+	Depth  int        `json:"depth"` // Relations walked from the start
+	Path   []Relation `json:"path"`  // Relations walked from the start, in order
+}
+
+// graphEdge is a relation as seen from one of its endpoints
+type graphEdge struct {
+	to       int // Node at the other end
+	relation int // Index in graphIndex.relations
+}
+
+// graphIndex is the adjacency of a knowledge graph, built in O(V+E). Entities are
+// found by ID or case-insensitive name, relations are walked in both directions, and
+// relation endpoints that name no entity become bare nodes without an entity.
+type graphIndex struct {
+	nodes     []Entity
+	bare      []bool
+	byRef     map[string]int
+	relations []Relation
+	edges     [][]graphEdge
+}
+
+// newGraphIndex indexes the entities and relations of kg that pass the filter
+func newGraphIndex(kg *KnowledgeGraph, filter RelationFilter) *graphIndex {
+	index := &graphIndex{byRef: make(map[string]int)}
+	if kg == nil {
+		return index
+	}
+	predicates := make(map[string]bool, len(filter.Predicates))
+	for _, predicate := range filter.Predicates {
+		predicates[strings.ToLower(predicate)] = true
+	}
+
+	excluded := make(map[string]bool) // Refs of entities below the confidence threshold
+	for _, entity := range kg.Entities {
+		if entity.Confidence < filter.MinConfidence {
+			excluded[entity.ID] = true
+			excluded[strings.ToLower(entity.Name)] = true
+			continue
+		}
+		index.add(entity, false)
+	}
+	for _, relation := range kg.Relations {
+		if relation.Confidence < filter.MinConfidence || (len(predicates) > 0 && !predicates[strings.ToLower(relation.Predicate)]) {
+			continue
+		}
+		subject, ok := index.endpoint(relation.Subject, excluded)
+		if !ok {
+			continue
+		}
+		object, ok := index.endpoint(relation.Object, excluded)
+		if !ok {
+			continue
+		}
+		i := len(index.relations)
+		index.relations = append(index.relations, relation)
+		index.edges[subject] = append(index.edges[subject], graphEdge{to: object, relation: i})
+		if object != subject {
+			index.edges[object] = append(index.edges[object], graphEdge{to: subject, relation: i})
+		}
+	}
+	return index
+}
+
+// add adds a node for the entity unless its ID or name is already indexed
+func (g *graphIndex) add(entity Entity, bare bool) int {
+	if i, ok := g.lookup(entity.ID); ok && entity.ID != "" {
+		return i
+	}
+	if i, ok := g.lookup(entity.Name); ok {
+		return i
+	}
+	i := len(g.nodes)
+	g.nodes = append(g.nodes, entity)
+	g.bare = append(g.bare, bare)
+	g.edges = append(g.edges, nil)
+	if entity.ID != "" {
+		g.byRef[entity.ID] = i
+	}
+	if name := strings.ToLower(entity.Name); name != "" {
+		g.byRef[name] = i
+	}
+	return i
+}
+
+// lookup finds the node of an entity ID or name
+func (g *graphIndex) lookup(ref string) (int, bool) {
+	if i, ok := g.byRef[ref]; ok {
+		return i, true
+	}
+	i, ok := g.byRef[strings.ToLower(ref)]
+	return i, ok
+}
+
+// endpoint returns the node of a relation endpoint, adding a bare node for refs that
+// name no entity, unless the entity was left out
+func (g *graphIndex) endpoint(ref string, excluded map[string]bool) (int, bool) {
+	if i, ok := g.lookup(ref); ok {
+		return i, true
+	}
+	if ref == "" || excluded[ref] || excluded[strings.ToLower(ref)] {
+		return 0, false
+	}
+	return g.add(Entity{Name: ref}, true), true
+}
+
+// bfs walks breadth-first from the start nodes for up to maxDepth relations, or
+// without limit when maxDepth is negative, stopping early once target is reached
+// unless it is -1. Returns the depth of every node, -1 where unreached, the edge each
+// reached node was first reached by, and the reached nodes in the order reached. Each
+// node and relation is visited once, so cycles terminate and a walk is O(V+E).
+func (g *graphIndex) bfs(starts []int, maxDepth, target int) ([]int, []graphEdge, []int) {
+	depth := make([]int, len(g.nodes))
+	for i := range depth {
+		depth[i] = -1
+	}
+	parent := make([]graphEdge, len(g.nodes))
+	queue := make([]int, 0, len(starts))
+	for _, start := range starts {
+		if depth[start] < 0 {
+			depth[start] = 0
+			parent[start] = graphEdge{to: -1, relation: -1}
+			queue = append(queue, start)
+		}
+	}
+	for next := 0; next < len(queue); next++ {
+		node := queue[next]
+		if node == target {
+			break
+		}
+		if maxDepth >= 0 && depth[node] >= maxDepth {
+			continue
+		}
+		for _, edge := range g.edges[node] {
+			if depth[edge.to] >= 0 {
+				continue
+			}
+			depth[edge.to] = depth[node] + 1
+			parent[edge.to] = graphEdge{to: node, relation: edge.relation}
+			queue = append(queue, edge.to)
+		}
+	}
+	return depth, parent, queue
+}
+
+// path returns the relations walked from a start node to node
+func (g *graphIndex) path(parent []graphEdge, node int) []Relation {
+	var path []Relation
+	for parent[node].relation >= 0 {
+		path = append(path, g.relations[parent[node].relation])
+		node = parent[node].to
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Neighbors returns the entities within depth relations of an entity, found by ID or
+// name, walking relations in either direction. Neighbors are ordered by depth, then
+// by the order the walk reached them, and carry the path that reached them. Relation
+// endpoints naming no entity are returned as entities with just a name. Runs a
+// breadth-first search in O(V+E).
+func (kg *KnowledgeGraph) Neighbors(entity string, depth int, filter RelationFilter) []Neighbor {
+	index := newGraphIndex(kg, filter)
+	start, ok := index.lookup(entity)
+	if !ok || depth <= 0 {
+		return nil
+	}
+	depths, parent, reached := index.bfs([]int{start}, depth, -1)
+
+	neighbors := make([]Neighbor, 0, len(reached)-1)
+	for _, node := range reached[1:] {
+		neighbors = append(neighbors, Neighbor{Entity: index.nodes[node], Depth: depths[node], Path: index.path(parent, node)})
+	}
+	return neighbors
+}
+
+// ShortestPath returns the fewest relations linking two entities, found by ID or name,
+// walking relations in either direction. Reports false when they aren't connected;
+// the path from an entity to itself is empty. Runs a breadth-first search in O(V+E).
+func (kg *KnowledgeGraph) ShortestPath(from, to string, filter RelationFilter) ([]Relation, bool) {
+	index := newGraphIndex(kg, filter)
+	start, ok := index.lookup(from)
+	if !ok {
+		return nil, false
+	}
+	target, ok := index.lookup(to)
+	if !ok {
+		return nil, false
+	}
+	depths, parent, _ := index.bfs([]int{start}, -1, target)
+	if depths[target] < 0 {
+		return nil, false
+	}
+	return index.path(parent, target), true
+}
+
+// Subgraph returns a new graph of the entities within hops relations of any of the
+// given entities, found by ID or name, and the relations between them. Entities and
+// relations keep their order in the graph. Runs a breadth-first search in O(V+E).
+func (kg *KnowledgeGraph) Subgraph(entities []string, hops int, filter RelationFilter) *KnowledgeGraph {
+	index := newGraphIndex(kg, filter)
+	subgraph := &KnowledgeGraph{Entities: make([]Entity, 0), Relations: make([]Relation, 0)}
+	var starts []int
+	for _, entity := range entities {
+		if node, ok := index.lookup(entity); ok {
+			starts = append(starts, node)
+		}
+	}
+	if len(starts) == 0 {
+		return subgraph
+	}
+	depths, _, _ := index.bfs(starts, max(0, hops), -1)
+
+	for node, entity := range index.nodes {
+		if depths[node] >= 0 && !index.bare[node] {
+			subgraph.Entities = append(subgraph.Entities, entity)
+		}
+	}
+	reached := func(ref string) bool {
+		node, ok := index.lookup(ref)
+		return ok && depths[node] >= 0
+	}
+	for _, relation := range index.relations {
+		if reached(relation.Subject) && reached(relation.Object) {
+			subgraph.Relations = append(subgraph.Relations, relation)
+		}
+	}
+	return subgraph
+}
@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+)
+
+// chainGraph returns a graph of n entities where entity i relates to entity i+1 and,
+// closing cycles, entity i+1 relates back to entity i/2
+func chainGraph(n int) *KnowledgeGraph {
+	kg := &KnowledgeGraph{}
+	name := func(i int) string { return fmt.Sprintf("Entity %d", i) }
+	for i := range n {
+		kg.Entities = append(kg.Entities, Entity{ID: fmt.Sprintf("entity_%d", i), Name: name(i), Type: "CONCEPT", Confidence: 0.9})
+		if i+1 < n {
+			kg.Relations = append(kg.Relations,
+				Relation{Subject: name(i), Predicate: "precedes", Object: name(i + 1), Confidence: 0.9},
+				Relation{Subject: name(i + 1), Predicate: "halves_to", Object: name(i / 2), Confidence: 0.6},
+			)
+		}
+	}
+	return kg
+}
+
+func TestKnowledgeGraphQueries(t *testing.T) {
+	kg := chainGraph(8)
+
+	neighbors := kg.Neighbors("entity 3", 1, RelationFilter{})
+	var names []string
+	for _, neighbor := range neighbors {
+		names = append(names, neighbor.Entity.Name)
+		if neighbor.Depth != 1 || len(neighbor.Path) != 1 {
+			t.Errorf("neighbor %s at depth %d with path %+v, want depth 1", neighbor.Entity.Name, neighbor.Depth, neighbor.Path)
+		}
+	}
+	if want := fmt.Sprint([]string{"Entity 2", "Entity 1", "Entity 4", "Entity 7"}); fmt.Sprint(names) != want {
+		t.Errorf("neighbors of Entity 3 = %v, want %s", names, want)
+	}
+
+	// The halving relations shortcut the chain, unless the filter skips them
+	tests := []struct {
+		name   string
+		filter RelationFilter
+		want   int
+	}{
+		{"all relations", RelationFilter{}, 3},
+		{"predicate", RelationFilter{Predicates: []string{"PRECEDES"}}, 7},
+		{"confidence", RelationFilter{MinConfidence: 0.8}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, ok := kg.ShortestPath("entity_7", "Entity 0", tt.filter)
+			if !ok || len(path) != tt.want {
+				t.Errorf("ShortestPath = %+v, %v, want %d relations", path, ok, tt.want)
+			}
+		})
+	}
+
+	subgraph := kg.Subgraph([]string{"Entity 0"}, 1, RelationFilter{})
+	if len(subgraph.Entities) != 3 || len(subgraph.Relations) != 4 {
+		t.Errorf("Subgraph = %+v, want Entity 0, 1 and 2 and the relations between them", subgraph)
+	}
+	if _, ok := kg.ShortestPath("Entity 0", "Nowhere", RelationFilter{}); ok {
+		t.Errorf("ShortestPath found a path to an unknown entity")
+	}
+}
+
+// BenchmarkKnowledgeGraph10k queries and merges a graph of 10k entities and 20k
+// relations. Each query builds its index and walks the graph once, so all of them
+// are O(V+E).
+func BenchmarkKnowledgeGraph10k(b *testing.B) {
+	const nodes = 10_000
+	kg := chainGraph(nodes)
+	first, last := "Entity 0", fmt.Sprintf("Entity %d", nodes-1)
+
+	b.Run("neighbors", func(b *testing.B) {
+		for range b.N {
+			if neighbors := kg.Neighbors(first, 3, RelationFilter{}); len(neighbors) == 0 {
+				b.Fatal("no neighbors")
+			}
+		}
+	})
+	b.Run("shortest_path", func(b *testing.B) {
+		for range b.N {
+			if _, ok := kg.ShortestPath(last, first, RelationFilter{Predicates: []string{"precedes"}}); !ok {
+				b.Fatal("no path")
+			}
+		}
+	})
+	b.Run("subgraph", func(b *testing.B) {
+		for range b.N {
+			if subgraph := kg.Subgraph([]string{first, last}, 2, RelationFilter{}); len(subgraph.Entities) == 0 {
+				b.Fatal("empty subgraph")
+			}
+		}
+	})
+	b.Run("merge", func(b *testing.B) {
+		// Half of the other graph's entities are new, half merge into existing ones
+		other := chainGraph(nodes + nodes/2)
+		other.Entities = other.Entities[nodes/2:]
+		for range b.N {
+			merged := &KnowledgeGraph{Entities: append([]Entity(nil), kg.Entities...), Relations: append([]Relation(nil), kg.Relations...)}
+			merged.Merge(other, MergePolicyMax)
+			if len(merged.Entities) != nodes+nodes/2 {
+				b.Fatalf("merged %d entities, want %d", len(merged.Entities), nodes+nodes/2)
+			}
+		}
+	})
+}
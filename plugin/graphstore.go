@@ -0,0 +1,360 @@
+package plugin
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// GraphStore persists the knowledge graph across requests, with a record of the chunk
+// contents already extracted into it. Entities are identified by name and type and
+// relations by subject, predicate and object, all case-insensitively, and stored with
+// the stable IDs KnowledgeGraph.AssignIDs derives from those. Implementations must be
+// safe for concurrent use.
+type GraphStore interface {
+	// UpsertEntities adds the entities, merging each into a stored entity with the same
+	// name and type by keeping the higher confidence and every document
+	UpsertEntities(ctx context.Context, entities []Entity) error
+	// UpsertRelations adds the relations not stored yet
+	UpsertRelations(ctx context.Context, relations []Relation) error
+	// Query returns the stored entities whose name occurs in text, and the relations
+	// whose subject or object is one of them
+	Query(ctx context.Context, text string) (*KnowledgeGraph, error)
+	// LoadAll returns every stored entity and relation
+	LoadAll(ctx context.Context) (*KnowledgeGraph, error)
+	// Clear removes every entity, relation and extracted content record
+	Clear(ctx context.Context) error
+	// Extracted reports which of the content hashes were extracted into the store
+	Extracted(ctx context.Context, hashes []string) (map[string]bool, error)
+	// MarkExtracted records the content hashes as extracted into the store
+	MarkExtracted(ctx context.Context, hashes []string) error
+	// DeleteDocument removes what the document contributed, as removeDocument does, and
+	// forgets the content hashes of its chunks as extracted
+	DeleteDocument(ctx context.Context, documentID string, hashes []string) error
+	// Neighbors returns the stored entities within depth relations of an entity, as
+	// KnowledgeGraph.Neighbors does
+	Neighbors(ctx context.Context, entity string, depth int, filter RelationFilter) ([]Neighbor, error)
+	// ShortestPath returns the fewest stored relations linking two entities, as
+	// KnowledgeGraph.ShortestPath does
+	ShortestPath(ctx context.Context, from, to string, filter RelationFilter) ([]Relation, bool, error)
+	// Subgraph returns the stored entities within hops relations of the entities and
+	// the relations between them, as KnowledgeGraph.Subgraph does
+	Subgraph(ctx context.Context, entities []string, hops int, filter RelationFilter) (*KnowledgeGraph, error)
+}
+
+// MemoryGraphStore is an in-process GraphStore
+type MemoryGraphStore struct {
+	mu        sync.Mutex
+	graph     *KnowledgeGraph
+	extracted map[string]bool
+}
+
+// NewMemoryGraphStore creates an empty in-memory store
+func NewMemoryGraphStore() *MemoryGraphStore {
+	return &MemoryGraphStore{extracted: make(map[string]bool)}
+}
+
+// UpsertEntities implements GraphStore
+func (s *MemoryGraphStore) UpsertEntities(ctx context.Context, entities []Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graph = mergeKnowledgeGraphs(s.graph, &KnowledgeGraph{Entities: entities})
+	return nil
+}
+
+// UpsertRelations implements GraphStore
+func (s *MemoryGraphStore) UpsertRelations(ctx context.Context, relations []Relation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graph = mergeKnowledgeGraphs(s.graph, &KnowledgeGraph{Relations: relations})
+	return nil
+}
+
+// Query implements GraphStore
+func (s *MemoryGraphStore) Query(ctx context.Context, text string) (*KnowledgeGraph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	text = strings.ToLower(text)
+	graph := &KnowledgeGraph{Entities: make([]Entity, 0), Relations: make([]Relation, 0)}
+	if s.graph == nil {
+		return graph, nil
+	}
+	names := make(map[string]bool)
+	for _, entity := range s.graph.Entities {
+		if name := strings.ToLower(entity.Name); name != "" && strings.Contains(text, name) {
+			names[name] = true
+			graph.Entities = append(graph.Entities, entity)
+		}
+	}
+	for _, relation := range s.graph.Relations {
+		if names[strings.ToLower(relation.Subject)] || names[strings.ToLower(relation.Object)] {
+			graph.Relations = append(graph.Relations, relation)
+		}
+	}
+	return mergeKnowledgeGraphs(nil, graph), nil
+}
+
+// LoadAll implements GraphStore
+func (s *MemoryGraphStore) LoadAll(ctx context.Context) (*KnowledgeGraph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.graph == nil {
+		return &KnowledgeGraph{Entities: make([]Entity, 0), Relations: make([]Relation, 0)}, nil
+	}
+	return mergeKnowledgeGraphs(nil, s.graph), nil
+}
+
+// Clear implements GraphStore
+func (s *MemoryGraphStore) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graph = nil
+	s.extracted = make(map[string]bool)
+	return nil
+}
+
+// Extracted implements GraphStore
+func (s *MemoryGraphStore) Extracted(ctx context.Context, hashes []string) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	extracted := make(map[string]bool)
+	for _, hash := range hashes {
+		if s.extracted[hash] {
+			extracted[hash] = true
+		}
+	}
+	return extracted, nil
+}
+
+// MarkExtracted implements GraphStore
+func (s *MemoryGraphStore) MarkExtracted(ctx context.Context, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hash := range hashes {
+		s.extracted[hash] = true
+	}
+	return nil
+}
+
+// DeleteDocument implements GraphStore
+func (s *MemoryGraphStore) DeleteDocument(ctx context.Context, documentID string, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, hash := range hashes {
+		delete(s.extracted, hash)
+	}
+	if s.graph == nil {
+		return nil
+	}
+	entityActions, relationActions := removeDocument(s.graph, documentID)
+	graph := &KnowledgeGraph{Entities: make([]Entity, 0, len(s.graph.Entities)), Relations: make([]Relation, 0, len(s.graph.Relations))}
+	for i, entity := range s.graph.Entities {
+		if entityActions[i] != removalDelete {
+			graph.Entities = append(graph.Entities, entity)
+		}
+	}
+	for i, relation := range s.graph.Relations {
+		if relationActions[i] != removalDelete {
+			graph.Relations = append(graph.Relations, relation)
+		}
+	}
+	s.graph = graph
+	return nil
+}
+
+// Neighbors implements GraphStore
+func (s *MemoryGraphStore) Neighbors(ctx context.Context, entity string, depth int, filter RelationFilter) ([]Neighbor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.graph.Neighbors(entity, depth, filter), nil
+}
+
+// ShortestPath implements GraphStore
+func (s *MemoryGraphStore) ShortestPath(ctx context.Context, from, to string, filter RelationFilter) ([]Relation, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path, ok := s.graph.ShortestPath(from, to, filter)
+	return path, ok, nil
+}
+
+// Subgraph implements GraphStore
+func (s *MemoryGraphStore) Subgraph(ctx context.Context, entities []string, hops int, filter RelationFilter) (*KnowledgeGraph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.graph.Subgraph(entities, hops, filter), nil
+}
+
+// contentHash returns the hex SHA-256 of content, identifying it in a GraphStore
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// resolveRelationNames replaces relation subjects and objects naming an entity of the
+// graph by its ID with the entity's name, so stored relations don't depend on the IDs
+// of one extraction
+func resolveRelationNames(kg *KnowledgeGraph) {
+	names := make(map[string]string, len(kg.Entities))
+	for _, entity := range kg.Entities {
+		if entity.ID != "" {
+			names[entity.ID] = entity.Name
+		}
+	}
+	for i := range kg.Relations {
+		if name, ok := names[kg.Relations[i].Subject]; ok {
+			kg.Relations[i].Subject = name
+		}
+		if name, ok := names[kg.Relations[i].Object]; ok {
+			kg.Relations[i].Object = name
+		}
+	}
+}
+
+// removalAction is what removing a document does to an entity or relation
+type removalAction int
+
+const (
+	removalKeep   removalAction = iota // Not contributed by the document
+	removalUpdate                      // Also contributed by other documents, updated in place
+	removalDelete                      // Only contributed by the document
+)
+
+// removeDocument drops the document and its mentions from the graph's entities and
+// relations in place, returning what became of each. Entities and relations left
+// without documents or mentions are deleted, and so are relations whose subject or
+// object no longer names an entity.
+func removeDocument(kg *KnowledgeGraph, documentID string) ([]removalAction, []removalAction) {
+	entityActions := make([]removalAction, len(kg.Entities))
+	deleted := make(map[string]bool)
+	kept := make(map[string]bool)
+	for i := range kg.Entities {
+		entity := &kg.Entities[i]
+		mentions, mentioned := withoutDocumentMentions(entity.Mentions, documentID)
+		documents := make([]string, 0, len(entity.DocumentIDs))
+		for _, id := range entity.DocumentIDs {
+			if id != documentID {
+				documents = append(documents, id)
+			}
+		}
+		name := strings.ToLower(entity.Name)
+		switch {
+		case !mentioned && len(documents) == len(entity.DocumentIDs):
+			kept[name] = true
+		case len(documents) == 0:
+			entityActions[i] = removalDelete
+			deleted[name] = true
+		default:
+			entity.DocumentIDs, entity.Mentions = documents, mentions
+			entityActions[i] = removalUpdate
+			kept[name] = true
+		}
+	}
+
+	relationActions := make([]removalAction, len(kg.Relations))
+	for i := range kg.Relations {
+		relation := &kg.Relations[i]
+		subject, object := strings.ToLower(relation.Subject), strings.ToLower(relation.Object)
+		mentions, mentioned := withoutDocumentMentions(relation.Mentions, documentID)
+		switch {
+		case (deleted[subject] && !kept[subject]) || (deleted[object] && !kept[object]):
+			relationActions[i] = removalDelete
+		case !mentioned:
+		case len(mentions) == 0:
+			relationActions[i] = removalDelete
+		default:
+			relation.Mentions = mentions
+			relationActions[i] = removalUpdate
+		}
+	}
+	return entityActions, relationActions
+}
+
+// withoutDocumentMentions returns the mentions outside the document, reporting whether
+// any was in it
+func withoutDocumentMentions(mentions []Mention, documentID string) ([]Mention, bool) {
+	kept := make([]Mention, 0, len(mentions))
+	for _, mention := range mentions {
+		if mention.DocumentID != documentID {
+			kept = append(kept, mention)
+		}
+	}
+	return kept, len(kept) != len(mentions)
+}
+
+// extractKnowledgeGraph extracts the knowledge graph of the chunks, resolves entity
+// types against the ontology, merges duplicate entities, validates relations and
+// locates entity mentions in the documents. With a graph store configured, only chunks
+// whose content wasn't extracted before go to the extractor, their graph is merged into
+// the store and the stored graph matching the chunks is returned.
+func (p *AgenticRAGProcessor) extractKnowledgeGraph(ctx context.Context, query string, chunks []DocumentChunk, documents []Document) (*KnowledgeGraph, error) {
+	state := requestStateFrom(ctx)
+	store := p.config.KnowledgeGraph.Store
+	if store == nil {
+		graph, err := p.extractor.Extract(ctx, ScoredChunks{Query: query, Chunks: chunks})
+		if err != nil {
+			return nil, err
+		}
+		p.resolveEntityTypes(ctx, graph)
+		attributeEntities(graph, chunks)
+		state.recordEntityMerges(p.deduplicateEntities(ctx, graph))
+		state.recordRelationValidation(validateRelations(graph, p.config.KnowledgeGraph))
+		locateMentions(graph, chunks, documents, state.languageHint())
+		graph.AssignIDs()
+		return graph, nil
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = contentHash(chunk.Content)
+	}
+	extracted, err := store.Extracted(ctx, hashes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read graph store: %w", err)
+	}
+	var pending []DocumentChunk
+	var pendingHashes []string
+	for i, chunk := range chunks {
+		if !extracted[hashes[i]] {
+			pending = append(pending, chunk)
+			pendingHashes = append(pendingHashes, hashes[i])
+		}
+	}
+
+	if len(pending) > 0 {
+		graph, err := p.extractor.Extract(ctx, ScoredChunks{Query: query, Chunks: pending})
+		if err != nil {
+			return nil, err
+		}
+		p.resolveEntityTypes(ctx, graph)
+		attributeEntities(graph, pending)
+		state.recordEntityMerges(p.deduplicateEntities(ctx, graph))
+		state.recordRelationValidation(validateRelations(graph, p.config.KnowledgeGraph))
+		locateMentions(graph, pending, documents, state.languageHint())
+		graph.AssignIDs()
+		if err := store.UpsertEntities(ctx, graph.Entities); err != nil {
+			return nil, fmt.Errorf("failed to store entities: %w", err)
+		}
+		if err := store.UpsertRelations(ctx, graph.Relations); err != nil {
+			return nil, fmt.Errorf("failed to store relations: %w", err)
+		}
+		if err := store.MarkExtracted(ctx, pendingHashes); err != nil {
+			return nil, fmt.Errorf("failed to store extracted chunks: %w", err)
+		}
+	}
+
+	var text strings.Builder
+	for _, chunk := range chunks {
+		text.WriteString(chunk.Content)
+		text.WriteString("\n")
+	}
+	graph, err := store.Query(ctx, text.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query graph store: %w", err)
+	}
+	graph.AssignIDs() // Entities stored before IDs were stable keep their old ones
+	return graph, nil
+}
@@ -0,0 +1,174 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// GroundednessLabel is the verdict on whether the chunks support an answer sentence
+type GroundednessLabel string
+
+const (
+	GroundednessGrounded     GroundednessLabel = "grounded"     // The chunks state or directly imply the sentence
+	GroundednessUngrounded   GroundednessLabel = "ungrounded"   // The chunks don't address the sentence
+	GroundednessContradicted GroundednessLabel = "contradicted" // The chunks say otherwise
+)
+
+// UngroundedPolicy selects what happens to answer sentences the chunks don't support
+type UngroundedPolicy string
+
+const (
+	UngroundedPolicyKeep   UngroundedPolicy = "keep"   // Only label them
+	UngroundedPolicyRemove UngroundedPolicy = "remove" // Drop them from the answer
+	UngroundedPolicyHedge  UngroundedPolicy = "hedge"  // Prefix them with ungroundedHedge
+)
+
+// ungroundedHedge is prefixed to unsupported sentences under UngroundedPolicyHedge
+const ungroundedHedge = "According to no provided source: "
+
+// listMarkerPattern matches a markdown list marker at the start of a sentence
+var listMarkerPattern = regexp.MustCompile(`^(?:[-*+]|\d+[.)])\s+`)
+
+// groundednessSchema is the JSON schema of the model's per-sentence verdicts
+var groundednessSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"sentences"},
+	"properties": map[string]any{
+		"sentences": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"index", "label"},
+				"properties": map[string]any{
+					"index":   map[string]any{"type": "integer"},
+					"label":   map[string]any{"type": "string", "enum": []any{"grounded", "ungrounded", "contradicted"}},
+					"sources": map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+				},
+			},
+		},
+	},
+}
+
+// checkGroundedness labels each sentence of the answer as grounded, ungrounded or
+// contradicted by the chunks, and applies the policy to the unsupported ones. Returns
+// the report and the answer after the policy. Sentences the model doesn't label count
+// as ungrounded.
+func (p *AgenticRAGProcessor) checkGroundedness(ctx context.Context, answer string, chunks []DocumentChunk, policy UngroundedPolicy, lang string) (*GroundednessReport, string, error) {
+	sentences := answerSentences(answer, lang)
+	if len(sentences) == 0 {
+		return nil, answer, nil
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(`Judge whether each numbered sentence of an answer is supported by the numbered sources below. Label each sentence:
+- grounded: the sources state or directly imply it
+- ungrounded: the sources don't address it
+- contradicted: the sources say otherwise
+Sentences without factual content, such as transitions, are grounded. List the numbers of the sources each verdict rests on.
+
+Sources:
+`)
+	for i, chunk := range chunks {
+		fmt.Fprintf(&prompt, "[%d] %s\n\n", i+1, chunk.Content)
+	}
+	prompt.WriteString("Sentences:\n")
+	for i, sentence := range sentences {
+		fmt.Fprintf(&prompt, "(%d) %s\n", i, strings.TrimSpace(sentence.text))
+	}
+	prompt.WriteString(`
+Example: {"sentences": [{"index": 0, "label": "grounded", "sources": [2]}, {"index": 1, "label": "ungrounded", "sources": []}]}`)
+
+	value, _, err := p.generateJSON(ctx, prompt.String(), groundednessSchema, &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 200 + 40*len(sentences),
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to check groundedness: %w", err)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse groundedness verdicts: %w", err)
+	}
+	var verdicts struct {
+		Sentences []struct {
+			Index   int               `json:"index"`
+			Label   GroundednessLabel `json:"label"`
+			Sources []int             `json:"sources"`
+		} `json:"sentences"`
+	}
+	if err := json.Unmarshal(data, &verdicts); err != nil {
+		return nil, "", fmt.Errorf("failed to parse groundedness verdicts: %w", err)
+	}
+
+	report := &GroundednessReport{Sentences: make([]SentenceGroundedness, len(sentences))}
+	for i, sentence := range sentences {
+		report.Sentences[i] = SentenceGroundedness{Sentence: strings.TrimSpace(sentence.text), Label: GroundednessUngrounded}
+	}
+	for _, verdict := range verdicts.Sentences {
+		if verdict.Index < 0 || verdict.Index >= len(sentences) {
+			continue
+		}
+		sentence := &report.Sentences[verdict.Index]
+		switch verdict.Label {
+		case GroundednessGrounded, GroundednessContradicted:
+			sentence.Label = verdict.Label
+		}
+		sentence.ChunkIDs = nil
+		for _, source := range verdict.Sources {
+			if source >= 1 && source <= len(chunks) {
+				sentence.ChunkIDs = append(sentence.ChunkIDs, chunks[source-1].ID)
+			}
+		}
+	}
+
+	grounded := 0
+	for _, sentence := range report.Sentences {
+		if sentence.Label == GroundednessGrounded {
+			grounded++
+		}
+	}
+	report.Ratio = float64(grounded) / float64(len(sentences))
+	return report, applyUngroundedPolicy(answer, sentences, report.Sentences, policy), nil
+}
+
+// applyUngroundedPolicy removes or hedges the sentences not labelled grounded, keeping
+// everything between the sentences as is
+func applyUngroundedPolicy(answer string, sentences []prosePart, verdicts []SentenceGroundedness, policy UngroundedPolicy) string {
+	if policy != UngroundedPolicyRemove && policy != UngroundedPolicyHedge {
+		return answer
+	}
+
+	result := ""
+	last := 0
+	for i, sentence := range sentences {
+		result += answer[last:sentence.start]
+		last = sentence.end
+		switch {
+		case verdicts[i].Label == GroundednessGrounded:
+			result += sentence.text
+		case policy == UngroundedPolicyHedge:
+			// The hedge goes after any indentation or list marker
+			text := sentence.text
+			body := strings.TrimLeftFunc(text, unicode.IsSpace)
+			if marker := listMarkerPattern.FindString(body); marker != "" {
+				body = body[len(marker):]
+			}
+			prefix := text[:len(text)-len(body)]
+			result += prefix + ungroundedHedge + body
+		default:
+			// Keep the line break ending a removed sentence, so list items stay apart
+			trailing := sentence.text[len(strings.TrimRightFunc(sentence.text, unicode.IsSpace)):]
+			if strings.Contains(trailing, "\n") && result != "" && !strings.HasSuffix(result, "\n") {
+				result = strings.TrimRight(result, " \t") + "\n"
+			}
+		}
+	}
+	result += answer[last:]
+	return strings.TrimSpace(result)
+}
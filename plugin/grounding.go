@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"google.golang.org/genai"
+)
+
+// isGeminiModel reports whether the named model supports Google Search grounding
+func isGeminiModel(name string) bool {
+	return (strings.HasPrefix(name, "googleai/") || strings.HasPrefix(name, "vertexai/")) &&
+		strings.Contains(name, "gemini")
+}
+
+// groundedPromptConfig returns the config that enables Google Search grounding for a
+// dotprompt execution, keeping the prompt's own generation config apart from a non-zero
+// maxOutputTokens. Returns nil when the prompt's model doesn't support grounding.
+func (p *AgenticRAGProcessor) groundedPromptConfig(ctx context.Context, prompt *ai.Prompt, input map[string]any, maxOutputTokens int) any {
+	rendered, err := p.renderPrompt(ctx, prompt, input)
+	if err != nil || rendered == nil || !isGeminiModel(rendered.Model) {
+		return nil
+	}
+
+	config, err := groundedConfig(rendered.Config)
+	if err != nil {
+		return nil
+	}
+	if maxOutputTokens > 0 {
+		config.MaxOutputTokens = int32(maxOutputTokens)
+	}
+	return config
+}
+
+// groundedConfig converts a generation config to a Gemini config with the Google Search tool enabled
+func groundedConfig(base any) (*genai.GenerateContentConfig, error) {
+	config := &genai.GenerateContentConfig{}
+	if base != nil {
+		// GenerationCommonConfig and prompt config maps share Gemini's field names
+		data, err := json.Marshal(base)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+	}
+
+	config.Tools = append(config.Tools, &genai.Tool{GoogleSearch: &genai.GoogleSearch{}})
+	return config, nil
+}
+
+// groundingFromResponse extracts the grounding metadata the provider attached to a
+// response, or nil if there is none
+func groundingFromResponse(response *ai.ModelResponse) *GroundingMetadata {
+	if response == nil || response.Custom == nil {
+		return nil
+	}
+
+	var raw *genai.GroundingMetadata
+	switch custom := response.Custom.(type) {
+	case *genai.GroundingMetadata:
+		raw = custom
+	case genai.GroundingMetadata:
+		raw = &custom
+	default:
+		// Generic custom data, e.g. {"groundingMetadata": {...}}
+		data, err := json.Marshal(custom)
+		if err != nil {
+			return nil
+		}
+		var wrapper struct {
+			GroundingMetadata *genai.GroundingMetadata `json:"groundingMetadata"`
+		}
+		if err := json.Unmarshal(data, &wrapper); err != nil {
+			return nil
+		}
+		raw = wrapper.GroundingMetadata
+	}
+	if raw == nil {
+		return nil
+	}
+
+	grounding := &GroundingMetadata{SearchQueries: raw.WebSearchQueries}
+	for _, chunk := range raw.GroundingChunks {
+		source := GroundingSource{}
+		if chunk != nil && chunk.Web != nil {
+			source = GroundingSource{URI: chunk.Web.URI, Title: chunk.Web.Title, Domain: chunk.Web.Domain}
+		}
+		// Keep empty sources so support indices stay aligned
+		grounding.Sources = append(grounding.Sources, source)
+	}
+	for _, support := range raw.GroundingSupports {
+		if support == nil {
+			continue
+		}
+		converted := GroundingSupport{}
+		if support.Segment != nil {
+			converted.Text = support.Segment.Text
+		}
+		for _, index := range support.GroundingChunkIndices {
+			converted.SourceIndices = append(converted.SourceIndices, int(index))
+		}
+		for _, score := range support.ConfidenceScores {
+			converted.Scores = append(converted.Scores, float64(score))
+		}
+		grounding.Supports = append(grounding.Supports, converted)
+	}
+
+	return grounding
+}
+
+// attachGroundingSources adds the URLs of the web sources supporting each claim,
+// matching claims to grounded text segments
+func attachGroundingSources(verification *FactVerification, grounding *GroundingMetadata) {
+	if verification == nil || grounding == nil {
+		return
+	}
+
+	for i := range verification.Claims {
+		claim := strings.ToLower(strings.TrimSpace(verification.Claims[i].Text))
+		if claim == "" {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, support := range grounding.Supports {
+			segment := strings.ToLower(strings.TrimSpace(support.Text))
+			if segment == "" || (!strings.Contains(segment, claim) && !strings.Contains(claim, segment)) {
+				continue
+			}
+			for _, index := range support.SourceIndices {
+				if index < 0 || index >= len(grounding.Sources) {
+					continue
+				}
+				uri := grounding.Sources[index].URI
+				if uri != "" && !seen[uri] {
+					seen[uri] = true
+					verification.Claims[i].Sources = append(verification.Claims[i].Sources, uri)
+				}
+			}
+		}
+	}
+}
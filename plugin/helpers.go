@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// builtinPromptHelpers are the helpers of handlebars and genkit, which can't be
+// redefined
+var builtinPromptHelpers = map[string]bool{
+	"if": true, "unless": true, "each": true, "with": true, "lookup": true, "log": true,
+	"role": true, "history": true, "section": true, "media": true, "json": true, "ifEquals": true, "unlessEquals": true,
+}
+
+// standardPromptHelpers returns the helpers registered when PromptsConfig.CustomHelpers
+// is set. Their names are reserved either way.
+func standardPromptHelpers() map[string]any {
+	return map[string]any{
+		// {{formatConfidence score}} formats a score with two decimals; confidence is its
+		// older name
+		"formatConfidence": formatConfidence,
+		"confidence":       formatConfidence,
+		// {{truncate text 500}} cuts text to at most length characters, marking the cut
+		// with an ellipsis
+		"truncate": func(text string, length int) string {
+			runes := []rune(text)
+			if len(runes) <= length {
+				return text
+			}
+			return string(runes[:max(length, 0)]) + "..."
+		},
+		// {{join items ", "}} joins a list of strings
+		"join": func(items []string, separator string) string {
+			return strings.Join(items, separator)
+		},
+		// {{jsonEscape text}} escapes text for a JSON string literal, without the quotes
+		"jsonEscape": func(text string) string {
+			var b strings.Builder
+			encoder := json.NewEncoder(&b)
+			encoder.SetEscapeHTML(false)
+			_ = encoder.Encode(text)
+			quoted := strings.TrimSpace(b.String())
+			return quoted[1 : len(quoted)-1]
+		},
+		// {{entityTypes types}} lists types in prose, e.g. "A, B and C"
+		"entityTypes": func(types []string) string {
+			if len(types) == 0 {
+				return ""
+			}
+			if len(types) == 1 {
+				return types[0]
+			}
+			return strings.Join(types[:len(types)-1], ", ") + " and " + types[len(types)-1]
+		},
+	}
+}
+
+// formatConfidence formats a confidence score with two decimals. It takes any number,
+// as templates pass integers and floats as they are.
+func formatConfidence(score any) string {
+	value := reflect.ValueOf(score)
+	switch {
+	case value.CanFloat():
+		return fmt.Sprintf("%.2f", value.Float())
+	case value.CanInt():
+		return fmt.Sprintf("%.2f", float64(value.Int()))
+	case value.CanUint():
+		return fmt.Sprintf("%.2f", float64(value.Uint()))
+	}
+	return fmt.Sprint(score)
+}
+
+// isPromptHelper reports whether templates can call name as a helper
+func (c PromptsConfig) isPromptHelper(name string) bool {
+	if builtinPromptHelpers[name] || c.Helpers[name] != nil {
+		return true
+	}
+	_, standard := standardPromptHelpers()[name]
+	return standard
+}
+
+// validatePromptHelper checks the helper's name is free and fn fits the helper
+// contract, see RegisterPromptHelper
+func validatePromptHelper(name string, fn any) error {
+	if name == "" || strings.ContainsAny(name, " \t\n.\"'(){}=/@") {
+		return fmt.Errorf("invalid helper name %q", name)
+	}
+	if _, standard := standardPromptHelpers()[name]; standard || builtinPromptHelpers[name] {
+		return fmt.Errorf("helper name %q is reserved", name)
+	}
+	t := reflect.TypeOf(fn)
+	if t == nil || t.Kind() != reflect.Func {
+		return fmt.Errorf("helper %q must be a function, got %T", name, fn)
+	}
+	if t.NumOut() != 1 {
+		return fmt.Errorf("helper %q must return exactly one value, returns %d", name, t.NumOut())
+	}
+	if t.IsVariadic() {
+		return fmt.Errorf("helper %q must not be variadic, templates call helpers with exactly their parameters", name)
+	}
+	return nil
+}
+
+// RegisterPromptHelper adds a helper templates can call, e.g. {{joinEntities entities}}.
+// It must be called before the processor first runs or its plugin is initialized.
+//
+// fn must be a function returning exactly one value, which is rendered into the
+// template; a string is inserted as is, other values are formatted. It takes exactly
+// the helper's arguments, in order, so it can't be variadic. Arguments are only
+// converted to string and bool parameters; any other parameter must accept the value
+// as the template passes it, e.g. int for a number literal, so take any for values of
+// several types. It must be safe for concurrent use. Names of the handlebars and genkit
+// helpers and of the standard helpers (formatConfidence, confidence, truncate, join,
+// jsonEscape and entityTypes) are reserved, and each name can be registered once.
+func (p *AgenticRAGProcessor) RegisterPromptHelper(name string, fn any) error {
+	if p.helpersRegistered.Load() {
+		return fmt.Errorf("prompt helper %q registered after the prompts were initialized", name)
+	}
+	if err := validatePromptHelper(name, fn); err != nil {
+		return err
+	}
+	if p.config.Prompts.Helpers[name] != nil {
+		return fmt.Errorf("helper %q is already registered", name)
+	}
+	if p.config.Prompts.Helpers == nil {
+		p.config.Prompts.Helpers = make(map[string]any)
+	}
+	p.config.Prompts.Helpers[name] = fn
+	return nil
+}
+
+// RegisterPromptHelper adds a helper the plugin's templates can call, see
+// AgenticRAGProcessor.RegisterPromptHelper. It must be called before Init.
+func (p *AgenticRAGPlugin) RegisterPromptHelper(name string, fn any) error {
+	return p.processor.RegisterPromptHelper(name, fn)
+}
+
+// registerHelpers registers the standard helpers, if enabled, and the helpers of
+// PromptsConfig.Helpers with genkit
+func (p *AgenticRAGProcessor) registerHelpers() {
+	p.helpersRegistered.Store(true)
+	g := p.config.Genkit
+	if p.config.Prompts.CustomHelpers {
+		for name, fn := range standardPromptHelpers() {
+			// Fails when another processor of the genkit instance registered them
+			_ = genkit.DefineHelper(g, name, fn)
+		}
+	}
+	for _, name := range sortedKeys(p.config.Prompts.Helpers) {
+		if err := genkit.DefineHelper(g, name, p.config.Prompts.Helpers[name]); err != nil {
+			slog.Warn("prompt helper already defined, keeping the existing one", "helper", name, "error", err)
+		}
+	}
+}
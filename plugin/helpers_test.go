@@ -0,0 +1,134 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// renderTemplate defines a prompt with the template on the processor's genkit instance
+// and returns the text it renders for the input
+func renderTemplate(t *testing.T, p *AgenticRAGProcessor, name, template string, input map[string]any) string {
+	t.Helper()
+	prompt, err := genkit.DefinePrompt(p.config.Genkit, name, ai.WithPrompt(template))
+	if err != nil {
+		t.Fatalf("failed to define %q: %v", template, err)
+	}
+	rendered, err := p.renderPrompt(context.Background(), prompt, input)
+	if err != nil {
+		t.Fatalf("failed to render %q: %v", template, err)
+	}
+	var text strings.Builder
+	for _, message := range rendered.Messages {
+		text.WriteString(message.Text())
+	}
+	return text.String()
+}
+
+func TestStandardPromptHelpers(t *testing.T) {
+	p := newTestProcessor(t, &mockProvider{}, nil)
+	tests := []struct {
+		name     string
+		template string
+		input    map[string]any
+		want     string
+	}{
+		{"truncate", "{{truncate text 5}}", map[string]any{"text": "Photosynthesis"}, "Photo..."},
+		{"truncate short", "{{truncate text 500}}", map[string]any{"text": "Paris"}, "Paris"},
+		{"truncate runes", "{{truncate text 3}}", map[string]any{"text": "エッフェル塔"}, "エッフ..."},
+		{"join", `{{join names ", "}}`, map[string]any{"names": []string{"Paris", "Lyon", "Nice"}}, "Paris, Lyon, Nice"},
+		{"formatConfidence", "{{formatConfidence score}}", map[string]any{"score": 0.876}, "0.88"},
+		{"confidence", "{{confidence score}}", map[string]any{"score": 1}, "1.00"},
+		{"jsonEscape", `{"quote": "{{jsonEscape text}}"}`, map[string]any{"text": "He said \"hi\"\n<b>"}, `{"quote": "He said \"hi\"\n<b>"}`},
+		{"entityTypes", "{{entityTypes types}}", map[string]any{"types": []string{"PERSON", "LOCATION", "CONCEPT"}}, "PERSON, LOCATION and CONCEPT"},
+		{"entityTypes one", "{{entityTypes types}}", map[string]any{"types": []string{"PERSON"}}, "PERSON"},
+		{"in a block", "{{#each chunks}}[{{truncate this 4}}]{{/each}}", map[string]any{"chunks": []string{"alpha", "be"}}, "[alph...][be]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renderTemplate(t, p, "standard_"+strings.ReplaceAll(tt.name, " ", "_"), tt.template, tt.input); got != tt.want {
+				t.Errorf("%s rendered %q, want %q", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterPromptHelper(t *testing.T) {
+	joinEntities := func(entities []any) string {
+		names := make([]string, 0, len(entities))
+		for _, entity := range entities {
+			if fields, ok := entity.(map[string]any); ok {
+				names = append(names, fields["name"].(string)+" ("+fields["type"].(string)+")")
+			}
+		}
+		return strings.Join(names, "; ")
+	}
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Prompts.Helpers = map[string]any{"shout": strings.ToUpper}
+	})
+	if err := p.RegisterPromptHelper("joinEntities", joinEntities); err == nil {
+		t.Errorf("registering after the prompts were initialized succeeded")
+	}
+
+	p = NewAgenticRAGProcessor(p.config)
+	if err := p.RegisterPromptHelper("joinEntities", joinEntities); err != nil {
+		t.Fatalf("RegisterPromptHelper: %v", err)
+	}
+	if err := p.initializePrompts(context.Background()); err != nil {
+		t.Fatalf("initializePrompts: %v", err)
+	}
+
+	input := map[string]any{
+		"query":    "where is it",
+		"entities": []any{map[string]any{"name": "Paris", "type": "LOCATION"}, map[string]any{"name": "Eiffel Tower", "type": "LANDMARK"}},
+	}
+	got := renderTemplate(t, p, "custom_helpers", "{{shout query}}: {{joinEntities entities}}", input)
+	if want := "WHERE IS IT: Paris (LOCATION); Eiffel Tower (LANDMARK)"; got != want {
+		t.Errorf("rendered %q, want %q", got, want)
+	}
+}
+
+func TestRegisterPromptHelperRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		helper string
+		fn     any
+		want   string
+	}{
+		{"handlebars helper", "each", strings.ToUpper, "reserved"},
+		{"genkit helper", "json", strings.ToUpper, "reserved"},
+		{"standard helper", "truncate", strings.ToUpper, "reserved"},
+		{"invalid name", "two words", strings.ToUpper, "invalid helper name"},
+		{"empty name", "", strings.ToUpper, "invalid helper name"},
+		{"not a function", "answer", 42, "must be a function"},
+		{"two results", "split", strings.Cut, "exactly one value"},
+		{"no result", "noop", func(string) {}, "exactly one value"},
+		{"variadic", "list", func(items ...any) []any { return items }, "variadic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewAgenticRAGProcessor(DefaultConfig())
+			err := p.RegisterPromptHelper(tt.helper, tt.fn)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("RegisterPromptHelper(%q) = %v, want an error mentioning %q", tt.helper, err, tt.want)
+			}
+		})
+	}
+
+	p := NewAgenticRAGProcessor(DefaultConfig())
+	if err := p.RegisterPromptHelper("shout", strings.ToUpper); err != nil {
+		t.Fatalf("RegisterPromptHelper: %v", err)
+	}
+	if err := p.RegisterPromptHelper("shout", strings.ToLower); err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("duplicate registration = %v, want an error", err)
+	}
+
+	config := DefaultConfig()
+	config.Prompts.Helpers = map[string]any{"if": strings.ToUpper}
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("Validate with a reserved helper name = %v, want an error", err)
+	}
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+)
+
+// StageEvent describes a pipeline stage to the stage hooks
+type StageEvent struct {
+	Stage     string // One of the Stage constants
+	RequestID string // ID of the request, also in ProcessingMetadata.RequestID
+	Input     string // Summary of the stage input, such as "12 chunks"
+	Output    string // Summary of the stage output, set for OnStageEnd when the stage completed
+	Err       error  // Why the stage failed or was skipped, set for OnStageEnd
+}
+
+// StageStartHook observes a stage starting
+type StageStartHook func(ctx context.Context, event StageEvent)
+
+// StageEndHook observes a stage ending. Returning an error stops the request with
+// ErrStageVetoed.
+type StageEndHook func(ctx context.Context, event StageEvent) error
+
+// OnStageStart adds a hook called as each stage starts. Hooks run synchronously in the
+// order added; a panicking hook is reported in ProcessingMetadata.Warnings.
+func OnStageStart(hook StageStartHook) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.startHooks = append(p.startHooks, hook) }
+}
+
+// OnStageEnd adds a hook called as each stage completes, fails or is skipped. Hooks run
+// synchronously in the order added; a panicking hook is reported in
+// ProcessingMetadata.Warnings and doesn't veto.
+func OnStageEnd(hook StageEndHook) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.endHooks = append(p.endHooks, hook) }
+}
+
+// newRequestID returns a random request ID
+func newRequestID() string {
+	return rand.Text()
+}
+
+// startStage begins timing and tracing the stage and runs the start hooks
+func (p *AgenticRAGProcessor) startStage(ctx context.Context, stage, input string) {
+	state := requestStateFrom(ctx)
+	state.beginStage(stage)
+	p.startStageSpan(ctx, state, stage)
+	if len(p.startHooks) == 0 && len(p.endHooks) == 0 {
+		return
+	}
+	state.openHookStage(stage, input)
+	event := StageEvent{Stage: stage, RequestID: state.id(), Input: input}
+	for _, hook := range p.startHooks {
+		runHook(state, "OnStageStart", stage, func() error {
+			hook(ctx, event)
+			return nil
+		})
+	}
+}
+
+// endStage runs the end hooks of the stage started last, with the stage error if it
+// failed or was skipped. Returns an ErrStageVetoed error if a hook vetoed.
+func (p *AgenticRAGProcessor) endStage(ctx context.Context, stage, output string, err error) error {
+	state := requestStateFrom(ctx)
+	p.endStageSpan(state, stage, output, err)
+	input, ok := state.closeHookStage(stage)
+	if !ok {
+		return nil
+	}
+	if err != nil {
+		output = ""
+	}
+	event := StageEvent{Stage: stage, RequestID: state.id(), Input: input, Output: output, Err: err}
+	for _, hook := range p.endHooks {
+		if vetoErr := runHook(state, "OnStageEnd", stage, func() error { return hook(ctx, event) }); vetoErr != nil {
+			return fmt.Errorf("%w after %s: %w", ErrStageVetoed, stage, vetoErr)
+		}
+	}
+	return nil
+}
+
+// failStage ends the spans of the stages still open and runs the end hooks of the
+// stage still open, if any, with the error that stopped the request
+func (p *AgenticRAGProcessor) failStage(ctx context.Context, err error) {
+	state := requestStateFrom(ctx)
+	p.endStageSpans(state, err)
+	if stage := state.hookStage(); stage != "" {
+		p.endStage(ctx, stage, "", err) // A veto is moot for a failing request
+	}
+}
+
+// runHook calls a hook, recovering a panic into a warning
+func runHook(state *requestState, name, stage string, call func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			state.recordWarning(fmt.Sprintf("%s hook panicked in stage %s: %v", name, stage, r))
+			err = nil
+		}
+	}()
+	return call()
+}
+
+// groundednessSummary summarizes a groundedness report for the stage hooks
+func groundednessSummary(report *GroundednessReport) string {
+	if report == nil {
+		return "no sentences"
+	}
+	return fmt.Sprintf("%d sentences, %.2f grounded", len(report.Sentences), report.Ratio)
+}
+
+// assessmentSummary summarizes an answer assessment for the stage hooks
+func assessmentSummary(assessment *answerAssessment) string {
+	if assessment == nil {
+		return ""
+	}
+	return fmt.Sprintf("confidence %.2f", assessment.Confidence)
+}
+
+// knowledgeGraphSummary summarizes a knowledge graph for the stage hooks
+func knowledgeGraphSummary(graph *KnowledgeGraph) string {
+	if graph == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d entities, %d relations", len(graph.Entities), len(graph.Relations))
+}
+
+// factVerificationSummary summarizes a fact verification for the stage hooks
+func factVerificationSummary(verification *FactVerification) string {
+	if verification == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d claims, %s", len(verification.Claims), verification.Overall)
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// stableEntityID derives the ID of an entity from its type and normalized name, so the
+// same entity gets the same ID across requests, processes and exports
+func stableEntityID(entity Entity) string {
+	name := normalizeEntityName(entity.Name)
+	if name == "" {
+		name = strings.ToLower(strings.TrimSpace(entity.Name))
+	}
+	return "kg_" + contentHash(strings.ToLower(strings.TrimSpace(entity.Type)) + "\x00" + name)[:16]
+}
+
+// stableRelationID derives the ID of a relation from the IDs of its endpoints and its
+// predicate
+func stableRelationID(subjectID, predicate, objectID string) string {
+	return "rel_" + contentHash(subjectID + "\x00" + strings.ToLower(strings.TrimSpace(predicate)) + "\x00" + objectID)[:16]
+}
+
+// edgeID returns the ID of an exported relation, derived from the IDs its endpoints
+// were exported with if it has none
+func edgeID(edge exportEdge, ids map[string]string) string {
+	if edge.relation.ID != "" {
+		return edge.relation.ID
+	}
+	return stableRelationID(ids[edge.source], edge.relation.Predicate, ids[edge.target])
+}
+
+// AssignIDs gives every entity and relation its stable ID. Relations referencing an
+// entity by a former ID are first rewritten to its name, as they're stored. A relation
+// with an endpoint outside the graph, as in a subgraph, keeps its ID; without one, the
+// endpoint counts as an untyped entity of that name.
+func (kg *KnowledgeGraph) AssignIDs() {
+	if kg == nil {
+		return
+	}
+	resolveRelationNames(kg)
+	ids := make(map[string]string, len(kg.Entities)) // Lowercased name to ID, the first entity of a name
+	for i := range kg.Entities {
+		entity := &kg.Entities[i]
+		entity.ID = stableEntityID(*entity)
+		if name := strings.ToLower(entity.Name); ids[name] == "" {
+			ids[name] = entity.ID
+		}
+	}
+	for i := range kg.Relations {
+		relation := &kg.Relations[i]
+		subjectID, subjectFound := ids[strings.ToLower(relation.Subject)]
+		objectID, objectFound := ids[strings.ToLower(relation.Object)]
+		if relation.ID != "" && (!subjectFound || !objectFound) {
+			continue
+		}
+		if !subjectFound {
+			subjectID = stableEntityID(Entity{Name: relation.Subject})
+		}
+		if !objectFound {
+			objectID = stableEntityID(Entity{Name: relation.Object})
+		}
+		relation.ID = stableRelationID(subjectID, relation.Predicate, objectID)
+	}
+}
+
+// UnmarshalJSON implements json.Unmarshaler, assigning stable IDs to graphs stored
+// before entities and relations had them
+func (kg *KnowledgeGraph) UnmarshalJSON(data []byte) error {
+	type plain KnowledgeGraph // Without this method
+	var decoded plain
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*kg = KnowledgeGraph(decoded)
+	kg.AssignIDs()
+	return nil
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// IngestReport is the outcome of ingesting one document
+type IngestReport struct {
+	DocumentID string `json:"document_id"`
+	Unchanged  bool   `json:"unchanged,omitempty"` // The document was ingested before with the same content, nothing was done
+	Chunks     int    `json:"chunks"`              // Chunks indexed
+	Entities   int    `json:"entities"`            // Entities of the stored graph found in the document
+	Relations  int    `json:"relations"`           // Relations between them
+	Error      string `json:"error,omitempty"`
+}
+
+// Ingest loads and chunks the documents, without a limit on the chunks per document,
+// extracts their knowledge graph into KnowledgeGraph.Store when one is configured and
+// the graph is enabled, and writes the chunks to Config.Indexer. Requests without
+// documents then answer from the ingested corpus. A document ingested before with the
+// same content is skipped, and one whose content changed replaces its old chunks and
+// graph contributions. Documents fail independently, reported in their IngestReport;
+// an error is returned only when no indexer is configured.
+func (p *AgenticRAGProcessor) Ingest(ctx context.Context, docs []Document) ([]IngestReport, error) {
+	if p.config.Indexer == nil {
+		return nil, errors.New("ingesting requires Config.Indexer")
+	}
+	ctx, _ = withRequestState(ctx)
+	reports := make([]IngestReport, len(docs))
+	for i, doc := range docs {
+		if doc.ID == "" {
+			doc.ID = fmt.Sprintf("doc_%d", i)
+		}
+		reports[i] = IngestReport{DocumentID: doc.ID}
+		if err := p.ingestDocument(ctx, doc, &reports[i]); err != nil {
+			reports[i].Error = err.Error()
+		}
+	}
+	return reports, nil
+}
+
+// ingestDocument ingests one document, filling in its report
+func (p *AgenticRAGProcessor) ingestDocument(ctx context.Context, doc Document, report *IngestReport) error {
+	loaded, err := p.loadDocuments(ctx, []Document{doc}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load document: %w", err)
+	}
+	doc = loaded[0]
+	hash := documentHash(doc)
+	stored, err := p.config.Indexer.DocumentHash(ctx, doc.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if stored == hash {
+		report.Unchanged = true
+		return nil
+	}
+	if stored != "" {
+		if err := p.Delete(ctx, doc.ID); err != nil {
+			return err
+		}
+	}
+
+	set, err := p.chunker.Chunk(ctx, loaded, math.MaxInt)
+	if err != nil {
+		return fmt.Errorf("failed to chunk document: %w", err)
+	}
+	// The graph goes first: the indexer records the document hash, after which the
+	// document is skipped
+	if p.config.KnowledgeGraph.Enabled && p.config.KnowledgeGraph.Store != nil && len(set.Chunks) > 0 {
+		graph, err := p.extractKnowledgeGraph(ctx, "", set.Chunks, loaded)
+		if err != nil {
+			return fmt.Errorf("failed to extract knowledge graph: %w", err)
+		}
+		report.Entities, report.Relations = len(graph.Entities), len(graph.Relations)
+	}
+	if err := p.config.Indexer.Index(ctx, loaded, set.Chunks); err != nil {
+		return fmt.Errorf("failed to index chunks: %w", err)
+	}
+	report.Chunks = len(set.Chunks)
+	return nil
+}
+
+// Delete removes an ingested document: its chunks from Config.Indexer and what it
+// contributed to the graph in KnowledgeGraph.Store. Entities and relations other
+// documents also contributed are kept without the document's mentions.
+func (p *AgenticRAGProcessor) Delete(ctx context.Context, documentID string) error {
+	if p.config.Indexer == nil {
+		return errors.New("deleting requires Config.Indexer")
+	}
+	hashes, err := p.config.Indexer.Delete(ctx, documentID)
+	if err != nil {
+		return fmt.Errorf("failed to delete document %s from the index: %w", documentID, err)
+	}
+	if store := p.config.KnowledgeGraph.Store; store != nil {
+		if err := store.DeleteDocument(ctx, documentID, hashes); err != nil {
+			return fmt.Errorf("failed to delete document %s from the graph store: %w", documentID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Sources of prompts besides the prompts directory and the embedded defaults
+const (
+	PromptSourceInline  = "inline"  // Given in PromptsConfig.Inline
+	PromptSourceBuiltin = "builtin" // The hardcoded prompt the stage falls back to
+)
+
+// PromptDescription describes a prompt the processor runs with, see DescribePrompts
+type PromptDescription struct {
+	Key     string `json:"key"`               // Key of the prompt in PromptsConfig.Variants, e.g. "relevance_scoring"
+	Name    string `json:"name"`              // Configured name, including the variant
+	Variant string `json:"variant,omitempty"` // Variant, empty for the default prompt
+	Source  string `json:"source"`            // PromptSourceInline, PromptSourceDirectory, PromptSourceEmbedded or PromptSourceBuiltin
+}
+
+// inlinePromptNamespace returns the genkit namespace of an inline prompt. Each version
+// registers under its own namespace, as genkit can't replace a registered prompt.
+func inlinePromptNamespace(source string) string {
+	return "agentic-rag-inline-" + contentHash(source)[:16]
+}
+
+// loadInlinePrompts registers the prompts of PromptsConfig.Inline, logging each
+func (p *AgenticRAGProcessor) loadInlinePrompts(ctx context.Context) error {
+	if len(p.config.Prompts.Inline) == 0 {
+		return nil
+	}
+	p.inline = make(map[string]*ai.Prompt, len(p.config.Prompts.Inline))
+	for _, name := range sortedKeys(p.config.Prompts.Inline) {
+		source := p.config.Prompts.Inline[name]
+		prompt, err := loadPromptSource(p.config.Genkit, inlinePromptNamespace(source), name, source)
+		if err != nil {
+			return fmt.Errorf("failed to load inline prompt %s: %w", name, err)
+		}
+		p.inline[name] = prompt
+		slog.InfoContext(ctx, "loaded prompt", "prompt", name, "source", PromptSourceInline)
+	}
+	return nil
+}
+
+// promptKeyOf returns the key of the prompt the name, possibly with a variant, is
+// configured for
+func (c PromptsConfig) promptKeyOf(name string) (string, bool) {
+	base, _, _ := strings.Cut(name, ".")
+	names := c.promptNames()
+	for _, key := range sortedKeys(names) {
+		if names[key] != "" && names[key] == base {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// DescribePrompts lists the prompts the processor runs with and where each comes from:
+// the configured prompt of every stage, and each variant configured, given inline or
+// found in the prompts directory. Prompts reloaded from the directory count as the
+// directory's.
+func (p *AgenticRAGProcessor) DescribePrompts(ctx context.Context) ([]PromptDescription, error) {
+	if err := p.initializePrompts(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	var descriptions []PromptDescription
+	for _, key := range sortedKeys(promptInputs) {
+		name := p.config.Prompts.promptNames()[key]
+		if name == "" {
+			continue
+		}
+		descriptions = append(descriptions, PromptDescription{Key: key, Name: name, Source: p.activePromptSource(ctx, key, name)})
+
+		variants := p.availableVariants(name)
+		if variant := p.config.Prompts.Variants[key]; variant != "" {
+			variants = append(variants, variant)
+		}
+		seen := make(map[string]bool)
+		for _, variant := range variants {
+			if seen[variant] {
+				continue
+			}
+			seen[variant] = true
+			variantName := name + "." + variant
+			descriptions = append(descriptions, PromptDescription{Key: key, Name: variantName, Variant: variant, Source: p.activePromptSource(ctx, key, variantName)})
+		}
+	}
+	return descriptions, nil
+}
+
+// activePromptSource returns where the named prompt is taken from, following lookupPrompt
+func (p *AgenticRAGProcessor) activePromptSource(ctx context.Context, key, name string) string {
+	switch {
+	case p.inline[name] != nil:
+		return PromptSourceInline
+	case p.reloadedPrompt(ctx, name) != nil || genkit.LookupPrompt(p.config.Genkit, name) != nil:
+		return PromptSourceDirectory
+	case !strings.Contains(name, ".") && p.embedded[key] != nil:
+		return PromptSourceEmbedded
+	case p.partialVariants[name] != nil:
+		// The variant overrides partials of the prompt's template
+		base, _, _ := strings.Cut(name, ".")
+		return p.activePromptSource(ctx, key, base)
+	}
+	return PromptSourceBuiltin
+}
@@ -0,0 +1,400 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle state of an asynchronous job
+type JobStatus string
+
+const (
+	JobStatusQueued    JobStatus = "queued"    // Waiting for a worker
+	JobStatusRunning   JobStatus = "running"   // Being processed, see Stage and Percent
+	JobStatusDone      JobStatus = "done"      // Finished, see Response
+	JobStatusFailed    JobStatus = "failed"    // Finished with an error, see Error
+	JobStatusCancelled JobStatus = "cancelled" // Aborted by Cancel or Close
+)
+
+// jobStages are the pipeline stages in the order they run, for the percent complete
+var jobStages = []string{
+	StageLoading,
+	StageQueryRewrite,
+	StageRetrieval,
+	StageChunking,
+	StageCommunityRouting,
+	StageRelevanceScoring,
+	StageReranking,
+	StageDiversification,
+	StageGraphExpansion,
+	StageRefinement,
+	StageGeneration,
+	StageGroundedness,
+	StageAnswerAssessment,
+	StageKnowledgeGraph,
+	StageFactVerification,
+}
+
+// Job is the state of a request submitted to a JobManager
+type Job struct {
+	ID          string              `json:"id"`
+	Status      JobStatus           `json:"status"`
+	Stage       string              `json:"stage,omitempty"`    // Stage last reported while running
+	Percent     float64             `json:"percent"`            // Estimated progress, 0-100
+	Error       string              `json:"error,omitempty"`    // Why the job failed or was cancelled
+	Response    *AgenticRAGResponse `json:"response,omitempty"` // Set once the job is done
+	SubmittedAt time.Time           `json:"submitted_at"`
+	StartedAt   time.Time           `json:"started_at,omitzero"`
+	FinishedAt  time.Time           `json:"finished_at,omitzero"`
+}
+
+// finished reports whether the job reached a final status
+func (j *Job) finished() bool {
+	return j.Status == JobStatusDone || j.Status == JobStatusFailed || j.Status == JobStatusCancelled
+}
+
+// JobStore persists jobs for a JobManager. Implementations must be safe for
+// concurrent use.
+type JobStore interface {
+	// Load returns the job with the given ID, or nil if it doesn't exist or expired
+	Load(ctx context.Context, id string) (*Job, error)
+	// Save stores the job, replacing any earlier version
+	Save(ctx context.Context, job *Job) error
+	// Delete removes the job with the given ID, if any
+	Delete(ctx context.Context, id string) error
+}
+
+// MemoryJobStore is an in-process JobStore whose finished jobs expire a fixed time
+// after they finish
+type MemoryJobStore struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewMemoryJobStore creates an in-memory store. A ttl of 0 keeps finished jobs forever.
+func NewMemoryJobStore(ttl time.Duration) *MemoryJobStore {
+	return &MemoryJobStore{ttl: ttl, jobs: make(map[string]*Job)}
+}
+
+// Load implements JobStore. The returned job is a copy.
+func (s *MemoryJobStore) Load(ctx context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, nil
+	}
+	if s.expired(job) {
+		delete(s.jobs, id)
+		return nil, nil
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// Save implements JobStore, dropping expired jobs along the way
+func (s *MemoryJobStore) Save(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, existing := range s.jobs {
+		if s.expired(existing) {
+			delete(s.jobs, id)
+		}
+	}
+	copied := *job
+	s.jobs[job.ID] = &copied
+	return nil
+}
+
+// Delete implements JobStore
+func (s *MemoryJobStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.jobs, id)
+	return nil
+}
+
+// expired reports whether the finished job outlived the TTL
+func (s *MemoryJobStore) expired(job *Job) bool {
+	return s.ttl > 0 && job.finished() && time.Since(job.FinishedAt) > s.ttl
+}
+
+// activeJob is a job submitted to this manager that hasn't finished yet
+type activeJob struct {
+	job     *Job // Guarded by JobManager.mu
+	version int  // Updates applied to job, guarded by JobManager.mu
+	request AgenticRAGRequest
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	saveMu sync.Mutex // Serializes the saves of the job
+	saved  int        // Version of the last save, guarded by saveMu
+}
+
+// JobManager runs requests in the background on a bounded pool of workers, so callers
+// can submit a request and poll for its result
+type JobManager struct {
+	processor *AgenticRAGProcessor
+	store     JobStore
+	queue     chan *activeJob
+
+	mu     sync.Mutex
+	active map[string]*activeJob
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewJobManager starts Jobs.Workers workers for the processor, storing jobs in the
+// configured JobStore or an in-memory store keeping results for Jobs.ResultTTL
+func NewJobManager(processor *AgenticRAGProcessor) *JobManager {
+	config := processor.config.Jobs
+	store := processor.config.JobStore
+	if store == nil {
+		store = NewMemoryJobStore(config.ResultTTL)
+	}
+	workers := max(config.Workers, 1)
+
+	m := &JobManager{
+		processor: processor,
+		store:     store,
+		queue:     make(chan *activeJob, max(config.QueueSize, 0)),
+		active:    make(map[string]*activeJob),
+	}
+	m.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.work()
+	}
+	return m
+}
+
+// Submit validates the request and queues it, returning the job ID immediately. The
+// job keeps ctx's values but not its cancellation; use Cancel to abort it.
+func (m *JobManager) Submit(ctx context.Context, request AgenticRAGRequest) (string, error) {
+	if err := m.processor.ValidateRequest(request); err != nil {
+		return "", err
+	}
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	active := &activeJob{
+		job:     &Job{ID: id, Status: JobStatusQueued, SubmittedAt: time.Now()},
+		request: request,
+		ctx:     jobCtx,
+		cancel:  cancel,
+	}
+
+	// The job isn't shared yet, so it's saved without holding the lock
+	if err := m.store.Save(ctx, active.job); err != nil {
+		cancel()
+		return "", fmt.Errorf("failed to save job %s: %w", id, err)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		m.deleteJob(ctx, id)
+		return "", ErrJobManagerClosed
+	}
+	m.active[id] = active
+	select {
+	case m.queue <- active:
+		m.mu.Unlock()
+		return id, nil
+	default:
+		delete(m.active, id)
+		m.mu.Unlock()
+		cancel()
+		m.deleteJob(ctx, id)
+		return "", ErrJobQueueFull
+	}
+}
+
+// Status returns the current state of the job, or ErrJobNotFound if it doesn't exist
+// or its result expired
+func (m *JobManager) Status(ctx context.Context, id string) (*Job, error) {
+	job, err := m.store.Load(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job %s: %w", id, err)
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// Result returns the response of a finished job. It returns ErrJobNotFinished while
+// the job is queued or running, and the job's error if it failed or was cancelled.
+func (m *JobManager) Result(ctx context.Context, id string) (*AgenticRAGResponse, error) {
+	job, err := m.Status(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case JobStatusDone:
+		return job.Response, nil
+	case JobStatusFailed, JobStatusCancelled:
+		return nil, fmt.Errorf("job %s %s: %s", id, job.Status, job.Error)
+	default:
+		return nil, ErrJobNotFinished
+	}
+}
+
+// Cancel aborts a queued or running job. Cancelling a finished job has no effect.
+func (m *JobManager) Cancel(ctx context.Context, id string) error {
+	m.mu.Lock()
+	active, ok := m.active[id]
+	m.mu.Unlock()
+	if ok {
+		active.cancel()
+		return nil
+	}
+	_, err := m.Status(ctx, id)
+	return err
+}
+
+// Close stops accepting jobs, cancels the queued and running ones and waits for the
+// workers to exit
+func (m *JobManager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	for _, active := range m.active {
+		active.cancel()
+	}
+	close(m.queue)
+	m.mu.Unlock()
+	m.wg.Wait()
+}
+
+// work runs queued jobs until the queue is closed
+func (m *JobManager) work() {
+	defer m.wg.Done()
+	for active := range m.queue {
+		m.run(active)
+	}
+}
+
+// run processes one job, recording its progress and outcome in the store
+func (m *JobManager) run(active *activeJob) {
+	defer func() {
+		active.cancel()
+		m.mu.Lock()
+		delete(m.active, active.job.ID)
+		m.mu.Unlock()
+	}()
+
+	if err := active.ctx.Err(); err != nil {
+		m.finish(active, nil, err)
+		return
+	}
+	m.update(active, func(job *Job) {
+		job.Status = JobStatusRunning
+		job.StartedAt = time.Now()
+	})
+
+	request := active.request
+	onProgress := request.Options.OnProgress
+	request.Options.OnProgress = func(stage string, done, total int) {
+		m.update(active, func(job *Job) {
+			job.Stage = stage
+			job.Percent = max(job.Percent, stagePercent(stage, done, total))
+		})
+		if onProgress != nil {
+			onProgress(stage, done, total)
+		}
+	}
+
+	response, err := m.processor.Process(active.ctx, request)
+	m.finish(active, response, err)
+}
+
+// update applies fn to a running job and saves a copy of it. Updates after the job
+// finished, such as late progress reports, are ignored. Saves happen outside the
+// manager lock; a save overtaken by a later update's is skipped, so a late progress
+// report can't overwrite the outcome. A failing save is logged.
+func (m *JobManager) update(active *activeJob, fn func(job *Job)) {
+	m.mu.Lock()
+	if active.job.finished() {
+		m.mu.Unlock()
+		return
+	}
+	fn(active.job)
+	active.version++
+	job, version := *active.job, active.version
+	m.mu.Unlock()
+
+	active.saveMu.Lock()
+	defer active.saveMu.Unlock()
+	if version < active.saved {
+		return
+	}
+	active.saved = version
+	ctx := context.WithoutCancel(active.ctx) // Record the outcome even when cancelled
+	if err := m.store.Save(ctx, &job); err != nil {
+		slog.ErrorContext(ctx, "failed to save job", "job_id", job.ID, "status", job.Status, "error", err)
+	}
+}
+
+// deleteJob removes a job that was never queued from the store, logging a failure
+func (m *JobManager) deleteJob(ctx context.Context, id string) {
+	if err := m.store.Delete(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "failed to delete job", "job_id", id, "error", err)
+	}
+}
+
+// finish records the outcome of a job
+func (m *JobManager) finish(active *activeJob, response *AgenticRAGResponse, err error) {
+	m.update(active, func(job *Job) {
+		job.FinishedAt = time.Now()
+		switch {
+		case err == nil:
+			job.Status, job.Percent, job.Response = JobStatusDone, 100, response
+		case errors.Is(err, context.Canceled) && active.ctx.Err() != nil:
+			job.Status, job.Error = JobStatusCancelled, err.Error()
+		default:
+			job.Status, job.Error = JobStatusFailed, err.Error()
+		}
+	})
+}
+
+// stagePercent estimates the overall progress from the progress within a stage
+func stagePercent(stage string, done, total int) float64 {
+	for i, s := range jobStages {
+		if s != stage {
+			continue
+		}
+		fraction := 0.0
+		if total > 0 {
+			fraction = min(float64(done)/float64(total), 1)
+		}
+		return (float64(i) + fraction) / float64(len(jobStages)) * 100
+	}
+	return 0
+}
+
+// newJobID returns a random job ID
+func newJobID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b[:]), nil
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingJobStore is a MemoryJobStore recording every save, failing the saves fail
+// returns an error for
+type recordingJobStore struct {
+	*MemoryJobStore
+	fail func(job *Job) error
+
+	mu    sync.Mutex
+	saves []Job
+}
+
+// Save implements JobStore
+func (s *recordingJobStore) Save(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	s.saves = append(s.saves, *job)
+	s.mu.Unlock()
+	if s.fail != nil {
+		if err := s.fail(job); err != nil {
+			return err
+		}
+	}
+	return s.MemoryJobStore.Save(ctx, job)
+}
+
+// saved returns the jobs saved so far, in save order
+func (s *recordingJobStore) saved() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Job(nil), s.saves...)
+}
+
+// newTestJobManager returns a job manager with one worker running on the mock
+// provider. configure, when set, adjusts the configuration.
+func newTestJobManager(t *testing.T, mock *mockProvider, configure func(*AgenticRAGConfig)) *JobManager {
+	t.Helper()
+	p := newTestProcessor(t, mock, func(config *AgenticRAGConfig) {
+		config.Jobs.Workers = 1
+		if configure != nil {
+			configure(config)
+		}
+	})
+	m := NewJobManager(p)
+	t.Cleanup(m.Close)
+	return m
+}
+
+// waitForJob polls the job until it finishes
+func waitForJob(t *testing.T, m *JobManager, id string) *Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		job, err := m.Status(context.Background(), id)
+		if err != nil {
+			t.Fatalf("Status: %v", err)
+		}
+		if job.finished() {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s still %s", id, job.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingProvider returns a mock whose first call blocks until release is closed,
+// closing started once it blocks
+func blockingProvider() (mock *mockProvider, started, release chan struct{}) {
+	started, release = make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	mock = &mockProvider{fail: func(string) error {
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		return nil
+	}}
+	return mock, started, release
+}
+
+func TestJobManagerRunsJob(t *testing.T) {
+	store := &recordingJobStore{MemoryJobStore: NewMemoryJobStore(0)}
+	m := newTestJobManager(t, &mockProvider{}, withJobStore(store))
+
+	id, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?"))
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	job := waitForJob(t, m, id)
+	if job.Status != JobStatusDone || job.Percent != 100 || job.Response == nil {
+		t.Fatalf("job = %+v, want done at 100%% with a response", job)
+	}
+	if job.StartedAt.IsZero() || job.FinishedAt.Before(job.StartedAt) {
+		t.Errorf("job started at %v and finished at %v", job.StartedAt, job.FinishedAt)
+	}
+	response, err := m.Result(context.Background(), id)
+	if err != nil || response.Answer == "" {
+		t.Errorf("Result = %v, %v, want the answer", response, err)
+	}
+
+	// Queued first, then running with progress that never goes back, done last
+	saves := store.saved()
+	if saves[0].Status != JobStatusQueued || saves[len(saves)-1].Status != JobStatusDone {
+		t.Fatalf("saved statuses %v, want queued first and done last", jobStatuses(saves))
+	}
+	progress := 0
+	for i, saved := range saves[1 : len(saves)-1] {
+		if saved.Status != JobStatusRunning {
+			t.Errorf("save %d has status %s, want running", i+1, saved.Status)
+		}
+		if saved.Stage != "" {
+			progress++
+		}
+		if saved.Percent < saves[i].Percent {
+			t.Errorf("save %d went back from %.0f%% to %.0f%%", i+1, saves[i].Percent, saved.Percent)
+		}
+	}
+	if progress == 0 {
+		t.Errorf("no progress was saved")
+	}
+}
+
+func TestJobManagerCancel(t *testing.T) {
+	mock, started, release := blockingProvider()
+	m := newTestJobManager(t, mock, nil)
+
+	id, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?"))
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	if err := m.Cancel(context.Background(), id); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	close(release)
+
+	job := waitForJob(t, m, id)
+	if job.Status != JobStatusCancelled || job.Error == "" {
+		t.Errorf("job = %+v, want cancelled with the error", job)
+	}
+	if _, err := m.Result(context.Background(), id); err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Errorf("Result returned %v, want the cancellation", err)
+	}
+	// Cancelling a finished job has no effect
+	if err := m.Cancel(context.Background(), id); err != nil {
+		t.Errorf("Cancel of a finished job returned %v", err)
+	}
+	if err := m.Cancel(context.Background(), "unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Cancel of an unknown job returned %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobManagerQueueFullAndClosed(t *testing.T) {
+	mock, started, release := blockingProvider()
+	m := newTestJobManager(t, mock, func(config *AgenticRAGConfig) { config.Jobs.QueueSize = 1 })
+
+	if _, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?")); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	// The only worker is busy, the second job fills the queue
+	if _, err := m.Submit(context.Background(), testRequest("Where is the Pacific?")); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if _, err := m.Submit(context.Background(), testRequest("What is photosynthesis?")); !errors.Is(err, ErrJobQueueFull) {
+		t.Errorf("Submit to a full queue returned %v, want ErrJobQueueFull", err)
+	}
+
+	close(release)
+	m.Close()
+	if _, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?")); !errors.Is(err, ErrJobManagerClosed) {
+		t.Errorf("Submit after Close returned %v, want ErrJobManagerClosed", err)
+	}
+}
+
+func TestJobManagerStoreFailure(t *testing.T) {
+	errStore := errors.New("store unavailable")
+
+	t.Run("submit", func(t *testing.T) {
+		store := &recordingJobStore{MemoryJobStore: NewMemoryJobStore(0), fail: func(*Job) error { return errStore }}
+		m := newTestJobManager(t, &mockProvider{}, withJobStore(store))
+		if _, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?")); !errors.Is(err, errStore) {
+			t.Errorf("Submit returned %v, want the store error", err)
+		}
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		// Failing progress saves are logged and don't keep the outcome from being saved
+		store := &recordingJobStore{MemoryJobStore: NewMemoryJobStore(0), fail: func(job *Job) error {
+			if job.Status == JobStatusRunning {
+				return errStore
+			}
+			return nil
+		}}
+		m := newTestJobManager(t, &mockProvider{}, withJobStore(store))
+		id, err := m.Submit(context.Background(), testRequest("Where is the Eiffel Tower?"))
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+		if job := waitForJob(t, m, id); job.Status != JobStatusDone {
+			t.Errorf("job = %+v, want done", job)
+		}
+	})
+}
+
+func TestJobJSONOmitsUnsetTimes(t *testing.T) {
+	encoded, err := json.Marshal(Job{ID: "job", Status: JobStatusQueued, SubmittedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	for _, key := range []string{"started_at", "finished_at"} {
+		if strings.Contains(string(encoded), key) {
+			t.Errorf("queued job %s has %s", encoded, key)
+		}
+	}
+}
+
+// withJobStore configures the job store
+func withJobStore(store JobStore) func(*AgenticRAGConfig) {
+	return func(config *AgenticRAGConfig) { config.JobStore = store }
+}
+
+// jobStatuses returns the statuses of the jobs
+func jobStatuses(jobs []Job) []JobStatus {
+	statuses := make([]JobStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.Status
+	}
+	return statuses
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// defaultJSONLDNamespace is the IRI prefix of ExportJSONLD's own terms and node IDs
+const defaultJSONLDNamespace = "urn:genkit-agentic-rag:kg:"
+
+// JSONLDExportOptions selects what ExportJSONLD writes
+type JSONLDExportOptions struct {
+	MinConfidence     float64           // Leave out entities and relations below this confidence
+	EntityTypes       []string          // Only export entities of these types or their subtypes, case-insensitively (default: all)
+	TypeParents       map[string]string // Entity type hierarchy for EntityTypes, see KnowledgeGraphConfig.TypeParents
+	Namespace         string            // IRI prefix of node IDs and the confidence and provenance properties (default: "urn:genkit-agentic-rag:kg:")
+	UnmappedNamespace string            // IRI prefix of types and predicates missing from the context map (default: Namespace)
+	SkipUnmapped      bool              // Leave out entities and relations whose type or predicate is missing from the context map
+}
+
+// ExportJSONLD writes the graph as a JSON-LD document. contextMap maps entity types
+// and relation predicates, case-insensitively, onto IRIs such as
+// "https://schema.org/Person" and "https://schema.org/worksFor". Entities become
+// nodes identified by their stable IDs under the namespace, named with schema.org
+// name, and relations become properties of their subject. Confidence and the
+// documents each was found in are kept as custom properties under the namespace,
+// for relations on an rdf:Statement describing them.
+func (kg *KnowledgeGraph) ExportJSONLD(w io.Writer, contextMap map[string]string, opts JSONLDExportOptions) error {
+	nodes, edges := kg.exportGraph(GraphExportOptions{MinConfidence: opts.MinConfidence, EntityTypes: opts.EntityTypes, TypeParents: opts.TypeParents})
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = defaultJSONLDNamespace
+	}
+	unmapped := opts.UnmappedNamespace
+	if unmapped == "" {
+		unmapped = namespace
+	}
+	iris := make(map[string]string, len(contextMap))
+	for term, iri := range contextMap {
+		iris[strings.ToUpper(strings.TrimSpace(term))] = iri
+	}
+	// iri returns the mapped IRI of a type or predicate, or one under the unmapped
+	// namespace, false when unmapped terms are skipped
+	iri := func(term, local string) (string, bool) {
+		if mapped, ok := iris[strings.ToUpper(strings.TrimSpace(term))]; ok {
+			return mapped, true
+		}
+		if opts.SkipUnmapped || local == "" {
+			return "", false
+		}
+		return unmapped + local, true
+	}
+
+	graph := make([]map[string]any, 0, len(nodes)+len(edges))
+	byNode := make(map[string]map[string]any, len(nodes))
+	ids := make(map[string]string, len(nodes))
+	documents := make(map[string][]string, len(nodes))
+	for _, node := range nodes {
+		id := stableEntityID(node.entity)
+		object := map[string]any{"@id": "kg:" + id, "name": node.entity.Name}
+		if node.entity.Type != "" {
+			typeIRI, ok := iri(node.entity.Type, cypherLabel(node.entity.Type))
+			if !ok && opts.SkipUnmapped {
+				continue
+			}
+			if ok {
+				object["@type"] = typeIRI
+			}
+		}
+		if !node.bare {
+			object["kg:confidence"] = node.entity.Confidence
+			if len(node.entity.DocumentIDs) > 0 {
+				object["kg:documentIds"] = node.entity.DocumentIDs
+			}
+		}
+		ids[node.id] = id
+		documents[node.id] = node.entity.DocumentIDs
+		byNode[node.id] = object
+		graph = append(graph, object)
+	}
+	for _, edge := range edges {
+		subject, ok := byNode[edge.source]
+		if !ok {
+			continue
+		}
+		if _, ok := byNode[edge.target]; !ok {
+			continue
+		}
+		predicate, ok := iri(edge.relation.Predicate, jsonldProperty(edge.relation.Predicate))
+		if !ok {
+			continue
+		}
+		target := map[string]any{"@id": "kg:" + ids[edge.target]}
+		values, _ := subject[predicate].([]any)
+		subject[predicate] = append(values, target)
+
+		statement := map[string]any{
+			"@id":           "kg:" + edgeID(edge, ids),
+			"@type":         "rdf:Statement",
+			"rdf:subject":   map[string]any{"@id": subject["@id"]},
+			"rdf:predicate": map[string]any{"@id": predicate},
+			"rdf:object":    target,
+			"kg:confidence": edge.relation.Confidence,
+		}
+		var shared []string
+		for _, id := range documents[edge.source] {
+			if slices.Contains(documents[edge.target], id) {
+				shared = append(shared, id)
+			}
+		}
+		if len(shared) > 0 {
+			statement["kg:documentIds"] = shared
+		}
+		for _, property := range temporalProperties(edge.relation.Temporal) {
+			statement["kg:"+jsonldProperty(property[0])] = property[1]
+		}
+		graph = append(graph, statement)
+	}
+
+	document := map[string]any{
+		"@context": map[string]any{
+			"kg":   namespace,
+			"rdf":  "http://www.w3.org/1999/02/22-rdf-syntax-ns#",
+			"name": "https://schema.org/name",
+		},
+		"@graph": graph,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("failed to write JSON-LD: %w", err)
+	}
+	return nil
+}
+
+// jsonldProperty turns a predicate into a lowerCamelCase property name, as schema.org
+// names its properties
+func jsonldProperty(predicate string) string {
+	var property strings.Builder
+	for i, word := range cypherWords(predicate) {
+		runes := []rune(strings.ToLower(word))
+		if i > 0 {
+			runes[0] = []rune(strings.ToUpper(string(runes[0])))[0]
+		}
+		property.WriteString(string(runes))
+	}
+	return property.String()
+}
@@ -0,0 +1,169 @@
+package plugin
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageNames maps the languages detectLanguage can report to their English names,
+// which prompts use in their instructions
+var languageNames = map[string]string{
+	"ar": "Arabic", "de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"fr": "French", "he": "Hebrew", "hi": "Hindi", "it": "Italian", "ja": "Japanese",
+	"ko": "Korean", "nl": "Dutch", "pt": "Portuguese", "ru": "Russian", "th": "Thai",
+	"zh": "Chinese",
+}
+
+// languageStopwords are frequent function words of Latin-script languages, used to
+// tell them apart
+var languageStopwords = map[string][]string{
+	"en": {"the", "is", "are", "what", "how", "why", "does", "do", "of", "and", "to", "in", "a", "an", "which", "can", "it", "for", "with"},
+	"es": {"el", "la", "los", "las", "es", "son", "qué", "que", "cómo", "como", "por", "para", "de", "del", "y", "en", "un", "una", "cuál", "cuáles", "se", "con"},
+	"fr": {"le", "la", "les", "est", "sont", "quel", "quelle", "quels", "comment", "pourquoi", "de", "des", "du", "et", "en", "un", "une", "qu'est-ce", "que", "avec", "pour"},
+	"de": {"der", "die", "das", "ist", "sind", "was", "wie", "warum", "und", "zu", "in", "ein", "eine", "mit", "für", "von", "den", "welche", "nicht"},
+	"pt": {"o", "a", "os", "as", "é", "são", "que", "como", "por", "para", "de", "do", "da", "e", "em", "um", "uma", "qual", "quais", "não", "com"},
+	"it": {"il", "lo", "la", "gli", "le", "è", "sono", "che", "come", "perché", "di", "del", "della", "e", "in", "un", "una", "quale", "quali", "con", "per"},
+	"nl": {"de", "het", "een", "is", "zijn", "wat", "hoe", "waarom", "en", "van", "in", "met", "voor", "welke", "niet"},
+}
+
+// detectLanguage guesses the language of a short text such as a query, returning a
+// BCP 47 primary language subtag or "" when it can't tell. Non-Latin scripts decide
+// the language directly; Latin-script text is scored by its function words and
+// language-specific letters.
+func detectLanguage(text string) string {
+	counts := make(map[string]int)
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			counts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts["he"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["el"]++
+		case unicode.Is(unicode.Thai, r):
+			counts["th"]++
+		case unicode.Is(unicode.Devanagari, r):
+			counts["hi"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Kana marks Japanese even when most characters are kanji
+	if counts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for _, lang := range sortedKeys(counts) {
+		if counts[lang] > bestCount {
+			best, bestCount = lang, counts[lang]
+		}
+	}
+	if bestCount*2 >= letters {
+		return best
+	}
+	return detectLatinLanguage(text)
+}
+
+// detectLatinLanguage scores Latin-script text against each language's function words
+// and distinctive letters
+func detectLatinLanguage(text string) string {
+	lower := strings.ToLower(text)
+	words := strings.FieldsFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	scores := make(map[string]float64)
+	for lang, stopwords := range languageStopwords {
+		for _, word := range words {
+			for _, stopword := range stopwords {
+				if word == stopword {
+					scores[lang]++
+					break
+				}
+			}
+		}
+	}
+	for _, marker := range []struct {
+		lang    string
+		letters string
+	}{
+		{"es", "ñ¿¡"},
+		{"de", "ßäöü"},
+		{"fr", "çœèêëàâîïû"},
+		{"pt", "ãõç"},
+	} {
+		if strings.ContainsAny(lower, marker.letters) {
+			scores[marker.lang] += 2
+		}
+	}
+	// A tie leaves the language undecided
+	best, bestScore, tied := "", 0.0, false
+	for _, lang := range sortedKeys(scores) {
+		switch score := scores[lang]; {
+		case score > bestScore:
+			best, bestScore, tied = lang, score, false
+		case score == bestScore && score > 0:
+			tied = true
+		}
+	}
+	if bestScore == 0 || tied {
+		return ""
+	}
+	return best
+}
+
+// promptLanguages returns the suffixes of the prompts localized to the language, most
+// specific first: the lowercased tag, then its primary subtag, e.g. "pt-br" and "pt".
+// Languages given by their English name map to their code.
+func promptLanguages(lang string) []string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return nil
+	}
+	for code, name := range languageNames {
+		if strings.EqualFold(name, lang) {
+			return []string{code}
+		}
+	}
+	if strings.ContainsAny(lang, " .") {
+		return nil
+	}
+	tag := strings.ReplaceAll(lang, "_", "-")
+	if primary := primaryLanguage(tag); primary != tag {
+		return []string{tag, primary}
+	}
+	return []string{tag}
+}
+
+// languageInstruction returns the prompt instruction to answer in the language, or ""
+// when no language is set
+func languageInstruction(lang string) string {
+	if lang == "" {
+		return ""
+	}
+	return "Write the answer in " + languageName(lang) + ", even where the context is in another language"
+}
+
+// languageName returns the English name of a language code for prompt instructions.
+// Unknown codes and names given in full are returned as is.
+func languageName(lang string) string {
+	if name, ok := languageNames[primaryLanguage(lang)]; ok {
+		return name
+	}
+	return lang
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Where is the Eiffel Tower?", "en"},
+		{"¿Dónde está la Torre Eiffel?", "es"},
+		{"¿Cuál es el océano más grande de la Tierra?", "es"},
+		{"エッフェル塔はどこにありますか？", "ja"},
+		{"光合成とは何ですか", "ja"},
+		{"Wie funktioniert die Photosynthese?", "de"},
+		{"Где находится Эйфелева башня?", "ru"},
+		{"Eiffel", ""},
+		{"123?", ""},
+	}
+	for _, tt := range tests {
+		if got := detectLanguage(tt.text); got != tt.want {
+			t.Errorf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// localizedAnswers are the mock's answers for the language a prompt asks for
+var localizedAnswers = map[string]string{
+	"Spanish":  "La Torre Eiffel está en París.",
+	"Japanese": "エッフェル塔はパリにあります。",
+	"French":   "La tour Eiffel se trouve à Paris.",
+}
+
+// answerInRequestedLanguage answers in the language the prompt asks for, in English
+// when it asks for none
+func answerInRequestedLanguage(prompt string) string {
+	for language, answer := range localizedAnswers {
+		if strings.Contains(prompt, "Write the answer in "+language) {
+			return answer
+		}
+	}
+	return "The Eiffel Tower is in Paris."
+}
+
+func TestResponseLanguageWithMockProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		override string
+		wantLang string
+		answer   string
+	}{
+		{"spanish query", "¿Dónde está la Torre Eiffel?", "", "es", localizedAnswers["Spanish"]},
+		{"japanese query", "エッフェル塔はどこにありますか？", "", "ja", localizedAnswers["Japanese"]},
+		{"english query", "Where is the Eiffel Tower?", "", "en", "The Eiffel Tower is in Paris."},
+		{"override", "¿Dónde está la Torre Eiffel?", "fr", "fr", localizedAnswers["French"]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockProvider{answer: answerInRequestedLanguage}
+			p := newTestProcessor(t, mock, nil)
+			request := testRequest(tt.query)
+			request.Options.ResponseLanguage = tt.override
+			request.Options.EnableKnowledgeGraph = true
+
+			response := mustProcess(t, p, request)
+			if response.Answer != tt.answer {
+				t.Errorf("answer = %q, want %q", response.Answer, tt.answer)
+			}
+			if got := response.ProcessingMetadata.ResponseLanguage; got != tt.wantLang {
+				t.Errorf("response language = %q, want %q", got, tt.wantLang)
+			}
+
+			// Entity names keep the language of the documents
+			if response.KnowledgeGraph == nil || len(response.KnowledgeGraph.Entities) == 0 {
+				t.Fatalf("no knowledge graph entities extracted")
+			}
+			for _, entity := range response.KnowledgeGraph.Entities {
+				if !strings.Contains(strings.Join(testDocuments, " "), entity.Name) {
+					t.Errorf("entity %q isn't named as in the documents", entity.Name)
+				}
+			}
+			extractions := 0
+			for _, prompt := range mock.servedPrompts() {
+				if !strings.Contains(prompt, "Extract entities and relationships") {
+					continue
+				}
+				extractions++
+				if strings.Contains(prompt, "Write the answer in") {
+					t.Errorf("knowledge extraction was asked for the response language")
+				}
+			}
+			if extractions == 0 {
+				t.Errorf("no knowledge extraction prompt was served")
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// isHTTPURL reports whether s is an absolute http(s) URL
+func isHTTPURL(s string) bool {
+	if strings.ContainsAny(s, " \t\r\n") {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// fetchedPage is the cleaned content of a fetched URL
+type fetchedPage struct {
+	title       string
+	content     string
+	data        []byte // Raw body of binary documents such as PDFs
+	contentType string
+	finalURL    string
+	fetchedAt   time.Time
+}
+
+// urlLoader fetches http(s) documents, extracts readable text and caches the result
+type urlLoader struct {
+	config URLLoaderConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]fetchedPage
+}
+
+// newURLLoader creates a loader for the configuration
+func newURLLoader(config URLLoaderConfig) *urlLoader {
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+	return &urlLoader{
+		config: config,
+		client: client,
+		cache:  make(map[string]fetchedPage),
+	}
+}
+
+// load returns the page at rawURL, from the cache when it's fresh enough. The second
+// return value reports whether the cache was used.
+func (l *urlLoader) load(ctx context.Context, rawURL string) (fetchedPage, bool, error) {
+	if page, ok := l.cached(rawURL); ok {
+		return page, true, nil
+	}
+
+	page, err := l.fetch(ctx, rawURL)
+	if err != nil {
+		return fetchedPage{}, false, err
+	}
+
+	if l.config.CacheTTL > 0 {
+		l.mu.Lock()
+		l.cache[rawURL] = page
+		l.mu.Unlock()
+	}
+	return page, false, nil
+}
+
+// cached returns the cached page for rawURL if it hasn't expired
+func (l *urlLoader) cached(rawURL string) (fetchedPage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	page, ok := l.cache[rawURL]
+	if !ok {
+		return fetchedPage{}, false
+	}
+	if time.Since(page.fetchedAt) > l.config.CacheTTL {
+		delete(l.cache, rawURL)
+		return fetchedPage{}, false
+	}
+	return page, true
+}
+
+// fetch downloads rawURL and extracts its readable text
+func (l *urlLoader) fetch(ctx context.Context, rawURL string) (fetchedPage, error) {
+	if l.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, l.config.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	if l.config.UserAgent != "" {
+		req.Header.Set("User-Agent", l.config.UserAgent)
+	}
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9, */*;q=0.5")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fetchedPage{}, fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+	}
+
+	// Read one byte past the cap to detect oversized bodies
+	body := io.Reader(resp.Body)
+	if l.config.MaxBytes > 0 {
+		body = io.LimitReader(resp.Body, l.config.MaxBytes+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fetchedPage{}, fmt.Errorf("failed to read %s: %w", rawURL, err)
+	}
+	if l.config.MaxBytes > 0 && int64(len(data)) > l.config.MaxBytes {
+		return fetchedPage{}, fmt.Errorf("document %s exceeds the %d byte limit", rawURL, l.config.MaxBytes)
+	}
+
+	page := fetchedPage{
+		contentType: resp.Header.Get("Content-Type"),
+		finalURL:    resp.Request.URL.String(),
+		fetchedAt:   time.Now(),
+	}
+	mediaType, _, _ := mime.ParseMediaType(page.contentType)
+	switch {
+	case mediaType == PDFMIMEType:
+		page.data = data
+		return page, nil
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		page.title, page.content = extractReadableText(string(data))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "":
+		page.content = string(data)
+	default:
+		return fetchedPage{}, fmt.Errorf("unsupported content type %q for %s", page.contentType, rawURL)
+	}
+	if strings.TrimSpace(page.content) == "" {
+		return fetchedPage{}, fmt.Errorf("no readable text found at %s", rawURL)
+	}
+
+	return page, nil
+}
+
+// HTML patterns used by extractReadableText
+var (
+	htmlTitlePattern       = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	htmlBoilerplatePattern = regexp.MustCompile(`(?is)<(script|style|noscript|template|svg|head|nav|header|footer|aside|form|iframe)\b[^>]*>.*?</(script|style|noscript|template|svg|head|nav|header|footer|aside|form|iframe)>`)
+	htmlMainPattern        = regexp.MustCompile(`(?is)<(article|main)\b[^>]*>(.*)</(article|main)>`)
+	htmlCommentPattern     = regexp.MustCompile(`(?s)<!--.*?-->`)
+	htmlBlockPattern       = regexp.MustCompile(`(?i)</?(p|div|section|article|main|h[1-6]|li|ul|ol|tr|table|blockquote|pre|br|hr)\b[^>]*>`)
+	htmlTagPattern         = regexp.MustCompile(`(?s)<[^>]+>`)
+	blankLinesPattern      = regexp.MustCompile(`\n{3,}`)
+)
+
+// extractReadableText strips boilerplate from an HTML page and returns its title and
+// main text, preferring the <article> or <main> element when present
+func extractReadableText(page string) (string, string) {
+	var title string
+	if match := htmlTitlePattern.FindStringSubmatch(page); match != nil {
+		title = strings.TrimSpace(html.UnescapeString(htmlTagPattern.ReplaceAllString(match[1], "")))
+	}
+
+	text := htmlCommentPattern.ReplaceAllString(page, "")
+	text = htmlBoilerplatePattern.ReplaceAllString(text, "")
+	if match := htmlMainPattern.FindStringSubmatch(text); match != nil {
+		text = match[2]
+	}
+	text = htmlBlockPattern.ReplaceAllString(text, "\n")
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	text = blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return title, strings.TrimSpace(text)
+}
+
+// fetchDocuments fills in the content of documents whose Source is an http(s) URL and
+// whose Content is empty, or their data for PDFs. Documents that fail to load are removed and reported as
+// document errors rather than failing the request.
+func (p *AgenticRAGProcessor) fetchDocuments(ctx context.Context, documents []Document) ([]Document, error) {
+	if !p.config.URLLoader.Enabled {
+		return documents, nil
+	}
+
+	_, errs, err := runParallel(ctx, len(documents), p.config.Processing.Concurrency, false,
+		func(ctx context.Context, i int) (struct{}, error) {
+			doc := &documents[i]
+			if doc.Content != "" || len(doc.Data) > 0 || !isHTTPURL(doc.Source) {
+				return struct{}{}, nil
+			}
+
+			page, fromCache, err := p.urlLoader.load(ctx, doc.Source)
+			if err != nil {
+				return struct{}{}, err
+			}
+			doc.Content = page.content
+			doc.Data = page.data
+			if doc.Title == "" {
+				doc.Title = page.title
+			}
+			if doc.MIMEType == "" {
+				doc.MIMEType = page.contentType
+			}
+			doc.Metadata["fetched_at"] = page.fetchedAt
+			doc.Metadata["final_url"] = page.finalURL
+			doc.Metadata["from_cache"] = fromCache
+			return struct{}{}, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	state := requestStateFrom(ctx)
+	loaded := documents[:0]
+	for i, doc := range documents {
+		if errs[i] != nil {
+			state.recordDocumentError(DocumentError{DocumentID: doc.ID, Source: doc.Source, Error: errs[i].Error()})
+			continue
+		}
+		loaded = append(loaded, doc)
+	}
+	return loaded, nil
+}
@@ -0,0 +1,260 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Block types recorded in DocumentChunk.BlockType for blocks that are never split
+const (
+	BlockTypeCode  = "code"
+	BlockTypeTable = "table"
+)
+
+// Markdown syntax patterns
+var (
+	markdownHeadingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownFencePattern   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})")
+)
+
+// markdownBlock is a heading, fenced code block, table or prose paragraph with its
+// byte range in the document
+type markdownBlock struct {
+	kind  string // BlockTypeCode, BlockTypeTable, "heading" or "" for prose
+	level int    // Heading level
+	text  string
+	start int
+	end   int
+}
+
+// parseMarkdownBlocks splits markdown into blocks. Unterminated code fences run to
+// the end of the document, as in CommonMark.
+func parseMarkdownBlocks(content string) []markdownBlock {
+	var blocks []markdownBlock
+	lines := strings.SplitAfter(content, "\n")
+
+	offset := 0
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		start := offset
+
+		switch {
+		case trimmed == "":
+			offset += len(line)
+			i++
+
+		case markdownFencePattern.MatchString(line):
+			fence := markdownFencePattern.FindStringSubmatch(line)[1]
+			var text strings.Builder
+			text.WriteString(line)
+			offset += len(line)
+			for i++; i < len(lines); i++ {
+				text.WriteString(lines[i])
+				offset += len(lines[i])
+				if strings.HasPrefix(strings.TrimSpace(lines[i]), fence[:3]) && strings.Trim(strings.TrimSpace(lines[i]), fence[:1]) == "" {
+					i++
+					break
+				}
+			}
+			blocks = append(blocks, markdownBlock{kind: BlockTypeCode, text: text.String(), start: start, end: offset})
+
+		case markdownHeadingPattern.MatchString(line):
+			match := markdownHeadingPattern.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+			offset += len(line)
+			i++
+			blocks = append(blocks, markdownBlock{kind: "heading", level: len(match[1]), text: match[2], start: start, end: offset})
+
+		case strings.HasPrefix(trimmed, "|"):
+			var text strings.Builder
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				text.WriteString(lines[i])
+				offset += len(lines[i])
+			}
+			blocks = append(blocks, markdownBlock{kind: BlockTypeTable, text: text.String(), start: start, end: offset})
+
+		default:
+			// Prose runs until a blank line or the start of another block
+			var text strings.Builder
+			for ; i < len(lines); i++ {
+				next := lines[i]
+				if strings.TrimSpace(next) == "" || (text.Len() > 0 && (markdownFencePattern.MatchString(next) ||
+					markdownHeadingPattern.MatchString(next) || strings.HasPrefix(strings.TrimSpace(next), "|"))) {
+					break
+				}
+				text.WriteString(next)
+				offset += len(next)
+			}
+			blocks = append(blocks, markdownBlock{text: text.String(), start: start, end: offset})
+		}
+	}
+	return blocks
+}
+
+// chunkMarkdown chunks markdown on heading boundaries, keeping fenced code blocks and
+// tables whole and recording each chunk's heading path. Prose is split on sentence
+// boundaries when RespectSentences is set, using the optional language hint.
+func (p *AgenticRAGProcessor) chunkMarkdown(doc Document, maxChunks int, lang string) []DocumentChunk {
+	chunkSize := p.config.Processing.DefaultChunkSize
+	size := p.chunkMeasure()
+	chunks := make([]DocumentChunk, 0)
+
+	var headings []string
+	var current strings.Builder
+	currentStart, currentEnd := 0, 0
+	currentKind := ""
+
+	emit := func(text, kind string, start, end int) {
+		if len(chunks) >= maxChunks || strings.TrimSpace(text) == "" {
+			return
+		}
+		chunks = append(chunks, DocumentChunk{
+			ID:          fmt.Sprintf("%s_chunk_%d", doc.ID, len(chunks)),
+			Content:     strings.TrimSpace(text),
+			DocumentID:  doc.ID,
+			ChunkIndex:  len(chunks),
+			StartIndex:  start,
+			EndIndex:    end,
+			HeadingPath: append([]string(nil), headings...),
+			BlockType:   kind,
+		})
+	}
+	flush := func() {
+		emit(current.String(), currentKind, currentStart, currentEnd)
+		current.Reset()
+		currentKind = ""
+	}
+	add := func(text, kind string, start, end int) {
+		if current.Len() > 0 && size(current.String())+size(text) > chunkSize {
+			flush()
+		}
+		if current.Len() == 0 {
+			currentStart = start
+			currentKind = kind
+		} else if currentKind != kind {
+			currentKind = "" // Mixed chunks aren't atomic
+		}
+		// Blocks are separated by newlines, sentences of a split block already end in whitespace
+		current.WriteString(text)
+		if strings.TrimRight(text, " \t\n") == text {
+			current.WriteString("\n")
+		}
+		currentEnd = end
+	}
+
+	for _, block := range parseMarkdownBlocks(doc.Content) {
+		if len(chunks) >= maxChunks {
+			break
+		}
+		switch {
+		case block.kind == "heading":
+			flush()
+			for len(headings) >= block.level {
+				headings = headings[:len(headings)-1]
+			}
+			for len(headings) < block.level-1 {
+				headings = append(headings, "") // Skipped heading levels
+			}
+			headings = append(headings, block.text)
+			add(doc.Content[block.start:block.end], "", block.start, block.end)
+
+		case size(block.text) > chunkSize && block.kind != "":
+			// Oversized code blocks and tables become their own chunk
+			flush()
+			emit(block.text, block.kind, block.start, block.end)
+
+		case size(block.text) > chunkSize:
+			for _, part := range splitProse(block.text, chunkSize, size, p.config.Processing.RespectSentences, lang) {
+				add(part.text, "", block.start+part.start, block.start+part.end)
+			}
+
+		default:
+			add(block.text, block.kind, block.start, block.end)
+		}
+	}
+	flush()
+
+	// Drop the placeholders for skipped heading levels
+	for i := range chunks {
+		path := chunks[i].HeadingPath[:0]
+		for _, heading := range chunks[i].HeadingPath {
+			if heading != "" {
+				path = append(path, heading)
+			}
+		}
+		chunks[i].HeadingPath = path
+		if len(path) == 0 {
+			chunks[i].HeadingPath = nil
+		}
+	}
+	return chunks
+}
+
+// prosePart is a piece of a prose block with its byte range in the block
+type prosePart struct {
+	text  string
+	start int
+	end   int
+}
+
+// splitProse splits prose into pieces no larger than limit as measured by size, on
+// sentence boundaries when respectSentences is set and on word boundaries otherwise.
+// A single sentence over the limit is split on word boundaries.
+func splitProse(text string, limit int, size func(string) int, respectSentences bool, lang string) []prosePart {
+	var units []prosePart
+	if respectSentences {
+		units = segmentSentences(text, lang)
+	} else {
+		units = []prosePart{{text: text, start: 0, end: len(text)}}
+	}
+
+	var parts []prosePart
+	for _, unit := range units {
+		if size(unit.text) <= limit {
+			parts = append(parts, unit)
+			continue
+		}
+		// Cut oversized units at the last space before the limit
+		for rest, offset := unit.text, unit.start; rest != ""; {
+			cut := len(rest)
+			if size(rest) > limit {
+				fit := prefixWithin(rest, limit, size)
+				cut = strings.LastIndexAny(rest[:fit], " \t\n")
+				if cut <= 0 {
+					cut = fit
+				}
+			}
+			parts = append(parts, prosePart{text: rest[:cut], start: offset, end: offset + cut})
+			rest, offset = rest[cut:], offset+cut
+		}
+	}
+	return parts
+}
+
+// prefixWithin returns the byte length of the longest prefix of text, ending on a
+// rune boundary, whose size is within limit. At least one rune is always included.
+func prefixWithin(text string, limit int, size func(string) int) int {
+	boundaries := make([]int, 0, len(text))
+	for i := range text {
+		if i > 0 {
+			boundaries = append(boundaries, i)
+		}
+	}
+	boundaries = append(boundaries, len(text))
+
+	// Size grows with the prefix, so binary search the boundaries
+	n := sort.Search(len(boundaries), func(i int) bool {
+		return size(text[:boundaries[i]]) > limit
+	})
+	if n == 0 {
+		return boundaries[0]
+	}
+	return boundaries[n-1]
+}
+
+// chunkLabel returns the chunk's heading path for display, e.g. "Setup > Install"
+func chunkLabel(chunk DocumentChunk) string {
+	return strings.Join(chunk.HeadingPath, " > ")
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxMentions bounds the mentions recorded per entity or relation
+const maxMentions = 20
+
+// Mention is a place in a document where an entity is named or a relation expressed
+type Mention struct {
+	DocumentID string `json:"document_id"`
+	ChunkID    string `json:"chunk_id"`
+	CharStart  int    `json:"char_start"` // Start offset in the document text
+	CharEnd    int    `json:"char_end"`   // End offset in the document text
+	Sentence   string `json:"sentence"`   // Sentence containing the mention
+}
+
+// documentText returns the text chunk offsets refer to: the content, or the pages
+// separated by form feeds
+func documentText(doc Document) string {
+	if doc.Content != "" || len(doc.Pages) == 0 {
+		return doc.Content
+	}
+	pages := make([]string, len(doc.Pages))
+	for i, page := range doc.Pages {
+		pages[i] = page.Content
+	}
+	return strings.Join(pages, "\f")
+}
+
+// chunkOffset returns the offset of the chunk's content in its document text. Markdown
+// chunks join their blocks with single newlines, so the content is searched for from
+// the chunk's start index; when it isn't found the start index is taken as is.
+func chunkOffset(chunk DocumentChunk, text string) int {
+	from := max(0, min(chunk.StartIndex, len(text)))
+	if i := strings.Index(text[from:], chunk.Content); i >= 0 {
+		return from + i
+	}
+	if i := strings.Index(text, chunk.Content); i >= 0 {
+		return i
+	}
+	return chunk.StartIndex
+}
+
+// indexWord returns the byte offset of the first case-insensitive occurrence of word
+// in s at or after from that isn't part of a longer word, or -1
+func indexWord(s, word string, from int) int {
+	if word == "" {
+		return -1
+	}
+	for i := from; i+len(word) <= len(s); i++ {
+		if !utf8.RuneStart(s[i]) || !strings.EqualFold(s[i:i+len(word)], word) {
+			continue
+		}
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[i+len(word):])
+		if !isWordRune(before) && !isWordRune(after) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isWordRune reports whether r continues a word, false for utf8.RuneError at the ends
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// surfaceForms returns the names an entity may appear under: its name, aliases and
+// the mentions the model reported
+func surfaceForms(entity Entity) []string {
+	forms := []string{entity.Name}
+	for _, key := range []string{"aliases", "mentions"} {
+		for _, form := range stringList(entity.Properties[key]) {
+			if form != "" && !containsString(forms, form) {
+				forms = append(forms, form)
+			}
+		}
+	}
+	return forms
+}
+
+// locateMentions records where in the documents each entity is named and each
+// relation is expressed, by finding the surface forms of entities as whole words in
+// the chunks. A relation is expressed by a sentence naming both its subject and its
+// object. Offsets refer to the document text, and a mention found in two overlapping
+// chunks is recorded once. At most maxMentions mentions are kept per entity or
+// relation.
+func locateMentions(kg *KnowledgeGraph, chunks []DocumentChunk, documents []Document, lang string) {
+	if kg == nil {
+		return
+	}
+	texts := make(map[string]string, len(documents))
+	for _, doc := range documents {
+		texts[doc.ID] = documentText(doc)
+	}
+	forms := make([][]string, len(kg.Entities))
+	byRef := make(map[string]int)
+	for i, entity := range kg.Entities {
+		forms[i] = surfaceForms(entity)
+		if entity.ID != "" {
+			byRef[entity.ID] = i
+		}
+		byRef[strings.ToLower(entity.Name)] = i
+	}
+	formsOf := func(ref string) []string {
+		if i, ok := byRef[ref]; ok {
+			return forms[i]
+		}
+		if i, ok := byRef[strings.ToLower(ref)]; ok {
+			return forms[i]
+		}
+		return []string{ref}
+	}
+
+	type span struct {
+		document   string
+		start, end int
+	}
+	entitySeen := make([]map[span]bool, len(kg.Entities))
+	relationSeen := make([]map[span]bool, len(kg.Relations))
+	record := func(mentions *[]Mention, seen *map[span]bool, mention Mention) {
+		key := span{mention.DocumentID, mention.CharStart, mention.CharEnd}
+		if *seen == nil {
+			*seen = make(map[span]bool)
+			for _, existing := range *mentions {
+				(*seen)[span{existing.DocumentID, existing.CharStart, existing.CharEnd}] = true
+			}
+		}
+		if len(*mentions) < maxMentions && !(*seen)[key] {
+			(*seen)[key] = true
+			*mentions = append(*mentions, mention)
+		}
+	}
+
+	for _, chunk := range chunks {
+		base := chunkOffset(chunk, texts[chunk.DocumentID])
+		sentences := segmentSentences(chunk.Content, lang)
+		sentenceAt := func(offset int) prosePart {
+			for _, sentence := range sentences {
+				if offset >= sentence.start && offset < sentence.end {
+					return sentence
+				}
+			}
+			return prosePart{text: chunk.Content, start: 0, end: len(chunk.Content)}
+		}
+
+		for i := range kg.Entities {
+			entity := &kg.Entities[i]
+			for _, form := range forms[i] {
+				for at := indexWord(chunk.Content, form, 0); at >= 0; at = indexWord(chunk.Content, form, at+len(form)) {
+					record(&entity.Mentions, &entitySeen[i], Mention{
+						DocumentID: chunk.DocumentID,
+						ChunkID:    chunk.ID,
+						CharStart:  base + at,
+						CharEnd:    base + at + len(form),
+						Sentence:   strings.TrimSpace(sentenceAt(at).text),
+					})
+				}
+			}
+		}
+
+		for i := range kg.Relations {
+			relation := &kg.Relations[i]
+			subjects, objects := formsOf(relation.Subject), formsOf(relation.Object)
+			for _, sentence := range sentences {
+				if !namesAny(sentence.text, subjects) || !namesAny(sentence.text, objects) {
+					continue
+				}
+				trimmed := strings.TrimRightFunc(sentence.text, unicode.IsSpace)
+				record(&relation.Mentions, &relationSeen[i], Mention{
+					DocumentID: chunk.DocumentID,
+					ChunkID:    chunk.ID,
+					CharStart:  base + sentence.start,
+					CharEnd:    base + sentence.start + len(trimmed),
+					Sentence:   strings.TrimSpace(sentence.text),
+				})
+			}
+		}
+	}
+}
+
+// namesAny reports whether text contains any of the forms as a whole word
+func namesAny(text string, forms []string) bool {
+	for _, form := range forms {
+		if indexWord(text, form, 0) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMentions appends the mentions of update not in base, up to maxMentions
+func mergeMentions(base, update []Mention) []Mention {
+	for _, mention := range update {
+		if len(base) >= maxMentions {
+			break
+		}
+		duplicate := false
+		for _, existing := range base {
+			if existing.DocumentID == mention.DocumentID && existing.CharStart == mention.CharStart && existing.CharEnd == mention.CharEnd {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			base = append(base, mention)
+		}
+	}
+	return base
+}
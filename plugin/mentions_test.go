@@ -0,0 +1,26 @@
+package plugin
+
+import "testing"
+
+func TestMentionOffsetsAcrossParagraphs(t *testing.T) {
+	// Chunks span paragraphs, so their sentences aren't separated by single spaces
+	doc, chunks := chunkParagraphs(t, 80)
+	kg := &KnowledgeGraph{
+		Entities:  []Entity{{ID: "e1", Name: "Eiffel Tower"}, {ID: "e2", Name: "Kafka"}, {ID: "e3", Name: "Paris"}},
+		Relations: []Relation{{Subject: "e1", Object: "e3"}},
+	}
+	locateMentions(kg, chunks, []Document{doc}, "")
+
+	for _, entity := range kg.Entities {
+		if len(entity.Mentions) != 1 {
+			t.Fatalf("%s has mentions %+v, want one", entity.Name, entity.Mentions)
+		}
+		mention := entity.Mentions[0]
+		if got := doc.Content[mention.CharStart:mention.CharEnd]; got != entity.Name {
+			t.Errorf("%s mention at [%d:%d] is %q", entity.Name, mention.CharStart, mention.CharEnd, got)
+		}
+	}
+	if mentions := kg.Relations[0].Mentions; len(mentions) != 1 || doc.Content[mentions[0].CharStart:mentions[0].CharEnd] != "The Eiffel Tower is in Paris." {
+		t.Errorf("relation mentions = %+v, want the sentence naming both", mentions)
+	}
+}
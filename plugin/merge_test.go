@@ -0,0 +1,159 @@
+package plugin
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// eiffelGraphs returns three extractions naming the Eiffel Tower with a different
+// type each. Entity IDs are reused across them for different entities, as separate
+// extractions do.
+func eiffelGraphs() []*KnowledgeGraph {
+	mention := func(doc string, start int) Mention {
+		return Mention{DocumentID: doc, CharStart: start, CharEnd: start + 12}
+	}
+	return []*KnowledgeGraph{
+		{
+			Entities: []Entity{
+				{ID: "entity_1", Name: "Eiffel Tower", Type: "LANDMARK", Confidence: 0.9, DocumentIDs: []string{"doc_1"}, Mentions: []Mention{mention("doc_1", 0)}},
+				{ID: "entity_2", Name: "Paris", Type: "LOCATION", Confidence: 0.9, DocumentIDs: []string{"doc_1"}},
+			},
+			Relations: []Relation{{Subject: "entity_1", Predicate: "located_in", Object: "entity_2", Confidence: 0.8}},
+		},
+		{
+			Entities: []Entity{
+				{ID: "entity_1", Name: "Paris", Type: "CITY", Confidence: 0.8, DocumentIDs: []string{"doc_2"}},
+				{ID: "entity_2", Name: "eiffel tower", Type: "STRUCTURE", Confidence: 0.5, DocumentIDs: []string{"doc_2"},
+					Mentions: []Mention{mention("doc_2", 0), mention("doc_2", 40), mention("doc_2", 80)}},
+			},
+			Relations: []Relation{{Subject: "entity_2", Predicate: "located_in", Object: "entity_1", Confidence: 0.95}},
+		},
+		{
+			Entities: []Entity{
+				{ID: "entity_1", Name: "Eiffel-Tower", Type: "LOCATION", Confidence: 0.7, DocumentIDs: []string{"doc_3"}, Mentions: []Mention{mention("doc_3", 10)}},
+				{ID: "entity_2", Name: "Gustave Eiffel", Type: "PERSON", Confidence: 0.9, DocumentIDs: []string{"doc_3"}},
+			},
+			Relations: []Relation{{Subject: "entity_2", Predicate: "designed", Object: "entity_1", Confidence: 0.9}},
+		},
+	}
+}
+
+// mergeAll folds the graphs into an empty graph in the given order
+func mergeAll(graphs []*KnowledgeGraph, order []int, policy MergePolicy) *KnowledgeGraph {
+	merged := &KnowledgeGraph{}
+	for _, i := range order {
+		merged.Merge(graphs[i], policy)
+	}
+	return merged
+}
+
+// findEntity returns the entity of the graph with the given name, after normalization
+func findEntity(t *testing.T, kg *KnowledgeGraph, name string) Entity {
+	t.Helper()
+	for _, entity := range kg.Entities {
+		if normalizeEntityName(entity.Name) == normalizeEntityName(name) {
+			return entity
+		}
+	}
+	t.Fatalf("no entity %q in %+v", name, kg.Entities)
+	return Entity{}
+}
+
+func TestMergeEntityWithDifferentTypes(t *testing.T) {
+	tests := []struct {
+		policy         MergePolicy
+		wantType       string
+		wantConfidence float64
+	}{
+		{MergePolicyMax, "LANDMARK", 0.9},
+		// (0.9*1 + 0.5*3 + 0.7*1) / 5 mentions; the three STRUCTURE mentions outweigh the others
+		{MergePolicyWeighted, "STRUCTURE", 0.62},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			merged := mergeAll(eiffelGraphs(), []int{0, 1, 2}, tt.policy)
+
+			if len(merged.Entities) != 3 {
+				t.Fatalf("got %d entities, want Eiffel Tower, Paris and Gustave Eiffel: %+v", len(merged.Entities), merged.Entities)
+			}
+			tower := findEntity(t, merged, "Eiffel Tower")
+			if tower.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", tower.Type, tt.wantType)
+			}
+			if math.Abs(tower.Confidence-tt.wantConfidence) > 1e-9 {
+				t.Errorf("confidence = %v, want %v", tower.Confidence, tt.wantConfidence)
+			}
+			if want := []string{"doc_1", "doc_2", "doc_3"}; !slices.Equal(tower.DocumentIDs, want) {
+				t.Errorf("documents = %v, want %v", tower.DocumentIDs, want)
+			}
+			if len(tower.Mentions) != 5 {
+				t.Errorf("got %d mentions, want 5", len(tower.Mentions))
+			}
+			if aliases := stringList(tower.Properties["aliases"]); !slices.Contains(aliases, "Eiffel-Tower") {
+				t.Errorf("aliases = %v, want Eiffel-Tower among them", aliases)
+			}
+			if paris := findEntity(t, merged, "Paris"); paris.Type != "LOCATION" {
+				t.Errorf("Paris has type %q, want the more confident LOCATION", paris.Type)
+			}
+			for _, entity := range merged.Entities {
+				if entity.ID != stableEntityID(entity) {
+					t.Errorf("entity %q has ID %q, want its stable ID", entity.Name, entity.ID)
+				}
+			}
+
+			// Both extractions of located_in collapse into one, though they referenced
+			// the entities by different IDs
+			want := map[string]Relation{
+				"located_in": {Subject: "Eiffel Tower", Object: "Paris", Confidence: 0.95},
+				"designed":   {Subject: "Gustave Eiffel", Object: "Eiffel Tower", Confidence: 0.9},
+			}
+			if len(merged.Relations) != len(want) {
+				t.Fatalf("got relations %+v, want %d", merged.Relations, len(want))
+			}
+			for _, relation := range merged.Relations {
+				w := want[relation.Predicate]
+				if normalizeEntityName(relation.Subject) != normalizeEntityName(w.Subject) ||
+					normalizeEntityName(relation.Object) != normalizeEntityName(w.Object) || relation.Confidence != w.Confidence {
+					t.Errorf("relation %+v, want %+v", relation, w)
+				}
+			}
+		})
+	}
+}
+
+// TestMergeOrder checks the result doesn't depend on the order the graphs are merged
+// in, as far as the policy allows: the highest confidence wins in any order, and the
+// weighted confidence is the same average in any order
+func TestMergeOrder(t *testing.T) {
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	for _, order := range orders {
+		maxMerged := mergeAll(eiffelGraphs(), order, MergePolicyMax)
+		if len(maxMerged.Entities) != 3 || len(maxMerged.Relations) != 2 {
+			t.Errorf("order %v: got %d entities and %d relations, want 3 and 2", order, len(maxMerged.Entities), len(maxMerged.Relations))
+			continue
+		}
+		for _, entity := range maxMerged.Entities {
+			if normalizeEntityName(entity.Name) == "eiffeltower" && (entity.Type != "LANDMARK" || entity.Confidence != 0.9) {
+				t.Errorf("order %v: max merge gave %s %v, want LANDMARK 0.9", order, entity.Type, entity.Confidence)
+			}
+		}
+
+		weighted := mergeAll(eiffelGraphs(), order, MergePolicyWeighted)
+		for _, entity := range weighted.Entities {
+			if normalizeEntityName(entity.Name) == "eiffeltower" && math.Abs(entity.Confidence-0.62) > 1e-9 {
+				t.Errorf("order %v: weighted merge gave confidence %v, want 0.62", order, entity.Confidence)
+			}
+		}
+	}
+}
+
+func TestMergeNil(t *testing.T) {
+	graph := eiffelGraphs()[0]
+	graph.Merge(nil, MergePolicyMax)
+	if len(graph.Entities) != 2 || len(graph.Relations) != 1 {
+		t.Errorf("merging nil changed the graph: %+v", graph)
+	}
+	var empty *KnowledgeGraph
+	empty.Merge(graph, MergePolicyMax) // Doesn't panic
+}
@@ -0,0 +1,381 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics receives the processor's measurements. Labels are bounded: models and
+// providers come from the configuration, stages and outcomes are constants, and no
+// query text or request ID ever appears. Implementations must be safe for concurrent
+// use. PrometheusMetrics is the bundled implementation.
+type Metrics interface {
+	// RequestStarted counts a request entering the pipeline
+	RequestStarted()
+	// RequestFinished counts a request leaving the pipeline with its outcome, one of the
+	// Outcome constants
+	RequestFinished(outcome string, duration time.Duration)
+	// StageFinished observes a stage of a request: OutcomeSuccess when it completed,
+	// OutcomeSkipped when the budget skipped it, else its request's failure outcome
+	StageFinished(stage, outcome string, duration time.Duration)
+	// TokensUsed adds the tokens the model calls of a stage consumed
+	TokensUsed(stage string, tokens int)
+	// ModelCalled counts an attempt of a model call, each retry being another, with its
+	// outcome
+	ModelCalled(provider, model, outcome string)
+	// RetryWaited adds the time a model call backed off before retrying, mostly on rate
+	// limits
+	RetryWaited(provider, model string, wait time.Duration)
+	// ResponseCacheLookup counts a lookup of the response cache
+	ResponseCacheLookup(hit bool)
+	// EmbeddingCacheLookups counts the lookups of the embedding cache of a request
+	EmbeddingCacheLookups(hits, misses int)
+}
+
+// Outcomes of requests, stages and model calls
+const (
+	OutcomeSuccess       = "success"
+	OutcomeSkipped       = "skipped"        // Stages only
+	OutcomeCanceled      = "canceled"       // The caller canceled or the deadline passed
+	OutcomeUserError     = "user_error"     // Invalid request or ErrClassUser
+	OutcomeProviderError = "provider_error" // ErrClassProvider
+	OutcomeContentError  = "content_error"  // ErrClassContent
+	OutcomeError         = "error"          // Any other failure
+)
+
+// WithMetrics reports the processor's measurements to metrics, replacing
+// AgenticRAGConfig.Metrics
+func WithMetrics(metrics Metrics) ProcessorOption {
+	return func(p *AgenticRAGProcessor) {
+		if metrics == nil {
+			metrics = nopMetrics{}
+		}
+		p.metrics = metrics
+	}
+}
+
+// nopMetrics discards measurements, the default
+type nopMetrics struct{}
+
+func (nopMetrics) RequestStarted()                             {}
+func (nopMetrics) RequestFinished(string, time.Duration)       {}
+func (nopMetrics) StageFinished(string, string, time.Duration) {}
+func (nopMetrics) TokensUsed(string, int)                      {}
+func (nopMetrics) ModelCalled(string, string, string)          {}
+func (nopMetrics) RetryWaited(string, string, time.Duration)   {}
+func (nopMetrics) ResponseCacheLookup(bool)                    {}
+func (nopMetrics) EmbeddingCacheLookups(int, int)              {}
+
+// metricsOutcome classifies err as one of the Outcome constants
+func metricsOutcome(err error) string {
+	var validation *ValidationError
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return OutcomeCanceled
+	case errors.Is(err, ErrClassUser), errors.As(err, &validation):
+		return OutcomeUserError
+	case errors.Is(err, ErrClassProvider):
+		return OutcomeProviderError
+	case errors.Is(err, ErrClassContent):
+		return OutcomeContentError
+	default:
+		return OutcomeError
+	}
+}
+
+// modelProvider returns the provider of a model name such as googleai/gemini-2.5-flash
+func modelProvider(model string) string {
+	if provider, _, ok := strings.Cut(model, "/"); ok {
+		return provider
+	}
+	return "unknown"
+}
+
+// recordRequestMetrics reports a finished request: its outcome and, unless the
+// response cache served it, its stages, tokens and embedding cache lookups
+func (p *AgenticRAGProcessor) recordRequestMetrics(response *AgenticRAGResponse, err error, duration time.Duration) {
+	outcome := metricsOutcome(err)
+	p.metrics.RequestFinished(outcome, duration)
+
+	var partial *PartialResultError
+	if response == nil && errors.As(err, &partial) {
+		response = partial.Response
+	}
+	if response == nil || response.ProcessingMetadata.CacheHit {
+		return
+	}
+	metadata := response.ProcessingMetadata
+	for _, timing := range metadata.StageTimings {
+		stageOutcome := outcome
+		switch {
+		case slices.Contains(metadata.CompletedStages, timing.Stage):
+			stageOutcome = OutcomeSuccess
+		case slices.Contains(metadata.SkippedStages, timing.Stage):
+			stageOutcome = OutcomeSkipped
+		case stageOutcome == OutcomeSuccess:
+			// Stages of successful requests that record no completion, such as
+			// optional stages that failed softly
+			stageOutcome = OutcomeError
+		}
+		p.metrics.StageFinished(timing.Stage, stageOutcome, timing.Duration)
+		if timing.TokensUsed > 0 {
+			p.metrics.TokensUsed(timing.Stage, timing.TokensUsed)
+		}
+	}
+	if metadata.EmbeddingCacheHits > 0 || metadata.EmbeddingCacheMisses > 0 {
+		p.metrics.EmbeddingCacheLookups(metadata.EmbeddingCacheHits, metadata.EmbeddingCacheMisses)
+	}
+}
+
+// Histogram buckets in seconds. Stages and requests span model calls, so the buckets
+// reach further than Prometheus' defaults.
+var (
+	requestBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+	stageBuckets   = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+)
+
+// PrometheusMetrics collects the processor's measurements and serves them in the
+// Prometheus text exposition format, so it can be mounted wherever promhttp's handler
+// would be:
+//
+//	metrics := plugin.NewPrometheusMetrics()
+//	processor := plugin.NewAgenticRAGProcessor(config, plugin.WithMetrics(metrics))
+//	http.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	mu              sync.Mutex
+	inFlight        int64
+	requests        *promCounter
+	requestDuration *promHistogram
+	stageDuration   *promHistogram
+	tokens          *promCounter
+	modelCalls      *promCounter
+	retryWait       *promCounter
+	responseCache   *promCounter
+	embeddingCache  *promCounter
+}
+
+// NewPrometheusMetrics creates an empty collector
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return &PrometheusMetrics{
+		requests:        newPromCounter("agentic_rag_requests_total", "Requests processed, by outcome.", "outcome"),
+		requestDuration: newPromHistogram("agentic_rag_request_duration_seconds", "Time to process a request, by outcome.", requestBuckets, "outcome"),
+		stageDuration:   newPromHistogram("agentic_rag_stage_duration_seconds", "Time spent in a pipeline stage, by stage and outcome.", stageBuckets, "stage", "outcome"),
+		tokens:          newPromCounter("agentic_rag_tokens_total", "Tokens consumed by model calls, by stage.", "stage"),
+		modelCalls:      newPromCounter("agentic_rag_model_calls_total", "Model call attempts, retries included, by provider, model and outcome.", "provider", "model", "outcome"),
+		retryWait:       newPromCounter("agentic_rag_retry_wait_seconds_total", "Time model calls backed off before retrying, by provider and model.", "provider", "model"),
+		responseCache:   newPromCounter("agentic_rag_response_cache_lookups_total", "Response cache lookups, by outcome (hit or miss).", "outcome"),
+		embeddingCache:  newPromCounter("agentic_rag_embedding_cache_lookups_total", "Embedding cache lookups, by outcome (hit or miss).", "outcome"),
+	}
+}
+
+// RequestStarted implements Metrics
+func (m *PrometheusMetrics) RequestStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight++
+}
+
+// RequestFinished implements Metrics
+func (m *PrometheusMetrics) RequestFinished(outcome string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.requests.add(1, outcome)
+	m.requestDuration.observe(duration.Seconds(), outcome)
+}
+
+// StageFinished implements Metrics
+func (m *PrometheusMetrics) StageFinished(stage, outcome string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stageDuration.observe(duration.Seconds(), stage, outcome)
+}
+
+// TokensUsed implements Metrics
+func (m *PrometheusMetrics) TokensUsed(stage string, tokens int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokens.add(float64(tokens), stage)
+}
+
+// ModelCalled implements Metrics
+func (m *PrometheusMetrics) ModelCalled(provider, model, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.modelCalls.add(1, provider, model, outcome)
+}
+
+// RetryWaited implements Metrics
+func (m *PrometheusMetrics) RetryWaited(provider, model string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.retryWait.add(wait.Seconds(), provider, model)
+}
+
+// ResponseCacheLookup implements Metrics
+func (m *PrometheusMetrics) ResponseCacheLookup(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responseCache.add(1, hitOutcome(hit))
+}
+
+// EmbeddingCacheLookups implements Metrics
+func (m *PrometheusMetrics) EmbeddingCacheLookups(hits, misses int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hits > 0 {
+		m.embeddingCache.add(float64(hits), hitOutcome(true))
+	}
+	if misses > 0 {
+		m.embeddingCache.add(float64(misses), hitOutcome(false))
+	}
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	m.mu.Lock()
+	m.requests.write(&b)
+	b.WriteString("# HELP agentic_rag_requests_in_flight Requests being processed.\n")
+	b.WriteString("# TYPE agentic_rag_requests_in_flight gauge\n")
+	fmt.Fprintf(&b, "agentic_rag_requests_in_flight %d\n", m.inFlight)
+	m.requestDuration.write(&b)
+	m.stageDuration.write(&b)
+	m.tokens.write(&b)
+	m.modelCalls.write(&b)
+	m.retryWait.write(&b)
+	m.responseCache.write(&b)
+	m.embeddingCache.write(&b)
+	m.mu.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scrape
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// hitOutcome returns the outcome label of a cache lookup
+func hitOutcome(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
+
+// promCounter is a counter family, by label values joined with promLabelSeparator
+type promCounter struct {
+	name, help string
+	labels     []string
+	values     map[string]float64
+}
+
+// promLabelSeparator joins label values into series keys; it can't appear in UTF-8
+const promLabelSeparator = "\xff"
+
+func newPromCounter(name, help string, labels ...string) *promCounter {
+	return &promCounter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+func (c *promCounter) add(value float64, labelValues ...string) {
+	c.values[strings.Join(labelValues, promLabelSeparator)] += value
+}
+
+func (c *promCounter) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(b, "%s%s %s\n", c.name, promLabels(c.labels, key, ""), promFloat(c.values[key]))
+	}
+}
+
+// promHistogram is a histogram family with fixed buckets
+type promHistogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	series     map[string]*promSeries
+}
+
+// promSeries holds the non-cumulative bucket counts of a histogram series; the last
+// count is of the observations above every bucket
+type promSeries struct {
+	counts []uint64
+	sum    float64
+}
+
+func newPromHistogram(name, help string, buckets []float64, labels ...string) *promHistogram {
+	return &promHistogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*promSeries)}
+}
+
+func (h *promHistogram) observe(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, promLabelSeparator)
+	series := h.series[key]
+	if series == nil {
+		series = &promSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = series
+	}
+	i, _ := slices.BinarySearch(h.buckets, value)
+	series.counts[i]++
+	series.sum += value
+}
+
+func (h *promHistogram) write(b *strings.Builder) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.series) {
+		series := h.series[key]
+		var cumulative uint64
+		for i, count := range series.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = promFloat(h.buckets[i])
+			}
+			fmt.Fprintf(b, "%s_bucket%s %d\n", h.name, promLabels(h.labels, key, le), cumulative)
+		}
+		fmt.Fprintf(b, "%s_sum%s %s\n", h.name, promLabels(h.labels, key, ""), promFloat(series.sum))
+		fmt.Fprintf(b, "%s_count%s %d\n", h.name, promLabels(h.labels, key, ""), cumulative)
+	}
+}
+
+// promLabels formats the label set of a series key, with an le label for buckets
+func promLabels(names []string, key, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, value := range strings.Split(key, promLabelSeparator) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", names[i], promEscaper.Replace(value))
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "le=\"%s\"", le)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// promEscaper escapes label values for the text exposition format
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promFloat formats a sample value the way Prometheus parses it
+func promFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics scrapes the collector over HTTP, returning each sample by its series,
+// e.g. agentic_rag_requests_total{outcome="success"}
+func scrapeMetrics(t *testing.T, m *PrometheusMetrics) map[string]float64 {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text exposition format", contentType)
+	}
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			t.Fatalf("malformed sample %q", line)
+		}
+		value, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			t.Fatalf("malformed sample %q: %v", line, err)
+		}
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+// sumSeries adds the samples of a metric whose labels contain every given label
+func sumSeries(samples map[string]float64, name string, labels ...string) float64 {
+	var sum float64
+	for series, value := range samples {
+		metric, rest, _ := strings.Cut(series, "{")
+		if metric != name {
+			continue
+		}
+		matches := true
+		for _, label := range labels {
+			matches = matches && strings.Contains(rest, label)
+		}
+		if matches {
+			sum += value
+		}
+	}
+	return sum
+}
+
+func TestPrometheusMetricsAfterMockRun(t *testing.T) {
+	metrics := NewPrometheusMetrics()
+	ctx := context.Background()
+	model := `provider="googleai",model="googleai/gemini-2.5-flash"`
+
+	mock := &mockProvider{}
+	p := newTestProcessor(t, mock, nil, WithMetrics(metrics))
+	request := testRequest("Where is the Eiffel Tower?")
+	request.Options.EnableKnowledgeGraph = true
+	mustProcess(t, p, request)
+	mustProcess(t, p, testRequest("What covers a third of the Earth?"))
+
+	samples := scrapeMetrics(t, metrics)
+	if got := samples[`agentic_rag_requests_total{outcome="success"}`]; got != 2 {
+		t.Errorf("successful requests = %v, want 2", got)
+	}
+	if got, want := samples[`agentic_rag_model_calls_total{`+model+`,outcome="success"}`], float64(mock.callCount()); got != want || got == 0 {
+		t.Errorf("successful model calls = %v, want the %v calls the mock served", got, want)
+	}
+	if got := samples["agentic_rag_requests_in_flight"]; got != 0 {
+		t.Errorf("requests in flight = %v, want 0", got)
+	}
+	if got := samples[`agentic_rag_request_duration_seconds_count{outcome="success"}`]; got != 2 {
+		t.Errorf("request durations observed = %v, want 2", got)
+	}
+	if got := sumSeries(samples, "agentic_rag_tokens_total"); got <= 0 {
+		t.Errorf("tokens = %v, want some", got)
+	}
+	for series := range samples {
+		if strings.Contains(series, "Eiffel") || strings.Contains(series, "Earth") {
+			t.Errorf("series %s leaks query text", series)
+		}
+	}
+
+	// A request whose every model call fails is counted as failed, with each attempt
+	failing := &mockProvider{fail: func(string) error { return errors.New("model unavailable") }}
+	p = newTestProcessor(t, failing, func(config *AgenticRAGConfig) {
+		config.Retry.InitialBackoff, config.Retry.MaxBackoff = time.Millisecond, time.Millisecond
+	}, WithMetrics(metrics))
+	_, err := p.Process(ctx, testRequest("Where is the Pacific?"))
+	if err == nil {
+		t.Fatalf("Process succeeded without a working model")
+	}
+
+	samples = scrapeMetrics(t, metrics)
+	outcome := metricsOutcome(err)
+	if got := samples[`agentic_rag_requests_total{outcome="`+outcome+`"}`]; got != 1 {
+		t.Errorf("requests with outcome %s = %v, want 1", outcome, got)
+	}
+	if got := sumSeries(samples, "agentic_rag_requests_total"); got != 3 {
+		t.Errorf("requests = %v, want 3", got)
+	}
+	failed := sumSeries(samples, "agentic_rag_model_calls_total", model) - float64(mock.callCount())
+	if failed != float64(failing.callCount()) || failed == 0 {
+		t.Errorf("model calls of the failing run = %v, want the %d the mock served", failed, failing.callCount())
+	}
+	if got := samples[`agentic_rag_model_calls_total{`+model+`,outcome="success"}`]; got != float64(mock.callCount()) {
+		t.Errorf("successful model calls = %v, want still %d", got, mock.callCount())
+	}
+	if got := samples[`agentic_rag_retry_wait_seconds_total{`+model+`}`]; got <= 0 {
+		t.Errorf("retry wait = %v, want the backoff of the retried calls", got)
+	}
+}
@@ -0,0 +1,206 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// mockModel is the model the default prompts declare, which the mock provider stands
+// in for
+const mockModel = "googleai/gemini-2.5-flash"
+
+// mockProvider answers the pipeline's prompts with canned JSON picked by the output
+// schema of each call, so whole requests run without a real provider
+type mockProvider struct {
+	mu      sync.Mutex
+	calls   int
+	prompts []string // Rendered prompts of the calls served, in call order
+
+	// answer returns the synthesized answer for a rendered prompt, "" for the default
+	answer func(prompt string) string
+	// fail, when set, fails the calls it returns an error for
+	fail func(prompt string) error
+}
+
+// callCount returns the calls the mock has served
+func (m *mockProvider) callCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls
+}
+
+// servedPrompts returns the rendered prompts of the calls served
+func (m *mockProvider) servedPrompts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.prompts...)
+}
+
+// generate implements ai.ModelFunc
+func (m *mockProvider) generate(ctx context.Context, req *ai.ModelRequest, cb ai.ModelStreamCallback) (*ai.ModelResponse, error) {
+	prompt := renderMessages(req.Messages)
+	m.mu.Lock()
+	m.calls++
+	m.prompts = append(m.prompts, prompt)
+	m.mu.Unlock()
+
+	if m.fail != nil {
+		if err := m.fail(prompt); err != nil {
+			return nil, err
+		}
+	}
+
+	var output any
+	switch properties := schemaProperties(req, prompt); {
+	case properties["answer"]:
+		answer := "The documents answer the question."
+		if m.answer != nil {
+			if text := m.answer(prompt); text != "" {
+				answer = text
+			}
+		}
+		output = map[string]any{"answer": answer, "sources_used": []string{}, "confidence_score": 0.9}
+	case properties["chunks"]:
+		chunks := make([]map[string]any, strings.Count(prompt, "**Chunk "))
+		for i := range chunks {
+			chunks[i] = map[string]any{"chunk_index": i, "relevance_score": 0.9 - float64(i)*0.01, "reasoning": "mentions the query"}
+		}
+		output = map[string]any{"chunks": chunks}
+	case properties["information_gain"]:
+		output = map[string]any{"information_gain": 0.0, "confidence": 0.9, "entities": []string{}, "relations": []string{}, "reasoning": "the passages answer the query"}
+	case properties["rewritten_query"]:
+		output = map[string]any{"rewritten_query": promptQuery(prompt)}
+	case properties["entities"]:
+		entities := []map[string]any{}
+		for _, entity := range mockEntities {
+			if strings.Contains(prompt, entity.name) {
+				entities = append(entities, map[string]any{"name": entity.name, "type": entity.kind, "confidence": 0.9, "mentions": []string{entity.name}})
+			}
+		}
+		output = map[string]any{"entities": entities, "relations": []any{}}
+	case properties["claims"]:
+		output = map[string]any{"claims": []any{}}
+	default:
+		output = map[string]any{}
+	}
+	text, err := json.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	if cb != nil {
+		if err := cb(ctx, &ai.ModelResponseChunk{Content: []*ai.Part{ai.NewTextPart(string(text))}}); err != nil {
+			return nil, err
+		}
+	}
+	return &ai.ModelResponse{
+		Request: req,
+		Message: ai.NewModelTextMessage(string(text)),
+		Usage:   &ai.GenerationUsage{InputTokens: len(prompt) / 4, OutputTokens: len(text) / 4},
+	}, nil
+}
+
+// mockEntities are the entities the mock extracts from the prompts naming them
+var mockEntities = []struct{ name, kind string }{
+	{"Eiffel Tower", "LOCATION"},
+	{"Paris", "LOCATION"},
+	{"Photosynthesis", "CONCEPT"},
+	{"Pacific", "LOCATION"},
+}
+
+// schemaProperties returns the top-level properties of the call's output schema, or of
+// the schema its prompt spells out, see generateJSON
+func schemaProperties(req *ai.ModelRequest, prompt string) map[string]bool {
+	properties := make(map[string]bool)
+	var schema map[string]any
+	if req.Output != nil {
+		schema = req.Output.Schema
+	}
+	if _, encoded, ok := strings.Cut(prompt, "matching this JSON schema, without code fences or commentary:\n"); ok && schema == nil {
+		_ = json.NewDecoder(strings.NewReader(encoded)).Decode(&schema)
+	}
+	fields, _ := schema["properties"].(map[string]any)
+	for name := range fields {
+		properties[name] = true
+	}
+	return properties
+}
+
+// promptQuery returns the query a rendered prompt states
+func promptQuery(prompt string) string {
+	_, query, _ := strings.Cut(prompt, "**Query:** ")
+	query, _, _ = strings.Cut(query, "\n")
+	return query
+}
+
+// newTestProcessor returns a processor running on a fresh genkit instance with the
+// mock provider registered in place of the prompts' model. configure, when set,
+// adjusts the configuration before the processor is created.
+func newTestProcessor(t testing.TB, mock *mockProvider, configure func(*AgenticRAGConfig), opts ...ProcessorOption) *AgenticRAGProcessor {
+	t.Helper()
+	ctx := context.Background()
+	g, err := genkit.Init(ctx, genkit.WithPromptDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("genkit.Init: %v", err)
+	}
+	provider, name, _ := strings.Cut(mockModel, "/")
+	genkit.DefineModel(g, provider, name, &ai.ModelInfo{
+		Supports: &ai.ModelSupports{Multiturn: true, SystemRole: true, Constrained: ai.ConstrainedSupportAll},
+	}, mock.generate)
+
+	config := DefaultConfig()
+	config.ModelName = mockModel
+	config.Genkit = g
+	if configure != nil {
+		configure(config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid test configuration: %v", err)
+	}
+	p := NewAgenticRAGProcessor(config, opts...)
+	if err := p.initializePrompts(ctx); err != nil {
+		t.Fatalf("initializePrompts: %v", err)
+	}
+	return p
+}
+
+// testDocuments are short English documents about distinct topics
+var testDocuments = []string{
+	"The Eiffel Tower is in Paris. It was completed in 1889 for the World's Fair.",
+	"Photosynthesis converts light into chemical energy. Plants store it as glucose.",
+	"The Pacific is the largest ocean on Earth. It covers about a third of the surface.",
+}
+
+// testRequest returns a request over testDocuments
+func testRequest(query string) AgenticRAGRequest {
+	return AgenticRAGRequest{Query: query, Documents: testDocuments}
+}
+
+// mustProcess runs the request, failing the test on error
+func mustProcess(t testing.TB, p *AgenticRAGProcessor, request AgenticRAGRequest) *AgenticRAGResponse {
+	t.Helper()
+	response, err := p.Process(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	return response
+}
+
+// TestMockProviderRunsPipeline checks the harness itself: a request runs end to end
+// on the mock provider
+func TestMockProviderRunsPipeline(t *testing.T) {
+	mock := &mockProvider{}
+	p := newTestProcessor(t, mock, nil)
+	response := mustProcess(t, p, testRequest("Where is the Eiffel Tower?"))
+	if response.Answer == "" {
+		t.Errorf("empty answer")
+	}
+	if mock.callCount() == 0 {
+		t.Errorf("the mock provider served no calls")
+	}
+}
@@ -0,0 +1,195 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// numericConflictPenalty scales the confidence of a claim whose numbers disagree with
+// the model's verdict
+const numericConflictPenalty = 0.5
+
+// numberPattern matches a number with optional thousands separators and decimals,
+// preceded by an optional comparison
+var numberPattern = regexp.MustCompile(`(?i)(?:\b(under|below|less than|fewer than|at most|up to|no more than|over|above|more than|greater than|at least|exceeding|exceeds)\s+|([<>≤≥]=?)\s*)?(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?`)
+
+// unitPattern matches the word or symbol following a number
+var unitPattern = regexp.MustCompile(`^(?:\s*|-)([%µ\pL]+)`)
+
+// numericUnit is a unit's dimension and its factor to the dimension's base unit
+type numericUnit struct {
+	dimension string
+	factor    float64
+}
+
+// numericUnits maps lowercase units to their dimension, so quantities in different
+// units of one dimension compare
+var numericUnits = map[string]numericUnit{
+	"%": {"percent", 1}, "percent": {"percent", 1}, "pct": {"percent", 1},
+	"ns": {"time", 1e-9}, "µs": {"time", 1e-6}, "us": {"time", 1e-6}, "ms": {"time", 1e-3},
+	"s": {"time", 1}, "sec": {"time", 1}, "secs": {"time", 1}, "second": {"time", 1}, "seconds": {"time", 1},
+	"min": {"time", 60}, "mins": {"time", 60}, "minute": {"time", 60}, "minutes": {"time", 60},
+	"h": {"time", 3600}, "hr": {"time", 3600}, "hrs": {"time", 3600}, "hour": {"time", 3600}, "hours": {"time", 3600},
+	"day": {"time", 86400}, "days": {"time", 86400},
+	"b": {"data", 1}, "byte": {"data", 1}, "bytes": {"data", 1},
+	"kb": {"data", 1e3}, "mb": {"data", 1e6}, "gb": {"data", 1e9}, "tb": {"data", 1e12},
+	"kib": {"data", 1 << 10}, "mib": {"data", 1 << 20}, "gib": {"data", 1 << 30}, "tib": {"data", 1 << 40},
+	"hz": {"frequency", 1}, "khz": {"frequency", 1e3}, "mhz": {"frequency", 1e6}, "ghz": {"frequency", 1e9},
+	"mm": {"length", 1e-3}, "cm": {"length", 1e-2}, "m": {"length", 1}, "km": {"length", 1e3},
+	"mg": {"mass", 1e-6}, "g": {"mass", 1e-3}, "kg": {"mass", 1},
+}
+
+// numericScales maps scale words and suffixes to their multiplier. Single letters are
+// matched case-sensitively, so "M" is a million and "m" a metre.
+var numericScales = map[string]float64{
+	"k": 1e3, "K": 1e3, "thousand": 1e3,
+	"M": 1e6, "mn": 1e6, "million": 1e6,
+	"bn": 1e9, "billion": 1e9,
+	"trillion": 1e12,
+}
+
+// quantity is a number read from text, in the base unit of its dimension. Numbers
+// followed by an unknown word count that word, so "2 million tokens" has the dimension
+// "token"; bare numbers have none.
+type quantity struct {
+	value     float64
+	dimension string
+	bound     int    // -1 for an upper bound such as "under 50ms", 1 for a lower bound, 0 for a value
+	text      string // As written
+}
+
+// parseQuantities returns the numbers of the text with their units
+func parseQuantities(text string) []quantity {
+	var quantities []quantity
+	for _, match := range numberPattern.FindAllStringSubmatchIndex(text, -1) {
+		numberStart := match[6]
+		// Skip digits inside words such as "GPT4" or after a decimal point
+		if r, _ := utf8.DecodeLastRuneInString(text[:numberStart]); numberStart > 0 && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.') {
+			continue
+		}
+		number := strings.ReplaceAll(text[numberStart:match[7]], ",", "")
+		if match[8] >= 0 {
+			number += text[match[8]:match[9]]
+		}
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil {
+			continue
+		}
+
+		q := quantity{value: value}
+		switch comparison := strings.ToLower(submatch(text, match, 1) + submatch(text, match, 2)); comparison {
+		case "":
+		case "under", "below", "less than", "fewer than", "at most", "up to", "no more than", "<", "<=", "≤":
+			q.bound = -1
+		default:
+			q.bound = 1
+		}
+
+		end := match[1]
+		if word := unitPattern.FindStringSubmatchIndex(text[end:]); word != nil {
+			token := text[end+word[2] : end+word[3]]
+			scale, ok := numericScales[token]
+			if !ok && len(token) > 1 {
+				scale, ok = numericScales[strings.ToLower(token)]
+			}
+			if ok {
+				q.value *= scale
+				end += word[1]
+				if word = unitPattern.FindStringSubmatchIndex(text[end:]); word != nil {
+					token = text[end+word[2] : end+word[3]]
+				}
+			}
+			if word != nil {
+				if unit, ok := numericUnits[strings.ToLower(token)]; ok {
+					q.value *= unit.factor
+					q.dimension = unit.dimension
+				} else {
+					q.dimension = strings.TrimSuffix(strings.ToLower(token), "s")
+				}
+				end += word[1]
+			}
+		}
+		q.text = strings.TrimSpace(text[match[0]:end])
+		quantities = append(quantities, q)
+	}
+	return quantities
+}
+
+// submatch returns the text of a capture group, empty when it didn't participate
+func submatch(text string, match []int, group int) string {
+	if match[2*group] < 0 {
+		return ""
+	}
+	return text[match[2*group]:match[2*group+1]]
+}
+
+// agrees reports whether an evidenced value satisfies the quantity within the relative
+// tolerance
+func (q quantity) agrees(value, tolerance float64) bool {
+	slack := tolerance * max(math.Abs(q.value), math.Abs(value))
+	switch q.bound {
+	case -1:
+		return value <= q.value+slack
+	case 1:
+		return value >= q.value-slack
+	}
+	return math.Abs(q.value-value) <= slack
+}
+
+// checkClaimNumbers compares the quantities of a claim with those of its evidence. A
+// supported or partially supported claim stating a number the evidence contradicts is
+// refuted; a refuted claim whose numbers all agree with the evidence keeps its verdict.
+// Either way the confidence drops and NumericConflict flags the claim for review.
+// Quantities the evidence says nothing about in their dimension are left alone.
+func checkClaimNumbers(claim *Claim, tolerance float64) {
+	claimed := parseQuantities(claim.Text)
+	if len(claimed) == 0 {
+		return
+	}
+	var evidenced []quantity
+	for _, text := range claim.Evidence {
+		evidenced = append(evidenced, parseQuantities(text)...)
+	}
+	for _, span := range claim.EvidenceSpans {
+		evidenced = append(evidenced, parseQuantities(span.Text)...)
+	}
+
+	agreed, conflict := 0, ""
+	for _, q := range claimed {
+		var stated *quantity
+		found := false
+		for i, e := range evidenced {
+			if e.dimension != q.dimension {
+				continue
+			}
+			if q.agrees(e.value, tolerance) {
+				found = true
+				break
+			}
+			if stated == nil {
+				stated = &evidenced[i]
+			}
+		}
+		switch {
+		case found:
+			agreed++
+		case stated != nil && conflict == "":
+			conflict = fmt.Sprintf("claim states %s, evidence states %s", q.text, stated.text)
+		}
+	}
+
+	switch {
+	case conflict != "" && (claim.Verdict == VerdictSupported || claim.Verdict == VerdictPartiallySupported):
+		claim.Verdict = VerdictRefuted
+		claim.Confidence *= numericConflictPenalty
+		claim.NumericConflict = conflict
+	case conflict == "" && agreed == len(claimed) && claim.Verdict == VerdictRefuted:
+		claim.Confidence *= numericConflictPenalty
+		claim.NumericConflict = "claim's numbers agree with the evidence"
+	}
+}
@@ -0,0 +1,179 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OtherEntityType is the type of entities whose type is unknown, with
+// UnknownEntityTypesOther
+const OtherEntityType = "OTHER"
+
+// UnknownEntityTypePolicy decides what happens to extracted entities whose type is
+// neither a requested entity type nor one of its subtypes
+type UnknownEntityTypePolicy string
+
+const (
+	UnknownEntityTypesKeep     UnknownEntityTypePolicy = "keep"     // Keep the type the model gave
+	UnknownEntityTypesAncestor UnknownEntityTypePolicy = "ancestor" // Map it onto its nearest requested ancestor, or the requested type it nearly spells, else OTHER
+	UnknownEntityTypesOther    UnknownEntityTypePolicy = "other"    // Map it onto OTHER
+)
+
+// entityOntology is the type hierarchy of KnowledgeGraphConfig.TypeParents, keyed by
+// uppercased type
+type entityOntology map[string]string
+
+// newEntityOntology builds the hierarchy from types to their parents
+func newEntityOntology(parents map[string]string) entityOntology {
+	ontology := make(entityOntology, len(parents))
+	for child, parent := range parents {
+		ontology[strings.ToUpper(strings.TrimSpace(child))] = strings.ToUpper(strings.TrimSpace(parent))
+	}
+	return ontology
+}
+
+// ancestors returns the parent of the type, its parent and so on, nearest first
+func (o entityOntology) ancestors(entityType string) []string {
+	var ancestors []string
+	current := strings.ToUpper(strings.TrimSpace(entityType))
+	for len(ancestors) < len(o) { // Bounded in case of a cycle
+		parent, ok := o[current]
+		if !ok || parent == "" {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		current = parent
+	}
+	return ancestors
+}
+
+// isA reports whether the type is ancestor or one of its subtypes, ignoring case
+func (o entityOntology) isA(entityType, ancestor string) bool {
+	if strings.EqualFold(strings.TrimSpace(entityType), strings.TrimSpace(ancestor)) {
+		return true
+	}
+	for _, parent := range o.ancestors(entityType) {
+		if strings.EqualFold(parent, strings.TrimSpace(ancestor)) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand returns the types followed by their subtypes in the ontology, sorted
+func (o entityOntology) expand(types []string) []string {
+	expanded := append([]string(nil), types...)
+	var subtypes []string
+	for child := range o {
+		if containsFold(expanded, child) {
+			continue
+		}
+		for _, entityType := range types {
+			if o.isA(child, entityType) {
+				subtypes = append(subtypes, child)
+				break
+			}
+		}
+	}
+	sort.Strings(subtypes)
+	return append(expanded, subtypes...)
+}
+
+// describe returns the types and their subtypes for the extraction prompt, subtypes
+// annotated with their parent, such as "FRAMEWORK (a kind of TECHNOLOGY)"
+func (o entityOntology) describe(types []string) []string {
+	expanded := o.expand(types)
+	described := make([]string, len(expanded))
+	for i, entityType := range expanded {
+		described[i] = entityType
+		if parent, ok := o[strings.ToUpper(strings.TrimSpace(entityType))]; ok {
+			described[i] = fmt.Sprintf("%s (a kind of %s)", entityType, parent)
+		}
+	}
+	return described
+}
+
+// filter returns a function reporting whether a type is one of the types or a subtype
+// of one, matching every type if there are none
+func (o entityOntology) filter(types []string) func(string) bool {
+	return func(entityType string) bool {
+		if len(types) == 0 {
+			return true
+		}
+		for _, t := range types {
+			if o.isA(entityType, t) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// containsFold reports whether values contains value, ignoring case
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// entityTypes returns the entity types the request extracts: its own if it overrides
+// them, else the configured ones
+func (p *AgenticRAGProcessor) entityTypes(ctx context.Context) []string {
+	if types := requestStateFrom(ctx).entityTypeOverride(); len(types) > 0 {
+		return types
+	}
+	return p.config.KnowledgeGraph.EntityTypes
+}
+
+// resolveEntityTypes rewrites the types of extracted entities to the spelling of the
+// requested type or subtype they name, and maps unknown types per
+// KnowledgeGraphConfig.UnknownEntityTypes
+func (p *AgenticRAGProcessor) resolveEntityTypes(ctx context.Context, kg *KnowledgeGraph) {
+	if kg == nil {
+		return
+	}
+	ontology := newEntityOntology(p.config.KnowledgeGraph.TypeParents)
+	requested := p.entityTypes(ctx)
+	known := ontology.expand(requested)
+	lookup := make(map[string]string, len(known))
+	for _, entityType := range known {
+		lookup[normalizeEntityName(entityType)] = entityType
+	}
+
+	for i := range kg.Entities {
+		entity := &kg.Entities[i]
+		if entityType, ok := lookup[normalizeEntityName(entity.Type)]; ok {
+			entity.Type = entityType
+			continue
+		}
+		switch p.config.KnowledgeGraph.UnknownEntityTypes {
+		case UnknownEntityTypesAncestor:
+			entity.Type = nearestEntityType(ontology, lookup, known, entity.Type)
+		case UnknownEntityTypesOther:
+			entity.Type = OtherEntityType
+		}
+	}
+}
+
+// nearestEntityType returns the nearest ancestor of an unknown type that is known, or
+// the known type its name is most alike at relationTypeSimilarity or more, else OTHER
+func nearestEntityType(ontology entityOntology, lookup map[string]string, known []string, entityType string) string {
+	for _, ancestor := range ontology.ancestors(entityType) {
+		if match, ok := lookup[normalizeEntityName(ancestor)]; ok {
+			return match
+		}
+	}
+	best, bestSimilarity := OtherEntityType, 0.0
+	key := normalizeEntityName(entityType)
+	for _, candidate := range known {
+		if similarity := jaroWinkler(key, normalizeEntityName(candidate)); similarity >= relationTypeSimilarity && similarity > bestSimilarity {
+			best, bestSimilarity = candidate, similarity
+		}
+	}
+	return best
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// modelStages are the pipeline stages that call models, the valid keys of
+// AgenticRAGOptions.ModelOverrides
+var modelStages = []string{
+	StageLoading,
+	StageQueryRewrite,
+	StageRelevanceScoring,
+	StageReranking,
+	StageRefinement,
+	StageGeneration,
+	StageGroundedness,
+	StageAnswerAssessment,
+	StageKnowledgeGraph,
+	StageFactVerification,
+}
+
+// stageKey is the context key for the pipeline stage a model call belongs to
+type stageKey struct{}
+
+// withStage returns a context attributing model calls to the stage
+func withStage(ctx context.Context, stage string) context.Context {
+	return context.WithValue(ctx, stageKey{}, stage)
+}
+
+// stageFrom returns the stage stored in ctx, or "" if there is none
+func stageFrom(ctx context.Context) string {
+	stage, _ := ctx.Value(stageKey{}).(string)
+	return stage
+}
+
+// validateModelOverrides checks the request's model overrides name known stages and
+// models registered with genkit
+func (p *AgenticRAGProcessor) validateModelOverrides(options AgenticRAGOptions) error {
+	if options.Model != "" {
+		if err := p.checkModelRegistered("options.model", options.Model); err != nil {
+			return err
+		}
+	}
+	for _, stage := range sortedKeys(options.ModelOverrides) {
+		field := "options.model_overrides." + stage
+		if !containsString(modelStages, stage) {
+			return fieldError(field, "unknown stage, must be one of %s", strings.Join(modelStages, ", "))
+		}
+		if err := p.checkModelRegistered(field, options.ModelOverrides[stage]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeFactVerification returns the configured fact verification settings with the
+// request's overrides applied
+func (p *AgenticRAGProcessor) mergeFactVerification(overrides *FactVerificationOverrides) FactVerificationConfig {
+	config := p.config.FactVerification
+	if overrides == nil {
+		return config
+	}
+	if overrides.RequireEvidence != nil {
+		config.RequireEvidence = *overrides.RequireEvidence
+	}
+	if overrides.MinConfidenceScore != nil {
+		config.MinConfidenceScore = *overrides.MinConfidenceScore
+	}
+	if overrides.PartialSupportMinConfidence != nil {
+		config.PartialSupportMinConfidence = *overrides.PartialSupportMinConfidence
+	}
+	if overrides.UnsupportedClaims != nil {
+		config.UnsupportedClaims = *overrides.UnsupportedClaims
+	}
+	if overrides.CheckNumbers != nil {
+		config.CheckNumbers = *overrides.CheckNumbers
+	}
+	if overrides.NumericTolerance != nil {
+		config.NumericTolerance = *overrides.NumericTolerance
+	}
+	if overrides.SelfConsistency != nil {
+		config.SelfConsistency = *overrides.SelfConsistency
+	}
+	if overrides.SelfConsistencySamples != nil {
+		config.SelfConsistencySamples = *overrides.SelfConsistencySamples
+	}
+	if overrides.SelfConsistencyBand != nil {
+		config.SelfConsistencyBand = *overrides.SelfConsistencyBand
+	}
+	return config
+}
+
+// validateFactVerificationOverrides checks the settings the request's overrides merge
+// into by the rules of the configuration
+func (p *AgenticRAGProcessor) validateFactVerificationOverrides(overrides *FactVerificationOverrides) error {
+	if overrides == nil {
+		return nil
+	}
+	return errors.Join(validateFactVerification("options.fact_verification.", p.mergeFactVerification(overrides))...)
+}
+
+// factVerification returns the fact verification settings of the request: the
+// configured ones with its overrides applied
+func (p *AgenticRAGProcessor) factVerification(ctx context.Context) FactVerificationConfig {
+	if config := requestStateFrom(ctx).factVerificationOverride(); config != nil {
+		return *config
+	}
+	return p.config.FactVerification
+}
+
+// checkModelRegistered returns a ValidationError for field unless the model is known
+// to genkit, including models a plugin resolves on demand
+func (p *AgenticRAGProcessor) checkModelRegistered(field, model string) error {
+	if model == "" {
+		return fieldError(field, "must not be empty")
+	}
+	if p.config.Genkit == nil {
+		return nil // Nothing to check against; the call itself will fail
+	}
+	provider, name, found := strings.Cut(model, "/")
+	if !found {
+		return fieldError(field, "must be a provider-qualified model name such as googleai/gemini-2.5-flash, got %q", model)
+	}
+	if genkit.LookupModel(p.config.Genkit, provider, name) == nil {
+		return fieldError(field, "model %q is not registered", model)
+	}
+	return nil
+}
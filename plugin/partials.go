@@ -0,0 +1,187 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// partialsDirectory is the subdirectory of the prompts directory, and of the embedded
+// defaults, holding the partials
+const partialsDirectory = "_partials"
+
+// partialTag matches the inclusion of a partial by name, e.g. {{> preamble}}, capturing
+// the tag's opening and the name. Partials named by a subexpression don't match.
+var partialTag = regexp.MustCompile(`({{~?>\s*)([\w.\-]+)`)
+
+// readPartials returns the partial templates by name: the embedded defaults, overridden
+// by the prompts directory's. The directory's partials are the files of its _partials
+// subdirectory and the files anywhere in it named with a leading underscore, as genkit
+// loads them; the underscore isn't part of the name. A partial named
+// <partial>.<variant> overrides the partial in prompts run in that variant.
+func readPartials(config PromptsConfig) (map[string]string, error) {
+	partials := make(map[string]string)
+	embedded, err := fs.Glob(defaultPrompts, "defaultprompts/"+partialsDirectory+"/*.prompt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded partials: %w", err)
+	}
+	for _, path := range embedded {
+		source, err := defaultPrompts.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read embedded partials: %w", err)
+		}
+		partials[partialName(path)] = string(source)
+	}
+
+	if config.Directory == "" {
+		return partials, nil
+	}
+	err = filepath.WalkDir(config.Directory, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".prompt") {
+			return nil
+		}
+		if filepath.Base(filepath.Dir(path)) != partialsDirectory && !strings.HasPrefix(entry.Name(), "_") {
+			return nil
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		partials[partialName(path)] = string(source)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read partials: %w", err)
+	}
+	return partials, nil
+}
+
+// partialName returns the name of the partial in the file at path
+func partialName(path string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "_"), ".prompt")
+}
+
+// variantPartialName returns the name the variant's override of the partial registers
+// under, as genkit has one set of partials for all prompts
+func variantPartialName(partial, variant string) string {
+	return partial + "__" + variant
+}
+
+// templatePartials returns the names of the partials the dotprompt source includes
+func templatePartials(source string) []string {
+	var names []string
+	for _, match := range partialTag.FindAllStringSubmatch(source, -1) {
+		names = append(names, match[2])
+	}
+	return names
+}
+
+// applyPartialOverrides rewrites the dotprompt source to include the variant's
+// overrides of the partials instead of the partials, reporting whether the source
+// includes any partial the variant overrides
+func applyPartialOverrides(source, variant string, partials map[string]string) (string, bool) {
+	applied := false
+	rewritten := partialTag.ReplaceAllStringFunc(source, func(tag string) string {
+		match := partialTag.FindStringSubmatch(tag)
+		if _, ok := partials[match[2]+"."+variant]; !ok {
+			return tag
+		}
+		applied = true
+		return match[1] + variantPartialName(match[2], variant)
+	})
+	return rewritten, applied
+}
+
+// partialVariantSource returns the source of the prompt in the variant with the
+// variant's partial overrides applied: the variant's own template if it has one, else
+// the prompt's. It reports false when the template includes no partial the variant
+// overrides.
+func partialVariantSource(config PromptsConfig, partials map[string]string, key, name, variant string) (string, bool) {
+	source, err := promptSource(config, key, name+"."+variant, true)
+	if err != nil || source == "" {
+		return "", false
+	}
+	return applyPartialOverrides(source, variant, partials)
+}
+
+// partialVariants returns the variants the partials override a partial in
+func partialVariants(partials map[string]string) []string {
+	seen := make(map[string]bool)
+	var variants []string
+	for _, name := range sortedKeys(partials) {
+		if _, variant, ok := strings.Cut(name, "."); ok && variant != "" && !seen[variant] {
+			seen[variant] = true
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// loadPartials registers the partials of readPartials with genkit, and the variants of
+// the configured prompts that override partials, so a variant can replace a partial
+// without copying the prompts including it
+func (p *AgenticRAGProcessor) loadPartials(ctx context.Context) error {
+	partials, err := readPartials(p.config.Prompts)
+	if err != nil {
+		return err
+	}
+	g := p.config.Genkit
+	for _, name := range sortedKeys(partials) {
+		registered := name
+		if partial, variant, ok := strings.Cut(name, "."); ok {
+			registered = variantPartialName(partial, variant)
+		}
+		// Fails when genkit loaded the partial from the prompts directory or another
+		// processor of the genkit instance registered it, whose version is kept
+		_ = genkit.DefinePartial(g, registered, partials[name])
+	}
+
+	variants := partialVariants(partials)
+	if len(variants) == 0 {
+		return nil
+	}
+	p.partialVariants = make(map[string]*ai.Prompt)
+	names := p.config.Prompts.promptNames()
+	for _, key := range sortedKeys(names) {
+		if names[key] == "" {
+			continue
+		}
+		for _, variant := range variants {
+			name := names[key] + "." + variant
+			source, ok := partialVariantSource(p.config.Prompts, partials, key, names[key], variant)
+			if !ok {
+				continue
+			}
+			prompt, err := loadPromptSource(g, "agentic-rag-partials-"+contentHash(source)[:16], name, source)
+			if err != nil {
+				return fmt.Errorf("failed to load prompt %s with its partial overrides: %w", name, err)
+			}
+			p.partialVariants[name] = prompt
+			slog.InfoContext(ctx, "loaded prompt variant from partial overrides", "prompt", name)
+		}
+	}
+	return nil
+}
+
+// hasPartialVariant reports whether the variant of the named prompt is made up by
+// partial overrides
+func (c PromptsConfig) hasPartialVariant(key, name, variant string) bool {
+	partials, err := readPartials(c)
+	if err != nil {
+		return false
+	}
+	_, ok := partialVariantSource(c, partials, key, name, variant)
+	return ok
+}
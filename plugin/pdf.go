@@ -0,0 +1,950 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// PDFMIMEType is the MIME type of PDF documents
+const PDFMIMEType = "application/pdf"
+
+// PDF errors reported per document
+var (
+	ErrPDFEncrypted  = errors.New("pdf is encrypted")
+	ErrPDFUnreadable = errors.New("pdf is unreadable")
+	ErrPDFNoText     = errors.New("pdf has no extractable text")
+	ErrPDFTooLarge   = errors.New("pdf decompresses beyond the size limit")
+)
+
+// isPDF reports whether the document should be ingested as a PDF
+func isPDF(doc Document) bool {
+	switch {
+	case doc.MIMEType != "":
+		return strings.HasPrefix(doc.MIMEType, PDFMIMEType)
+	case len(doc.Data) > 0:
+		return bytes.HasPrefix(doc.Data, []byte("%PDF-"))
+	default:
+		return strings.EqualFold(filepath.Ext(doc.Path), ".pdf")
+	}
+}
+
+// ingestPDFs extracts the text of PDF documents that have no content yet, page by
+// page. Documents that fail to extract are removed and reported as document errors
+// rather than failing the request.
+func (p *AgenticRAGProcessor) ingestPDFs(ctx context.Context, documents []Document) ([]Document, error) {
+	if !p.config.PDF.Enabled {
+		return documents, nil
+	}
+
+	_, errs, err := runParallel(ctx, len(documents), p.config.Processing.Concurrency, false,
+		func(ctx context.Context, i int) (struct{}, error) {
+			doc := &documents[i]
+			if doc.Content != "" || len(doc.Pages) > 0 || (len(doc.Data) == 0 && doc.Path == "") || !isPDF(*doc) {
+				return struct{}{}, nil
+			}
+
+			data, err := p.readPDF(*doc)
+			if err != nil {
+				return struct{}{}, err
+			}
+			pages, err := p.extractPDFPages(ctx, data)
+			if err != nil {
+				return struct{}{}, err
+			}
+
+			texts := make([]string, len(pages))
+			for i, page := range pages {
+				texts[i] = page.Content
+			}
+			doc.Pages = pages
+			doc.Content = strings.Join(texts, "\f")
+			doc.MIMEType = PDFMIMEType
+			doc.Metadata["page_count"] = len(pages)
+			return struct{}{}, nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	state := requestStateFrom(ctx)
+	loaded := documents[:0]
+	for i, doc := range documents {
+		if errs[i] != nil {
+			source := doc.Source
+			if source == "" {
+				source = doc.Path
+			}
+			state.recordDocumentError(DocumentError{DocumentID: doc.ID, Source: source, Error: errs[i].Error()})
+			continue
+		}
+		loaded = append(loaded, doc)
+	}
+	return loaded, nil
+}
+
+// readPDF returns the raw bytes of a PDF document, reading them from its path if needed
+func (p *AgenticRAGProcessor) readPDF(doc Document) ([]byte, error) {
+	config := p.config.PDF
+	data := doc.Data
+	if len(data) == 0 {
+		if !config.AllowFilePaths {
+			return nil, fmt.Errorf("reading documents from file paths is disabled")
+		}
+		info, err := os.Stat(doc.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", doc.Path, err)
+		}
+		if config.MaxBytes > 0 && info.Size() > config.MaxBytes {
+			return nil, fmt.Errorf("document %s exceeds the %d byte limit", doc.Path, config.MaxBytes)
+		}
+		if data, err = os.ReadFile(doc.Path); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", doc.Path, err)
+		}
+	}
+	if config.MaxBytes > 0 && int64(len(data)) > config.MaxBytes {
+		return nil, fmt.Errorf("document exceeds the %d byte limit", config.MaxBytes)
+	}
+	return data, nil
+}
+
+// extractPDFPages extracts the text of each page. Pages without a text layer are
+// transcribed from their scanned image when the OCR fallback is enabled.
+func (p *AgenticRAGProcessor) extractPDFPages(ctx context.Context, data []byte) ([]DocumentPage, error) {
+	file, err := parsePDF(data, p.config.PDF.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([]DocumentPage, len(file.pages))
+	hasText := false
+	for i, page := range file.pages {
+		text, err := file.pageText(page)
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = DocumentPage{Number: i + 1, Content: text}
+		if pages[i].Content != "" {
+			hasText = true
+			continue
+		}
+		if !p.config.PDF.OCRFallback {
+			continue
+		}
+
+		image := file.pageImage(page)
+		if image == nil {
+			continue
+		}
+		text, err = p.transcribePageImage(ctx, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transcribe page %d: %w", i+1, err)
+		}
+		pages[i].Content = text
+		pages[i].OCR = true
+		hasText = hasText || text != ""
+	}
+
+	if !hasText {
+		return nil, ErrPDFNoText
+	}
+	return pages, nil
+}
+
+// transcribePageImage sends a scanned page image to the multimodal model and returns
+// its text
+func (p *AgenticRAGProcessor) transcribePageImage(ctx context.Context, jpeg []byte) (string, error) {
+	primary := namedCandidate(p.config.ModelName)
+	if p.config.PDF.OCRModel != "" {
+		primary = namedCandidate(p.config.PDF.OCRModel)
+	} else if p.config.Model != nil {
+		primary = modelCandidate{name: p.config.Model.Name(), option: ai.WithModel(p.config.Model)}
+	}
+
+	instruction := "Transcribe all text on this scanned document page exactly as written, in reading order. " +
+		"Respond with the text only, without commentary. Respond with nothing if the page has no text."
+	image := "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(jpeg)
+
+	response, err := p.callModel(ctx, primary, estimateTokens(instruction)+pageImageTokens,
+		func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+			opts := []ai.GenerateOption{
+				model.option,
+				ai.WithMessages(ai.NewUserMessage(ai.NewTextPart(instruction), ai.NewMediaPart("image/jpeg", image))),
+				ai.WithConfig(&ai.GenerationCommonConfig{Temperature: 0}),
+			}
+			if middleware := p.callMiddleware(ctx, model); middleware != nil {
+				opts = append(opts, middleware)
+			}
+			return genkit.Generate(ctx, p.config.Genkit, opts...)
+		})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(response.Text()), nil
+}
+
+// pageImageTokens is the estimated prompt cost of a page image
+const pageImageTokens = 1024
+
+// pdfObject is an indirect object of a PDF file
+type pdfObject struct {
+	dict   string // Object body, or the stream dictionary for stream objects
+	stream []byte // Raw stream data, nil if the object isn't a stream
+}
+
+// pdfFile is a parsed PDF file
+type pdfFile struct {
+	objects map[int]pdfObject
+	pages   []pdfObject
+	fonts   map[int]*pdfFont // Fonts loaded so far by object number
+
+	maxDecoded int64 // Bytes all streams may decompress to, 0 for no limit
+	decoded    int64 // Bytes decompressed so far
+}
+
+// PDF syntax patterns
+var (
+	pdfObjectPattern    = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfRefPattern       = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	pdfTrailerPattern   = regexp.MustCompile(`\btrailer\s*<<`)
+	pdfRootPattern      = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R\b`)
+	pdfTypePattern      = regexp.MustCompile(`/Type\s*/(\w+)`)
+	pdfLengthPattern    = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+	pdfStreamPattern    = regexp.MustCompile(`\bstream\r?\n`)
+	pdfIntegerPattern   = regexp.MustCompile(`^\s*(\d+)`)
+	pdfFilterPattern    = regexp.MustCompile(`/Filter\s*(?:\[\s*)?/(\w+)`)
+	pdfSubtypePattern   = regexp.MustCompile(`/Subtype\s*/(\w+)`)
+	pdfDimensionPattern = regexp.MustCompile(`/(Width|Height)\s+(\d+)`)
+)
+
+// parsePDF parses the objects and page tree of a PDF file. Only what's needed for
+// text extraction is supported: classic and compressed object streams with the
+// FlateDecode filter. Decompressing the streams to more than maxDecoded bytes fails
+// with ErrPDFTooLarge, guarding against decompression bombs. A file is encrypted
+// when a trailer or cross-reference stream dictionary has an /Encrypt entry.
+func parsePDF(data []byte, maxDecoded int64) (*pdfFile, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF-")) {
+		return nil, fmt.Errorf("%w: missing %%PDF header", ErrPDFUnreadable)
+	}
+
+	file := &pdfFile{objects: make(map[int]pdfObject), fonts: make(map[int]*pdfFont), maxDecoded: maxDecoded}
+	encrypted := false
+	matches := pdfObjectPattern.FindAllSubmatchIndex(data, -1)
+	for i, match := range matches {
+		number, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		end := len(data)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		object, rest := file.parseObject(data[match[1]:end])
+		// Later objects replace earlier ones, as in incremental updates
+		file.objects[number] = object
+		if file.typeOf(object) == "XRef" && file.keyIndex(object.dict, "Encrypt") >= 0 {
+			encrypted = true
+		}
+		// Trailers follow the cross-reference table after an object
+		for _, loc := range pdfTrailerPattern.FindAllIndex(rest, -1) {
+			trailer := string(rest[loc[1]-len("<<"):])
+			if file.keyIndex(trailer[:matchingDelimiter(trailer, "<<", ">>")], "Encrypt") >= 0 {
+				encrypted = true
+			}
+		}
+	}
+	if encrypted {
+		return nil, ErrPDFEncrypted
+	}
+	if len(file.objects) == 0 {
+		return nil, fmt.Errorf("%w: no objects found", ErrPDFUnreadable)
+	}
+
+	// Objects packed into compressed object streams
+	for _, object := range file.sortedObjects() {
+		if object.stream != nil && file.typeOf(object) == "ObjStm" {
+			if err := file.unpackObjectStream(object); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	file.pages = file.pageTree()
+	if len(file.pages) == 0 {
+		return nil, fmt.Errorf("%w: no pages found", ErrPDFUnreadable)
+	}
+	return file, nil
+}
+
+// parseObject splits the data following an object header into the object's
+// dictionary and raw stream data, and what follows its endobj keyword
+func (f *pdfFile) parseObject(body []byte) (object pdfObject, rest []byte) {
+	loc := pdfStreamPattern.FindIndex(body)
+	if end := bytes.Index(body, []byte("endobj")); end >= 0 && (loc == nil || end < loc[0]) {
+		return pdfObject{dict: string(body[:end])}, body[end+len("endobj"):]
+	}
+	if loc == nil {
+		return pdfObject{dict: string(body)}, nil
+	}
+
+	object = pdfObject{dict: string(body[:loc[0]])}
+	data := body[loc[1]:]
+	length := -1
+	if match := pdfLengthPattern.FindStringSubmatch(object.dict); match != nil && match[2] == "" {
+		length, _ = strconv.Atoi(match[1])
+	}
+	if length < 0 || length > len(data) || !bytes.HasPrefix(bytes.TrimLeft(data[length:], "\r\n "), []byte("endstream")) {
+		// Indirect or wrong /Length, fall back to the endstream keyword
+		length = bytes.Index(data, []byte("endstream"))
+		if length < 0 {
+			length = len(data)
+		}
+		for length > 0 && (data[length-1] == '\n' || data[length-1] == '\r') {
+			length--
+		}
+	}
+	object.stream = data[:length]
+	if end := bytes.Index(data[length:], []byte("endobj")); end >= 0 {
+		rest = data[length+end+len("endobj"):]
+	}
+	return object, rest
+}
+
+// unpackObjectStream adds the objects of a compressed object stream that aren't
+// defined directly in the file. A stream that doesn't decode is skipped; only
+// ErrPDFTooLarge is returned.
+func (f *pdfFile) unpackObjectStream(object pdfObject) error {
+	data, err := f.decode(object)
+	if errors.Is(err, ErrPDFTooLarge) {
+		return err
+	}
+	if err != nil {
+		return nil
+	}
+	count := f.intValue(object.dict, "N")
+	first := f.intValue(object.dict, "First")
+	if count <= 0 || first <= 0 || first > len(data) {
+		return nil
+	}
+
+	header := strings.Fields(string(data[:first]))
+	for i := 0; i+1 < len(header) && i/2 < count; i += 2 {
+		number, err1 := strconv.Atoi(header[i])
+		offset, err2 := strconv.Atoi(header[i+1])
+		if err1 != nil || err2 != nil || offset < 0 || offset > len(data)-first {
+			continue
+		}
+		end := len(data)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && next >= offset && next <= len(data)-first {
+				end = first + next
+			}
+		}
+		if _, ok := f.objects[number]; !ok {
+			f.objects[number] = pdfObject{dict: string(data[first+offset : end])}
+		}
+	}
+	return nil
+}
+
+// sortedObjects returns the objects in object number order
+func (f *pdfFile) sortedObjects() []pdfObject {
+	numbers := make([]int, 0, len(f.objects))
+	for number := range f.objects {
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+
+	objects := make([]pdfObject, len(numbers))
+	for i, number := range numbers {
+		objects[i] = f.objects[number]
+	}
+	return objects
+}
+
+// pageTree returns the pages in document order, walking the page tree from the
+// catalog and falling back to object order when the tree can't be followed
+func (f *pdfFile) pageTree() []pdfObject {
+	var pages []pdfObject
+	visited := make(map[int]bool)
+	var walk func(number int)
+	walk = func(number int) {
+		object, ok := f.objects[number]
+		if !ok || visited[number] {
+			return
+		}
+		visited[number] = true
+		switch f.typeOf(object) {
+		case "Pages":
+			for _, kid := range f.refs(f.rawEntry(object.dict, "Kids")) {
+				walk(kid)
+			}
+		case "Page":
+			pages = append(pages, object)
+		}
+	}
+
+	for _, object := range f.sortedObjects() {
+		match := pdfRootPattern.FindStringSubmatch(object.dict)
+		if match == nil {
+			continue
+		}
+		root, _ := strconv.Atoi(match[1])
+		if catalog, ok := f.objects[root]; ok {
+			for _, ref := range f.refs(f.rawEntry(catalog.dict, "Pages")) {
+				walk(ref)
+			}
+		}
+		if len(pages) > 0 {
+			return pages
+		}
+	}
+	// The trailer isn't an object in classic files, look for the catalog itself
+	for _, object := range f.sortedObjects() {
+		if f.typeOf(object) == "Catalog" {
+			for _, ref := range f.refs(f.rawEntry(object.dict, "Pages")) {
+				walk(ref)
+			}
+		}
+	}
+	if len(pages) > 0 {
+		return pages
+	}
+
+	for _, object := range f.sortedObjects() {
+		if f.typeOf(object) == "Page" {
+			pages = append(pages, object)
+		}
+	}
+	return pages
+}
+
+// typeOf returns the /Type of an object
+func (f *pdfFile) typeOf(object pdfObject) string {
+	if match := pdfTypePattern.FindStringSubmatch(object.dict); match != nil {
+		return match[1]
+	}
+	return ""
+}
+
+// entry returns the raw value of a top-level dictionary key, resolving it when it's
+// an indirect reference to a non-stream object
+func (f *pdfFile) entry(dict, key string) string {
+	i := f.keyIndex(dict, key)
+	if i < 0 {
+		return ""
+	}
+	value := strings.TrimLeft(dict[i:], " \t\r\n")
+
+	var raw string
+	switch {
+	case strings.HasPrefix(value, "<<"):
+		raw = value[:matchingDelimiter(value, "<<", ">>")]
+	case strings.HasPrefix(value, "["):
+		raw = value[:matchingDelimiter(value, "[", "]")]
+	default:
+		if match := pdfRefPattern.FindStringSubmatchIndex(value); match != nil && match[0] == 0 {
+			number, _ := strconv.Atoi(value[match[2]:match[3]])
+			if object, ok := f.objects[number]; ok && object.stream == nil {
+				return object.dict
+			}
+			return value[:match[1]]
+		}
+		end := strings.IndexAny(value, "/>]\r\n")
+		if end < 0 {
+			end = len(value)
+		}
+		raw = value[:end]
+	}
+	return raw
+}
+
+// keyIndex returns the offset just past /key in the top level of dict, or -1
+func (f *pdfFile) keyIndex(dict, key string) int {
+	needle := "/" + key
+	depth := 0
+	for i := 0; i < len(dict); i++ {
+		switch {
+		case strings.HasPrefix(dict[i:], "<<"):
+			depth++
+			i++
+		case strings.HasPrefix(dict[i:], ">>"):
+			depth--
+			i++
+		case dict[i] == '(':
+			i += len(readLiteralString(dict[i:])) - 1
+		case depth == 1 && strings.HasPrefix(dict[i:], needle):
+			end := i + len(needle)
+			if end == len(dict) || !isPDFNameChar(dict[end]) {
+				return end
+			}
+		}
+	}
+	return -1
+}
+
+// isPDFNameChar reports whether c can continue a PDF name
+func isPDFNameChar(c byte) bool {
+	return !strings.ContainsRune(" \t\r\n/<>[]()%{}", rune(c))
+}
+
+// matchingDelimiter returns the offset just past the delimiter closing the one at
+// the start of s
+func matchingDelimiter(s, open, close string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], open):
+			depth++
+			i += len(open) - 1
+		case strings.HasPrefix(s[i:], close):
+			depth--
+			i += len(close) - 1
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(s)
+}
+
+// refs returns the object numbers referenced in a value
+func (f *pdfFile) refs(value string) []int {
+	var numbers []int
+	for _, match := range pdfRefPattern.FindAllStringSubmatch(value, -1) {
+		number, _ := strconv.Atoi(match[1])
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+// intValue returns an integer dictionary entry, or 0
+func (f *pdfFile) intValue(dict, key string) int {
+	match := pdfIntegerPattern.FindStringSubmatch(f.entry(dict, key))
+	if match == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(match[1])
+	return n
+}
+
+// decode returns the decoded data of a stream object
+func (f *pdfFile) decode(object pdfObject) ([]byte, error) {
+	match := pdfFilterPattern.FindStringSubmatch(object.dict)
+	if match == nil {
+		return object.stream, nil
+	}
+	switch match[1] {
+	case "FlateDecode", "Fl":
+		reader, err := zlib.NewReader(bytes.NewReader(object.stream))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPDFUnreadable, err)
+		}
+		defer reader.Close()
+		var limited io.Reader = reader
+		if f.maxDecoded > 0 {
+			// Read one byte past the limit to tell reaching it from exceeding it
+			limited = io.LimitReader(reader, f.maxDecoded-f.decoded+1)
+		}
+		// Truncated streams are common, keep what decoded
+		data, err := io.ReadAll(limited)
+		if len(data) == 0 && err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrPDFUnreadable, err)
+		}
+		f.decoded += int64(len(data))
+		if f.maxDecoded > 0 && f.decoded > f.maxDecoded {
+			return nil, fmt.Errorf("%w of %d bytes", ErrPDFTooLarge, f.maxDecoded)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported filter %s", ErrPDFUnreadable, match[1])
+	}
+}
+
+// pageText extracts the text of a page, returning an empty string when the page has
+// no readable text layer. Content streams that don't decode are skipped; only
+// ErrPDFTooLarge is returned.
+func (f *pdfFile) pageText(page pdfObject) (string, error) {
+	var content bytes.Buffer
+	for _, number := range f.refs(f.rawEntry(page.dict, "Contents")) {
+		object, ok := f.objects[number]
+		if !ok {
+			continue
+		}
+		// A contents array may itself be an indirect object
+		if object.stream == nil {
+			for _, inner := range f.refs(object.dict) {
+				if err := f.appendDecoded(&content, f.objects[inner]); err != nil {
+					return "", err
+				}
+			}
+			continue
+		}
+		if err := f.appendDecoded(&content, object); err != nil {
+			return "", err
+		}
+	}
+
+	fonts, err := f.pageFonts(page)
+	if err != nil {
+		return "", err
+	}
+	text := extractContentText(content.Bytes(), fonts)
+	if !isReadableText(text) {
+		return "", nil
+	}
+	return text, nil
+}
+
+// appendDecoded appends the decoded data of a content stream and a line break to
+// content, skipping a stream that doesn't decode unless it exceeds the size limit
+func (f *pdfFile) appendDecoded(content *bytes.Buffer, object pdfObject) error {
+	data, err := f.decode(object)
+	if errors.Is(err, ErrPDFTooLarge) {
+		return err
+	}
+	if err == nil {
+		content.Write(data)
+		content.WriteByte('\n')
+	}
+	return nil
+}
+
+// inheritedEntry returns the entry of a page, or of the nearest page tree node above
+// it that has the key, for the attributes pages inherit such as /Resources
+func (f *pdfFile) inheritedEntry(page pdfObject, key string) string {
+	object := page
+	// Bound the walk up the tree, parents may form a cycle in damaged files
+	for range 32 {
+		if value := f.entry(object.dict, key); value != "" {
+			return value
+		}
+		parents := f.refs(f.rawEntry(object.dict, "Parent"))
+		if len(parents) == 0 {
+			return ""
+		}
+		var ok bool
+		if object, ok = f.objects[parents[0]]; !ok {
+			return ""
+		}
+	}
+	return ""
+}
+
+// name returns a name entry of a dictionary without its slash, or ""
+func (f *pdfFile) name(dict, key string) string {
+	i := f.keyIndex(dict, key)
+	if i < 0 {
+		return ""
+	}
+	value := strings.TrimLeft(dict[i:], " \t\r\n")
+	if !strings.HasPrefix(value, "/") {
+		return ""
+	}
+	end := 1
+	for end < len(value) && isPDFNameChar(value[end]) {
+		end++
+	}
+	return value[1:end]
+}
+
+// rawEntry returns the raw value of a dictionary key without resolving references
+func (f *pdfFile) rawEntry(dict, key string) string {
+	i := f.keyIndex(dict, key)
+	if i < 0 {
+		return ""
+	}
+	value := strings.TrimLeft(dict[i:], " \t\r\n")
+	if strings.HasPrefix(value, "[") {
+		return value[:matchingDelimiter(value, "[", "]")]
+	}
+	if match := pdfRefPattern.FindStringIndex(value); match != nil && match[0] == 0 {
+		return value[:match[1]]
+	}
+	return ""
+}
+
+// pageImage returns the largest JPEG image drawn on a page, the usual shape of a
+// scanned page, or nil if there is none
+func (f *pdfFile) pageImage(page pdfObject) []byte {
+	resources := f.inheritedEntry(page, "Resources")
+	xobjects := f.entry(resources, "XObject")
+
+	var best []byte
+	bestArea := 0
+	for _, number := range f.refs(xobjects) {
+		object, ok := f.objects[number]
+		if !ok || object.stream == nil {
+			continue
+		}
+		subtype := pdfSubtypePattern.FindStringSubmatch(object.dict)
+		filter := pdfFilterPattern.FindStringSubmatch(object.dict)
+		if subtype == nil || subtype[1] != "Image" || filter == nil || (filter[1] != "DCTDecode" && filter[1] != "DCT") {
+			continue
+		}
+		area := 1
+		for _, dimension := range pdfDimensionPattern.FindAllStringSubmatch(object.dict, 2) {
+			n, _ := strconv.Atoi(dimension[2])
+			area *= n
+		}
+		if area > bestArea {
+			best, bestArea = object.stream, area
+		}
+	}
+	return best
+}
+
+// contentOperand is an operand of a content stream operator
+type contentOperand struct {
+	value string
+	text  bool // A string, decoded to text, rather than a number, name or array start
+}
+
+// extractContentText extracts the text shown by a page content stream, decoding
+// strings with the page's fonts by resource name
+func extractContentText(content []byte, fonts map[string]*pdfFont) string {
+	var text strings.Builder
+	var operands []contentOperand
+	var font *pdfFont
+	newline := func() {
+		if text.Len() > 0 && !strings.HasSuffix(text.String(), "\n") {
+			text.WriteByte('\n')
+		}
+	}
+
+	s := string(content)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '(':
+			literal := readLiteralString(s[i:])
+			operands = append(operands, contentOperand{value: font.decode(unescapeLiteralString(literal)), text: true})
+			i += len(literal)
+		case c == '<' && !strings.HasPrefix(s[i:], "<<"):
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				end = len(s) - i - 1
+			}
+			operands = append(operands, contentOperand{value: font.decode(decodeHexString(s[i+1 : i+end])), text: true})
+			i += end + 1
+		case c == '[':
+			operands = append(operands, contentOperand{value: "["})
+			i++
+		case c == ']':
+			// Collapse the array into one operand, with large kerning gaps as spaces
+			var array strings.Builder
+			j := len(operands) - 1
+			for j >= 0 && (operands[j].text || operands[j].value != "[") {
+				j--
+			}
+			if j >= 0 {
+				for _, operand := range operands[j+1:] {
+					if !operand.text {
+						if n, err := strconv.ParseFloat(operand.value, 64); err == nil && n < -200 {
+							array.WriteByte(' ')
+						}
+						continue
+					}
+					array.WriteString(operand.value)
+				}
+				operands = append(operands[:j], contentOperand{value: array.String(), text: true})
+			}
+			i++
+		case c == '%':
+			for i < len(s) && s[i] != '\n' && s[i] != '\r' {
+				i++
+			}
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0:
+			i++
+		default:
+			start := i
+			for i < len(s) && !strings.ContainsRune(" \t\r\n\f\x00()<>[]/%", rune(s[i])) {
+				i++
+			}
+			if i == start {
+				// A name, skip its slash and read it as an operand
+				i++
+				for i < len(s) && !strings.ContainsRune(" \t\r\n\f\x00()<>[]/%", rune(s[i])) {
+					i++
+				}
+				operands = append(operands, contentOperand{value: s[start:i]})
+				continue
+			}
+			token := s[start:i]
+			if _, err := strconv.ParseFloat(token, 64); err == nil {
+				operands = append(operands, contentOperand{value: token})
+				continue
+			}
+
+			var last contentOperand
+			if len(operands) > 0 {
+				last = operands[len(operands)-1]
+			}
+			switch token {
+			case "Tj", "TJ":
+				if last.text {
+					text.WriteString(last.value)
+				}
+			case "'", "\"":
+				newline()
+				if last.text {
+					text.WriteString(last.value)
+				}
+			case "T*", "ET":
+				newline()
+			case "Tf":
+				if len(operands) >= 2 {
+					font = fonts[strings.TrimPrefix(operands[len(operands)-2].value, "/")]
+				}
+			case "Td", "TD":
+				if len(operands) >= 2 {
+					if ty, err := strconv.ParseFloat(last.value, 64); err == nil && ty != 0 {
+						newline()
+					} else if tx, err := strconv.ParseFloat(operands[len(operands)-2].value, 64); err == nil && tx > 0 && text.Len() > 0 {
+						text.WriteByte(' ')
+					}
+				}
+			case "BI":
+				// Skip inline image data
+				if end := strings.Index(s[i:], "EI"); end >= 0 {
+					i += end + 2
+				}
+			}
+			operands = operands[:0]
+		}
+	}
+
+	lines := strings.Split(text.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// readLiteralString returns the literal string starting at s[0] == '(', including
+// its balanced parentheses
+func readLiteralString(s string) string {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return s[:i+1]
+			}
+		}
+	}
+	return s
+}
+
+// unescapeLiteralString decodes the escapes of a literal string including its
+// parentheses
+func unescapeLiteralString(literal string) []byte {
+	literal = strings.TrimPrefix(literal, "(")
+	literal = strings.TrimSuffix(literal, ")")
+
+	var out []byte
+	for i := 0; i < len(literal); i++ {
+		c := literal[i]
+		if c != '\\' || i+1 == len(literal) {
+			out = append(out, c)
+			continue
+		}
+		i++
+		switch e := literal[i]; e {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n':
+			// Line continuation
+			if e == '\r' && i+1 < len(literal) && literal[i+1] == '\n' {
+				i++
+			}
+		default:
+			if e >= '0' && e <= '7' {
+				n, j := 0, i
+				for ; j < len(literal) && j < i+3 && literal[j] >= '0' && literal[j] <= '7'; j++ {
+					n = n*8 + int(literal[j]-'0')
+				}
+				out = append(out, byte(n))
+				i = j - 1
+				continue
+			}
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// decodeHexString decodes the body of a hex string, ignoring whitespace
+func decodeHexString(hex string) []byte {
+	digits := make([]byte, 0, len(hex))
+	for i := 0; i < len(hex); i++ {
+		if c := hex[i]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		n, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		out[i] = byte(n)
+	}
+	return out
+}
+
+// decodePDFString decodes a text string, which is UTF-16BE when it starts with a
+// byte order mark and single-byte (approximated as Latin-1) otherwise
+func decodePDFString(data []byte) string {
+	if len(data) >= 2 && data[0] == 0xFE && data[1] == 0xFF {
+		return string(utf16.Decode(utf16Units(data[2:])))
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// isReadableText reports whether extracted text looks like real text rather than
+// glyph IDs from fonts without a usable encoding
+func isReadableText(text string) bool {
+	total, readable := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r) {
+			readable++
+		}
+	}
+	return total > 0 && float64(readable)/float64(total) >= 0.8
+}
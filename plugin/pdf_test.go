@@ -0,0 +1,290 @@
+package plugin
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// buildPDF assembles a PDF file from object bodies numbered from 1, followed by a
+// cross-reference table and a trailer naming object 1 as the catalog. Empty bodies
+// leave their object number free. trailer adds entries to the trailer dictionary.
+func buildPDF(trailer string, objects ...string) []byte {
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		if body != "" {
+			offsets[i] = out.Len()
+			fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, body)
+		}
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		if offset == 0 {
+			out.WriteString("0000000000 65535 f \n")
+			continue
+		}
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R %s>>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, trailer, xref)
+	return out.Bytes()
+}
+
+// pdfStream returns the body of a stream object holding data
+func pdfStream(dict string, data []byte) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+// flate compresses data with the FlateDecode filter
+func flate(data []byte) []byte {
+	var out bytes.Buffer
+	w := zlib.NewWriter(&out)
+	w.Write(data)
+	w.Close()
+	return out.Bytes()
+}
+
+// Objects 1 to 3 of the fixtures: the catalog, the page tree and a page showing the
+// content stream in object 4 with the font in object 5 as /F1
+const (
+	pdfCatalog   = "<< /Type /Catalog /Pages 2 0 R >>"
+	pdfPageTree  = "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	pdfPage      = "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>"
+	pdfType1Font = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+)
+
+// simplePDF returns a one-page PDF showing content with a standard font
+func simplePDF(content string) []byte {
+	return buildPDF("", pdfCatalog, pdfPageTree, pdfPage, pdfStream("", []byte(content)), pdfType1Font)
+}
+
+// cidFontPDF returns a one-page PDF showing content with an embedded subset font
+// using Identity-H, whose ToUnicode CMap in object 6 maps its glyph IDs to text when
+// cmap is set
+func cidFontPDF(content, cmap string) []byte {
+	font := "<< /Type /Font /Subtype /Type0 /BaseFont /ABCDEF+NotoSans /Encoding /Identity-H /DescendantFonts [7 0 R]"
+	if cmap != "" {
+		font += " /ToUnicode 6 0 R"
+	}
+	return buildPDF("",
+		pdfCatalog, pdfPageTree, pdfPage,
+		pdfStream("/Filter /FlateDecode", flate([]byte(content))),
+		font+" >>",
+		pdfStream("/Filter /FlateDecode", flate([]byte(cmap))),
+		"<< /Type /Font /Subtype /CIDFontType2 /BaseFont /ABCDEF+NotoSans /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> >>",
+	)
+}
+
+// notoToUnicode maps the glyph IDs of the subset font of cidFontPDF, with single
+// codes, a range counting up from a base and a range listing its texts
+const notoToUnicode = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+/CMapName /Adobe-Identity-UCS def
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+3 beginbfchar
+<0001> <0048>
+<0005> <00660069>
+<0006> <0020>
+endbfchar
+2 beginbfrange
+<0002> <0002> <0065>
+<0003> <0004> [<006C> <006F>]
+<0007> <0008> <006E>
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+// objectStreamPDF returns a PDF whose catalog, page tree and page are packed into a
+// compressed object stream, followed in its header by entries with bad offsets
+func objectStreamPDF(badEntries string) []byte {
+	var header, body strings.Builder
+	for i, object := range []string{pdfCatalog, pdfPageTree, pdfPage} {
+		fmt.Fprintf(&header, "%d %d ", i+1, body.Len())
+		body.WriteString(object + "\n")
+	}
+	header.WriteString(badEntries)
+	count := strings.Count(header.String(), " ") / 2
+	data := header.String() + "\n" + body.String()
+	return buildPDF("",
+		"", "", "",
+		pdfStream("", []byte("BT /F1 12 Tf 72 700 Td (Packed objects) Tj ET")),
+		pdfType1Font,
+		pdfStream(fmt.Sprintf("/Type /ObjStm /N %d /First %d /Filter /FlateDecode", count, len(header.String())+1), flate([]byte(data))),
+	)
+}
+
+// xrefOffsetPattern matches the entries of the cross-reference table
+var xrefOffsetPattern = regexp.MustCompile(`\d{10} 00000 n|startxref\n\d+`)
+
+// breakXref points every cross-reference entry and startxref past the end of the
+// file, as in files edited without updating the table
+func breakXref(data []byte) []byte {
+	return xrefOffsetPattern.ReplaceAllFunc(data, func(entry []byte) []byte {
+		if bytes.HasPrefix(entry, []byte("startxref")) {
+			return []byte("startxref\n99999999")
+		}
+		return []byte("9999999999 00000 n")
+	})
+}
+
+// pdfFixtures are the PDF files the extraction tests and the fuzz seeds use
+var pdfFixtures = []struct {
+	name     string
+	data     []byte
+	wantText string
+	wantErr  error
+}{
+	{
+		name:     "literal strings",
+		data:     simplePDF("BT /F1 12 Tf 72 700 Td (Hello, \\(PDF\\) world) Tj 0 -14 Td (Second line) Tj ET"),
+		wantText: "Hello, (PDF) world\nSecond line",
+	},
+	{
+		name:     "kerned array with digits",
+		data:     simplePDF("BT /F1 12 Tf [(Page) -300 (12) 20 (3)] TJ ET"),
+		wantText: "Page 123",
+	},
+	{
+		name:     "UTF-16BE hex string",
+		data:     simplePDF("BT /F1 12 Tf <FEFF00C5006E006700730074007200F6006D> Tj ET"),
+		wantText: "Ångström",
+	},
+	{
+		name:     "UTF-16BE literal string",
+		data:     simplePDF("BT /F1 12 Tf (\\376\\377\\000C\\000a\\000f\\000\\351) Tj ET"),
+		wantText: "Café",
+	},
+	{
+		name:     "CID font with ToUnicode CMap",
+		data:     cidFontPDF("BT /F1 12 Tf <000100020003000300040006000500070002> Tj ET", notoToUnicode),
+		wantText: "Hello fine",
+	},
+	{
+		name:    "CID font without ToUnicode CMap",
+		data:    cidFontPDF("BT /F1 12 Tf <000100020003000300040006000500070002> Tj ET", ""),
+		wantErr: ErrPDFNoText,
+	},
+	{
+		name: "resources inherited from the page tree",
+		data: buildPDF("",
+			pdfCatalog,
+			"<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 5 0 R >> >> >>",
+			"<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+			pdfStream("", []byte("BT /F1 12 Tf <0001000200030003> Tj ET")),
+			"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 6 0 R >>",
+			pdfStream("", []byte(notoToUnicode)),
+		),
+		wantText: "Hell",
+	},
+	{
+		name:     "broken cross-reference table",
+		data:     breakXref(simplePDF("BT /F1 12 Tf (Still readable) Tj ET")),
+		wantText: "Still readable",
+	},
+	{
+		name: "wrong stream length",
+		data: buildPDF("", pdfCatalog, pdfPageTree, pdfPage,
+			"<< /Length 99999 >>\nstream\nBT /F1 12 Tf (Length is wrong) Tj ET\nendstream",
+			pdfType1Font),
+		wantText: "Length is wrong",
+	},
+	{
+		name:     "object stream with bad offsets",
+		data:     objectStreamPDF("9 -5 10 999999 11 x"),
+		wantText: "Packed objects",
+	},
+	{
+		name: "object stream with /First past its data",
+		data: buildPDF("",
+			"",
+			pdfStream("/Type /ObjStm /N 1 /First 99999 /Filter /FlateDecode", flate([]byte("2 0 << /Type /Catalog >>"))),
+		),
+		wantErr: ErrPDFUnreadable,
+	},
+	{
+		name: "FlateDecode bomb in a content stream",
+		data: buildPDF("", pdfCatalog, pdfPageTree, pdfPage,
+			pdfStream("/Filter /FlateDecode", flate(bytes.Repeat([]byte(" "), 4<<20))),
+			pdfType1Font),
+		wantErr: ErrPDFTooLarge,
+	},
+	{
+		name: "FlateDecode bomb in an object stream",
+		data: buildPDF("",
+			pdfStream("/Type /ObjStm /N 1 /First 4 /Filter /FlateDecode", flate(bytes.Repeat([]byte("0 0 "), 1<<20)))),
+		wantErr: ErrPDFTooLarge,
+	},
+	{
+		name:    "encrypted",
+		data:    buildPDF("/Encrypt 6 0 R /ID [<01> <01>] ", pdfCatalog, pdfPageTree, pdfPage, pdfStream("", []byte("BT (x) Tj ET")), pdfType1Font, "<< /Filter /Standard /V 2 /R 3 >>"),
+		wantErr: ErrPDFEncrypted,
+	},
+	{
+		name: "encrypted with a cross-reference stream",
+		data: buildPDF("", pdfCatalog, pdfPageTree, pdfPage, pdfStream("", []byte("BT (x) Tj ET")), pdfType1Font,
+			pdfStream("/Type /XRef /Size 7 /Root 1 0 R /Encrypt << /Filter /Standard /V 2 /R 3 >> /W [1 2 1]", []byte{})),
+		wantErr: ErrPDFEncrypted,
+	},
+	{
+		name:     "/Encrypt shown as text",
+		data:     simplePDF("BT /F1 12 Tf (trailer << /Encrypt 6 0 R >>) Tj ET"),
+		wantText: "trailer << /Encrypt 6 0 R >>",
+	},
+	{
+		name:    "missing header",
+		data:    []byte("1 0 obj << /Type /Catalog >> endobj"),
+		wantErr: ErrPDFUnreadable,
+	},
+}
+
+func TestExtractPDFPages(t *testing.T) {
+	config := DefaultConfig()
+	config.PDF.MaxBytes = 1 << 20
+	p := &AgenticRAGProcessor{config: config}
+	for _, tt := range pdfFixtures {
+		t.Run(tt.name, func(t *testing.T) {
+			pages, err := p.extractPDFPages(context.Background(), tt.data)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("extractPDFPages returned %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractPDFPages: %v", err)
+			}
+			if len(pages) != 1 || pages[0].Content != tt.wantText {
+				t.Errorf("pages = %+v, want one page reading %q", pages, tt.wantText)
+			}
+		})
+	}
+}
+
+// FuzzParsePDF checks that no input crashes the parser or text extraction
+func FuzzParsePDF(f *testing.F) {
+	for _, fixture := range pdfFixtures {
+		f.Add(fixture.data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := parsePDF(data, 1<<20)
+		if err != nil {
+			return
+		}
+		for _, page := range file.pages {
+			file.pageText(page)
+			file.pageImage(page)
+		}
+	})
+}
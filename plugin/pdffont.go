@@ -0,0 +1,254 @@
+package plugin
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// pdfFont decodes the strings shown with a font into text
+type pdfFont struct {
+	composite bool     // Type0 font, whose codes are two bytes (Identity-H) unless its CMap says otherwise
+	toUnicode *pdfCMap // Code to text mapping of the font, nil if it has none
+}
+
+// decode returns the text of a string shown with the font. Strings shown with an
+// unknown font or a simple font without a ToUnicode CMap are decoded as text strings.
+// Codes of composite fonts without a mapping are glyph IDs and decode to U+FFFD.
+func (font *pdfFont) decode(data []byte) string {
+	if font == nil || (!font.composite && font.toUnicode == nil) {
+		return decodePDFString(data)
+	}
+
+	var text strings.Builder
+	for i := 0; i < len(data); {
+		n := font.codeLength(data[i:])
+		code := data[i : i+n]
+		i += n
+		if s, ok := font.toUnicode.lookup(code); ok {
+			text.WriteString(s)
+		} else if font.composite {
+			text.WriteRune(unicode.ReplacementChar)
+		} else {
+			text.WriteRune(rune(code[0]))
+		}
+	}
+	return text.String()
+}
+
+// codeLength returns the length of the character code at the start of data
+func (font *pdfFont) codeLength(data []byte) int {
+	n := 1
+	if font.composite {
+		n = 2
+	}
+	if font.toUnicode != nil {
+		if length := font.toUnicode.codeLength(data); length > 0 {
+			n = length
+		}
+	}
+	return min(n, len(data))
+}
+
+// pdfCodeRange is a range of character codes of a CMap, all of the same length
+type pdfCodeRange struct {
+	low, high []byte
+
+	base  []uint16 // UTF-16 text of the low code, the last unit counting up across the range
+	texts []string // Text of each code in the range, instead of base
+}
+
+// contains reports whether the range includes code
+func (r pdfCodeRange) contains(code []byte) bool {
+	return len(code) == len(r.low) && bytes.Compare(r.low, code) <= 0 && bytes.Compare(code, r.high) <= 0
+}
+
+// pdfCMap is a parsed ToUnicode CMap
+type pdfCMap struct {
+	codespace []pdfCodeRange    // Valid code ranges, telling the length of each code
+	chars     map[string]string // Text of single codes from bfchar
+	ranges    []pdfCodeRange    // Text of code ranges from bfrange
+}
+
+// codeLength returns the length of the codespace range matching the start of data,
+// or 0 if none does. Codespace ranges bound each byte of the code separately.
+func (cmap *pdfCMap) codeLength(data []byte) int {
+	for _, r := range cmap.codespace {
+		n := len(r.low)
+		if n > len(data) {
+			continue
+		}
+		matches := true
+		for i := range n {
+			if data[i] < r.low[i] || data[i] > r.high[i] {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return n
+		}
+	}
+	return 0
+}
+
+// lookup returns the text of a code
+func (cmap *pdfCMap) lookup(code []byte) (string, bool) {
+	if cmap == nil {
+		return "", false
+	}
+	if s, ok := cmap.chars[string(code)]; ok {
+		return s, true
+	}
+	for _, r := range cmap.ranges {
+		if !r.contains(code) || len(code) > 4 {
+			continue
+		}
+		offset := int(codeValue(code) - codeValue(r.low))
+		if r.texts != nil {
+			if offset < len(r.texts) {
+				return r.texts[offset], true
+			}
+			return "", false
+		}
+		units := append([]uint16(nil), r.base...)
+		units[len(units)-1] += uint16(offset)
+		return string(utf16.Decode(units)), true
+	}
+	return "", false
+}
+
+// codeValue returns a code of up to four bytes as a big-endian number
+func codeValue(code []byte) uint32 {
+	var n uint32
+	for _, b := range code {
+		n = n<<8 | uint32(b)
+	}
+	return n
+}
+
+// pdfCMapTokenPattern matches the tokens of a CMap: hex strings, array brackets and
+// keywords. Names, numbers and dictionaries of the CMap header are matched as
+// keywords and ignored.
+var pdfCMapTokenPattern = regexp.MustCompile(`<[0-9A-Fa-f\s]*>|\[|\]|[^\s<>\[\]]+`)
+
+// parseCMap parses the codespace ranges and the bfchar and bfrange mappings of a
+// ToUnicode CMap. Malformed entries are skipped.
+func parseCMap(data []byte) *pdfCMap {
+	cmap := &pdfCMap{chars: make(map[string]string)}
+	tokens := pdfCMapTokenPattern.FindAllString(string(data), -1)
+	hex := func(i int) ([]byte, bool) {
+		if i >= len(tokens) || !strings.HasPrefix(tokens[i], "<") {
+			return nil, false
+		}
+		return decodeHexString(tokens[i][1 : len(tokens[i])-1]), true
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "begincodespacerange":
+			for i++; ; i += 2 {
+				low, ok1 := hex(i)
+				high, ok2 := hex(i + 1)
+				if !ok1 || !ok2 {
+					break
+				}
+				if len(low) == len(high) && len(low) > 0 {
+					cmap.codespace = append(cmap.codespace, pdfCodeRange{low: low, high: high})
+				}
+			}
+		case "beginbfchar":
+			for i++; ; i += 2 {
+				code, ok1 := hex(i)
+				text, ok2 := hex(i + 1)
+				if !ok1 || !ok2 {
+					break
+				}
+				cmap.chars[string(code)] = string(utf16.Decode(utf16Units(text)))
+			}
+		case "beginbfrange":
+			for i++; ; {
+				low, ok1 := hex(i)
+				high, ok2 := hex(i + 1)
+				if !ok1 || !ok2 {
+					break
+				}
+				r := pdfCodeRange{low: low, high: high}
+				if base, ok := hex(i + 2); ok {
+					r.base = utf16Units(base)
+					i += 3
+				} else if i+2 < len(tokens) && tokens[i+2] == "[" {
+					r.texts = []string{}
+					for i += 3; i < len(tokens) && tokens[i] != "]"; i++ {
+						if text, ok := hex(i); ok {
+							r.texts = append(r.texts, string(utf16.Decode(utf16Units(text))))
+						}
+					}
+					i++
+				} else {
+					break
+				}
+				if len(low) == len(high) && len(low) > 0 && (len(r.base) > 0 || r.texts != nil) {
+					cmap.ranges = append(cmap.ranges, r)
+				}
+			}
+		}
+	}
+	return cmap
+}
+
+// utf16Units returns big-endian bytes as UTF-16 code units
+func utf16Units(data []byte) []uint16 {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+	}
+	return units
+}
+
+// pdfFontRefPattern matches a font resource name and the font object it refers to
+var pdfFontRefPattern = regexp.MustCompile(`/([^\s/<>\[\]()%{}]+)\s+(\d+)\s+\d+\s+R\b`)
+
+// pageFonts returns the fonts of a page by resource name. Only ErrPDFTooLarge is
+// returned; fonts whose CMap doesn't decode have no mapping.
+func (f *pdfFile) pageFonts(page pdfObject) (map[string]*pdfFont, error) {
+	resources := f.inheritedEntry(page, "Resources")
+	fonts := make(map[string]*pdfFont)
+	for _, match := range pdfFontRefPattern.FindAllStringSubmatch(f.entry(resources, "Font"), -1) {
+		number, _ := strconv.Atoi(match[2])
+		font, err := f.font(number)
+		if err != nil {
+			return nil, err
+		}
+		fonts[match[1]] = font
+	}
+	return fonts, nil
+}
+
+// font returns the font defined by an object, loading it on first use
+func (f *pdfFile) font(number int) (*pdfFont, error) {
+	if font, ok := f.fonts[number]; ok {
+		return font, nil
+	}
+	object := f.objects[number]
+	font := &pdfFont{composite: f.name(object.dict, "Subtype") == "Type0"}
+	for _, ref := range f.refs(f.rawEntry(object.dict, "ToUnicode")) {
+		stream, ok := f.objects[ref]
+		if !ok || stream.stream == nil {
+			continue
+		}
+		data, err := f.decode(stream)
+		if errors.Is(err, ErrPDFTooLarge) {
+			return nil, err
+		}
+		if err == nil {
+			font.toUnicode = parseCMap(data)
+		}
+	}
+	f.fonts[number] = font
+	return font, nil
+}
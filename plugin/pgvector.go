@@ -0,0 +1,417 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// PgVectorMetric is the similarity a PgVectorStore searches by
+type PgVectorMetric string
+
+const (
+	PgVectorCosine       PgVectorMetric = "cosine"        // Cosine similarity, for embeddings of any length
+	PgVectorInnerProduct PgVectorMetric = "inner_product" // Inner product, faster for normalized embeddings
+)
+
+// pgVectorTableName matches the table names, optionally schema qualified, that
+// PgVectorStore accepts, as the name is spliced into its statements
+var pgVectorTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// PgVectorOptions configures a PgVectorStore
+type PgVectorOptions struct {
+	Table      string         // Table of the chunks (default: rag_chunks)
+	Dimensions int            // Length of the embedder's vectors, required to create the table
+	Metric     PgVectorMetric // Similarity searched by (default: cosine)
+	BatchSize  int            // Chunks embedded and upserted per statement (default: 100)
+}
+
+// PgVectorStore is an Indexer and Retriever in a Postgres database with the pgvector
+// extension. It works with any database/sql Postgres driver, which the caller
+// registers and opens, such as github.com/jackc/pgx/v5/stdlib, whose OpenDBFromPool
+// wraps an existing pgx pool, or github.com/lib/pq.
+//
+// Chunks are keyed on their document's ID and the hash of their content, so indexing
+// a document again only embeds its new chunks and drops the ones it no longer has.
+// Document metadata is stored as JSONB, and document filters are translated to
+// conditions on it.
+type PgVectorStore struct {
+	db       *sql.DB
+	embedder ai.Embedder
+	options  PgVectorOptions
+}
+
+// NewPgVectorStore creates the pgvector extension, the chunk table and its indexes in
+// db if they don't exist yet. The embedder embeds both chunks and queries.
+func NewPgVectorStore(ctx context.Context, db *sql.DB, embedder ai.Embedder, options PgVectorOptions) (*PgVectorStore, error) {
+	if options.Table == "" {
+		options.Table = "rag_chunks"
+	}
+	if options.Metric == "" {
+		options.Metric = PgVectorCosine
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 100
+	}
+	switch {
+	case !pgVectorTableName.MatchString(options.Table):
+		return nil, fmt.Errorf("invalid pgvector table name %q", options.Table)
+	case options.Dimensions <= 0:
+		return nil, fmt.Errorf("pgvector dimensions must be greater than 0, got %d", options.Dimensions)
+	case options.Metric != PgVectorCosine && options.Metric != PgVectorInnerProduct:
+		return nil, fmt.Errorf("unknown pgvector metric %q", options.Metric)
+	case embedder == nil:
+		return nil, errors.New("pgvector store requires an embedder")
+	}
+
+	s := &PgVectorStore{db: db, embedder: embedder, options: options}
+	if err := s.CreateSchema(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// CreateSchema creates the pgvector extension, the chunk table and its indexes if they
+// don't exist yet. Creating the extension requires the privilege to, unless it is
+// already installed.
+func (s *PgVectorStore) CreateSchema(ctx context.Context) error {
+	table := s.options.Table
+	index := strings.ReplaceAll(table, ".", "_")
+	ops := "vector_cosine_ops"
+	if s.options.Metric == PgVectorInnerProduct {
+		ops = "vector_ip_ops"
+	}
+	for _, statement := range []string{
+		`CREATE EXTENSION IF NOT EXISTS vector`,
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	document_id   TEXT NOT NULL,
+	chunk_hash    TEXT NOT NULL,
+	chunk_id      TEXT NOT NULL,
+	chunk_index   INTEGER NOT NULL,
+	content       TEXT NOT NULL,
+	title         TEXT,
+	source        TEXT,
+	metadata      JSONB,
+	embedding     vector(%d),
+	document_hash TEXT,
+	PRIMARY KEY (document_id, chunk_hash)
+)`, table, s.options.Dimensions),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_embedding ON %s USING hnsw (embedding %s)`, index, table, ops),
+		fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s_metadata ON %s USING gin (metadata)`, index, table),
+	} {
+		if _, err := s.db.ExecContext(ctx, statement); err != nil {
+			return fmt.Errorf("failed to create pgvector schema: %w", err)
+		}
+	}
+	return nil
+}
+
+// pgVectorRow is a chunk to upsert, with its embedding if the table lacks it
+type pgVectorRow struct {
+	chunk     DocumentChunk
+	hash      string
+	embedding []float32
+}
+
+// Index implements Indexer. The chunks of each document replace those stored for it,
+// embedding only the chunks whose content isn't stored yet.
+func (s *PgVectorStore) Index(ctx context.Context, documents []Document, chunks []DocumentChunk) error {
+	byID := make(map[string]Document, len(documents))
+	for _, doc := range documents {
+		byID[doc.ID] = doc
+	}
+	var order []string
+	grouped := make(map[string][]DocumentChunk)
+	for _, chunk := range chunks {
+		if _, ok := grouped[chunk.DocumentID]; !ok {
+			order = append(order, chunk.DocumentID)
+		}
+		grouped[chunk.DocumentID] = append(grouped[chunk.DocumentID], chunk)
+	}
+	for _, id := range order {
+		if err := s.indexDocument(ctx, byID[id], id, grouped[id]); err != nil {
+			return fmt.Errorf("failed to index document %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// indexDocument replaces the stored chunks of the document with its chunks
+func (s *PgVectorStore) indexDocument(ctx context.Context, doc Document, id string, chunks []DocumentChunk) error {
+	stored, err := s.storedHashes(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// Chunks of the same content share their key, so only the first is kept
+	rows := make([]pgVectorRow, 0, len(chunks))
+	hashes := make([]string, 0, len(chunks))
+	seen := make(map[string]bool, len(chunks))
+	var missing []int
+	for _, chunk := range chunks {
+		hash := contentHash(chunk.Content)
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if !stored[hash] {
+			missing = append(missing, len(rows))
+		}
+		rows = append(rows, pgVectorRow{chunk: chunk, hash: hash})
+		hashes = append(hashes, hash)
+	}
+	for start := 0; start < len(missing); start += s.options.BatchSize {
+		batch := missing[start:min(start+s.options.BatchSize, len(missing))]
+		texts := make([]string, len(batch))
+		for i, row := range batch {
+			texts[i] = rows[row].chunk.Content
+		}
+		embeddings, err := s.embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i, row := range batch {
+			rows[row].embedding = embeddings[i]
+		}
+	}
+
+	metadata, err := marshalColumn(doc.Metadata)
+	if err != nil {
+		return err
+	}
+	keep, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx,
+		fmt.Sprintf(`DELETE FROM %s WHERE document_id = $1 AND chunk_hash NOT IN (SELECT jsonb_array_elements_text($2::jsonb))`, s.options.Table),
+		id, string(keep)); err != nil {
+		return err
+	}
+	for start := 0; start < len(rows); start += s.options.BatchSize {
+		if err := s.upsert(ctx, tx, doc, id, metadata, rows[start:min(start+s.options.BatchSize, len(rows))]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storedHashes returns the content hashes of the chunks stored for the document
+func (s *PgVectorStore) storedHashes(ctx context.Context, id string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT chunk_hash FROM %s WHERE document_id = $1 AND embedding IS NOT NULL`, s.options.Table), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hashes := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes[hash] = true
+	}
+	return hashes, rows.Err()
+}
+
+// upsert writes the rows in one statement, keeping the stored embedding of rows
+// without one
+func (s *PgVectorStore) upsert(ctx context.Context, tx *sql.Tx, doc Document, id string, metadata sql.NullString, rows []pgVectorRow) error {
+	values := make([]string, 0, len(rows))
+	args := make([]any, 0, len(rows)*10)
+	hash := documentHash(doc)
+	for _, row := range rows {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d::jsonb, $%d::vector, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10))
+		var embedding sql.NullString
+		if row.embedding != nil {
+			embedding = sql.NullString{String: pgVectorLiteral(row.embedding), Valid: true}
+		}
+		args = append(args, id, row.hash, row.chunk.ID, row.chunk.ChunkIndex, row.chunk.Content, doc.Title, doc.Source, metadata, embedding, hash)
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s AS t (document_id, chunk_hash, chunk_id, chunk_index, content, title, source, metadata, embedding, document_hash)
+VALUES %[2]s
+ON CONFLICT (document_id, chunk_hash) DO UPDATE SET
+	chunk_id = EXCLUDED.chunk_id, chunk_index = EXCLUDED.chunk_index, content = EXCLUDED.content,
+	title = EXCLUDED.title, source = EXCLUDED.source, metadata = EXCLUDED.metadata, document_hash = EXCLUDED.document_hash,
+	embedding = COALESCE(EXCLUDED.embedding, t.embedding)`, s.options.Table, strings.Join(values, ", ")), args...)
+	return err
+}
+
+// DocumentHash implements Indexer
+func (s *PgVectorStore) DocumentHash(ctx context.Context, documentID string) (string, error) {
+	var hash sql.NullString
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT document_hash FROM %s WHERE document_id = $1 LIMIT 1`, s.options.Table), documentID).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return hash.String, err
+}
+
+// Delete implements Indexer
+func (s *PgVectorStore) Delete(ctx context.Context, documentID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE document_id = $1 RETURNING chunk_hash`, s.options.Table), documentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete document %s: %w", documentID, err)
+	}
+	defer rows.Close()
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+// Retrieve implements Retriever. Filters are applied in SQL where they translate,
+// and to the rows fetched otherwise.
+func (s *PgVectorStore) Retrieve(ctx context.Context, query string, k int, filter *DocumentFilter) ([]ScoredChunk, error) {
+	embeddings, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+
+	args := []any{pgVectorLiteral(embeddings[0])}
+	where, exact := "TRUE", true
+	if filter != nil {
+		where, exact = pgVectorFilter(*filter, &args)
+	}
+	limit := k
+	if !exact {
+		limit = k * retrievalOverfetch
+	}
+	distance, score := "embedding <=> $1::vector", "1 - (embedding <=> $1::vector)"
+	if s.options.Metric == PgVectorInnerProduct {
+		distance, score = "embedding <#> $1::vector", "-(embedding <#> $1::vector)"
+	}
+	args = append(args, limit)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(
+		`SELECT document_id, chunk_id, chunk_index, content, title, source, metadata, %s FROM %s WHERE embedding IS NOT NULL AND (%s) ORDER BY %s LIMIT $%d`,
+		score, s.options.Table, where, distance, len(args)), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search pgvector: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []ScoredChunk
+	for rows.Next() && len(chunks) < k {
+		var scored ScoredChunk
+		var title, source, metadata sql.NullString
+		if err := rows.Scan(&scored.Chunk.DocumentID, &scored.Chunk.ID, &scored.Chunk.ChunkIndex, &scored.Chunk.Content,
+			&title, &source, &metadata, &scored.Score); err != nil {
+			return nil, fmt.Errorf("failed to read pgvector chunk: %w", err)
+		}
+		if err := unmarshalColumn(metadata, &scored.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to read pgvector chunk metadata: %w", err)
+		}
+		if filter != nil && !filter.matches(scored.Metadata) {
+			continue
+		}
+		scored.Title, scored.Source = title.String, source.String
+		chunks = append(chunks, scored)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search pgvector: %w", err)
+	}
+	return chunks, nil
+}
+
+// embed returns one embedding per text, checking they fit the table
+func (s *PgVectorStore) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	response, err := ai.Embed(ctx, s.embedder, ai.WithTextDocs(texts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with %s: %w", s.embedder.Name(), err)
+	}
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder %s returned %d embeddings for %d texts", s.embedder.Name(), len(response.Embeddings), len(texts))
+	}
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range response.Embeddings {
+		if len(embedding.Embedding) != s.options.Dimensions {
+			return nil, fmt.Errorf("embedder %s returned %d dimensions, the table has %d", s.embedder.Name(), len(embedding.Embedding), s.options.Dimensions)
+		}
+		embeddings[i] = embedding.Embedding
+	}
+	return embeddings, nil
+}
+
+// pgVectorLiteral formats the embedding as a pgvector text literal, e.g. [1,0.5]
+func pgVectorLiteral(embedding []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, v := range embedding {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(v), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// pgVectorFilter translates the filter to a condition on the metadata column, adding
+// its parameters to args, and reports whether the condition is exact. Predicates on
+// dates become TRUE, leaving them to DocumentFilter.matches on the rows fetched.
+func pgVectorFilter(f DocumentFilter, args *[]any) (string, bool) {
+	switch {
+	case f.And != nil, f.Or != nil:
+		subs, join, empty := f.And, " AND ", "TRUE"
+		if f.Or != nil {
+			subs, join, empty = f.Or, " OR ", "FALSE"
+		}
+		if len(subs) == 0 {
+			return empty, true
+		}
+		conditions := make([]string, len(subs))
+		exact := true
+		for i, sub := range subs {
+			condition, ok := pgVectorFilter(sub, args)
+			conditions[i] = "(" + condition + ")"
+			exact = exact && ok
+		}
+		return strings.Join(conditions, join), exact
+	}
+
+	var jsonType, cast string
+	value := f.Value
+	if number, ok := filterNumber(value); ok {
+		jsonType, cast, value = "number", "float8", number
+	} else if _, ok := filterTime(value); ok {
+		return "TRUE", false
+	} else if _, ok := value.(bool); ok {
+		jsonType, cast = "boolean", "boolean"
+	} else if _, ok := value.(string); ok {
+		jsonType, cast = "string", "text"
+	} else {
+		return "TRUE", false
+	}
+
+	*args = append(*args, f.Key, value)
+	key, target := len(*args)-1, len(*args)
+	field := fmt.Sprintf(`CASE WHEN jsonb_typeof(metadata->($%[1]d::text)) = '%[2]s' THEN (metadata->>($%[1]d::text))::%[3]s END`, key, jsonType, cast)
+	if jsonType == "string" {
+		field = "(" + field + `) COLLATE "C"` // Byte order, as strings.Compare
+	}
+	operator := map[FilterOp]string{FilterOpEq: "=", FilterOpNe: "=", FilterOpGt: ">", FilterOpGte: ">=", FilterOpLt: "<", FilterOpLte: "<="}[f.Op]
+	condition := fmt.Sprintf("COALESCE(%s %s $%d::%s, FALSE)", field, operator, target, cast)
+	if f.Op == FilterOpNe {
+		condition = "NOT " + condition
+	}
+	return condition, true
+}
@@ -0,0 +1,609 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// testEmbeddingDimensions is the length of testEmbedder's vectors
+const testEmbeddingDimensions = 16
+
+// testEmbedder embeds texts as normalized bags of hashed words, so texts sharing words
+// are similar. It records the texts it embeds.
+type testEmbedder struct {
+	mu    sync.Mutex
+	texts []string
+}
+
+// define registers the embedder with a fresh genkit instance
+func (e *testEmbedder) define(t testing.TB) ai.Embedder {
+	t.Helper()
+	g, err := genkit.Init(context.Background(), genkit.WithPromptDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("genkit.Init: %v", err)
+	}
+	return genkit.DefineEmbedder(g, "test", "words", func(ctx context.Context, req *ai.EmbedRequest) (*ai.EmbedResponse, error) {
+		response := &ai.EmbedResponse{}
+		for _, doc := range req.Input {
+			var text strings.Builder
+			for _, part := range doc.Content {
+				text.WriteString(part.Text)
+			}
+			e.mu.Lock()
+			e.texts = append(e.texts, text.String())
+			e.mu.Unlock()
+			response.Embeddings = append(response.Embeddings, &ai.Embedding{Embedding: embedWords(text.String())})
+		}
+		return response, nil
+	})
+}
+
+// embedded returns the texts embedded so far
+func (e *testEmbedder) embedded() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.texts...)
+}
+
+// embedWords returns the normalized bag of hashed words of text
+func embedWords(text string) []float32 {
+	vector := make([]float32, testEmbeddingDimensions)
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		h := fnv.New32a()
+		h.Write([]byte(strings.Trim(word, ".,?!")))
+		vector[h.Sum32()%testEmbeddingDimensions]++
+	}
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v * v)
+	}
+	if norm > 0 {
+		for i := range vector {
+			vector[i] /= float32(math.Sqrt(norm))
+		}
+	}
+	return vector
+}
+
+// fakeStatement is a statement the fake database ran, with its arguments
+type fakeStatement struct {
+	query string
+	args  []any
+}
+
+// fakeResult is what the fake database answers a query with
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+	err     error
+}
+
+// fakeDB is a database/sql driver recording the statements it's sent and answering
+// queries through respond, standing in for Postgres below PgVectorStore
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []fakeStatement
+	commits    int
+	rollbacks  int
+	respond    func(query string, args []any) fakeResult // nil answers every query with no rows
+}
+
+// open returns a *sql.DB talking to the fake database
+func (f *fakeDB) open(t *testing.T) *sql.DB {
+	db := sql.OpenDB(fakeConnector{f})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// ran returns the statements run so far, without the schema creation ones
+func (f *fakeDB) ran() []fakeStatement {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var statements []fakeStatement
+	for _, statement := range f.statements {
+		if !strings.HasPrefix(statement.query, "CREATE") {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// record adds a statement, returning the answer to it
+func (f *fakeDB) record(query string, named []driver.NamedValue) fakeResult {
+	args := make([]any, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	f.mu.Lock()
+	f.statements = append(f.statements, fakeStatement{query: query, args: args})
+	respond := f.respond
+	f.mu.Unlock()
+	if respond == nil {
+		return fakeResult{}
+	}
+	return respond(query, args)
+}
+
+// fakeConnector connects database/sql to a fakeDB
+type fakeConnector struct{ db *fakeDB }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn(c), nil }
+func (c fakeConnector) Driver() driver.Driver                        { return nil }
+
+// fakeConn is a connection to a fakeDB, running statements without preparing them
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("fake database doesn't prepare statements")
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx(c), nil }
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if result := c.db.record(query, args); result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	result := c.db.record(query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{result: result}, nil
+}
+
+// fakeTx counts the commits and rollbacks of a fakeDB
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+// fakeRows iterates the rows of a fakeResult
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+// newFakePgVectorStore returns a store on the fake database
+func newFakePgVectorStore(t *testing.T, db *fakeDB, embedder *testEmbedder, options PgVectorOptions) *PgVectorStore {
+	t.Helper()
+	if options.Dimensions == 0 {
+		options.Dimensions = testEmbeddingDimensions
+	}
+	store, err := NewPgVectorStore(context.Background(), db.open(t), embedder.define(t), options)
+	if err != nil {
+		t.Fatalf("NewPgVectorStore: %v", err)
+	}
+	return store
+}
+
+func TestNewPgVectorStoreCreatesSchema(t *testing.T) {
+	db := &fakeDB{}
+	newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{Table: "rag.chunks", Metric: PgVectorInnerProduct})
+
+	want := []string{
+		"CREATE EXTENSION IF NOT EXISTS vector",
+		"CREATE TABLE IF NOT EXISTS rag.chunks (",
+		"CREATE INDEX IF NOT EXISTS rag_chunks_embedding ON rag.chunks USING hnsw (embedding vector_ip_ops)",
+		"CREATE INDEX IF NOT EXISTS rag_chunks_metadata ON rag.chunks USING gin (metadata)",
+	}
+	if len(db.statements) != len(want) {
+		t.Fatalf("ran %d statements, want %d", len(db.statements), len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(db.statements[i].query, prefix) {
+			t.Errorf("statement %d = %q, want it to start with %q", i, db.statements[i].query, prefix)
+		}
+	}
+	if !strings.Contains(db.statements[1].query, "embedding     vector(16)") {
+		t.Errorf("table isn't created with the embedder's dimensions:\n%s", db.statements[1].query)
+	}
+	if !strings.Contains(db.statements[1].query, "document_hash TEXT") {
+		t.Errorf("table isn't created with the document hash column:\n%s", db.statements[1].query)
+	}
+}
+
+func TestNewPgVectorStoreRejectsOptions(t *testing.T) {
+	embedder := (&testEmbedder{}).define(t)
+	tests := []struct {
+		name     string
+		options  PgVectorOptions
+		embedder ai.Embedder
+		want     string
+	}{
+		{"injected table name", PgVectorOptions{Table: "chunks; DROP TABLE users", Dimensions: 16}, embedder, "invalid pgvector table name"},
+		{"no dimensions", PgVectorOptions{}, embedder, "dimensions must be greater than 0"},
+		{"unknown metric", PgVectorOptions{Dimensions: 16, Metric: "euclidean"}, embedder, "unknown pgvector metric"},
+		{"no embedder", PgVectorOptions{Dimensions: 16}, nil, "requires an embedder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{}
+			_, err := NewPgVectorStore(context.Background(), db.open(t), tt.embedder, tt.options)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewPgVectorStore = %v, want an error mentioning %q", err, tt.want)
+			}
+			if len(db.statements) != 0 {
+				t.Errorf("ran %d statements before rejecting the options", len(db.statements))
+			}
+		})
+	}
+
+	db := &fakeDB{respond: func(string, []any) fakeResult {
+		return fakeResult{err: errors.New("permission denied to create extension")}
+	}}
+	if _, err := NewPgVectorStore(context.Background(), db.open(t), embedder, PgVectorOptions{Dimensions: 16}); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("NewPgVectorStore with a failing database = %v, want its error", err)
+	}
+}
+
+func TestPgVectorIndexEmbedsOnlyNewChunks(t *testing.T) {
+	doc := Document{ID: "doc_1", Title: "Paris", Source: "https://example.com/paris", Content: "unused", Metadata: map[string]any{"lang": "en"}}
+	chunks := []DocumentChunk{
+		{ID: "doc_1_chunk_0", DocumentID: "doc_1", ChunkIndex: 0, Content: "The Eiffel Tower is in Paris."},
+		{ID: "doc_1_chunk_1", DocumentID: "doc_1", ChunkIndex: 1, Content: "It was completed in 1889."},
+		{ID: "doc_1_chunk_2", DocumentID: "doc_1", ChunkIndex: 2, Content: "The Seine flows through Paris."},
+		{ID: "doc_1_chunk_3", DocumentID: "doc_1", ChunkIndex: 3, Content: "The Eiffel Tower is in Paris."}, // Same content as the first
+	}
+	stored := contentHash(chunks[1].Content)
+	db := &fakeDB{respond: func(query string, args []any) fakeResult {
+		if strings.HasPrefix(query, "SELECT chunk_hash") {
+			return fakeResult{columns: []string{"chunk_hash"}, rows: [][]driver.Value{{stored}}}
+		}
+		return fakeResult{}
+	}}
+	embedder := &testEmbedder{}
+	store := newFakePgVectorStore(t, db, embedder, PgVectorOptions{BatchSize: 2})
+
+	if err := store.Index(context.Background(), []Document{doc}, chunks); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	// The stored chunk and the repeated one aren't embedded
+	if got, want := embedder.embedded(), []string{chunks[0].Content, chunks[2].Content}; strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("embedded %q, want %q", got, want)
+	}
+
+	statements := db.ran()
+	if len(statements) != 4 {
+		t.Fatalf("ran %d statements, want the hash lookup, a delete and two upsert batches: %+v", len(statements), statements)
+	}
+	if statements[0].args[0] != "doc_1" {
+		t.Errorf("hash lookup args = %v, want the document ID", statements[0].args)
+	}
+	remove := statements[1]
+	if !strings.HasPrefix(remove.query, "DELETE FROM rag_chunks WHERE document_id = $1 AND chunk_hash NOT IN") {
+		t.Errorf("second statement = %q, want the delete of dropped chunks", remove.query)
+	}
+	wantKeep := `["` + contentHash(chunks[0].Content) + `","` + stored + `","` + contentHash(chunks[2].Content) + `"]`
+	if remove.args[1] != wantKeep {
+		t.Errorf("kept hashes = %v, want %s", remove.args[1], wantKeep)
+	}
+
+	var upserted [][]any
+	for _, statement := range statements[2:] {
+		if !strings.HasPrefix(statement.query, "INSERT INTO rag_chunks AS t") || !strings.Contains(statement.query, "ON CONFLICT (document_id, chunk_hash) DO UPDATE") {
+			t.Fatalf("statement %q isn't an upsert", statement.query)
+		}
+		if len(statement.args)%10 != 0 {
+			t.Fatalf("upsert has %d arguments, want 10 per row", len(statement.args))
+		}
+		for i := 0; i < len(statement.args); i += 10 {
+			upserted = append(upserted, statement.args[i:i+10])
+		}
+	}
+	if len(upserted) != 3 {
+		t.Fatalf("upserted %d rows, want 3", len(upserted))
+	}
+	for i, row := range upserted {
+		chunk := chunks[i]
+		if row[0] != "doc_1" || row[1] != contentHash(chunk.Content) || row[2] != chunk.ID || row[4] != chunk.Content ||
+			row[5] != doc.Title || row[6] != doc.Source || row[7] != `{"lang":"en"}` || row[9] != documentHash(doc) {
+			t.Errorf("row %d = %v", i, row)
+		}
+		if chunk.Content == chunks[1].Content {
+			if row[8] != nil {
+				t.Errorf("stored chunk got embedding %v, want NULL to keep the stored one", row[8])
+			}
+		} else if row[8] != pgVectorLiteral(embedWords(chunk.Content)) {
+			t.Errorf("row %d has embedding %v", i, row[8])
+		}
+	}
+	if db.commits != 1 {
+		t.Errorf("committed %d times, want 1", db.commits)
+	}
+}
+
+func TestPgVectorIndexRollsBackOnError(t *testing.T) {
+	db := &fakeDB{respond: func(query string, args []any) fakeResult {
+		if strings.HasPrefix(query, "INSERT") {
+			return fakeResult{err: errors.New("disk full")}
+		}
+		return fakeResult{}
+	}}
+	store := newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{})
+	err := store.Index(context.Background(), nil, []DocumentChunk{{ID: "c", DocumentID: "doc_1", Content: "Paris"}})
+	if err == nil || !strings.Contains(err.Error(), "failed to index document doc_1") || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("Index = %v, want the insert error", err)
+	}
+	if db.commits != 0 || db.rollbacks != 1 {
+		t.Errorf("commits = %d, rollbacks = %d, want a rollback only", db.commits, db.rollbacks)
+	}
+}
+
+func TestPgVectorIndexChecksDimensions(t *testing.T) {
+	db := &fakeDB{}
+	store := newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{Dimensions: 8})
+	err := store.Index(context.Background(), nil, []DocumentChunk{{ID: "c", DocumentID: "doc_1", Content: "Paris"}})
+	if err == nil || !strings.Contains(err.Error(), "returned 16 dimensions, the table has 8") {
+		t.Errorf("Index = %v, want a dimension mismatch", err)
+	}
+	for _, statement := range db.ran() {
+		if strings.HasPrefix(statement.query, "INSERT") {
+			t.Errorf("upserted despite the mismatch")
+		}
+	}
+}
+
+// retrievedRows returns scripted search results, one per document, in the given order
+func retrievedRows(metadata ...string) [][]driver.Value {
+	rows := make([][]driver.Value, len(metadata))
+	for i, m := range metadata {
+		id := "doc_" + string(rune('a'+i))
+		rows[i] = []driver.Value{id, id + "_chunk_0", int64(0), "Content of " + id, "Title " + id, nil, m, 0.9 - float64(i)/10}
+	}
+	return rows
+}
+
+func TestPgVectorRetrieve(t *testing.T) {
+	columns := []string{"document_id", "chunk_id", "chunk_index", "content", "title", "source", "metadata", "score"}
+	tests := []struct {
+		name      string
+		metric    PgVectorMetric
+		filter    *DocumentFilter
+		rows      [][]driver.Value
+		k         int
+		wantSQL   []string
+		wantLimit int64
+		wantDocs  []string
+	}{
+		{
+			name:      "cosine without a filter",
+			k:         2,
+			rows:      retrievedRows(`{"lang":"en"}`, `{"lang":"fr"}`),
+			wantSQL:   []string{"1 - (embedding <=> $1::vector)", "WHERE embedding IS NOT NULL AND (TRUE)", "ORDER BY embedding <=> $1::vector LIMIT $2"},
+			wantLimit: 2,
+			wantDocs:  []string{"doc_a", "doc_b"},
+		},
+		{
+			name:      "inner product",
+			metric:    PgVectorInnerProduct,
+			k:         1,
+			rows:      retrievedRows(`{}`),
+			wantSQL:   []string{"-(embedding <#> $1::vector)", "ORDER BY embedding <#> $1::vector"},
+			wantLimit: 1,
+			wantDocs:  []string{"doc_a"},
+		},
+		{
+			name:      "translated filter",
+			k:         5,
+			filter:    &DocumentFilter{Key: "lang", Op: FilterOpEq, Value: "en"},
+			rows:      retrievedRows(`{"lang":"en"}`),
+			wantSQL:   []string{`jsonb_typeof(metadata->($2::text)) = 'string'`, `COALESCE(`, `= $3::text, FALSE)`, "LIMIT $4"},
+			wantLimit: 5,
+			wantDocs:  []string{"doc_a"},
+		},
+		{
+			// Dates aren't translated, so more rows are fetched and filtered here
+			name:      "untranslated filter",
+			k:         2,
+			filter:    &DocumentFilter{Key: "published", Op: FilterOpGte, Value: "2024-01-01"},
+			rows:      retrievedRows(`{"published":"2023-06-01"}`, `{"published":"2024-03-01"}`, `{}`, `{"published":"2025-01-01"}`, `{"published":"2024-02-01"}`),
+			wantSQL:   []string{"AND (TRUE)"},
+			wantLimit: 8,
+			wantDocs:  []string{"doc_b", "doc_d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeDB{respond: func(query string, args []any) fakeResult {
+				return fakeResult{columns: columns, rows: tt.rows}
+			}}
+			store := newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{Metric: tt.metric})
+
+			chunks, err := store.Retrieve(context.Background(), "Where is the Eiffel Tower?", tt.k, tt.filter)
+			if err != nil {
+				t.Fatalf("Retrieve: %v", err)
+			}
+			statements := db.ran()
+			if len(statements) != 1 {
+				t.Fatalf("ran %d statements, want one search", len(statements))
+			}
+			search := statements[0]
+			for _, fragment := range tt.wantSQL {
+				if !strings.Contains(search.query, fragment) {
+					t.Errorf("search doesn't contain %q:\n%s", fragment, search.query)
+				}
+			}
+			if search.args[0] != pgVectorLiteral(embedWords("Where is the Eiffel Tower?")) {
+				t.Errorf("search vector = %v, want the query's embedding", search.args[0])
+			}
+			if limit := search.args[len(search.args)-1]; limit != tt.wantLimit {
+				t.Errorf("limit = %v, want %d", limit, tt.wantLimit)
+			}
+
+			var docs []string
+			for _, chunk := range chunks {
+				docs = append(docs, chunk.Chunk.DocumentID)
+				if chunk.Chunk.ID != chunk.Chunk.DocumentID+"_chunk_0" || chunk.Title != "Title "+chunk.Chunk.DocumentID || chunk.Score <= 0 {
+					t.Errorf("chunk not read back: %+v", chunk)
+				}
+			}
+			if strings.Join(docs, ",") != strings.Join(tt.wantDocs, ",") {
+				t.Errorf("retrieved %v, want %v", docs, tt.wantDocs)
+			}
+		})
+	}
+}
+
+func TestPgVectorRetrieveError(t *testing.T) {
+	db := &fakeDB{respond: func(query string, args []any) fakeResult {
+		if strings.HasPrefix(query, "SELECT") {
+			return fakeResult{err: errors.New("connection reset")}
+		}
+		return fakeResult{}
+	}}
+	store := newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{})
+	if _, err := store.Retrieve(context.Background(), "query", 3, nil); err == nil || !strings.Contains(err.Error(), "failed to search pgvector: connection reset") {
+		t.Errorf("Retrieve = %v, want the search error", err)
+	}
+}
+
+func TestPgVectorDocumentHashAndDelete(t *testing.T) {
+	db := &fakeDB{respond: func(query string, args []any) fakeResult {
+		switch {
+		case strings.HasPrefix(query, "SELECT document_hash") && args[0] == "doc_1":
+			return fakeResult{columns: []string{"document_hash"}, rows: [][]driver.Value{{"abc"}}}
+		case strings.HasPrefix(query, "DELETE"):
+			return fakeResult{columns: []string{"chunk_hash"}, rows: [][]driver.Value{{"h1"}, {"h2"}}}
+		}
+		return fakeResult{columns: []string{"document_hash"}}
+	}}
+	store := newFakePgVectorStore(t, db, &testEmbedder{}, PgVectorOptions{})
+	ctx := context.Background()
+
+	if hash, err := store.DocumentHash(ctx, "doc_1"); err != nil || hash != "abc" {
+		t.Errorf("DocumentHash(doc_1) = %q, %v, want abc", hash, err)
+	}
+	if hash, err := store.DocumentHash(ctx, "missing"); err != nil || hash != "" {
+		t.Errorf("DocumentHash(missing) = %q, %v, want empty", hash, err)
+	}
+	if hashes, err := store.Delete(ctx, "doc_1"); err != nil || strings.Join(hashes, ",") != "h1,h2" {
+		t.Errorf("Delete = %v, %v, want the deleted hashes", hashes, err)
+	}
+}
+
+func TestPgVectorFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    DocumentFilter
+		want      []string // Fragments of the condition
+		wantArgs  []any
+		wantExact bool
+	}{
+		{
+			name:      "string",
+			filter:    DocumentFilter{Key: "lang", Op: FilterOpEq, Value: "en"},
+			want:      []string{`jsonb_typeof(metadata->($1::text)) = 'string'`, `(metadata->>($1::text))::text`, `COLLATE "C"`, `= $2::text`},
+			wantArgs:  []any{"lang", "en"},
+			wantExact: true,
+		},
+		{
+			name:      "number",
+			filter:    DocumentFilter{Key: "year", Op: FilterOpGt, Value: 2000},
+			want:      []string{`= 'number'`, `::float8 END > $2::float8`},
+			wantArgs:  []any{"year", 2000.0},
+			wantExact: true,
+		},
+		{
+			name:      "not equal",
+			filter:    DocumentFilter{Key: "draft", Op: FilterOpNe, Value: true},
+			want:      []string{"NOT COALESCE(", `= 'boolean'`, `= $2::boolean`},
+			wantArgs:  []any{"draft", true},
+			wantExact: true,
+		},
+		{
+			name: "and with a date",
+			filter: DocumentFilter{And: []DocumentFilter{
+				{Key: "lang", Op: FilterOpEq, Value: "en"},
+				{Key: "published", Op: FilterOpGte, Value: "2024-01-01"},
+			}},
+			want:      []string{") AND (TRUE)"},
+			wantArgs:  []any{"lang", "en"},
+			wantExact: false,
+		},
+		{
+			name:      "empty or",
+			filter:    DocumentFilter{Or: []DocumentFilter{}},
+			want:      []string{"FALSE"},
+			wantExact: true,
+		},
+		{
+			name:      "injection in the key",
+			filter:    DocumentFilter{Key: "x') OR TRUE --", Op: FilterOpEq, Value: "y"},
+			want:      []string{"metadata->($1::text)"},
+			wantArgs:  []any{"x') OR TRUE --", "y"},
+			wantExact: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []any
+			condition, exact := pgVectorFilter(tt.filter, &args)
+			for _, fragment := range tt.want {
+				if !strings.Contains(condition, fragment) {
+					t.Errorf("condition doesn't contain %q:\n%s", fragment, condition)
+				}
+			}
+			if strings.Contains(condition, "OR TRUE") {
+				t.Errorf("filter key was spliced into the condition:\n%s", condition)
+			}
+			if exact != tt.wantExact {
+				t.Errorf("exact = %v, want %v", exact, tt.wantExact)
+			}
+			if len(args) != len(tt.wantArgs) {
+				t.Fatalf("args = %v, want %v", args, tt.wantArgs)
+			}
+			for i := range args {
+				if args[i] != tt.wantArgs[i] {
+					t.Errorf("arg %d = %v, want %v", i, args[i], tt.wantArgs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPgVectorLiteral(t *testing.T) {
+	if got := pgVectorLiteral([]float32{1, 0.5, -0.25, 1e-8}); got != "[1,0.5,-0.25,1e-08]" {
+		t.Errorf("pgVectorLiteral = %q", got)
+	}
+	if got := pgVectorLiteral(nil); got != "[]" {
+		t.Errorf("pgVectorLiteral(nil) = %q", got)
+	}
+}
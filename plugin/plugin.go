@@ -16,14 +16,15 @@ type AgenticRAGPlugin struct {
 	config    *AgenticRAGConfig
 }
 
-// NewPlugin creates a new agentic RAG plugin
-func NewPlugin(config *AgenticRAGConfig) *AgenticRAGPlugin {
+// NewPlugin creates a new agentic RAG plugin. Options customize its processor, as for
+// NewAgenticRAGProcessor.
+func NewPlugin(config *AgenticRAGConfig, opts ...ProcessorOption) *AgenticRAGPlugin {
 	if config == nil {
 		config = DefaultConfig()
 	}
 
 	return &AgenticRAGPlugin{
-		processor: NewAgenticRAGProcessor(config),
+		processor: NewAgenticRAGProcessor(config, opts...),
 		config:    config,
 	}
 }
@@ -35,14 +36,30 @@ func (p *AgenticRAGPlugin) Name() string {
 
 // Init initializes the plugin with GenKit
 func (p *AgenticRAGPlugin) Init(ctx context.Context, g *genkit.Genkit) error {
+	// Reject misconfiguration up front instead of midway through a request
+	if err := p.config.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	// Store GenKit instance in config for processor access
 	p.config.Genkit = g
 
+	// Check the prompts, including their models now genkit's plugins registered them
+	if err := ValidatePrompts(p.config); err != nil {
+		return fmt.Errorf("invalid prompts: %w", err)
+	}
+
 	// Initialize prompts and custom helpers
 	if err := p.processor.initializePrompts(ctx); err != nil {
 		return fmt.Errorf("failed to initialize prompts: %w", err)
 	}
 
+	// Expose the ingested corpus to other flows, first so a name collision fails
+	// before anything is registered
+	if err := p.registerCorpus(g); err != nil {
+		return fmt.Errorf("failed to register corpus: %w", err)
+	}
+
 	// Register the main agentic RAG flow
 	if err := p.registerFlows(ctx, g); err != nil {
 		return fmt.Errorf("failed to register flows: %w", err)
@@ -52,37 +69,23 @@ func (p *AgenticRAGPlugin) Init(ctx context.Context, g *genkit.Genkit) error {
 	if err := p.registerTools(ctx, g); err != nil {
 		return fmt.Errorf("failed to register tools: %w", err)
 	}
-
 	return nil
 }
 
-// registerFlows registers the agentic RAG flows
+// registerFlows registers the agentic RAG flows unless flows are disabled. Requests
+// run by the flows run their stages as flow steps, so the developer UI's trace
+// timeline shows each stage.
 func (p *AgenticRAGPlugin) registerFlows(ctx context.Context, g *genkit.Genkit) error {
-	// Main agentic RAG streaming flow using correct GenKit Go API
-	genkit.DefineStreamingFlow(
-		g,
-		"agenticRAG",
-		func(ctx context.Context, input AgenticRAGRequest, cb func(context.Context, *AgenticRAGResponse) error) (*AgenticRAGResponse, error) {
-			// Use the processor to handle the full agentic RAG pipeline
-			response, err := p.processor.Process(ctx, input)
-			if err != nil {
-				return nil, err
-			}
-
-			// If streaming callback is provided, stream the response
-			if cb != nil {
-				if err := cb(ctx, response); err != nil {
-					return nil, err
-				}
-			}
+	if p.config.Flows.Disabled {
+		return nil
+	}
 
-			return response, nil
-		},
-	)
+	// Main agentic RAG flow, streaming the pipeline's events as they happen
+	genkit.DefineStreamingFlow(g, "agenticRAG", p.processor.processFlow)
 
 	// Also register a simple non-streaming flow for basic usage
 	genkit.DefineFlow(g, "agenticRAGSimple", func(ctx context.Context, input AgenticRAGRequest) (*AgenticRAGResponse, error) {
-		return p.processor.Process(ctx, input)
+		return p.processor.Process(withFlowTracer(ctx), input)
 	})
 
 	return nil
@@ -102,10 +105,11 @@ func (p *AgenticRAGPlugin) registerTools(ctx context.Context, g *genkit.Genkit)
 				Source:  "user_input",
 			}
 
-			chunks, err := p.processor.chunkDocument(ctx, doc, input.MaxChunks)
+			set, err := p.processor.chunker.Chunk(ctx, []Document{doc}, input.MaxChunks)
 			if err != nil {
 				return ChunkDocumentResponse{}, err
 			}
+			chunks := set.Chunks
 
 			return ChunkDocumentResponse{
 				Chunks:      chunks,
@@ -154,7 +158,7 @@ func (p *AgenticRAGPlugin) registerTools(ctx context.Context, g *genkit.Genkit)
 					}
 				}
 
-				kg, err := p.processor.buildKnowledgeGraph(ctx, chunks)
+				kg, err := p.processor.extractor.Extract(ctx, ScoredChunks{Chunks: chunks})
 				if err != nil {
 					return KnowledgeGraphResponse{}, err
 				}
@@ -0,0 +1,102 @@
+package plugin
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/firebase/genkit/go/genkit"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRegisterFlows(t *testing.T) {
+	tests := []struct {
+		name  string
+		flows FlowConfig
+		want  bool
+	}{
+		{"zero value", FlowConfig{}, true},
+		{"disabled", FlowConfig{Disabled: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+				config.Flows = tt.flows
+			})
+			p := &AgenticRAGPlugin{processor: processor, config: processor.config}
+			if err := p.registerFlows(context.Background(), processor.config.Genkit); err != nil {
+				t.Fatalf("registerFlows: %v", err)
+			}
+			registered := make(map[string]bool)
+			for _, flow := range genkit.ListFlows(processor.config.Genkit) {
+				registered[flow.Name()] = true
+			}
+			for _, name := range []string{"agenticRAG", "agenticRAGSimple"} {
+				if registered[name] != tt.want {
+					t.Errorf("flow %s registered = %v, want %v", name, registered[name], tt.want)
+				}
+			}
+		})
+	}
+}
+
+// spanAttribute returns the string attribute of the span, empty if it has none
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
+
+func TestFlowRunsStagesAsSteps(t *testing.T) {
+	processor := newTestProcessor(t, &mockProvider{}, nil)
+	g := processor.config.Genkit
+	recorder := tracetest.NewSpanRecorder()
+	genkit.RegisterSpanProcessor(g, recorder)
+	flow := genkit.DefineStreamingFlow(g, "agenticRAGSteps", processor.processFlow)
+
+	if _, err := flow.Run(context.Background(), testRequest("Where is the Eiffel Tower?")); err != nil {
+		t.Fatalf("flow.Run: %v", err)
+	}
+
+	var request sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "agentic_rag.process" {
+			request = span
+		}
+	}
+	if request == nil {
+		t.Fatal("no request span under the flow")
+	}
+	var steps []string
+	for _, span := range recorder.Ended() {
+		if spanAttribute(span, "genkit:type") != "flowStep" {
+			continue
+		}
+		steps = append(steps, span.Name())
+		if span.Parent().SpanID() != request.SpanContext().SpanID() {
+			t.Errorf("step %s isn't under the request span", span.Name())
+		}
+		if spanAttribute(span, "genkit:state") != "success" || spanAttribute(span, "genkit:output") == "" {
+			t.Errorf("step %s ended %q with output %q, want a successful summary", span.Name(), spanAttribute(span, "genkit:state"), spanAttribute(span, "genkit:output"))
+		}
+	}
+	want := []string{StageLoading, StageChunking, StageRelevanceScoring, StageRefinement, StageGeneration}
+	if !slices.Equal(steps, want) {
+		t.Errorf("flow steps %v, want %v", steps, want)
+	}
+
+	// Outside flows the stages run without steps
+	recorder = tracetest.NewSpanRecorder()
+	genkit.RegisterSpanProcessor(g, recorder)
+	mustProcess(t, processor, testRequest("Where is the Eiffel Tower?"))
+	for _, span := range recorder.Ended() {
+		if spanAttribute(span, "genkit:type") == "flowStep" {
+			t.Errorf("step %s recorded outside a flow", span.Name())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// runParallel runs fn for the indices 0..n-1 on up to workers goroutines and returns
+// the results and errors in index order. In fail-fast mode the first error cancels
+// the remaining work and is returned as err; otherwise every index runs and err is
+// only set when ctx itself is done.
+func runParallel[T any](ctx context.Context, n, workers int, failFast bool, fn func(ctx context.Context, i int) (T, error)) (results []T, errs []error, err error) {
+	results = make([]T, n)
+	errs = make([]error, n)
+	if n == 0 {
+		return results, errs, nil
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+
+	poolCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var first error
+	var once sync.Once
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				if err := poolCtx.Err(); err != nil {
+					errs[i] = err
+					continue
+				}
+				results[i], errs[i] = fn(poolCtx, i)
+				if errs[i] != nil && failFast {
+					once.Do(func() { first = errs[i] })
+					cancel()
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indices <- i:
+		case <-poolCtx.Done():
+			for ; i < n; i++ {
+				errs[i] = poolCtx.Err()
+			}
+			break feed
+		}
+	}
+	close(indices)
+	wg.Wait()
+
+	if first != nil {
+		return results, errs, first
+	}
+	return results, errs, ctx.Err()
+}
+
+// runChunks runs fn for every chunk of a fan-out stage on the configured worker pool.
+// Outside fail-fast mode chunks that failed with a provider error get one more attempt
+// once the pool is done, and the chunks still failing are recorded in the request
+// state. If more than Processing.MaxChunkFailureRate of the chunks failed the stage
+// fails with ErrTooManyChunkFailures wrapping each chunk's error; otherwise errs
+// reports them for the caller to keep the chunks unprocessed. The time each chunk
+// took is summed into the stage's StageTiming.WorkTime.
+func runChunks[T any](ctx context.Context, p *AgenticRAGProcessor, stage string, chunks []DocumentChunk, fn func(ctx context.Context, i int) (T, error)) (results []T, errs []error, err error) {
+	processing := p.config.Processing
+	state := requestStateFrom(ctx)
+	timed := fn
+	fn = func(ctx context.Context, i int) (T, error) {
+		start := time.Now()
+		defer func() { state.addStageWork(stage, time.Since(start)) }()
+		return timed(ctx, i)
+	}
+	results, errs, err = runParallel(ctx, len(chunks), processing.Concurrency, processing.FailFast, fn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Retry provider failures one at a time, since a burst of parallel calls is a
+	// common cause of rate limiting
+	for i := range chunks {
+		if errs[i] != nil && errors.Is(errs[i], ErrClassProvider) {
+			results[i], errs[i] = fn(ctx, i)
+			if err := ctx.Err(); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	var failed []error
+	for i, chunkErr := range errs {
+		if chunkErr == nil {
+			continue
+		}
+		state.recordChunkError(ChunkError{ChunkID: chunks[i].ID, Stage: stage, Error: chunkErr.Error()})
+		state.recordWarning(fmt.Sprintf("chunk %s skipped in %s: %v", chunks[i].ID, stage, chunkErr))
+		if !errors.Is(chunkErr, ErrBudgetExceeded) { // Running out of budget isn't a failure of the chunk
+			failed = append(failed, fmt.Errorf("chunk %s: %w", chunks[i].ID, chunkErr))
+		}
+	}
+	if len(failed) > 0 && float64(len(failed)) > processing.MaxChunkFailureRate*float64(len(chunks)) {
+		return nil, nil, fmt.Errorf("%w: %d of %d chunks failed in %s: %w",
+			ErrTooManyChunkFailures, len(failed), len(chunks), stage, errors.Join(failed...))
+	}
+	return results, errs, nil
+}
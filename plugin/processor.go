@@ -3,30 +3,140 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
-	"sort"
+	"io/fs"
+	"log/slog"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/firebase/genkit/go/ai"
-	"github.com/firebase/genkit/go/genkit"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// AgenticRAGProcessor implements the core agentic RAG flow
+// AgenticRAGProcessor implements the core agentic RAG flow.
+//
+// A processor is safe for concurrent use: Process, ProcessStream and ProcessBatch may
+// be called from any number of goroutines. Everything a request accumulates lives in
+// a request-scoped state carried by its context, never on the processor. The state
+// shared across requests is synchronized: the model balancer and downgrader, the URL
+// and embedding caches, the session store, the response cache and the one-time prompt
+// helper registration. Concurrent requests on the same conversation each see the
+// session as it was when they started, and the last one to finish wins. Rendering
+// dotprompts is serialized, since genkit's renderer isn't safe for concurrent use; the
+// model calls themselves run concurrently.
+//
+// The configuration must not be modified once the processor is in use.
 type AgenticRAGProcessor struct {
-	config *AgenticRAGConfig
+	config            *AgenticRAGConfig
+	balancer          *modelBalancer
+	downgrader        *modelDowngrader
+	urlLoader         *urlLoader
+	embeddings        EmbeddingCache // Nil when embedding caching is disabled
+	communities       communityCache // Community summaries by content hash
+	sessions          SessionStore
+	cache             ResponseCache     // Nil when response caching is disabled
+	verifyCache       VerificationCache // Nil when verdict caching is disabled
+	helpers           sync.Once         // Registers the prompt helpers with genkit once
+	helpersRegistered atomic.Bool       // The prompt helpers were registered, so no more can be added
+	startHooks        []StageStartHook
+	endHooks          []StageEndHook
+	experimentHooks   []ExperimentHook
+
+	// Pipeline stages, the built-in implementations unless replaced by options
+	chunker     Chunker
+	scorer      RelevanceScorer
+	extractor   KnowledgeExtractor
+	synthesizer Synthesizer
+	verifier    Verifier
+
+	verificationSource VerificationSource // Consulted for claims the documents don't settle
+	verificationReport bool               // Attach the markdown report of the fact verification to responses
+
+	prompts       atomic.Pointer[promptSet] // Prompts reloaded from the prompts directory
+	reloadMu      sync.Mutex                // Serializes prompt reloads
+	stopWatch     func()                    // Stops watching the prompts directory, nil when not watching
+	stopWatchOnce sync.Once                 // Lets Close be called more than once
+	embedded      map[string]*ai.Prompt     // Embedded defaults of the prompts missing from the directory
+	inline        map[string]*ai.Prompt     // Prompts of PromptsConfig.Inline by name
+	defaults      sync.Once                 // Loads the embedded prompts on the first initialization
+	defaultsErr   error                     // Error of loading the embedded prompts
+
+	partialVariants map[string]*ai.Prompt // Variants made up by partial overrides, by name including the variant
+	debugMu         sync.Mutex            // Serializes writes to DebugConfig.Writer
+	tracer          trace.Tracer          // Nil when tracing is off
+	metrics         Metrics               // nopMetrics unless configured
+	audit           *auditor              // Nil without an audit logger
 }
 
-// NewAgenticRAGProcessor creates a new processor with the given configuration
-func NewAgenticRAGProcessor(config *AgenticRAGConfig) *AgenticRAGProcessor {
+// NewAgenticRAGProcessor creates a new processor with the given configuration. Options
+// replace the built-in pipeline stages.
+func NewAgenticRAGProcessor(config *AgenticRAGConfig, opts ...ProcessorOption) *AgenticRAGProcessor {
 	if config == nil {
 		config = DefaultConfig()
 	}
-	return &AgenticRAGProcessor{
-		config: config,
+	sessions := config.SessionStore
+	if sessions == nil {
+		sessions = NewMemorySessionStore(config.Sessions.TTL)
+	}
+	cache := config.ResponseCache
+	if cache == nil && config.Cache.Enabled {
+		cache = NewMemoryResponseCache(config.Cache.MaxEntries, config.Cache.TTL)
+	}
+	verifyCache := config.VerificationCache
+	if verifyCache == nil && config.FactVerification.Cache.Enabled {
+		verifyCache = NewMemoryVerificationCache(config.FactVerification.Cache.MaxEntries, config.FactVerification.Cache.TTL)
+	}
+	metrics := config.Metrics
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	embeddings := config.EmbeddingCache
+	if embeddings == nil && config.Embedding.CacheSize > 0 {
+		embeddings = NewMemoryEmbeddingCache(config.Embedding.CacheSize)
+	}
+	p := &AgenticRAGProcessor{
+		config:      config,
+		balancer:    newModelBalancer(config.LoadBalancing),
+		downgrader:  newModelDowngrader(config.Downgrade),
+		urlLoader:   newURLLoader(config.URLLoader),
+		embeddings:  embeddings,
+		sessions:    sessions,
+		cache:       cache,
+		verifyCache: verifyCache,
+		tracer:      newTracer(config),
+		metrics:     metrics,
+		audit:       newAuditor(config.Audit),
+	}
+	p.chunker = builtinChunker{processor: p}
+	p.scorer = builtinScorer{processor: p}
+	p.extractor = promptExtractor{processor: p}
+	p.synthesizer = promptSynthesizer{processor: p}
+	p.verifier = promptVerifier{processor: p}
+	p.verificationSource = googleSearchSource{processor: p}
+	for _, opt := range opts {
+		opt(p)
+	}
+	// Record the prompt files as genkit loaded them, so reloads pick up changes
+	if err := p.ReloadPrompts(context.Background()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("failed to scan prompts directory", "error", err)
+	}
+	if config.Prompts.Watch && config.Genkit != nil && config.Prompts.Directory != "" {
+		debounce := config.Prompts.WatchDebounce
+		if debounce == 0 {
+			debounce = defaultPromptWatchDebounce
+		}
+		// Without a watcher, prompts can still be reloaded with ReloadPrompts
+		stop, err := p.watchPrompts(debounce)
+		if err != nil {
+			slog.Error("failed to watch prompts directory", "error", err)
+		}
+		p.stopWatch = stop
 	}
+	return p
 }
 
 // DefaultConfig returns a default configuration
@@ -37,18 +147,50 @@ func DefaultConfig() *AgenticRAGConfig {
 			DefaultChunkSize:      1000,
 			DefaultMaxChunks:      20,
 			DefaultRecursiveDepth: 3,
+			MaxRecursiveDepth:     5,
 			RespectSentences:      true,
+			ChunkStrategy:         ChunkStrategySentence,
+			ChunkUnit:             ChunkUnitChars,
+			RelevanceMode:         RelevanceModeLLM,
+			HybridTopK:            10,
+			RerankTopN:            10,
+			RetrievalTopK:         20,
+			DiversityLambda:       0.7,
+			Semantic: SemanticChunkingConfig{
+				BreakpointPercentile: 90,
+				MinChunkSize:         200,
+			},
+			Termination: TerminationConfig{
+				MinInformationGain:  0.1,
+				StopOnNoNewEntities: true,
+				TargetConfidence:    0.9,
+			},
+			Concurrency:         4,
+			MaxChunkFailureRate: 0.2,
 		},
 		KnowledgeGraph: KnowledgeGraphConfig{
 			Enabled:                true,
 			EntityTypes:            []string{"PERSON", "ORGANIZATION", "LOCATION", "CONCEPT", "TECHNOLOGY", "EVENT"},
 			RelationTypes:          []string{"WORKS_FOR", "LOCATED_IN", "FOUNDED", "DEVELOPS", "USES", "RELATED_TO"},
 			MinConfidenceThreshold: 0.7,
+			ModelAssistedMerge:     true,
+			MergeSimilarity:        0.85,
+			MaxEntities:            500,
+			MaxRelations:           1000,
 		},
 		FactVerification: FactVerificationConfig{
-			Enabled:            true,
-			RequireEvidence:    true,
-			MinConfidenceScore: 0.7,
+			Enabled:                     true,
+			RequireEvidence:             true,
+			MinConfidenceScore:          0.7,
+			PartialSupportMinConfidence: 0.4,
+			CheckNumbers:                true,
+			NumericTolerance:            0.01,
+			SelfConsistencySamples:      3,
+			SelfConsistencyBand:         0.15,
+			Cache: CacheConfig{
+				TTL:        time.Hour,
+				MaxEntries: 10000,
+			},
 		},
 		Prompts: PromptsConfig{
 			Directory:                 "./prompts",
@@ -56,63 +198,134 @@ func DefaultConfig() *AgenticRAGConfig {
 			ResponseGenerationPrompt:  "response_generation",
 			KnowledgeExtractionPrompt: "knowledge_extraction",
 			FactVerificationPrompt:    "fact_verification",
+			RerankPrompt:              "rerank",
+			QueryRewritePrompt:        "query_rewrite",
+			ExtractiveAnswerPrompt:    "extractive_answer",
+			RecursionAssessmentPrompt: "recursion_assessment",
+			AnswerAssessmentPrompt:    "answer_assessment",
 			Variants:                  make(map[string]string),
 			CustomHelpers:             true,
 		},
+		Retry: RetryConfig{
+			MaxAttempts:        3,
+			InitialBackoff:     time.Second,
+			MaxBackoff:         8 * time.Second,
+			Multiplier:         2,
+			MinAttemptDuration: 2 * time.Second,
+		},
+		ContextEscalation: ContextEscalationConfig{
+			Enabled:        true,
+			MaxEscalations: 1,
+		},
+		Truncation: TruncationConfig{
+			Strategy:      TruncationNone,
+			OutputReserve: 8192,
+		},
+		Downgrade: DowngradeConfig{
+			Threshold: 2,
+			Cooldown:  5 * time.Minute,
+		},
+		URLLoader: URLLoaderConfig{
+			Enabled:   true,
+			Timeout:   15 * time.Second,
+			UserAgent: "genkit-agentic-rag/1.0",
+			MaxBytes:  5 << 20,
+			CacheTTL:  15 * time.Minute,
+		},
+		PDF: PDFConfig{
+			Enabled:  true,
+			MaxBytes: 50 << 20,
+		},
+		Embedding: EmbeddingConfig{
+			BatchSize: 100,
+			CacheSize: 10000,
+		},
+		Sessions: SessionConfig{
+			TTL:      30 * time.Minute,
+			MaxTurns: 20,
+		},
+		Batch: BatchConfig{
+			Concurrency: 4,
+		},
+		Cache: CacheConfig{
+			Enabled:    false,
+			TTL:        10 * time.Minute,
+			MaxEntries: 1000,
+		},
+		Jobs: JobConfig{
+			Workers:   4,
+			QueueSize: 100,
+			ResultTTL: time.Hour,
+		},
+		Debug: DebugConfig{
+			MaxCaptureBytes: 16 << 10,
+		},
+		Audit: AuditConfig{
+			Redaction: RedactionPolicy{
+				PreviewBytes: 200,
+				Emails:       true,
+			},
+		},
 	}
 }
 
-// initializePrompts sets up the prompt system with custom helpers
+// initializePrompts sets up the prompt system with the prompt helpers, the partials, the
+// inline prompts and the embedded default prompts. They're registered on the first call
+// only, since genkit's registries aren't safe for concurrent writes.
 func (p *AgenticRAGProcessor) initializePrompts(ctx context.Context) error {
 	if p.config.Genkit == nil {
 		return fmt.Errorf("GenKit instance not provided in config")
 	}
-
-	g := p.config.Genkit
-
-	// Register custom helpers for prompt templates
-	if p.config.Prompts.CustomHelpers {
-		// Helper to create arrays in templates
-		genkit.DefineHelper(g, "array", func(items ...interface{}) []interface{} {
-			return items
-		})
-
-		// Helper to format confidence scores
-		genkit.DefineHelper(g, "confidence", func(score float64) string {
-			return fmt.Sprintf("%.2f", score)
-		})
-
-		// Helper to truncate text with ellipsis
-		genkit.DefineHelper(g, "truncate", func(text string, length int) string {
-			if len(text) <= length {
-				return text
-			}
-			return text[:length] + "..."
-		})
-
-		// Helper to join array elements
-		genkit.DefineHelper(g, "join", func(items []string, separator string) string {
-			return strings.Join(items, separator)
-		})
-
-		// Helper to format entity types
-		genkit.DefineHelper(g, "entityTypes", func(types []string) string {
-			if len(types) == 0 {
-				return ""
-			}
-			if len(types) == 1 {
-				return types[0]
-			}
-			return strings.Join(types[:len(types)-1], ", ") + " and " + types[len(types)-1]
-		})
-	}
-
-	return nil
+	p.helpers.Do(p.registerHelpers)
+	p.defaults.Do(func() {
+		if p.defaultsErr = p.loadPartials(ctx); p.defaultsErr != nil {
+			return
+		}
+		if p.defaultsErr = p.loadInlinePrompts(ctx); p.defaultsErr == nil {
+			p.defaultsErr = p.loadEmbeddedPrompts(ctx)
+		}
+	})
+	return p.defaultsErr
 }
 
 // Process executes the agentic RAG flow according to the specification
 func (p *AgenticRAGProcessor) Process(ctx context.Context, request AgenticRAGRequest) (*AgenticRAGResponse, error) {
+	ctx, state := withRequestState(ctx)
+	return p.process(ctx, state, request)
+}
+
+// process runs the pipeline, emitting events through the request state, in the
+// request's span when tracing
+func (p *AgenticRAGProcessor) process(ctx context.Context, state *requestState, request AgenticRAGRequest) (*AgenticRAGResponse, error) {
+	start := time.Now()
+	p.metrics.RequestStarted()
+	state.setFlowTracer(flowTracerFrom(ctx))
+	ctx = p.startRequestSpan(ctx, state, request)
+	response, err := p.runPipeline(ctx, state, request)
+	p.endRequestSpan(state, response, err)
+	p.recordRequestMetrics(response, err, time.Since(start))
+	return response, err
+}
+
+// runPipeline runs the stages of a request
+func (p *AgenticRAGProcessor) runPipeline(ctx context.Context, state *requestState, request AgenticRAGRequest) (*AgenticRAGResponse, error) {
 	startTime := time.Now()
+	state.progress = newProgressReporter(request.Options.OnProgress)
+	defer state.progress.stop()
+	state.setTokenBudget(request.Options.MaxTotalTokens)
+	state.setLanguageHint(request.Options.Language)
+
+	if err := p.ValidateRequest(request); err != nil {
+		return nil, err
+	}
+	state.setModelOverrides(request.Options.Model, request.Options.ModelOverrides)
+	state.setPromptVariants(request.Options.PromptVariants)
+	p.assignExperiments(ctx, request)
+	state.setPromptSnapshot(p.prompts.Load())
+	state.setEntityTypeOverride(request.Options.EntityTypes)
+	if request.Options.FactVerification != nil {
+		state.setFactVerificationOverride(p.mergeFactVerification(request.Options.FactVerification))
+	}
 
 	// Set default options
 	if request.Options.MaxChunks == 0 {
@@ -124,178 +337,832 @@ func (p *AgenticRAGProcessor) Process(ctx context.Context, request AgenticRAGReq
 	if request.Options.Temperature == 0 {
 		request.Options.Temperature = 0.7 // Default temperature
 	}
+	if request.Options.Deterministic {
+		request.Options.Temperature = 0
+		state.setDeterministic()
+	}
+	if request.Options.DryRun {
+		state.setDryRun()
+	}
+	if request.Options.Debug {
+		state.setDebug()
+	}
+	warnings := p.clampOptions(ctx, &request.Options)
+	if request.Options.ResponseLanguage == "" {
+		request.Options.ResponseLanguage = detectLanguage(request.Query)
+	}
+	state.setResponseLanguage(request.Options.ResponseLanguage)
+	if request.Options.AllowDowngrade != nil && !*request.Options.AllowDowngrade {
+		state.disableDowngrades()
+	}
 
-	// Step 1: Load documents into context window
-	documents, err := p.loadDocuments(ctx, request.Documents)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load documents: %w", err)
+	// Serve identical requests from the response cache. Conversations and batches always
+	// run, since their results depend on state outside the request, dry runs are cheap
+	// enough not to cache and debug requests are after the model calls.
+	var cacheKey string
+	if p.cache != nil && request.ConversationID == "" && state.corpus == nil && !request.Options.DryRun && !request.Options.Debug {
+		key, err := responseCacheKey(request, state.promptSnapshot())
+		if err != nil {
+			return nil, err
+		}
+		cacheKey = key
+		if !request.Options.ForceRefresh {
+			response, err := p.cachedResponse(ctx, cacheKey, startTime)
+			if err == nil {
+				p.metrics.ResponseCacheLookup(response != nil)
+			}
+			if err != nil || response != nil {
+				return response, err
+			}
+		}
+	}
+
+	// Stage outputs, declared up front so a cancelled request can report what completed
+	var (
+		session             *Session
+		retrievalQuery      = request.Query
+		rewrittenQuery      string
+		documents           []Document
+		allChunks           []DocumentChunk
+		resultChunks        []DocumentChunk // Relevant chunks as of the last completed stage
+		rerank              *RerankMetadata
+		expansions          []GraphExpansion
+		graphPruned         *PruneResult
+		routedCommunities   []Community
+		recursiveLevels     int
+		stopReason          string
+		answer, cleanAnswer string
+		citations           []Citation
+		structuredAnswer    any
+		tokenCount          int
+		answerTruncated     bool
+		knowledgeGraph      *KnowledgeGraph
+		factVerification    *FactVerification
+		assessment          *answerAssessment
+		groundedness        *GroundednessReport
+		unsupportedClaims   *UnsupportedClaimsReport
+		refusal             *Refusal
+		dryRunPlan          *DryRunPlan
+		rewrite             = request.Options.EnableQueryRewrite
+		err                 error
+	)
+
+	// respond assembles the response from the stages completed so far
+	respond := func() *AgenticRAGResponse {
+		budgetExhausted, skippedStages := state.budgetReport()
+		var originalQuery string
+		if rewrite {
+			originalQuery = request.Query
+		}
+		modelCalls := 1 + recursiveLevels + 1 // identification + recursive calls + generation
+		if calls := state.modelCallCount(); calls > 0 || dryRunPlan != nil {
+			modelCalls = calls
+		}
+		chunkStrategy := p.config.Processing.ChunkStrategy
+		fellBack, breakpoints := state.chunkingReport()
+		if chunkStrategy == "" || fellBack {
+			chunkStrategy = ChunkStrategySentence
+		}
+		for i := range citations {
+			citations[i].Reason = expansionReason(expansions, citations[i].ChunkID)
+		}
+		tokensUsed := tokenCount
+		if tokens := state.tokens(); tokens > 0 {
+			tokensUsed = tokens
+		}
+
+		// Convert chunks to processed chunks format
+		processedChunks := make([]ProcessedChunk, len(resultChunks))
+		for i, chunk := range resultChunks {
+			processedChunks[i] = ProcessedChunk{
+				Chunk: chunk,
+				// Entities and Relations will be populated during knowledge graph building
+			}
+		}
+
+		var verificationReport string
+		if p.verificationReport && factVerification != nil {
+			verificationReport = factVerification.RenderMarkdown()
+		}
+
+		return &AgenticRAGResponse{
+			Answer:             answer,
+			ConversationID:     request.ConversationID,
+			CleanAnswer:        cleanAnswer,
+			Citations:          citations,
+			StructuredAnswer:   structuredAnswer,
+			RelevantChunks:     processedChunks,
+			KnowledgeGraph:     knowledgeGraph,
+			FactVerification:   factVerification,
+			Confidence:         answerConfidence(resultChunks, assessment, factVerification),
+			Refusal:            refusal,
+			Groundedness:       groundedness,
+			VerificationReport: verificationReport,
+			ProcessingMetadata: ProcessingMetadata{
+				RequestID:            state.id(),
+				ProcessingTime:       time.Since(startTime),
+				ChunksProcessed:      len(allChunks),
+				RecursiveLevels:      recursiveLevels,
+				RecursionStopReason:  stopReason,
+				ModelCalls:           modelCalls,
+				EmbeddingCalls:       state.embeddingCallCount(),
+				EmbeddingCacheHits:   state.embeddingCacheHits(),
+				EmbeddingCacheMisses: state.embeddingCacheMisses(),
+				Rerank:               rerank,
+				OriginalQuery:        originalQuery,
+				AnswerTruncated:      answerTruncated,
+				ResponseLanguage:     request.Options.ResponseLanguage,
+				RewrittenQuery:       rewrittenQuery,
+				TokensUsed:           tokensUsed,
+				ModelInstances:       state.instanceCounts(),
+				ModelsUsed:           state.models(),
+				ModelEscalations:     state.modelEscalations(),
+				TruncatedChunks:      state.truncatedChunks(),
+				ModelDowngrades:      state.modelDowngrades(),
+				Grounding:            state.groundingMetadata(),
+				ModelTime:            state.totalModelTime(),
+				ChunkErrors:          state.chunkErrors(),
+				DocumentErrors:       state.documentErrors(),
+				DocumentsFiltered:    state.filteredDocuments(),
+				ChunkStrategy:        chunkStrategy,
+				Breakpoints:          breakpoints,
+				EntityMerges:         state.entityMergeCount(),
+				GraphExpansions:      expansions,
+				GraphPruned:          graphPruned,
+				RoutedCommunities:    routedCommunities,
+				RelationValidation:   state.relationValidation(),
+				UnsupportedClaims:    unsupportedClaims,
+				ModelUsage:           state.modelUsage(),
+				StageModels:          state.stageModelsSelected(),
+				BudgetExhausted:      budgetExhausted,
+				SkippedStages:        skippedStages,
+				CompletedStages:      state.completedStages(),
+				ModelCallsByStage:    state.modelCallsByStage(),
+				PromptVariants:       state.promptVariants(),
+				PromptNames:          state.promptNames(),
+				RetrievedChunks:      state.retrievedChunkIDs(),
+				StageTimings:         state.stageTimings(),
+				Warnings:             append(warnings, state.recordedWarnings()...),
+				DryRunPlan:           dryRunPlan,
+			},
+			DebugTrace: state.debugTrace(),
+		}
+	}
+
+	// fail returns err, or a PartialResultError carrying the completed stages when the
+	// request was cancelled or timed out and allows partial results
+	fail := func(err error) (*AgenticRAGResponse, error) {
+		p.failStage(ctx, err)
+		if request.Options.AllowPartial && ctx.Err() != nil {
+			return nil, &PartialResultError{Response: respond(), Cause: context.Cause(ctx), Err: err}
+		}
+		return nil, err
 	}
 
-	// Step 2: Chunk documents into initial chunks (respecting sentence boundaries)
-	allChunks := make([]DocumentChunk, 0)
-	for _, doc := range documents {
-		chunks, err := p.chunkDocument(ctx, doc, request.Options.MaxChunks)
+	// Pick up the conversation, if any. The request's history takes precedence.
+	history := request.History
+	if request.ConversationID != "" {
+		session, err = p.sessions.Load(ctx, request.ConversationID)
 		if err != nil {
-			return nil, fmt.Errorf("failed to chunk document %s: %w", doc.ID, err)
+			return fail(fmt.Errorf("failed to load conversation %s: %w", request.ConversationID, err))
+		}
+		if session == nil {
+			session = &Session{ID: request.ConversationID}
+		}
+		if len(history) == 0 {
+			history = session.Turns
+		}
+	}
+
+	// Optionally rewrite the query for retrieval, always for follow-ups so references to
+	// earlier turns resolve. Synthesis keeps the user's wording.
+	rewrite = rewrite || len(history) > 0
+	if rewrite && !request.Options.DryRun {
+		err := runStage(ctx, StageQueryRewrite, func() (string, error) {
+			p.startStage(ctx, StageQueryRewrite, fmt.Sprintf("query %q, %d turns", request.Query, len(history)))
+			state.reportProgress(StageQueryRewrite, 0, 1)
+			rewritten, err := p.rewriteQuery(withStage(ctx, StageQueryRewrite), request.Query, historyInput(history))
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageQueryRewrite)
+			case err != nil:
+				if ctx.Err() != nil {
+					return "", fmt.Errorf("failed to rewrite query: %w", err)
+				}
+				state.skipStage(StageQueryRewrite) // Retrieval works with the original query
+			default:
+				if rewritten != "" {
+					retrievalQuery, rewrittenQuery = rewritten, rewritten
+				}
+				state.completeStage(StageQueryRewrite)
+			}
+			state.reportProgress(StageQueryRewrite, 1, 1)
+			output := fmt.Sprintf("query %q", retrievalQuery)
+			return output, p.endStage(ctx, StageQueryRewrite, output, err)
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Steps 1 & 2: Load documents into context window and chunk them (respecting sentence
+	// boundaries). A batch loads and chunks its documents once for all of its queries.
+	if corpus := state.corpus; corpus != nil {
+		documents, allChunks = corpus.documents, corpus.chunks
+		state.completeStage(StageLoading)
+		state.completeStage(StageChunking)
+	} else if len(request.Docs) == 0 && len(request.Documents) == 0 && p.retriever() != nil {
+		// Without documents the chunks come from the index, through the same stages
+		documents, allChunks, err = p.retrieveCorpus(ctx, retrievalQuery, request.Options.DocumentFilter)
+		if err != nil {
+			return fail(err)
+		}
+	} else {
+		documents, allChunks, err = p.loadCorpus(ctx, request.Docs, request.Documents, request.Options.MaxChunks, request.Options.DocumentFilter)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	// Follow-ups without documents reuse the chunks the previous answer was built from
+	if len(documents) == 0 && session != nil {
+		allChunks = append(allChunks, session.Chunks...)
+	}
+
+	// A dry run stops here with the model calls the remaining stages would make. The
+	// conversation is left as it was.
+	if request.Options.DryRun {
+		dryRunPlan = p.planDryRun(ctx, request, history, allChunks)
+		state.beginStage("")
+		return respond(), nil
+	}
+	state.emitEvent(RAGEvent{Type: EventChunkingDone, ChunkCount: len(allChunks)})
+
+	// Optionally narrow a large corpus to the chunks about the knowledge graph communities
+	// matching the query before scoring them, keeping all chunks if the budget ran out
+	if request.Options.EnableCommunityRouting && ((session != nil && session.KnowledgeGraph != nil) || p.config.KnowledgeGraph.Store != nil) {
+		err := runStage(ctx, StageCommunityRouting, func() (string, error) {
+			p.startStage(ctx, StageCommunityRouting, fmt.Sprintf("%d chunks", len(allChunks)))
+			state.reportProgress(StageCommunityRouting, 0, 1)
+			routed, communities, err := p.routeCommunities(withStage(ctx, StageCommunityRouting), retrievalQuery, request.Options, session, allChunks)
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageCommunityRouting)
+			case err != nil:
+				return "", fmt.Errorf("failed to route to communities: %w", err)
+			default:
+				allChunks, routedCommunities = routed, communities
+				state.completeStage(StageCommunityRouting)
+			}
+			state.reportProgress(StageCommunityRouting, 1, 1)
+			output := fmt.Sprintf("%d chunks, %d communities", len(allChunks), len(routedCommunities))
+			return output, p.endStage(ctx, StageCommunityRouting, output, err)
+		})
+		if err != nil {
+			return fail(err)
 		}
-		allChunks = append(allChunks, chunks...)
 	}
 
 	// Step 3: Prompt model to identify relevant chunks
-	relevantChunks, err := p.identifyRelevantChunks(ctx, request.Query, allChunks)
+	var relevantChunks []DocumentChunk
+	err = runStage(ctx, StageRelevanceScoring, func() (string, error) {
+		p.startStage(ctx, StageRelevanceScoring, fmt.Sprintf("%d chunks", len(allChunks)))
+		state.reportProgress(StageRelevanceScoring, 0, len(allChunks))
+		scored, err := p.scorer.Score(withStage(ctx, StageRelevanceScoring), retrievalQuery, ChunkSet{Documents: documents, Chunks: allChunks})
+		if err != nil {
+			return "", fmt.Errorf("failed to identify relevant chunks: %w", err)
+		}
+		relevantChunks = scored.Chunks
+		state.reportProgress(StageRelevanceScoring, len(allChunks), len(allChunks))
+		for i := range relevantChunks {
+			chunk := relevantChunks[i]
+			state.emitEvent(RAGEvent{Type: EventRelevanceScored, Chunk: &chunk})
+		}
+		resultChunks = relevantChunks
+		state.completeStage(StageRelevanceScoring)
+		output := fmt.Sprintf("%d relevant chunks", len(relevantChunks))
+		return output, p.endStage(ctx, StageRelevanceScoring, output, nil)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to identify relevant chunks: %w", err)
+		return fail(err)
+	}
+
+	// Optionally rerank the top chunks, keeping the first-pass order if the budget ran out
+	if request.Options.EnableRerank {
+		topN := request.Options.RerankTopN
+		if topN == 0 {
+			topN = p.config.Processing.RerankTopN
+		}
+		err := runStage(ctx, StageReranking, func() (string, error) {
+			p.startStage(ctx, StageReranking, fmt.Sprintf("%d chunks, top %d", len(relevantChunks), topN))
+			state.reportProgress(StageReranking, 0, min(topN, len(relevantChunks)))
+			reranked, metadata, err := p.rerankChunks(withStage(ctx, StageReranking), retrievalQuery, relevantChunks, topN)
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageReranking)
+			case err != nil:
+				return "", fmt.Errorf("failed to rerank chunks: %w", err)
+			default:
+				relevantChunks, rerank = reranked, metadata
+				resultChunks = relevantChunks
+				state.completeStage(StageReranking)
+			}
+			output := fmt.Sprintf("%d chunks", len(relevantChunks))
+			return output, p.endStage(ctx, StageReranking, output, err)
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Optionally select the chunks balancing relevance against coverage
+	if request.Options.EnableDiversification {
+		err := runStage(ctx, StageDiversification, func() (string, error) {
+			p.startStage(ctx, StageDiversification, fmt.Sprintf("%d chunks, top %d", len(relevantChunks), request.Options.MaxChunks))
+			state.reportProgress(StageDiversification, 0, 1)
+			selected, displaced, err := p.diversifyChunks(withStage(ctx, StageDiversification), relevantChunks, request.Options.MaxChunks, p.diversityLambda(request.Options))
+			if err != nil {
+				return "", fmt.Errorf("failed to diversify chunks: %w", err)
+			}
+			relevantChunks = selected
+			resultChunks = relevantChunks
+			state.recordDisplacedChunks(displaced)
+			state.completeStage(StageDiversification)
+			state.reportProgress(StageDiversification, 1, 1)
+			output := fmt.Sprintf("%d chunks, %d displaced", len(selected), len(displaced))
+			return output, p.endStage(ctx, StageDiversification, output, nil)
+		})
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	// Optionally add chunks about entities related to those in the query
+	if request.Options.EnableGraphExpansion && ((session != nil && session.KnowledgeGraph != nil) || p.config.KnowledgeGraph.Store != nil) {
+		err := runStage(ctx, StageGraphExpansion, func() (string, error) {
+			p.startStage(ctx, StageGraphExpansion, fmt.Sprintf("%d chunks", len(relevantChunks)))
+			state.reportProgress(StageGraphExpansion, 0, 1)
+			expanded, added, err := p.expandWithGraph(withStage(ctx, StageGraphExpansion), retrievalQuery, request.Options, session, relevantChunks, allChunks)
+			if err != nil {
+				return "", fmt.Errorf("failed to expand chunks with the knowledge graph: %w", err)
+			}
+			relevantChunks, expansions = expanded, added
+			resultChunks = relevantChunks
+			state.completeStage(StageGraphExpansion)
+			state.reportProgress(StageGraphExpansion, 1, 1)
+			output := fmt.Sprintf("%d chunks added", len(added))
+			return output, p.endStage(ctx, StageGraphExpansion, output, nil)
+		})
+		if err != nil {
+			return fail(err)
+		}
 	}
 
 	// Step 4 & 5: Recursively drill down into selected chunks
-	finalChunks, recursiveLevels, err := p.recursivelyRefineChunks(ctx, request.Query, relevantChunks, request.Options.RecursiveDepth)
+	var finalChunks []DocumentChunk
+	err = runStage(ctx, StageRefinement, func() (string, error) {
+		p.startStage(ctx, StageRefinement, fmt.Sprintf("%d chunks, depth %d", len(relevantChunks), request.Options.RecursiveDepth))
+		state.reportProgress(StageRefinement, 0, len(relevantChunks))
+		refined, levels, reason, err := p.recursivelyRefineChunks(withStage(ctx, StageRefinement), retrievalQuery, relevantChunks, request.Options.RecursiveDepth)
+		if err != nil {
+			return "", fmt.Errorf("failed to recursively refine chunks: %w", err)
+		}
+		finalChunks = refined
+		resultChunks, recursiveLevels, stopReason = finalChunks, levels, reason
+		state.completeStage(StageRefinement)
+		output := fmt.Sprintf("%d chunks after %d levels", len(finalChunks), levels)
+		return output, p.endStage(ctx, StageRefinement, output, nil)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to recursively refine chunks: %w", err)
+		return fail(err)
 	}
 
-	// Step 6: Generate response based on retrieved information
-	answer, tokenCount, err := p.generateResponse(ctx, request.Query, finalChunks, request.Options)
+	// Step 6: Generate response based on retrieved information, shrinking the context
+	// if it won't fit the model or the token budget
+	var (
+		synthesisChunks []DocumentChunk
+		documentIndex   map[string]int
+	)
+	err = runStage(ctx, StageGeneration, func() (string, error) {
+		p.startStage(ctx, StageGeneration, fmt.Sprintf("%d chunks", len(finalChunks)))
+		state.reportProgress(StageGeneration, 0, 1)
+		generationCtx := withStage(ctx, StageGeneration)
+		var err error
+		synthesisChunks, err = p.fitContext(generationCtx, request.Query, finalChunks)
+		if err != nil {
+			return "", fmt.Errorf("failed to fit context: %w", err)
+		}
+		synthesisChunks, err = p.fitBudget(generationCtx, request.Query, synthesisChunks)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate response: %w", err)
+		}
+		documentIndex = make(map[string]int, len(documents))
+		for i, doc := range documents {
+			documentIndex[doc.ID] = i
+		}
+		synthesis, err := p.synthesizer.Synthesize(generationCtx, SynthesisInput{
+			Query:     request.Query,
+			Chunks:    synthesisChunks,
+			Documents: documents,
+			Options:   request.Options,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to generate response: %w", err)
+		}
+		generated := synthesis.Answer
+		if request.Options.ResponseFormat != ResponseFormatJSON && request.Options.AnswerMode != AnswerModeExtractive {
+			generated, answerTruncated = truncateAnswer(generated, request.Options.MaxAnswerTokens, request.Options.TargetLength, request.Options.Language)
+		}
+
+		// Resolve citation markers against the chunks the answer was built from, unless
+		// the synthesizer cited its sources itself, as extractive answers do
+		clean, cited := synthesis.CleanAnswer, synthesis.Citations
+		if request.Options.EnableCitations && request.Options.AnswerMode != AnswerModeExtractive && cited == nil {
+			generated, clean, cited = extractCitations(generated, synthesisChunks, documentIndex)
+		}
+		answer, cleanAnswer, citations, structuredAnswer, tokenCount = generated, clean, cited, synthesis.StructuredAnswer, synthesis.Tokens
+		state.reportProgress(StageGeneration, 1, 1)
+		state.completeStage(StageGeneration)
+		output := fmt.Sprintf("%d characters, %d citations", len(answer), len(citations))
+		return output, p.endStage(ctx, StageGeneration, output, nil)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate response: %w", err)
+		return fail(err)
+	}
+
+	// Optionally check each sentence of a prose answer against the chunks, removing or
+	// hedging the unsupported ones. Citations are resolved again for the edited answer.
+	if request.Options.CheckGroundedness && answer != "" && request.Options.ResponseFormat != ResponseFormatJSON && request.Options.AnswerMode != AnswerModeExtractive {
+		err := runStage(ctx, StageGroundedness, func() (string, error) {
+			p.startStage(ctx, StageGroundedness, fmt.Sprintf("%d characters, %d chunks", len(answer), len(synthesisChunks)))
+			state.reportProgress(StageGroundedness, 0, 1)
+			report, checked, err := p.checkGroundedness(withStage(ctx, StageGroundedness), answer, synthesisChunks, request.Options.UngroundedPolicy, request.Options.ResponseLanguage)
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageGroundedness)
+			case err != nil:
+				return "", err
+			default:
+				groundedness = report
+				if checked != answer {
+					answer = checked
+					if request.Options.EnableCitations {
+						answer, cleanAnswer, citations = extractCitations(answer, synthesisChunks, documentIndex)
+					}
+				}
+				state.completeStage(StageGroundedness)
+			}
+			state.reportProgress(StageGroundedness, 1, 1)
+			output := groundednessSummary(groundedness)
+			return output, p.endStage(ctx, StageGroundedness, output, err)
+		})
+		if err != nil {
+			return fail(err)
+		}
 	}
 
-	// Step 7: Build knowledge graph if enabled
-	var knowledgeGraph *KnowledgeGraph
-	if request.Options.EnableKnowledgeGraph && p.config.KnowledgeGraph.Enabled {
-		knowledgeGraph, err = p.buildKnowledgeGraph(ctx, finalChunks)
+	// Withhold an answer the chunks don't support, before spending tokens on the
+	// knowledge graph and fact verification. A failed self-assessment leaves the
+	// confidence to chunk relevance alone.
+	if minConfidence := request.Options.MinAnswerConfidence; minConfidence > 0 {
+		err := runStage(ctx, StageAnswerAssessment, func() (string, error) {
+			p.startStage(ctx, StageAnswerAssessment, fmt.Sprintf("%d characters, %d chunks", len(answer), len(synthesisChunks)))
+			state.reportProgress(StageAnswerAssessment, 0, 1)
+			assessed, err := p.assessAnswer(withStage(ctx, StageAnswerAssessment), request.Query, answer, synthesisChunks)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return "", fmt.Errorf("failed to assess answer: %w", err)
+			case err != nil:
+				state.skipStage(StageAnswerAssessment)
+			default:
+				assessment = assessed
+				state.completeStage(StageAnswerAssessment)
+			}
+			state.reportProgress(StageAnswerAssessment, 1, 1)
+			output := assessmentSummary(assessment)
+			return output, p.endStage(ctx, StageAnswerAssessment, output, err)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to build knowledge graph: %w", err)
+			return fail(err)
+		}
+
+		if confidence := answerConfidence(finalChunks, assessment, nil); confidence < minConfidence {
+			refusal = newRefusal(confidence, minConfidence, finalChunks, assessment)
+			answer, cleanAnswer, citations, structuredAnswer = "", "", nil, nil
 		}
 	}
 
-	// Step 8: Verify answer for factual accuracy if enabled
-	var factVerification *FactVerification
-	if request.Options.EnableFactVerification {
-		factVerification, err = p.verifyFacts(ctx, answer, finalChunks)
+	// Step 7: Build knowledge graph if enabled, skipped if the token budget ran out
+	if request.Options.EnableKnowledgeGraph && p.config.KnowledgeGraph.Enabled && refusal == nil {
+		err := runStage(ctx, StageKnowledgeGraph, func() (string, error) {
+			p.startStage(ctx, StageKnowledgeGraph, fmt.Sprintf("%d chunks", len(finalChunks)))
+			state.reportProgress(StageKnowledgeGraph, 0, 1)
+			graph, err := p.extractKnowledgeGraph(withStage(ctx, StageKnowledgeGraph), retrievalQuery, finalChunks, documents)
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageKnowledgeGraph)
+			case err != nil:
+				return "", fmt.Errorf("failed to build knowledge graph: %w", err)
+			default:
+				knowledgeGraph = graph
+				limits := p.config.KnowledgeGraph
+				if pruned := knowledgeGraph.prune(0, limits.MaxEntities, limits.MaxRelations); pruned.Entities > 0 || pruned.Relations > 0 {
+					graphPruned = &pruned
+				}
+				if p.config.KnowledgeGraph.EnableCommunities {
+					knowledgeGraph.Communities = p.buildCommunities(withStage(ctx, StageKnowledgeGraph), knowledgeGraph)
+				}
+				state.emitEvent(RAGEvent{Type: EventKnowledgeGraphUpdated, KnowledgeGraph: knowledgeGraph})
+				state.completeStage(StageKnowledgeGraph)
+			}
+			state.reportProgress(StageKnowledgeGraph, 1, 1)
+			output := knowledgeGraphSummary(knowledgeGraph)
+			return output, p.endStage(ctx, StageKnowledgeGraph, output, err)
+		})
 		if err != nil {
-			return nil, fmt.Errorf("failed to verify facts: %w", err)
+			return fail(err)
 		}
 	}
 
-	// Convert chunks to processed chunks format
-	processedChunks := make([]ProcessedChunk, len(finalChunks))
-	for i, chunk := range finalChunks {
-		processedChunks[i] = ProcessedChunk{
-			Chunk: chunk,
-			// Entities and Relations will be populated during knowledge graph building
+	// Step 8: Verify answer for factual accuracy if enabled, skipped if the token budget ran out.
+	// When evidence is required, the unsupported claim policy then applies to the answer,
+	// and citations are resolved again for an edited one.
+	if request.Options.EnableFactVerification && refusal == nil {
+		err := runStage(ctx, StageFactVerification, func() (string, error) {
+			p.startStage(ctx, StageFactVerification, fmt.Sprintf("%d characters, %d chunks", len(answer), len(finalChunks)))
+			state.reportProgress(StageFactVerification, 0, 1)
+			verification, err := p.verifier.Verify(withStage(ctx, StageFactVerification), VerificationInput{
+				Answer:        answer,
+				Chunks:        finalChunks,
+				Documents:     documents,
+				Language:      request.Options.ResponseLanguage,
+				DatedFacts:    datedFacts(knowledgeGraph),
+				ForceReverify: request.Options.ForceReverify,
+			})
+			if err == nil && request.Options.ExternalVerification {
+				p.verifyExternally(withStage(ctx, StageFactVerification), verification)
+			}
+			switch {
+			case errors.Is(err, ErrBudgetExceeded):
+				state.skipStage(StageFactVerification)
+			case err != nil:
+				return "", fmt.Errorf("failed to verify facts: %w", err)
+			default:
+				factVerification = verification
+				if config := p.factVerification(ctx); config.RequireEvidence {
+					policy := config.UnsupportedClaims
+					if request.Options.ResponseFormat == ResponseFormatJSON && (policy == UnsupportedClaimPolicyFlag || policy == UnsupportedClaimPolicyRemove) {
+						state.recordWarning(fmt.Sprintf("unsupported claim policy %q applies to prose answers only, unsupported claims kept", policy))
+						policy = UnsupportedClaimPolicyKeep
+					}
+					enforced, report, err := p.enforceEvidence(withStage(ctx, StageFactVerification), answer, verification, policy, request.Options.ResponseLanguage)
+					unsupportedClaims = report
+					if err != nil {
+						return "", err
+					}
+					if enforced != answer {
+						answer = enforced
+						if request.Options.EnableCitations {
+							answer, cleanAnswer, citations = extractCitations(answer, synthesisChunks, documentIndex)
+						}
+					}
+				}
+				state.completeStage(StageFactVerification)
+			}
+			state.reportProgress(StageFactVerification, 1, 1)
+			output := factVerificationSummary(factVerification)
+			return output, p.endStage(ctx, StageFactVerification, output, err)
+		})
+		if err != nil {
+			return fail(err)
 		}
 	}
+	state.beginStage("")
 
-	return &AgenticRAGResponse{
-		Answer:           answer,
-		RelevantChunks:   processedChunks,
-		KnowledgeGraph:   knowledgeGraph,
-		FactVerification: factVerification,
-		ProcessingMetadata: ProcessingMetadata{
-			ProcessingTime:  time.Since(startTime),
-			ChunksProcessed: len(allChunks),
-			RecursiveLevels: recursiveLevels,
-			ModelCalls:      1 + recursiveLevels + 1, // identification + recursive calls + generation
-			TokensUsed:      tokenCount,
-		},
-	}, nil
+	// Record the turn and merge the new graph into the conversation's
+	if session != nil {
+		content := answer
+		if cleanAnswer != "" {
+			content = cleanAnswer
+		}
+		if refusal != nil {
+			content = strings.Join(append([]string{refusal.Reason}, refusal.ClarifyingQuestions...), "\n")
+		}
+		session.Turns = append(session.Turns,
+			Turn{Role: TurnRoleUser, Content: request.Query},
+			Turn{Role: TurnRoleAssistant, Content: content})
+		if maxTurns := p.config.Sessions.MaxTurns; maxTurns > 0 && len(session.Turns) > maxTurns {
+			session.Turns = session.Turns[len(session.Turns)-maxTurns:]
+		}
+		session.Chunks = finalChunks
+		if knowledgeGraph != nil {
+			if session.KnowledgeGraph == nil {
+				session.KnowledgeGraph = &KnowledgeGraph{}
+			}
+			session.KnowledgeGraph.Merge(knowledgeGraph, MergePolicyMax)
+		}
+		if err := p.sessions.Save(ctx, session); err != nil {
+			return fail(fmt.Errorf("failed to save conversation %s: %w", session.ID, err))
+		}
+	}
+
+	response := respond()
+	if cacheKey != "" {
+		if err := p.cacheResponse(ctx, cacheKey, response); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
 }
 
-// loadDocuments loads documents from various sources
-func (p *AgenticRAGProcessor) loadDocuments(ctx context.Context, sources []string) ([]Document, error) {
-	documents := make([]Document, 0, len(sources))
+// loadDocuments loads the structured documents followed by the legacy documents,
+// assigning IDs to documents that don't have one, fetching URL documents and
+// extracting the text of PDFs
+func (p *AgenticRAGProcessor) loadDocuments(ctx context.Context, docs []Document, sources []string) ([]Document, error) {
+	documents := make([]Document, 0, len(docs)+len(sources))
+	documents = append(documents, docs...)
+	for _, source := range sources {
+		// Legacy documents are raw text, or a bare URL to fetch
+		if isHTTPURL(source) {
+			documents = append(documents, Document{Source: source})
+		} else {
+			documents = append(documents, Document{Content: source})
+		}
+	}
 
-	for i, source := range sources {
-		doc := Document{
-			ID:      fmt.Sprintf("doc_%d", i),
-			Content: source, // For MVP, treat as raw text
-			Source:  source,
-			Metadata: map[string]interface{}{
-				"loaded_at": time.Now(),
-			},
+	seen := make(map[string]bool, len(documents))
+	for i := range documents {
+		doc := &documents[i]
+		if doc.ID == "" {
+			doc.ID = fmt.Sprintf("doc_%d", i)
+		}
+		if seen[doc.ID] {
+			return nil, fmt.Errorf("duplicate document ID %q", doc.ID)
 		}
-		documents = append(documents, doc)
+		seen[doc.ID] = true
+
+		// Copy the caller's metadata before adding to it
+		metadata := make(map[string]interface{}, len(doc.Metadata)+1)
+		for key, value := range doc.Metadata {
+			metadata[key] = value
+		}
+		metadata["loaded_at"] = time.Now()
+		doc.Metadata = metadata
+	}
+
+	documents, err := p.fetchDocuments(ctx, documents)
+	if err != nil {
+		return nil, err
 	}
+	return p.ingestPDFs(ctx, documents)
+}
+
+// attributeEntities records which documents each knowledge graph entity appears in
+func attributeEntities(kg *KnowledgeGraph, chunks []DocumentChunk) {
+	if kg == nil {
+		return
+	}
+
+	for i := range kg.Entities {
+		entity := &kg.Entities[i]
+		name := strings.ToLower(entity.Name)
+		if name == "" {
+			continue
+		}
 
-	return documents, nil
+		seen := make(map[string]bool)
+		for _, chunk := range chunks {
+			if chunk.DocumentID == "" || seen[chunk.DocumentID] {
+				continue
+			}
+			if strings.Contains(strings.ToLower(chunk.Content), name) {
+				seen[chunk.DocumentID] = true
+				entity.DocumentIDs = append(entity.DocumentIDs, chunk.DocumentID)
+			}
+		}
+	}
 }
 
-// chunkDocument breaks a document into chunks respecting sentence boundaries
+// chunkDocument breaks a document into chunks respecting sentence boundaries, or
+// markdown structure or topic shifts with those strategies. Paged documents are
+// chunked page by page so no chunk spans a page break.
 func (p *AgenticRAGProcessor) chunkDocument(ctx context.Context, doc Document, maxChunks int) ([]DocumentChunk, error) {
+	switch p.config.Processing.ChunkStrategy {
+	case ChunkStrategyMarkdown:
+		if len(doc.Pages) == 0 {
+			return p.chunkMarkdown(doc, maxChunks, requestStateFrom(ctx).languageHint()), nil
+		}
+	case ChunkStrategySemantic:
+		// Without a working embedder, fall back to sentence chunking. Dry runs don't
+		// call the embedder either.
+		state := requestStateFrom(ctx)
+		if embedder := p.embedder(); embedder != nil && len(doc.Pages) == 0 && !state.isDryRun() {
+			chunks, breakpoints, err := p.chunkSemantic(ctx, embedder, doc, maxChunks)
+			if err == nil {
+				state.recordBreakpoints(breakpoints)
+				return chunks, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+		}
+		state.recordChunkFallback()
+	}
+	if len(doc.Pages) == 0 {
+		return p.chunkText(doc, doc.Content, 0, 0, maxChunks, requestStateFrom(ctx).languageHint()), nil
+	}
+
+	chunks := make([]DocumentChunk, 0)
+	offset := 0
+	for _, page := range doc.Pages {
+		if len(chunks) >= maxChunks {
+			break
+		}
+		for _, chunk := range p.chunkText(doc, page.Content, offset, len(chunks), maxChunks-len(chunks), requestStateFrom(ctx).languageHint()) {
+			chunk.Page = page.Number
+			chunks = append(chunks, chunk)
+		}
+		offset += len(page.Content) + 1 // Pages are separated by a form feed
+	}
+	return chunks, nil
+}
+
+// chunkText chunks a section of a document starting at the given character offset
+// and chunk index, segmenting sentences with the optional language hint. Chunk content
+// is a slice of the section, so the start and end indexes locate it in the document.
+func (p *AgenticRAGProcessor) chunkText(doc Document, content string, offset, chunkIndex, maxChunks int, lang string) []DocumentChunk {
 	chunkSize := p.config.Processing.DefaultChunkSize
-	content := doc.Content
+	size := p.chunkMeasure()
 
 	// Simple sentence-aware chunking
-	sentences := p.splitIntoSentences(content)
+	sentences := p.splitIntoSentences(content, lang)
 	chunks := make([]DocumentChunk, 0)
 
-	currentChunk := ""
-	currentStart := 0
-	chunkIndex := 0
+	currentStart, currentEnd := -1, 0
+	emit := func() {
+		chunks = append(chunks, DocumentChunk{
+			ID:         fmt.Sprintf("%s_chunk_%d", doc.ID, chunkIndex),
+			Content:    content[currentStart:currentEnd],
+			DocumentID: doc.ID,
+			ChunkIndex: chunkIndex,
+			StartIndex: offset + currentStart,
+			EndIndex:   offset + currentEnd,
+		})
+		chunkIndex++
+	}
 
 	for _, sentence := range sentences {
 		// If adding this sentence would exceed chunk size, finalize current chunk
-		if len(currentChunk)+len(sentence) > chunkSize && currentChunk != "" {
-			chunk := DocumentChunk{
-				ID:         fmt.Sprintf("%s_chunk_%d", doc.ID, chunkIndex),
-				Content:    strings.TrimSpace(currentChunk),
-				DocumentID: doc.ID,
-				ChunkIndex: chunkIndex,
-				StartIndex: currentStart,
-				EndIndex:   currentStart + len(currentChunk),
-			}
-			chunks = append(chunks, chunk)
-
-			// Start new chunk
-			chunkIndex++
-			currentStart = currentStart + len(currentChunk)
-			currentChunk = sentence + " "
+		if currentStart >= 0 && size(content[currentStart:sentence.start])+size(sentence.text) > chunkSize {
+			emit()
+			currentStart = -1
 
 			// Stop if we've reached max chunks
 			if len(chunks) >= maxChunks {
 				break
 			}
-		} else {
-			currentChunk += sentence + " "
 		}
+		if currentStart < 0 {
+			currentStart = sentence.start
+		}
+		currentEnd = sentence.end
 	}
 
 	// Add final chunk if it has content
-	if currentChunk != "" && len(chunks) < maxChunks {
-		chunk := DocumentChunk{
-			ID:         fmt.Sprintf("%s_chunk_%d", doc.ID, chunkIndex),
-			Content:    strings.TrimSpace(currentChunk),
-			DocumentID: doc.ID,
-			ChunkIndex: chunkIndex,
-			StartIndex: currentStart,
-			EndIndex:   currentStart + len(currentChunk),
-		}
-		chunks = append(chunks, chunk)
+	if currentStart >= 0 && len(chunks) < maxChunks {
+		emit()
 	}
 
-	return chunks, nil
+	return chunks
 }
 
-// splitIntoSentences splits text into sentences using simple regex
-func (p *AgenticRAGProcessor) splitIntoSentences(text string) []string {
-	// Simple sentence splitting regex
-	sentenceRegex := regexp.MustCompile(`[.!?]+\s+`)
-	sentences := sentenceRegex.Split(text, -1)
+// chunkMeasure returns the function measuring chunk sizes in the configured unit, tokens being
+// estimated the same way as when fitting chunks into the synthesis context
+func (p *AgenticRAGProcessor) chunkMeasure() func(string) int {
+	if p.config.Processing.ChunkUnit == ChunkUnitTokens {
+		return estimateTokens
+	}
+	return func(text string) int { return len(text) }
+}
+
+// splitIntoSentences splits text into trimmed sentences with their byte ranges in
+// text, see segmentSentences
+func (p *AgenticRAGProcessor) splitIntoSentences(text, lang string) []prosePart {
+	sentences := segmentSentences(text, lang)
 
 	// Filter out empty sentences
-	result := make([]string, 0, len(sentences))
+	result := make([]prosePart, 0, len(sentences))
 	for _, sentence := range sentences {
-		sentence = strings.TrimSpace(sentence)
-		if sentence != "" {
-			result = append(result, sentence)
+		if trimmed := strings.TrimSpace(sentence.text); trimmed != "" {
+			start := sentence.start + strings.Index(sentence.text, trimmed)
+			result = append(result, prosePart{text: trimmed, start: start, end: start + len(trimmed)})
 		}
 	}
 
 	return result
 }
 
-// identifyRelevantChunks uses LLM to identify which chunks are most relevant to the query
-func (p *AgenticRAGProcessor) identifyRelevantChunks(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
+// scoreChunksWithLLM uses LLM to identify which chunks are most relevant to the query
+func (p *AgenticRAGProcessor) scoreChunksWithLLM(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
 	if len(chunks) == 0 {
 		return chunks, nil
 	}
@@ -305,33 +1172,27 @@ func (p *AgenticRAGProcessor) identifyRelevantChunks(ctx context.Context, query
 		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
 	}
 
-	// Prepare chunk content for prompt
+	// Prepare chunk content for prompt, prefixed with where the chunk came from
 	chunkTexts := make([]string, len(chunks))
 	for i, chunk := range chunks {
 		chunkTexts[i] = chunk.Content
+		if label := chunkLabel(chunk); label != "" {
+			chunkTexts[i] = fmt.Sprintf("[%s]\n%s", label, chunk.Content)
+		}
 	}
 
-	// Get the prompt variant to use (default or configured variant)
-	promptName := p.config.Prompts.RelevanceScoringPrompt
-	if variant, exists := p.config.Prompts.Variants["relevance_scoring"]; exists {
-		promptName = fmt.Sprintf("%s.%s", promptName, variant)
-	}
-
-	// Lookup the dotprompt
-	relevancePrompt := genkit.LookupPrompt(p.config.Genkit, promptName)
+	relevancePrompt := p.lookupPrompt(ctx, "relevance_scoring", p.config.Prompts.RelevanceScoringPrompt)
 	if relevancePrompt == nil {
 		// Fallback to hardcoded prompt if dotprompt not found
 		return p.identifyRelevantChunksFallback(ctx, query, chunks)
 	}
 
 	// Execute the prompt with proper input
-	response, err := relevancePrompt.Execute(ctx,
-		ai.WithInput(map[string]any{
-			"query":      query,
-			"chunks":     chunkTexts,
-			"max_chunks": p.config.Processing.DefaultMaxChunks,
-		}),
-	)
+	response, err := p.executePrompt(ctx, relevancePrompt, map[string]any{
+		"query":      query,
+		"chunks":     chunkTexts,
+		"max_chunks": p.config.Processing.DefaultMaxChunks,
+	})
 	if err != nil {
 		// Fallback to simple scoring if LLM fails
 		return p.fallbackRelevanceScoring(query, chunks), nil
@@ -360,6 +1221,10 @@ Document Chunks:
 `, query)
 
 	for i, chunk := range chunks {
+		if label := chunkLabel(chunk); label != "" {
+			prompt += fmt.Sprintf("\n[%d] (%s) %s", i, label, chunk.Content)
+			continue
+		}
 		prompt += fmt.Sprintf("\n[%d] %s", i, chunk.Content)
 	}
 
@@ -371,31 +1236,10 @@ Only include chunks with score > 0.3. Order by relevance score (highest first).
 Example: [{"index": 2, "score": 0.9}, {"index": 0, "score": 0.7}]`
 
 	// Use genkit.Generate to get LLM response
-	model := p.config.Model
-	var response *ai.ModelResponse
-	var err error
-
-	if model == nil {
-		// Use model by name if no model instance available
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModelName(p.config.ModelName),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.1, // Low temperature for consistent scoring
-				MaxOutputTokens: 1000,
-			}),
-		)
-	} else {
-		// Use model instance
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModel(model),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.1, // Low temperature for consistent scoring
-				MaxOutputTokens: 1000,
-			}),
-		)
-	}
+	response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+		Temperature:     0.1, // Low temperature for consistent scoring
+		MaxOutputTokens: 1000,
+	})
 
 	if err != nil {
 		// Final fallback to simple keyword matching
@@ -446,9 +1290,7 @@ func (p *AgenticRAGProcessor) parseRelevanceResponseData(responseData map[string
 	}
 
 	// Sort by relevance score (highest first)
-	sort.Slice(relevantChunks, func(i, j int) bool {
-		return relevantChunks[i].RelevanceScore > relevantChunks[j].RelevanceScore
-	})
+	sortByRelevance(relevantChunks)
 
 	return relevantChunks, nil
 }
@@ -478,9 +1320,7 @@ func (p *AgenticRAGProcessor) parseRelevanceResponse(responseText string, chunks
 	}
 
 	// Sort by relevance score (highest first)
-	sort.Slice(relevantChunks, func(i, j int) bool {
-		return relevantChunks[i].RelevanceScore > relevantChunks[j].RelevanceScore
-	})
+	sortByRelevance(relevantChunks)
 
 	// Return top chunks (up to half for recursive refinement)
 	maxRelevant := len(chunks) / 2
@@ -504,9 +1344,7 @@ func (p *AgenticRAGProcessor) fallbackRelevanceScoring(query string, chunks []Do
 	}
 
 	// Sort by relevance score (highest first)
-	sort.Slice(relevantChunks, func(i, j int) bool {
-		return relevantChunks[i].RelevanceScore > relevantChunks[j].RelevanceScore
-	})
+	sortByRelevance(relevantChunks)
 
 	// Return top chunks (up to half for recursive refinement)
 	maxRelevant := len(chunks) / 2
@@ -532,46 +1370,10 @@ func (p *AgenticRAGProcessor) calculateRelevanceScore(query, content string) flo
 	return float64(matches) / float64(len(queryWords))
 }
 
-// recursivelyRefineChunks recursively drills down into chunks for more granular information
-func (p *AgenticRAGProcessor) recursivelyRefineChunks(ctx context.Context, query string, chunks []DocumentChunk, maxDepth int) ([]DocumentChunk, int, error) {
-	if maxDepth <= 0 || len(chunks) == 0 {
-		return chunks, 0, nil
-	}
-
-	// For each chunk, break it down further if it's still too large
-	refinedChunks := make([]DocumentChunk, 0)
-	currentDepth := 0
-
-	for _, chunk := range chunks {
-		// If chunk is large enough, break it down further
-		if len(chunk.Content) > 200 { // Paragraph-level threshold
-			subChunks := p.breakdownChunk(chunk)
-
-			// Recursively process sub-chunks
-			if len(subChunks) > 1 {
-				relevantSubChunks, _ := p.identifyRelevantChunks(ctx, query, subChunks)
-				if len(relevantSubChunks) > 0 {
-					furtherRefined, depth, _ := p.recursivelyRefineChunks(ctx, query, relevantSubChunks, maxDepth-1)
-					refinedChunks = append(refinedChunks, furtherRefined...)
-					if depth+1 > currentDepth {
-						currentDepth = depth + 1
-					}
-					continue
-				}
-			}
-		}
-
-		// If we can't break it down further or it's already small, keep as is
-		refinedChunks = append(refinedChunks, chunk)
-	}
-
-	return refinedChunks, currentDepth, nil
-}
-
 // breakdownChunk breaks a chunk into smaller sub-chunks
-func (p *AgenticRAGProcessor) breakdownChunk(chunk DocumentChunk) []DocumentChunk {
+func (p *AgenticRAGProcessor) breakdownChunk(chunk DocumentChunk, lang string) []DocumentChunk {
 	// Break into sentences for paragraph-level content
-	sentences := p.splitIntoSentences(chunk.Content)
+	sentences := p.splitIntoSentences(chunk.Content, lang)
 
 	if len(sentences) <= 1 {
 		return []DocumentChunk{chunk}
@@ -580,12 +1382,14 @@ func (p *AgenticRAGProcessor) breakdownChunk(chunk DocumentChunk) []DocumentChun
 	subChunks := make([]DocumentChunk, 0, len(sentences))
 	for idx, sentence := range sentences {
 		subChunk := DocumentChunk{
-			ID:         fmt.Sprintf("%s_sub_%d", chunk.ID, idx),
-			Content:    sentence,
-			DocumentID: chunk.DocumentID,
-			ChunkIndex: chunk.ChunkIndex*100 + idx, // Hierarchical indexing
-			StartIndex: chunk.StartIndex,           // Simplified for MVP
-			EndIndex:   chunk.EndIndex,             // Simplified for MVP
+			ID:          fmt.Sprintf("%s_sub_%d", chunk.ID, idx),
+			Content:     sentence.text,
+			DocumentID:  chunk.DocumentID,
+			ChunkIndex:  chunk.ChunkIndex*100 + idx, // Hierarchical indexing
+			StartIndex:  chunk.StartIndex + sentence.start,
+			EndIndex:    chunk.StartIndex + sentence.end,
+			Page:        chunk.Page,
+			HeadingPath: chunk.HeadingPath,
 		}
 		subChunks = append(subChunks, subChunk)
 	}
@@ -608,37 +1412,54 @@ func (p *AgenticRAGProcessor) generateResponse(ctx context.Context, query string
 	contextChunks := make([]map[string]any, len(chunks))
 	for i, chunk := range chunks {
 		contextChunks[i] = map[string]any{
+			"marker":          i + 1,
 			"content":         chunk.Content,
 			"source":          fmt.Sprintf("Source %d", i+1),
 			"relevance_score": chunk.RelevanceScore,
 		}
 	}
 
-	// Get the prompt variant to use
-	promptName := p.config.Prompts.ResponseGenerationPrompt
-	if variant, exists := p.config.Prompts.Variants["response_generation"]; exists {
-		promptName = fmt.Sprintf("%s.%s", promptName, variant)
-	}
-
-	// Lookup the dotprompt
-	responsePrompt := genkit.LookupPrompt(p.config.Genkit, promptName)
+	responsePrompt := p.lookupPrompt(ctx, "response_generation", p.config.Prompts.ResponseGenerationPrompt)
 	if responsePrompt == nil {
 		// Fallback to hardcoded prompt if dotprompt not found
 		return p.generateResponseFallback(ctx, query, chunks, options)
 	}
 
-	// Execute the prompt with proper input
-	response, err := responsePrompt.Execute(ctx,
-		ai.WithInput(map[string]any{
-			"query":            query,
-			"context_chunks":   contextChunks,
-			"enable_citations": true,
-		}),
-	)
+	// Execute the prompt with proper input, grounded with Google Search if requested
+	input := map[string]any{
+		"query":             query,
+		"context_chunks":    contextChunks,
+		"enable_citations":  true,
+		"citation_markers":  options.EnableCitations,
+		"plain_text":        options.ResponseFormat == ResponseFormatPlain,
+		"style":             styleInstruction(options.Style),
+		"length":            lengthInstruction(options),
+		"response_language": languageName(options.ResponseLanguage),
+	}
+	grounded := p.config.Grounding.Enabled
+	if options.EnableGrounding != nil {
+		grounded = *options.EnableGrounding
+	}
+	var config any
+	if grounded {
+		config = p.groundedPromptConfig(ctx, responsePrompt, input, options.MaxAnswerTokens)
+	}
+	if config == nil && options.MaxAnswerTokens > 0 {
+		config = &ai.GenerationCommonConfig{
+			Temperature:     float64(options.Temperature),
+			MaxOutputTokens: options.MaxAnswerTokens,
+		}
+	}
+	var onText func(string)
+	if state := requestStateFrom(ctx); state.streaming() {
+		onText = answerDeltas(state)
+	}
+	response, err := p.executePromptStream(ctx, responsePrompt, input, config, onText)
 	if err != nil {
 		// Fallback if LLM fails
 		return p.generateResponseFallback(ctx, query, chunks, options)
 	}
+	requestStateFrom(ctx).recordGrounding(groundingFromResponse(response))
 
 	// Parse the structured response
 	var responseData map[string]any
@@ -663,7 +1484,23 @@ func (p *AgenticRAGProcessor) generateResponseFallback(ctx context.Context, quer
 	contextBuilder.WriteString("Based on the following relevant information:\n\n")
 
 	for i, chunk := range chunks {
-		contextBuilder.WriteString(fmt.Sprintf("Source %d:\n%s\n\n", i+1, chunk.Content))
+		contextBuilder.WriteString(fmt.Sprintf("Source [%d]:\n%s\n\n", i+1, chunk.Content))
+	}
+
+	citationInstruction := `Cite which sources support your statements (e.g., "According to Source 1...")`
+	if options.EnableCitations {
+		citationInstruction = "Cite sources with the bracketed marker shown for each source (e.g., [1] or [1][3]) directly after the statement it supports"
+	}
+
+	formatInstruction := "Format the answer in markdown where it helps readability"
+	if options.ResponseFormat == ResponseFormatPlain {
+		formatInstruction = "Write plain text without markdown formatting such as headings, lists, bold or code blocks"
+	}
+
+	for _, instruction := range []string{styleInstruction(options.Style), lengthInstruction(options), languageInstruction(options.ResponseLanguage)} {
+		if instruction != "" {
+			formatInstruction += "\n- " + instruction
+		}
 	}
 
 	// Create a sophisticated prompt for response generation
@@ -678,34 +1515,21 @@ Instructions:
 1. Answer the question using ONLY the information provided in the context
 2. Be comprehensive but concise
 3. If the context doesn't contain enough information to answer fully, state what you can answer and what information is missing
-4. Cite which sources support your statements (e.g., "According to Source 1...")
+4. %s
 5. If the question cannot be answered with the given context, clearly state this
+6. %s
 
-Answer:`, contextBuilder.String(), query)
+Answer:`, contextBuilder.String(), query, citationInstruction, formatInstruction)
 
 	// Generate response using LLM
-	var response *ai.ModelResponse
-	var err error
-
-	if p.config.Model != nil {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModel(p.config.Model),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     float64(options.Temperature),
-				MaxOutputTokens: 2000,
-			}),
-		)
-	} else {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModelName(p.config.ModelName),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     float64(options.Temperature),
-				MaxOutputTokens: 2000,
-			}),
-		)
+	maxOutputTokens := 2000
+	if options.MaxAnswerTokens > 0 {
+		maxOutputTokens = options.MaxAnswerTokens
 	}
+	response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+		Temperature:     float64(options.Temperature),
+		MaxOutputTokens: maxOutputTokens,
+	})
 
 	if err != nil {
 		return "", 0, fmt.Errorf("failed to generate response: %w", err)
@@ -715,7 +1539,10 @@ Answer:`, contextBuilder.String(), query)
 	return responseText, len(responseText), nil
 }
 
-// buildKnowledgeGraph extracts entities and relations from chunks using LLM
+// buildKnowledgeGraph extracts entities and relations from the chunks concurrently on
+// the worker pool. A chunk that fails, such as with output that stays malformed after
+// a corrective retry, is left out with a warning unless fail-fast is set or too many
+// chunks fail.
 func (p *AgenticRAGProcessor) buildKnowledgeGraph(ctx context.Context, chunks []DocumentChunk) (*KnowledgeGraph, error) {
 	if !p.config.KnowledgeGraph.Enabled || len(chunks) == 0 {
 		return nil, nil
@@ -726,48 +1553,33 @@ func (p *AgenticRAGProcessor) buildKnowledgeGraph(ctx context.Context, chunks []
 		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
 	}
 
-	// Prepare chunk texts for prompt
-	textChunks := make([]string, len(chunks))
-	for i, chunk := range chunks {
-		textChunks[i] = chunk.Content
-	}
-
-	// Get the prompt variant to use
-	promptName := p.config.Prompts.KnowledgeExtractionPrompt
-	if variant, exists := p.config.Prompts.Variants["knowledge_extraction"]; exists {
-		promptName = fmt.Sprintf("%s.%s", promptName, variant)
-	}
-
-	// Lookup the dotprompt
-	kgPrompt := genkit.LookupPrompt(p.config.Genkit, promptName)
-	if kgPrompt == nil {
-		// Fallback to hardcoded prompt if dotprompt not found
-		return p.buildKnowledgeGraphFallback(ctx, chunks)
-	}
-
-	// Execute the prompt with proper input
-	response, err := kgPrompt.Execute(ctx,
-		ai.WithInput(map[string]any{
-			"text_chunks":    textChunks,
-			"entity_types":   p.config.KnowledgeGraph.EntityTypes,
-			"relation_types": p.config.KnowledgeGraph.RelationTypes,
-			"min_confidence": p.config.KnowledgeGraph.MinConfidenceThreshold,
-		}),
-	)
+	// Each chunk is extracted on its own, so a malformed response only loses its chunk
+	state := requestStateFrom(ctx)
+	var completed atomic.Int64
+	graphs, errs, err := runChunks(ctx, p, StageKnowledgeGraph, chunks,
+		func(ctx context.Context, i int) (*KnowledgeGraph, error) {
+			defer func() {
+				state.reportProgress(StageKnowledgeGraph, min(int(completed.Add(1)), len(chunks)), len(chunks))
+			}()
+			return p.extractChunkGraph(ctx, chunks[i])
+		})
 	if err != nil {
-		// Fallback if LLM fails
-		return p.buildKnowledgeGraphFallback(ctx, chunks)
+		return nil, err
 	}
 
-	// Parse the structured response
-	var responseData map[string]any
-	if err := response.Output(&responseData); err != nil {
-		// Fallback if parsing fails
-		return p.buildKnowledgeGraphFallback(ctx, chunks)
+	// Combine the chunk graphs in chunk order; duplicates are merged by the caller
+	kg := &KnowledgeGraph{
+		Entities:  make([]Entity, 0),
+		Relations: make([]Relation, 0),
 	}
-
-	// Extract knowledge graph from structured response
-	return p.parseKnowledgeGraphResponse(responseData)
+	for i, graph := range graphs {
+		if errs[i] != nil || graph == nil {
+			continue
+		}
+		kg.Entities = append(kg.Entities, graph.Entities...)
+		kg.Relations = append(kg.Relations, graph.Relations...)
+	}
+	return kg, nil
 }
 
 // buildKnowledgeGraphFallback provides a fallback when dotprompt is not available
@@ -779,7 +1591,7 @@ func (p *AgenticRAGProcessor) buildKnowledgeGraphFallback(ctx context.Context, c
 	}
 
 	// Create prompt for knowledge extraction
-	entityTypes := strings.Join(p.config.KnowledgeGraph.EntityTypes, ", ")
+	entityTypes := strings.Join(newEntityOntology(p.config.KnowledgeGraph.TypeParents).describe(p.entityTypes(ctx)), ", ")
 	relationTypes := strings.Join(p.config.KnowledgeGraph.RelationTypes, ", ")
 
 	prompt := fmt.Sprintf(`You are an expert knowledge graph extractor. Extract entities and relationships from the provided text.
@@ -813,35 +1625,24 @@ Respond with JSON in this exact format:
 		relationTypes, p.config.KnowledgeGraph.MinConfidenceThreshold)
 
 	// Generate response using LLM
-	var response *ai.ModelResponse
-	var err error
-
-	if p.config.Model != nil {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModel(p.config.Model),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.2, // Low temperature for structured output
-				MaxOutputTokens: 2500,
-			}),
-		)
-	} else {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModelName(p.config.ModelName),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.2, // Low temperature for structured output
-				MaxOutputTokens: 2500,
-			}),
-		)
-	}
+	response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+		Temperature:     0.2, // Low temperature for structured output
+		MaxOutputTokens: 2500,
+	})
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract knowledge graph: %w", err)
 	}
 
-	// Parse the LLM response
-	responseText := response.Text()
+	return p.parseFallbackGraph(response.Text())
+}
+
+// parseFallbackGraph parses the built-in extraction prompt's response as the JSON asked
+// for, then as the older line format
+func (p *AgenticRAGProcessor) parseFallbackGraph(responseText string) (*KnowledgeGraph, error) {
+	if kg, err := p.parseFallbackGraphJSON(responseText); err == nil {
+		return kg, nil
+	}
 	return p.parseKnowledgeGraphFromText(responseText)
 }
 
@@ -880,6 +1681,7 @@ func (p *AgenticRAGProcessor) parseKnowledgeGraphResponse(responseData map[strin
 						}
 						entity.Properties["mentions"] = mentionsList
 					}
+					entity.Attributes = parseAttributes(entityMap["attributes"])
 
 					if entity.Confidence >= p.config.KnowledgeGraph.MinConfidenceThreshold {
 						kg.Entities = append(kg.Entities, entity)
@@ -914,6 +1716,7 @@ func (p *AgenticRAGProcessor) parseKnowledgeGraphResponse(responseData map[strin
 						}
 						relation.Properties["evidence"] = evidence
 					}
+					relation.Temporal = parseTemporal(relationMap)
 
 					if relation.Confidence >= p.config.KnowledgeGraph.MinConfidenceThreshold {
 						kg.Relations = append(kg.Relations, relation)
@@ -991,18 +1794,27 @@ func (p *AgenticRAGProcessor) parseKnowledgeGraphFromText(responseText string) (
 	return kg, nil
 }
 
-// parseConfidence safely parses a confidence value from string
+// parseConfidence safely parses a confidence value from string, either a percentage or
+// a fraction
 func parseConfidence(confidenceStr string) float64 {
-	confidenceStr = strings.TrimSuffix(confidenceStr, "%")
-	confidence, err := strconv.ParseFloat(confidenceStr, 64)
+	confidenceStr = strings.TrimSpace(confidenceStr)
+	percentage := strings.HasSuffix(confidenceStr, "%")
+	confidence, err := strconv.ParseFloat(strings.TrimSuffix(confidenceStr, "%"), 64)
 	if err != nil {
 		return 0.0
 	}
-	return confidence / 100.0
+	if percentage || confidence > 1 {
+		return confidence / 100.0
+	}
+	return confidence
 }
 
-// verifyFacts performs fact verification on the generated response using LLM
-func (p *AgenticRAGProcessor) verifyFacts(ctx context.Context, answer string, chunks []DocumentChunk) (*FactVerification, error) {
+// verifyFacts performs fact verification on the generated response using LLM. The
+// answer's claims come from ClaimExtractor and are checked one by one against the
+// chunks. The claims and reasoning are written in language, if set, and the dates
+// claims make are checked against datedFacts too. Claims verified before against the
+// same chunks come from the verification cache, unless forceReverify is set.
+func (p *AgenticRAGProcessor) verifyFacts(ctx context.Context, answer string, chunks []DocumentChunk, documents []Document, language string, datedFacts []string, forceReverify bool) (*FactVerification, error) {
 	if len(chunks) == 0 {
 		return nil, nil
 	}
@@ -1012,190 +1824,160 @@ func (p *AgenticRAGProcessor) verifyFacts(ctx context.Context, answer string, ch
 		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
 	}
 
+	claims, err := NewClaimExtractor(p).Extract(ctx, answer, ClaimExtractionOptions{Language: language})
+	if err != nil {
+		return nil, err
+	}
+	if len(claims) == 0 {
+		return &FactVerification{Claims: []Claim{}, Overall: VerificationVerified}, nil
+	}
+	return p.verifyCached(ctx, claims, chunks, forceReverify, func(claims []Claim) (*FactVerification, error) {
+		return p.verifyClaims(ctx, answer, claims, chunks, documents, language, datedFacts)
+	})
+}
+
+// verifyClaims verifies the claims of the answer with the fact verification prompt,
+// falling back to the built-in prompt
+func (p *AgenticRAGProcessor) verifyClaims(ctx context.Context, answer string, claims []Claim, chunks []DocumentChunk, documents []Document, language string, datedFacts []string) (*FactVerification, error) {
+	claimTexts := make([]string, len(claims))
+	for i, claim := range claims {
+		claimTexts[i] = claim.Text
+	}
+
 	// Prepare source documents for prompt
 	sourceDocuments := make([]string, len(chunks))
 	for i, chunk := range chunks {
 		sourceDocuments[i] = chunk.Content
 	}
 
-	// Get the prompt variant to use
-	promptName := p.config.Prompts.FactVerificationPrompt
-	if variant, exists := p.config.Prompts.Variants["fact_verification"]; exists {
-		promptName = fmt.Sprintf("%s.%s", promptName, variant)
-	}
-
-	// Lookup the dotprompt
-	factPrompt := genkit.LookupPrompt(p.config.Genkit, promptName)
+	factPrompt := p.lookupPrompt(ctx, "fact_verification", p.config.Prompts.FactVerificationPrompt)
 	if factPrompt == nil {
 		// Fallback to hardcoded prompt if dotprompt not found
-		return p.verifyFactsFallback(ctx, answer, chunks)
+		return p.verifyFactsFallback(ctx, claims, chunks, documents, language, datedFacts)
 	}
 
-	// Execute the prompt with proper input
-	response, err := factPrompt.Execute(ctx,
-		ai.WithInput(map[string]any{
-			"answer_text":      answer,
-			"source_documents": sourceDocuments,
-			"require_evidence": p.config.FactVerification.RequireEvidence,
-		}),
-	)
+	// Execute the prompt with proper input, grounded with Google Search if configured
+	input := map[string]any{
+		"answer_text":       answer,
+		"claims":            claimTexts,
+		"source_documents":  sourceDocuments,
+		"require_evidence":  p.factVerification(ctx).RequireEvidence,
+		"response_language": languageName(language),
+		"dated_facts":       datedFacts,
+	}
+	var config any
+	if p.config.Grounding.FactVerification {
+		config = p.groundedPromptConfig(ctx, factPrompt, input, 0)
+	}
+	response, err := p.executePromptStream(ctx, factPrompt, input, config, nil)
 	if err != nil {
 		// Fallback if LLM fails
-		return p.verifyFactsFallback(ctx, answer, chunks)
+		return p.verifyFactsFallback(ctx, claims, chunks, documents, language, datedFacts)
 	}
 
 	// Parse the structured response
 	var responseData map[string]any
-	if err := response.Output(&responseData); err != nil {
+	err = response.Output(&responseData)
+	if err == nil {
+		err = checkSchema(responseData, verificationSchema, "output")
+	}
+	if err != nil {
 		// Fallback if parsing fails
-		return p.verifyFactsFallback(ctx, answer, chunks)
+		return p.verifyFactsFallback(ctx, claims, chunks, documents, language, datedFacts)
 	}
 
 	// Extract fact verification from structured response
-	return p.parseFactVerificationResponse(responseData)
-}
-
-// parseFactVerificationResponse parses the structured response from fact verification dotprompt
-func (p *AgenticRAGProcessor) parseFactVerificationResponse(responseData map[string]any) (*FactVerification, error) {
-	claims, ok := responseData["claims"].([]interface{})
-	if !ok {
-		return nil, fmt.Errorf("invalid claims format in response")
+	verification, err := p.decodeVerification(ctx, responseData, claims, chunks, documents)
+	if err != nil {
+		return nil, err
 	}
 
-	var factClaims []Claim
-	for _, claimData := range claims {
-		claimMap, ok := claimData.(map[string]interface{})
-		if !ok {
-			continue
-		}
+	// Attach the web sources backing each claim
+	if grounding := groundingFromResponse(response); grounding != nil {
+		attachGroundingSources(verification, grounding)
+		verification.Metadata = map[string]interface{}{"grounding": grounding}
+	}
 
-		text, _ := claimMap["text"].(string)
-		status, _ := claimMap["status"].(string)
-		confidence, _ := claimMap["confidence"].(float64)
+	return verification, nil
+}
 
-		evidenceList, _ := claimMap["evidence"].([]interface{})
-		var evidence []string
-		for _, ev := range evidenceList {
-			if evStr, ok := ev.(string); ok {
-				evidence = append(evidence, evStr)
-			}
+// verifyFactsFallback provides a fallback fact verification method when dotprompt is unavailable
+func (p *AgenticRAGProcessor) verifyFactsFallback(ctx context.Context, claims []Claim, chunks []DocumentChunk, documents []Document, language string, datedFacts []string) (*FactVerification, error) {
+	// Generate the verdicts as JSON matching the schema
+	value, raw, err := p.generateJSON(ctx, verificationPrompt(claims, chunks, language, datedFacts), verificationSchema, &ai.GenerationCommonConfig{
+		Temperature:     0.1, // Low temperature for consistent verification
+		MaxOutputTokens: 2048,
+	})
+	var outputErr *StructuredOutputError
+	if errors.As(err, &outputErr) {
+		// Return the claims unchecked if the verdicts stay malformed
+		unchecked := make([]Claim, len(claims))
+		for i, claim := range claims {
+			claim.Verdict = VerdictNotEnoughEvidence
+			claim.Evidence = []string{"Fact verification parsing failed"}
+			unchecked[i] = claim
 		}
-
-		factClaims = append(factClaims, Claim{
-			Text:       text,
-			Status:     status,
-			Confidence: confidence,
-			Evidence:   evidence,
-		})
+		return &FactVerification{
+			Claims:  unchecked,
+			Overall: overallVerdict(unchecked),
+			Metadata: map[string]interface{}{
+				"verification_error": outputErr.Err.Error(),
+				"raw_response":       raw,
+			},
+		}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify facts: %w", err)
 	}
 
-	overall, _ := responseData["overall"].(string)
-
-	return &FactVerification{
-		Claims:  factClaims,
-		Overall: overall,
-	}, nil
+	verification, err := p.decodeVerification(ctx, value, claims, chunks, documents)
+	if err != nil {
+		return nil, err
+	}
+	verification.Metadata = map[string]interface{}{
+		"verification_method": "llm_based",
+		"source_count":        len(chunks),
+		"verified_at":         time.Now(),
+	}
+	return verification, nil
 }
 
-// verifyFactsFallback provides a fallback fact verification method when dotprompt is unavailable
-func (p *AgenticRAGProcessor) verifyFactsFallback(ctx context.Context, answer string, chunks []DocumentChunk) (*FactVerification, error) {
+// verificationPrompt asks the model for a verdict on each numbered claim against the
+// numbered chunks, answered as verificationSchema JSON
+func verificationPrompt(claims []Claim, chunks []DocumentChunk, language string, datedFacts []string) string {
 	// Build source context for verification
 	var contextBuilder strings.Builder
 	contextBuilder.WriteString("Source documents:\n\n")
 	for i, chunk := range chunks {
-		contextBuilder.WriteString(fmt.Sprintf("Source %d:\n%s\n\n", i+1, chunk.Content))
+		contextBuilder.WriteString(fmt.Sprintf("Source %d:\n%s\n\n", i, chunk.Content))
+	}
+	var claimsBuilder strings.Builder
+	for i, claim := range claims {
+		claimsBuilder.WriteString(fmt.Sprintf("(%d) %s\n", i, claim.Text))
 	}
 
 	// Create prompt for fact verification
-	prompt := fmt.Sprintf(`You are an expert fact-checker. Verify the factual accuracy of the given answer against the provided source documents.
+	prompt := fmt.Sprintf(`You are an expert fact-checker. Verify each numbered claim against the provided source documents.
 
 Source Context:
 %s
 
-Answer to verify:
+Claims to verify:
 %s
-
 Task:
-1. Break down the answer into individual factual claims
-2. For each claim, verify it against the source documents
-3. Assign status: "verified" (supported by sources), "refuted" (contradicted by sources), or "inconclusive" (not addressed in sources)
-4. Provide confidence score (0.0-1.0)
-5. List evidence from sources that support or refute each claim
-
-Respond with JSON in this exact format:
-{
-  "claims": [
-    {
-      "text": "Specific claim text",
-      "status": "verified|refuted|inconclusive", 
-      "confidence": 0.95,
-      "evidence": ["Source 1: Supporting text", "Source 2: Additional evidence"]
-    }
-  ],
-  "overall": "verified|partially_verified|unverified"
-}`, contextBuilder.String(), answer)
-
-	// Generate fact verification using LLM
-	var response *ai.ModelResponse
-	var err error
-
-	if p.config.Model != nil {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModel(p.config.Model),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.1, // Low temperature for consistent verification
-				MaxOutputTokens: 2048,
-			}),
-		)
-	} else {
-		response, err = genkit.Generate(ctx, p.config.Genkit,
-			ai.WithModelName(p.config.ModelName),
-			ai.WithPrompt(prompt),
-			ai.WithConfig(&ai.GenerationCommonConfig{
-				Temperature:     0.1, // Low temperature for consistent verification
-				MaxOutputTokens: 2048,
-			}),
-		)
-	}
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to verify facts: %w", err)
-	}
+1. For each claim, verify it against the source documents
+2. Give a verdict: "supported" (the sources state or directly imply it), "refuted" (the sources contradict it), "partially_supported" (the sources back only part of it) or "not_enough_evidence" (the sources don't settle it)
+3. Provide confidence in the verdict (0.0-1.0)
+4. List evidence from sources that support or refute each claim, and quote each piece verbatim with the number of its source as evidence_spans
 
-	// Parse the LLM response
-	var verificationResponse struct {
-		Claims  []Claim `json:"claims"`
-		Overall string  `json:"overall"`
+Example: {"claims": [{"claim_index": 0, "verdict": "supported", "confidence": 0.95, "evidence": ["Source 0: Supporting text"], "evidence_spans": [{"source": 0, "quote": "Supporting text"}]}]}`,
+		contextBuilder.String(), claimsBuilder.String())
+	if len(datedFacts) > 0 {
+		prompt += "\n\nThese facts were extracted from the sources with their dates. A claim whose date or time period disagrees with them or the sources is refuted:\n- " +
+			strings.Join(datedFacts, "\n- ")
 	}
-
-	responseText := response.Text()
-	if err := json.Unmarshal([]byte(responseText), &verificationResponse); err != nil {
-		// Return basic verification if parsing fails
-		return &FactVerification{
-			Claims: []Claim{
-				{
-					Text:       answer,
-					Status:     "inconclusive",
-					Confidence: 0.5,
-					Evidence:   []string{"Fact verification parsing failed"},
-				},
-			},
-			Overall: "unverified",
-			Metadata: map[string]interface{}{
-				"verification_error": err.Error(),
-				"raw_response":       responseText,
-			},
-		}, nil
+	if language != "" {
+		prompt += "\n\nQuote evidence exactly as it appears in the sources."
 	}
-
-	return &FactVerification{
-		Claims:  verificationResponse.Claims,
-		Overall: verificationResponse.Overall,
-		Metadata: map[string]interface{}{
-			"verification_method": "llm_based",
-			"source_count":        len(chunks),
-			"verified_at":         time.Now(),
-		},
-	}, nil
+	return prompt
 }
@@ -0,0 +1,68 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestProcessConcurrent runs concurrent requests on one processor, sharing its response
+// cache, metrics and prompts. Run it with -race.
+func TestProcessConcurrent(t *testing.T) {
+	const requests = 50
+
+	mock := &mockProvider{answer: func(prompt string) string {
+		return "Answer to " + promptQuery(prompt)
+	}}
+	p := newTestProcessor(t, mock, func(config *AgenticRAGConfig) {
+		config.Cache.Enabled = true
+	}, WithMetrics(NewPrometheusMetrics()))
+
+	// run sends the requests concurrently. Every tenth request repeats a query, so
+	// requests racing on the same cache entry read and write it at once.
+	run := func() []*AgenticRAGResponse {
+		var wg sync.WaitGroup
+		responses := make([]*AgenticRAGResponse, requests)
+		errs := make([]error, requests)
+		for i := range requests {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				request := testRequest(fmt.Sprintf("Where is the Eiffel Tower? (%d)", i%(requests/5)))
+				request.Options.EnableKnowledgeGraph = true
+				request.Options.EnableFactVerification = i%2 == 0
+				responses[i], errs[i] = p.Process(context.Background(), request)
+			}()
+		}
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("request %d: %v", i, err)
+			}
+			want := fmt.Sprintf("(%d)", i%(requests/5))
+			if !strings.Contains(responses[i].Answer, want) {
+				t.Errorf("request %d got the answer of another request: %q", i, responses[i].Answer)
+			}
+			if responses[i].KnowledgeGraph == nil || len(responses[i].KnowledgeGraph.Entities) == 0 {
+				t.Errorf("request %d has no knowledge graph", i)
+			}
+		}
+		return responses
+	}
+
+	run()
+	calls := mock.callCount()
+
+	// The first round cached every query, so repeating it calls no model
+	for i, response := range run() {
+		if !response.ProcessingMetadata.CacheHit {
+			t.Errorf("request %d of the second round missed the response cache", i)
+		}
+	}
+	if got := mock.callCount(); got != calls {
+		t.Errorf("the second round made %d model calls, want none", got-calls)
+	}
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"sync"
+)
+
+// Pipeline stages reported to AgenticRAGOptions.OnProgress
+const (
+	StageLoading          = "loading"
+	StageQueryRewrite     = "query_rewrite"
+	StageRetrieval        = "retrieval"
+	StageChunking         = "chunking"
+	StageCommunityRouting = "community_routing"
+	StageRelevanceScoring = "relevance_scoring"
+	StageReranking        = "reranking"
+	StageDiversification  = "diversification"
+	StageGraphExpansion   = "graph_expansion"
+	StageRefinement       = "refinement"
+	StageGeneration       = "generation"
+	StageGroundedness     = "groundedness"
+	StageAnswerAssessment = "answer_assessment"
+	StageKnowledgeGraph   = "knowledge_graph"
+	StageFactVerification = "fact_verification"
+)
+
+// progressQueueSize bounds the updates waiting for a slow progress callback
+const progressQueueSize = 64
+
+// progressUpdate is a single progress report
+type progressUpdate struct {
+	stage       string
+	done, total int
+}
+
+// progressReporter dispatches progress updates to a callback on its own goroutine
+// so a slow callback never blocks the pipeline. Updates that don't fit the queue
+// are dropped.
+type progressReporter struct {
+	callback func(stage string, done, total int)
+	queue    chan progressUpdate
+	done     chan struct{} // Closed when the dispatcher exits
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+// newProgressReporter starts a reporter for the callback, or returns nil if there is none
+func newProgressReporter(callback func(stage string, done, total int)) *progressReporter {
+	if callback == nil {
+		return nil
+	}
+
+	r := &progressReporter{
+		callback: callback,
+		queue:    make(chan progressUpdate, progressQueueSize),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// run delivers queued updates until the reporter is stopped
+func (r *progressReporter) run() {
+	defer close(r.done)
+	for update := range r.queue {
+		if r.isStopped() {
+			// Drain without delivering once stopped
+			continue
+		}
+		r.callback(update.stage, update.done, update.total)
+	}
+}
+
+// report queues an update, dropping it if the callback has fallen behind
+func (r *progressReporter) report(stage string, done, total int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	select {
+	case r.queue <- progressUpdate{stage: stage, done: done, total: total}:
+	default:
+	}
+}
+
+// stop discards pending updates and waits for a callback in progress to return, so
+// nothing is delivered after stop returns
+func (r *progressReporter) stop() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	if !r.stopped {
+		r.stopped = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+// isStopped reports whether stop has been called
+func (r *progressReporter) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
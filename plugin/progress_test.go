@@ -0,0 +1,36 @@
+package plugin
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProgressReporterStopWaitsForCallback(t *testing.T) {
+	var delivered, running atomic.Int32
+	r := newProgressReporter(func(stage string, done, total int) {
+		running.Add(1)
+		time.Sleep(time.Millisecond)
+		delivered.Add(1)
+		running.Add(-1)
+	})
+	for i := range progressQueueSize {
+		r.report(StageChunking, i, progressQueueSize)
+	}
+	time.Sleep(2 * time.Millisecond) // Let the dispatcher pick up an update
+	r.stop()
+	r.stop()
+
+	if running.Load() != 0 {
+		t.Fatal("a callback was still running after stop returned")
+	}
+	stopped := delivered.Load()
+	r.report(StageGeneration, 1, 1)
+	time.Sleep(20 * time.Millisecond)
+	if got := delivered.Load(); got != stopped {
+		t.Errorf("%d updates delivered after stop returned", got-stopped)
+	}
+	if stopped == progressQueueSize {
+		t.Errorf("all %d updates delivered, want the pending ones discarded", stopped)
+	}
+}
@@ -0,0 +1,448 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// promptVar describes a variable the processor supplies to a prompt
+type promptVar struct {
+	required bool                 // The prompt can't do its job without it
+	fields   map[string]promptVar // Fields of the list's items or the object, nil for scalars and lists of scalars
+}
+
+// chunkFields are the fields of the context chunks passed to the answer prompts
+var chunkFields = map[string]promptVar{
+	"marker":          {required: true},
+	"content":         {required: true},
+	"source":          {},
+	"relevance_score": {},
+}
+
+// promptInputs are the variables the processor supplies to each prompt, by the key of
+// the prompt in PromptsConfig.Variants
+var promptInputs = map[string]map[string]promptVar{
+	"relevance_scoring": {
+		"query":      {required: true},
+		"chunks":     {required: true},
+		"max_chunks": {},
+	},
+	"response_generation": {
+		"query":             {required: true},
+		"context_chunks":    {required: true, fields: chunkFields},
+		"enable_citations":  {},
+		"citation_markers":  {},
+		"plain_text":        {},
+		"style":             {},
+		"length":            {},
+		"response_language": {},
+	},
+	"knowledge_extraction": {
+		"text_chunks":    {required: true},
+		"entity_types":   {},
+		"relation_types": {},
+		"min_confidence": {},
+	},
+	"fact_verification": {
+		"answer_text":       {},
+		"claims":            {required: true},
+		"source_documents":  {required: true},
+		"require_evidence":  {},
+		"response_language": {},
+		"dated_facts":       {},
+	},
+	"rerank": {
+		"query": {required: true},
+		"chunk": {required: true},
+	},
+	"query_rewrite": {
+		"query":   {required: true},
+		"history": {fields: map[string]promptVar{"role": {}, "content": {}}},
+	},
+	"extractive_answer": {
+		"query":          {required: true},
+		"context_chunks": {required: true, fields: map[string]promptVar{"marker": {required: true}, "content": {required: true}}},
+		"rejected":       {},
+	},
+	"recursion_assessment": {
+		"query":               {required: true},
+		"passages":            {required: true},
+		"known_entities":      {},
+		"previous_confidence": {},
+	},
+	"answer_assessment": {
+		"query":    {required: true},
+		"answer":   {required: true},
+		"passages": {required: true},
+	},
+}
+
+// templateTag matches the tags of a handlebars template, including triple-stash and
+// whitespace-control forms
+var templateTag = regexp.MustCompile(`(?s){{{?~?\s*(.*?)\s*~?}?}}`)
+
+// ValidatePrompts checks the configured prompts and prompt variants against the
+// variables the processor supplies them: variables the templates use that it doesn't
+// supply, variables it requires that they don't use, partials they include that neither
+// the prompts directory nor the embedded defaults provide, and prompt files missing from
+// the prompts directory are all reported, as are models the frontmatter declares that
+// aren't registered if config.Genkit is set. Prompts the directory lacks are checked in their
+// embedded version; without the directory the others fall back to built-in prompts and
+// aren't checked. Besides the model check it reads the prompt files only, so it runs
+// without genkit or a model.
+func ValidatePrompts(config *AgenticRAGConfig) error {
+	partials, err := readPartials(config.Prompts)
+	if err != nil {
+		return fieldError("prompts.directory", "%v", err)
+	}
+	var errs []error
+	for _, key := range sortedKeys(promptInputs) {
+		name := config.Prompts.promptNames()[key]
+		if name == "" {
+			continue
+		}
+		errs = append(errs, validatePrompt(config, partials, key, name, false, "prompts."+key+"_prompt")...)
+		if variant := config.Prompts.Variants[key]; variant != "" {
+			errs = append(errs, validatePrompt(config, partials, key, name+"."+variant, true, "prompts.variants."+key)...)
+		}
+	}
+
+	for _, key := range sortedKeys(config.Prompts.Experiments) {
+		name := config.Prompts.promptNames()[key]
+		if name == "" {
+			continue
+		}
+		for _, variant := range sortedKeys(config.Prompts.Experiments[key].Variants) {
+			if variant != promptVariantDefault && variant != "" && variant != config.Prompts.Variants[key] {
+				errs = append(errs, validatePrompt(config, partials, key, name+"."+variant, true, "prompts.experiments."+key+".variants."+variant)...)
+			}
+		}
+	}
+
+	// Localized prompts are selected by the response language, so they're checked here
+	for _, key := range sortedKeys(promptInputs) {
+		name := config.Prompts.promptNames()[key]
+		for _, localized := range localizedPromptFiles(config.Prompts, name) {
+			_, variant, _ := strings.Cut(localized, ".")
+			if _, experiment := config.Prompts.Experiments[key].Variants[variant]; variant != config.Prompts.Variants[key] && !experiment {
+				errs = append(errs, validatePrompt(config, partials, key, localized, true, "prompts.directory")...)
+			}
+		}
+	}
+
+	// Inline variants may only be selected per request, so they're checked here
+	for _, name := range sortedKeys(config.Prompts.Inline) {
+		field := "prompts.inline." + name
+		key, ok := config.Prompts.promptKeyOf(name)
+		if !ok {
+			errs = append(errs, fieldError(field, "doesn't name a configured prompt or a variant of one"))
+			continue
+		}
+		_, variant, _ := strings.Cut(name, ".")
+		if _, experiment := config.Prompts.Experiments[key].Variants[variant]; variant != "" && variant != config.Prompts.Variants[key] && !experiment {
+			errs = append(errs, validatePrompt(config, partials, key, name, true, field)...)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// localizedPromptFiles returns the names of the prompt files of the prompts directory
+// localized to a language, named <prompt>.<language> or <prompt>.<language>.<variant>
+func localizedPromptFiles(config PromptsConfig, name string) []string {
+	if name == "" || config.Directory == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(config.Directory)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		file, ok := strings.CutSuffix(entry.Name(), ".prompt")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		suffix, ok := strings.CutPrefix(file, name+".")
+		lang, _, _ := strings.Cut(suffix, ".")
+		if _, known := languageNames[primaryLanguage(lang)]; ok && known {
+			names = append(names, file)
+		}
+	}
+	return names
+}
+
+// validatePrompt checks the named prompt file, a variant of the prompt if variant is set
+func validatePrompt(c *AgenticRAGConfig, partials map[string]string, key, name string, variant bool, field string) []error {
+	config := c.Prompts
+	source, err := promptSource(config, key, name, variant)
+	if err != nil {
+		return []error{fieldError(field, "%v", err)}
+	}
+	if source == "" {
+		return nil
+	}
+
+	inputs := promptInputs[key]
+	used, unknown := scanTemplate(source, inputs, config.isPromptHelper)
+	var errs []error
+	var unknownPartials []string
+	for _, variable := range unknown {
+		errs = append(errs, fieldError(field, "prompt %s uses unknown variable %q", name, variable))
+	}
+	for _, variable := range sortedKeys(inputs) {
+		if inputs[variable].required && !used[variable] {
+			errs = append(errs, fieldError(field, "prompt %s doesn't use required variable %q", name, variable))
+		}
+	}
+	for _, partial := range templatePartials(source) {
+		if _, ok := partials[partial]; !ok && !slices.Contains(unknownPartials, partial) {
+			unknownPartials = append(unknownPartials, partial)
+			errs = append(errs, fieldError(field, "prompt %s includes unknown partial %q", name, partial))
+		}
+	}
+	if model := frontmatterModel(source); model != "" && c.Genkit != nil {
+		provider, modelName, _ := strings.Cut(model, "/")
+		if genkit.LookupModel(c.Genkit, provider, modelName) == nil {
+			errs = append(errs, fieldError(field, "prompt %s uses unknown model %q", name, model))
+		}
+	}
+	return errs
+}
+
+// frontmatterModel returns the model the dotprompt source's frontmatter declares, if any
+func frontmatterModel(source string) string {
+	rest, ok := strings.CutPrefix(source, "---")
+	if !ok {
+		return ""
+	}
+	frontmatter, _, _ := strings.Cut(rest, "\n---")
+	for _, line := range strings.Split(frontmatter, "\n") {
+		if value, ok := strings.CutPrefix(line, "model:"); ok {
+			return strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	return ""
+}
+
+// promptSource returns the template of the named prompt from the prompts directory, or
+// its embedded version when the directory lacks it. A variant without a template of its
+// own that overrides partials the prompt includes has the prompt's template. It returns
+// "" when the prompt falls back to a built-in one.
+func promptSource(config PromptsConfig, key, name string, variant bool) (string, error) {
+	if source, ok := config.Inline[name]; ok {
+		return source, nil
+	}
+	dir := config.Directory
+	if dir != "" {
+		source, err := os.ReadFile(filepath.Join(dir, name+".prompt"))
+		if err == nil {
+			return string(source), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("failed to read prompt %s: %w", name, err)
+		}
+	}
+	if base, suffix, ok := strings.Cut(name, "."); variant && ok {
+		if partials, err := readPartials(config); err == nil {
+			if source, err := promptSource(config, key, base, false); err == nil && source != "" {
+				if _, applies := applyPartialOverrides(source, suffix, partials); applies {
+					return source, nil
+				}
+			}
+		}
+	}
+	if config.RequireDirectory {
+		return "", fmt.Errorf("prompt file %s.prompt not found in prompts directory %q", name, dir)
+	}
+	if !variant && slices.Contains(embeddedPromptKeys, key) {
+		source, err := defaultPrompts.ReadFile("defaultprompts/" + key + ".prompt")
+		return string(source), err
+	}
+	if _, err := os.Stat(dir); dir == "" || errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	return "", fmt.Errorf("prompt file %s.prompt not found in prompts directory %q", name, dir)
+}
+
+// templateScope is the context a template section resolves variables against
+type templateScope struct {
+	fields    map[string]promptVar // Nil for a list of scalars, where only this is defined
+	open      bool                 // Anything resolves, as the scope's variable is unknown
+	root      bool                 // The prompt's input
+	inherited bool                 // Opened by a block that keeps its parent's context, such as if
+}
+
+// scanTemplate resolves the variables the template of a dotprompt source uses against
+// the inputs and helpers, returning the inputs used and the unknown variables in order of first
+// use. Partials aren't expanded, but the variables passed to them are resolved.
+func scanTemplate(source string, inputs map[string]promptVar, isHelper func(string) bool) (map[string]bool, []string) {
+	if rest, ok := strings.CutPrefix(source, "---"); ok {
+		if _, template, found := strings.Cut(rest, "\n---"); found {
+			source = template
+		}
+	}
+
+	used := make(map[string]bool)
+	var unknown []string
+	scopes := []templateScope{{fields: inputs, root: true}}
+	resolve := func(path string) templateScope {
+		depth := len(scopes) - 1
+		for {
+			rest, ok := strings.CutPrefix(path, "../")
+			if !ok {
+				break
+			}
+			path = rest
+			for depth > 0 && scopes[depth].inherited {
+				depth--
+			}
+			depth = max(depth-1, 0)
+		}
+		if rest, ok := strings.CutPrefix(path, "@root."); ok {
+			path, depth = rest, 0
+		} else if strings.HasPrefix(path, "@") {
+			return templateScope{open: true}
+		}
+		path = strings.TrimPrefix(strings.TrimPrefix(path, "this."), "./")
+		scope := scopes[depth]
+		if path == "this" || path == "." || scope.open {
+			return scope
+		}
+
+		name, rest, _ := strings.Cut(path, ".")
+		variable, ok := scope.fields[name]
+		if !ok {
+			if !slices.Contains(unknown, name) {
+				unknown = append(unknown, name)
+			}
+			return templateScope{open: true}
+		}
+		if scope.root {
+			used[name] = true
+		}
+		if rest != "" && variable.fields != nil {
+			field, _, _ := strings.Cut(rest, ".")
+			if _, ok := variable.fields[field]; !ok && !slices.Contains(unknown, path) {
+				unknown = append(unknown, path)
+			}
+		}
+		return templateScope{fields: variable.fields, open: rest != ""}
+	}
+
+	for _, match := range templateTag.FindAllStringSubmatch(source, -1) {
+		tag := match[1]
+		if tag == "" || tag[0] == '!' || tag == "else" {
+			continue
+		}
+		tag = strings.TrimPrefix(tag, "else ")
+		switch tag[0] {
+		case '/':
+			if len(scopes) > 1 {
+				scopes = scopes[:len(scopes)-1]
+			}
+			continue
+		case '>':
+			// The partial's name isn't a variable, but its arguments are
+			tokens := tagTokens(tag[1:])
+			for _, token := range tokens[min(1, len(tokens)):] {
+				if !token.helper {
+					resolve(token.text)
+				}
+			}
+			continue
+		}
+
+		block, inverse := tag[0] == '#', tag[0] == '^'
+		if block || inverse {
+			tag = tag[1:]
+		}
+		tokens := tagTokens(tag)
+		if len(tokens) == 0 {
+			continue
+		}
+		head, args := tokens[0].text, tokens[1:]
+		scope := scopes[len(scopes)-1]
+		scope.inherited = true
+		if !isHelper(head) {
+			// A plain variable, or a block over one unless it's an inverse block
+			if resolved := resolve(head); block {
+				scope = resolved
+			}
+		}
+		for i, arg := range args {
+			if arg.helper {
+				continue
+			}
+			resolved := resolve(arg.text)
+			if i == 0 && (head == "each" || head == "with") {
+				scope = resolved
+			}
+		}
+		if block || inverse {
+			scopes = append(scopes, scope)
+		}
+	}
+	return used, unknown
+}
+
+// tagToken is a word of a template tag
+type tagToken struct {
+	text   string
+	helper bool // Names the helper of a subexpression
+}
+
+// tagTokens splits a template tag into the paths and helper names it refers to,
+// dropping literals and the keys of hash arguments
+func tagTokens(tag string) []tagToken {
+	var tokens []tagToken
+	var word strings.Builder
+	helper := false
+	flush := func() {
+		text := word.String()
+		word.Reset()
+		if _, value, ok := strings.Cut(text, "="); ok {
+			text = value
+		}
+		if text == "" {
+			return
+		}
+		if _, err := strconv.ParseFloat(text, 64); err == nil || text == "true" || text == "false" || text == "null" || text == "undefined" {
+			helper = false
+			return
+		}
+		tokens = append(tokens, tagToken{text: text, helper: helper})
+		helper = false
+	}
+
+	for i := 0; i < len(tag); i++ {
+		switch c := tag[i]; c {
+		case '"', '\'':
+			// String literal, possibly the value of a hash argument
+			end := strings.IndexByte(tag[i+1:], c)
+			if end < 0 {
+				end = len(tag) - i - 1
+			}
+			i += end + 1
+			word.Reset()
+			helper = false
+		case '(':
+			flush()
+			helper = true
+		case ')', ' ', '\t', '\n', '\r':
+			flush()
+		default:
+			word.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,133 @@
+package plugin
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// PruneResult counts what pruning dropped from a knowledge graph
+type PruneResult struct {
+	Entities  int `json:"entities"`
+	Relations int `json:"relations"`
+}
+
+// Prune drops entities and relations below minConfidence, then keeps the maxNodes
+// entities with the highest confidence weighted by degree, with no limit when
+// maxNodes is 0. Relations to dropped entities are dropped with them. Returns what
+// was dropped.
+func (kg *KnowledgeGraph) Prune(minConfidence float64, maxNodes int) PruneResult {
+	return kg.prune(minConfidence, maxNodes, 0)
+}
+
+// prune is Prune that also keeps at most maxRelations relations, the most confident,
+// with no limit when 0. Kept entities and relations stay in their order.
+func (kg *KnowledgeGraph) prune(minConfidence float64, maxEntities, maxRelations int) PruneResult {
+	if kg == nil {
+		return PruneResult{}
+	}
+	entitiesBefore, relationsBefore := len(kg.Entities), len(kg.Relations)
+
+	entities := make([]Entity, 0, len(kg.Entities))
+	for _, entity := range kg.Entities {
+		if entity.Confidence >= minConfidence {
+			entities = append(entities, entity)
+		}
+	}
+	relations := make([]Relation, 0, len(kg.Relations))
+	for _, relation := range kg.Relations {
+		if relation.Confidence >= minConfidence {
+			relations = append(relations, relation)
+		}
+	}
+
+	// Relations whose endpoint is an entity that was dropped go with it. Endpoints
+	// naming no entity at all are kept, as they never were nodes.
+	dropped := make(map[string]bool)
+	for _, entity := range kg.Entities {
+		if entity.Confidence < minConfidence {
+			dropped[entity.ID] = true
+			dropped[strings.ToLower(entity.Name)] = true
+		}
+	}
+	dropRelations := func() {
+		delete(dropped, "")
+		if len(dropped) == 0 {
+			return
+		}
+		// A name shared by a kept entity, such as one of another type, still resolves
+		for _, entity := range entities {
+			delete(dropped, entity.ID)
+			delete(dropped, strings.ToLower(entity.Name))
+		}
+		kept := relations[:0]
+		for _, relation := range relations {
+			if !dropped[relation.Subject] && !dropped[strings.ToLower(relation.Subject)] &&
+				!dropped[relation.Object] && !dropped[strings.ToLower(relation.Object)] {
+				kept = append(kept, relation)
+			}
+		}
+		relations = kept
+	}
+	dropRelations()
+
+	if maxEntities > 0 && len(entities) > maxEntities {
+		// Rank by confidence weighted by degree, so hubs outrank leaves of equal confidence
+		degree := make(map[string]int)
+		for _, relation := range relations {
+			degree[strings.ToLower(relation.Subject)]++
+			degree[strings.ToLower(relation.Object)]++
+		}
+		scores := make([]float64, len(entities))
+		for i, entity := range entities {
+			links := degree[strings.ToLower(entity.Name)]
+			if entity.ID != "" {
+				links += degree[strings.ToLower(entity.ID)]
+			}
+			scores[i] = entity.Confidence * (1 + math.Log1p(float64(links)))
+		}
+		order := make([]int, len(entities))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return scores[order[a]] > scores[order[b]] })
+
+		keep := make([]bool, len(entities))
+		for _, i := range order[:maxEntities] {
+			keep[i] = true
+		}
+		kept := entities[:0]
+		for i, entity := range entities {
+			if keep[i] {
+				kept = append(kept, entity)
+				continue
+			}
+			dropped[entity.ID] = true
+			dropped[strings.ToLower(entity.Name)] = true
+		}
+		entities = kept
+		dropRelations()
+	}
+
+	if maxRelations > 0 && len(relations) > maxRelations {
+		order := make([]int, len(relations))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return relations[order[a]].Confidence > relations[order[b]].Confidence })
+		keep := make([]bool, len(relations))
+		for _, i := range order[:maxRelations] {
+			keep[i] = true
+		}
+		kept := relations[:0]
+		for i, relation := range relations {
+			if keep[i] {
+				kept = append(kept, relation)
+			}
+		}
+		relations = kept
+	}
+
+	kg.Entities, kg.Relations = entities, relations
+	return PruneResult{Entities: entitiesBefore - len(entities), Relations: relationsBefore - len(relations)}
+}
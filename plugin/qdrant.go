@@ -0,0 +1,537 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Distances a Qdrant collection can be created with
+const (
+	QdrantCosine    = "Cosine"
+	QdrantDot       = "Dot"
+	QdrantEuclidean = "Euclid"
+)
+
+// qdrantMetadataKey is the payload field holding the document metadata, which document
+// filters address as qdrantMetadataKey.<key>
+const qdrantMetadataKey = "metadata"
+
+// qdrantDimensionProbe is embedded to learn the embedder's dimensions when creating a
+// collection without QdrantConfig.Dimensions
+const qdrantDimensionProbe = "dimension probe"
+
+// QdrantConfig contains configuration for a QdrantStore
+type QdrantConfig struct {
+	URL        string        `json:"url"`        // Base URL of the REST API, e.g. http://localhost:6333
+	APIKey     string        `json:"api_key"`    // Sent as the api-key header, required by Qdrant Cloud
+	Collection string        `json:"collection"` // Collection of the chunks (default: rag_chunks)
+	Dimensions int           `json:"dimensions"` // Vector size of a collection created by the store (default: probed from the embedder)
+	Distance   string        `json:"distance"`   // Distance of a collection created by the store: Cosine, Dot or Euclid (default: Cosine)
+	BatchSize  int           `json:"batch_size"` // Chunks embedded and upserted per request (default: 100)
+	Timeout    time.Duration `json:"timeout"`    // Per-request timeout
+
+	TLS        *tls.Config  `json:"-"` // TLS settings such as a private CA or client certificates, ignored with HTTPClient
+	HTTPClient *http.Client `json:"-"` // Client used for requests (not serialized)
+}
+
+// QdrantStore is an Indexer and Retriever in a Qdrant collection, over its REST API.
+// Each chunk is a point whose payload holds the chunk and its document, with the
+// document metadata under "metadata". Point IDs derive from the document ID and the
+// chunk's content, so indexing a document again only embeds its new chunks and drops
+// the ones it no longer has. Document filters are translated to Qdrant filters.
+type QdrantStore struct {
+	config     QdrantConfig
+	embedder   ai.Embedder
+	client     *http.Client
+	dimensions int
+}
+
+// NewQdrantStore creates the collection, sized for the embedder, if it doesn't exist
+// yet. The embedder embeds both chunks and queries.
+func NewQdrantStore(ctx context.Context, embedder ai.Embedder, config QdrantConfig) (*QdrantStore, error) {
+	if config.Collection == "" {
+		config.Collection = "rag_chunks"
+	}
+	if config.Distance == "" {
+		config.Distance = QdrantCosine
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid qdrant URL %q", config.URL)
+	}
+	switch config.Distance {
+	case QdrantCosine, QdrantDot, QdrantEuclidean:
+	default:
+		return nil, fmt.Errorf("unknown qdrant distance %q, must be Cosine, Dot or Euclid", config.Distance)
+	}
+	if embedder == nil {
+		return nil, errors.New("qdrant store requires an embedder")
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+		if config.TLS != nil {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = config.TLS
+			client.Transport = transport
+		}
+	}
+	s := &QdrantStore{config: config, embedder: embedder, client: client}
+	if err := s.ensureCollection(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ensureCollection reads the vector size of the collection, creating it first if it
+// doesn't exist
+func (s *QdrantStore) ensureCollection(ctx context.Context) error {
+	var info struct {
+		Config struct {
+			Params struct {
+				Vectors json.RawMessage `json:"vectors"`
+			} `json:"params"`
+		} `json:"config"`
+	}
+	err := s.call(ctx, "collection lookup", http.MethodGet, "", nil, &info)
+	if err == nil {
+		var vectors struct {
+			Size int `json:"size"`
+		}
+		if err := json.Unmarshal(info.Config.Params.Vectors, &vectors); err != nil || vectors.Size == 0 {
+			return &QdrantError{Op: "collection lookup", Message: "collection " + s.config.Collection + " has named vectors",
+				Hint: "use a collection with a single unnamed vector"}
+		}
+		if s.config.Dimensions != 0 && vectors.Size != s.config.Dimensions {
+			return s.dimensionError("collection lookup", vectors.Size, s.config.Dimensions)
+		}
+		s.dimensions = vectors.Size
+		return nil
+	}
+	if !errors.Is(err, ErrQdrantCollectionNotFound) {
+		return err
+	}
+
+	dimensions := s.config.Dimensions
+	if dimensions == 0 {
+		embeddings, err := s.embed(ctx, []string{qdrantDimensionProbe})
+		if err != nil {
+			return err
+		}
+		dimensions = len(embeddings[0])
+	}
+	create := map[string]any{"vectors": map[string]any{"size": dimensions, "distance": s.config.Distance}}
+	if err := s.call(ctx, "collection creation", http.MethodPut, "", create, nil); err != nil {
+		return err
+	}
+	s.dimensions = dimensions
+	return nil
+}
+
+// qdrantPoint is a point of the collection
+type qdrantPoint struct {
+	ID      string         `json:"id"`
+	Vector  []float32      `json:"vector,omitempty"`
+	Payload map[string]any `json:"payload,omitempty"`
+	Score   float64        `json:"score,omitempty"`
+}
+
+// Index implements Indexer. The chunks of each document replace the points stored for
+// it, embedding only the chunks whose content isn't stored yet.
+func (s *QdrantStore) Index(ctx context.Context, documents []Document, chunks []DocumentChunk) error {
+	byID := make(map[string]Document, len(documents))
+	for _, doc := range documents {
+		byID[doc.ID] = doc
+	}
+	var order []string
+	grouped := make(map[string][]DocumentChunk)
+	for _, chunk := range chunks {
+		if _, ok := grouped[chunk.DocumentID]; !ok {
+			order = append(order, chunk.DocumentID)
+		}
+		grouped[chunk.DocumentID] = append(grouped[chunk.DocumentID], chunk)
+	}
+	for _, id := range order {
+		if err := s.indexDocument(ctx, byID[id], id, grouped[id]); err != nil {
+			return fmt.Errorf("failed to index document %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// indexDocument replaces the points of the document with its chunks
+func (s *QdrantStore) indexDocument(ctx context.Context, doc Document, id string, chunks []DocumentChunk) error {
+	// Chunks of the same content share their point, so only the first is kept
+	points := make([]qdrantPoint, 0, len(chunks))
+	ids := make([]string, 0, len(chunks))
+	docHash := documentHash(doc)
+	seen := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		hash := contentHash(chunk.Content)
+		pointID := qdrantPointID(id, hash)
+		if seen[pointID] {
+			continue
+		}
+		seen[pointID] = true
+		points = append(points, qdrantPoint{ID: pointID, Payload: map[string]any{
+			"document_id":     id,
+			"chunk_id":        chunk.ID,
+			"chunk_index":     chunk.ChunkIndex,
+			"content":         chunk.Content,
+			"content_hash":    hash,
+			"title":           doc.Title,
+			"source":          doc.Source,
+			"document_hash":   docHash,
+			qdrantMetadataKey: doc.Metadata,
+		}})
+		ids = append(ids, pointID)
+	}
+
+	for start := 0; start < len(points); start += s.config.BatchSize {
+		batch := points[start:min(start+s.config.BatchSize, len(points))]
+		if err := s.upsert(ctx, batch); err != nil {
+			return err
+		}
+	}
+
+	stale := map[string]any{"filter": map[string]any{
+		"must":     []any{qdrantMatch("document_id", id)},
+		"must_not": []any{map[string]any{"has_id": ids}},
+	}}
+	return s.call(ctx, "stale point deletion", http.MethodPost, "/points/delete?wait=true", stale, nil)
+}
+
+// upsert writes the points, embedding those the collection lacks and overwriting the
+// payload of the others
+func (s *QdrantStore) upsert(ctx context.Context, points []qdrantPoint) error {
+	ids := make([]string, len(points))
+	for i, point := range points {
+		ids[i] = point.ID
+	}
+	var existing []qdrantPoint
+	lookup := map[string]any{"ids": ids, "with_payload": false, "with_vector": false}
+	if err := s.call(ctx, "point lookup", http.MethodPost, "/points", lookup, &existing); err != nil {
+		return err
+	}
+	stored := make(map[string]bool, len(existing))
+	for _, point := range existing {
+		stored[point.ID] = true
+	}
+
+	var missing []qdrantPoint
+	var texts []string
+	var operations []any
+	for _, point := range points {
+		if stored[point.ID] {
+			operations = append(operations, map[string]any{"overwrite_payload": map[string]any{"payload": point.Payload, "points": []string{point.ID}}})
+			continue
+		}
+		missing = append(missing, point)
+		texts = append(texts, point.Payload["content"].(string))
+	}
+	if len(missing) > 0 {
+		embeddings, err := s.embed(ctx, texts)
+		if err != nil {
+			return err
+		}
+		for i := range missing {
+			if len(embeddings[i]) != s.dimensions {
+				return s.dimensionError("upsert", s.dimensions, len(embeddings[i]))
+			}
+			missing[i].Vector = embeddings[i]
+		}
+		operations = append(operations, map[string]any{"upsert": map[string]any{"points": missing}})
+	}
+	return s.call(ctx, "upsert", http.MethodPost, "/points/batch?wait=true", map[string]any{"operations": operations}, nil)
+}
+
+// qdrantScrollPage is a page of points scrolled through
+type qdrantScrollPage struct {
+	Points []qdrantPoint `json:"points"`
+	Next   any           `json:"next_page_offset"`
+}
+
+// DocumentHash implements Indexer
+func (s *QdrantStore) DocumentHash(ctx context.Context, documentID string) (string, error) {
+	var page qdrantScrollPage
+	scroll := map[string]any{
+		"filter":       map[string]any{"must": []any{qdrantMatch("document_id", documentID)}},
+		"limit":        1,
+		"with_payload": []string{"document_hash"},
+		"with_vector":  false,
+	}
+	if err := s.call(ctx, "document lookup", http.MethodPost, "/points/scroll", scroll, &page); err != nil {
+		return "", err
+	}
+	if len(page.Points) == 0 {
+		return "", nil
+	}
+	hash, _ := page.Points[0].Payload["document_hash"].(string)
+	return hash, nil
+}
+
+// Delete implements Indexer
+func (s *QdrantStore) Delete(ctx context.Context, documentID string) ([]string, error) {
+	filter := map[string]any{"must": []any{qdrantMatch("document_id", documentID)}}
+	var hashes []string
+	var offset any
+	for {
+		scroll := map[string]any{"filter": filter, "limit": s.config.BatchSize, "with_payload": []string{"content_hash"}, "with_vector": false}
+		if offset != nil {
+			scroll["offset"] = offset
+		}
+		var page qdrantScrollPage
+		if err := s.call(ctx, "document lookup", http.MethodPost, "/points/scroll", scroll, &page); err != nil {
+			return nil, err
+		}
+		for _, point := range page.Points {
+			if hash, ok := point.Payload["content_hash"].(string); ok {
+				hashes = append(hashes, hash)
+			}
+		}
+		if offset = page.Next; offset == nil {
+			break
+		}
+	}
+	if err := s.call(ctx, "document deletion", http.MethodPost, "/points/delete?wait=true", map[string]any{"filter": filter}, nil); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// Retrieve implements Retriever. Filters are applied by Qdrant where they translate,
+// and to the points returned otherwise.
+func (s *QdrantStore) Retrieve(ctx context.Context, query string, k int, filter *DocumentFilter) ([]ScoredChunk, error) {
+	embeddings, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(embeddings[0]) != s.dimensions {
+		return nil, s.dimensionError("search", s.dimensions, len(embeddings[0]))
+	}
+
+	search := map[string]any{"vector": embeddings[0], "limit": k, "with_payload": true}
+	if filter != nil {
+		condition, exact := qdrantFilter(*filter)
+		if condition != nil {
+			search["filter"] = condition
+		}
+		if !exact {
+			search["limit"] = k * retrievalOverfetch
+		}
+	}
+	var points []qdrantPoint
+	if err := s.call(ctx, "search", http.MethodPost, "/points/search", search, &points); err != nil {
+		return nil, err
+	}
+
+	chunks := make([]ScoredChunk, 0, min(k, len(points)))
+	for _, point := range points {
+		if len(chunks) == k {
+			break
+		}
+		metadata, _ := point.Payload[qdrantMetadataKey].(map[string]any)
+		if filter != nil && !filter.matches(metadata) {
+			continue
+		}
+		scored := ScoredChunk{Score: point.Score, Metadata: metadata}
+		if s.config.Distance == QdrantEuclidean {
+			scored.Score = 1 / (1 + point.Score) // Qdrant returns the distance
+		}
+		scored.Chunk.DocumentID, _ = point.Payload["document_id"].(string)
+		scored.Chunk.ID, _ = point.Payload["chunk_id"].(string)
+		scored.Chunk.Content, _ = point.Payload["content"].(string)
+		if index, ok := point.Payload["chunk_index"].(float64); ok {
+			scored.Chunk.ChunkIndex = int(index)
+		}
+		scored.Title, _ = point.Payload["title"].(string)
+		scored.Source, _ = point.Payload["source"].(string)
+		chunks = append(chunks, scored)
+	}
+	return chunks, nil
+}
+
+// embed returns one embedding per text
+func (s *QdrantStore) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	response, err := ai.Embed(ctx, s.embedder, ai.WithTextDocs(texts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed with %s: %w", s.embedder.Name(), err)
+	}
+	if len(response.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("embedder %s returned %d embeddings for %d texts", s.embedder.Name(), len(response.Embeddings), len(texts))
+	}
+	embeddings := make([][]float32, len(texts))
+	for i, embedding := range response.Embeddings {
+		embeddings[i] = embedding.Embedding
+	}
+	return embeddings, nil
+}
+
+// call sends a request to the collection's endpoint under path and decodes the result
+// into result unless it is nil
+func (s *QdrantStore) call(ctx context.Context, op, method, path string, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode qdrant %s: %w", op, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := strings.TrimRight(s.config.URL, "/") + "/collections/" + url.PathEscape(s.config.Collection) + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create qdrant %s request: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.config.APIKey != "" {
+		req.Header.Set("api-key", s.config.APIKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &QdrantError{Op: op, Message: err.Error(), Hint: "check QdrantConfig.URL and that Qdrant is reachable", Kind: ErrRetrieverUnavailable}
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+		Status json.RawMessage `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil && resp.StatusCode < 300 {
+		return fmt.Errorf("failed to read qdrant %s response: %w", op, err)
+	}
+	if resp.StatusCode >= 300 {
+		var status struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(envelope.Status, &status)
+		if status.Error == "" {
+			status.Error = resp.Status
+		}
+		return s.classify(op, resp.StatusCode, status.Error)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Result, result); err != nil {
+		return fmt.Errorf("failed to read qdrant %s response: %w", op, err)
+	}
+	return nil
+}
+
+// classify turns a Qdrant error response into a QdrantError, recognizing missing
+// collections, vector size mismatches, rejected API keys and server failures
+func (s *QdrantStore) classify(op string, status int, message string) error {
+	err := &QdrantError{Op: op, Status: status, Message: message}
+	lower := strings.ToLower(message)
+	switch {
+	case status == http.StatusNotFound && strings.Contains(lower, "collection"):
+		err.Kind = ErrQdrantCollectionNotFound
+		err.Hint = fmt.Sprintf("collection %s was deleted after the store was created, create the store again to recreate it", s.config.Collection)
+	case strings.Contains(lower, "dimension"):
+		err.Kind = ErrQdrantDimensionMismatch
+		err.Hint = "the collection was created for another embedder, use a new collection or recreate it"
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		err.Hint = "check QdrantConfig.APIKey"
+	case status >= http.StatusInternalServerError:
+		err.Kind = ErrRetrieverUnavailable
+	}
+	return err
+}
+
+// dimensionError reports embeddings whose size differs from the collection's vectors
+func (s *QdrantStore) dimensionError(op string, collection, embedding int) error {
+	return &QdrantError{
+		Op:      op,
+		Message: fmt.Sprintf("collection %s holds vectors of %d dimensions, the embedder produces %d", s.config.Collection, collection, embedding),
+		Hint:    "the collection was created for another embedder, use a new collection or recreate it",
+		Kind:    ErrQdrantDimensionMismatch,
+	}
+}
+
+// qdrantPointID derives the UUID of a chunk's point from its document and content
+func qdrantPointID(documentID, hash string) string {
+	sum := sha256.Sum256([]byte(documentID + "\x00" + hash))
+	sum[6] = sum[6]&0x0f | 0x50 // Version 5 style, name-based
+	sum[8] = sum[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// qdrantMatch returns a condition on a payload field equal to value
+func qdrantMatch(key string, value any) map[string]any {
+	return map[string]any{"key": key, "match": map[string]any{"value": value}}
+}
+
+// qdrantFilter translates the filter to a Qdrant filter and reports whether it is
+// exact. Predicates Qdrant can't express, on dates or comparing strings by order, are
+// left out, widening the filter, for DocumentFilter.matches on the points returned. A
+// nil filter matches every point.
+func qdrantFilter(f DocumentFilter) (map[string]any, bool) {
+	switch {
+	case f.And != nil:
+		var must []any
+		exact := true
+		for _, sub := range f.And {
+			condition, ok := qdrantFilter(sub)
+			exact = exact && ok
+			if condition != nil {
+				must = append(must, condition)
+			}
+		}
+		if len(must) == 0 {
+			return nil, exact
+		}
+		return map[string]any{"must": must}, exact
+	case f.Or != nil:
+		if len(f.Or) == 0 {
+			return nil, false
+		}
+		should := make([]any, 0, len(f.Or))
+		for _, sub := range f.Or {
+			condition, ok := qdrantFilter(sub)
+			if condition == nil || !ok {
+				return nil, false // Leaving out a branch would narrow the filter
+			}
+			should = append(should, condition)
+		}
+		return map[string]any{"should": should}, true
+	}
+
+	key := qdrantMetadataKey + "." + f.Key
+	var condition map[string]any
+	if number, ok := filterNumber(f.Value); ok {
+		bound := map[string]any{}
+		switch f.Op {
+		case FilterOpEq, FilterOpNe:
+			bound["gte"], bound["lte"] = number, number
+		default:
+			bound[string(f.Op)] = number
+		}
+		condition = map[string]any{"key": key, "range": bound}
+	} else if _, ok := filterTime(f.Value); ok {
+		return nil, false
+	} else if f.Op != FilterOpEq && f.Op != FilterOpNe {
+		return nil, false
+	} else {
+		condition = qdrantMatch(key, f.Value)
+	}
+	if f.Op == FilterOpNe {
+		return map[string]any{"must_not": []any{condition}}, true
+	}
+	return map[string]any{"must": []any{condition}}, true
+}
@@ -0,0 +1,475 @@
+package plugin
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQdrant stands in for the REST API of one Qdrant collection, keeping its points
+// in memory. Searches rank the points passing the filter by dot product; the filters
+// sent are recorded.
+type fakeQdrant struct {
+	mu       sync.Mutex
+	size     int    // Vector size of the collection, 0 while it doesn't exist
+	distance string // Distance the collection was created with
+	points   map[string]qdrantPoint
+	requests []string         // Method and path of every request, without the collection prefix
+	apiKeys  []string         // api-key header of every request
+	filters  []map[string]any // Filters of the searches
+	fail     func(path string) (status int, message string)
+	server   *httptest.Server
+}
+
+// newFakeQdrant starts a fake whose collection has vectors of size, or doesn't exist
+// when size is 0
+func newFakeQdrant(t *testing.T, size int) *fakeQdrant {
+	f := &fakeQdrant{size: size, points: make(map[string]qdrantPoint)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// log returns the requests answered so far
+func (f *fakeQdrant) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.requests...)
+}
+
+// respondQdrant writes a Qdrant response envelope, or error status
+func respondQdrant(w http.ResponseWriter, status int, result any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if status >= 300 {
+		json.NewEncoder(w).Encode(map[string]any{"status": map[string]any{"error": result}})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"result": result, "status": "ok"})
+}
+
+func (f *fakeQdrant) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/collections/rag_chunks")
+	f.requests = append(f.requests, r.Method+" "+path)
+	f.apiKeys = append(f.apiKeys, r.Header.Get("api-key"))
+	if f.fail != nil {
+		if status, message := f.fail(path); status != 0 {
+			respondQdrant(w, status, message)
+			return
+		}
+	}
+	var body map[string]any
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	if f.size == 0 && (r.Method != http.MethodPut || path != "") {
+		respondQdrant(w, http.StatusNotFound, "Not found: Collection `rag_chunks` doesn't exist!")
+		return
+	}
+
+	switch r.Method + " " + path {
+	case "GET ":
+		respondQdrant(w, http.StatusOK, map[string]any{"config": map[string]any{"params": map[string]any{
+			"vectors": map[string]any{"size": f.size, "distance": f.distance},
+		}}})
+	case "PUT ":
+		vectors := body["vectors"].(map[string]any)
+		f.size, f.distance = int(vectors["size"].(float64)), vectors["distance"].(string)
+		respondQdrant(w, http.StatusOK, true)
+	case "POST /points":
+		var found []qdrantPoint
+		for _, id := range body["ids"].([]any) {
+			if _, ok := f.points[id.(string)]; ok {
+				found = append(found, qdrantPoint{ID: id.(string)})
+			}
+		}
+		respondQdrant(w, http.StatusOK, found)
+	case "POST /points/batch":
+		for _, operation := range body["operations"].([]any) {
+			var op struct {
+				Upsert *struct {
+					Points []qdrantPoint `json:"points"`
+				} `json:"upsert"`
+				OverwritePayload *struct {
+					Payload map[string]any `json:"payload"`
+					Points  []string       `json:"points"`
+				} `json:"overwrite_payload"`
+			}
+			remarshal(operation, &op)
+			if op.Upsert != nil {
+				for _, point := range op.Upsert.Points {
+					if len(point.Vector) != f.size {
+						respondQdrant(w, http.StatusBadRequest, fmt.Sprintf("Wrong input: Vector dimension error: expected dim: %d, got %d", f.size, len(point.Vector)))
+						return
+					}
+					f.points[point.ID] = point
+				}
+			}
+			if op.OverwritePayload != nil {
+				for _, id := range op.OverwritePayload.Points {
+					point := f.points[id]
+					point.Payload = op.OverwritePayload.Payload
+					f.points[id] = point
+				}
+			}
+		}
+		respondQdrant(w, http.StatusOK, true)
+	case "POST /points/delete":
+		filter := body["filter"].(map[string]any)
+		var keep []any
+		if mustNot, ok := filter["must_not"].([]any); ok {
+			keep = mustNot[0].(map[string]any)["has_id"].([]any)
+		}
+		for _, point := range f.matching(filter) {
+			if !slices.Contains(keep, any(point.ID)) {
+				delete(f.points, point.ID)
+			}
+		}
+		respondQdrant(w, http.StatusOK, true)
+	case "POST /points/scroll":
+		points := f.matching(body["filter"].(map[string]any))
+		offset := 0
+		if start, ok := body["offset"].(float64); ok {
+			offset = int(start)
+		}
+		end := min(offset+int(body["limit"].(float64)), len(points))
+		page := map[string]any{"points": points[offset:end], "next_page_offset": nil}
+		if end < len(points) {
+			page["next_page_offset"] = end
+		}
+		respondQdrant(w, http.StatusOK, page)
+	case "POST /points/search":
+		filter, _ := body["filter"].(map[string]any)
+		f.filters = append(f.filters, filter)
+		var vector []float32
+		remarshal(body["vector"], &vector)
+		var points []qdrantPoint
+		for _, point := range f.points {
+			if filter != nil && !qdrantPasses(point.Payload, filter) {
+				continue
+			}
+			point.Score = float64(dot(vector, point.Vector))
+			point.Vector = nil
+			points = append(points, point)
+		}
+		slices.SortFunc(points, func(a, b qdrantPoint) int {
+			return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.ID, b.ID))
+		})
+		respondQdrant(w, http.StatusOK, points[:min(int(body["limit"].(float64)), len(points))])
+	default:
+		respondQdrant(w, http.StatusNotFound, "no such endpoint "+r.Method+" "+path)
+	}
+}
+
+// matching returns the points of the document the filter's first must condition
+// names, ordered by ID
+func (f *fakeQdrant) matching(filter map[string]any) []qdrantPoint {
+	condition := filter["must"].([]any)[0].(map[string]any)
+	documentID := condition["match"].(map[string]any)["value"]
+	var points []qdrantPoint
+	for _, point := range f.points {
+		if point.Payload["document_id"] == documentID {
+			points = append(points, qdrantPoint{ID: point.ID, Payload: point.Payload})
+		}
+	}
+	slices.SortFunc(points, func(a, b qdrantPoint) int { return strings.Compare(a.ID, b.ID) })
+	return points
+}
+
+// qdrantPasses evaluates the must, must_not and should clauses of a Qdrant filter with
+// match and range conditions on a payload
+func qdrantPasses(payload map[string]any, filter map[string]any) bool {
+	condition := func(c any) bool {
+		clause := c.(map[string]any)
+		key, ok := clause["key"].(string)
+		if !ok {
+			return qdrantPasses(payload, clause)
+		}
+		var value any = payload
+		for _, field := range strings.Split(key, ".") {
+			object, _ := value.(map[string]any)
+			value = object[field]
+		}
+		if match, ok := clause["match"].(map[string]any); ok {
+			return value == match["value"]
+		}
+		number, ok := value.(float64)
+		for op, bound := range clause["range"].(map[string]any) {
+			limit := bound.(float64)
+			ok = ok && map[string]bool{"gt": number > limit, "gte": number >= limit, "lt": number < limit, "lte": number <= limit}[op]
+		}
+		return ok
+	}
+	for _, c := range asSlice(filter["must"]) {
+		if !condition(c) {
+			return false
+		}
+	}
+	for _, c := range asSlice(filter["must_not"]) {
+		if condition(c) {
+			return false
+		}
+	}
+	should := asSlice(filter["should"])
+	return len(should) == 0 || slices.ContainsFunc(should, condition)
+}
+
+// asSlice returns value as a slice, nil if it isn't one
+func asSlice(value any) []any {
+	slice, _ := value.([]any)
+	return slice
+}
+
+// remarshal converts decoded JSON into value
+func remarshal(from, value any) {
+	data, _ := json.Marshal(from)
+	json.Unmarshal(data, value)
+}
+
+// dot returns the dot product of two vectors
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range min(len(a), len(b)) {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// newTestQdrantStore returns a store on the fake
+func newTestQdrantStore(t *testing.T, f *fakeQdrant, embedder *testEmbedder, config QdrantConfig) *QdrantStore {
+	t.Helper()
+	config.URL = f.server.URL
+	store, err := NewQdrantStore(context.Background(), embedder.define(t), config)
+	if err != nil {
+		t.Fatalf("NewQdrantStore: %v", err)
+	}
+	return store
+}
+
+func TestNewQdrantStoreCreatesCollection(t *testing.T) {
+	f := newFakeQdrant(t, 0)
+	embedder := &testEmbedder{}
+	newTestQdrantStore(t, f, embedder, QdrantConfig{APIKey: "secret", Distance: QdrantDot})
+
+	if got, want := f.log(), []string{"GET ", "PUT "}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests %q, want %q", got, want)
+	}
+	if f.size != testEmbeddingDimensions || f.distance != QdrantDot {
+		t.Errorf("collection created with size %d and distance %s, want the embedder's %d and Dot", f.size, f.distance, testEmbeddingDimensions)
+	}
+	if got := embedder.embedded(); !reflect.DeepEqual(got, []string{qdrantDimensionProbe}) {
+		t.Errorf("embedded %q, want only the dimension probe", got)
+	}
+	for i, key := range f.apiKeys {
+		if key != "secret" {
+			t.Errorf("request %d sent api-key %q", i, key)
+		}
+	}
+
+	// An existing collection is used as is, unless its size contradicts the configuration
+	newTestQdrantStore(t, f, embedder, QdrantConfig{})
+	if len(f.log()) != 3 {
+		t.Errorf("requests %q, want one more lookup", f.log())
+	}
+	_, err := NewQdrantStore(context.Background(), embedder.define(t), QdrantConfig{URL: f.server.URL, Dimensions: 8})
+	var qdrantErr *QdrantError
+	if !errors.Is(err, ErrQdrantDimensionMismatch) || !errors.As(err, &qdrantErr) || qdrantErr.Hint == "" {
+		t.Errorf("NewQdrantStore on a collection of another size = %v, want a dimension mismatch with a hint", err)
+	}
+}
+
+func TestNewQdrantStoreRejectsConfig(t *testing.T) {
+	embedder := (&testEmbedder{}).define(t)
+	tests := []struct {
+		name     string
+		config   QdrantConfig
+		embedder bool
+		want     string
+	}{
+		{"no URL", QdrantConfig{}, true, "invalid qdrant URL"},
+		{"unknown scheme", QdrantConfig{URL: "grpc://localhost:6334"}, true, "invalid qdrant URL"},
+		{"unknown distance", QdrantConfig{URL: "http://localhost:6333", Distance: "Manhattan"}, true, "unknown qdrant distance"},
+		{"no embedder", QdrantConfig{URL: "http://localhost:6333"}, false, "requires an embedder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := embedder
+			if !tt.embedder {
+				e = nil
+			}
+			if _, err := NewQdrantStore(context.Background(), e, tt.config); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewQdrantStore = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestQdrantStoreIndexAndRetrieve(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeQdrant(t, testEmbeddingDimensions)
+	embedder := &testEmbedder{}
+	store := newTestQdrantStore(t, f, embedder, QdrantConfig{BatchSize: 2})
+
+	paris := Document{ID: "doc_1", Title: "Paris", Source: "https://example.com/paris", Metadata: map[string]any{"lang": "en", "year": 2024}}
+	chunks := []DocumentChunk{
+		{ID: "doc_1_chunk_0", DocumentID: "doc_1", ChunkIndex: 0, Content: "The Eiffel Tower is in Paris."},
+		{ID: "doc_1_chunk_1", DocumentID: "doc_1", ChunkIndex: 1, Content: "It was completed in 1889."},
+		{ID: "doc_1_chunk_2", DocumentID: "doc_1", ChunkIndex: 2, Content: "The Eiffel Tower is in Paris."}, // Same content as the first
+	}
+	if err := store.Index(ctx, []Document{paris}, chunks); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if len(f.points) != 2 {
+		t.Fatalf("stored %d points, want one per distinct chunk", len(f.points))
+	}
+
+	// Indexing the document again embeds only its new chunk and drops the one it lost
+	embedded := len(embedder.embedded())
+	chunks = []DocumentChunk{chunks[0], {ID: "doc_1_chunk_1", DocumentID: "doc_1", ChunkIndex: 1, Content: "The Seine flows through Paris."}}
+	if err := store.Index(ctx, []Document{paris}, chunks); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+	if got := embedder.embedded()[embedded:]; !reflect.DeepEqual(got, []string{chunks[1].Content}) {
+		t.Errorf("reindexing embedded %q, want only the new chunk", got)
+	}
+	var contents []string
+	for _, point := range f.points {
+		contents = append(contents, point.Payload["content"].(string))
+	}
+	slices.Sort(contents)
+	if want := []string{chunks[0].Content, chunks[1].Content}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("stored %q, want %q", contents, want)
+	}
+	if hash, err := store.DocumentHash(ctx, "doc_1"); err != nil || hash != documentHash(paris) {
+		t.Errorf("DocumentHash = %q, %v, want %q", hash, err, documentHash(paris))
+	}
+	if hash, err := store.DocumentHash(ctx, "unknown"); err != nil || hash != "" {
+		t.Errorf("DocumentHash of an unknown document = %q, %v, want none", hash, err)
+	}
+
+	lyon := Document{ID: "doc_2", Title: "Lyon", Metadata: map[string]any{"lang": "fr", "year": 2020}}
+	if err := store.Index(ctx, []Document{lyon}, []DocumentChunk{{ID: "doc_2_chunk_0", DocumentID: "doc_2", Content: "The Eiffel Tower is not in Lyon."}}); err != nil {
+		t.Fatalf("Index: %v", err)
+	}
+
+	// The filter goes to Qdrant and is applied to the points it returns
+	results, err := store.Retrieve(ctx, "Where is the Eiffel Tower?", 1, &DocumentFilter{Key: "lang", Op: FilterOpEq, Value: "en"})
+	if err != nil {
+		t.Fatalf("Retrieve: %v", err)
+	}
+	if len(results) != 1 || results[0].Chunk.DocumentID != "doc_1" || results[0].Chunk.Content != chunks[0].Content ||
+		results[0].Title != "Paris" || results[0].Source != paris.Source || results[0].Metadata["lang"] != "en" {
+		t.Errorf("Retrieve = %+v, want the English Eiffel Tower chunk", results)
+	}
+	want := map[string]any{"must": []any{map[string]any{"key": "metadata.lang", "match": map[string]any{"value": "en"}}}}
+	if !reflect.DeepEqual(f.filters[0], want) {
+		t.Errorf("search filter %v, want %v", f.filters[0], want)
+	}
+
+	hashes, err := store.Delete(ctx, "doc_1")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	slices.Sort(hashes)
+	wantHashes := []string{contentHash(chunks[0].Content), contentHash(chunks[1].Content)}
+	slices.Sort(wantHashes)
+	if !reflect.DeepEqual(hashes, wantHashes) {
+		t.Errorf("Delete returned hashes %q, want %q", hashes, wantHashes)
+	}
+	if len(f.points) != 1 {
+		t.Errorf("%d points left, want only Lyon's", len(f.points))
+	}
+}
+
+func TestQdrantStoreErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		message string
+		kind    error
+		hint    string
+	}{
+		{"collection deleted", http.StatusNotFound, "Not found: Collection `rag_chunks` doesn't exist!", ErrQdrantCollectionNotFound, "create the store again"},
+		{"dimension mismatch", http.StatusBadRequest, "Wrong input: Vector dimension error: expected dim: 8, got 16", ErrQdrantDimensionMismatch, "another embedder"},
+		{"rejected API key", http.StatusUnauthorized, "Invalid api-key", nil, "QdrantConfig.APIKey"},
+		{"server failure", http.StatusServiceUnavailable, "Service unavailable", ErrRetrieverUnavailable, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeQdrant(t, testEmbeddingDimensions)
+			store := newTestQdrantStore(t, f, &testEmbedder{}, QdrantConfig{})
+			f.fail = func(string) (int, string) { return tt.status, tt.message }
+
+			_, err := store.Retrieve(context.Background(), "Where is the Eiffel Tower?", 3, nil)
+			var qdrantErr *QdrantError
+			if !errors.As(err, &qdrantErr) || qdrantErr.Op != "search" || qdrantErr.Status != tt.status || qdrantErr.Message != tt.message {
+				t.Fatalf("Retrieve = %v, want the search failure", err)
+			}
+			if tt.kind != nil && !errors.Is(err, tt.kind) || tt.kind == nil && qdrantErr.Kind != nil {
+				t.Errorf("error kind %v, want %v", qdrantErr.Kind, tt.kind)
+			}
+			if !strings.Contains(qdrantErr.Hint, tt.hint) {
+				t.Errorf("hint %q, want it to mention %q", qdrantErr.Hint, tt.hint)
+			}
+		})
+	}
+
+	// An unreachable server is unavailable too
+	f := newFakeQdrant(t, testEmbeddingDimensions)
+	store := newTestQdrantStore(t, f, &testEmbedder{}, QdrantConfig{})
+	f.server.Close()
+	if _, err := store.Retrieve(context.Background(), "Where is the Eiffel Tower?", 3, nil); !errors.Is(err, ErrRetrieverUnavailable) {
+		t.Errorf("Retrieve from a stopped server = %v, want ErrRetrieverUnavailable", err)
+	}
+}
+
+func TestQdrantFilter(t *testing.T) {
+	en := DocumentFilter{Key: "lang", Op: FilterOpEq, Value: "en"}
+	tests := []struct {
+		name   string
+		filter DocumentFilter
+		want   string
+		exact  bool
+	}{
+		{"equal string", en, `{"must":[{"key":"metadata.lang","match":{"value":"en"}}]}`, true},
+		{"not equal", DocumentFilter{Key: "lang", Op: FilterOpNe, Value: "en"}, `{"must_not":[{"key":"metadata.lang","match":{"value":"en"}}]}`, true},
+		{"number range", DocumentFilter{Key: "year", Op: FilterOpGte, Value: 2020}, `{"must":[{"key":"metadata.year","range":{"gte":2020}}]}`, true},
+		{"equal number", DocumentFilter{Key: "year", Op: FilterOpEq, Value: 2020}, `{"must":[{"key":"metadata.year","range":{"gte":2020,"lte":2020}}]}`, true},
+		{"date", DocumentFilter{Key: "published", Op: FilterOpGt, Value: "2024-01-01"}, `null`, false},
+		{"string order", DocumentFilter{Key: "lang", Op: FilterOpLt, Value: "en"}, `null`, false},
+		{
+			"and keeps what translates",
+			DocumentFilter{And: []DocumentFilter{en, {Key: "published", Op: FilterOpGt, Value: "2024-01-01"}}},
+			`{"must":[{"must":[{"key":"metadata.lang","match":{"value":"en"}}]}]}`, false,
+		},
+		{
+			"or",
+			DocumentFilter{Or: []DocumentFilter{en, {Key: "year", Op: FilterOpLt, Value: 2000}}},
+			`{"should":[{"must":[{"key":"metadata.lang","match":{"value":"en"}}]},{"must":[{"key":"metadata.year","range":{"lt":2000}}]}]}`, true,
+		},
+		{"or with a branch left out", DocumentFilter{Or: []DocumentFilter{en, {Key: "published", Op: FilterOpGt, Value: "2024-01-01"}}}, `null`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, exact := qdrantFilter(tt.filter)
+			got, err := json.Marshal(condition)
+			if err != nil {
+				t.Fatalf("Marshal: %v", err)
+			}
+			if string(got) != tt.want || exact != tt.exact {
+				t.Errorf("qdrantFilter = %s, %v, want %s, %v", got, exact, tt.want, tt.exact)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Reasons the recursive refinement stopped, reported in ProcessingMetadata.RecursionStopReason
+const (
+	StopReasonMaxDepth           = "max_depth"            // The requested recursive depth was reached
+	StopReasonNothingToRefine    = "nothing_to_refine"    // No chunk could be broken down further
+	StopReasonLowInformationGain = "low_information_gain" // The last level added too little new information
+	StopReasonNoNewEntities      = "no_new_entities"      // The last level surfaced no new entities or relations
+	StopReasonConfidenceReached  = "confidence_reached"   // The chunks already answer the query confidently
+)
+
+// levelAssessment is the model's judgement of the chunks after a refinement level
+type levelAssessment struct {
+	InformationGain float64  `json:"information_gain"`
+	Confidence      float64  `json:"confidence"`
+	Entities        []string `json:"entities"`
+	Relations       []string `json:"relations"`
+}
+
+// recursivelyRefineChunks drills down into the chunks one level at a time, up to
+// maxDepth levels. Before each level the chunks are assessed against the configured
+// termination criteria, so the loop stops early once it stops learning anything.
+// Returns the refined chunks, the number of levels run and why the loop stopped.
+func (p *AgenticRAGProcessor) recursivelyRefineChunks(ctx context.Context, query string, chunks []DocumentChunk, maxDepth int) ([]DocumentChunk, int, string, error) {
+	if len(chunks) == 0 {
+		return chunks, 0, StopReasonNothingToRefine, nil
+	}
+
+	criteria := p.config.Processing.Termination
+	assess := criteria.MinInformationGain > 0 || criteria.StopOnNoNewEntities || criteria.TargetConfidence > 0
+	var previous *levelAssessment
+	known := make(map[string]bool)
+
+	for level := 0; level < maxDepth; level++ {
+		if assess {
+			assessment, err := p.assessLevel(ctx, query, chunks, sortedKeys(known), previous)
+			switch {
+			case err != nil && ctx.Err() != nil:
+				return nil, level, "", err
+			case err != nil:
+				assess = false // Keep refining to the full depth without criteria
+			default:
+				if reason := criteria.stopReason(assessment, previous, known); reason != "" {
+					return chunks, level, reason, nil
+				}
+				previous = assessment
+			}
+		}
+
+		refined, changed, err := p.refineLevel(ctx, query, chunks)
+		if err != nil {
+			return nil, level, "", err
+		}
+		if !changed {
+			return chunks, level, StopReasonNothingToRefine, nil
+		}
+		chunks = refined
+	}
+	return chunks, maxDepth, StopReasonMaxDepth, nil
+}
+
+// stopReason returns why the loop should stop given the latest assessment, or "" to
+// keep going. Entities and relations of the assessment are added to known.
+func (c TerminationConfig) stopReason(assessment, previous *levelAssessment, known map[string]bool) string {
+	newFacts := 0
+	facts := append(append([]string(nil), assessment.Entities...), assessment.Relations...)
+	for _, fact := range facts {
+		key := strings.ToLower(strings.TrimSpace(fact))
+		if key != "" && !known[key] {
+			known[key] = true
+			newFacts++
+		}
+	}
+
+	switch {
+	case c.TargetConfidence > 0 && assessment.Confidence >= c.TargetConfidence:
+		return StopReasonConfidenceReached
+	case previous == nil:
+		return "" // Gain and novelty need a level to compare against
+	case c.MinInformationGain > 0 && assessment.InformationGain < c.MinInformationGain:
+		return StopReasonLowInformationGain
+	case c.StopOnNoNewEntities && newFacts == 0:
+		return StopReasonNoNewEntities
+	}
+	return ""
+}
+
+// refineLevel breaks each chunk down into its relevant sub-chunks, concurrently. A
+// chunk that fails is kept unrefined unless fail-fast is set or too many chunks fail.
+// Reports whether any chunk was broken down.
+func (p *AgenticRAGProcessor) refineLevel(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, bool, error) {
+	state := requestStateFrom(ctx)
+	var completed atomic.Int64
+	type refinement struct {
+		chunks  []DocumentChunk
+		changed bool
+	}
+	results, errs, err := runChunks(ctx, p, StageRefinement, chunks,
+		func(ctx context.Context, i int) (refinement, error) {
+			defer func() {
+				state.reportProgress(StageRefinement, min(int(completed.Add(1)), len(chunks)), len(chunks))
+			}()
+			refined, changed, err := p.refineChunk(ctx, query, chunks[i])
+			return refinement{chunks: refined, changed: changed}, err
+		})
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Keep results in chunk order; a failed chunk is kept unrefined
+	refinedChunks := make([]DocumentChunk, 0, len(chunks))
+	changed := false
+	for i, result := range results {
+		if errs[i] != nil {
+			refinedChunks = append(refinedChunks, chunks[i])
+			continue
+		}
+		refinedChunks = append(refinedChunks, result.chunks...)
+		changed = changed || result.changed
+	}
+	return refinedChunks, changed, nil
+}
+
+// refineChunk breaks a single chunk down into its relevant sub-chunks. Small chunks
+// and atomic blocks are kept as is. Reports whether the chunk was broken down.
+func (p *AgenticRAGProcessor) refineChunk(ctx context.Context, query string, chunk DocumentChunk) ([]DocumentChunk, bool, error) {
+	if len(chunk.Content) <= 200 || chunk.BlockType != "" { // Paragraph-level threshold
+		return []DocumentChunk{chunk}, false, nil
+	}
+
+	subChunks := p.breakdownChunk(chunk, requestStateFrom(ctx).languageHint())
+	if len(subChunks) <= 1 {
+		return []DocumentChunk{chunk}, false, nil
+	}
+
+	scored, err := p.scorer.Score(ctx, query, ChunkSet{Chunks: subChunks})
+	if err != nil {
+		return nil, false, err
+	}
+	relevantSubChunks := scored.Chunks
+	if len(relevantSubChunks) == 0 {
+		return []DocumentChunk{chunk}, false, nil
+	}
+	return relevantSubChunks, true, nil
+}
+
+// assessLevel asks the model how much the current chunks add and how well they answer
+// the query, for the termination criteria
+func (p *AgenticRAGProcessor) assessLevel(ctx context.Context, query string, chunks []DocumentChunk, known []string, previous *levelAssessment) (*levelAssessment, error) {
+	if err := p.initializePrompts(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	passages := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		passages[i] = chunk.Content
+	}
+
+	assessmentPrompt := p.lookupPrompt(ctx, "recursion_assessment", p.config.Prompts.RecursionAssessmentPrompt)
+	if assessmentPrompt == nil {
+		return p.assessLevelFallback(ctx, query, passages, known, previous)
+	}
+
+	input := map[string]any{"query": query, "passages": passages}
+	if len(known) > 0 {
+		input["known_entities"] = known
+	}
+	if previous != nil {
+		input["previous_confidence"] = previous.Confidence
+	}
+	response, err := p.executePrompt(ctx, assessmentPrompt, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess refinement level: %w", err)
+	}
+
+	var assessment levelAssessment
+	if err := response.Output(&assessment); err != nil {
+		return nil, fmt.Errorf("failed to parse refinement assessment: %w", err)
+	}
+	return &assessment, nil
+}
+
+// assessLevelFallback assesses the chunks with a hardcoded prompt when the dotprompt
+// isn't available
+func (p *AgenticRAGProcessor) assessLevelFallback(ctx context.Context, query string, passages, known []string, previous *levelAssessment) (*levelAssessment, error) {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "The passages below were narrowed down from larger documents to answer the query %q. Judge whether narrowing them further is still worth it.\n\n", query)
+	for i, passage := range passages {
+		fmt.Fprintf(&prompt, "Passage %d:\n%s\n\n", i, passage)
+	}
+	if len(known) > 0 {
+		fmt.Fprintf(&prompt, "Entities and relations found at earlier levels: %s\n", strings.Join(known, ", "))
+	}
+	if previous != nil {
+		fmt.Fprintf(&prompt, "Confidence at the previous level: %.2f\n", previous.Confidence)
+	}
+	prompt.WriteString(`
+Respond with JSON in this exact format:
+{
+  "information_gain": 0.4,
+  "confidence": 0.7,
+  "entities": ["names of entities the passages mention that bear on the query"],
+  "relations": ["subject predicate object"]
+}
+information_gain (0.0-1.0) is how much relevant information these passages add over the previous level, 1.0 at the first level.
+confidence (0.0-1.0) is how confidently the query can be answered fully from these passages alone.`)
+
+	response, err := p.generate(ctx, prompt.String(), &ai.GenerationCommonConfig{
+		Temperature:     0.1,
+		MaxOutputTokens: 800,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess refinement level: %w", err)
+	}
+
+	text := response.Text()
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	var assessment levelAssessment
+	if err := json.Unmarshal([]byte(text), &assessment); err != nil {
+		return nil, fmt.Errorf("failed to parse refinement assessment: %w", err)
+	}
+	return &assessment, nil
+}
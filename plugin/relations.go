@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"strings"
+)
+
+// Relation validation settings, see KnowledgeGraphConfig.StrictRelationTypes
+const (
+	relationTypeSimilarity   = 0.9 // Jaro-Winkler similarity at which a predicate maps onto a configured type
+	danglingEntityConfidence = 0.3 // Confidence of entities created for relation endpoints
+)
+
+// DanglingRelationPolicy decides what happens to extracted relations whose subject or
+// object wasn't extracted as an entity
+type DanglingRelationPolicy string
+
+const (
+	DanglingRelationsCreate DanglingRelationPolicy = "create" // Add the missing endpoint as an untyped entity with low confidence
+	DanglingRelationsDrop   DanglingRelationPolicy = "drop"   // Drop the relation
+)
+
+// RelationValidation counts what validating extracted relations against the configured
+// relation types and the extracted entities changed, to monitor extraction quality
+type RelationValidation struct {
+	Mapped          int `json:"mapped,omitempty"`           // Predicates rewritten to a configured relation type
+	Unmapped        int `json:"unmapped,omitempty"`         // Predicates matching no type, kept and flagged
+	Dropped         int `json:"dropped,omitempty"`          // Relations dropped for a predicate matching no type
+	DanglingCreated int `json:"dangling_created,omitempty"` // Entities created for relation endpoints
+	DanglingDropped int `json:"dangling_dropped,omitempty"` // Relations dropped for a missing endpoint
+}
+
+// add accumulates other into v
+func (v *RelationValidation) add(other RelationValidation) {
+	v.Mapped += other.Mapped
+	v.Unmapped += other.Unmapped
+	v.Dropped += other.Dropped
+	v.DanglingCreated += other.DanglingCreated
+	v.DanglingDropped += other.DanglingDropped
+}
+
+// validateRelations checks the extracted relations against the configuration. A
+// predicate that isn't a configured relation type is mapped onto the one it equals
+// ignoring case and punctuation, a synonym of or a near miss of, and otherwise dropped
+// with StrictRelationTypes or flagged with an "unmapped_predicate" property. Relations
+// naming no extracted entity create their endpoint or are dropped per the dangling
+// relation policy; those with a blank endpoint are always dropped. Relations the
+// mapping made duplicates of another are merged into it.
+func validateRelations(kg *KnowledgeGraph, config KnowledgeGraphConfig) RelationValidation {
+	var stats RelationValidation
+	if kg == nil || len(kg.Relations) == 0 {
+		return stats
+	}
+	resolveRelationNames(kg)
+
+	types := make(map[string]string, len(config.RelationTypes)+len(config.RelationSynonyms))
+	for _, relationType := range config.RelationTypes {
+		types[normalizeEntityName(relationType)] = relationType
+	}
+	for synonym, relationType := range config.RelationSynonyms {
+		if key := normalizeEntityName(synonym); types[key] == "" {
+			types[key] = relationType
+		}
+	}
+	mapPredicate := func(predicate string) (string, bool) {
+		key := normalizeEntityName(predicate)
+		if relationType, ok := types[key]; ok {
+			return relationType, true
+		}
+		best, bestSimilarity := "", 0.0
+		for _, relationType := range config.RelationTypes {
+			if similarity := jaroWinkler(key, normalizeEntityName(relationType)); similarity > bestSimilarity {
+				best, bestSimilarity = relationType, similarity
+			}
+		}
+		return best, bestSimilarity >= relationTypeSimilarity
+	}
+
+	names := make(map[string]bool, len(kg.Entities))
+	for _, entity := range kg.Entities {
+		names[strings.ToLower(entity.Name)] = true
+	}
+	policy := config.DanglingRelations
+	if policy == "" {
+		policy = DanglingRelationsCreate
+	}
+
+	kept := kg.Relations[:0]
+	seen := make(map[string]int) // Relations mapped onto the same predicate as another are merged into it
+	for _, relation := range kg.Relations {
+		if len(config.RelationTypes) > 0 {
+			relationType, ok := mapPredicate(relation.Predicate)
+			switch {
+			case ok && relationType != relation.Predicate:
+				relation.Predicate = relationType
+				stats.Mapped++
+			case ok:
+			case config.StrictRelationTypes:
+				stats.Dropped++
+				continue
+			default:
+				properties := make(map[string]interface{}, len(relation.Properties)+1)
+				for key, value := range relation.Properties {
+					properties[key] = value
+				}
+				properties["unmapped_predicate"] = true
+				relation.Properties = properties
+				stats.Unmapped++
+			}
+		}
+
+		var missing []string
+		blank := false
+		for _, endpoint := range []string{relation.Subject, relation.Object} {
+			blank = blank || strings.TrimSpace(endpoint) == ""
+			if !names[strings.ToLower(endpoint)] && !containsString(missing, endpoint) {
+				missing = append(missing, endpoint)
+			}
+		}
+		if len(missing) > 0 && (blank || policy == DanglingRelationsDrop) {
+			stats.DanglingDropped++
+			continue
+		}
+		for _, name := range missing {
+			names[strings.ToLower(name)] = true
+			kg.Entities = append(kg.Entities, Entity{
+				Name:       name,
+				Confidence: danglingEntityConfidence,
+				Properties: map[string]interface{}{"created_from_relation": true},
+			})
+			stats.DanglingCreated++
+		}
+		key := strings.ToLower(relation.Subject + "\x00" + relation.Predicate + "\x00" + relation.Object)
+		if i, ok := seen[key]; ok {
+			kept[i].Confidence = max(kept[i].Confidence, relation.Confidence)
+			kept[i].Mentions = mergeMentions(kept[i].Mentions, relation.Mentions)
+			if kept[i].Temporal == nil {
+				kept[i].Temporal = relation.Temporal
+			}
+			continue
+		}
+		seen[key] = len(kept)
+		kept = append(kept, relation)
+	}
+	kg.Relations = kept
+	return stats
+}
@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+)
+
+// relevanceThreshold is the minimum score for a chunk to count as relevant, in every
+// relevance mode
+const relevanceThreshold = 0.3
+
+// identifyRelevantChunks scores chunks against the query with the configured relevance
+// mode and returns the relevant ones, most relevant first. The embedding modes fall
+// back to model scoring when no embedder is configured or embedding fails.
+func (p *AgenticRAGProcessor) identifyRelevantChunks(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
+	mode := p.config.Processing.RelevanceMode
+	embedder := p.embedder()
+	if len(chunks) == 0 || mode == "" || mode == RelevanceModeLLM || embedder == nil {
+		return p.scoreChunksWithLLM(ctx, query, chunks)
+	}
+
+	scored, err := p.scoreChunksWithEmbeddings(ctx, query, chunks)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return p.scoreChunksWithLLM(ctx, query, chunks)
+	}
+
+	if mode == RelevanceModeHybrid {
+		// Only the best embedding matches go on to model scoring
+		if topK := p.config.Processing.HybridTopK; topK > 0 && len(scored) > topK {
+			scored = scored[:topK]
+		}
+		return p.scoreChunksWithLLM(ctx, query, scored)
+	}
+
+	relevant := scored[:0]
+	for _, chunk := range scored {
+		if chunk.RelevanceScore >= relevanceThreshold {
+			relevant = append(relevant, chunk)
+		}
+	}
+	return relevant, nil
+}
+
+// scoreChunksWithEmbeddings scores every chunk by the cosine similarity of its
+// embedding to the query's, clamped to the 0-1 scale used by model scoring, and
+// returns them sorted most similar first
+func (p *AgenticRAGProcessor) scoreChunksWithEmbeddings(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
+	texts := make([]string, len(chunks)+1)
+	texts[0] = query
+	for i, chunk := range chunks {
+		texts[i+1] = chunk.Content
+	}
+
+	embeddings, err := p.embed(ctx, p.embedder(), texts)
+	if err != nil {
+		return nil, err
+	}
+
+	scored := make([]DocumentChunk, len(chunks))
+	for i, chunk := range chunks {
+		chunk.RelevanceScore = max(0, min(1, cosineSimilarity(embeddings[0], embeddings[i+1])))
+		scored[i] = chunk
+	}
+	sortByRelevance(scored)
+	return scored, nil
+}
+
+// sortByRelevance sorts chunks most relevant first, breaking ties by document and
+// chunk index so equal scores always come out in the same order
+func sortByRelevance(chunks []DocumentChunk) {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		a, b := chunks[i], chunks[j]
+		if a.RelevanceScore != b.RelevanceScore {
+			return a.RelevanceScore > b.RelevanceScore
+		}
+		if a.DocumentID != b.DocumentID {
+			return a.DocumentID < b.DocumentID
+		}
+		return a.ChunkIndex < b.ChunkIndex
+	})
+}
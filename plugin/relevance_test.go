@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"fmt"
+	"testing"
+)
+
+// benchmarkChunks is the chunk count of the relevance benchmarks' request
+const benchmarkChunks = 50
+
+// benchmarkRequest returns a request whose documents chunk into benchmarkChunks
+// chunks, one per document
+func benchmarkRequest() AgenticRAGRequest {
+	documents := make([]string, benchmarkChunks)
+	for i := range documents {
+		documents[i] = fmt.Sprintf("%s Document %d adds detail number %d.", testDocuments[i%len(testDocuments)], i, i)
+	}
+	return AgenticRAGRequest{
+		Query:     "Where is the Eiffel Tower?",
+		Documents: documents,
+		Options:   AgenticRAGOptions{MaxChunks: benchmarkChunks, RecursiveDepth: 1},
+	}
+}
+
+// BenchmarkRelevanceModes reports the model and embedding calls a 50-chunk request
+// makes in each relevance mode. The embedding cache is off so every iteration embeds
+// the chunks again, as a request over new documents would.
+func BenchmarkRelevanceModes(b *testing.B) {
+	for _, mode := range []RelevanceMode{RelevanceModeLLM, RelevanceModeEmbedding, RelevanceModeHybrid} {
+		b.Run(string(mode), func(b *testing.B) {
+			mock := &mockProvider{}
+			embedder := &testEmbedder{}
+			p := newTestProcessor(b, mock, func(config *AgenticRAGConfig) {
+				config.Processing.RelevanceMode = mode
+				config.Processing.DefaultMaxChunks = benchmarkChunks
+				config.Embedder = embedder.define(b)
+				config.Embedding.CacheSize = 0
+			})
+			request := benchmarkRequest()
+
+			embeddingCalls := 0
+			b.ResetTimer()
+			for range b.N {
+				response := mustProcess(b, p, request)
+				if chunks := response.ProcessingMetadata.ChunksProcessed; chunks != benchmarkChunks {
+					b.Fatalf("request processed %d chunks, want %d", chunks, benchmarkChunks)
+				}
+				embeddingCalls += response.ProcessingMetadata.EmbeddingCalls
+			}
+			b.ReportMetric(float64(mock.callCount())/float64(b.N), "model-calls/op")
+			b.ReportMetric(float64(embeddingCalls)/float64(b.N), "embed-calls/op")
+		})
+	}
+}
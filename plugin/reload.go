@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+	"github.com/fsnotify/fsnotify"
+)
+
+// defaultPromptWatchDebounce is how long the prompts watcher waits for writes to
+// settle when PromptsConfig.WatchDebounce is unset
+const defaultPromptWatchDebounce = 100 * time.Millisecond
+
+// promptSet is the prompts reloaded from the prompts directory, by name including any
+// variant such as "relevance_scoring.concise", and the content hash of every prompt
+// file seen. A set is never modified once published; each reload publishes a new one.
+type promptSet struct {
+	prompts map[string]*ai.Prompt
+	hashes  map[string]string
+}
+
+// ReloadPrompts loads the prompt files of PromptsConfig.Directory whose content
+// changed since they were last loaded. New requests get the new versions while
+// requests in flight keep the ones they started with. A file that fails to load is
+// logged and the previous version kept; the failures are also returned. Partials and
+// deleted files aren't reloaded.
+func (p *AgenticRAGProcessor) ReloadPrompts(ctx context.Context) error {
+	if p.config.Genkit == nil || p.config.Prompts.Directory == "" {
+		return nil
+	}
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	files, err := promptFiles(p.config.Prompts.Directory)
+	if err != nil {
+		return err
+	}
+	current := p.prompts.Load()
+	next := &promptSet{prompts: make(map[string]*ai.Prompt), hashes: make(map[string]string)}
+	if current != nil {
+		for name, prompt := range current.prompts {
+			next.prompts[name] = prompt
+		}
+		for name, hash := range current.hashes {
+			next.hashes[name] = hash
+		}
+	}
+
+	var errs []error
+	changed := false
+	for _, path := range files {
+		name := strings.TrimSuffix(filepath.Base(path), ".prompt")
+		content, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read prompt %s: %w", name, err))
+			continue
+		}
+		hash := contentHash(string(content))
+		if next.hashes[name] == hash {
+			continue
+		}
+		if current == nil {
+			// The first scan records what genkit loaded at startup
+			next.hashes[name] = hash
+			continue
+		}
+
+		// Each version registers under its own namespace, as genkit can't replace a
+		// registered prompt
+		namespace := "reload-" + hash[:16]
+		prompt := genkit.LookupPrompt(p.config.Genkit, namespace+"/"+name)
+		if prompt == nil {
+			prompt, err = genkit.LoadPrompt(p.config.Genkit, path, namespace)
+			if err == nil && prompt == nil {
+				err = errors.New("invalid dotprompt")
+			}
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to reload prompt, keeping the previous version", "prompt", name, "hash", hash, "error", err)
+			errs = append(errs, fmt.Errorf("failed to reload prompt %s: %w", name, err))
+			continue
+		}
+		next.prompts[name] = prompt
+		next.hashes[name] = hash
+		changed = true
+		slog.InfoContext(ctx, "reloaded prompt", "prompt", name, "hash", hash)
+	}
+	if current == nil || changed {
+		p.prompts.Store(next)
+	}
+	return errors.Join(errs...)
+}
+
+// promptFiles returns the paths of the prompt files under dir, leaving out partials and
+// the _partials subdirectory
+func promptFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() && entry.Name() == partialsDirectory {
+			return filepath.SkipDir
+		}
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".prompt") && !strings.HasPrefix(entry.Name(), "_") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prompts directory: %w", err)
+	}
+	return files, nil
+}
+
+// watchPrompts watches the prompts directory and its subdirectories, reloading the
+// prompt files once writes to them have settled for debounce. Editors save in bursts
+// of writes, renames and creates, so each burst triggers one reload. Returns a
+// function that stops watching and waits for the watcher to exit.
+func (p *AgenticRAGProcessor) watchPrompts(debounce time.Duration) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch prompts directory: %w", err)
+	}
+	dirs, err := promptDirs(p.config.Prompts.Directory)
+	if err == nil {
+		for _, dir := range dirs {
+			if err = watcher.Add(dir); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("failed to watch prompts directory: %w", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ctx := context.Background()
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		defer timer.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) {
+					// Watch directories created later too
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() && info.Name() != partialsDirectory {
+						if err := watcher.Add(event.Name); err != nil {
+							slog.ErrorContext(ctx, "failed to watch prompts directory", "directory", event.Name, "error", err)
+						}
+					}
+				}
+				if strings.HasSuffix(event.Name, ".prompt") && !event.Has(fsnotify.Chmod) {
+					timer.Reset(debounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.ErrorContext(ctx, "prompts directory watcher failed", "error", err)
+			case <-timer.C:
+				// Failures are logged by ReloadPrompts
+				_ = p.ReloadPrompts(ctx)
+			}
+		}
+	}()
+	return func() {
+		watcher.Close()
+		<-done
+	}, nil
+}
+
+// promptDirs returns dir and its subdirectories, leaving out the _partials
+// subdirectory
+func promptDirs(dir string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == partialsDirectory {
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// Close stops watching the prompts directory. The processor stays usable.
+func (p *AgenticRAGProcessor) Close() error {
+	p.stopWatchOnce.Do(func() {
+		if p.stopWatch != nil {
+			p.stopWatch()
+		}
+	})
+	return nil
+}
+
+// reloadedPrompt returns the reloaded version of the named prompt the request started
+// with, or nil to use the one genkit loaded at startup
+func (p *AgenticRAGProcessor) reloadedPrompt(ctx context.Context, name string) *ai.Prompt {
+	set := requestStateFrom(ctx).promptSnapshot()
+	if set == nil {
+		set = p.prompts.Load()
+	}
+	if set == nil {
+		return nil
+	}
+	return set.prompts[name]
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePrompt writes a dotprompt file for the mock model whose template says version
+func writePrompt(t *testing.T, path, version string) string {
+	t.Helper()
+	content := fmt.Sprintf("---\nmodel: %s\n---\nAnswer {{query}} in the style of version %s.\n", mockModel, version)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return contentHash(content)
+}
+
+// waitForPromptHash waits until the processor has loaded the named prompt with the
+// given content hash
+func waitForPromptHash(t *testing.T, p *AgenticRAGProcessor, name, hash string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		set := p.prompts.Load()
+		if set != nil && set.hashes[name] == hash && set.prompts[name] != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("prompt %s was never reloaded with hash %s", name, hash)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchPromptsReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.prompt")
+	writePrompt(t, path, "1")
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Prompts.Directory = dir
+		config.Prompts.Watch = true
+		config.Prompts.WatchDebounce = 20 * time.Millisecond
+	})
+	t.Cleanup(func() { p.Close() })
+	if p.stopWatch == nil {
+		t.Fatal("the prompts directory isn't watched")
+	}
+
+	waitForPromptHash(t, p, "custom", writePrompt(t, path, "2"))
+
+	// A burst of writes settles on the last one
+	var hash string
+	for version := range 5 {
+		hash = writePrompt(t, path, fmt.Sprintf("3.%d", version))
+	}
+	waitForPromptHash(t, p, "custom", hash)
+
+	// Prompts in directories created after the watch started are picked up too
+	nested := filepath.Join(dir, "nested")
+	if err := os.Mkdir(nested, 0o755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	waitForPromptHash(t, p, "other", writePrompt(t, filepath.Join(nested, "other.prompt"), "1"))
+
+	// Once closed, changes are left for ReloadPrompts
+	p.Close()
+	p.Close()
+	stale := p.prompts.Load()
+	writePrompt(t, path, "4")
+	time.Sleep(100 * time.Millisecond)
+	if p.prompts.Load() != stale {
+		t.Errorf("prompts were reloaded after Close")
+	}
+}
+
+func TestReloadPromptsMissesResponseCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.prompt")
+	writePrompt(t, path, "1")
+	p := newTestProcessor(t, &mockProvider{}, func(config *AgenticRAGConfig) {
+		config.Prompts.Directory = dir
+		config.Cache.Enabled = true
+	})
+
+	request := testRequest("Where is the Eiffel Tower?")
+	mustProcess(t, p, request)
+	if !mustProcess(t, p, request).ProcessingMetadata.CacheHit {
+		t.Fatal("the repeated request missed the cache")
+	}
+
+	hash := writePrompt(t, path, "2")
+	if err := p.ReloadPrompts(context.Background()); err != nil {
+		t.Fatalf("ReloadPrompts: %v", err)
+	}
+	waitForPromptHash(t, p, "custom", hash)
+	if mustProcess(t, p, request).ProcessingMetadata.CacheHit {
+		t.Error("a response of the old prompts was served after the reload")
+	}
+	if !mustProcess(t, p, request).ProcessingMetadata.CacheHit {
+		t.Error("the request missed the cache with the reloaded prompts")
+	}
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verdictBadges labels each verdict in verification reports
+var verdictBadges = map[Verdict]string{
+	VerdictSupported:          "✅ Supported",
+	VerdictRefuted:            "❌ Refuted",
+	VerdictPartiallySupported: "⚠️ Partially supported",
+	VerdictNotEnoughEvidence:  "❔ Not enough evidence",
+}
+
+// overallBadges labels each overall outcome in verification reports
+var overallBadges = map[string]string{
+	VerificationVerified: "✅ Verified",
+	VerificationRefuted:  "❌ Refuted",
+	VerificationMixed:    "⚠️ Mixed",
+}
+
+// markdownEscaper backslash-escapes the characters with inline meaning in markdown and
+// table cells, and replaces those with meaning in HTML by entities. Block syntax such
+// as headings and lists can't start within a table row.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "|", `\|`, "~", `\~`,
+	"<", "&lt;", ">", "&gt;", "&", "&amp;",
+)
+
+// WithVerificationReport attaches the markdown report of the fact verification to
+// responses that have one, see FactVerification.RenderMarkdown
+func WithVerificationReport() ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.verificationReport = true }
+}
+
+// RenderMarkdown renders the verification as a markdown report for reviewers: a
+// summary with the overall outcome and the claims per verdict, and a table of the
+// claims with their verdict, confidence, quoted evidence and where it was found. Text
+// from the claims and documents is escaped so it can't change the report's structure.
+// The output depends on the verification alone, so it's stable for snapshot tests.
+func (v *FactVerification) RenderMarkdown() string {
+	var b strings.Builder
+	b.WriteString("# Fact Verification Report\n\n## Summary\n\n")
+	overall := overallBadges[v.Overall]
+	if overall == "" {
+		overall = markdownText(v.Overall)
+	}
+	fmt.Fprintf(&b, "**Overall:** %s\n\n", overall)
+
+	counts := make(map[Verdict]int)
+	for _, claim := range v.Claims {
+		counts[claim.Verdict]++
+	}
+	b.WriteString("| Verdict | Claims |\n| --- | ---: |\n")
+	for _, verdict := range verdictOrder {
+		fmt.Fprintf(&b, "| %s | %d |\n", verdictBadges[verdict], counts[verdict])
+	}
+	fmt.Fprintf(&b, "| **Total** | **%d** |\n", len(v.Claims))
+	if len(v.Claims) == 0 {
+		return b.String()
+	}
+
+	b.WriteString("\n## Claims\n\n| # | Claim | Verdict | Confidence | Evidence | Notes |\n| ---: | --- | --- | ---: | --- | --- |\n")
+	for i, claim := range v.Claims {
+		badge := verdictBadges[claim.Verdict]
+		if badge == "" {
+			badge = markdownText(string(claim.Verdict))
+		}
+		fmt.Fprintf(&b, "| %d | %s | %s | %.2f | %s | %s |\n",
+			i+1, markdownText(claim.Text), badge, claim.Confidence, claimEvidenceCell(claim), claimNotesCell(claim))
+	}
+	return b.String()
+}
+
+// claimEvidenceCell renders the claim's evidence for a table cell: the located spans
+// with their document, page and offsets, else the model's evidence, then any external
+// evidence
+func claimEvidenceCell(claim Claim) string {
+	var items []string
+	for _, span := range claim.EvidenceSpans {
+		reference := "document " + markdownText(span.DocumentID)
+		if span.Page > 0 {
+			reference += fmt.Sprintf(", p. %d", span.Page)
+		}
+		reference += fmt.Sprintf(", chars %d–%d", span.CharStart, span.CharEnd)
+		items = append(items, fmt.Sprintf("“%s” (%s)", markdownText(span.Text), reference))
+	}
+	if len(claim.EvidenceSpans) == 0 {
+		for _, evidence := range claim.Evidence {
+			items = append(items, "“"+markdownText(evidence)+"”")
+		}
+	}
+	for _, evidence := range claim.ExternalEvidence {
+		source := markdownText(evidence.URL)
+		if evidence.Title != "" {
+			source = markdownText(evidence.Title) + ", " + source
+		}
+		items = append(items, fmt.Sprintf("“%s” (%s)", markdownText(evidence.Text), source))
+	}
+	if len(items) == 0 {
+		return "—"
+	}
+	return strings.Join(items, "<br>")
+}
+
+// claimNotesCell renders what else a reviewer should know about the claim for a table
+// cell
+func claimNotesCell(claim Claim) string {
+	var notes []string
+	if claim.NumericConflict != "" {
+		notes = append(notes, "Numeric conflict: "+markdownText(claim.NumericConflict))
+	}
+	if len(claim.Votes) > 0 {
+		var votes []string
+		for _, verdict := range verdictOrder {
+			if count := claim.Votes[verdict]; count > 0 {
+				votes = append(votes, fmt.Sprintf("%s %d", markdownText(string(verdict)), count))
+			}
+		}
+		notes = append(notes, "Votes: "+strings.Join(votes, ", "))
+	}
+	if claim.Cached {
+		notes = append(notes, "Cached verdict")
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return strings.Join(notes, "<br>")
+}
+
+// markdownText escapes text for a markdown table cell, collapsing its whitespace so it
+// stays on one row
+func markdownText(text string) string {
+	return markdownEscaper.Replace(collapseWhitespace(text))
+}
@@ -0,0 +1,98 @@
+package plugin
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares got with the golden file, rewriting it with -update
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("failed to update %s: %v", path, err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s, rerun with -update if intended:\n%s", path, got)
+	}
+}
+
+// reportVerification exercises every part of the report, with document text that
+// tries to break out of its table cell
+func reportVerification() *FactVerification {
+	return &FactVerification{
+		Overall: VerificationMixed,
+		Claims: []Claim{
+			{
+				Text:       "The Eiffel Tower is in Paris.",
+				Verdict:    VerdictSupported,
+				Confidence: 0.95,
+				EvidenceSpans: []EvidenceSpan{
+					{ChunkID: "doc_1_chunk_0", DocumentID: "doc_1", CharStart: 0, CharEnd: 44, Page: 3, Text: "The Eiffel Tower is located in Paris, France"},
+				},
+				Votes: map[Verdict]int{VerdictSupported: 2, VerdictPartiallySupported: 1},
+			},
+			{
+				Text:            "The tower is 500 metres tall.",
+				Verdict:         VerdictRefuted,
+				Confidence:      0.8,
+				Evidence:        []string{"The tower is 330 metres | tall\n| --- | injected row |"},
+				NumericConflict: "claim says 500, evidence says 330",
+			},
+			{
+				Text:       "It was the *tallest* [structure](javascript:alert(1)) <script>alert(1)</script> until 1930.",
+				Verdict:    VerdictPartiallySupported,
+				Confidence: 0.55,
+				ExternalEvidence: []Evidence{
+					{Text: "It held the record for `41` years & more.", URL: "https://example.com/eiffel_tower", Title: "Tower ~ history", Origin: "google_search"},
+				},
+				Cached: true,
+			},
+			{
+				Text:       "# Gustave Eiffel designed it\\",
+				Verdict:    VerdictNotEnoughEvidence,
+				Confidence: 0.3,
+			},
+		},
+	}
+}
+
+func TestRenderMarkdownSnapshot(t *testing.T) {
+	report := reportVerification().RenderMarkdown()
+	checkGolden(t, "verification_report.md", report)
+
+	// Rendering is deterministic, whatever the map iteration order
+	for range 20 {
+		if again := reportVerification().RenderMarkdown(); again != report {
+			t.Fatalf("report differs between renders:\n%s", again)
+		}
+	}
+
+	// Each claim stays on its own table row
+	var rows int
+	for _, line := range strings.Split(report, "\n") {
+		if strings.HasPrefix(line, "| ") && strings.HasSuffix(line, " |") && !strings.HasPrefix(line, "| ---") {
+			rows++
+		}
+	}
+	// Both headers, the verdict counts and their total, and the claims
+	if want := 2 + len(verdictOrder) + 1 + len(reportVerification().Claims); rows != want {
+		t.Errorf("report has %d table rows, want %d", rows, want)
+	}
+}
+
+func TestRenderMarkdownNoClaims(t *testing.T) {
+	report := (&FactVerification{Claims: []Claim{}, Overall: VerificationVerified}).RenderMarkdown()
+	checkGolden(t, "verification_report_empty.md", report)
+}
@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Reranker rescores chunks against a query. Implementations return one score between
+// 0 and 1 per chunk, in the order given, and may wrap an external reranking API.
+type Reranker interface {
+	Rerank(ctx context.Context, query string, chunks []DocumentChunk) ([]float64, error)
+}
+
+// promptReranker is the built-in Reranker, scoring each query and chunk pair with a
+// dedicated prompt
+type promptReranker struct {
+	processor *AgenticRAGProcessor
+}
+
+// reranker returns the configured reranker, or the built-in prompt reranker
+func (p *AgenticRAGProcessor) reranker() Reranker {
+	if p.config.Reranker != nil {
+		return p.config.Reranker
+	}
+	return promptReranker{processor: p}
+}
+
+// rerankChunks rescores the top topN chunks with the reranker and reorders them by
+// the new scores. Chunks past topN keep their first-pass order after the reranked
+// ones. Returns the reordered chunks and the orderings before and after.
+func (p *AgenticRAGProcessor) rerankChunks(ctx context.Context, query string, chunks []DocumentChunk, topN int) ([]DocumentChunk, *RerankMetadata, error) {
+	if topN <= 0 || topN > len(chunks) {
+		topN = len(chunks)
+	}
+	if topN == 0 {
+		return chunks, nil, nil
+	}
+
+	metadata := &RerankMetadata{Before: rankedChunks(chunks)}
+	top := append([]DocumentChunk(nil), chunks[:topN]...)
+	scores, err := p.reranker().Rerank(ctx, query, top)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(scores) != len(top) {
+		return nil, nil, fmt.Errorf("reranker returned %d scores for %d chunks", len(scores), len(top))
+	}
+
+	for i := range top {
+		top[i].RelevanceScore = max(0, min(1, scores[i]))
+	}
+	sort.SliceStable(top, func(i, j int) bool {
+		return top[i].RelevanceScore > top[j].RelevanceScore
+	})
+
+	reranked := append(top, chunks[topN:]...)
+	metadata.After = rankedChunks(reranked)
+	return reranked, metadata, nil
+}
+
+// rankedChunks returns the ordering of chunks for the rerank metadata
+func rankedChunks(chunks []DocumentChunk) []RankedChunk {
+	ranked := make([]RankedChunk, len(chunks))
+	for i, chunk := range chunks {
+		ranked[i] = RankedChunk{ChunkID: chunk.ID, Score: chunk.RelevanceScore}
+	}
+	return ranked
+}
+
+// Rerank scores each chunk with its own model call, in parallel. A chunk whose call
+// fails keeps its first-pass score unless fail-fast is set or too many chunks fail.
+func (r promptReranker) Rerank(ctx context.Context, query string, chunks []DocumentChunk) ([]float64, error) {
+	p := r.processor
+	if err := p.initializePrompts(ctx); err != nil {
+		return nil, fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	rerankPrompt := p.lookupPrompt(ctx, "rerank", p.config.Prompts.RerankPrompt)
+
+	state := requestStateFrom(ctx)
+	scores, errs, err := runChunks(ctx, p, StageReranking, chunks,
+		func(ctx context.Context, i int) (float64, error) {
+			score, err := p.rerankScore(ctx, rerankPrompt, query, chunks[i])
+			state.reportProgress(StageReranking, i+1, len(chunks))
+			return score, err
+		})
+	if err != nil {
+		return nil, err
+	}
+	for i, chunkErr := range errs {
+		if chunkErr != nil {
+			scores[i] = chunks[i].RelevanceScore
+		}
+	}
+	return scores, nil
+}
+
+// rerankScore scores one chunk against the query, with the hardcoded prompt when the
+// dotprompt isn't available
+func (p *AgenticRAGProcessor) rerankScore(ctx context.Context, rerankPrompt *ai.Prompt, query string, chunk DocumentChunk) (float64, error) {
+	content := chunk.Content
+	if label := chunkLabel(chunk); label != "" {
+		content = fmt.Sprintf("[%s]\n%s", label, chunk.Content)
+	}
+
+	var result struct {
+		Score float64 `json:"score"`
+	}
+	if rerankPrompt != nil {
+		response, err := p.executePrompt(ctx, rerankPrompt, map[string]any{
+			"query": query,
+			"chunk": content,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if err := response.Output(&result); err != nil {
+			return 0, fmt.Errorf("failed to parse rerank score: %w", err)
+		}
+		return result.Score, nil
+	}
+
+	prompt := fmt.Sprintf(`Judge how well the passage answers the query. Read them together and give a calibrated score between 0.0 and 1.0:
+0.9+ only if the passage directly and fully answers the query, 0.6-0.8 if it answers part of it or gives essential context,
+0.3-0.5 if it is on topic but doesn't help, below 0.3 if it is unrelated.
+
+Query: %q
+
+Passage:
+%s
+
+Respond with JSON only, e.g. {"score": 0.75}`, query, content)
+
+	response, err := p.generate(ctx, prompt, &ai.GenerationCommonConfig{
+		Temperature:     0,
+		MaxOutputTokens: 100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	text := strings.TrimSpace(response.Text())
+	text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```"), "```")
+	if err := json.Unmarshal([]byte(strings.TrimSpace(text)), &result); err != nil {
+		return 0, fmt.Errorf("failed to parse rerank score: %w", err)
+	}
+	return result.Score, nil
+}
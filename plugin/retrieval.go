@@ -0,0 +1,237 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// ScoredChunk is a chunk retrieved from an index for a query
+type ScoredChunk struct {
+	Chunk    DocumentChunk  // ID, Content and DocumentID are used; offsets are recomputed
+	Score    float64        // Similarity to the query between 0 and 1, seeding the chunk's relevance score
+	Title    string         // Title of the chunk's document, if known
+	Source   string         // URI of the chunk's document, if known
+	Metadata map[string]any // Metadata of the chunk's document, matched by document filters
+}
+
+// Retriever pulls the chunks most similar to a query from an index, for corpora too
+// large to pass in the request. Implementations return at most k chunks, most similar
+// first, and should only return chunks matching the filter when it isn't nil.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, k int, filter *DocumentFilter) ([]ScoredChunk, error)
+}
+
+// Indexer stores chunks for a Retriever to search. Indexing a document again replaces
+// its chunks.
+type Indexer interface {
+	// Index stores the chunks, recording the documentHash of each of their documents
+	Index(ctx context.Context, documents []Document, chunks []DocumentChunk) error
+	// DocumentHash returns the documentHash recorded when the document was last
+	// indexed, empty if it isn't indexed
+	DocumentHash(ctx context.Context, documentID string) (string, error)
+	// Delete removes the chunks of the document, returning their content hashes
+	Delete(ctx context.Context, documentID string) ([]string, error)
+}
+
+// volatileMetadata are the metadata keys loading sets that change between loads of the
+// same content
+var volatileMetadata = []string{"loaded_at", "fetched_at", "from_cache"}
+
+// documentHash returns the hash of what a loaded document puts in an index: its text,
+// title, source and metadata, without volatileMetadata
+func documentHash(doc Document) string {
+	metadata := make(map[string]any, len(doc.Metadata))
+	for key, value := range doc.Metadata {
+		if !containsString(volatileMetadata, key) {
+			metadata[key] = value
+		}
+	}
+	data, _ := json.Marshal(Document{Title: doc.Title, Content: doc.Content, Source: doc.Source, Metadata: metadata, Pages: doc.Pages})
+	return contentHash(string(data))
+}
+
+// retrievalOverfetch multiplies the chunks a store fetches when part of a filter can't
+// be translated to its query language and is applied to the chunks fetched instead
+const retrievalOverfetch = 4
+
+// Metadata keys of genkit documents read by NewGenkitRetriever
+const (
+	RetrievedDocumentIDKey = "document_id" // ID of the document the chunk was cut from
+	RetrievedTitleKey      = "title"       // Title of that document
+	RetrievedSourceKey     = "source"      // URI of that document
+	RetrievedScoreKey      = "score"       // Similarity to the query between 0 and 1
+)
+
+// genkitRetriever is the Retriever wrapping a genkit ai.Retriever
+type genkitRetriever struct {
+	retriever ai.Retriever
+	options   func(k int, filter *DocumentFilter) any
+}
+
+// NewGenkitRetriever adapts a genkit retriever, such as a vector store plugin's, to a
+// Retriever. options builds the retriever's store-specific options for a request, e.g.
+// &localvec.RetrieverOptions{K: k}, and may translate the filter; nil sends none.
+//
+// Each genkit document is a chunk. Its metadata under RetrievedDocumentIDKey,
+// RetrievedTitleKey and RetrievedSourceKey describes the document it was cut from, and
+// RetrievedScoreKey holds its score, which defaults to its rank otherwise. The filter
+// is also applied to the metadata of the documents returned.
+func NewGenkitRetriever(retriever ai.Retriever, options func(k int, filter *DocumentFilter) any) Retriever {
+	return genkitRetriever{retriever: retriever, options: options}
+}
+
+// Retrieve runs the genkit retriever and converts the documents it returns
+func (r genkitRetriever) Retrieve(ctx context.Context, query string, k int, filter *DocumentFilter) ([]ScoredChunk, error) {
+	request := &ai.RetrieverRequest{Query: ai.DocumentFromText(query, nil)}
+	if r.options != nil {
+		request.Options = r.options(k, filter)
+	}
+	response, err := r.retriever.Retrieve(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("retriever %s failed: %w", r.retriever.Name(), err)
+	}
+
+	chunks := make([]ScoredChunk, 0, len(response.Documents))
+	for i, doc := range response.Documents {
+		if doc == nil || (filter != nil && !filter.matches(doc.Metadata)) {
+			continue
+		}
+		var text strings.Builder
+		for _, part := range doc.Content {
+			if part.IsText() {
+				text.WriteString(part.Text)
+			}
+		}
+		score, ok := filterNumber(doc.Metadata[RetrievedScoreKey])
+		if !ok {
+			score = 1 - float64(i)/float64(len(response.Documents))
+		}
+		documentID, _ := doc.Metadata[RetrievedDocumentIDKey].(string)
+		title, _ := doc.Metadata[RetrievedTitleKey].(string)
+		source, _ := doc.Metadata[RetrievedSourceKey].(string)
+		chunks = append(chunks, ScoredChunk{
+			Chunk:    DocumentChunk{Content: text.String(), DocumentID: documentID},
+			Score:    score,
+			Title:    title,
+			Source:   source,
+			Metadata: doc.Metadata,
+		})
+		if len(chunks) == k {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+// IndexDocuments loads and chunks the documents as requests do, without a limit on
+// the chunks per document, and stores the chunks with the indexer, returning how many
+// were indexed
+func (p *AgenticRAGProcessor) IndexDocuments(ctx context.Context, indexer Indexer, docs []Document) (int, error) {
+	documents, err := p.loadDocuments(ctx, docs, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load documents: %w", err)
+	}
+	set, err := p.chunker.Chunk(ctx, documents, math.MaxInt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to chunk documents: %w", err)
+	}
+	if err := indexer.Index(ctx, documents, set.Chunks); err != nil {
+		return 0, fmt.Errorf("failed to index chunks: %w", err)
+	}
+	return len(set.Chunks), nil
+}
+
+// retriever returns the configured retriever, or the indexer if it can retrieve
+func (p *AgenticRAGProcessor) retriever() Retriever {
+	if p.config.Retriever != nil {
+		return p.config.Retriever
+	}
+	retriever, _ := p.config.Indexer.(Retriever)
+	return retriever
+}
+
+// retrieveCorpus pulls the chunks for the query from the configured retriever in place
+// of loading and chunking the request's documents, returning them with the documents
+// they were cut from
+func (p *AgenticRAGProcessor) retrieveCorpus(ctx context.Context, query string, filter *DocumentFilter) ([]Document, []DocumentChunk, error) {
+	state := requestStateFrom(ctx)
+	k := p.config.Processing.RetrievalTopK
+
+	var (
+		documents []Document
+		chunks    []DocumentChunk
+	)
+	err := runStage(ctx, StageRetrieval, func() (string, error) {
+		p.startStage(ctx, StageRetrieval, fmt.Sprintf("query %q, top %d", query, k))
+		state.reportProgress(StageRetrieval, 0, 1)
+		retrieved, err := p.retriever().Retrieve(withStage(ctx, StageRetrieval), query, k, filter)
+		if errors.Is(err, ErrRetrieverUnavailable) {
+			err = fmt.Errorf("failed to retrieve chunks, pass the documents in the request to answer while the retriever is unavailable: %w", err)
+		} else if err != nil {
+			err = fmt.Errorf("failed to retrieve chunks: %w", err)
+		}
+		if err != nil {
+			p.failStage(ctx, err)
+			return "", err
+		}
+		if len(retrieved) > k {
+			retrieved = retrieved[:k]
+		}
+		documents, chunks = retrievedCorpus(retrieved)
+		state.recordRetrievedChunks(chunks)
+		state.reportProgress(StageRetrieval, 1, 1)
+		state.completeStage(StageRetrieval)
+		output := fmt.Sprintf("%d chunks from %d documents", len(chunks), len(documents))
+		return output, p.endStage(ctx, StageRetrieval, output, nil)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	state.beginStage("")
+	return documents, chunks, nil
+}
+
+// retrievedCorpus turns retrieved chunks into documents and their chunks. The chunks of
+// a document, in retrieval order and separated by blank lines, make up its content, so
+// the chunks' offsets, citations and evidence spans point into it as they would into a
+// supplied document.
+func retrievedCorpus(retrieved []ScoredChunk) ([]Document, []DocumentChunk) {
+	var documents []Document
+	chunks := make([]DocumentChunk, 0, len(retrieved))
+	index := make(map[string]int)
+	counts := make([]int, 0, len(retrieved)) // Chunks per document so far
+	for i, scored := range retrieved {
+		chunk := scored.Chunk
+		if chunk.DocumentID == "" {
+			chunk.DocumentID = fmt.Sprintf("retrieved_%d", i)
+		}
+		n, ok := index[chunk.DocumentID]
+		if !ok {
+			n = len(documents)
+			index[chunk.DocumentID] = n
+			documents = append(documents, Document{ID: chunk.DocumentID, Title: scored.Title, Source: scored.Source, Metadata: scored.Metadata})
+			counts = append(counts, 0)
+		}
+		doc := &documents[n]
+		if doc.Content != "" {
+			doc.Content += "\n\n"
+		}
+		chunk.ChunkIndex = counts[n]
+		counts[n]++
+		chunk.StartIndex = len(doc.Content)
+		doc.Content += chunk.Content
+		chunk.EndIndex = len(doc.Content)
+		if chunk.ID == "" {
+			chunk.ID = fmt.Sprintf("%s_chunk_%d", chunk.DocumentID, chunk.ChunkIndex)
+		}
+		chunk.RelevanceScore = max(0, min(1, scored.Score))
+		chunks = append(chunks, chunk)
+	}
+	return documents, chunks
+}
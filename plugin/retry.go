@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/ZanzyTHEbar/genkit-agentic-rag/internal/retry"
+)
+
+// ErrRetryBudgetExhausted is returned when the caller's context deadline leaves
+// too little time for another attempt to complete
+var ErrRetryBudgetExhausted = retry.ErrBudgetExhausted
+
+// RetryAttempt describes a single failed model call attempt passed to RetryConfig.OnAttempt
+type RetryAttempt = retry.Attempt
+
+// withRetry runs fn through the shared retry loop using the processor's retry settings
+func withRetry[T any](ctx context.Context, cfg RetryConfig, fn func(context.Context) (T, error)) (T, error) {
+	return retry.Do(ctx, cfg.toRetryConfig(), isRetryable, func() (T, error) {
+		return fn(ctx)
+	})
+}
+
+// toRetryConfig converts the user-facing retry settings into the internal retry configuration
+func (c RetryConfig) toRetryConfig() retry.Config {
+	return retry.Config{
+		MaxAttempts:        c.MaxAttempts,
+		InitialBackoff:     c.InitialBackoff,
+		MaxBackoff:         c.MaxBackoff,
+		Multiplier:         c.Multiplier,
+		MinAttemptDuration: c.MinAttemptDuration,
+		OnAttempt:          c.OnAttempt,
+	}
+}
+
+// isRetryable reports whether a model call error is worth retrying. Only
+// provider-side failures can succeed on a later attempt.
+func isRetryable(err error) bool {
+	return errors.Is(err, ErrClassProvider)
+}
@@ -0,0 +1,120 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithRetryRetriesOnlyProviderErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"provider error", errors.New("503 Service Unavailable"), 3},
+		{"rate limit", errors.New("429 Too Many Requests: rate limit reached"), 3},
+		{"user error", errors.New("API key not valid. Please pass a valid API key."), 1},
+		{"content error", errors.New("This model's maximum context length is 8192 tokens"), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2}
+			calls := 0
+			_, err := withRetry(context.Background(), cfg, func(context.Context) (string, error) {
+				calls++
+				return "", classifyError("test-model", tt.err)
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("withRetry returned %v, want it to wrap %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWithRetryBackoff(t *testing.T) {
+	var delays []time.Duration
+	cfg := RetryConfig{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     3 * time.Millisecond,
+		Multiplier:     2,
+		OnAttempt:      func(a RetryAttempt) { delays = append(delays, a.Delay) },
+	}
+	calls := 0
+	result, err := withRetry(context.Background(), cfg, func(context.Context) (int, error) {
+		calls++
+		if calls < 4 {
+			return 0, classifyError("test-model", errors.New("500 Internal Server Error"))
+		}
+		return calls, nil
+	})
+	if err != nil || result != 4 {
+		t.Fatalf("withRetry = %d, %v, want the fourth attempt's result", result, err)
+	}
+	want := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+	if len(delays) != len(want) {
+		t.Fatalf("delays = %v, want %v", delays, want)
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+}
+
+func TestWithRetryBudgetExhausted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cfg := RetryConfig{MaxAttempts: 3, InitialBackoff: time.Millisecond, MinAttemptDuration: time.Hour}
+	calls := 0
+	_, err := withRetry(ctx, cfg, func(context.Context) (string, error) {
+		calls++
+		return "", classifyError("test-model", errors.New("503 Service Unavailable"))
+	})
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+	if !errors.Is(err, ErrRetryBudgetExhausted) || !errors.Is(err, ErrClassProvider) {
+		t.Errorf("withRetry returned %v, want ErrRetryBudgetExhausted wrapping the provider error", err)
+	}
+}
+
+// TestDefaultRetryConfig pins the retry defaults model calls had before the retry loop
+// moved to internal/retry
+func TestDefaultRetryConfig(t *testing.T) {
+	got := DefaultConfig().Retry
+	if got.MaxAttempts != 3 || got.InitialBackoff != time.Second || got.MaxBackoff != 8*time.Second ||
+		got.Multiplier != 2 || got.MinAttemptDuration != 2*time.Second {
+		t.Errorf("default retry config = %+v, want 3 attempts from 1s doubling to 8s, with 2s per attempt", got)
+	}
+}
+
+func TestProcessRetriesProviderErrors(t *testing.T) {
+	clean := &mockProvider{}
+	mustProcess(t, newTestProcessor(t, clean, fastRetries), testRequest("Where is the Eiffel Tower?"))
+
+	// The first two calls fail with a provider error and are retried
+	var failures atomic.Int32
+	flaky := &mockProvider{fail: func(string) error {
+		if failures.Add(1) <= 2 {
+			return errors.New("503 Service Unavailable")
+		}
+		return nil
+	}}
+	mustProcess(t, newTestProcessor(t, flaky, fastRetries), testRequest("Where is the Eiffel Tower?"))
+	if got, want := flaky.callCount(), clean.callCount()+2; got != want {
+		t.Errorf("flaky provider served %d calls, want %d: the clean run's plus two retries", got, want)
+	}
+}
+
+// fastRetries shortens the retry backoff so tests don't sleep
+func fastRetries(config *AgenticRAGConfig) {
+	config.Retry.InitialBackoff = time.Millisecond
+	config.Retry.MaxBackoff = time.Millisecond
+}
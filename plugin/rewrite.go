@@ -0,0 +1,66 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// rewriteQuery expands a terse query for retrieval, resolving references against the
+// conversation history when there is one. history holds prior turns as role and
+// content pairs, oldest first.
+func (p *AgenticRAGProcessor) rewriteQuery(ctx context.Context, query string, history []map[string]any) (string, error) {
+	if err := p.initializePrompts(ctx); err != nil {
+		return "", fmt.Errorf("failed to initialize prompts: %w", err)
+	}
+
+	rewritePrompt := p.lookupPrompt(ctx, "query_rewrite", p.config.Prompts.QueryRewritePrompt)
+	if rewritePrompt == nil {
+		return p.rewriteQueryFallback(ctx, query, history)
+	}
+
+	input := map[string]any{"query": query}
+	if len(history) > 0 {
+		input["history"] = history
+	}
+	response, err := p.executePrompt(ctx, rewritePrompt, input)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		RewrittenQuery string `json:"rewritten_query"`
+	}
+	if err := response.Output(&result); err != nil {
+		return "", fmt.Errorf("failed to parse rewritten query: %w", err)
+	}
+	return strings.TrimSpace(result.RewrittenQuery), nil
+}
+
+// rewriteQueryFallback rewrites the query with a hardcoded prompt when the dotprompt
+// isn't available
+func (p *AgenticRAGProcessor) rewriteQueryFallback(ctx context.Context, query string, history []map[string]any) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString(`Rewrite the search query so it retrieves the right passages from verbose documents.
+Resolve pronouns and references using the conversation, expand abbreviations, add close synonyms,
+and turn terse keywords into a full, specific question without changing the user's intent.
+`)
+	if len(history) > 0 {
+		prompt.WriteString("\nConversation so far:\n")
+		for _, turn := range history {
+			fmt.Fprintf(&prompt, "%v: %v\n", turn["role"], turn["content"])
+		}
+	}
+	fmt.Fprintf(&prompt, "\nQuery: %q\n\nRespond with only the rewritten query.", query)
+
+	response, err := p.generate(ctx, prompt.String(), &ai.GenerationCommonConfig{
+		Temperature:     0.2,
+		MaxOutputTokens: 300,
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(strings.TrimSpace(response.Text()), `"`), nil
+}
@@ -0,0 +1,109 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// selfConsistencyTemperature is the sampling temperature of re-verifications, high
+// enough for the samples to differ
+const selfConsistencyTemperature = 0.7
+
+// verdictOrder breaks ties between verdicts with equal votes and confidence
+var verdictOrder = []Verdict{VerdictSupported, VerdictRefuted, VerdictPartiallySupported, VerdictNotEnoughEvidence}
+
+// borderlineClaims returns the indices of the claims whose confidence lies within the
+// self-consistency band around MinConfidenceScore. Cached claims and those the numeric
+// check already overruled are left out.
+func borderlineClaims(config FactVerificationConfig, claims []Claim) []int {
+	var indices []int
+	for i, claim := range claims {
+		if claim.NumericConflict == "" && !claim.Cached && math.Abs(claim.Confidence-config.MinConfidenceScore) <= config.SelfConsistencyBand {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// resampleBorderline verifies the borderline claims SelfConsistencySamples more times
+// at selfConsistencyTemperature. Each claim settles on the majority verdict among its
+// first verdict and the samples, with the mean confidence and the vote split in
+// Claim.Votes. A verdict that changes takes its evidence from the first sample giving
+// it. Failed samples are skipped with a warning, and the token budget running out ends
+// the sampling.
+func (p *AgenticRAGProcessor) resampleBorderline(ctx context.Context, verification *FactVerification, input VerificationInput) {
+	config := p.factVerification(ctx)
+	state := requestStateFrom(ctx)
+	indices := borderlineClaims(config, verification.Claims)
+	if len(indices) == 0 {
+		return
+	}
+	if state.isDeterministic() {
+		state.recordWarning("self-consistency sampling skipped for a deterministic request")
+		return
+	}
+
+	claims := make([]Claim, len(indices))
+	for i, index := range indices {
+		claims[i] = verification.Claims[index]
+	}
+	prompt := verificationPrompt(claims, input.Chunks, input.Language, input.DatedFacts)
+	var samples []*FactVerification
+	for range config.SelfConsistencySamples {
+		value, _, err := p.generateJSON(ctx, prompt, verificationSchema, &ai.GenerationCommonConfig{
+			Temperature:     selfConsistencyTemperature,
+			MaxOutputTokens: 2048,
+		})
+		if errors.Is(err, ErrBudgetExceeded) {
+			state.recordWarning(fmt.Sprintf("self-consistency sampling stopped after %d of %d samples: token budget exhausted", len(samples), config.SelfConsistencySamples))
+			break
+		}
+		if err == nil {
+			var sample *FactVerification
+			if sample, err = p.decodeVerification(ctx, value, claims, input.Chunks, input.Documents); err == nil {
+				samples = append(samples, sample)
+				continue
+			}
+		}
+		state.recordWarning(fmt.Sprintf("self-consistency sample skipped: %v", err))
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	for i, index := range indices {
+		claim := &verification.Claims[index]
+		votes := map[Verdict]int{claim.Verdict: 1}
+		weights := map[Verdict]float64{claim.Verdict: claim.Confidence}
+		first := map[Verdict]*Claim{}
+		total := claim.Confidence
+		for _, sample := range samples {
+			voted := &sample.Claims[i]
+			votes[voted.Verdict]++
+			weights[voted.Verdict] += voted.Confidence
+			total += voted.Confidence
+			if first[voted.Verdict] == nil {
+				first[voted.Verdict] = voted
+			}
+		}
+
+		majority := claim.Verdict
+		for _, verdict := range verdictOrder {
+			if votes[verdict] > votes[majority] || votes[verdict] == votes[majority] && weights[verdict] > weights[majority] {
+				majority = verdict
+			}
+		}
+		if majority != claim.Verdict {
+			claim.Verdict = majority
+			claim.Evidence = first[majority].Evidence
+			claim.EvidenceSpans = first[majority].EvidenceSpans
+		}
+		claim.Confidence = total / float64(len(samples)+1)
+		claim.Votes = votes
+	}
+	verification.Overall = overallVerdict(verification.Claims)
+}
@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// chunkSemantic chunks a document at topic shifts: sentences are embedded and a chunk
+// ends where the cosine distance between neighbouring sentences is in the configured
+// top percentile, within the min/max chunk sizes. Returns the chunks and the number of
+// topic breakpoints used.
+func (p *AgenticRAGProcessor) chunkSemantic(ctx context.Context, embedder ai.Embedder, doc Document, maxChunks int) ([]DocumentChunk, int, error) {
+	config := p.config.Processing.Semantic
+	size := p.chunkMeasure()
+	maxSize := config.MaxChunkSize
+	if maxSize <= 0 {
+		maxSize = p.config.Processing.DefaultChunkSize
+	}
+
+	sentences := splitProse(doc.Content, maxSize, size, true, requestStateFrom(ctx).languageHint())
+	texts := make([]string, 0, len(sentences))
+	units := make([]prosePart, 0, len(sentences))
+	for _, sentence := range sentences {
+		if strings.TrimSpace(sentence.text) != "" {
+			texts = append(texts, strings.TrimSpace(sentence.text))
+			units = append(units, sentence)
+		}
+	}
+	if len(units) == 0 {
+		return nil, 0, nil
+	}
+
+	var distances []float64
+	threshold := 0.0
+	if len(units) > 1 {
+		embeddings, err := p.embed(ctx, embedder, texts)
+		if err != nil {
+			return nil, 0, err
+		}
+		distances = make([]float64, len(units)-1)
+		for i := range distances {
+			distances[i] = 1 - cosineSimilarity(embeddings[i], embeddings[i+1])
+		}
+		threshold = percentile(distances, config.BreakpointPercentile)
+	}
+
+	chunks := make([]DocumentChunk, 0)
+	breakpoints := 0
+	var current strings.Builder
+	currentStart, currentEnd := 0, 0
+	emit := func() {
+		if current.Len() == 0 || len(chunks) >= maxChunks {
+			return
+		}
+		chunks = append(chunks, DocumentChunk{
+			ID:         fmt.Sprintf("%s_chunk_%d", doc.ID, len(chunks)),
+			Content:    strings.TrimSpace(current.String()),
+			DocumentID: doc.ID,
+			ChunkIndex: len(chunks),
+			StartIndex: currentStart,
+			EndIndex:   currentEnd,
+		})
+		current.Reset()
+	}
+
+	for i, unit := range units {
+		if current.Len() > 0 {
+			shift := distances[i-1] > threshold && size(current.String()) >= config.MinChunkSize
+			if shift {
+				breakpoints++
+			}
+			if shift || size(current.String())+size(unit.text) > maxSize {
+				emit()
+			}
+		}
+		if len(chunks) >= maxChunks {
+			break
+		}
+		if current.Len() == 0 {
+			currentStart = unit.start
+		}
+		current.WriteString(unit.text)
+		currentEnd = unit.end
+	}
+	emit()
+
+	return chunks, breakpoints, nil
+}
+
+// percentile returns the pth percentile of values using linear interpolation
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
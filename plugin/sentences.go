@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// commonAbbreviations are lowercased abbreviations, without their final period, that
+// don't end a sentence. Single-letter initials are handled separately.
+var commonAbbreviations = map[string]bool{
+	// Titles and names
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true, "sr": true, "jr": true,
+	"st": true, "rev": true, "hon": true, "gen": true, "col": true, "lt": true, "sgt": true, "capt": true,
+	// Latin and general
+	"e.g": true, "i.e": true, "etc": true, "vs": true, "cf": true, "viz": true, "al": true,
+	"approx": true, "ca": true, "resp": true, "esp": true, "incl": true,
+	// Citations and references
+	"fig": true, "figs": true, "eq": true, "eqs": true, "ref": true, "refs": true,
+	"vol": true, "vols": true, "pp": true, "ch": true, "sec": true, "para": true, "eds": true,
+	"ibid": true, "cit": true, "seq": true, "suppl": true,
+	// Legal
+	"v": true, "u.s": true, "u.s.c": true, "civ": true, "crim": true, "evid": true,
+	"supp": true, "cir": true, "ct": true, "reg": true, "regs": true,
+	// Organizations
+	"inc": true, "ltd": true, "corp": true, "dept": true, "univ": true, "assn": true, "bros": true,
+	// Months
+	"jan": true, "feb": true, "mar": true, "apr": true, "jun": true, "jul": true, "aug": true,
+	"sep": true, "sept": true, "oct": true, "nov": true, "dec": true,
+}
+
+// contextAbbreviations are abbreviations that are also ordinary words. Their period
+// only continues the sentence before a number or section sign, as in "No. 5" and
+// "Art. 12", or before an initial or another abbreviation, as in "Fed. R. Civ. P.".
+var contextAbbreviations = map[string]bool{
+	"no": true, "nos": true, "art": true, "tab": true, "min": true, "max": true, "op": true,
+	"id": true, "ed": true, "co": true, "fed": true, "stat": true, "app": true,
+}
+
+// languageAbbreviations are additional abbreviations for language hints, keyed by the
+// primary language subtag
+var languageAbbreviations = map[string]map[string]bool{
+	"de": {"z.b": true, "bzw": true, "usw": true, "nr": true, "hr": true, "fr": true, "evtl": true,
+		"ggf": true, "vgl": true, "d.h": true, "u.a": true, "s": true, "abs": true},
+	"fr": {"m": true, "mme": true, "mlle": true, "p.ex": true, "env": true, "cf": true, "p": true},
+	"es": {"sra": true, "srta": true, "ud": true, "uds": true, "p.ej": true, "pág": true, "núm": true},
+}
+
+// segmentSentences splits text into sentences with their trailing whitespace and byte
+// ranges. Periods after abbreviations, initials and list numbers, inside decimal
+// numbers and in ellipses continuing a sentence aren't boundaries; closing quotes and
+// brackets stay with the sentence they end; full-width terminators (。！？) end a
+// sentence without needing whitespace unless a quoted sentence continues. lang is an
+// optional BCP 47 language hint.
+func segmentSentences(text, lang string) []prosePart {
+	extra := languageAbbreviations[primaryLanguage(lang)]
+
+	var parts []prosePart
+	start := 0
+	for i := 0; i < len(text); {
+		r, width := utf8.DecodeRuneInString(text[i:])
+		if !isSentenceTerminator(r) {
+			i += width
+			continue
+		}
+
+		// Take the whole run of terminators and any closing quotes or brackets
+		end := i
+		last := r
+		for end < len(text) {
+			next, w := utf8.DecodeRuneInString(text[end:])
+			if !isSentenceTerminator(next) {
+				break
+			}
+			last = next
+			end += w
+		}
+		terminators := text[i:end]
+		for end < len(text) {
+			next, w := utf8.DecodeRuneInString(text[end:])
+			if !isClosingPunctuation(next) {
+				break
+			}
+			end += w
+		}
+
+		if isFullWidthTerminator(last) {
+			// A quote followed by a particle continues the sentence, as in 「はい。」と言った
+			next, _ := utf8.DecodeRuneInString(text[end:])
+			if end > len(terminators)+i && end < len(text) && unicode.Is(unicode.Hiragana, next) {
+				i = end
+				continue
+			}
+		} else {
+			next, _ := utf8.DecodeRuneInString(text[end:])
+			if end < len(text) && !unicode.IsSpace(next) {
+				i = end // Decimal numbers, dotted names and URLs
+				continue
+			}
+			if !isSentenceBoundary(text, start, i, terminators, end, extra) {
+				i = end
+				continue
+			}
+		}
+
+		for end < len(text) {
+			next, w := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsSpace(next) {
+				break
+			}
+			end += w
+		}
+		parts = append(parts, prosePart{text: text[start:end], start: start, end: end})
+		start, i = end, end
+	}
+
+	if start < len(text) {
+		if strings.TrimSpace(text[start:]) != "" || len(parts) == 0 {
+			parts = append(parts, prosePart{text: text[start:], start: start, end: len(text)})
+		} else {
+			// Trailing whitespace belongs to the last sentence
+			last := &parts[len(parts)-1]
+			last.text += text[start:]
+			last.end = len(text)
+		}
+	}
+	return parts
+}
+
+// isSentenceBoundary decides whether ASCII terminators at text[at:] followed by
+// whitespace end the sentence that began at start. after is the offset past the
+// terminators and closing punctuation.
+func isSentenceBoundary(text string, start, at int, terminators string, after int, extra map[string]bool) bool {
+	nextWord := strings.TrimLeftFunc(text[after:], unicode.IsSpace)
+	if nextWord == "" {
+		return true
+	}
+	next, _ := utf8.DecodeRuneInString(nextWord)
+
+	if strings.ContainsAny(terminators, "!?") {
+		return true
+	}
+	// An ellipsis only ends the sentence when a new one visibly starts
+	if terminators != "." {
+		return unicode.IsUpper(next) || unicode.IsDigit(next) || isOpeningPunctuation(next)
+	}
+
+	// A lowercase continuation means the period didn't end the sentence
+	if unicode.IsLower(next) {
+		return false
+	}
+
+	wordStart := strings.LastIndexFunc(text[:at], unicode.IsSpace) + 1
+	if wordStart < start {
+		wordStart = start
+	}
+	word := strings.TrimLeftFunc(text[wordStart:at], isOpeningPunctuation)
+	// Scripts written without spaces run into the word, as in 詳細はFig.
+	if i := strings.LastIndexFunc(word, isUnspacedScript); i >= 0 {
+		_, width := utf8.DecodeRuneInString(word[i:])
+		word = word[i+width:]
+	}
+	lower := strings.ToLower(word)
+	switch {
+	case word == "":
+		return true
+	case commonAbbreviations[lower] || extra[lower]:
+		return false
+	case contextAbbreviations[lower]:
+		return !continuesAbbreviation(nextWord, extra)
+	case utf8.RuneCountInString(word) == 1 && unicode.IsUpper([]rune(word)[0]):
+		return false // An initial, as in "J. Smith"
+	case wordStart == start && isDigits(word):
+		return false // A list item number, as in "1. First"
+	}
+	return true
+}
+
+// continuesAbbreviation reports whether the word after a context abbreviation shows it
+// abbreviates: a number, a section sign, an initial or another abbreviation
+func continuesAbbreviation(nextWord string, extra map[string]bool) bool {
+	next, _ := utf8.DecodeRuneInString(nextWord)
+	if unicode.IsDigit(next) || next == '§' || next == '¶' {
+		return true
+	}
+	word := nextWord
+	if i := strings.IndexFunc(word, unicode.IsSpace); i >= 0 {
+		word = word[:i]
+	}
+	stem, dotted := strings.CutSuffix(word, ".")
+	if !dotted {
+		return false
+	}
+	lower := strings.ToLower(stem)
+	isInitial := utf8.RuneCountInString(stem) == 1 && unicode.IsUpper(next)
+	return isInitial || commonAbbreviations[lower] || extra[lower] || contextAbbreviations[lower]
+}
+
+// primaryLanguage returns the lowercased primary subtag of a BCP 47 language tag
+func primaryLanguage(lang string) string {
+	if i := strings.IndexAny(lang, "-_"); i >= 0 {
+		lang = lang[:i]
+	}
+	return strings.ToLower(lang)
+}
+
+// isSentenceTerminator reports whether r can end a sentence
+func isSentenceTerminator(r rune) bool {
+	switch r {
+	case '.', '!', '?', '…', '‼', '⁇', '。', '！', '？', '｡':
+		return true
+	}
+	return false
+}
+
+// isFullWidthTerminator reports whether r is a CJK sentence terminator, which ends a
+// sentence without following whitespace
+func isFullWidthTerminator(r rune) bool {
+	return r == '。' || r == '！' || r == '？' || r == '｡'
+}
+
+// isClosingPunctuation reports whether r closes a quote or bracket
+func isClosingPunctuation(r rune) bool {
+	return strings.ContainsRune("\"')]}’”»」』）】》〉", r)
+}
+
+// isOpeningPunctuation reports whether r opens a quote or bracket
+func isOpeningPunctuation(r rune) bool {
+	return strings.ContainsRune("\"'([{‘“«「『（【《〈", r)
+}
+
+// isUnspacedScript reports whether r belongs to a script written without spaces
+// between words
+func isUnspacedScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// isDigits reports whether word is a run of digits
+func isDigits(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return word != ""
+}
@@ -0,0 +1,107 @@
+package plugin
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSegmentSentences(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		text string
+		want []string
+	}{
+		// Legal text
+		{
+			name: "case citation",
+			text: "See Brown v. Board of Education, 347 U.S. 483 (1954). The Court reversed.",
+			want: []string{"See Brown v. Board of Education, 347 U.S. 483 (1954).", "The Court reversed."},
+		},
+		{
+			name: "rule citation",
+			text: "The motion was brought under Fed. R. Civ. P. 12(b)(6). It was denied.",
+			want: []string{"The motion was brought under Fed. R. Civ. P. 12(b)(6).", "It was denied."},
+		},
+		{
+			name: "id and section",
+			text: "The statute applies. Id. at 12. See also 42 U.S.C. § 1983 and Art. 5 of the treaty.",
+			want: []string{"The statute applies.", "Id. at 12.", "See also 42 U.S.C. § 1983 and Art. 5 of the treaty."},
+		},
+		{
+			name: "docket number",
+			text: "The appeal, No. 21-1234, was argued in March. Judgment was entered.",
+			want: []string{"The appeal, No. 21-1234, was argued in March.", "Judgment was entered."},
+		},
+		{
+			name: "reporter",
+			text: "The panel relied on 512 F. Supp. 2d 45 (App. 2008). Review was denied.",
+			want: []string{"The panel relied on 512 F. Supp. 2d 45 (App. 2008).", "Review was denied."},
+		},
+
+		// Scientific citations
+		{
+			name: "et al. and figure",
+			text: "Smith et al. reported a 3.5% increase (Fig. 2). Eq. 4 gives the rate. Results vary.",
+			want: []string{"Smith et al. reported a 3.5% increase (Fig. 2).", "Eq. 4 gives the rate.", "Results vary."},
+		},
+		{
+			name: "latin and initials",
+			text: "Growth was fast, cf. J. Doe's review, i.e. faster than expected. It slowed after day 4.",
+			want: []string{"Growth was fast, cf. J. Doe's review, i.e. faster than expected.", "It slowed after day 4."},
+		},
+		{
+			name: "journal reference",
+			text: "This follows earlier work (Nature, Vol. 5, pp. 12-19). Tab. 3 lists the samples.",
+			want: []string{"This follows earlier work (Nature, Vol. 5, pp. 12-19).", "Tab. 3 lists the samples."},
+		},
+
+		// Ordinary words that are also abbreviations end sentences
+		{
+			name: "ordinary words",
+			text: "She said no. The cat was fed. We built an app. Make it art. He read the id. It was the max.",
+			want: []string{"She said no.", "The cat was fed.", "We built an app.", "Make it art.", "He read the id.", "It was the max."},
+		},
+		{
+			name: "company",
+			text: "He works at Acme & Co. Ltd. in Leeds. She works at Smith & Co. They met in 2020.",
+			want: []string{"He works at Acme & Co. Ltd. in Leeds.", "She works at Smith & Co.", "They met in 2020."},
+		},
+
+		// Japanese
+		{
+			name: "japanese",
+			lang: "ja",
+			text: "東京は日本の首都です。人口は約1400万人です！本当ですか？",
+			want: []string{"東京は日本の首都です。", "人口は約1400万人です！", "本当ですか？"},
+		},
+		{
+			name: "japanese quote",
+			lang: "ja",
+			text: "彼は「はい。」と言った。それから帰った。",
+			want: []string{"彼は「はい。」と言った。", "それから帰った。"},
+		},
+		{
+			name: "japanese with latin",
+			lang: "ja",
+			text: "バージョン3.5をリリースしました。詳細はFig. 2を参照。",
+			want: []string{"バージョン3.5をリリースしました。", "詳細はFig. 2を参照。"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := segmentSentences(tt.text, tt.lang)
+			got := make([]string, len(parts))
+			for i, part := range parts {
+				got[i] = strings.TrimSpace(part.text)
+				if tt.text[part.start:part.end] != part.text {
+					t.Errorf("part %d range [%d:%d] doesn't match its text %q", i, part.start, part.end, part.text)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segmentSentences =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session is the state a conversation carries from one turn to the next
+type Session struct {
+	ID             string          `json:"id"`
+	Turns          []Turn          `json:"turns"`                     // Prior questions and answers, oldest first
+	Chunks         []DocumentChunk `json:"chunks,omitempty"`          // Chunks the last answer was built from
+	KnowledgeGraph *KnowledgeGraph `json:"knowledge_graph,omitempty"` // Entities and relations merged across turns
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// SessionStore persists conversation sessions between requests. Implementations must
+// be safe for concurrent use.
+type SessionStore interface {
+	// Load returns the session with the given ID, or nil if it doesn't exist or expired
+	Load(ctx context.Context, id string) (*Session, error)
+	// Save stores the session, replacing any earlier version
+	Save(ctx context.Context, session *Session) error
+	// Delete removes the session with the given ID, if any
+	Delete(ctx context.Context, id string) error
+}
+
+// MemorySessionStore is an in-process SessionStore whose sessions expire a fixed time
+// after their last update
+type MemorySessionStore struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewMemorySessionStore creates an in-memory store. A ttl of 0 keeps sessions forever.
+func NewMemorySessionStore(ttl time.Duration) *MemorySessionStore {
+	return &MemorySessionStore{ttl: ttl, sessions: make(map[string]*Session)}
+}
+
+// Load implements SessionStore. The returned session is a copy.
+func (s *MemorySessionStore) Load(ctx context.Context, id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	if s.expired(session) {
+		delete(s.sessions, id)
+		return nil, nil
+	}
+	return copySession(session), nil
+}
+
+// Save implements SessionStore, dropping expired sessions along the way
+func (s *MemorySessionStore) Save(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, existing := range s.sessions {
+		if s.expired(existing) {
+			delete(s.sessions, id)
+		}
+	}
+	saved := copySession(session)
+	saved.UpdatedAt = time.Now()
+	s.sessions[session.ID] = saved
+	return nil
+}
+
+// Delete implements SessionStore
+func (s *MemorySessionStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// expired reports whether the session outlived the TTL
+func (s *MemorySessionStore) expired(session *Session) bool {
+	return s.ttl > 0 && time.Since(session.UpdatedAt) > s.ttl
+}
+
+// copySession copies a session so callers can't modify the stored one
+func copySession(session *Session) *Session {
+	copied := *session
+	copied.Turns = append([]Turn(nil), session.Turns...)
+	copied.Chunks = append([]DocumentChunk(nil), session.Chunks...)
+	copied.KnowledgeGraph = mergeKnowledgeGraphs(nil, session.KnowledgeGraph)
+	return &copied
+}
+
+// historyInput converts turns to the role and content pairs prompts take as history
+func historyInput(turns []Turn) []map[string]any {
+	history := make([]map[string]any, len(turns))
+	for i, turn := range turns {
+		history[i] = map[string]any{"role": turn.Role, "content": turn.Content}
+	}
+	return history
+}
+
+// mergeKnowledgeGraphs returns a new graph with the entities and relations of both
+// graphs. Entities with the same name and type are merged, keeping the higher
+// confidence and every document and mention; duplicate relations are dropped, keeping
+// their mentions. The merged graph has stable IDs.
+func mergeKnowledgeGraphs(base, update *KnowledgeGraph) *KnowledgeGraph {
+	if base == nil && update == nil {
+		return nil
+	}
+
+	merged := &KnowledgeGraph{Entities: make([]Entity, 0), Relations: make([]Relation, 0)}
+	entityIndex := make(map[string]int)
+	relationIndex := make(map[string]int)
+	for _, kg := range []*KnowledgeGraph{base, update} {
+		if kg == nil {
+			continue
+		}
+		for _, entity := range kg.Entities {
+			key := strings.ToLower(entity.Type) + "\x00" + strings.ToLower(entity.Name)
+			i, exists := entityIndex[key]
+			if !exists {
+				entity.DocumentIDs = append([]string(nil), entity.DocumentIDs...)
+				entity.Mentions = append([]Mention(nil), entity.Mentions...)
+				entity.Attributes = mergeAttributes(nil, entity.Attributes)
+				entityIndex[key] = len(merged.Entities)
+				merged.Entities = append(merged.Entities, entity)
+				continue
+			}
+			existing := &merged.Entities[i]
+			existing.Confidence = max(existing.Confidence, entity.Confidence)
+			existing.Mentions = mergeMentions(existing.Mentions, entity.Mentions)
+			existing.Attributes = mergeAttributes(existing.Attributes, entity.Attributes)
+			for _, id := range entity.DocumentIDs {
+				if !containsString(existing.DocumentIDs, id) {
+					existing.DocumentIDs = append(existing.DocumentIDs, id)
+				}
+			}
+		}
+		for _, relation := range kg.Relations {
+			key := strings.ToLower(relation.Subject + "\x00" + relation.Predicate + "\x00" + relation.Object)
+			if i, seen := relationIndex[key]; seen {
+				merged.Relations[i].Mentions = mergeMentions(merged.Relations[i].Mentions, relation.Mentions)
+				if merged.Relations[i].Temporal == nil {
+					merged.Relations[i].Temporal = relation.Temporal
+				}
+				continue
+			}
+			relation.Mentions = append([]Mention(nil), relation.Mentions...)
+			relationIndex[key] = len(merged.Relations)
+			merged.Relations = append(merged.Relations, relation)
+		}
+		if len(kg.Metadata) > 0 && merged.Metadata == nil {
+			merged.Metadata = make(map[string]interface{}, len(kg.Metadata))
+		}
+		for key, value := range kg.Metadata {
+			merged.Metadata[key] = value
+		}
+	}
+	merged.AssignIDs()
+	return merged
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,662 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sqliteBatchSize bounds the host parameters of one IN clause, well under SQLite's limit
+const sqliteBatchSize = 500
+
+// sqliteGraphSchema creates the tables of a SQLiteGraphStore. Keys are the lowercased
+// identifying fields, so lookups are case-insensitive beyond ASCII.
+const sqliteGraphSchema = `
+CREATE TABLE IF NOT EXISTS kg_entities (
+	type_key     TEXT NOT NULL,
+	name_key     TEXT NOT NULL,
+	id           TEXT NOT NULL,
+	name         TEXT NOT NULL,
+	type         TEXT NOT NULL,
+	properties   TEXT,
+	confidence   REAL NOT NULL,
+	document_ids TEXT,
+	mentions     TEXT,
+	attributes   TEXT,
+	PRIMARY KEY (type_key, name_key)
+);
+CREATE TABLE IF NOT EXISTS kg_relations (
+	relation_key TEXT PRIMARY KEY,
+	subject_key  TEXT NOT NULL,
+	object_key   TEXT NOT NULL,
+	id           TEXT NOT NULL,
+	subject      TEXT NOT NULL,
+	predicate    TEXT NOT NULL,
+	object       TEXT NOT NULL,
+	properties   TEXT,
+	confidence   REAL NOT NULL,
+	mentions     TEXT,
+	temporal     TEXT
+);
+CREATE INDEX IF NOT EXISTS kg_relations_subject ON kg_relations (subject_key);
+CREATE INDEX IF NOT EXISTS kg_relations_object ON kg_relations (object_key);
+CREATE TABLE IF NOT EXISTS kg_extracted (
+	hash TEXT PRIMARY KEY
+);`
+
+// SQLiteGraphStore is a GraphStore in a SQLite database. It works with any
+// database/sql SQLite driver, which the caller registers and opens, such as
+// modernc.org/sqlite or github.com/mattn/go-sqlite3.
+type SQLiteGraphStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteGraphStore creates the graph tables in db if they don't exist yet
+func NewSQLiteGraphStore(ctx context.Context, db *sql.DB) (*SQLiteGraphStore, error) {
+	if _, err := db.ExecContext(ctx, sqliteGraphSchema); err != nil {
+		return nil, fmt.Errorf("failed to create graph tables: %w", err)
+	}
+	// Tables created before mentions, attributes and temporal qualifiers were recorded
+	// lack the columns
+	for _, column := range []struct{ table, name string }{
+		{"kg_entities", "mentions"},
+		{"kg_entities", "attributes"},
+		{"kg_relations", "mentions"},
+		{"kg_relations", "temporal"},
+	} {
+		if _, err := db.ExecContext(ctx, `SELECT `+column.name+` FROM `+column.table+` LIMIT 0`); err == nil {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `ALTER TABLE `+column.table+` ADD COLUMN `+column.name+` TEXT`); err != nil {
+			return nil, fmt.Errorf("failed to add %s to %s: %w", column.name, column.table, err)
+		}
+	}
+	return &SQLiteGraphStore{db: db}, nil
+}
+
+// UpsertEntities implements GraphStore
+func (s *SQLiteGraphStore) UpsertEntities(ctx context.Context, entities []Entity) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, entity := range entities {
+			typeKey, nameKey := strings.ToLower(entity.Type), strings.ToLower(entity.Name)
+			var confidence float64
+			var documents, mentions, attributes sql.NullString
+			err := tx.QueryRowContext(ctx,
+				`SELECT confidence, document_ids, mentions, attributes FROM kg_entities WHERE type_key = ? AND name_key = ?`,
+				typeKey, nameKey).Scan(&confidence, &documents, &mentions, &attributes)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				properties, err := marshalColumn(entity.Properties)
+				if err != nil {
+					return err
+				}
+				documentIDs, err := marshalColumn(entity.DocumentIDs)
+				if err != nil {
+					return err
+				}
+				mentionsColumn, err := marshalColumn(entity.Mentions)
+				if err != nil {
+					return err
+				}
+				attributesColumn, err := marshalColumn(entity.Attributes)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx,
+					`INSERT INTO kg_entities (type_key, name_key, id, name, type, properties, confidence, document_ids, mentions, attributes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					typeKey, nameKey, stableEntityID(entity), entity.Name, entity.Type, properties, entity.Confidence, documentIDs, mentionsColumn, attributesColumn); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			default:
+				var stored []string
+				if err := unmarshalColumn(documents, &stored); err != nil {
+					return err
+				}
+				for _, id := range entity.DocumentIDs {
+					if !containsString(stored, id) {
+						stored = append(stored, id)
+					}
+				}
+				documentIDs, err := marshalColumn(stored)
+				if err != nil {
+					return err
+				}
+				var storedMentions []Mention
+				if err := unmarshalColumn(mentions, &storedMentions); err != nil {
+					return err
+				}
+				mentionsColumn, err := marshalColumn(mergeMentions(storedMentions, entity.Mentions))
+				if err != nil {
+					return err
+				}
+				var storedAttributes map[string]AttributeValue
+				if err := unmarshalColumn(attributes, &storedAttributes); err != nil {
+					return err
+				}
+				attributesColumn, err := marshalColumn(mergeAttributes(storedAttributes, entity.Attributes))
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx,
+					`UPDATE kg_entities SET id = ?, confidence = ?, document_ids = ?, mentions = ?, attributes = ? WHERE type_key = ? AND name_key = ?`,
+					stableEntityID(entity), max(confidence, entity.Confidence), documentIDs, mentionsColumn, attributesColumn, typeKey, nameKey); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// UpsertRelations implements GraphStore
+func (s *SQLiteGraphStore) UpsertRelations(ctx context.Context, relations []Relation) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, relation := range relations {
+			properties, err := marshalColumn(relation.Properties)
+			if err != nil {
+				return err
+			}
+			key := strings.ToLower(relation.Subject + "\x00" + relation.Predicate + "\x00" + relation.Object)
+			relationID := relation.ID
+			if relationID == "" {
+				subjectID, err := sqliteEntityID(ctx, tx, relation.Subject)
+				if err != nil {
+					return err
+				}
+				objectID, err := sqliteEntityID(ctx, tx, relation.Object)
+				if err != nil {
+					return err
+				}
+				relationID = stableRelationID(subjectID, relation.Predicate, objectID)
+			}
+			temporal, err := marshalColumn(relation.Temporal)
+			if err != nil {
+				return err
+			}
+			var mentions, storedTemporal sql.NullString
+			err = tx.QueryRowContext(ctx, `SELECT mentions, temporal FROM kg_relations WHERE relation_key = ?`, key).Scan(&mentions, &storedTemporal)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				mentionsColumn, err := marshalColumn(relation.Mentions)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx,
+					`INSERT INTO kg_relations (relation_key, subject_key, object_key, id, subject, predicate, object, properties, confidence, mentions, temporal) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+					key, strings.ToLower(relation.Subject), strings.ToLower(relation.Object),
+					relationID, relation.Subject, relation.Predicate, relation.Object, properties, relation.Confidence, mentionsColumn, temporal); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			default:
+				var stored []Mention
+				if err := unmarshalColumn(mentions, &stored); err != nil {
+					return err
+				}
+				mentionsColumn, err := marshalColumn(mergeMentions(stored, relation.Mentions))
+				if err != nil {
+					return err
+				}
+				if storedTemporal.Valid {
+					temporal = storedTemporal // The first qualifier stored wins, as when merging graphs
+				}
+				if _, err := tx.ExecContext(ctx, `UPDATE kg_relations SET id = ?, mentions = ?, temporal = ? WHERE relation_key = ?`, relationID, mentionsColumn, temporal, key); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// sqliteEntityID returns the ID of the first stored entity named name, or that of an
+// untyped entity of the name if none is stored
+func sqliteEntityID(ctx context.Context, tx *sql.Tx, name string) (string, error) {
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM kg_entities WHERE name_key = ? ORDER BY rowid LIMIT 1`, strings.ToLower(name)).Scan(&id)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return stableEntityID(Entity{Name: name}), nil
+	case err != nil:
+		return "", err
+	}
+	return id, nil
+}
+
+// Query implements GraphStore
+func (s *SQLiteGraphStore) Query(ctx context.Context, text string) (*KnowledgeGraph, error) {
+	entities, err := s.queryEntities(ctx,
+		`SELECT id, name, type, properties, confidence, document_ids, mentions, attributes FROM kg_entities WHERE name_key != '' AND instr(?, name_key) > 0 ORDER BY rowid`,
+		strings.ToLower(text))
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entities))
+	seen := make(map[string]bool)
+	for _, entity := range entities {
+		if name := strings.ToLower(entity.Name); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	_, relations, err := s.relationsOf(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+	return &KnowledgeGraph{Entities: entities, Relations: relations}, nil
+}
+
+// Neighbors implements GraphStore, loading only the graph within depth relations
+func (s *SQLiteGraphStore) Neighbors(ctx context.Context, entity string, depth int, filter RelationFilter) ([]Neighbor, error) {
+	graph, err := s.neighborhood(ctx, []string{entity}, depth, filter, "")
+	if err != nil {
+		return nil, err
+	}
+	return graph.Neighbors(entity, depth, filter), nil
+}
+
+// ShortestPath implements GraphStore, loading the graph hop by hop until the target
+// entity is reached
+func (s *SQLiteGraphStore) ShortestPath(ctx context.Context, from, to string, filter RelationFilter) ([]Relation, bool, error) {
+	graph, err := s.neighborhood(ctx, []string{from}, -1, filter, to)
+	if err != nil {
+		return nil, false, err
+	}
+	path, ok := graph.ShortestPath(from, to, filter)
+	return path, ok, nil
+}
+
+// Subgraph implements GraphStore, loading only the graph within hops relations
+func (s *SQLiteGraphStore) Subgraph(ctx context.Context, entities []string, hops int, filter RelationFilter) (*KnowledgeGraph, error) {
+	graph, err := s.neighborhood(ctx, entities, max(0, hops), filter, "")
+	if err != nil {
+		return nil, err
+	}
+	return graph.Subgraph(entities, hops, filter), nil
+}
+
+// neighborhood loads the stored entities within hops relations of the entities, found
+// by ID or name, and the relations walked to them, with one query per hop. Without
+// limit when hops is negative, loading stops once the until entity is reached.
+// Relations failing the filter aren't walked.
+func (s *SQLiteGraphStore) neighborhood(ctx context.Context, entities []string, hops int, filter RelationFilter, until string) (*KnowledgeGraph, error) {
+	predicates := make(map[string]bool, len(filter.Predicates))
+	for _, predicate := range filter.Predicates {
+		predicates[strings.ToLower(predicate)] = true
+	}
+	frontier, err := s.entityKeys(ctx, entities)
+	if err != nil {
+		return nil, err
+	}
+	var targets []string
+	if until != "" {
+		if targets, err = s.entityKeys(ctx, []string{until}); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool, len(frontier))
+	for _, key := range frontier {
+		seen[key] = true
+	}
+	reachedTarget := func() bool {
+		for _, key := range targets {
+			if seen[key] {
+				return true
+			}
+		}
+		return false
+	}
+	relations := make([]Relation, 0)
+	found := make(map[string]bool)
+	for hop := 0; (hops < 0 || hop < hops) && len(frontier) > 0 && !reachedTarget(); hop++ {
+		keys, batch, err := s.relationsOf(ctx, frontier)
+		if err != nil {
+			return nil, err
+		}
+		var next []string
+		for i, relation := range batch {
+			if found[keys[i]] || relation.Confidence < filter.MinConfidence ||
+				(len(predicates) > 0 && !predicates[strings.ToLower(relation.Predicate)]) {
+				continue
+			}
+			found[keys[i]] = true
+			relations = append(relations, relation)
+			for _, endpoint := range []string{relation.Subject, relation.Object} {
+				if key := strings.ToLower(endpoint); !seen[key] {
+					seen[key] = true
+					next = append(next, key)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	loaded := make([]Entity, 0, len(seen))
+	names := sortedKeys(seen)
+	for start := 0; start < len(names); start += sqliteBatchSize {
+		batch := names[start:min(start+sqliteBatchSize, len(names))]
+		batchEntities, err := s.queryEntities(ctx,
+			`SELECT id, name, type, properties, confidence, document_ids, mentions, attributes FROM kg_entities WHERE name_key IN (`+sqlitePlaceholders(len(batch))+`) ORDER BY rowid`,
+			sqliteArgs(batch)...)
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, batchEntities...)
+	}
+	return &KnowledgeGraph{Entities: loaded, Relations: relations}, nil
+}
+
+// entityKeys returns the name keys of the stored entities with the given IDs or names,
+// and the lowercased names themselves for relation endpoints without an entity
+func (s *SQLiteGraphStore) entityKeys(ctx context.Context, entities []string) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	add := func(key string) {
+		if key != "" && !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for start := 0; start < len(entities); start += sqliteBatchSize {
+		batch := entities[start:min(start+sqliteBatchSize, len(entities))]
+		lowered := make([]string, len(batch))
+		for i, entity := range batch {
+			lowered[i] = strings.ToLower(entity)
+		}
+		placeholders := sqlitePlaceholders(len(batch))
+		rows, err := s.db.QueryContext(ctx,
+			`SELECT name_key FROM kg_entities WHERE id IN (`+placeholders+`) OR name_key IN (`+placeholders+`) ORDER BY rowid`,
+			append(sqliteArgs(batch), sqliteArgs(lowered)...)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			add(key)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range lowered {
+			add(key)
+		}
+	}
+	return keys, nil
+}
+
+// relationsOf returns the relations whose subject or object is one of the lowercased
+// names, and their keys, in row order
+func (s *SQLiteGraphStore) relationsOf(ctx context.Context, names []string) ([]string, []Relation, error) {
+	var keys []string
+	relations := make([]Relation, 0)
+	found := make(map[string]bool)
+	for start := 0; start < len(names); start += sqliteBatchSize {
+		batch := sqliteArgs(names[start:min(start+sqliteBatchSize, len(names))])
+		placeholders := sqlitePlaceholders(len(batch))
+		batchKeys, batchRelations, err := s.queryRelations(ctx,
+			`SELECT relation_key, id, subject, predicate, object, properties, confidence, mentions, temporal FROM kg_relations WHERE subject_key IN (`+placeholders+`) OR object_key IN (`+placeholders+`) ORDER BY rowid`,
+			append(append([]any(nil), batch...), batch...)...)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, relation := range batchRelations {
+			if !found[batchKeys[i]] {
+				found[batchKeys[i]] = true
+				keys = append(keys, batchKeys[i])
+				relations = append(relations, relation)
+			}
+		}
+	}
+	return keys, relations, nil
+}
+
+// sqlitePlaceholders returns n comma-separated host parameters
+func sqlitePlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sqliteArgs converts strings to query arguments
+func sqliteArgs(values []string) []any {
+	args := make([]any, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	return args
+}
+
+// LoadAll implements GraphStore
+func (s *SQLiteGraphStore) LoadAll(ctx context.Context) (*KnowledgeGraph, error) {
+	entities, err := s.queryEntities(ctx,
+		`SELECT id, name, type, properties, confidence, document_ids, mentions, attributes FROM kg_entities ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	_, relations, err := s.queryRelations(ctx,
+		`SELECT relation_key, id, subject, predicate, object, properties, confidence, mentions, temporal FROM kg_relations ORDER BY rowid`)
+	if err != nil {
+		return nil, err
+	}
+	return &KnowledgeGraph{Entities: entities, Relations: relations}, nil
+}
+
+// Clear implements GraphStore
+func (s *SQLiteGraphStore) Clear(ctx context.Context) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"kg_entities", "kg_relations", "kg_extracted"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Extracted implements GraphStore
+func (s *SQLiteGraphStore) Extracted(ctx context.Context, hashes []string) (map[string]bool, error) {
+	extracted := make(map[string]bool)
+	for start := 0; start < len(hashes); start += sqliteBatchSize {
+		batch := hashes[start:min(start+sqliteBatchSize, len(hashes))]
+		rows, err := s.db.QueryContext(ctx, `SELECT hash FROM kg_extracted WHERE hash IN (`+sqlitePlaceholders(len(batch))+`)`, sqliteArgs(batch)...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var hash string
+			if err := rows.Scan(&hash); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			extracted[hash] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return extracted, nil
+}
+
+// MarkExtracted implements GraphStore
+func (s *SQLiteGraphStore) MarkExtracted(ctx context.Context, hashes []string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO kg_extracted (hash) VALUES (?)`, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteDocument implements GraphStore
+func (s *SQLiteGraphStore) DeleteDocument(ctx context.Context, documentID string, hashes []string) error {
+	entities, err := s.queryEntities(ctx,
+		`SELECT id, name, type, properties, confidence, document_ids, mentions, attributes FROM kg_entities ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	keys, relations, err := s.queryRelations(ctx,
+		`SELECT relation_key, id, subject, predicate, object, properties, confidence, mentions, temporal FROM kg_relations ORDER BY rowid`)
+	if err != nil {
+		return err
+	}
+	graph := &KnowledgeGraph{Entities: entities, Relations: relations}
+	entityActions, relationActions := removeDocument(graph, documentID)
+
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		for i, entity := range graph.Entities {
+			typeKey, nameKey := strings.ToLower(entity.Type), strings.ToLower(entity.Name)
+			switch entityActions[i] {
+			case removalDelete:
+				if _, err := tx.ExecContext(ctx, `DELETE FROM kg_entities WHERE type_key = ? AND name_key = ?`, typeKey, nameKey); err != nil {
+					return err
+				}
+			case removalUpdate:
+				documentIDs, err := marshalColumn(entity.DocumentIDs)
+				if err != nil {
+					return err
+				}
+				mentions, err := marshalColumn(entity.Mentions)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, `UPDATE kg_entities SET document_ids = ?, mentions = ? WHERE type_key = ? AND name_key = ?`,
+					documentIDs, mentions, typeKey, nameKey); err != nil {
+					return err
+				}
+			}
+		}
+		for i, relation := range graph.Relations {
+			switch relationActions[i] {
+			case removalDelete:
+				if _, err := tx.ExecContext(ctx, `DELETE FROM kg_relations WHERE relation_key = ?`, keys[i]); err != nil {
+					return err
+				}
+			case removalUpdate:
+				mentions, err := marshalColumn(relation.Mentions)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.ExecContext(ctx, `UPDATE kg_relations SET mentions = ? WHERE relation_key = ?`, mentions, keys[i]); err != nil {
+					return err
+				}
+			}
+		}
+		for _, hash := range hashes {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM kg_extracted WHERE hash = ?`, hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs fn in a transaction, committing if it succeeds
+func (s *SQLiteGraphStore) inTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryEntities runs a query selecting entity columns
+func (s *SQLiteGraphStore) queryEntities(ctx context.Context, query string, args ...any) ([]Entity, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entities := make([]Entity, 0)
+	for rows.Next() {
+		var entity Entity
+		var properties, documents, mentions, attributes sql.NullString
+		if err := rows.Scan(&entity.ID, &entity.Name, &entity.Type, &properties, &entity.Confidence, &documents, &mentions, &attributes); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(attributes, &entity.Attributes); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(mentions, &entity.Mentions); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(properties, &entity.Properties); err != nil {
+			return nil, err
+		}
+		if err := unmarshalColumn(documents, &entity.DocumentIDs); err != nil {
+			return nil, err
+		}
+		entities = append(entities, entity)
+	}
+	return entities, rows.Err()
+}
+
+// queryRelations runs a query selecting the key and relation columns, returning the
+// relations and their keys in row order
+func (s *SQLiteGraphStore) queryRelations(ctx context.Context, query string, args ...any) ([]string, []Relation, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	relations := make([]Relation, 0)
+	for rows.Next() {
+		var key string
+		var relation Relation
+		var properties, mentions, temporal sql.NullString
+		if err := rows.Scan(&key, &relation.ID, &relation.Subject, &relation.Predicate, &relation.Object, &properties, &relation.Confidence, &mentions, &temporal); err != nil {
+			return nil, nil, err
+		}
+		if err := unmarshalColumn(temporal, &relation.Temporal); err != nil {
+			return nil, nil, err
+		}
+		if err := unmarshalColumn(mentions, &relation.Mentions); err != nil {
+			return nil, nil, err
+		}
+		if err := unmarshalColumn(properties, &relation.Properties); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		relations = append(relations, relation)
+	}
+	return keys, relations, rows.Err()
+}
+
+// marshalColumn encodes a value as a JSON column, NULL when empty
+func marshalColumn(value any) (sql.NullString, error) {
+	data, err := json.Marshal(value)
+	if err != nil || string(data) == "null" {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalColumn decodes a JSON column into value, leaving it unchanged for NULL
+func unmarshalColumn(column sql.NullString, value any) error {
+	if !column.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(column.String), value)
+}
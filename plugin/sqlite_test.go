@@ -0,0 +1,248 @@
+package plugin
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLite opens the SQLite database file at path, closing it when the test ends
+func openSQLite(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newSQLiteGraphStore returns a store on the database file at path
+func newSQLiteGraphStore(t *testing.T, path string) *SQLiteGraphStore {
+	t.Helper()
+	store, err := NewSQLiteGraphStore(context.Background(), openSQLite(t, path))
+	if err != nil {
+		t.Fatalf("NewSQLiteGraphStore: %v", err)
+	}
+	return store
+}
+
+// sqliteTestGraph has an entity with every column set and a relation with a temporal
+// qualifier
+var sqliteTestGraph = KnowledgeGraph{
+	Entities: []Entity{
+		{
+			Name: "Eiffel Tower", Type: "LOCATION", Confidence: 0.9,
+			Properties:  map[string]any{"height": "330 m"},
+			DocumentIDs: []string{"paris"},
+			Mentions:    []Mention{{DocumentID: "paris", ChunkID: "paris_chunk_0", CharStart: 4, CharEnd: 16, Sentence: "The Eiffel Tower is in Paris."}},
+			Attributes:  map[string]AttributeValue{"completed": {Value: "1889", Confidence: 0.8}},
+		},
+		{Name: "Paris", Type: "LOCATION", Confidence: 0.8, DocumentIDs: []string{"paris"}},
+	},
+	Relations: []Relation{
+		{
+			Subject: "Eiffel Tower", Predicate: "located_in", Object: "Paris", Confidence: 0.9,
+			Mentions: []Mention{{DocumentID: "paris", CharStart: 0, CharEnd: 29, Sentence: "The Eiffel Tower is in Paris."}},
+			Temporal: &TemporalQualifier{Start: "1889"},
+		},
+	},
+}
+
+// upsertGraph stores the entities and relations of the graph
+func upsertGraph(t *testing.T, store GraphStore, graph KnowledgeGraph) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.UpsertEntities(ctx, graph.Entities); err != nil {
+		t.Fatalf("UpsertEntities: %v", err)
+	}
+	if err := store.UpsertRelations(ctx, graph.Relations); err != nil {
+		t.Fatalf("UpsertRelations: %v", err)
+	}
+}
+
+// loadGraph returns everything stored
+func loadGraph(t *testing.T, store GraphStore) *KnowledgeGraph {
+	t.Helper()
+	graph, err := store.LoadAll(context.Background())
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	return graph
+}
+
+func TestSQLiteGraphStorePersists(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "graph.db")
+
+	store := newSQLiteGraphStore(t, path)
+	upsertGraph(t, store, sqliteTestGraph)
+	if err := store.MarkExtracted(ctx, []string{"hash1", "hash2"}); err != nil {
+		t.Fatalf("MarkExtracted: %v", err)
+	}
+	want := loadGraph(t, store)
+	store.db.Close()
+
+	// A store opened on the same file later sees everything
+	reopened := newSQLiteGraphStore(t, path)
+	got := loadGraph(t, reopened)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("reopened store has\n%+v\nwant\n%+v", got, want)
+	}
+	if len(got.Entities) != 2 || len(got.Relations) != 1 {
+		t.Fatalf("stored %d entities and %d relations, want 2 and 1", len(got.Entities), len(got.Relations))
+	}
+	tower, relation := got.Entities[0], got.Relations[0]
+	if tower.ID == "" || tower.Properties["height"] != "330 m" || len(tower.Mentions) != 1 || tower.Attributes["completed"].Value != "1889" {
+		t.Errorf("entity columns didn't round-trip: %+v", tower)
+	}
+	if relation.ID == "" || relation.Temporal == nil || relation.Temporal.Start != "1889" || len(relation.Mentions) != 1 {
+		t.Errorf("relation columns didn't round-trip: %+v", relation)
+	}
+
+	extracted, err := reopened.Extracted(ctx, []string{"hash1", "hash2", "hash3"})
+	if err != nil {
+		t.Fatalf("Extracted: %v", err)
+	}
+	if !extracted["hash1"] || !extracted["hash2"] || extracted["hash3"] {
+		t.Errorf("Extracted = %v, want hash1 and hash2", extracted)
+	}
+
+	if err := reopened.Clear(ctx); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	if graph := loadGraph(t, reopened); len(graph.Entities) != 0 || len(graph.Relations) != 0 {
+		t.Errorf("Clear left %+v", graph)
+	}
+}
+
+func TestSQLiteGraphStoreUpsertMerges(t *testing.T) {
+	ctx := context.Background()
+	store := newSQLiteGraphStore(t, filepath.Join(t.TempDir(), "graph.db"))
+	upsertGraph(t, store, sqliteTestGraph)
+
+	// The same entity under other case, from another document, less confident
+	upsertGraph(t, store, KnowledgeGraph{
+		Entities: []Entity{{
+			Name: "EIFFEL TOWER", Type: "location", Confidence: 0.5,
+			DocumentIDs: []string{"landmarks", "paris"},
+			Mentions:    []Mention{{DocumentID: "landmarks", CharStart: 0, CharEnd: 12, Sentence: "Eiffel Tower, Paris."}},
+		}},
+		Relations: []Relation{{
+			Subject: "eiffel tower", Predicate: "LOCATED_IN", Object: "paris", Confidence: 0.4,
+			Mentions: []Mention{{DocumentID: "landmarks", CharStart: 0, CharEnd: 19, Sentence: "Eiffel Tower, Paris."}},
+			Temporal: &TemporalQualifier{Start: "1900"},
+		}},
+	})
+
+	graph := loadGraph(t, store)
+	if len(graph.Entities) != 2 || len(graph.Relations) != 1 {
+		t.Fatalf("stored %d entities and %d relations, want the upserts merged into 2 and 1", len(graph.Entities), len(graph.Relations))
+	}
+	tower := graph.Entities[0]
+	if tower.Name != "Eiffel Tower" || tower.Confidence != 0.9 {
+		t.Errorf("merged entity %q with confidence %v, want the first name and the higher confidence", tower.Name, tower.Confidence)
+	}
+	if want := []string{"paris", "landmarks"}; !reflect.DeepEqual(tower.DocumentIDs, want) {
+		t.Errorf("merged documents %v, want %v", tower.DocumentIDs, want)
+	}
+	if len(tower.Mentions) != 2 || tower.Attributes["completed"].Value != "1889" {
+		t.Errorf("merged entity has mentions %+v and attributes %+v", tower.Mentions, tower.Attributes)
+	}
+	relation := graph.Relations[0]
+	if len(relation.Mentions) != 2 || relation.Temporal == nil || relation.Temporal.Start != "1889" {
+		t.Errorf("merged relation %+v, want both mentions and the first temporal qualifier", relation)
+	}
+
+	// Queries match names case-insensitively and bring their relations along
+	found, err := store.Query(ctx, "How tall is the EIFFEL tower?")
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(found.Entities) != 1 || found.Entities[0].Name != "Eiffel Tower" || len(found.Relations) != 1 {
+		t.Errorf("Query found %+v, want the tower and its relation", found)
+	}
+}
+
+func TestSQLiteGraphStoreDeleteDocument(t *testing.T) {
+	ctx := context.Background()
+	store := newSQLiteGraphStore(t, filepath.Join(t.TempDir(), "graph.db"))
+	upsertGraph(t, store, sqliteTestGraph)
+	upsertGraph(t, store, KnowledgeGraph{Entities: []Entity{{Name: "Eiffel Tower", Type: "LOCATION", Confidence: 0.5, DocumentIDs: []string{"landmarks"}}}})
+	if err := store.MarkExtracted(ctx, []string{"paris_hash"}); err != nil {
+		t.Fatalf("MarkExtracted: %v", err)
+	}
+
+	if err := store.DeleteDocument(ctx, "paris", []string{"paris_hash"}); err != nil {
+		t.Fatalf("DeleteDocument: %v", err)
+	}
+	graph := loadGraph(t, store)
+	if len(graph.Entities) != 1 || graph.Entities[0].Name != "Eiffel Tower" || !reflect.DeepEqual(graph.Entities[0].DocumentIDs, []string{"landmarks"}) {
+		t.Errorf("entities after deleting the document: %+v, want only the tower, from landmarks", graph.Entities)
+	}
+	if len(graph.Relations) != 0 {
+		t.Errorf("relations after deleting the document: %+v, want none", graph.Relations)
+	}
+	if extracted, err := store.Extracted(ctx, []string{"paris_hash"}); err != nil || extracted["paris_hash"] {
+		t.Errorf("Extracted = %v, %v, want the document's hash forgotten", extracted, err)
+	}
+}
+
+// TestSQLiteGraphStoreMigrates opens a database created before mentions, attributes
+// and temporal qualifiers were stored
+func TestSQLiteGraphStoreMigrates(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "graph.db")
+	db := openSQLite(t, path)
+	for _, statement := range []string{
+		`CREATE TABLE kg_entities (type_key TEXT NOT NULL, name_key TEXT NOT NULL, id TEXT NOT NULL, name TEXT NOT NULL, type TEXT NOT NULL,
+			properties TEXT, confidence REAL NOT NULL, document_ids TEXT, PRIMARY KEY (type_key, name_key))`,
+		`CREATE TABLE kg_relations (relation_key TEXT PRIMARY KEY, subject_key TEXT NOT NULL, object_key TEXT NOT NULL, id TEXT NOT NULL,
+			subject TEXT NOT NULL, predicate TEXT NOT NULL, object TEXT NOT NULL, properties TEXT, confidence REAL NOT NULL)`,
+		`INSERT INTO kg_entities VALUES ('location', 'paris', 'entity_paris', 'Paris', 'LOCATION', NULL, 0.8, '["paris"]')`,
+		`INSERT INTO kg_relations VALUES ('seine' || char(0) || 'flows_through' || char(0) || 'paris', 'seine', 'paris', 'relation_seine', 'Seine', 'flows_through', 'Paris', NULL, 0.7)`,
+	} {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			t.Fatalf("failed to create the old tables: %v", err)
+		}
+	}
+
+	// Opening the store twice migrates once
+	for range 2 {
+		if _, err := NewSQLiteGraphStore(ctx, db); err != nil {
+			t.Fatalf("NewSQLiteGraphStore on the old tables: %v", err)
+		}
+	}
+	store := &SQLiteGraphStore{db: db}
+
+	graph := loadGraph(t, store)
+	if len(graph.Entities) != 1 || graph.Entities[0].ID != "entity_paris" || len(graph.Relations) != 1 || graph.Relations[0].ID != "relation_seine" {
+		t.Fatalf("old rows loaded as %+v", graph)
+	}
+
+	// The new columns are written and read back
+	upsertGraph(t, store, sqliteTestGraph)
+	graph = loadGraph(t, store)
+	var paris *Entity
+	for i := range graph.Entities {
+		if graph.Entities[i].Name == "Paris" {
+			paris = &graph.Entities[i]
+		}
+	}
+	if paris == nil || paris.ID == "" || paris.Confidence != 0.8 {
+		t.Errorf("old entity after the upsert: %+v", paris)
+	}
+	if len(graph.Entities) != 2 || len(graph.Relations) != 2 {
+		t.Fatalf("stored %d entities and %d relations after the upsert, want 2 and 2", len(graph.Entities), len(graph.Relations))
+	}
+	if tower := graph.Entities[1]; len(tower.Mentions) != 1 || tower.Attributes["completed"].Value != "1889" {
+		t.Errorf("migrated columns didn't round-trip: %+v", tower)
+	}
+	if relation := graph.Relations[1]; relation.Temporal == nil || len(relation.Mentions) != 1 {
+		t.Errorf("migrated relation columns didn't round-trip: %+v", relation)
+	}
+}
@@ -0,0 +1,199 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+)
+
+// ChunkSet is the output of chunking: the documents and the chunks cut from them
+type ChunkSet struct {
+	Documents []Document
+	Chunks    []DocumentChunk
+}
+
+// ScoredChunks is the output of relevance scoring: the chunks relevant to the query,
+// most relevant first, with RelevanceScore set
+type ScoredChunks struct {
+	Query  string
+	Chunks []DocumentChunk
+}
+
+// SynthesisInput is what an answer is synthesized from. Chunks have already been fit
+// to the model's context window and the token budget.
+type SynthesisInput struct {
+	Query     string
+	Chunks    []DocumentChunk
+	Documents []Document
+	Options   AgenticRAGOptions
+}
+
+// Synthesis is a synthesized answer. Answer cites chunks with [n] markers referring to
+// Chunks[n-1] of the input when citations are enabled.
+type Synthesis struct {
+	Answer           string
+	CleanAnswer      string     // Set with Citations by synthesizers resolving their own citations
+	Citations        []Citation // Set by synthesizers resolving their own citations
+	StructuredAnswer any        // Set for ResponseFormatJSON
+	Tokens           int
+}
+
+// VerificationInput is what an answer's facts are verified against
+type VerificationInput struct {
+	Answer    string
+	Chunks    []DocumentChunk
+	Documents []Document // Documents of the chunks, for the offsets of evidence spans
+	Language  string     // Language of the claims and explanations, empty for the answer's
+
+	// Relations of the knowledge graph qualified in time, such as "Brewer FORMULATED CAP
+	// (1998)", to check the dates claims make against; empty without a graph
+	DatedFacts []string
+
+	ForceReverify bool // Bypass the verification cache
+}
+
+// Chunker cuts documents into chunks of at most maxChunks per document
+type Chunker interface {
+	Chunk(ctx context.Context, documents []Document, maxChunks int) (ChunkSet, error)
+}
+
+// RelevanceScorer scores chunks against a query and keeps the relevant ones. It also
+// scores the sub-chunks of recursive refinement.
+type RelevanceScorer interface {
+	Score(ctx context.Context, query string, chunks ChunkSet) (ScoredChunks, error)
+}
+
+// KnowledgeExtractor extracts entities and relations from chunks
+type KnowledgeExtractor interface {
+	Extract(ctx context.Context, chunks ScoredChunks) (*KnowledgeGraph, error)
+}
+
+// Synthesizer writes the answer to a query from chunks
+type Synthesizer interface {
+	Synthesize(ctx context.Context, input SynthesisInput) (Synthesis, error)
+}
+
+// Verifier checks the claims of an answer against chunks
+type Verifier interface {
+	Verify(ctx context.Context, input VerificationInput) (*FactVerification, error)
+}
+
+// ProcessorOption customizes a processor created by NewAgenticRAGProcessor
+type ProcessorOption func(*AgenticRAGProcessor)
+
+// WithChunker replaces the built-in chunker
+func WithChunker(chunker Chunker) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.chunker = chunker }
+}
+
+// WithRelevanceScorer replaces the built-in relevance scorer
+func WithRelevanceScorer(scorer RelevanceScorer) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.scorer = scorer }
+}
+
+// WithKnowledgeExtractor replaces the built-in knowledge graph extractor
+func WithKnowledgeExtractor(extractor KnowledgeExtractor) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.extractor = extractor }
+}
+
+// WithSynthesizer replaces the built-in answer synthesizer
+func WithSynthesizer(synthesizer Synthesizer) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.synthesizer = synthesizer }
+}
+
+// WithVerifier replaces the built-in fact verifier
+func WithVerifier(verifier Verifier) ProcessorOption {
+	return func(p *AgenticRAGProcessor) { p.verifier = verifier }
+}
+
+// builtinChunker is the built-in Chunker, using the configured chunk strategy and
+// reporting chunking progress per document
+type builtinChunker struct {
+	processor *AgenticRAGProcessor
+}
+
+// Chunk chunks each document in turn
+func (c builtinChunker) Chunk(ctx context.Context, documents []Document, maxChunks int) (ChunkSet, error) {
+	state := requestStateFrom(ctx)
+	set := ChunkSet{Documents: documents, Chunks: make([]DocumentChunk, 0)}
+	for i, doc := range documents {
+		chunks, err := c.processor.chunkDocument(ctx, doc, maxChunks)
+		if err != nil {
+			return ChunkSet{}, fmt.Errorf("failed to chunk document %s: %w", doc.ID, err)
+		}
+		set.Chunks = append(set.Chunks, chunks...)
+		state.reportProgress(StageChunking, i+1, len(documents))
+	}
+	return set, nil
+}
+
+// builtinScorer is the built-in RelevanceScorer, using the configured relevance mode
+type builtinScorer struct {
+	processor *AgenticRAGProcessor
+}
+
+// Score scores the chunks with the configured relevance mode
+func (s builtinScorer) Score(ctx context.Context, query string, chunks ChunkSet) (ScoredChunks, error) {
+	relevant, err := s.processor.identifyRelevantChunks(ctx, query, chunks.Chunks)
+	if err != nil {
+		return ScoredChunks{}, err
+	}
+	return ScoredChunks{Query: query, Chunks: relevant}, nil
+}
+
+// promptExtractor is the built-in KnowledgeExtractor, prompting the model for the graph
+type promptExtractor struct {
+	processor *AgenticRAGProcessor
+}
+
+// Extract builds the graph with the knowledge graph prompt
+func (e promptExtractor) Extract(ctx context.Context, chunks ScoredChunks) (*KnowledgeGraph, error) {
+	return e.processor.buildKnowledgeGraph(ctx, chunks.Chunks)
+}
+
+// promptSynthesizer is the built-in Synthesizer, writing extractive, structured or
+// prose answers as the options ask
+type promptSynthesizer struct {
+	processor *AgenticRAGProcessor
+}
+
+// Synthesize dispatches on the answer mode and response format
+func (s promptSynthesizer) Synthesize(ctx context.Context, input SynthesisInput) (Synthesis, error) {
+	var synthesis Synthesis
+	var err error
+	switch {
+	case input.Options.AnswerMode == AnswerModeExtractive:
+		documentIndex := make(map[string]int, len(input.Documents))
+		for i, doc := range input.Documents {
+			documentIndex[doc.ID] = i
+		}
+		synthesis.Answer, synthesis.CleanAnswer, synthesis.Citations, err = s.processor.generateExtractiveAnswer(ctx, input.Query, input.Chunks, documentIndex)
+		synthesis.Tokens = len(synthesis.Answer)
+	case input.Options.ResponseFormat == ResponseFormatJSON:
+		synthesis.StructuredAnswer, synthesis.Answer, err = s.processor.generateStructuredAnswer(ctx, input.Query, input.Chunks, input.Options)
+		synthesis.Tokens = len(synthesis.Answer)
+	default:
+		synthesis.Answer, synthesis.Tokens, err = s.processor.generateResponse(ctx, input.Query, input.Chunks, input.Options)
+	}
+	if err != nil {
+		return Synthesis{}, err
+	}
+	return synthesis, nil
+}
+
+// promptVerifier is the built-in Verifier, prompting the model to check each claim
+type promptVerifier struct {
+	processor *AgenticRAGProcessor
+}
+
+// Verify verifies the answer with the fact verification prompt, resampling borderline
+// verdicts when self-consistency is enabled
+func (v promptVerifier) Verify(ctx context.Context, input VerificationInput) (*FactVerification, error) {
+	verification, err := v.processor.verifyFacts(ctx, input.Answer, input.Chunks, input.Documents, input.Language, input.DatedFacts, input.ForceReverify)
+	if err != nil || verification == nil {
+		return verification, err
+	}
+	if v.processor.factVerification(ctx).SelfConsistency {
+		v.processor.resampleBorderline(ctx, verification, input)
+	}
+	return verification, nil
+}
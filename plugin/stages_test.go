@@ -0,0 +1,137 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeStages implements every pipeline stage trivially, recording what each received
+type fakeStages struct {
+	mu         sync.Mutex
+	chunked    []Document
+	scored     []DocumentChunk
+	extracted  []DocumentChunk
+	synthesis  SynthesisInput
+	verified   VerificationInput
+	scoreCalls int
+}
+
+// Chunk cuts each document into a single chunk
+func (f *fakeStages) Chunk(ctx context.Context, documents []Document, maxChunks int) (ChunkSet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.chunked = documents
+	set := ChunkSet{Documents: documents}
+	for _, doc := range documents {
+		set.Chunks = append(set.Chunks, DocumentChunk{
+			ID:         doc.ID + "_fake",
+			Content:    doc.Content,
+			DocumentID: doc.ID,
+			EndIndex:   len(doc.Content),
+		})
+	}
+	return set, nil
+}
+
+// Score keeps the chunks sharing a word with the query
+func (f *fakeStages) Score(ctx context.Context, query string, chunks ChunkSet) (ScoredChunks, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scoreCalls++
+	if f.scoreCalls == 1 {
+		f.scored = chunks.Chunks
+	}
+	scored := ScoredChunks{Query: query}
+	for _, chunk := range chunks.Chunks {
+		if strings.Contains(chunk.Content, "Eiffel") {
+			chunk.RelevanceScore = 1
+			scored.Chunks = append(scored.Chunks, chunk)
+		}
+	}
+	return scored, nil
+}
+
+// Extract names an entity after each chunk
+func (f *fakeStages) Extract(ctx context.Context, chunks ScoredChunks) (*KnowledgeGraph, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.extracted = chunks.Chunks
+	graph := &KnowledgeGraph{Entities: []Entity{}, Relations: []Relation{}}
+	for _, chunk := range chunks.Chunks {
+		graph.Entities = append(graph.Entities, Entity{ID: "entity_" + chunk.ID, Name: chunk.ID, Type: "CONCEPT", Confidence: 1})
+	}
+	return graph, nil
+}
+
+// Synthesize lists the chunks it was given
+func (f *fakeStages) Synthesize(ctx context.Context, input SynthesisInput) (Synthesis, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.synthesis = input
+	ids := make([]string, len(input.Chunks))
+	for i, chunk := range input.Chunks {
+		ids[i] = chunk.ID
+	}
+	answer := fmt.Sprintf("Synthesized from %s.", strings.Join(ids, ", "))
+	return Synthesis{Answer: answer, Tokens: len(answer)}, nil
+}
+
+// Verify supports the answer as a single claim
+func (f *fakeStages) Verify(ctx context.Context, input VerificationInput) (*FactVerification, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.verified = input
+	return &FactVerification{
+		Claims:  []Claim{{Text: input.Answer, Verdict: VerdictSupported, Confidence: 1}},
+		Overall: "verified",
+	}, nil
+}
+
+// TestFakeStagesCompose swaps every stage for a fake and checks each one receives the
+// output of the stage before it
+func TestFakeStagesCompose(t *testing.T) {
+	fake := &fakeStages{}
+	mock := &mockProvider{}
+	p := newTestProcessor(t, mock, nil,
+		WithChunker(fake), WithRelevanceScorer(fake), WithKnowledgeExtractor(fake),
+		WithSynthesizer(fake), WithVerifier(fake))
+
+	request := testRequest("Where is the Eiffel Tower?")
+	request.Options.EnableKnowledgeGraph = true
+	request.Options.EnableFactVerification = true
+	request.Options.RecursiveDepth = 1
+	response := mustProcess(t, p, request)
+
+	if len(fake.chunked) != len(testDocuments) {
+		t.Fatalf("chunker got %d documents, want %d", len(fake.chunked), len(testDocuments))
+	}
+	if len(fake.scored) != len(testDocuments) || fake.scored[0].ID != fake.chunked[0].ID+"_fake" {
+		t.Errorf("scorer didn't get the fake chunks: %+v", fake.scored)
+	}
+	wantChunk := fake.chunked[0].ID + "_fake"
+	if len(fake.extracted) != 1 || fake.extracted[0].ID != wantChunk {
+		t.Errorf("extractor got %+v, want the scored chunk %s", fake.extracted, wantChunk)
+	}
+	if len(fake.synthesis.Chunks) != 1 || fake.synthesis.Chunks[0].ID != wantChunk {
+		t.Errorf("synthesizer got %+v, want the scored chunk %s", fake.synthesis.Chunks, wantChunk)
+	}
+	if fake.synthesis.Query != request.Query {
+		t.Errorf("synthesizer got query %q, want %q", fake.synthesis.Query, request.Query)
+	}
+	if !strings.Contains(fake.verified.Answer, wantChunk) {
+		t.Errorf("verifier got answer %q, want the synthesized one", fake.verified.Answer)
+	}
+
+	if !strings.Contains(response.Answer, wantChunk) {
+		t.Errorf("answer = %q, want the synthesized one", response.Answer)
+	}
+	if response.KnowledgeGraph == nil || len(response.KnowledgeGraph.Entities) != 1 || response.KnowledgeGraph.Entities[0].Name != wantChunk {
+		t.Errorf("knowledge graph = %+v, want the fake extractor's", response.KnowledgeGraph)
+	}
+	if response.FactVerification == nil || response.FactVerification.Overall != "verified" {
+		t.Errorf("fact verification = %+v, want the fake verifier's", response.FactVerification)
+	}
+}
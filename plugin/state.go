@@ -0,0 +1,1334 @@
+package plugin
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestState accumulates per-request bookkeeping shared across pipeline stages.
+// It lives in the request context so concurrent Process calls never share it.
+type requestState struct {
+	mu             sync.Mutex
+	modelInstances map[string]int
+	modelsUsed     map[string]bool
+	escalations    []ModelEscalation
+	truncations    []TruncatedChunk
+	downgrades     []ModelDowngrade
+	noDowngrade    bool
+	grounding      *GroundingMetadata
+	modelTime      time.Duration
+	failedChunks   []ChunkError
+	failedDocs     []DocumentError
+	chunkFallback  bool
+	breakpoints    int
+	entityMerges   int // Knowledge graph entities merged as duplicates
+	relations      RelationValidation
+	language       string                  // BCP 47 language hint for sentence segmentation
+	entityTypes    []string                // Entity types the request extracts instead of the configured ones
+	factConfig     *FactVerificationConfig // Fact verification settings with the request's overrides, nil for the configured ones
+	modelCalls     int
+	embedCalls     int
+	embedHits      int // Embeddings served from the cache
+	embedMisses    int // Embeddings looked up in the cache and not found
+	tokensUsed     int
+	tokenBudget    int // Zero for no budget
+	budgetHit      bool
+	skippedStages  []string
+	stagesDone     []string
+	modelOverrides map[string]string         // Model per stage, "" for all stages
+	stageCalls     map[string]map[string]int // Successful model calls per stage and model
+	stageModels    map[string]StageModel     // Model each stage selected last
+	variants       map[string]string         // Prompt variants selected by the request
+	variantsUsed   map[string]string         // Prompt variants that ran, per prompt
+	promptsUsed    map[string]string         // Names of the prompts that ran, with any language and variant, per prompt
+	answerLang     string                    // Language the answer is requested in, see AgenticRAGOptions.ResponseLanguage
+	retrieved      []string                  // IDs of the chunks pulled from the retriever
+	deterministic  bool                      // Greedy decoding and ordered metadata, see AgenticRAGOptions.Deterministic
+	timings        []StageTiming             // Per-stage timings in the order the stages began
+	runningStage   string                    // Stage being timed, "" for none
+	stageStart     time.Time                 // When the running stage began
+	warnings       []string                  // Degradations to report in ProcessingMetadata.Warnings
+	filteredDocs   int                       // Documents excluded by the document filter
+	requestID      string                    // Random ID passed to the stage hooks
+	openStage      string                    // Stage whose end hooks haven't run, "" for none
+	openInput      string                    // Input summary of openStage
+	dryRun         bool                      // No model or embedder calls, see AgenticRAGOptions.DryRun
+	verifyFallback bool                      // The verification model failed, fact verification uses the main model
+	prompts        *promptSet                // Reloaded prompts as of the request's start
+	debug          bool                      // Capture the model calls, see AgenticRAGOptions.Debug
+	debugCalls     []DebugCall               // Captured model calls when no debug writer is configured
+	displaced      []string                  // Chunks diversification left out, recorded for debug requests
+	span           trace.Span                // Span of the request, nil when tracing is off
+	stageSpans     map[string]trace.Span     // Spans of the stages started and not yet ended
+	flowTracer     trace.Tracer              // Genkit's tracer when the request runs in a flow, nil otherwise
+
+	emit     func(RAGEvent) // Event sink set by ProcessStream before the pipeline starts
+	corpus   *batchCorpus   // Documents and chunks shared by a batch, set by ProcessBatch before the pipeline starts
+	progress *progressReporter
+}
+
+// requestStateKey is the context key for the request state
+type requestStateKey struct{}
+
+// withRequestState returns a context carrying a fresh request state
+func withRequestState(ctx context.Context) (context.Context, *requestState) {
+	state := &requestState{
+		modelInstances: make(map[string]int),
+		modelsUsed:     make(map[string]bool),
+		requestID:      newRequestID(),
+	}
+	return context.WithValue(ctx, requestStateKey{}, state), state
+}
+
+// requestStateFrom returns the request state stored in ctx, or nil if there is none.
+// All requestState methods are safe to call on a nil receiver.
+func requestStateFrom(ctx context.Context) *requestState {
+	state, _ := ctx.Value(requestStateKey{}).(*requestState)
+	return state
+}
+
+// recordInstance counts a model call served by the labelled model instance
+func (s *requestState) recordInstance(label string) {
+	if s == nil || label == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modelInstances[label]++
+}
+
+// instanceCounts returns a copy of the per-instance call counts
+func (s *requestState) instanceCounts() map[string]int {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.modelInstances) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(s.modelInstances))
+	for label, count := range s.modelInstances {
+		counts[label] = count
+	}
+	return counts
+}
+
+// recordModel notes a model that successfully served a call
+func (s *requestState) recordModel(name string) {
+	if s == nil || name == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modelsUsed[name] = true
+}
+
+// models returns the sorted names of the models that served calls
+func (s *requestState) models() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.modelsUsed) == 0 {
+		return nil
+	}
+	return sortedKeys(s.modelsUsed)
+}
+
+// recordEscalation notes a switch to a larger-context model
+func (s *requestState) recordEscalation(escalation ModelEscalation) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.escalations = append(s.escalations, escalation)
+}
+
+// modelEscalations returns a copy of the recorded escalations
+func (s *requestState) modelEscalations() []ModelEscalation {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.escalations) == 0 {
+		return nil
+	}
+	return append([]ModelEscalation(nil), s.escalations...)
+}
+
+// recordTruncation notes a chunk dropped or compressed to fit the context window
+func (s *requestState) recordTruncation(chunk TruncatedChunk) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncations = append(s.truncations, chunk)
+}
+
+// truncatedChunks returns a copy of the recorded truncations
+func (s *requestState) truncatedChunks() []TruncatedChunk {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.truncations) == 0 {
+		return nil
+	}
+	return append([]TruncatedChunk(nil), s.truncations...)
+}
+
+// disableDowngrades opts the request out of quota-driven model downgrades
+func (s *requestState) disableDowngrades() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noDowngrade = true
+}
+
+// downgradesAllowed reports whether calls for this request may use downgraded models
+func (s *requestState) downgradesAllowed() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.noDowngrade
+}
+
+// recordDowngrade notes a call served by a downgraded model, once per model pair
+func (s *requestState) recordDowngrade(downgrade ModelDowngrade) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.downgrades {
+		if existing == downgrade {
+			return
+		}
+	}
+	s.downgrades = append(s.downgrades, downgrade)
+}
+
+// modelDowngrades returns a copy of the recorded downgrades
+func (s *requestState) modelDowngrades() []ModelDowngrade {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.downgrades) == 0 {
+		return nil
+	}
+	return append([]ModelDowngrade(nil), s.downgrades...)
+}
+
+// recordGrounding stores the grounding metadata of the answer generation
+func (s *requestState) recordGrounding(grounding *GroundingMetadata) {
+	if s == nil || grounding == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.grounding = grounding
+}
+
+// groundingMetadata returns the recorded grounding metadata
+func (s *requestState) groundingMetadata() *GroundingMetadata {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.grounding
+}
+
+// streaming reports whether the request emits events
+func (s *requestState) streaming() bool {
+	return s != nil && s.emit != nil
+}
+
+// emitEvent sends an event to the request's event sink, if any
+func (s *requestState) emitEvent(event RAGEvent) {
+	if !s.streaming() {
+		return
+	}
+	s.emit(event)
+}
+
+// reportProgress forwards a progress update to the request's progress callback, if any
+func (s *requestState) reportProgress(stage string, done, total int) {
+	if s == nil {
+		return
+	}
+	s.progress.report(stage, done, total)
+}
+
+// addModelTime adds the duration of a model call to the cumulative model time
+func (s *requestState) addModelTime(d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modelTime += d
+}
+
+// totalModelTime returns the cumulative time spent in model calls
+func (s *requestState) totalModelTime() time.Duration {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modelTime
+}
+
+// recordChunkError notes a chunk whose processing failed in best-effort mode
+func (s *requestState) recordChunkError(chunkErr ChunkError) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failedChunks = append(s.failedChunks, chunkErr)
+}
+
+// chunkErrors returns a copy of the recorded chunk errors
+func (s *requestState) chunkErrors() []ChunkError {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failedChunks) == 0 {
+		return nil
+	}
+	return append([]ChunkError(nil), s.failedChunks...)
+}
+
+// addTokens adds the tokens used by a model call on behalf of the stage to the
+// request's total
+func (s *requestState) addTokens(stage string, tokens int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokensUsed += tokens
+	if timing := s.stageTiming(stage); timing != nil {
+		timing.TokensUsed += tokens
+	}
+}
+
+// tokens returns the tokens used so far by the request
+func (s *requestState) tokens() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokensUsed
+}
+
+// setTokenBudget caps the tokens the request may use, zero for no cap
+func (s *requestState) setTokenBudget(budget int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokenBudget = budget
+}
+
+// budgetRemaining returns the tokens left in the budget and whether a budget is set
+func (s *requestState) budgetRemaining() (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokenBudget <= 0 {
+		return 0, false
+	}
+	return max(s.tokenBudget-s.tokensUsed, 0), true
+}
+
+// markBudgetExhausted notes that the budget cut the request short
+func (s *requestState) markBudgetExhausted() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budgetHit = true
+}
+
+// skipStage notes an optional stage skipped because the budget ran out
+func (s *requestState) skipStage(stage string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.budgetHit = true
+	s.skippedStages = append(s.skippedStages, stage)
+}
+
+// budgetReport returns whether the budget ran out and which stages were skipped
+func (s *requestState) budgetReport() (bool, []string) {
+	if s == nil {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.budgetHit, append([]string(nil), s.skippedStages...)
+}
+
+// completeStage notes a pipeline stage that finished
+func (s *requestState) completeStage(stage string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stagesDone = append(s.stagesDone, stage)
+}
+
+// completedStages returns the stages that finished, in order
+func (s *requestState) completedStages() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.stagesDone...)
+}
+
+// recordDocumentError notes a document that couldn't be loaded
+func (s *requestState) recordDocumentError(docErr DocumentError) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failedDocs = append(s.failedDocs, docErr)
+}
+
+// documentErrors returns a copy of the recorded document errors
+func (s *requestState) documentErrors() []DocumentError {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.failedDocs) == 0 {
+		return nil
+	}
+	return append([]DocumentError(nil), s.failedDocs...)
+}
+
+// recordBreakpoints adds the topic breakpoints found by semantic chunking
+func (s *requestState) recordBreakpoints(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakpoints += n
+}
+
+// recordEntityMerges adds duplicate entities merged into another
+func (s *requestState) recordEntityMerges(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entityMerges += n
+}
+
+// entityMergeCount returns the duplicate entities merged so far
+func (s *requestState) entityMergeCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entityMerges
+}
+
+// recordRelationValidation adds the changes of a relation validation pass
+func (s *requestState) recordRelationValidation(stats RelationValidation) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.relations.add(stats)
+}
+
+// relationValidation returns the changes relation validation made so far, or nil if
+// it made none
+func (s *requestState) relationValidation() *RelationValidation {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.relations == (RelationValidation{}) {
+		return nil
+	}
+	stats := s.relations
+	return &stats
+}
+
+// recordChunkFallback notes a document chunked with the default strategy because the
+// configured one wasn't available
+func (s *requestState) recordChunkFallback() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.chunkFallback = true
+}
+
+// chunkingReport returns whether any document fell back to the default chunk strategy
+// and the number of semantic breakpoints
+func (s *requestState) chunkingReport() (bool, int) {
+	if s == nil {
+		return false, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chunkFallback, s.breakpoints
+}
+
+// setLanguageHint sets the request's language hint
+func (s *requestState) setLanguageHint(lang string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.language = lang
+}
+
+// setEntityTypeOverride records the entity types the request extracts
+func (s *requestState) setEntityTypeOverride(types []string) {
+	if s == nil || len(types) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entityTypes = append([]string(nil), types...)
+}
+
+// setPromptSnapshot records the reloaded prompts the request runs with
+func (s *requestState) setPromptSnapshot(set *promptSet) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prompts = set
+}
+
+// promptSnapshot returns the reloaded prompts the request runs with, nil if it didn't
+// record them
+func (s *requestState) promptSnapshot() *promptSet {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prompts
+}
+
+// setFactVerificationOverride records the fact verification settings of the request
+func (s *requestState) setFactVerificationOverride(config FactVerificationConfig) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.factConfig = &config
+}
+
+// factVerificationOverride returns the fact verification settings of the request, nil
+// for the configured ones
+func (s *requestState) factVerificationOverride() *FactVerificationConfig {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.factConfig
+}
+
+// entityTypeOverride returns the entity types the request extracts, nil for the
+// configured ones
+func (s *requestState) entityTypeOverride() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entityTypes
+}
+
+// languageHint returns the request's language hint, empty if none was given
+func (s *requestState) languageHint() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.language
+}
+
+// countModelCall counts a successful call of the model on behalf of the stage
+func (s *requestState) countModelCall(stage, model string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modelCalls++
+	if stage == "" {
+		return
+	}
+	if s.stageCalls == nil {
+		s.stageCalls = make(map[string]map[string]int)
+	}
+	if s.stageCalls[stage] == nil {
+		s.stageCalls[stage] = make(map[string]int)
+	}
+	s.stageCalls[stage][model]++
+}
+
+// recordStageModel notes the model the stage selected and what selected it
+func (s *requestState) recordStageModel(stage, model, source string) {
+	if s == nil || stage == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stageModels == nil {
+		s.stageModels = make(map[string]StageModel)
+	}
+	s.stageModels[stage] = StageModel{Model: model, Source: source}
+}
+
+// stageModelsSelected returns a copy of the model each stage selected last
+func (s *requestState) stageModelsSelected() map[string]StageModel {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stageModels) == 0 {
+		return nil
+	}
+	models := make(map[string]StageModel, len(s.stageModels))
+	for stage, model := range s.stageModels {
+		models[stage] = model
+	}
+	return models
+}
+
+// modelCallsByStage returns a copy of the successful model calls per stage and model
+func (s *requestState) modelCallsByStage() map[string]map[string]int {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stageCalls) == 0 {
+		return nil
+	}
+	calls := make(map[string]map[string]int, len(s.stageCalls))
+	for stage, models := range s.stageCalls {
+		calls[stage] = make(map[string]int, len(models))
+		for model, count := range models {
+			calls[stage][model] = count
+		}
+	}
+	return calls
+}
+
+// setModelOverrides sets the models the request selected, model applying to every
+// stage without an override of its own
+func (s *requestState) setModelOverrides(model string, overrides map[string]string) {
+	if s == nil || (model == "" && len(overrides) == 0) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.modelOverrides = make(map[string]string, len(overrides)+1)
+	for stage, name := range overrides {
+		s.modelOverrides[stage] = name
+	}
+	if model != "" {
+		s.modelOverrides[""] = model
+	}
+}
+
+// setPromptVariants sets the prompt variants the request selected
+func (s *requestState) setPromptVariants(variants map[string]string) {
+	if s == nil || len(variants) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.variants = make(map[string]string, len(variants))
+	for key, variant := range variants {
+		s.variants[key] = variant
+	}
+}
+
+// assignPromptVariant selects the variant a prompt experiment assigned the request,
+// "default" for the prompt without a variant
+func (s *requestState) assignPromptVariant(key, variant string) {
+	if s == nil {
+		return
+	}
+	if variant == promptVariantDefault {
+		variant = ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.variants == nil {
+		s.variants = make(map[string]string)
+	}
+	s.variants[key] = variant
+}
+
+// promptVariant returns the variant the request selected for the prompt and whether it
+// selected one
+func (s *requestState) promptVariant(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	variant, ok := s.variants[key]
+	return variant, ok
+}
+
+// recordPromptVariant notes the variant a prompt ran with
+func (s *requestState) recordPromptVariant(key, variant string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.variantsUsed == nil {
+		s.variantsUsed = make(map[string]string)
+	}
+	s.variantsUsed[key] = variant
+}
+
+// recordPromptName notes the name of the prompt that ran for the key, including any
+// language and variant suffix
+func (s *requestState) recordPromptName(key, name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.promptsUsed == nil {
+		s.promptsUsed = make(map[string]string)
+	}
+	s.promptsUsed[key] = name
+}
+
+// promptNames returns a copy of the names of the prompts that ran
+func (s *requestState) promptNames() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.promptsUsed) == 0 {
+		return nil
+	}
+	names := make(map[string]string, len(s.promptsUsed))
+	for key, name := range s.promptsUsed {
+		names[key] = name
+	}
+	return names
+}
+
+// setResponseLanguage sets the language the answer is requested in
+func (s *requestState) setResponseLanguage(lang string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.answerLang = lang
+}
+
+// responseLanguage returns the language the answer is requested in, "" when unknown
+func (s *requestState) responseLanguage() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.answerLang
+}
+
+// promptVariants returns a copy of the variants the prompts ran with
+func (s *requestState) promptVariants() map[string]string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.variantsUsed) == 0 {
+		return nil
+	}
+	variants := make(map[string]string, len(s.variantsUsed))
+	for key, variant := range s.variantsUsed {
+		variants[key] = variant
+	}
+	return variants
+}
+
+// modelOverride returns the model the request selected for the stage, or "" to use
+// the configured model
+func (s *requestState) modelOverride(stage string) string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name, ok := s.modelOverrides[stage]; ok && stage != "" {
+		return name
+	}
+	return s.modelOverrides[""]
+}
+
+// modelCallCount returns the number of successful model calls
+func (s *requestState) modelCallCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modelCalls
+}
+
+// countEmbeddingCall counts an embedding request
+func (s *requestState) countEmbeddingCall() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedCalls++
+}
+
+// embeddingCallCount returns the number of embedding requests
+func (s *requestState) embeddingCallCount() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.embedCalls
+}
+
+// countEmbeddingCacheHits counts embeddings served from the cache
+func (s *requestState) countEmbeddingCacheHits(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedHits += n
+}
+
+// embeddingCacheHits returns the number of embeddings served from the cache
+func (s *requestState) embeddingCacheHits() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.embedHits
+}
+
+// setDeterministic switches the request to deterministic mode
+func (s *requestState) setDeterministic() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deterministic = true
+}
+
+// isDeterministic reports whether the request runs in deterministic mode
+func (s *requestState) isDeterministic() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deterministic
+}
+
+// beginStage stops timing the running stage and starts timing the given one, or none
+// for ""
+func (s *requestState) beginStage(stage string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if timing := s.stageTiming(s.runningStage); timing != nil {
+		timing.Duration += now.Sub(s.stageStart)
+	}
+	s.runningStage, s.stageStart = stage, now
+	s.stageTiming(stage)
+}
+
+// countRetries adds the retries of a model call on behalf of the stage
+func (s *requestState) countRetries(stage string, retries int) {
+	if s == nil || retries <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timing := s.stageTiming(stage); timing != nil {
+		timing.Retries += retries
+	}
+}
+
+// addStageWork adds the time one unit of a fan-out stage's parallel work took
+func (s *requestState) addStageWork(stage string, d time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timing := s.stageTiming(stage); timing != nil {
+		timing.WorkTime += d
+	}
+}
+
+// stageTiming returns the timing of the stage, adding it if it's new, or nil for "".
+// The caller must hold the lock.
+func (s *requestState) stageTiming(stage string) *StageTiming {
+	if stage == "" {
+		return nil
+	}
+	for i := range s.timings {
+		if s.timings[i].Stage == stage {
+			return &s.timings[i]
+		}
+	}
+	s.timings = append(s.timings, StageTiming{Stage: stage})
+	return &s.timings[len(s.timings)-1]
+}
+
+// fallBackVerification moves fact verification to the main model, reporting whether
+// it hadn't been moved yet
+func (s *requestState) fallBackVerification() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.verifyFallback {
+		return false
+	}
+	s.verifyFallback = true
+	return true
+}
+
+// verificationFellBack reports whether fact verification moved to the main model
+func (s *requestState) verificationFellBack() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.verifyFallback
+}
+
+// modelUsage splits the model calls and tokens between fact verification and the other
+// stages, nil when fact verification didn't call a model
+func (s *requestState) modelUsage() map[string]ModelUsage {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stageCalls[StageFactVerification]) == 0 {
+		return nil
+	}
+	var generation, verification ModelUsage
+	for stage, models := range s.stageCalls {
+		usage := &generation
+		if stage == StageFactVerification {
+			usage = &verification
+		}
+		for model, calls := range models {
+			usage.ModelCalls += calls
+			if !containsString(usage.Models, model) {
+				usage.Models = append(usage.Models, model)
+			}
+		}
+	}
+	for _, timing := range s.timings {
+		if timing.Stage == StageFactVerification {
+			verification.TokensUsed += timing.TokensUsed
+		}
+	}
+	generation.ModelCalls = s.modelCalls - verification.ModelCalls
+	generation.TokensUsed = s.tokensUsed - verification.TokensUsed
+	sort.Strings(generation.Models)
+	sort.Strings(verification.Models)
+	return map[string]ModelUsage{ModelRoleGeneration: generation, ModelRoleVerification: verification}
+}
+
+// stageTimings returns a copy of the stage timings, including the running stage's
+// time so far and the model calls counted per stage
+func (s *requestState) stageTimings() []StageTiming {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.timings) == 0 {
+		return nil
+	}
+	timings := append([]StageTiming(nil), s.timings...)
+	for i := range timings {
+		if timings[i].Stage == s.runningStage {
+			timings[i].Duration += time.Since(s.stageStart)
+		}
+		for _, calls := range s.stageCalls[timings[i].Stage] {
+			timings[i].ModelCalls += calls
+		}
+	}
+	return timings
+}
+
+// recordWarning notes a degradation the response should report
+func (s *requestState) recordWarning(warning string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.warnings = append(s.warnings, warning)
+}
+
+// recordedWarnings returns a copy of the recorded warnings
+func (s *requestState) recordedWarnings() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.warnings) == 0 {
+		return nil
+	}
+	return append([]string(nil), s.warnings...)
+}
+
+// recordFilteredDocuments adds documents excluded by the document filter
+func (s *requestState) recordFilteredDocuments(n int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filteredDocs += n
+}
+
+// filteredDocuments returns the number of documents excluded by the document filter
+func (s *requestState) filteredDocuments() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filteredDocs
+}
+
+// id returns the request ID
+func (s *requestState) id() string {
+	if s == nil {
+		return ""
+	}
+	return s.requestID
+}
+
+// openHookStage notes a stage whose start hooks ran
+func (s *requestState) openHookStage(stage, input string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.openStage, s.openInput = stage, input
+}
+
+// closeHookStage returns the input summary of the stage if its end hooks are due, and
+// marks them run
+func (s *requestState) closeHookStage(stage string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stage == "" || s.openStage != stage {
+		return "", false
+	}
+	input := s.openInput
+	s.openStage, s.openInput = "", ""
+	return input, true
+}
+
+// hookStage returns the stage whose end hooks are due, or ""
+func (s *requestState) hookStage() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.openStage
+}
+
+// setDryRun marks the request as a dry run
+func (s *requestState) setDryRun() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dryRun = true
+}
+
+// isDryRun reports whether the request is a dry run
+func (s *requestState) isDryRun() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dryRun
+}
+
+// setDebug switches the request to capturing its model calls
+func (s *requestState) setDebug() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.debug = true
+}
+
+// isDebug reports whether the request captures its model calls
+func (s *requestState) isDebug() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.debug
+}
+
+// addDebugCall adds a captured model call to the debug trace
+func (s *requestState) addDebugCall(call DebugCall) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.debugCalls = append(s.debugCalls, call)
+}
+
+// debugTrace returns the captured model calls and displaced chunks, nil when nothing
+// was captured
+func (s *requestState) debugTrace() *DebugTrace {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.debugCalls) == 0 && len(s.displaced) == 0 {
+		return nil
+	}
+	return &DebugTrace{
+		Calls:           append([]DebugCall(nil), s.debugCalls...),
+		DisplacedChunks: append([]string(nil), s.displaced...),
+	}
+}
+
+// recordRetrievedChunks notes the chunks pulled from the retriever
+func (s *requestState) recordRetrievedChunks(chunks []DocumentChunk) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, chunk := range chunks {
+		s.retrieved = append(s.retrieved, chunk.ID)
+	}
+}
+
+// retrievedChunkIDs returns a copy of the IDs of the chunks pulled from the retriever
+func (s *requestState) retrievedChunkIDs() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.retrieved...)
+}
+
+// countEmbeddingCacheMisses counts embeddings looked up in the cache and not found
+func (s *requestState) countEmbeddingCacheMisses(n int) {
+	if s == nil || n == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.embedMisses += n
+}
+
+// embeddingCacheMisses returns the number of embeddings looked up in the cache and not
+// found
+func (s *requestState) embeddingCacheMisses() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.embedMisses
+}
+
+// recordDisplacedChunks notes the IDs of the chunks diversification left out, for the
+// debug trace of debug requests
+func (s *requestState) recordDisplacedChunks(ids []string) {
+	if s == nil || len(ids) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.debug {
+		s.displaced = append(s.displaced, ids...)
+	}
+}
+
+// setSpan notes the span of the request
+func (s *requestState) setSpan(span trace.Span) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.span = span
+}
+
+// requestSpan returns the span of the request, nil when tracing is off
+func (s *requestState) requestSpan() trace.Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.span
+}
+
+// openStageSpan notes the span of a stage that started
+func (s *requestState) openStageSpan(stage string, span trace.Span) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stageSpans == nil {
+		s.stageSpans = make(map[string]trace.Span)
+	}
+	s.stageSpans[stage] = span
+}
+
+// stageSpan returns the span of the stage while it runs, nil otherwise
+func (s *requestState) stageSpan(stage string) trace.Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stageSpans[stage]
+}
+
+// closeStageSpan returns the span of the stage for ending, nil if it isn't open
+func (s *requestState) closeStageSpan(stage string) trace.Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := s.stageSpans[stage]
+	delete(s.stageSpans, stage)
+	return span
+}
+
+// closeStageSpans returns the spans of every open stage for ending
+func (s *requestState) closeStageSpans() []trace.Span {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spans := make([]trace.Span, 0, len(s.stageSpans))
+	for _, span := range s.stageSpans {
+		spans = append(spans, span)
+	}
+	clear(s.stageSpans)
+	return spans
+}
+
+// setFlowTracer notes genkit's tracer for a request running in a flow
+func (s *requestState) setFlowTracer(tracer trace.Tracer) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flowTracer = tracer
+}
+
+// tracerInFlow returns genkit's tracer when the request runs in a flow, nil otherwise
+func (s *requestState) tracerInFlow() trace.Tracer {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flowTracer
+}
@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// partialEventWait is how long the Complete event of a partial response waits for a
+// full channel to drain before it's dropped and the channel closed
+var partialEventWait = 5 * time.Second
+
+// ProcessStream runs the agentic RAG flow like Process, emitting events as the
+// pipeline progresses. Events are delivered in order; the last event is either
+// Complete or Error, after which the channel is closed. The channel is also closed
+// when ctx is cancelled, so callers must keep receiving until close or cancel ctx.
+// With AllowPartial a cancelled request ends with a Complete event carrying the partial
+// response and the PartialResultError. When the channel's buffer is full the event
+// waits up to five seconds for the caller to receive, then the channel is closed
+// without it.
+func (p *AgenticRAGProcessor) ProcessStream(ctx context.Context, request AgenticRAGRequest) (<-chan RAGEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	events := make(chan RAGEvent, 16)
+	send := func(event RAGEvent) {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+
+	ctx, state := withRequestState(ctx)
+	state.emit = send
+
+	go func() {
+		defer close(events)
+
+		response, err := p.process(ctx, state, request)
+		var partial *PartialResultError
+		if errors.As(err, &partial) {
+			// ctx is done by now, so send would drop the partial response
+			timer := time.NewTimer(partialEventWait)
+			defer timer.Stop()
+			select {
+			case events <- RAGEvent{Type: EventComplete, Response: partial.Response, Err: err, Error: err.Error()}:
+			case <-timer.C:
+			}
+			return
+		}
+		if err != nil {
+			send(RAGEvent{Type: EventError, Err: err, Error: err.Error()})
+			return
+		}
+		send(RAGEvent{Type: EventComplete, Response: response})
+	}()
+
+	return events, nil
+}
+
+// answerDeltas returns a stream handler that emits AnswerDelta events for the answer
+// field of a structured response as its text accumulates. Text already emitted by an
+// earlier attempt isn't emitted again when a retry restarts the stream.
+func answerDeltas(state *requestState) func(text string) {
+	emitted := 0
+	return func(text string) {
+		answer := partialJSONString(text, "answer")
+		if len(answer) <= emitted {
+			return
+		}
+		state.emitEvent(RAGEvent{Type: EventAnswerDelta, Delta: answer[emitted:]})
+		emitted = len(answer)
+	}
+}
+
+// partialJSONString decodes as much of the string value of key as is present in a
+// possibly incomplete JSON object
+func partialJSONString(text, key string) string {
+	i := strings.Index(text, strconv.Quote(key))
+	if i < 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(text[i+len(key)+2:], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+	rest = rest[1:]
+
+	var value strings.Builder
+	for len(rest) > 0 {
+		c := rest[0]
+		switch {
+		case c == '"':
+			return value.String()
+		case c != '\\':
+			value.WriteByte(c)
+			rest = rest[1:]
+			continue
+		}
+
+		// Escape sequence; stop at an incomplete one and wait for more text
+		if len(rest) < 2 {
+			break
+		}
+		switch rest[1] {
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		case 'r':
+			value.WriteByte('\r')
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'u':
+			if len(rest) < 6 {
+				return value.String()
+			}
+			r, err := strconv.ParseUint(rest[2:6], 16, 32)
+			if err != nil {
+				return value.String()
+			}
+			value.WriteRune(rune(r))
+			rest = rest[6:]
+			continue
+		default:
+			value.WriteByte(rest[1])
+		}
+		rest = rest[2:]
+	}
+
+	// Don't emit a trailing partial UTF-8 sequence
+	result := value.String()
+	for len(result) > 0 {
+		r, size := utf8.DecodeLastRuneInString(result)
+		if r != utf8.RuneError || size != 1 {
+			break
+		}
+		result = result[:len(result)-1]
+	}
+	return result
+}
@@ -0,0 +1,104 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// cancelWithFullBuffer starts a partial-result stream, cancels it once its event
+// buffer is full and returns the events once the interrupted stage has failed
+func cancelWithFullBuffer(t *testing.T) <-chan RAGEvent {
+	t.Helper()
+	// The stage interrupted by the cancellation fails before the terminal event is sent
+	failed := make(chan struct{}, 1)
+	p := newTestProcessor(t, &mockProvider{}, nil, OnStageEnd(func(ctx context.Context, event StageEvent) error {
+		if event.Err != nil {
+			select {
+			case failed <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	}))
+	request := testRequest("Where is the Eiffel Tower?")
+	request.Documents = nil
+	for i := range 40 {
+		request.Documents = append(request.Documents, fmt.Sprintf("%s Copy %d.", testDocuments[i%len(testDocuments)], i))
+	}
+	request.Options.MaxChunks = 40
+	request.Options.AllowPartial = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	events, err := p.ProcessStream(ctx, request)
+	if err != nil {
+		t.Fatalf("ProcessStream: %v", err)
+	}
+
+	// Let the pipeline fill the buffer without receiving, then cancel
+	deadline := time.Now().Add(10 * time.Second)
+	for len(events) < cap(events) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the event buffer never filled, %d of %d events", len(events), cap(events))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-failed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("no stage failed after the cancellation")
+	}
+	return events
+}
+
+// TestProcessStreamPartialWithFullBuffer cancels a request while its event buffer is
+// full and checks the partial response still arrives as the last event
+func TestProcessStreamPartialWithFullBuffer(t *testing.T) {
+	events := cancelWithFullBuffer(t)
+	// Give the pipeline time to send the terminal event while the buffer is still full
+	time.Sleep(50 * time.Millisecond)
+
+	var last RAGEvent
+	received := 0
+	for event := range events {
+		last = event
+		received++
+	}
+	if received <= cap(events) {
+		t.Fatalf("received %d events, want the %d buffered ones and the terminal event", received, cap(events))
+	}
+	var partial *PartialResultError
+	if last.Type != EventComplete || !errors.As(last.Err, &partial) || last.Response == nil {
+		t.Fatalf("last event = %s with error %v, want Complete carrying the partial response", last.Type, last.Err)
+	}
+	if !errors.Is(last.Err, context.Canceled) {
+		t.Errorf("partial result error %v, want it to wrap context.Canceled", last.Err)
+	}
+}
+
+// TestProcessStreamPartialAbandoned cancels a request while its event buffer is full
+// and never receives, checking the stream still closes
+func TestProcessStreamPartialAbandoned(t *testing.T) {
+	wait := partialEventWait
+	partialEventWait = 20 * time.Millisecond
+	t.Cleanup(func() { partialEventWait = wait })
+
+	events := cancelWithFullBuffer(t)
+	time.Sleep(200 * time.Millisecond)
+
+	// Had the goroutine kept waiting, receiving now would deliver the partial response
+	received := 0
+	for event := range events {
+		if event.Type == EventComplete {
+			t.Fatal("the partial response was still waiting for the abandoned stream")
+		}
+		received++
+	}
+	if received != cap(events) {
+		t.Errorf("received %d events, want the %d buffered before the cancellation", received, cap(events))
+	}
+}
@@ -0,0 +1,209 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// ResponseFormat selects the shape of the generated answer
+type ResponseFormat string
+
+const (
+	ResponseFormatMarkdown ResponseFormat = "markdown" // Markdown text
+	ResponseFormatPlain    ResponseFormat = "plain"    // Text without markdown formatting
+	ResponseFormatJSON     ResponseFormat = "json"     // A JSON value matching AgenticRAGOptions.ResponseSchema
+)
+
+// generateStructuredAnswer asks the model for an answer matching the response schema.
+// It returns the parsed answer and the raw JSON text.
+func (p *AgenticRAGProcessor) generateStructuredAnswer(ctx context.Context, query string, chunks []DocumentChunk, options AgenticRAGOptions) (any, string, error) {
+	var contextBuilder strings.Builder
+	for i, chunk := range chunks {
+		fmt.Fprintf(&contextBuilder, "Source [%d]:\n%s\n\n", i+1, chunk.Content)
+	}
+	extraInstructions := ""
+	if options.EnableCitations {
+		extraInstructions = "\nCite sources inside string values with the bracketed marker shown for each source, e.g. [1] or [1][3]."
+	}
+	if instruction := languageInstruction(options.ResponseLanguage); instruction != "" {
+		extraInstructions += "\n" + instruction + "."
+	}
+	prompt := fmt.Sprintf(`Answer the question using ONLY the information provided in the context. If the context
+doesn't contain enough information, say so in the answer.%s
+
+Context Information:
+%s
+User Question: %s`, extraInstructions, contextBuilder.String(), query)
+
+	config := &ai.GenerationCommonConfig{
+		Temperature:     float64(options.Temperature),
+		MaxOutputTokens: 2000,
+	}
+	if options.MaxAnswerTokens > 0 {
+		config.MaxOutputTokens = options.MaxAnswerTokens
+	}
+	answer, raw, err := p.generateJSON(ctx, prompt, options.ResponseSchema, config)
+	var outputErr *StructuredOutputError
+	if err != nil && !errors.As(err, &outputErr) {
+		return nil, "", fmt.Errorf("failed to generate structured answer: %w", err)
+	}
+	return answer, raw, err
+}
+
+// generateJSON asks the model for JSON matching the schema, retrying once with the
+// problem spelled out when the output doesn't conform. It returns the parsed value and
+// the raw text, or a StructuredOutputError if the retry doesn't conform either.
+func (p *AgenticRAGProcessor) generateJSON(ctx context.Context, prompt string, schema map[string]any, config *ai.GenerationCommonConfig) (any, string, error) {
+	encoded, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode schema: %w", err)
+	}
+	instructions := fmt.Sprintf("Respond with only a JSON value matching this JSON schema, without code fences or commentary:\n%s", encoded)
+
+	call := func(prompt string) func(context.Context, modelCandidate) (*ai.ModelResponse, error) {
+		return func(ctx context.Context, model modelCandidate) (*ai.ModelResponse, error) {
+			opts := []ai.GenerateOption{
+				model.option,
+				ai.WithPrompt(prompt),
+				ai.WithConfig(config),
+				ai.WithOutputFormat(ai.OutputFormatJSON),
+				ai.WithOutputInstructions(instructions),
+			}
+			if middleware := p.callMiddleware(ctx, model); middleware != nil {
+				opts = append(opts, middleware)
+			}
+			return genkit.Generate(ctx, p.config.Genkit, opts...)
+		}
+	}
+
+	response, err := p.callModel(ctx, p.primaryCandidate(), estimateTokens(prompt+instructions), call(prompt))
+	if err != nil {
+		return nil, "", err
+	}
+	raw := response.Text()
+	value, parseErr := parseStructuredAnswer(raw, schema)
+	if parseErr == nil {
+		return value, raw, nil
+	}
+
+	// One corrective retry, showing the model what was wrong
+	corrective := fmt.Sprintf("%s\n\nYour previous response was rejected: %v\n\nPrevious response:\n%s\n\nRespond again with corrected JSON.", prompt, parseErr, raw)
+	response, err = p.callModel(ctx, p.primaryCandidate(), estimateTokens(corrective+instructions), call(corrective))
+	if err != nil {
+		return nil, "", err
+	}
+	raw = response.Text()
+	value, parseErr = parseStructuredAnswer(raw, schema)
+	if parseErr != nil {
+		return nil, raw, &StructuredOutputError{Raw: raw, Err: parseErr}
+	}
+	return value, raw, nil
+}
+
+// parseStructuredAnswer decodes the model's JSON, tolerating code fences, and checks
+// it against the schema
+func parseStructuredAnswer(text string, schema map[string]any) (any, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```json")
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+	}
+
+	var answer any
+	if err := json.Unmarshal([]byte(text), &answer); err != nil {
+		return nil, fmt.Errorf("not valid JSON: %w", err)
+	}
+	if err := checkSchema(answer, schema, "answer"); err != nil {
+		return nil, err
+	}
+	return answer, nil
+}
+
+// checkSchema checks a decoded JSON value against the type, required, properties and
+// items keywords of a JSON schema. Other keywords aren't enforced.
+func checkSchema(value any, schema map[string]any, path string) error {
+	if schemaType, ok := schema["type"].(string); ok && !hasJSONType(value, schemaType) {
+		return fmt.Errorf("%s must be of type %s", path, schemaType)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, key := range requiredProperties(schema) {
+			if _, exists := v[key]; !exists {
+				return fmt.Errorf("%s is missing required property %q", path, key)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for _, key := range sortedKeys(properties) {
+			property, exists := v[key]
+			nested, ok := properties[key].(map[string]any)
+			if !exists || !ok {
+				continue
+			}
+			if err := checkSchema(property, nested, path+"."+key); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := checkSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// requiredProperties returns the schema's required property names, whether the schema
+// was built in Go or decoded from JSON
+func requiredProperties(schema map[string]any) []string {
+	switch required := schema["required"].(type) {
+	case []string:
+		return required
+	case []any:
+		names := make([]string, 0, len(required))
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				names = append(names, key)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+// hasJSONType reports whether a decoded JSON value has the JSON schema type
+func hasJSONType(value any, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == float64(int64(n))
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package plugin
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// AnswerStyle selects how the answer is written
+type AnswerStyle string
+
+const (
+	AnswerStyleConcise  AnswerStyle = "concise"  // A few sentences leading with the direct answer
+	AnswerStyleDetailed AnswerStyle = "detailed" // Long-form, covering every relevant point
+	AnswerStyleBulleted AnswerStyle = "bulleted" // A list of short points
+)
+
+// styleInstruction returns the prompt instruction for the answer style, or "" for the
+// prompt's default style
+func styleInstruction(style AnswerStyle) string {
+	switch style {
+	case AnswerStyleConcise:
+		return "Be brief: lead with the direct answer and keep to a few sentences"
+	case AnswerStyleDetailed:
+		return "Give a thorough, long-form answer covering every relevant point in the context"
+	case AnswerStyleBulleted:
+		return "Answer as a bulleted list of short, self-contained points"
+	}
+	return ""
+}
+
+// lengthInstruction returns the prompt instruction for the requested answer length,
+// or "" when the length isn't limited
+func lengthInstruction(options AgenticRAGOptions) string {
+	var limits []string
+	if options.TargetLength > 0 {
+		limits = append(limits, pluralize(options.TargetLength, "sentence", "sentences"))
+	}
+	if options.MaxAnswerTokens > 0 {
+		limits = append(limits, "about "+pluralize(options.MaxAnswerTokens*3/4, "word", "words"))
+	}
+	if len(limits) == 0 {
+		return ""
+	}
+	return "Keep the answer to at most " + strings.Join(limits, " and ")
+}
+
+// truncateAnswer cuts the answer to at most maxSentences sentences (list items count
+// as sentences) and maxTokens estimated tokens, ending on a sentence boundary. Zero
+// limits are ignored. A first sentence over the token limit is cut at a word
+// boundary. The second return value reports whether anything was cut.
+func truncateAnswer(answer string, maxTokens, maxSentences int, lang string) (string, bool) {
+	if (maxTokens <= 0 || estimateTokens(answer) <= maxTokens) && maxSentences <= 0 {
+		return answer, false
+	}
+
+	var sentences []prosePart
+	offset := 0
+	for _, line := range strings.SplitAfter(answer, "\n") {
+		for _, sentence := range segmentSentences(line, lang) {
+			sentence.start += offset
+			sentence.end += offset
+			sentences = append(sentences, sentence)
+		}
+		offset += len(line)
+	}
+
+	end, count := 0, 0
+	for _, sentence := range sentences {
+		if strings.TrimSpace(sentence.text) == "" {
+			continue
+		}
+		if maxSentences > 0 && count == maxSentences {
+			break
+		}
+		if maxTokens > 0 && estimateTokens(answer[:sentence.end]) > maxTokens {
+			break
+		}
+		end, count = sentence.end, count+1
+	}
+
+	if end == 0 && maxTokens > 0 {
+		fit := prefixWithin(answer, maxTokens, estimateTokens)
+		if cut := strings.LastIndexAny(answer[:fit], " \t\n"); cut > 0 {
+			fit = cut
+		}
+		end = fit
+	}
+	if strings.TrimSpace(answer[end:]) == "" {
+		return answer, false
+	}
+	return strings.TrimRightFunc(answer[:end], unicode.IsSpace), true
+}
+
+// pluralize formats n with the singular or plural noun
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(n) + " " + plural
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TemporalQualifier is when a relation held or happened, as stated in the text.
+// Dates are ISO-8601 at the precision given: a year, a month or a day.
+type TemporalQualifier struct {
+	Date       string `json:"date,omitempty"`       // When it happened, for a point in time
+	Start      string `json:"start,omitempty"`      // When it began holding
+	End        string `json:"end,omitempty"`        // When it stopped holding
+	Expression string `json:"expression,omitempty"` // The text's wording, such as "in the late nineties"; the only field when no date could be normalized
+}
+
+// temporalLayouts are the date formats normalizeDate understands, with the ISO-8601
+// layout of the precision each carries
+var temporalLayouts = []struct{ layout, iso string }{
+	{"2006-01-02", "2006-01-02"},
+	{"2006/01/02", "2006-01-02"},
+	{time.RFC3339, "2006-01-02"},
+	{"January 2, 2006", "2006-01-02"},
+	{"January 2 2006", "2006-01-02"},
+	{"Jan 2, 2006", "2006-01-02"},
+	{"2 January 2006", "2006-01-02"},
+	{"2 Jan 2006", "2006-01-02"},
+	{"2006-01", "2006-01"},
+	{"2006/01", "2006-01"},
+	{"January 2006", "2006-01"},
+	{"Jan 2006", "2006-01"},
+	{"2006", "2006"},
+}
+
+// normalizeDate returns value as an ISO-8601 date, or false if it isn't one of
+// temporalLayouts
+func normalizeDate(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	for _, prefix := range []string{"in ", "on "} {
+		if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			value = value[len(prefix):]
+		}
+	}
+	for _, format := range temporalLayouts {
+		if parsed, err := time.Parse(format.layout, value); err == nil {
+			return parsed.Format(format.iso), true
+		}
+	}
+	return "", false
+}
+
+// parseTemporal reads the temporal fields of an extracted relation, normalizing the
+// dates. A date that can't be normalized becomes the expression, unless the text's
+// wording was given too. Returns nil when the relation isn't qualified in time.
+func parseTemporal(fields map[string]any) *TemporalQualifier {
+	text := func(key string) string {
+		value, _ := fields[key].(string)
+		return strings.TrimSpace(value)
+	}
+	temporal := TemporalQualifier{Expression: text("temporal_expression")}
+	var raw []string
+	for _, field := range []struct {
+		key  string
+		date *string
+	}{{"date", &temporal.Date}, {"start_date", &temporal.Start}, {"end_date", &temporal.End}} {
+		value := text(field.key)
+		if value == "" {
+			continue
+		}
+		if date, ok := normalizeDate(value); ok {
+			*field.date = date
+		} else {
+			raw = append(raw, value)
+		}
+	}
+	if temporal.Expression == "" {
+		temporal.Expression = strings.Join(raw, " – ")
+	}
+	if temporal == (TemporalQualifier{}) {
+		return nil
+	}
+	return &temporal
+}
+
+// temporalProperties returns the temporal qualifier flattened into export properties
+func temporalProperties(temporal *TemporalQualifier) [][2]string {
+	if temporal == nil {
+		return nil
+	}
+	var properties [][2]string
+	for _, property := range [][2]string{
+		{"date", temporal.Date},
+		{"start_date", temporal.Start},
+		{"end_date", temporal.End},
+		{"temporal_expression", temporal.Expression},
+	} {
+		if property[1] != "" {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// datedFacts describes the relations of the graph qualified in time, for fact
+// verification to check claims' dates against
+func datedFacts(kg *KnowledgeGraph) []string {
+	if kg == nil {
+		return nil
+	}
+	var facts []string
+	for _, relation := range kg.Relations {
+		if when := temporalLabel(relation.Temporal); when != "" {
+			facts = append(facts, fmt.Sprintf("%s %s %s (%s)", relation.Subject, relation.Predicate, relation.Object, when))
+		}
+	}
+	return facts
+}
+
+// temporalLabel describes the temporal qualifier briefly, such as "1998", "from 2001"
+// or "2001-03 – 2004", or returns "" for none
+func temporalLabel(temporal *TemporalQualifier) string {
+	switch {
+	case temporal == nil:
+		return ""
+	case temporal.Date != "":
+		return temporal.Date
+	case temporal.Start != "" && temporal.End != "":
+		return temporal.Start + " – " + temporal.End
+	case temporal.Start != "":
+		return "from " + temporal.Start
+	case temporal.End != "":
+		return "until " + temporal.End
+	}
+	return temporal.Expression
+}
@@ -0,0 +1,202 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/firebase/genkit/go/ai"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracingConfig contains configuration for OpenTelemetry tracing. Each request is a
+// span under the caller's span in its context, with a child span per stage and per
+// model or embedding call. Nothing is recorded when tracing is off.
+type TracingConfig struct {
+	Enabled bool `json:"enabled"` // Record spans with the global tracer provider, unless AgenticRAGConfig.TracerProvider is set
+}
+
+// tracerName is the instrumentation scope of the processor's spans
+const tracerName = "github.com/ZanzyTHEbar/genkit-agentic-rag/plugin"
+
+// Span attribute keys. Model calls also carry the OpenTelemetry gen_ai attributes.
+const (
+	attrRequestID            = "agentic_rag.request_id"
+	attrStage                = "agentic_rag.stage"
+	attrStageOutput          = "agentic_rag.stage.output"
+	attrDocuments            = "agentic_rag.documents"
+	attrChunks               = "agentic_rag.chunks"
+	attrRelevantChunks       = "agentic_rag.relevant_chunks"
+	attrModelCalls           = "agentic_rag.model_calls"
+	attrTokensUsed           = "agentic_rag.tokens_used"
+	attrModelsUsed           = "agentic_rag.models_used"
+	attrRetries              = "agentic_rag.retries"
+	attrCacheHit             = "agentic_rag.cache_hit"
+	attrEmbedder             = "agentic_rag.embedder"
+	attrTexts                = "agentic_rag.texts"
+	attrEmbeddingCacheHits   = "agentic_rag.embedding_cache_hits"
+	attrEmbeddingCacheMisses = "agentic_rag.embedding_cache_misses"
+	attrModel                = "gen_ai.request.model"
+	attrInputTokens          = "gen_ai.usage.input_tokens"
+	attrOutputTokens         = "gen_ai.usage.output_tokens"
+)
+
+// newTracer returns the tracer of the configuration, nil when tracing is off
+func newTracer(config *AgenticRAGConfig) trace.Tracer {
+	if config.TracerProvider != nil {
+		return config.TracerProvider.Tracer(tracerName)
+	}
+	if config.Tracing.Enabled {
+		return otel.GetTracerProvider().Tracer(tracerName)
+	}
+	return nil
+}
+
+// tracerFor returns the tracer of the request: genkit's when it runs in a flow, so the
+// spans show in the developer UI, else the configured one, nil when tracing is off
+func (p *AgenticRAGProcessor) tracerFor(state *requestState) trace.Tracer {
+	if tracer := state.tracerInFlow(); tracer != nil {
+		return tracer
+	}
+	return p.tracer
+}
+
+// startRequestSpan starts the span of a request under the caller's span in ctx,
+// returning the context carrying it
+func (p *AgenticRAGProcessor) startRequestSpan(ctx context.Context, state *requestState, request AgenticRAGRequest) context.Context {
+	tracer := p.tracerFor(state)
+	if tracer == nil {
+		return ctx
+	}
+	ctx, span := tracer.Start(ctx, "agentic_rag.process", trace.WithAttributes(
+		attribute.String(attrRequestID, state.id()),
+		attribute.Int(attrDocuments, len(request.Docs)+len(request.Documents)),
+	))
+	state.setSpan(span)
+	return ctx
+}
+
+// endRequestSpan ends the span of a request with what its response reports, ending
+// the stage spans a failure left open
+func (p *AgenticRAGProcessor) endRequestSpan(state *requestState, response *AgenticRAGResponse, err error) {
+	span := state.requestSpan()
+	if span == nil {
+		return
+	}
+	p.endStageSpans(state, err)
+	var partial *PartialResultError
+	if response == nil && errors.As(err, &partial) {
+		response = partial.Response
+	}
+	if response != nil {
+		metadata := response.ProcessingMetadata
+		span.SetAttributes(
+			attribute.Int(attrChunks, metadata.ChunksProcessed),
+			attribute.Int(attrRelevantChunks, len(response.RelevantChunks)),
+			attribute.Int(attrModelCalls, metadata.ModelCalls),
+			attribute.Int(attrTokensUsed, metadata.TokensUsed),
+			attribute.StringSlice(attrModelsUsed, metadata.ModelsUsed),
+			attribute.Bool(attrCacheHit, metadata.CacheHit),
+			attribute.Int(attrEmbeddingCacheHits, metadata.EmbeddingCacheHits),
+			attribute.Int(attrEmbeddingCacheMisses, metadata.EmbeddingCacheMisses),
+		)
+	}
+	endSpan(span, err)
+}
+
+// startStageSpan starts the span of a stage under the request's span. In a flow, the
+// stage's flow step is its span.
+func (p *AgenticRAGProcessor) startStageSpan(ctx context.Context, state *requestState, stage string) {
+	if p.tracer == nil || state.tracerInFlow() != nil {
+		return
+	}
+	_, span := p.tracer.Start(ctx, "agentic_rag."+stage, trace.WithAttributes(attribute.String(attrStage, stage)))
+	state.openStageSpan(stage, span)
+}
+
+// endStageSpan ends the span of a stage with its output summary or error
+func (p *AgenticRAGProcessor) endStageSpan(state *requestState, stage, output string, err error) {
+	if p.tracer == nil {
+		return
+	}
+	span := state.closeStageSpan(stage)
+	if span == nil {
+		return
+	}
+	if output != "" {
+		span.SetAttributes(attribute.String(attrStageOutput, output))
+	}
+	endSpan(span, err)
+}
+
+// endStageSpans ends the spans of the stages still open when a request fails
+func (p *AgenticRAGProcessor) endStageSpans(state *requestState, err error) {
+	if p.tracer == nil {
+		return
+	}
+	for _, span := range state.closeStageSpans() {
+		endSpan(span, err)
+	}
+}
+
+// startCallSpan starts the span of a provider call under the span of the stage in ctx,
+// or the caller's span outside stages and in flows. The returned context carries the span, so
+// genkit's own spans nest under it. Returns a nil span when tracing is off.
+func (p *AgenticRAGProcessor) startCallSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	state := requestStateFrom(ctx)
+	tracer := p.tracerFor(state)
+	if tracer == nil {
+		return ctx, nil
+	}
+	stage := stageFrom(ctx)
+	if span := state.stageSpan(stage); span != nil {
+		ctx = trace.ContextWithSpan(ctx, span)
+	}
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient))
+	if stage != "" {
+		span.SetAttributes(attribute.String(attrStage, stage))
+	}
+	return ctx, span
+}
+
+// endModelSpan ends the span of a model call with the model, its token usage and
+// retries
+func endModelSpan(span trace.Span, model string, response *ai.ModelResponse, retries int, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(attribute.String(attrModel, model), attribute.Int(attrRetries, retries))
+	if response != nil && response.Usage != nil {
+		span.SetAttributes(
+			attribute.Int(attrInputTokens, response.Usage.InputTokens),
+			attribute.Int(attrOutputTokens, response.Usage.OutputTokens),
+		)
+	}
+	endSpan(span, err)
+}
+
+// endEmbedSpan ends the span of an embedding call with the embedder, the texts and how
+// many of them the cache served
+func endEmbedSpan(span trace.Span, embedder ai.Embedder, texts, cacheHits, retries int, err error) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(
+		attribute.String(attrEmbedder, embedder.Name()),
+		attribute.Int(attrTexts, texts),
+		attribute.Int(attrEmbeddingCacheHits, cacheHits),
+		attribute.Int(attrRetries, retries),
+	)
+	endSpan(span, err)
+}
+
+// endSpan ends the span, marking it failed with err unless err is nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
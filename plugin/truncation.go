@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// Truncation actions reported in TruncatedChunk.Action
+const (
+	TruncationActionDropped    = "dropped"    // Chunk was left out of the prompt
+	TruncationActionCompressed = "compressed" // Chunk was replaced by a summary
+)
+
+// Estimated token overhead of the response prompt template and of each chunk's framing
+const (
+	promptOverheadTokens = 512
+	chunkOverheadTokens  = 16
+)
+
+// contextBudget returns the number of tokens available for context chunks in the
+// response prompt, or 0 if the limit is unknown
+func (p *AgenticRAGProcessor) contextBudget(query string) int {
+	config := p.config.Truncation
+	limit := config.MaxContextTokens
+	if limit == 0 {
+		name := p.config.ModelName
+		if p.config.Model != nil {
+			name = p.config.Model.Name()
+		}
+		limit = p.contextWindow(name)
+	}
+	if limit == 0 {
+		return 0
+	}
+
+	budget := limit - config.OutputReserve - promptOverheadTokens - estimateTokens(query)
+	if budget < 1 {
+		budget = 1
+	}
+	return budget
+}
+
+// chunkTokens estimates the prompt tokens taken by a chunk
+func chunkTokens(chunk DocumentChunk) int {
+	return estimateTokens(chunk.Content) + chunkOverheadTokens
+}
+
+// fitContext applies the configured truncation strategy so the chunks fit the
+// response prompt's budget. Every dropped or compressed chunk is recorded in the
+// request state for the response metadata.
+func (p *AgenticRAGProcessor) fitContext(ctx context.Context, query string, chunks []DocumentChunk) ([]DocumentChunk, error) {
+	strategy := p.config.Truncation.Strategy
+	if strategy == "" || strategy == TruncationNone {
+		return chunks, nil
+	}
+
+	budget := p.contextBudget(query)
+	if budget == 0 {
+		return chunks, nil
+	}
+
+	total := 0
+	for _, chunk := range chunks {
+		total += chunkTokens(chunk)
+	}
+	if total <= budget {
+		return chunks, nil
+	}
+
+	state := requestStateFrom(ctx)
+	kept := append([]DocumentChunk(nil), chunks...)
+	drop := func(i int) {
+		state.recordTruncation(TruncatedChunk{
+			ChunkID:        kept[i].ID,
+			DocumentID:     kept[i].DocumentID,
+			Action:         TruncationActionDropped,
+			OriginalTokens: estimateTokens(kept[i].Content),
+		})
+		total -= chunkTokens(kept[i])
+		kept = append(kept[:i], kept[i+1:]...)
+	}
+
+	switch strategy {
+	case TruncationHeadTail:
+		// Keep the beginning and end of the context, dropping from the middle
+		for total > budget && len(kept) > 0 {
+			drop(len(kept) / 2)
+		}
+
+	case TruncationSummary:
+		// Compress the least relevant chunks first, stopping once everything fits
+		for _, i := range byRelevance(kept) {
+			if total <= budget {
+				break
+			}
+			original := estimateTokens(kept[i].Content)
+			summary, err := p.summarizeChunk(ctx, query, kept[i].Content, original/4)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				continue
+			}
+			if tokens := estimateTokens(summary); tokens < original {
+				state.recordTruncation(TruncatedChunk{
+					ChunkID:        kept[i].ID,
+					DocumentID:     kept[i].DocumentID,
+					Action:         TruncationActionCompressed,
+					OriginalTokens: original,
+					Tokens:         tokens,
+				})
+				total -= original - tokens
+				kept[i].Content = summary
+			}
+		}
+		// Summaries alone weren't enough, drop what still overflows
+		fallthrough
+
+	case TruncationDropLowestRelevance:
+		for total > budget && len(kept) > 0 {
+			drop(byRelevance(kept)[0])
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown truncation strategy %q", strategy)
+	}
+
+	return kept, nil
+}
+
+// byRelevance returns chunk indices ordered from least to most relevant, with
+// later chunks first among equal scores
+func byRelevance(chunks []DocumentChunk) []int {
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = len(chunks) - 1 - i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return chunks[order[a]].RelevanceScore < chunks[order[b]].RelevanceScore
+	})
+	return order
+}
+
+// summarizeChunk compresses chunk content to roughly targetTokens, keeping the
+// facts relevant to the query. Uses the configured summary model when set.
+func (p *AgenticRAGProcessor) summarizeChunk(ctx context.Context, query, content string, targetTokens int) (string, error) {
+	if targetTokens < 32 {
+		targetTokens = 32
+	}
+
+	prompt := fmt.Sprintf(`Summarize the following passage in at most %d words. Keep every fact, name and number that could help answer the question, and do not add information.
+
+Question: %s
+
+Passage:
+%s
+
+Summary:`, targetTokens*3/4, query, content)
+
+	config := &ai.GenerationCommonConfig{Temperature: 0}
+
+	var response *ai.ModelResponse
+	var err error
+	if name := p.config.Truncation.SummaryModel; name != "" {
+		response, err = p.withFailover(ctx, namedCandidate(name), p.generateCall(prompt, config))
+	} else {
+		response, err = p.generate(ctx, prompt, config)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize chunk: %w", err)
+	}
+
+	return strings.TrimSpace(response.Text()), nil
+}
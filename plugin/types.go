@@ -1,10 +1,12 @@
 package plugin
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/firebase/genkit/go/ai"
 	"github.com/firebase/genkit/go/genkit"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Core request/response types for agentic RAG flow
@@ -12,8 +14,24 @@ import (
 // AgenticRAGRequest represents a request for the agentic RAG flow
 type AgenticRAGRequest struct {
 	Query     string            `json:"query" jsonschema_description:"The user's query or question"`
-	Documents []string          `json:"documents,omitempty" jsonschema_description:"Documents to process (URLs, file paths, or raw text)"`
+	Documents []string          `json:"documents,omitempty" jsonschema_description:"Documents to process as raw text (legacy, see docs)"`
+	Docs      []Document        `json:"docs,omitempty" jsonschema_description:"Structured documents to process, with IDs and metadata"`
 	Options   AgenticRAGOptions `json:"options,omitempty" jsonschema_description:"Processing options"`
+
+	ConversationID string `json:"conversation_id,omitempty" jsonschema_description:"Conversation to continue; documents may be omitted on follow-up turns"`
+	History        []Turn `json:"history,omitempty" jsonschema_description:"Prior turns, oldest first (default: the conversation's stored turns)"`
+}
+
+// Turn roles
+const (
+	TurnRoleUser      = "user"
+	TurnRoleAssistant = "assistant"
+)
+
+// Turn is one message of a conversation
+type Turn struct {
+	Role    string `json:"role" jsonschema_description:"user or assistant"`
+	Content string `json:"content"`
 }
 
 // AgenticRAGOptions contains processing options
@@ -23,34 +41,173 @@ type AgenticRAGOptions struct {
 	EnableKnowledgeGraph   bool    `json:"enable_knowledge_graph,omitempty" jsonschema_description:"Whether to build knowledge graph"`
 	EnableFactVerification bool    `json:"enable_fact_verification,omitempty" jsonschema_description:"Whether to verify facts in response"`
 	Temperature            float32 `json:"temperature,omitempty" jsonschema_description:"Temperature for generation (default: 0.7)"`
+	AllowDowngrade         *bool   `json:"allow_downgrade,omitempty" jsonschema_description:"Whether calls may fall back to cheaper models on quota exhaustion (default: true)"`
+	EnableGrounding        *bool   `json:"enable_grounding,omitempty" jsonschema_description:"Whether to ground the answer with Google Search (default: from config)"`
+	MaxTotalTokens         int     `json:"max_total_tokens,omitempty" jsonschema_description:"Token budget across all model calls; optional stages are skipped when it runs out (default: unlimited)"`
+	Language               string  `json:"language,omitempty" jsonschema_description:"BCP 47 language hint for sentence segmentation, e.g. en or ja"`
+	EnableCitations        bool    `json:"enable_citations,omitempty" jsonschema_description:"Whether to cite source chunks with [n] markers in the answer"`
+	EnableQueryRewrite     bool    `json:"enable_query_rewrite,omitempty" jsonschema_description:"Whether to expand the query for retrieval; the answer still responds to the original wording"`
+	EnableRerank           bool    `json:"enable_rerank,omitempty" jsonschema_description:"Whether to rerank the top relevant chunks with a dedicated scoring pass"`
+	RerankTopN             int     `json:"rerank_top_n,omitempty" jsonschema_description:"Number of top chunks to rerank (default: from config)"`
+
+	EnableGraphExpansion    bool `json:"enable_graph_expansion,omitempty" jsonschema_description:"Whether to add chunks mentioning entities related to those in the query, walking the conversation's knowledge graph and the graph store"`
+	GraphExpansionHops      int  `json:"graph_expansion_hops,omitempty" jsonschema_description:"Relations to walk from the query's entities, 1 or 2 (default: 1)"`
+	MaxGraphExpansionChunks int  `json:"max_graph_expansion_chunks,omitempty" jsonschema_description:"Maximum number of chunks graph expansion adds (default: 3)"`
+
+	EnableDiversification bool    `json:"enable_diversification,omitempty" jsonschema_description:"Whether to select up to max_chunks relevant chunks by maximal marginal relevance, trading some relevance for coverage"`
+	DiversityLambda       float64 `json:"diversity_lambda,omitempty" jsonschema_description:"Weight of relevance against diversity from 0 to 1, lower favoring coverage (default: from config)"`
+
+	EntityTypes []string `json:"entity_types,omitempty" jsonschema_description:"Entity types to extract into the knowledge graph, replacing the configured ones for this request"`
+
+	EnableCommunityRouting bool `json:"enable_community_routing,omitempty" jsonschema_description:"Whether to first narrow the chunks to those about the knowledge graph communities whose summaries best match the query, for large corpora"`
+	MaxCommunities         int  `json:"max_communities,omitempty" jsonschema_description:"Communities community routing narrows the chunks to (default: 3)"`
+
+	ResponseFormat ResponseFormat `json:"response_format,omitempty" jsonschema_description:"Answer format: markdown, plain or json (default: markdown)"`
+	ResponseSchema map[string]any `json:"response_schema,omitempty" jsonschema_description:"JSON schema the answer must match, required for the json format"`
+
+	Style           AnswerStyle `json:"style,omitempty" jsonschema_description:"Answer style: concise, detailed or bulleted"`
+	TargetLength    int         `json:"target_length,omitempty" jsonschema_description:"Maximum answer length in sentences; longer answers are truncated"`
+	MaxAnswerTokens int         `json:"max_answer_tokens,omitempty" jsonschema_description:"Maximum answer length in tokens, also capping the model's output"`
+
+	ResponseLanguage     string           `json:"response_language,omitempty" jsonschema_description:"Language to answer in, e.g. es or Spanish (default: detected from the query)"`
+	AnswerMode           AnswerMode       `json:"answer_mode,omitempty" jsonschema_description:"abstractive (default) or extractive, answering only with verbatim quotes that are cited in Citations"`
+	MinAnswerConfidence  float64          `json:"min_answer_confidence,omitempty" jsonschema_description:"Confidence from 0 to 1 below which the answer is withheld and a refusal returned instead (default: 0, never refuse)"`
+	CheckGroundedness    bool             `json:"check_groundedness,omitempty" jsonschema_description:"Whether to check each answer sentence against the chunks, reported in groundedness"`
+	ExternalVerification bool             `json:"external_verification,omitempty" jsonschema_description:"Whether to look up claims the documents don't settle in the verification source, by default Google Search; sends claims outside and adds latency"`
+	UngroundedPolicy     UngroundedPolicy `json:"ungrounded_policy,omitempty" jsonschema_description:"What to do with sentences the chunks don't support: keep (default), remove or hedge"`
+
+	Model          string            `json:"model,omitempty" jsonschema_description:"Model for every stage of this request, e.g. googleai/gemini-2.5-pro (default: from config)"`
+	ModelOverrides map[string]string `json:"model_overrides,omitempty" jsonschema_description:"Model per pipeline stage, e.g. relevance_scoring or generation, taking precedence over model"`
+
+	DocumentFilter *DocumentFilter `json:"document_filter,omitempty" jsonschema_description:"Predicates over document metadata, e.g. team eq payments, selecting the documents to use before chunking"`
+
+	PromptVariants map[string]string `json:"prompt_variants,omitempty" jsonschema_description:"Prompt variant per prompt for this request, e.g. response_generation: creative, overriding the configured variants and experiments"`
+	ExperimentSeed string            `json:"experiment_seed,omitempty" jsonschema_description:"Key the prompt experiment variants are sampled from, e.g. a user ID, so it always gets the same variants (default: the query)"`
+
+	ForceRefresh     bool                       `json:"force_refresh,omitempty" jsonschema_description:"Whether to bypass the response cache and store a fresh response"`
+	ForceReverify    bool                       `json:"force_reverify,omitempty" jsonschema_description:"Whether to bypass the verification cache and store fresh verdicts, e.g. after documents changed"`
+	FactVerification *FactVerificationOverrides `json:"fact_verification,omitempty" jsonschema_description:"Fact verification settings for this request, merged over the configured ones"`
+	AllowPartial     bool                       `json:"allow_partial,omitempty" jsonschema_description:"Whether a cancelled or timed out request returns what the completed stages produced, see PartialResultError"`
+	Deterministic    bool                       `json:"deterministic,omitempty" jsonschema_description:"Whether to run reproducibly for evaluations: temperature 0, a fixed seed where supported, no load balancing and ordered metadata"`
+	DryRun           bool                       `json:"dry_run,omitempty" jsonschema_description:"Whether to load and chunk the documents and return the planned model calls in dry_run_plan without making any"`
+	Debug            bool                       `json:"debug,omitempty" jsonschema_description:"Whether to capture the rendered prompt, generation config and raw output of every model call in debug_trace, bypassing the response cache"`
+
+	// OnProgress is called at stage transitions and per unit of work within a stage.
+	// Calls happen on a separate goroutine, are dropped if the callback falls behind
+	// and stop before Process returns, which waits for a call in progress.
+	OnProgress func(stage string, done, total int) `json:"-"`
 }
 
 // AgenticRAGResponse represents the response from agentic RAG flow
 type AgenticRAGResponse struct {
-	Answer             string             `json:"answer" jsonschema_description:"The generated answer"`
-	RelevantChunks     []ProcessedChunk   `json:"relevant_chunks" jsonschema_description:"Chunks used to generate answer"`
-	KnowledgeGraph     *KnowledgeGraph    `json:"knowledge_graph,omitempty" jsonschema_description:"Knowledge graph if enabled"`
-	FactVerification   *FactVerification  `json:"fact_verification,omitempty" jsonschema_description:"Fact verification results if enabled"`
-	ProcessingMetadata ProcessingMetadata `json:"processing_metadata" jsonschema_description:"Processing metadata"`
+	Answer             string              `json:"answer" jsonschema_description:"The generated answer"`
+	ConversationID     string              `json:"conversation_id,omitempty" jsonschema_description:"Conversation the answer was added to"`
+	CleanAnswer        string              `json:"clean_answer,omitempty" jsonschema_description:"The answer without citation markers, when citations are enabled"`
+	Citations          []Citation          `json:"citations,omitempty" jsonschema_description:"Sources referenced by the answer's citation markers"`
+	StructuredAnswer   any                 `json:"structured_answer,omitempty" jsonschema_description:"The parsed answer for the json response format, Answer holds its raw text"`
+	RelevantChunks     []ProcessedChunk    `json:"relevant_chunks" jsonschema_description:"Chunks used to generate answer"`
+	KnowledgeGraph     *KnowledgeGraph     `json:"knowledge_graph,omitempty" jsonschema_description:"Knowledge graph if enabled"`
+	FactVerification   *FactVerification   `json:"fact_verification,omitempty" jsonschema_description:"Fact verification results if enabled"`
+	Confidence         float64             `json:"confidence" jsonschema_description:"Confidence in the answer from 0 to 1, combining chunk relevance, the model's self-assessment and fact verification where available"`
+	Refusal            *Refusal            `json:"refusal,omitempty" jsonschema_description:"Why the answer was withheld, when its confidence fell below min_answer_confidence"`
+	Groundedness       *GroundednessReport `json:"groundedness,omitempty" jsonschema_description:"Whether the chunks support each answer sentence, when check_groundedness is set"`
+	VerificationReport string              `json:"verification_report,omitempty" jsonschema_description:"Fact verification rendered as a markdown report, when the processor attaches one"`
+	ProcessingMetadata ProcessingMetadata  `json:"processing_metadata" jsonschema_description:"Processing metadata"`
+	DebugTrace         *DebugTrace         `json:"debug_trace,omitempty" jsonschema_description:"Rendered prompts, generation configs and raw outputs of the model calls, when debug is set and no debug writer is configured"`
+}
+
+// GroundednessReport labels each sentence of the answer by whether the chunks it was
+// built from support it
+type GroundednessReport struct {
+	Sentences []SentenceGroundedness `json:"sentences"`
+	Ratio     float64                `json:"ratio"` // Share of sentences labelled grounded
+}
+
+// SentenceGroundedness is the verdict on one answer sentence, as generated before
+// UngroundedPolicy was applied
+type SentenceGroundedness struct {
+	Sentence string            `json:"sentence"`
+	Label    GroundednessLabel `json:"label"`
+	ChunkIDs []string          `json:"chunk_ids,omitempty"` // Chunks the verdict rests on
+}
+
+// Refusal explains an answer withheld for low confidence
+type Refusal struct {
+	Reason              string   `json:"reason"`
+	ClarifyingQuestions []string `json:"clarifying_questions,omitempty"` // Questions for the user that could lead to an answer
+}
+
+// RAGEventType identifies the kind of event emitted by ProcessStream
+type RAGEventType string
+
+const (
+	EventChunkingDone          RAGEventType = "chunking_done"           // Documents were chunked, see ChunkCount
+	EventRelevanceScored       RAGEventType = "relevance_scored"        // A chunk was scored as relevant, see Chunk
+	EventKnowledgeGraphUpdated RAGEventType = "knowledge_graph_updated" // Entities and relations were added, see KnowledgeGraph
+	EventAnswerDelta           RAGEventType = "answer_delta"            // More answer text was generated, see Delta
+	EventComplete              RAGEventType = "complete"                // The pipeline finished, see Response
+	EventError                 RAGEventType = "error"                   // The pipeline failed, see Err
+)
+
+// RAGEvent is a pipeline progress event emitted by ProcessStream
+type RAGEvent struct {
+	Type           RAGEventType        `json:"type"`
+	ChunkCount     int                 `json:"chunk_count,omitempty"`
+	Chunk          *DocumentChunk      `json:"chunk,omitempty"`
+	KnowledgeGraph *KnowledgeGraph     `json:"knowledge_graph,omitempty"` // Delta since the previous update
+	Delta          string              `json:"delta,omitempty"`
+	Response       *AgenticRAGResponse `json:"response,omitempty"`
+	Err            error               `json:"-"`
+	Error          string              `json:"error,omitempty"`
+}
+
+// Citation ties a citation marker in the answer to the source chunk it refers to
+type Citation struct {
+	Marker        int      `json:"marker"`         // Number used in the answer's [n] markers
+	DocumentIndex int      `json:"document_index"` // Index of the source document in the request
+	DocumentID    string   `json:"document_id"`
+	ChunkID       string   `json:"chunk_id"`
+	CharStart     int      `json:"char_start"`             // Start offset of the chunk in the document
+	CharEnd       int      `json:"char_end"`               // End offset of the chunk in the document
+	Page          int      `json:"page,omitempty"`         // Page the chunk is on, for paged documents
+	HeadingPath   []string `json:"heading_path,omitempty"` // Enclosing markdown headings of the chunk
+	Quote         string   `json:"quote"`                  // Cited chunk text
+	Reason        string   `json:"reason,omitempty"`       // Why the chunk was retrieved, for chunks added by graph expansion
 }
 
 // Document represents a document to be processed
 type Document struct {
-	ID       string                 `json:"id"`
+	ID       string                 `json:"id,omitempty" jsonschema_description:"Unique document ID (default: doc_<index>)"`
+	Title    string                 `json:"title,omitempty"`
 	Content  string                 `json:"content"`
-	Source   string                 `json:"source"`
+	Source   string                 `json:"source,omitempty" jsonschema_description:"URI the document came from"`
+	MIMEType string                 `json:"mime_type,omitempty"`
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	Data  []byte         `json:"data,omitempty" jsonschema_description:"Raw document bytes, such as a PDF"`
+	Path  string         `json:"path,omitempty" jsonschema_description:"Local file path to read the document from"`
+	Pages []DocumentPage `json:"pages,omitempty"`
+}
+
+// DocumentPage is the text of one page of a paged document
+type DocumentPage struct {
+	Number  int    `json:"number"` // 1-based page number
+	Content string `json:"content"`
+	OCR     bool   `json:"ocr,omitempty"` // Text was transcribed from the page image
 }
 
 // DocumentChunk represents a chunk of a document
 type DocumentChunk struct {
-	ID             string  `json:"id"`
-	Content        string  `json:"content"`
-	DocumentID     string  `json:"document_id"`
-	ChunkIndex     int     `json:"chunk_index"`
-	StartIndex     int     `json:"start_index"`
-	EndIndex       int     `json:"end_index"`
-	RelevanceScore float64 `json:"relevance_score,omitempty"`
+	ID             string   `json:"id"`
+	Content        string   `json:"content"`
+	DocumentID     string   `json:"document_id"`
+	ChunkIndex     int      `json:"chunk_index"`
+	StartIndex     int      `json:"start_index"`
+	EndIndex       int      `json:"end_index"`
+	RelevanceScore float64  `json:"relevance_score,omitempty"`
+	Page           int      `json:"page,omitempty"`         // Page the chunk is on, for paged documents
+	HeadingPath    []string `json:"heading_path,omitempty"` // Enclosing markdown headings, outermost first
+	BlockType      string   `json:"block_type,omitempty"`   // BlockTypeCode or BlockTypeTable for blocks kept whole
 }
 
 // ProcessedChunk represents a chunk that has been processed and scored
@@ -63,11 +220,14 @@ type ProcessedChunk struct {
 
 // Entity represents an extracted entity
 type Entity struct {
-	ID         string                 `json:"id"`
-	Name       string                 `json:"name"`
-	Type       string                 `json:"type"`
-	Properties map[string]interface{} `json:"properties,omitempty"`
-	Confidence float64                `json:"confidence"`
+	ID          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Type        string                    `json:"type"`
+	Properties  map[string]interface{}    `json:"properties,omitempty"`
+	Confidence  float64                   `json:"confidence"`
+	DocumentIDs []string                  `json:"document_ids,omitempty"` // Documents the entity was found in
+	Mentions    []Mention                 `json:"mentions,omitempty"`     // Where the entity is named in the documents
+	Attributes  map[string]AttributeValue `json:"attributes,omitempty"`   // Facts the text states about the entity, by lower snake case name
 }
 
 // Relation represents a relationship between entities
@@ -78,48 +238,305 @@ type Relation struct {
 	Object     string                 `json:"object"`
 	Properties map[string]interface{} `json:"properties,omitempty"`
 	Confidence float64                `json:"confidence"`
+	Mentions   []Mention              `json:"mentions,omitempty"` // Sentences expressing the relation
+	Temporal   *TemporalQualifier     `json:"temporal,omitempty"` // When the relation held or happened, if the text says
 }
 
+// MergePolicy resolves the confidence of an entity found in several merged graphs
+type MergePolicy string
+
+const (
+	MergePolicyMax      MergePolicy = "max"      // Keep the highest confidence
+	MergePolicyWeighted MergePolicy = "weighted" // Average the confidences, weighted by each entity's mentions
+)
+
 // KnowledgeGraph represents the constructed knowledge graph
 type KnowledgeGraph struct {
-	Entities  []Entity               `json:"entities"`
-	Relations []Relation             `json:"relations"`
-	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Entities    []Entity               `json:"entities"`
+	Relations   []Relation             `json:"relations"`
+	Communities []Community            `json:"communities,omitempty"` // With KnowledgeGraphConfig.EnableCommunities
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // FactVerification represents fact verification results
 type FactVerification struct {
 	Claims   []Claim                `json:"claims"`
-	Overall  string                 `json:"overall"` // "verified", "partially_verified", "unverified"
+	Overall  string                 `json:"overall"` // Derived from the claims' verdicts: "verified", "refuted" or "mixed"
 	Metadata map[string]interface{} `json:"metadata,omitempty"`
 }
 
-// Claim represents a factual claim and its verification
+// Claim represents a factual claim and its verification. ClaimExtractor fills in
+// where the claim comes from; fact verification adds its verdict and evidence.
 type Claim struct {
-	Text       string   `json:"text"`
-	Status     string   `json:"status"` // "verified", "refuted", "inconclusive"
-	Confidence float64  `json:"confidence"`
-	Evidence   []string `json:"evidence,omitempty"`
+	Text             string          `json:"text"`              // The claim as a self-contained statement
+	Verdict          Verdict         `json:"verdict,omitempty"` // Empty until verified
+	Confidence       float64         `json:"confidence"`        // Confidence in the verdict, between 0 and 1
+	Evidence         []string        `json:"evidence,omitempty"`
+	Sources          []string        `json:"sources,omitempty"` // URLs of web sources backing the claim when grounded
+	Type             ClaimType       `json:"type,omitempty"`
+	Sentence         string          `json:"sentence,omitempty"`       // Sentence of the text making the claim
+	SentenceStart    int             `json:"sentence_start,omitempty"` // Byte offset of the sentence in the text
+	SentenceEnd      int             `json:"sentence_end,omitempty"`
+	Entities         []string        `json:"entities,omitempty"`          // Entities the claim names
+	EvidenceSpans    []EvidenceSpan  `json:"evidence_spans,omitempty"`    // Where the evidence was found in the chunks
+	ExternalEvidence []Evidence      `json:"external_evidence,omitempty"` // Evidence from the verification source, with the verdict resting on it
+	NumericConflict  string          `json:"numeric_conflict,omitempty"`  // How the claim's numbers disagree with the model's verdict, flagged for review
+	Votes            map[Verdict]int `json:"votes,omitempty"`             // Verdicts of self-consistency sampling, the first verification included
+	Cached           bool            `json:"cached,omitempty"`            // The verdict came from the verification cache
+}
+
+// BatchResult is the outcome of one query of a batch
+type BatchResult struct {
+	Query    string              `json:"query"`
+	Response *AgenticRAGResponse `json:"response,omitempty"` // Nil when the query failed
+	Err      error               `json:"-"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// BatchResponse represents the response from ProcessBatch
+type BatchResponse struct {
+	Results        []BatchResult   `json:"results"`                   // One per query, in query order
+	KnowledgeGraph *KnowledgeGraph `json:"knowledge_graph,omitempty"` // Graphs of all answered queries merged
+	Metadata       BatchMetadata   `json:"metadata"`
+}
+
+// BatchMetadata contains metadata about a whole batch
+type BatchMetadata struct {
+	RequestID            string          `json:"request_id"` // ID passed to the stage hooks while loading and chunking
+	ProcessingTime       time.Duration   `json:"processing_time"`
+	ChunksProcessed      int             `json:"chunks_processed"`                 // Chunks of the shared corpus
+	Succeeded            int             `json:"succeeded"`                        // Queries answered
+	Failed               int             `json:"failed"`                           // Queries that returned an error
+	TokensUsed           int             `json:"tokens_used"`                      // Tokens across loading and every query
+	ModelCalls           int             `json:"model_calls"`                      // Model calls across loading and every query
+	EmbeddingCalls       int             `json:"embedding_calls,omitempty"`        // Embedding requests across indexing and every query
+	EmbeddingCacheHits   int             `json:"embedding_cache_hits,omitempty"`   // Embeddings served from the cache
+	EmbeddingCacheMisses int             `json:"embedding_cache_misses,omitempty"` // Embeddings looked up in the cache and not found
+	DocumentCacheHits    int             `json:"document_cache_hits,omitempty"`    // Documents served from the URL cache
+	DocumentErrors       []DocumentError `json:"document_errors,omitempty"`        // Documents that couldn't be loaded and were skipped
+	DocumentsFiltered    int             `json:"documents_filtered,omitempty"`     // Documents excluded by the document filter
+	StageTimings         []StageTiming   `json:"stage_timings,omitempty"`          // Loading and chunking of the shared corpus; each query reports its own stages
 }
 
 // ProcessingMetadata contains metadata about the processing
 type ProcessingMetadata struct {
-	ProcessingTime  time.Duration `json:"processing_time"`
-	ChunksProcessed int           `json:"chunks_processed"`
-	RecursiveLevels int           `json:"recursive_levels"`
-	ModelCalls      int           `json:"model_calls"`
-	TokensUsed      int           `json:"tokens_used"`
+	RequestID            string                    `json:"request_id"` // ID passed to the stage hooks
+	ProcessingTime       time.Duration             `json:"processing_time"`
+	ChunksProcessed      int                       `json:"chunks_processed"`
+	RecursiveLevels      int                       `json:"recursive_levels"`                // Refinement levels actually run
+	RecursionStopReason  string                    `json:"recursion_stop_reason,omitempty"` // Why refinement stopped, one of the StopReason constants
+	ModelCalls           int                       `json:"model_calls"`
+	EmbeddingCalls       int                       `json:"embedding_calls,omitempty"`        // Embedding requests sent, after caching
+	EmbeddingCacheHits   int                       `json:"embedding_cache_hits,omitempty"`   // Embeddings served from the cache
+	EmbeddingCacheMisses int                       `json:"embedding_cache_misses,omitempty"` // Embeddings looked up in the cache and not found
+	Rerank               *RerankMetadata           `json:"rerank,omitempty"`                 // Chunk order before and after reranking
+	OriginalQuery        string                    `json:"original_query,omitempty"`         // Query as sent, when query rewriting is enabled
+	AnswerTruncated      bool                      `json:"answer_truncated,omitempty"`       // The answer exceeded the requested length and was cut on a sentence boundary
+	ResponseLanguage     string                    `json:"response_language,omitempty"`      // Language the answer was requested in, when known
+	RewrittenQuery       string                    `json:"rewritten_query,omitempty"`        // Query used for retrieval
+	TokensUsed           int                       `json:"tokens_used"`
+	ModelInstances       map[string]int            `json:"model_instances,omitempty"`      // Calls served per load-balanced model instance label
+	ModelsUsed           []string                  `json:"models_used,omitempty"`          // Models that served at least one call
+	ModelEscalations     []ModelEscalation         `json:"model_escalations,omitempty"`    // Switches to larger-context models
+	TruncatedChunks      []TruncatedChunk          `json:"truncated_chunks,omitempty"`     // Chunks dropped or compressed to fit the context window
+	ModelDowngrades      []ModelDowngrade          `json:"model_downgrades,omitempty"`     // Cheaper models used after quota exhaustion
+	Grounding            *GroundingMetadata        `json:"grounding,omitempty"`            // Web sources the answer was grounded on
+	ModelTime            time.Duration             `json:"model_time"`                     // Cumulative time spent in model calls, compare with ProcessingTime
+	ChunkErrors          []ChunkError              `json:"chunk_errors,omitempty"`         // Chunks that failed in best-effort mode
+	DocumentErrors       []DocumentError           `json:"document_errors,omitempty"`      // Documents that couldn't be loaded and were skipped
+	DocumentsFiltered    int                       `json:"documents_filtered,omitempty"`   // Documents excluded by the document filter
+	ChunkStrategy        ChunkStrategy             `json:"chunk_strategy,omitempty"`       // Strategy actually used, after any fallback
+	Breakpoints          int                       `json:"breakpoints,omitempty"`          // Topic breakpoints found by semantic chunking
+	BudgetExhausted      bool                      `json:"budget_exhausted,omitempty"`     // The token budget cut the request short
+	SkippedStages        []string                  `json:"skipped_stages,omitempty"`       // Optional stages skipped to stay within the budget
+	CompletedStages      []string                  `json:"completed_stages,omitempty"`     // Stages that finished, in order
+	ModelCallsByStage    map[string]map[string]int `json:"model_calls_by_stage,omitempty"` // Successful model calls per stage and model
+	PromptVariants       map[string]string         `json:"prompt_variants,omitempty"`      // Variant each dotprompt ran with, "default" for none
+	PromptNames          map[string]string         `json:"prompt_names,omitempty"`         // Name of each dotprompt that ran, with any language and variant, e.g. response_generation.de
+	RetrievedChunks      []string                  `json:"retrieved_chunks,omitempty"`     // IDs of the chunks pulled from the retriever; the others were cut from supplied documents
+	CacheHit             bool                      `json:"cache_hit,omitempty"`            // The response was served from the response cache
+	StageTimings         []StageTiming             `json:"stage_timings,omitempty"`        // Time and model usage per stage, in the order the stages ran
+	Warnings             []string                  `json:"warnings,omitempty"`             // Request options that were adjusted, such as a clamped recursive depth
+	DryRunPlan           *DryRunPlan               `json:"dry_run_plan,omitempty"`         // Model calls the request would make, for dry runs
+	EntityMerges         int                       `json:"entity_merges,omitempty"`        // Duplicate knowledge graph entities merged into another
+	GraphExpansions      []GraphExpansion          `json:"graph_expansions,omitempty"`     // Chunks added by graph expansion and why
+	GraphPruned          *PruneResult              `json:"graph_pruned,omitempty"`         // Entities and relations dropped from the knowledge graph by its size limits
+	RoutedCommunities    []Community               `json:"routed_communities,omitempty"`   // Communities community routing narrowed the chunks to
+	RelationValidation   *RelationValidation       `json:"relation_validation,omitempty"`  // Extracted relations mapped, flagged or dropped by validation
+	UnsupportedClaims    *UnsupportedClaimsReport  `json:"unsupported_claims,omitempty"`   // Claims without evidence and what was done about them
+	ModelUsage           map[string]ModelUsage     `json:"model_usage,omitempty"`          // Calls and tokens by ModelRoleGeneration and ModelRoleVerification, when fact verification called a model
+	StageModels          map[string]StageModel     `json:"stage_models,omitempty"`         // Model each stage that called a model selected last, and what selected it
+}
+
+// Sources of StageModel
+const (
+	ModelSourceRequest = "request" // AgenticRAGOptions.Model or ModelOverrides
+	ModelSourcePrompt  = "prompt"  // The model of the dotprompt's frontmatter
+	ModelSourceConfig  = "config"  // The configured model, load balancer or verification model
+)
+
+// StageModel is the model a stage selected
+type StageModel struct {
+	Model  string `json:"model"`
+	Source string `json:"source"` // ModelSourceRequest, ModelSourcePrompt or ModelSourceConfig
+}
+
+// ModelUsage is the model calls and tokens of one role of ProcessingMetadata.ModelUsage
+type ModelUsage struct {
+	Models     []string `json:"models,omitempty"` // Models that served the calls
+	ModelCalls int      `json:"model_calls"`
+	TokensUsed int      `json:"tokens_used"`
+}
+
+// UnsupportedClaimsReport is the claims fact verification found refuted or lacking
+// evidence under FactVerificationConfig.RequireEvidence, and the policy applied to
+// them. The claims' sentence offsets refer to the answer before any rewrite.
+type UnsupportedClaimsReport struct {
+	Policy    UnsupportedClaimPolicy `json:"policy"`
+	Claims    []Claim                `json:"claims"`
+	Rewritten bool                   `json:"rewritten,omitempty"` // The policy changed the answer
+}
+
+// DryRunPlan is the work a dry run plans after chunking, estimated without calling
+// any model. Estimates are upper bounds.
+type DryRunPlan struct {
+	Stages          []PlannedStage `json:"stages"`                   // Stages that would call a model, in order
+	ChunkCount      int            `json:"chunk_count"`              // Chunks the documents were cut into
+	EstimatedTokens int            `json:"estimated_tokens"`         // Input and output tokens across all stages
+	EstimatedCost   float64        `json:"estimated_cost,omitempty"` // From AgenticRAGConfig.Pricing, leaving out models without a price
+}
+
+// PlannedStage is the model usage planned for one stage of a dry run
+type PlannedStage struct {
+	Stage         string  `json:"stage"`
+	Model         string  `json:"model"`
+	ModelCalls    int     `json:"model_calls"`
+	InputTokens   int     `json:"input_tokens"`             // Estimated prompt tokens
+	OutputTokens  int     `json:"output_tokens"`            // Output limits of the calls
+	EstimatedCost float64 `json:"estimated_cost,omitempty"` // Zero when the model has no price
+	OverBudget    bool    `json:"over_budget,omitempty"`    // The token budget would run out by this stage
+}
+
+// StageTiming breaks down the work of one pipeline stage. Stage is one of the Stage
+// constants also reported to OnProgress and in stream events.
+type StageTiming struct {
+	Stage      string        `json:"stage"`
+	Duration   time.Duration `json:"duration"`    // Wall time from the start of the stage to the start of the next
+	ModelCalls int           `json:"model_calls"` // Successful model calls
+	TokensUsed int           `json:"tokens_used"`
+	Retries    int           `json:"retries,omitempty"`   // Model call attempts retried after transient failures
+	WorkTime   time.Duration `json:"work_time,omitempty"` // Summed time of the per-chunk work; above Duration by the speedup of running chunks in parallel
+}
+
+// RerankMetadata records the chunk order around the rerank stage for debugging
+type RerankMetadata struct {
+	Before []RankedChunk `json:"before"` // First-pass relevance order
+	After  []RankedChunk `json:"after"`  // Order after reranking
+}
+
+// RankedChunk is a chunk's position in a ranking
+type RankedChunk struct {
+	ChunkID string  `json:"chunk_id"`
+	Score   float64 `json:"score"`
+}
+
+// DocumentError records a document that couldn't be loaded
+type DocumentError struct {
+	DocumentID string `json:"document_id"`
+	Source     string `json:"source,omitempty"`
+	Error      string `json:"error"`
+}
+
+// ChunkError records a chunk whose processing failed without aborting the request
+type ChunkError struct {
+	ChunkID string `json:"chunk_id"`
+	Stage   string `json:"stage"`
+	Error   string `json:"error"`
+}
+
+// GroundingMetadata describes the web sources a grounded generation relied on
+type GroundingMetadata struct {
+	SearchQueries []string           `json:"search_queries,omitempty"`
+	Sources       []GroundingSource  `json:"sources,omitempty"`
+	Supports      []GroundingSupport `json:"supports,omitempty"`
+}
+
+// GroundingSource is a web page returned by Google Search
+type GroundingSource struct {
+	URI    string `json:"uri"`
+	Title  string `json:"title,omitempty"`
+	Domain string `json:"domain,omitempty"`
+}
+
+// GroundingSupport links a segment of the generated text to the sources backing it
+type GroundingSupport struct {
+	Text          string    `json:"text"`
+	SourceIndices []int     `json:"source_indices"`   // Indices into GroundingMetadata.Sources
+	Scores        []float64 `json:"scores,omitempty"` // Confidence per source index
+}
+
+// ModelDowngrade records calls served by a cheaper model after quota exhaustion
+type ModelDowngrade struct {
+	From string `json:"from"` // Model whose quota was exhausted
+	To   string `json:"to"`   // Model that served the call instead
+}
+
+// TruncatedChunk records a chunk that was dropped or compressed to fit the context window
+type TruncatedChunk struct {
+	ChunkID        string `json:"chunk_id"`
+	DocumentID     string `json:"document_id"`
+	Action         string `json:"action"`           // dropped or compressed
+	OriginalTokens int    `json:"original_tokens"`  // Estimated tokens before truncation
+	Tokens         int    `json:"tokens,omitempty"` // Estimated tokens of the summary for compressed chunks
+}
+
+// ModelEscalation records a switch to a larger-context model
+type ModelEscalation struct {
+	From   string `json:"from"`   // Model that couldn't hold the prompt, empty for the prompt's own model
+	To     string `json:"to"`     // Model the call escalated to
+	Reason string `json:"reason"` // Why the escalation happened (preflight_overflow or context_length_error)
 }
 
 // AgenticRAGConfig contains configuration for the agentic RAG system
 type AgenticRAGConfig struct {
-	Genkit           *genkit.Genkit         `json:"-"`          // GenKit instance (not serialized)
-	Model            ai.Model               `json:"-"`          // Model instance (not serialized)
-	ModelName        string                 `json:"model_name"` // Model name for serialization
-	Processing       ProcessingConfig       `json:"processing"`
-	KnowledgeGraph   KnowledgeGraphConfig   `json:"knowledge_graph"`
-	FactVerification FactVerificationConfig `json:"fact_verification"`
-	Prompts          PromptsConfig          `json:"prompts"`
+	Genkit            *genkit.Genkit          `json:"-"`                         // GenKit instance (not serialized)
+	Model             ai.Model                `json:"-"`                         // Model instance (not serialized)
+	ModelName         string                  `json:"model_name"`                // Model name for serialization
+	Embedder          ai.Embedder             `json:"-"`                         // Embedder instance (not serialized)
+	EmbedderName      string                  `json:"embedder_name,omitempty"`   // Embedder name, e.g. "googleai/text-embedding-004"
+	FallbackModels    []string                `json:"fallback_models,omitempty"` // Models tried in order when the primary fails with a provider error
+	Processing        ProcessingConfig        `json:"processing"`
+	KnowledgeGraph    KnowledgeGraphConfig    `json:"knowledge_graph"`
+	FactVerification  FactVerificationConfig  `json:"fact_verification"`
+	Prompts           PromptsConfig           `json:"prompts"`
+	Retry             RetryConfig             `json:"retry"`
+	LoadBalancing     LoadBalancingConfig     `json:"load_balancing"`
+	ContextEscalation ContextEscalationConfig `json:"context_escalation"`
+	Truncation        TruncationConfig        `json:"truncation"`
+	Downgrade         DowngradeConfig         `json:"downgrade"`
+	Grounding         GroundingConfig         `json:"grounding"`
+	URLLoader         URLLoaderConfig         `json:"url_loader"`
+	PDF               PDFConfig               `json:"pdf"`
+	Embedding         EmbeddingConfig         `json:"embedding"`
+	Sessions          SessionConfig           `json:"sessions"`
+	Cache             CacheConfig             `json:"cache"`
+	Batch             BatchConfig             `json:"batch"`
+	Jobs              JobConfig               `json:"jobs"`
+	Debug             DebugConfig             `json:"debug"`
+	Tracing           TracingConfig           `json:"tracing"`
+	Audit             AuditConfig             `json:"audit"`
+	Flows             FlowConfig              `json:"flows"`
+	Reranker          Reranker                `json:"-"`                 // Reranker for the rerank stage (default: built-in prompt reranker)
+	Retriever         Retriever               `json:"-"`                 // Index requests without documents retrieve their chunks from (default: none, documents are required)
+	Indexer           Indexer                 `json:"-"`                 // Index Ingest writes chunks to, also the Retriever when it is one and none is set
+	SessionStore      SessionStore            `json:"-"`                 // Conversation session storage (default: in-memory store)
+	JobStore          JobStore                `json:"-"`                 // Asynchronous job storage for JobManager (default: in-memory store)
+	ResponseCache     ResponseCache           `json:"-"`                 // Response cache, used even when Cache.Enabled is off (default: in-memory LRU when enabled)
+	EmbeddingCache    EmbeddingCache          `json:"-"`                 // Embedding cache, used even when Embedding.CacheSize is 0 (default: in-memory LRU of Embedding.CacheSize embeddings)
+	VerificationCache VerificationCache       `json:"-"`                 // Verdict cache, used even when FactVerification.Cache.Enabled is off (default: in-memory LRU when enabled)
+	Pricing           map[string]ModelPricing `json:"pricing,omitempty"` // Price per model name, for dry run cost estimates
+	TracerProvider    trace.TracerProvider    `json:"-"`                 // Tracer provider of the spans, enabling tracing (default: the global provider when Tracing.Enabled)
+	Metrics           Metrics                 `json:"-"`                 // Receiver of request, stage and model call measurements, such as a PrometheusMetrics (default: none)
 }
 
 // ModelConfig contains model configuration
@@ -133,25 +550,147 @@ type ModelConfig struct {
 
 // ProcessingConfig contains processing configuration
 type ProcessingConfig struct {
-	DefaultChunkSize      int  `json:"default_chunk_size"`
-	DefaultMaxChunks      int  `json:"default_max_chunks"`
-	DefaultRecursiveDepth int  `json:"default_recursive_depth"`
-	RespectSentences      bool `json:"respect_sentences"`
+	DefaultChunkSize      int                    `json:"default_chunk_size"`
+	DefaultMaxChunks      int                    `json:"default_max_chunks"`
+	DefaultRecursiveDepth int                    `json:"default_recursive_depth"`
+	MaxRecursiveDepth     int                    `json:"max_recursive_depth"` // Requests asking for more are clamped with a warning (0 for no limit)
+	RespectSentences      bool                   `json:"respect_sentences"`
+	ChunkStrategy         ChunkStrategy          `json:"chunk_strategy"`
+	ChunkUnit             ChunkUnit              `json:"chunk_unit"` // Unit of DefaultChunkSize
+	Semantic              SemanticChunkingConfig `json:"semantic"`
+	RelevanceMode         RelevanceMode          `json:"relevance_mode"`
+	HybridTopK            int                    `json:"hybrid_top_k"`           // Chunks kept by the embedding pre-filter in hybrid mode
+	RerankTopN            int                    `json:"rerank_top_n"`           // Chunks reranked when the request enables reranking
+	RetrievalTopK         int                    `json:"retrieval_top_k"`        // Chunks pulled from the retriever for requests without documents
+	DiversityLambda       float64                `json:"diversity_lambda"`       // Weight of relevance against diversity when the request enables diversification
+	Termination           TerminationConfig      `json:"termination"`            // Early stopping of recursive refinement
+	Concurrency           int                    `json:"concurrency"`            // Worker pool size for per-chunk model calls (0 runs sequentially)
+	FailFast              bool                   `json:"fail_fast"`              // Abort on the first failed chunk instead of keeping it unprocessed
+	MaxChunkFailureRate   float64                `json:"max_chunk_failure_rate"` // Fraction of a stage's chunks that may fail before the request does
+}
+
+// ChunkStrategy selects how documents are split into chunks
+type ChunkStrategy string
+
+const (
+	ChunkStrategySentence ChunkStrategy = "sentence" // Fixed-size chunks on sentence boundaries
+	ChunkStrategyMarkdown ChunkStrategy = "markdown" // Chunks on heading boundaries, keeping code blocks and tables whole
+	ChunkStrategySemantic ChunkStrategy = "semantic" // Variable-size chunks split at topic shifts detected with embeddings
+)
+
+// RelevanceMode selects how chunks are scored against the query
+type RelevanceMode string
+
+const (
+	RelevanceModeLLM       RelevanceMode = "llm"       // The model scores every chunk
+	RelevanceModeEmbedding RelevanceMode = "embedding" // Cosine similarity of query and chunk embeddings
+	RelevanceModeHybrid    RelevanceMode = "hybrid"    // Embedding pre-filter to the top chunks, then model scoring
+)
+
+// SemanticChunkingConfig contains configuration for the semantic chunk strategy. Sizes
+// are in the configured ChunkUnit.
+type SemanticChunkingConfig struct {
+	BreakpointPercentile float64 `json:"breakpoint_percentile"` // Sentence distances above this percentile start a new chunk
+	MinChunkSize         int     `json:"min_chunk_size"`        // Chunks aren't split at a topic shift below this size
+	MaxChunkSize         int     `json:"max_chunk_size"`        // Chunks are always split above this size (default: DefaultChunkSize)
+}
+
+// TerminationConfig contains the criteria that stop recursive refinement before the
+// requested depth. Each level is assessed by the model when any criterion is set.
+type TerminationConfig struct {
+	MinInformationGain  float64 `json:"min_information_gain"`    // Stop when a level's reported information gain falls below this (0 disables)
+	StopOnNoNewEntities bool    `json:"stop_on_no_new_entities"` // Stop when a level surfaces no new entities or relations
+	TargetConfidence    float64 `json:"target_confidence"`       // Stop once the self-rated confidence in answering reaches this (0 disables)
+}
+
+// EmbeddingConfig contains configuration for embedding calls
+type EmbeddingConfig struct {
+	BatchSize int `json:"batch_size"` // Texts sent per embedding request (0 sends all at once)
+	CacheSize int `json:"cache_size"` // Embeddings kept across requests by the default cache, least recently used evicted first (0 disables caching)
+}
+
+// CacheConfig contains configuration for the default response cache
+type CacheConfig struct {
+	Enabled    bool          `json:"enabled"`     // Whether identical requests are served from the cache
+	TTL        time.Duration `json:"ttl"`         // How long a response is served, 0 until evicted
+	MaxEntries int           `json:"max_entries"` // Responses kept before the least recently used is evicted, 0 for no limit
+}
+
+// BatchConfig contains configuration for ProcessBatch
+type BatchConfig struct {
+	Concurrency int `json:"concurrency"` // Queries answered at once (0 runs them sequentially)
+}
+
+// JobConfig contains configuration for JobManager
+type JobConfig struct {
+	Workers   int           `json:"workers"`    // Jobs processed at once (0 runs one at a time)
+	QueueSize int           `json:"queue_size"` // Jobs waiting for a worker before Submit fails with ErrJobQueueFull
+	ResultTTL time.Duration `json:"result_ttl"` // How long finished jobs are kept in the default store, 0 keeps them forever
+}
+
+// SessionConfig contains configuration for multi-turn conversations
+type SessionConfig struct {
+	TTL      time.Duration `json:"ttl"`       // How long a conversation lives after its last turn in the default store, 0 keeps it forever
+	MaxTurns int           `json:"max_turns"` // Most recent turns kept per conversation (0 keeps all)
 }
 
+// ChunkUnit selects how chunk sizes are measured
+type ChunkUnit string
+
+const (
+	ChunkUnitChars  ChunkUnit = "chars"  // Bytes of text
+	ChunkUnitTokens ChunkUnit = "tokens" // Estimated model tokens, the unit used when fitting chunks into the context
+)
+
 // KnowledgeGraphConfig contains knowledge graph configuration
 type KnowledgeGraphConfig struct {
-	Enabled                bool     `json:"enabled"`
-	EntityTypes            []string `json:"entity_types"`
-	RelationTypes          []string `json:"relation_types"`
-	MinConfidenceThreshold float64  `json:"min_confidence_threshold"`
+	Enabled                bool                    `json:"enabled"`
+	EntityTypes            []string                `json:"entity_types"`
+	RelationTypes          []string                `json:"relation_types"`
+	MinConfidenceThreshold float64                 `json:"min_confidence_threshold"`
+	Store                  GraphStore              `json:"-"`                           // Persists the graph across requests, skipping chunks already extracted (default: none, the graph lives in the response)
+	Aliases                map[string]string       `json:"aliases,omitempty"`           // Alternative entity names to canonical names, such as "K8s" to "Kubernetes", matched ignoring case and punctuation
+	ModelAssistedMerge     bool                    `json:"model_assisted_merge"`        // Ask the model whether entities with similar names are the same, one extra call per extraction
+	MergeSimilarity        float64                 `json:"merge_similarity"`            // Name similarity from 0 to 1 at which entities become merge candidates for the model
+	MaxEntities            int                     `json:"max_entities"`                // Entities kept in a response's graph, the most confident and connected (0 for no limit)
+	MaxRelations           int                     `json:"max_relations"`               // Relations kept in a response's graph, the most confident (0 for no limit)
+	RelationSynonyms       map[string]string       `json:"relation_synonyms,omitempty"` // Predicates to the relation types they mean, such as "employed by" to "WORKS_FOR", matched ignoring case and punctuation
+	StrictRelationTypes    bool                    `json:"strict_relation_types"`       // Drop relations whose predicate maps onto no relation type, instead of flagging them
+	DanglingRelations      DanglingRelationPolicy  `json:"dangling_relations"`          // What to do with relations naming an entity that wasn't extracted: create (default) or drop
+	TypeParents            map[string]string       `json:"type_parents,omitempty"`      // Entity types to their parent type, such as FRAMEWORK to TECHNOLOGY; subtypes of extracted types are extracted too and match their ancestors in filters
+	UnknownEntityTypes     UnknownEntityTypePolicy `json:"unknown_entity_types"`        // What to do with entity types the model invents: keep (default), ancestor or other
+	EnableCommunities      bool                    `json:"enable_communities"`          // Group the graph's entities into communities with a model-written summary each, one call per new community
 }
 
 // FactVerificationConfig contains fact verification configuration
 type FactVerificationConfig struct {
-	Enabled            bool    `json:"enabled"`
-	RequireEvidence    bool    `json:"require_evidence"`
-	MinConfidenceScore float64 `json:"min_confidence_score"`
+	Enabled                     bool                   `json:"enabled"`
+	RequireEvidence             bool                   `json:"require_evidence"`
+	MinConfidenceScore          float64                `json:"min_confidence_score"`            // Supported claims below this confidence are only partially supported
+	PartialSupportMinConfidence float64                `json:"partial_support_min_confidence"`  // Partially supported claims below this confidence don't have enough evidence
+	UnsupportedClaims           UnsupportedClaimPolicy `json:"unsupported_claims,omitempty"`    // What to do with answer content making unsupported claims under RequireEvidence (default: keep)
+	CheckNumbers                bool                   `json:"check_numbers"`                   // Refute supported claims whose numbers and units the evidence contradicts
+	NumericTolerance            float64                `json:"numeric_tolerance"`               // Relative difference up to which numbers agree
+	VerificationModel           string                 `json:"verification_model,omitempty"`    // Model fact verification runs on, falling back to the main model when unavailable (default: the main model)
+	VerificationProvider        string                 `json:"verification_provider,omitempty"` // Provider of VerificationModel when its name isn't provider-qualified, e.g. googleai
+	SelfConsistency             bool                   `json:"self_consistency"`                // Re-verify claims with borderline confidence and settle on the majority verdict
+	SelfConsistencySamples      int                    `json:"self_consistency_samples"`        // Re-verifications of each borderline claim (default: 3)
+	SelfConsistencyBand         float64                `json:"self_consistency_band"`           // Claims within this distance of MinConfidenceScore are borderline (default: 0.15)
+	Cache                       CacheConfig            `json:"cache"`                           // Verdicts reused across requests for the same claim and chunks
+}
+
+// FactVerificationOverrides replaces fact verification settings for one request. Unset
+// fields keep the configured value.
+type FactVerificationOverrides struct {
+	RequireEvidence             *bool                   `json:"require_evidence,omitempty"`
+	MinConfidenceScore          *float64                `json:"min_confidence_score,omitempty"`
+	PartialSupportMinConfidence *float64                `json:"partial_support_min_confidence,omitempty"`
+	UnsupportedClaims           *UnsupportedClaimPolicy `json:"unsupported_claims,omitempty"`
+	CheckNumbers                *bool                   `json:"check_numbers,omitempty"`
+	NumericTolerance            *float64                `json:"numeric_tolerance,omitempty"`
+	SelfConsistency             *bool                   `json:"self_consistency,omitempty"`
+	SelfConsistencySamples      *int                    `json:"self_consistency_samples,omitempty"`
+	SelfConsistencyBand         *float64                `json:"self_consistency_band,omitempty"`
 }
 
 // PromptsConfig contains prompt configuration
@@ -161,8 +700,118 @@ type PromptsConfig struct {
 	ResponseGenerationPrompt  string            `json:"response_generation_prompt"`  // Name of response generation prompt
 	KnowledgeExtractionPrompt string            `json:"knowledge_extraction_prompt"` // Name of knowledge extraction prompt
 	FactVerificationPrompt    string            `json:"fact_verification_prompt"`    // Name of fact verification prompt
+	RerankPrompt              string            `json:"rerank_prompt"`               // Name of rerank prompt, empty for the built-in prompt
+	QueryRewritePrompt        string            `json:"query_rewrite_prompt"`        // Name of query rewrite prompt, empty for the built-in prompt
+	ExtractiveAnswerPrompt    string            `json:"extractive_answer_prompt"`    // Name of extractive answer prompt, empty for the built-in prompt
+	RecursionAssessmentPrompt string            `json:"recursion_assessment_prompt"` // Name of the prompt judging each refinement level, empty for the built-in prompt
+	AnswerAssessmentPrompt    string            `json:"answer_assessment_prompt"`    // Name of the prompt judging answer confidence, empty for the built-in prompt
 	Variants                  map[string]string `json:"variants,omitempty"`          // Prompt variants for A/B testing
-	CustomHelpers             bool              `json:"custom_helpers"`              // Whether to register custom helpers
+	Inline                    map[string]string `json:"inline,omitempty"`            // Dotprompt sources by prompt name, "name.variant" for variants; take precedence over the directory and embedded prompts
+	Helpers                   map[string]any    `json:"-"`                           // Template helpers by name, see AgenticRAGProcessor.RegisterPromptHelper
+	CustomHelpers             bool              `json:"custom_helpers"`              // Register the standard helpers, see AgenticRAGProcessor.RegisterPromptHelper
+	RequireDirectory          bool              `json:"require_directory"`           // Fail when the default prompts aren't all in Directory instead of using the embedded ones
+	Watch                     bool              `json:"watch"`                       // Watch Directory and reload changed prompt files, see AgenticRAGProcessor.ReloadPrompts
+	WatchDebounce             time.Duration     `json:"watch_debounce"`              // How long Watch waits for writes to settle before reloading, zero for the default of 100ms
+
+	// Weighted variant experiments by prompt key, e.g. response_generation; the configured
+	// variant doesn't apply while one runs
+	Experiments map[string]PromptExperiment `json:"experiments,omitempty"`
+}
+
+// RetryConfig contains retry configuration for model calls
+type RetryConfig struct {
+	MaxAttempts        int           `json:"max_attempts"`         // Total attempts including the first call
+	InitialBackoff     time.Duration `json:"initial_backoff"`      // Delay before the first retry
+	MaxBackoff         time.Duration `json:"max_backoff"`          // Upper bound for the delay between attempts
+	Multiplier         float64       `json:"multiplier"`           // Backoff growth factor per attempt
+	MinAttemptDuration time.Duration `json:"min_attempt_duration"` // Minimum time a retry needs to complete; the first attempt always runs
+
+	OnAttempt func(RetryAttempt) `json:"-"` // Per-attempt hook, e.g. for metrics (not serialized)
+}
+
+// LoadBalancingStrategy selects how model calls are spread across model instances
+type LoadBalancingStrategy string
+
+const (
+	LoadBalanceWeightedRoundRobin LoadBalancingStrategy = "weighted_round_robin" // Interleave calls in proportion to instance weights
+	LoadBalanceLeastInflight      LoadBalancingStrategy = "least_inflight"       // Prefer the instance with the fewest running calls per weight
+)
+
+// ModelInstance is one of several interchangeable model deployments, e.g. the same
+// model served from separate projects with separate quotas
+type ModelInstance struct {
+	Label     string   `json:"label"`      // Label reported in processing metadata for billing reconciliation
+	ModelName string   `json:"model_name"` // Model name to call
+	Model     ai.Model `json:"-"`          // Model instance, takes precedence over ModelName (not serialized)
+	Weight    int      `json:"weight"`     // Relative share of traffic (default: 1)
+}
+
+// LoadBalancingConfig contains configuration for spreading calls across model instances
+type LoadBalancingConfig struct {
+	Instances         []ModelInstance       `json:"instances,omitempty"` // When set, replaces the primary model for every call
+	Strategy          LoadBalancingStrategy `json:"strategy"`            // Selection strategy (default: weighted_round_robin)
+	UnhealthyCooldown time.Duration         `json:"unhealthy_cooldown"`  // How long a failing instance stays out of rotation (default: 30s)
+}
+
+// ContextEscalationConfig contains configuration for escalating to larger-context
+// models when a prompt doesn't fit the current model's window
+type ContextEscalationConfig struct {
+	Enabled        bool           `json:"enabled"`                   // Opt-out switch for escalation
+	Models         []string       `json:"models,omitempty"`          // Larger-context models tried in order
+	MaxEscalations int            `json:"max_escalations"`           // Hard cap on escalations per model call
+	ContextWindows map[string]int `json:"context_windows,omitempty"` // Context window sizes in tokens, overriding built-in defaults
+}
+
+// TruncationStrategy selects how context is shrunk when the response prompt is too large
+type TruncationStrategy string
+
+const (
+	TruncationNone                TruncationStrategy = "none"                  // Send the prompt as is
+	TruncationDropLowestRelevance TruncationStrategy = "drop_lowest_relevance" // Drop the least relevant chunks
+	TruncationHeadTail            TruncationStrategy = "head_tail"             // Keep the first and last chunks, dropping from the middle
+	TruncationSummary             TruncationStrategy = "summary"               // Summarize the least relevant chunks, dropping only if still too large
+)
+
+// TruncationConfig contains configuration for shrinking context that doesn't fit the model
+type TruncationConfig struct {
+	Strategy         TruncationStrategy `json:"strategy"`
+	MaxContextTokens int                `json:"max_context_tokens,omitempty"` // Prompt token limit, defaults to the model's context window
+	OutputReserve    int                `json:"output_reserve"`               // Tokens kept free for the generated answer
+	SummaryModel     string             `json:"summary_model,omitempty"`      // Cheaper model used by the summary strategy
+}
+
+// DowngradeConfig contains configuration for switching to cheaper models when a
+// model keeps exhausting its quota
+type DowngradeConfig struct {
+	Models    map[string]string `json:"models,omitempty"` // Model to cheaper fallback model
+	Threshold int               `json:"threshold"`        // Consecutive quota-exhausted calls before downgrading
+	Cooldown  time.Duration     `json:"cooldown"`         // How long a downgrade stays in effect
+}
+
+// GroundingConfig contains configuration for grounding Gemini generations with Google Search
+type GroundingConfig struct {
+	Enabled          bool `json:"enabled"`           // Ground answer generation, overridable per request
+	FactVerification bool `json:"fact_verification"` // Ground fact verification and attach cited URLs to claims
+}
+
+// URLLoaderConfig contains configuration for fetching documents whose source is an http(s) URL
+type URLLoaderConfig struct {
+	Enabled   bool          `json:"enabled"`
+	Timeout   time.Duration `json:"timeout"`    // Per-document fetch timeout
+	UserAgent string        `json:"user_agent"` // User-Agent header sent with requests
+	MaxBytes  int64         `json:"max_bytes"`  // Maximum response body size
+	CacheTTL  time.Duration `json:"cache_ttl"`  // How long fetched content is reused across requests, 0 disables caching
+
+	HTTPClient *http.Client `json:"-"` // Client used for fetching (not serialized)
+}
+
+// PDFConfig contains configuration for ingesting PDF documents
+type PDFConfig struct {
+	Enabled        bool   `json:"enabled"`
+	MaxBytes       int64  `json:"max_bytes"`        // Maximum PDF size
+	AllowFilePaths bool   `json:"allow_file_paths"` // Read Document.Path from the local filesystem, only enable for trusted requests
+	OCRFallback    bool   `json:"ocr_fallback"`     // Transcribe scanned pages from their images with the multimodal model
+	OCRModel       string `json:"ocr_model"`        // Model used for transcription (default: the main model)
 }
 
 // Tool request/response types
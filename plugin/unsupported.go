@@ -0,0 +1,151 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/firebase/genkit/go/ai"
+)
+
+// UnsupportedClaimPolicy selects what happens to answer content making claims fact
+// verification found no evidence for, when FactVerificationConfig.RequireEvidence is set
+type UnsupportedClaimPolicy string
+
+const (
+	UnsupportedClaimPolicyKeep   UnsupportedClaimPolicy = "keep"   // Only report them
+	UnsupportedClaimPolicyFlag   UnsupportedClaimPolicy = "flag"   // Mark the sentences making them with unsupportedClaimMarker
+	UnsupportedClaimPolicyRemove UnsupportedClaimPolicy = "remove" // Regenerate the answer without them
+	UnsupportedClaimPolicyFail   UnsupportedClaimPolicy = "fail"   // Fail the request with an UnsupportedClaimsError
+)
+
+// unsupportedClaimMarker is appended to sentences under UnsupportedClaimPolicyFlag
+const unsupportedClaimMarker = " [unsupported]"
+
+// unsupportedClaims returns the claims refuted or lacking evidence, in answer order
+func unsupportedClaims(verification *FactVerification) []Claim {
+	if verification == nil {
+		return nil
+	}
+	var claims []Claim
+	for _, claim := range verification.Claims {
+		if claim.Verdict == VerdictRefuted || claim.Verdict == VerdictNotEnoughEvidence {
+			claims = append(claims, claim)
+		}
+	}
+	return claims
+}
+
+// enforceEvidence applies the policy to the answer content making unsupported claims.
+// Returns the answer after the policy and a report of what was done, nil when every
+// claim is supported. Under UnsupportedClaimPolicyFail the error is an
+// UnsupportedClaimsError. A rewrite the token budget doesn't allow keeps the answer as
+// is with a warning.
+func (p *AgenticRAGProcessor) enforceEvidence(ctx context.Context, answer string, verification *FactVerification, policy UnsupportedClaimPolicy, lang string) (string, *UnsupportedClaimsReport, error) {
+	claims := unsupportedClaims(verification)
+	if len(claims) == 0 {
+		return answer, nil, nil
+	}
+	if policy == "" {
+		policy = UnsupportedClaimPolicyKeep
+	}
+	report := &UnsupportedClaimsReport{Policy: policy, Claims: claims}
+
+	switch policy {
+	case UnsupportedClaimPolicyFail:
+		return answer, report, &UnsupportedClaimsError{Claims: claims}
+	case UnsupportedClaimPolicyFlag:
+		flagged := flagUnsupportedSentences(answer, answerSentences(answer, lang), claims)
+		report.Rewritten = flagged != answer
+		return flagged, report, nil
+	case UnsupportedClaimPolicyRemove:
+		rewritten, err := p.removeUnsupportedClaims(ctx, answer, claims, lang)
+		switch {
+		case errors.Is(err, ErrBudgetExceeded):
+			requestStateFrom(ctx).recordWarning("unsupported claims kept in the answer: token budget exhausted")
+			return answer, report, nil
+		case err != nil:
+			return answer, report, err
+		}
+		report.Rewritten = rewritten != answer
+		return rewritten, report, nil
+	}
+	return answer, report, nil
+}
+
+// answerSentences returns the non-blank sentences of the answer, as groundedness
+// checking numbers them
+func answerSentences(answer, lang string) []prosePart {
+	var sentences []prosePart
+	for _, part := range segmentSentences(answer, lang) {
+		if strings.TrimSpace(part.text) != "" {
+			sentences = append(sentences, part)
+		}
+	}
+	return sentences
+}
+
+// flagUnsupportedSentences appends unsupportedClaimMarker to each sentence making one
+// of the claims, found by the claims' sentence offsets. Claims without offsets can't
+// be placed and are left out.
+func flagUnsupportedSentences(answer string, sentences []prosePart, claims []Claim) string {
+	flagged := make([]bool, len(sentences))
+	for _, claim := range claims {
+		if claim.SentenceEnd <= claim.SentenceStart {
+			continue
+		}
+		for i, sentence := range sentences {
+			if claim.SentenceStart >= sentence.start && claim.SentenceStart < sentence.end {
+				flagged[i] = true
+				break
+			}
+		}
+	}
+
+	var result strings.Builder
+	last := 0
+	for i, sentence := range sentences {
+		if !flagged[i] {
+			continue
+		}
+		// The marker goes before the whitespace ending the sentence
+		body := strings.TrimRightFunc(sentence.text, unicode.IsSpace)
+		result.WriteString(answer[last : sentence.start+len(body)])
+		result.WriteString(unsupportedClaimMarker)
+		last = sentence.start + len(body)
+	}
+	result.WriteString(answer[last:])
+	return result.String()
+}
+
+// removeUnsupportedClaims asks the model for the answer rewritten without the claims,
+// keeping everything else and its citation markers
+func (p *AgenticRAGProcessor) removeUnsupportedClaims(ctx context.Context, answer string, claims []Claim, lang string) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString(`Rewrite the answer below so it no longer states any of the listed claims, which the sources don't support. Remove or reword only the content making those claims; keep everything else, including its wording, formatting and citation markers such as [1]. Don't add new information. Return only the rewritten answer.
+`)
+	if lang != "" {
+		fmt.Fprintf(&prompt, "Keep the answer in %s.\n", languageName(lang))
+	}
+	prompt.WriteString("\nUnsupported claims:\n")
+	for _, claim := range claims {
+		fmt.Fprintf(&prompt, "- %s\n", claim.Text)
+	}
+	prompt.WriteString("\nAnswer:\n")
+	prompt.WriteString(answer)
+
+	resp, err := p.generate(ctx, prompt.String(), &ai.GenerationCommonConfig{
+		Temperature:     0.1,
+		MaxOutputTokens: max(estimateTokens(answer)*2, 256),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to remove unsupported claims: %w", err)
+	}
+	rewritten := strings.TrimSpace(resp.Text())
+	if rewritten == "" {
+		return "", errors.New("failed to remove unsupported claims: empty answer")
+	}
+	return rewritten, nil
+}
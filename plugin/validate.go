@@ -0,0 +1,177 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// Accepted range of AgenticRAGOptions.Temperature
+const (
+	minTemperature = 0
+	maxTemperature = 2
+)
+
+// Validate checks the request on its own, without a processor. Every problem is
+// reported as a ValidationError naming the field, joined into a single error.
+func (r AgenticRAGRequest) Validate() error {
+	return r.validate(true)
+}
+
+// validate checks the request, requiring documents or a conversation if
+// requireDocuments is set
+func (r AgenticRAGRequest) validate(requireDocuments bool) error {
+	var errs []error
+	if strings.TrimSpace(r.Query) == "" {
+		errs = append(errs, fieldError("query", "is required"))
+	}
+	if requireDocuments && len(r.Docs) == 0 && len(r.Documents) == 0 && r.ConversationID == "" {
+		errs = append(errs, fieldError("docs", "at least one document is required unless conversation_id continues a conversation or a retriever is configured"))
+	}
+
+	seen := make(map[string]bool, len(r.Docs))
+	for i, doc := range r.Docs {
+		field := fmt.Sprintf("docs[%d]", i)
+		if doc.Content == "" && len(doc.Data) == 0 && doc.Path == "" && len(doc.Pages) == 0 && !isHTTPURL(doc.Source) {
+			errs = append(errs, fieldError(field, "needs content, data, path, pages or an http(s) source"))
+		}
+		if doc.ID != "" {
+			if seen[doc.ID] {
+				errs = append(errs, fieldError(field+".id", "duplicate document ID %q", doc.ID))
+			}
+			seen[doc.ID] = true
+		}
+	}
+	for i, document := range r.Documents {
+		if strings.TrimSpace(document) == "" {
+			errs = append(errs, fieldError(fmt.Sprintf("documents[%d]", i), "must not be empty"))
+		}
+	}
+	for i, turn := range r.History {
+		if turn.Role != TurnRoleUser && turn.Role != TurnRoleAssistant {
+			errs = append(errs, fieldError(fmt.Sprintf("history[%d].role", i), "must be %q or %q, got %q", TurnRoleUser, TurnRoleAssistant, turn.Role))
+		}
+	}
+
+	if err := r.Options.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the options on their own. Zero values select the defaults and are
+// always accepted.
+func (o AgenticRAGOptions) Validate() error {
+	var errs []error
+	if o.MaxChunks < 0 {
+		errs = append(errs, fieldError("options.max_chunks", "must not be negative (0 for the default), got %d", o.MaxChunks))
+	}
+	if o.RecursiveDepth < 0 {
+		errs = append(errs, fieldError("options.recursive_depth", "must not be negative (0 for the default), got %d", o.RecursiveDepth))
+	}
+	if o.Temperature < minTemperature || o.Temperature > maxTemperature {
+		errs = append(errs, fieldError("options.temperature", "must be between %d and %d, got %v", minTemperature, maxTemperature, o.Temperature))
+	}
+	if o.MinAnswerConfidence < 0 || o.MinAnswerConfidence > 1 {
+		errs = append(errs, fieldError("options.min_answer_confidence", "must be between 0 and 1, got %v", o.MinAnswerConfidence))
+	}
+	if o.MaxTotalTokens < 0 {
+		errs = append(errs, fieldError("options.max_total_tokens", "must not be negative (0 for no budget), got %d", o.MaxTotalTokens))
+	}
+	if o.RerankTopN < 0 {
+		errs = append(errs, fieldError("options.rerank_top_n", "must not be negative (0 for the default), got %d", o.RerankTopN))
+	}
+	if o.DiversityLambda < 0 || o.DiversityLambda > 1 {
+		errs = append(errs, fieldError("options.diversity_lambda", "must be between 0 and 1 (0 for the default), got %v", o.DiversityLambda))
+	}
+	if o.GraphExpansionHops < 0 || o.GraphExpansionHops > maxGraphExpansionHops {
+		errs = append(errs, fieldError("options.graph_expansion_hops", "must be between 0 and %d (0 for the default), got %d", maxGraphExpansionHops, o.GraphExpansionHops))
+	}
+	if o.MaxGraphExpansionChunks < 0 {
+		errs = append(errs, fieldError("options.max_graph_expansion_chunks", "must not be negative (0 for the default), got %d", o.MaxGraphExpansionChunks))
+	}
+	for i, entityType := range o.EntityTypes {
+		if strings.TrimSpace(entityType) == "" {
+			errs = append(errs, fieldError(fmt.Sprintf("options.entity_types[%d]", i), "must not be empty"))
+		}
+	}
+	if o.MaxCommunities < 0 {
+		errs = append(errs, fieldError("options.max_communities", "must not be negative (0 for the default), got %d", o.MaxCommunities))
+	}
+
+	switch o.ResponseFormat {
+	case "", ResponseFormatMarkdown, ResponseFormatPlain:
+	case ResponseFormatJSON:
+		if len(o.ResponseSchema) == 0 {
+			errs = append(errs, fieldError("options.response_schema", "is required for the %q response format", ResponseFormatJSON))
+		}
+	default:
+		errs = append(errs, fieldError("options.response_format", "must be one of %q, %q or %q, got %q",
+			ResponseFormatMarkdown, ResponseFormatPlain, ResponseFormatJSON, o.ResponseFormat))
+	}
+	switch o.Style {
+	case "", AnswerStyleConcise, AnswerStyleDetailed, AnswerStyleBulleted:
+	default:
+		errs = append(errs, fieldError("options.style", "must be one of %q, %q or %q, got %q",
+			AnswerStyleConcise, AnswerStyleDetailed, AnswerStyleBulleted, o.Style))
+	}
+	if o.TargetLength < 0 {
+		errs = append(errs, fieldError("options.target_length", "must not be negative, got %d", o.TargetLength))
+	}
+	if o.MaxAnswerTokens < 0 {
+		errs = append(errs, fieldError("options.max_answer_tokens", "must not be negative, got %d", o.MaxAnswerTokens))
+	}
+	if o.DocumentFilter != nil {
+		errs = append(errs, o.DocumentFilter.validate("options.document_filter")...)
+	}
+	switch o.UngroundedPolicy {
+	case "", UngroundedPolicyKeep:
+	case UngroundedPolicyRemove, UngroundedPolicyHedge:
+		if !o.CheckGroundedness {
+			errs = append(errs, fieldError("options.ungrounded_policy", "%q requires check_groundedness", o.UngroundedPolicy))
+		}
+	default:
+		errs = append(errs, fieldError("options.ungrounded_policy", "must be one of %q, %q or %q, got %q",
+			UngroundedPolicyKeep, UngroundedPolicyRemove, UngroundedPolicyHedge, o.UngroundedPolicy))
+	}
+	if o.ExternalVerification && !o.EnableFactVerification {
+		errs = append(errs, fieldError("options.external_verification", "requires enable_fact_verification"))
+	}
+	switch o.AnswerMode {
+	case "", AnswerModeAbstractive:
+	case AnswerModeExtractive:
+		if o.ResponseFormat == ResponseFormatJSON {
+			errs = append(errs, fieldError("options.answer_mode", "%q can't be combined with the %q response format", AnswerModeExtractive, ResponseFormatJSON))
+		}
+	default:
+		errs = append(errs, fieldError("options.answer_mode", "must be %q or %q, got %q", AnswerModeAbstractive, AnswerModeExtractive, o.AnswerMode))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateRequest checks the request as Process would before running it, including
+// the models and prompt variants it selects, so front-ends can reject bad requests
+// before enqueueing work
+func (p *AgenticRAGProcessor) ValidateRequest(request AgenticRAGRequest) error {
+	return errors.Join(
+		request.validate(p.retriever() == nil),
+		p.validateModelOverrides(request.Options),
+		p.validatePromptVariants(request.Options.PromptVariants),
+		p.validateFactVerificationOverrides(request.Options.FactVerification),
+	)
+}
+
+// clampOptions brings soft limits of the options within the configuration, returning
+// a warning for each adjustment
+func (p *AgenticRAGProcessor) clampOptions(ctx context.Context, options *AgenticRAGOptions) []string {
+	var warnings []string
+	if limit := p.config.Processing.MaxRecursiveDepth; limit > 0 && options.RecursiveDepth > limit {
+		warning := fmt.Sprintf("options.recursive_depth %d exceeds the maximum of %d and was clamped", options.RecursiveDepth, limit)
+		slog.WarnContext(ctx, "clamping request option", "field", "options.recursive_depth", "requested", options.RecursiveDepth, "max", limit)
+		options.RecursiveDepth = limit
+		warnings = append(warnings, warning)
+	}
+	return warnings
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/firebase/genkit/go/ai"
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// promptVariantDefault is recorded for prompts run without a variant
+const promptVariantDefault = "default"
+
+// promptNames maps the keys of PromptsConfig.Variants to the configured prompt names
+func (c PromptsConfig) promptNames() map[string]string {
+	return map[string]string{
+		"relevance_scoring":    c.RelevanceScoringPrompt,
+		"response_generation":  c.ResponseGenerationPrompt,
+		"knowledge_extraction": c.KnowledgeExtractionPrompt,
+		"fact_verification":    c.FactVerificationPrompt,
+		"rerank":               c.RerankPrompt,
+		"query_rewrite":        c.QueryRewritePrompt,
+		"extractive_answer":    c.ExtractiveAnswerPrompt,
+		"recursion_assessment": c.RecursionAssessmentPrompt,
+		"answer_assessment":    c.AnswerAssessmentPrompt,
+	}
+}
+
+// lookupPrompt returns the dotprompt named name in the variant the request or the
+// configuration selects for key, or nil if it isn't loaded. A version localized to the
+// response language, named <prompt>.<language>.<variant> or <prompt>.<language> without
+// a variant, is preferred when loaded. The variant and the prompt used are recorded in
+// the request state.
+func (p *AgenticRAGProcessor) lookupPrompt(ctx context.Context, key, name string) *ai.Prompt {
+	if name == "" {
+		return nil
+	}
+
+	state := requestStateFrom(ctx)
+	variant, exists := state.promptVariant(key)
+	if !exists {
+		variant, exists = p.config.Prompts.Variants[key]
+	}
+	suffix := ""
+	if exists && variant != "" {
+		suffix = "." + variant
+	}
+
+	var prompt *ai.Prompt
+	for _, lang := range promptLanguages(state.responseLanguage()) {
+		if prompt = p.resolvePrompt(ctx, name+"."+lang+suffix); prompt != nil {
+			name += "." + lang
+			break
+		}
+	}
+	name += suffix
+	if prompt == nil {
+		prompt = p.resolvePrompt(ctx, name)
+	}
+	if prompt == nil && variant == "" {
+		prompt = p.embedded[key]
+	}
+	if prompt != nil {
+		if variant == "" {
+			variant = promptVariantDefault
+		}
+		state.recordPromptVariant(key, variant)
+		state.recordPromptName(key, name)
+	}
+	return prompt
+}
+
+// resolvePrompt returns the loaded dotprompt of the name, including any language and
+// variant, or nil. Variants made up by partial overrides take precedence, then inline
+// prompts, then reloaded ones, then genkit's.
+func (p *AgenticRAGProcessor) resolvePrompt(ctx context.Context, name string) *ai.Prompt {
+	if prompt := p.partialVariants[name]; prompt != nil {
+		return prompt
+	}
+	if prompt := p.inline[name]; prompt != nil {
+		return prompt
+	}
+	if prompt := p.reloadedPrompt(ctx, name); prompt != nil {
+		return prompt
+	}
+	return genkit.LookupPrompt(p.config.Genkit, name)
+}
+
+// validatePromptVariants checks each variant the request selects is loaded, listing
+// the variants given inline or found in the prompt directory otherwise
+func (p *AgenticRAGProcessor) validatePromptVariants(variants map[string]string) error {
+	if len(variants) == 0 {
+		return nil
+	}
+
+	names := p.config.Prompts.promptNames()
+	for _, key := range sortedKeys(variants) {
+		field := "options.prompt_variants." + key
+		name, known := names[key]
+		if !known {
+			return fieldError(field, "unknown prompt, must be one of %s", strings.Join(sortedKeys(names), ", "))
+		}
+		variant := variants[key]
+		if variant == "" {
+			continue // Selects the default prompt
+		}
+		if _, inline := p.config.Prompts.Inline[name+"."+variant]; name != "" && inline {
+			continue
+		}
+		if name != "" && p.config.Genkit != nil && genkit.LookupPrompt(p.config.Genkit, name+"."+variant) != nil {
+			continue
+		}
+		if name != "" && p.config.Prompts.hasPartialVariant(key, name, variant) {
+			continue
+		}
+
+		available := p.availableVariants(name)
+		if len(available) == 0 {
+			return fieldError(field, "variant %q is not loaded and %s has no variants", variant, key)
+		}
+		return fieldError(field, "variant %q is not loaded, available variants: %s", variant, strings.Join(available, ", "))
+	}
+	return nil
+}
+
+// availableVariants returns the variants of the prompt given inline, keyed
+// <prompt>.<variant>, made up by overrides of partials it includes, and found in the
+// prompt directory, named <prompt>.<variant>.prompt
+func (p *AgenticRAGProcessor) availableVariants(name string) []string {
+	if name == "" {
+		return nil
+	}
+	var variants []string
+	for _, inline := range sortedKeys(p.config.Prompts.Inline) {
+		if variant, ok := strings.CutPrefix(inline, name+"."); ok && variant != "" {
+			variants = append(variants, variant)
+		}
+	}
+	if key, ok := p.config.Prompts.promptKeyOf(name); ok {
+		if partials, err := readPartials(p.config.Prompts); err == nil {
+			for _, variant := range partialVariants(partials) {
+				if _, ok := partialVariantSource(p.config.Prompts, partials, key, name, variant); ok && !slices.Contains(variants, variant) {
+					variants = append(variants, variant)
+				}
+			}
+		}
+	}
+	if p.config.Prompts.Directory == "" {
+		return variants
+	}
+	entries, err := os.ReadDir(p.config.Prompts.Directory)
+	if err != nil {
+		return variants
+	}
+
+	for _, entry := range entries {
+		variant, ok := strings.CutPrefix(entry.Name(), name+".")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if variant, ok = strings.CutSuffix(variant, ".prompt"); ok && variant != "" && !strings.Contains(variant, ".") {
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
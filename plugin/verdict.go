@@ -0,0 +1,155 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Verdict is the outcome of checking one claim against the chunks
+type Verdict string
+
+const (
+	VerdictSupported          Verdict = "supported"           // The sources state or directly imply the claim
+	VerdictRefuted            Verdict = "refuted"             // The sources contradict the claim
+	VerdictPartiallySupported Verdict = "partially_supported" // The sources back part of the claim, or back it weakly
+	VerdictNotEnoughEvidence  Verdict = "not_enough_evidence" // The sources don't settle the claim
+)
+
+// Overall outcomes of a FactVerification, derived from its claims' verdicts
+const (
+	VerificationVerified = "verified" // Every claim is supported
+	VerificationRefuted  = "refuted"  // At least one claim is refuted
+	VerificationMixed    = "mixed"    // Anything else
+)
+
+// verificationSchema is the JSON schema of the model's per-claim verdicts
+var verificationSchema = map[string]any{
+	"type":     "object",
+	"required": []any{"claims"},
+	"properties": map[string]any{
+		"claims": map[string]any{
+			"type": "array",
+			"items": map[string]any{
+				"type":     "object",
+				"required": []any{"claim_index", "verdict", "confidence"},
+				"properties": map[string]any{
+					"claim_index": map[string]any{"type": "integer"},
+					"verdict":     map[string]any{"type": "string", "enum": []any{"supported", "refuted", "partially_supported", "not_enough_evidence"}},
+					"confidence":  map[string]any{"type": "number"},
+					"evidence":    map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+					"evidence_spans": map[string]any{
+						"type": "array",
+						"items": map[string]any{
+							"type":     "object",
+							"required": []any{"source", "quote"},
+							"properties": map[string]any{
+								"source": map[string]any{"type": "integer"},
+								"quote":  map[string]any{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+}
+
+// verificationOutput is the model's verdicts as decoded from verificationSchema
+type verificationOutput struct {
+	Claims []struct {
+		ClaimIndex    int      `json:"claim_index"`
+		Verdict       Verdict  `json:"verdict"`
+		Confidence    float64  `json:"confidence"`
+		Evidence      []string `json:"evidence"`
+		EvidenceSpans []struct {
+			Source int    `json:"source"`
+			Quote  string `json:"quote"`
+		} `json:"evidence_spans"`
+	} `json:"claims"`
+}
+
+// calibrateVerdict settles a claim's verdict from the model's verdict and confidence:
+// a supported claim below MinConfidenceScore is only partially supported, and a
+// partially supported one below PartialSupportMinConfidence lacks evidence. Unknown
+// verdicts lack evidence too.
+func calibrateVerdict(config FactVerificationConfig, verdict Verdict, confidence float64) Verdict {
+	switch verdict {
+	case VerdictSupported:
+		if confidence < config.MinConfidenceScore {
+			verdict = VerdictPartiallySupported
+		}
+	case VerdictRefuted, VerdictPartiallySupported, VerdictNotEnoughEvidence:
+	default:
+		return VerdictNotEnoughEvidence
+	}
+	if verdict == VerdictPartiallySupported && confidence < config.PartialSupportMinConfidence {
+		return VerdictNotEnoughEvidence
+	}
+	return verdict
+}
+
+// overallVerdict derives the outcome of a verification from its claims
+func overallVerdict(claims []Claim) string {
+	supported := 0
+	for _, claim := range claims {
+		switch claim.Verdict {
+		case VerdictRefuted:
+			return VerificationRefuted
+		case VerdictSupported:
+			supported++
+		}
+	}
+	if supported == len(claims) {
+		return VerificationVerified
+	}
+	return VerificationMixed
+}
+
+// decodeVerification decodes the model's verdicts into the claims, matched by index.
+// Claims without a verdict lack evidence. Evidence quotes are located in the chunks
+// they cite, and with RequireEvidence a supported or partially supported claim without
+// located evidence lacks evidence. With CheckNumbers, the claims' numbers are then
+// checked against their evidence, see checkClaimNumbers.
+func (p *AgenticRAGProcessor) decodeVerification(ctx context.Context, value any, claims []Claim, chunks []DocumentChunk, documents []Document) (*FactVerification, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse verdicts: %w", err)
+	}
+	var output verificationOutput
+	if err := json.Unmarshal(data, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse verdicts: %w", err)
+	}
+
+	config := p.factVerification(ctx)
+	verified := make([]Claim, len(claims))
+	for i, claim := range claims {
+		claim.Verdict = VerdictNotEnoughEvidence
+		verified[i] = claim
+	}
+	for _, item := range output.Claims {
+		if item.ClaimIndex < 0 || item.ClaimIndex >= len(verified) {
+			continue
+		}
+		claim := &verified[item.ClaimIndex]
+		claim.Confidence = max(0, min(1, item.Confidence))
+		claim.Verdict = calibrateVerdict(config, item.Verdict, claim.Confidence)
+		claim.Evidence = item.Evidence
+		for _, quoted := range item.EvidenceSpans {
+			if quoted.Source < 0 || quoted.Source >= len(chunks) {
+				continue
+			}
+			if span, ok := evidenceSpan(quoted.Quote, chunks[quoted.Source], documents); ok {
+				claim.EvidenceSpans = append(claim.EvidenceSpans, span)
+			}
+		}
+		if config.RequireEvidence && len(claim.EvidenceSpans) == 0 &&
+			(claim.Verdict == VerdictSupported || claim.Verdict == VerdictPartiallySupported) {
+			claim.Verdict = VerdictNotEnoughEvidence
+		}
+		if config.CheckNumbers {
+			checkClaimNumbers(claim, config.NumericTolerance)
+		}
+	}
+	return &FactVerification{Claims: verified, Overall: overallVerdict(verified)}, nil
+}
@@ -0,0 +1,50 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/firebase/genkit/go/genkit"
+)
+
+// Roles of ProcessingMetadata.ModelUsage
+const (
+	ModelRoleGeneration   = "generation"   // Every stage but fact verification
+	ModelRoleVerification = "verification" // Fact verification
+)
+
+// verificationModelName returns the provider-qualified model fact verification runs
+// on, empty to run it on the main model
+func (p *AgenticRAGProcessor) verificationModelName() string {
+	config := p.config.FactVerification
+	if config.VerificationModel != "" && !strings.Contains(config.VerificationModel, "/") && config.VerificationProvider != "" {
+		return config.VerificationProvider + "/" + config.VerificationModel
+	}
+	return config.VerificationModel
+}
+
+// verificationCandidate returns the verification model for calls of the fact
+// verification stage. A verification model that isn't registered, or failed earlier in
+// the request, leaves the calls to the main model.
+func (p *AgenticRAGProcessor) verificationCandidate(ctx context.Context) (modelCandidate, bool) {
+	name := p.verificationModelName()
+	state := requestStateFrom(ctx)
+	if name == "" || stageFrom(ctx) != StageFactVerification || state.verificationFellBack() {
+		return modelCandidate{}, false
+	}
+	provider, model, _ := strings.Cut(name, "/")
+	if genkit.LookupModel(p.config.Genkit, provider, model) == nil {
+		p.fallBackFromVerificationModel(ctx, fmt.Errorf("model %q is not registered", name))
+		return modelCandidate{}, false
+	}
+	return namedCandidate(name), true
+}
+
+// fallBackFromVerificationModel moves the rest of the request's fact verification to
+// the main model, warning once
+func (p *AgenticRAGProcessor) fallBackFromVerificationModel(ctx context.Context, err error) {
+	if state := requestStateFrom(ctx); state.fallBackVerification() {
+		state.recordWarning(fmt.Sprintf("verification model unavailable, verifying with the main model: %v", err))
+	}
+}
@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// VerificationCache stores fact verification verdicts across requests, keyed by the
+// claim and the chunks it was checked against. Implementations must be safe for
+// concurrent use and may keep the stored claims as is; the processor never modifies
+// them.
+type VerificationCache interface {
+	// Get returns the claim stored under key, or nil if there is none or it expired
+	Get(ctx context.Context, key string) (*Claim, error)
+	// Put stores the claim under key, replacing any earlier one
+	Put(ctx context.Context, key string, claim *Claim) error
+}
+
+// MemoryVerificationCache is an in-process VerificationCache that evicts the least
+// recently used claim once full and expires claims a fixed time after they were stored
+type MemoryVerificationCache struct {
+	entries *lruCache[*Claim]
+}
+
+// NewMemoryVerificationCache creates an in-memory cache holding up to maxEntries
+// claims (0 for no limit). A ttl of 0 keeps claims until they're evicted.
+func NewMemoryVerificationCache(maxEntries int, ttl time.Duration) *MemoryVerificationCache {
+	return &MemoryVerificationCache{entries: newLRUCache[*Claim](maxEntries, ttl)}
+}
+
+// Get implements VerificationCache
+func (c *MemoryVerificationCache) Get(ctx context.Context, key string) (*Claim, error) {
+	return c.entries.get(key), nil
+}
+
+// Put implements VerificationCache, evicting the least recently used claims when full
+func (c *MemoryVerificationCache) Put(ctx context.Context, key string, claim *Claim) error {
+	c.entries.put(key, claim)
+	return nil
+}
+
+// verificationCacheKey returns a stable hash of the normalized claim, the contents of
+// the chunks it's checked against in any order, and the settings affecting its verdict
+func (p *AgenticRAGProcessor) verificationCacheKey(ctx context.Context, claim string, chunkHashes []string) (string, error) {
+	config := p.factVerification(ctx)
+	data, err := json.Marshal(struct {
+		Claim                       string   `json:"claim"`
+		Chunks                      []string `json:"chunks"`
+		Model                       string   `json:"model,omitempty"`
+		RequireEvidence             bool     `json:"require_evidence"`
+		MinConfidenceScore          float64  `json:"min_confidence_score"`
+		PartialSupportMinConfidence float64  `json:"partial_support_min_confidence"`
+		CheckNumbers                bool     `json:"check_numbers"`
+		NumericTolerance            float64  `json:"numeric_tolerance"`
+	}{
+		Claim:                       strings.ToLower(collapseWhitespace(claim)),
+		Chunks:                      chunkHashes,
+		Model:                       p.verificationModelName(),
+		RequireEvidence:             config.RequireEvidence,
+		MinConfidenceScore:          config.MinConfidenceScore,
+		PartialSupportMinConfidence: config.PartialSupportMinConfidence,
+		CheckNumbers:                config.CheckNumbers,
+		NumericTolerance:            config.NumericTolerance,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode claim: %w", err)
+	}
+	return hashBytes(data), nil
+}
+
+// verifyCached serves the claims verified before against the same chunks from the
+// verification cache, unless force is set, and verifies the others with verify,
+// caching their verdicts. Claims stay in order, cached verdicts marked as such.
+// Verdicts of a verification whose output didn't parse aren't cached.
+func (p *AgenticRAGProcessor) verifyCached(ctx context.Context, claims []Claim, chunks []DocumentChunk, force bool, verify func([]Claim) (*FactVerification, error)) (*FactVerification, error) {
+	if p.verifyCache == nil {
+		return verify(claims)
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = contentHash(chunk.Content)
+	}
+	sort.Strings(hashes)
+	keys := make([]string, len(claims))
+	cached := make([]*Claim, len(claims))
+	var pending []Claim
+	for i, claim := range claims {
+		key, err := p.verificationCacheKey(ctx, claim.Text, hashes)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		if !force {
+			hit, err := p.verifyCache.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read verification cache: %w", err)
+			}
+			if hit != nil {
+				restored, err := restoreVerdict(claim, hit)
+				if err != nil {
+					return nil, err
+				}
+				cached[i] = &restored
+				continue
+			}
+		}
+		pending = append(pending, claim)
+	}
+
+	verification := &FactVerification{}
+	if len(pending) > 0 {
+		verified, err := verify(pending)
+		if err != nil {
+			return nil, err
+		}
+		verification = verified
+	}
+	_, failed := verification.Metadata["verification_error"]
+	merged := make([]Claim, len(claims))
+	next := 0
+	for i := range claims {
+		if cached[i] != nil {
+			merged[i] = *cached[i]
+			continue
+		}
+		merged[i] = verification.Claims[next]
+		next++
+		if failed {
+			continue
+		}
+		stored, err := cloneClaim(merged[i])
+		if err != nil {
+			return nil, err
+		}
+		if err := p.verifyCache.Put(ctx, keys[i], stored); err != nil {
+			return nil, fmt.Errorf("failed to write verification cache: %w", err)
+		}
+	}
+	verification.Claims = merged
+	verification.Overall = overallVerdict(merged)
+	return verification, nil
+}
+
+// restoreVerdict returns the claim with the verdict and evidence of a cached claim,
+// keeping where the claim sits in this answer
+func restoreVerdict(claim Claim, cached *Claim) (Claim, error) {
+	verdict, err := cloneClaim(*cached)
+	if err != nil {
+		return Claim{}, err
+	}
+	claim.Verdict = verdict.Verdict
+	claim.Confidence = verdict.Confidence
+	claim.Evidence = verdict.Evidence
+	claim.Sources = verdict.Sources
+	claim.EvidenceSpans = verdict.EvidenceSpans
+	claim.NumericConflict = verdict.NumericConflict
+	claim.Votes = verdict.Votes
+	claim.Cached = true
+	return claim, nil
+}
+
+// cloneClaim returns a deep copy of the claim
+func cloneClaim(claim Claim) (*Claim, error) {
+	data, err := json.Marshal(claim)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode claim: %w", err)
+	}
+	var cloned Claim
+	if err := json.Unmarshal(data, &cloned); err != nil {
+		return nil, fmt.Errorf("failed to decode claim: %w", err)
+	}
+	return &cloned, nil
+}
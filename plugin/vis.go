@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Default scaling of VisExportOptions
+const (
+	defaultMinNodeSize   = 10
+	defaultMaxNodeSize   = 40
+	defaultMinEdgeWeight = 1
+	defaultMaxEdgeWeight = 5
+)
+
+// VisExportOptions selects what VisGraph and ExportVisJSON produce. Confidence maps
+// linearly onto node sizes and edge weights between the minimum and maximum.
+type VisExportOptions struct {
+	MinConfidence float64           // Leave out entities and relations below this confidence
+	EntityTypes   []string          // Only export entities of these types or their subtypes, case-insensitively (default: all)
+	TypeParents   map[string]string // Entity type hierarchy for EntityTypes, see KnowledgeGraphConfig.TypeParents
+	MinNodeSize   float64           // Size of a node with confidence 0 (default: 10)
+	MaxNodeSize   float64           // Size of a node with confidence 1 (default: 40)
+	MinEdgeWeight float64           // Weight of an edge with confidence 0 (default: 1)
+	MaxEdgeWeight float64           // Weight of an edge with confidence 1 (default: 5)
+	Groups        bool              // Assign nodes to communities of densely related entities
+}
+
+// VisGraph is a knowledge graph in the nodes and edges shape of d3 and cytoscape
+type VisGraph struct {
+	Nodes []VisNode `json:"nodes"`
+	Edges []VisEdge `json:"edges"`
+}
+
+// VisNode is an entity of a VisGraph
+type VisNode struct {
+	ID         string                    `json:"id"` // Derived from the entity's type and name, stable across requests
+	Label      string                    `json:"label"`
+	Type       string                    `json:"type,omitempty"`
+	Confidence float64                   `json:"confidence"`
+	Size       float64                   `json:"size"`
+	Group      *int                      `json:"group,omitempty"`      // Community, when groups are requested
+	Attributes map[string]AttributeValue `json:"attributes,omitempty"` // Facts the text states about the entity
+}
+
+// VisEdge is a relation of a VisGraph
+type VisEdge struct {
+	ID     string  `json:"id"`
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Label  string  `json:"label"`
+	Weight float64 `json:"weight"`
+	When   string  `json:"when,omitempty"` // When the relation held, such as "1998" or "from 2001"
+}
+
+// VisGraph returns the graph as nodes and edges for web frontends, sorted so the
+// output is stable
+func (kg *KnowledgeGraph) VisGraph(opts VisExportOptions) VisGraph {
+	nodes, edges := kg.exportGraph(GraphExportOptions{MinConfidence: opts.MinConfidence, EntityTypes: opts.EntityTypes, TypeParents: opts.TypeParents})
+	minSize, maxSize := scaleRange(opts.MinNodeSize, opts.MaxNodeSize, defaultMinNodeSize, defaultMaxNodeSize)
+	minWeight, maxWeight := scaleRange(opts.MinEdgeWeight, opts.MaxEdgeWeight, defaultMinEdgeWeight, defaultMaxEdgeWeight)
+
+	graph := VisGraph{Nodes: make([]VisNode, 0, len(nodes)), Edges: make([]VisEdge, 0, len(edges))}
+	ids := make(map[string]string, len(nodes))
+	for _, node := range nodes {
+		id := stableEntityID(node.entity)
+		ids[node.id] = id
+		confidence := max(0, min(1, node.entity.Confidence))
+		graph.Nodes = append(graph.Nodes, VisNode{
+			ID:         id,
+			Label:      node.entity.Name,
+			Type:       node.entity.Type,
+			Confidence: node.entity.Confidence,
+			Size:       minSize + confidence*(maxSize-minSize),
+			Attributes: node.entity.Attributes,
+		})
+	}
+	for _, edge := range edges {
+		confidence := max(0, min(1, edge.relation.Confidence))
+		graph.Edges = append(graph.Edges, VisEdge{
+			ID:     edgeID(edge, ids),
+			Source: ids[edge.source],
+			Target: ids[edge.target],
+			Label:  edge.relation.Predicate,
+			Weight: minWeight + confidence*(maxWeight-minWeight),
+			When:   temporalLabel(edge.relation.Temporal),
+		})
+	}
+	if opts.Groups {
+		assignGroups(&graph)
+	}
+	return graph
+}
+
+// ExportVisJSON writes the graph as VisGraph JSON
+func (kg *KnowledgeGraph) ExportVisJSON(w io.Writer, opts VisExportOptions) error {
+	if err := json.NewEncoder(w).Encode(kg.VisGraph(opts)); err != nil {
+		return fmt.Errorf("failed to write graph JSON: %w", err)
+	}
+	return nil
+}
+
+// scaleRange returns the minimum and maximum of a scale, defaulting those unset
+func scaleRange(low, high, defaultLow, defaultHigh float64) (float64, float64) {
+	if low == 0 && high == 0 {
+		return defaultLow, defaultHigh
+	}
+	return low, high
+}
+
+// assignGroups numbers the nodes' communities, see labelPropagation
+func assignGroups(graph *VisGraph) {
+	index := make(map[string]int, len(graph.Nodes))
+	for i, node := range graph.Nodes {
+		index[node.ID] = i
+	}
+	edges := make([]weightedEdge, len(graph.Edges))
+	for i, edge := range graph.Edges {
+		edges[i] = weightedEdge{a: index[edge.Source], b: index[edge.Target], weight: edge.Weight}
+	}
+	for i, group := range labelPropagation(len(graph.Nodes), edges) {
+		graph.Nodes[i].Group = &group
+	}
+}